@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+// AdminHandler handles operator-only endpoints
+type AdminHandler struct {
+	operations     *OperationRegistry
+	storageManager *storage.Manager
+	token          string
+}
+
+// NewAdminHandler creates a new admin handler. Requests must carry the
+// given token as a bearer token; an empty token disables the admin API.
+func NewAdminHandler(operations *OperationRegistry, token string) *AdminHandler {
+	return &AdminHandler{
+		operations: operations,
+		token:      token,
+	}
+}
+
+// SetStorageManager sets the storages whose usage statistics can be reset
+func (ah *AdminHandler) SetStorageManager(manager *storage.Manager) {
+	ah.storageManager = manager
+}
+
+// RequireAdmin wraps a handler so it is only reachable with the admin token
+func (ah *AdminHandler) RequireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ah.token == "" {
+			errorResponse(w, "Admin API is disabled", http.StatusForbidden)
+			return
+		}
+
+		auth := r.Header.Get("Authorization")
+		provided := strings.TrimPrefix(auth, "Bearer ")
+		if provided == auth || subtle.ConstantTimeCompare([]byte(provided), []byte(ah.token)) != 1 {
+			errorResponse(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// ListOperations returns every active operation
+func (ah *AdminHandler) ListOperations(w http.ResponseWriter, r *http.Request) {
+	ops := ah.operations.List()
+	successResponse(w, map[string]interface{}{
+		"operations": ops,
+		"count":      len(ops),
+	})
+}
+
+// CancelOperation cancels an active operation
+func (ah *AdminHandler) CancelOperation(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	if !ah.operations.Cancel(id) {
+		errorResponse(w, "Operation not found", http.StatusNotFound)
+		return
+	}
+
+	successResponse(w, map[string]interface{}{
+		"message":      "Operation cancelled",
+		"operation_id": id,
+	})
+}
+
+// ResetStorageStats sets a storage's usage statistics back to zero
+func (ah *AdminHandler) ResetStorageStats(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	if ah.storageManager == nil {
+		errorResponse(w, "Storage not found", http.StatusNotFound)
+		return
+	}
+	stats, ok := ah.storageManager.Stats(id)
+	if !ok {
+		errorResponse(w, "Storage not found", http.StatusNotFound)
+		return
+	}
+	stats.ResetStats()
+
+	successResponse(w, map[string]interface{}{
+		"message": "Storage statistics reset",
+		"storage": id,
+	})
+}
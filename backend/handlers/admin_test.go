@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+// endlessFileSystem serves an infinite stream for every Read so operations
+// run until they are cancelled
+type endlessFileSystem struct {
+	*mockFileSystem
+}
+
+type endlessReader struct{}
+
+func (endlessReader) Read(p []byte) (int, error) {
+	time.Sleep(time.Millisecond)
+	for i := range p {
+		p[i] = 'x'
+	}
+	return len(p), nil
+}
+
+func (e *endlessFileSystem) Read(path string) (io.ReadCloser, error) {
+	return io.NopCloser(endlessReader{}), nil
+}
+
+func TestAdminHandler_ListAndCancelOperations(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("TMPDIR", tmpDir)
+
+	fs := &endlessFileSystem{newMockFileSystem()}
+	fs.files["/big.bin"] = []byte("placeholder")
+
+	mgr := storage.NewManager()
+	mgr.Register("mock", fs)
+
+	operations := NewOperationRegistry()
+	compression := NewCompressionHandler(mgr)
+	compression.SetOperationRegistry(operations)
+	admin := NewAdminHandler(operations, "secret")
+
+	router := mux.NewRouter()
+	router.HandleFunc("/api/fs/compress", compression.Compress).Methods("POST")
+	router.HandleFunc("/api/admin/operations", admin.RequireAdmin(admin.ListOperations)).Methods("GET")
+	router.HandleFunc("/api/admin/operations/{id}", admin.RequireAdmin(admin.CancelOperation)).Methods("DELETE")
+
+	// Start a compression that never finishes on its own
+	body := `{"storage": "mock", "files": ["big.bin"], "base_path": "/", "output_path": "/out.zip", "format": "zip"}`
+	req := httptest.NewRequest("POST", "/api/fs/compress", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Failed to start compression: %d %s", rr.Code, rr.Body.String())
+	}
+
+	t.Run("Requires admin token", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/admin/operations", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("Expected 401 without token, got %d", rr.Code)
+		}
+	})
+
+	var listed struct {
+		Data struct {
+			Operations []OperationInfo `json:"operations"`
+		} `json:"data"`
+	}
+	req = httptest.NewRequest("GET", "/api/admin/operations", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if err := json.NewDecoder(rr.Body).Decode(&listed); err != nil {
+		t.Fatalf("Failed to decode listing: %v", err)
+	}
+	if len(listed.Data.Operations) != 1 {
+		t.Fatalf("Expected 1 active operation, got %d", len(listed.Data.Operations))
+	}
+	op := listed.Data.Operations[0]
+	if op.Type != "compress" || op.Storage != "mock" || len(op.Paths) != 1 {
+		t.Errorf("Unexpected operation info: %+v", op)
+	}
+
+	req = httptest.NewRequest("DELETE", "/api/admin/operations/"+op.ID, nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Failed to cancel operation: %d %s", rr.Code, rr.Body.String())
+	}
+
+	// The operation should unregister itself and remove its temp file
+	deadline := time.Now().Add(5 * time.Second)
+	for len(operations.List()) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Operation did not stop after cancellation")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	entries, err := os.ReadDir(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to read temp dir: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Expected temp files to be cleaned up, found %d", len(entries))
+	}
+	if _, ok := fs.files["/out.zip"]; ok {
+		t.Error("Cancelled compression should not write its output")
+	}
+
+	t.Run("Unknown operation", func(t *testing.T) {
+		req := httptest.NewRequest("DELETE", "/api/admin/operations/nope", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("Expected 404, got %d", rr.Code)
+		}
+	})
+}
+
+func TestAdminHandler_ResetStorageStats(t *testing.T) {
+	fs := newMockFileSystem()
+	mgr := storage.NewManager()
+	mgr.Register("mock", fs)
+	registered, _ := mgr.Get("mock")
+	if err := registered.Write("/a.txt", strings.NewReader("data")); err != nil {
+		t.Fatal(err)
+	}
+
+	admin := NewAdminHandler(NewOperationRegistry(), "secret")
+	admin.SetStorageManager(mgr)
+	router := mux.NewRouter()
+	router.HandleFunc("/api/admin/storages/{id}/stats", admin.RequireAdmin(admin.ResetStorageStats)).Methods("DELETE")
+
+	reset := func(id string) int {
+		req := httptest.NewRequest("DELETE", "/api/admin/storages/"+id+"/stats", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	if code := reset("mock"); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	stats, _ := mgr.Stats("mock")
+	if got := stats.Stats(); got.BytesWritten != 0 || got.Operations["write"] != 0 {
+		t.Errorf("Expected counters to be reset, got %+v", got)
+	}
+	if code := reset("missing"); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown storage, got %d", code)
+	}
+}
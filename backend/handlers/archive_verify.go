@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// VerifyArchiveRequest names the archive to check
+type VerifyArchiveRequest struct {
+	Storage string `json:"storage"`
+	Path    string `json:"path"`
+}
+
+// ArchiveEntryResult is the verdict on one archive entry
+type ArchiveEntryResult struct {
+	Name  string `json:"name"`
+	Size  int64  `json:"size"`
+	Valid bool   `json:"valid"`
+	Error string `json:"error,omitempty"`
+}
+
+// ArchiveVerification is the outcome of checking a whole archive. Error
+// is set when the archive's structure is broken beyond a single entry.
+type ArchiveVerification struct {
+	Path    string               `json:"path"`
+	Format  string               `json:"format"`
+	Valid   bool                 `json:"valid"`
+	Entries []ArchiveEntryResult `json:"entries"`
+	Error   string               `json:"error,omitempty"`
+}
+
+// fail marks the archive invalid because of err
+func (v *ArchiveVerification) fail(err error) {
+	v.Valid = false
+	v.Error = err.Error()
+}
+
+// addEntry records an entry, which invalidates the archive if it failed
+func (v *ArchiveVerification) addEntry(name string, size int64, err error) {
+	entry := ArchiveEntryResult{Name: name, Size: size, Valid: err == nil}
+	if err != nil {
+		entry.Error = err.Error()
+		v.Valid = false
+	}
+	v.Entries = append(v.Entries, entry)
+}
+
+// VerifyArchive reads an archive through to the end to check it isn't
+// corrupt, without extracting anything. Zip entries are checked against
+// their stored CRC-32; tar archives must decompress completely and every
+// entry must hold as many bytes as its header says. A corrupt archive is
+// still a 200 response, with valid set to false.
+func (ch *CompressionHandler) VerifyArchive(w http.ResponseWriter, r *http.Request) {
+	var req VerifyArchiveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	format := "zip"
+	codec, suffix, isTar := tarCodecForArchive(req.Path)
+	if isTar {
+		format = strings.TrimPrefix(strings.ToLower(suffix), ".")
+	} else if strings.ToLower(filepath.Ext(req.Path)) != ".zip" {
+		errorResponse(w, fmt.Sprintf("Unsupported archive format: %s", filepath.Base(req.Path)), http.StatusBadRequest)
+		return
+	}
+
+	fs, ok := ch.storageManager.Get(req.Storage)
+	if !ok {
+		errorResponse(w, "Storage not found", http.StatusNotFound)
+		return
+	}
+	info, err := fs.Stat(req.Path)
+	if err != nil {
+		storageErrorResponse(w, "Archive not found", err)
+		return
+	}
+	if info.IsDir {
+		errorResponse(w, "Path is a directory", http.StatusBadRequest)
+		return
+	}
+
+	reader, err := fs.Read(req.Path)
+	if err != nil {
+		storageErrorResponse(w, "Failed to read archive", err)
+		return
+	}
+	defer func() {
+		if err := reader.Close(); err != nil {
+			log.Printf("Error closing archive reader: %v", err)
+		}
+	}()
+
+	ctx := r.Context()
+	result := &ArchiveVerification{Path: req.Path, Format: format, Valid: true, Entries: []ArchiveEntryResult{}}
+	if format == "zip" {
+		err = ch.verifyZip(ctx, reader, result)
+	} else {
+		err = ch.verifyTar(ctx, reader, codec, result)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Error verifying archive %s: %v", req.Path, err)
+		storageErrorResponse(w, "Failed to read archive", err)
+		return
+	}
+
+	successResponse(w, result)
+}
+
+// verifyZip checks each zip entry's content against its CRC-32. Only
+// failures to read the archive from storage are returned as errors;
+// corruption is recorded in result.
+func (ch *CompressionHandler) verifyZip(ctx context.Context, reader io.Reader, result *ArchiveVerification) error {
+	tmpFile, cleanup, err := ch.spoolArchive(ctx, reader)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	stat, err := tmpFile.Stat()
+	if err != nil {
+		return err
+	}
+	zipReader, err := zip.NewReader(tmpFile, stat.Size())
+	if err != nil {
+		result.fail(err)
+		return nil
+	}
+
+	for _, file := range zipReader.File {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if file.FileInfo().IsDir() {
+			result.addEntry(file.Name, 0, nil)
+			continue
+		}
+
+		rc, err := file.Open()
+		if err == nil {
+			// archive/zip compares the CRC-32 once the entry is read to the end
+			_, err = io.Copy(io.Discard, &contextReader{ctx: ctx, r: rc})
+			if closeErr := rc.Close(); closeErr != nil {
+				log.Printf("Error closing zip entry: %v", closeErr)
+			}
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		result.addEntry(file.Name, int64(file.UncompressedSize64), err)
+	}
+	return nil
+}
+
+// verifyTar streams a tar archive through its decompressor, checking that
+// each entry holds its declared size and that the compressed stream is
+// intact to its end
+func (ch *CompressionHandler) verifyTar(ctx context.Context, reader io.Reader, codec *tarCodec, result *ArchiveVerification) error {
+	reader = &contextReader{ctx: ctx, r: reader}
+	if codec != nil {
+		decompressed, err := codec.newReader(reader)
+		if err != nil {
+			result.fail(fmt.Errorf("invalid %s stream: %w", codec.name, err))
+			return nil
+		}
+		defer func() {
+			if err := decompressed.Close(); err != nil {
+				log.Printf("Error closing %s reader: %v", codec.name, err)
+			}
+		}()
+		reader = decompressed
+	}
+	tarReader := tar.NewReader(reader)
+
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			result.fail(err)
+			return nil
+		}
+
+		n, err := io.Copy(io.Discard, tarReader)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			result.addEntry(header.Name, header.Size, fmt.Errorf("read %d of %d bytes: %w", n, header.Size, err))
+			// The stream can't be followed past a truncated entry
+			result.fail(errors.New("archive is truncated or corrupt"))
+			return nil
+		}
+		result.addEntry(header.Name, header.Size, nil)
+	}
+
+	// Compressed formats keep their own checksum after the tar end marker
+	if codec == nil {
+		return nil
+	}
+	if _, err := io.Copy(io.Discard, reader); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		result.fail(fmt.Errorf("invalid %s stream: %w", codec.name, err))
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+func TestCompressionHandler_VerifyArchive(t *testing.T) {
+	root := t.TempDir()
+	write := func(name string, content []byte) {
+		full := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("tree/a.txt", []byte(strings.Repeat("verify me\n", 500)))
+	write("tree/sub/b.bin", bytes.Repeat([]byte{1, 2, 3}, 10000))
+
+	fs := storage.NewLocalStorage(root)
+	mgr := storage.NewManager()
+	mgr.Register("local", fs)
+	ch := NewCompressionHandler(mgr)
+
+	for _, format := range []string{"zip", "tar", "tar.gz", "tar.xz", "tar.zst"} {
+		req := CompressRequest{Files: []string{"tree"}, BasePath: "/", OutputPath: "/good." + format, Format: format}
+		ch.performCompression(ch.operations.Start("compress", "test", "local", req.Files), fs, req, nil)
+	}
+
+	// A stored zip entry whose content no longer matches its CRC-32
+	var zipBuf bytes.Buffer
+	zw := zip.NewWriter(&zipBuf)
+	for _, name := range []string{"ok.txt", "bad.txt"} {
+		entry, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry.Write([]byte("original content of " + name))
+	}
+	zw.Close()
+	corruptZip := bytes.Replace(zipBuf.Bytes(), []byte("original content of bad"), []byte("tampered content of bad"), 1)
+	write("corrupt.zip", corruptZip)
+
+	good, err := os.ReadFile(filepath.Join(root, "good.tar.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	write("truncated.tar.gz", good[:len(good)/2])
+	// Entries intact, but the gzip trailer's CRC-32 is wrong
+	badTrailer := append([]byte(nil), good...)
+	badTrailer[len(badTrailer)-8] ^= 0xff
+	write("trailer.tar.gz", badTrailer)
+	write("notes.txt", []byte("not an archive"))
+
+	verify := func(path string) (int, ArchiveVerification) {
+		rr := httptest.NewRecorder()
+		body := `{"storage":"local","path":"` + path + `"}`
+		ch.VerifyArchive(rr, httptest.NewRequest("POST", "/api/fs/archive/verify", strings.NewReader(body)))
+		var resp struct {
+			Data ArchiveVerification `json:"data"`
+		}
+		if rr.Code == http.StatusOK {
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("Invalid response: %v", err)
+			}
+		}
+		return rr.Code, resp.Data
+	}
+
+	for _, format := range []string{"zip", "tar", "tar.gz", "tar.xz", "tar.zst"} {
+		code, result := verify("/good." + format)
+		if code != http.StatusOK || !result.Valid || result.Error != "" {
+			t.Errorf("%s: expected a valid archive, got %d %+v", format, code, result)
+			continue
+		}
+		files := 0
+		for _, entry := range result.Entries {
+			if !entry.Valid {
+				t.Errorf("%s: entry %s reported invalid: %s", format, entry.Name, entry.Error)
+			}
+			if strings.HasSuffix(entry.Name, "a.txt") || strings.HasSuffix(entry.Name, "b.bin") {
+				files++
+			}
+		}
+		if files != 2 {
+			t.Errorf("%s: expected both files among the entries, got %+v", format, result.Entries)
+		}
+	}
+
+	code, result := verify("/corrupt.zip")
+	if code != http.StatusOK || result.Valid || len(result.Entries) != 2 {
+		t.Fatalf("Expected an invalid zip with 2 entries, got %d %+v", code, result)
+	}
+	if !result.Entries[0].Valid || result.Entries[1].Valid || result.Entries[1].Error == "" {
+		t.Errorf("Expected only bad.txt to fail its CRC check, got %+v", result.Entries)
+	}
+
+	for _, path := range []string{"/truncated.tar.gz", "/trailer.tar.gz"} {
+		code, result := verify(path)
+		if code != http.StatusOK || result.Valid || result.Error == "" {
+			t.Errorf("%s: expected an invalid archive with an error, got %d %+v", path, code, result)
+		}
+	}
+
+	if code, _ := verify("/notes.txt"); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a file that isn't an archive, got %d", code)
+	}
+	if code, _ := verify("/missing.zip"); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a missing archive, got %d", code)
+	}
+}
@@ -0,0 +1,368 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Audit levels, from least to most recorded
+const (
+	AuditFailures = "failures" // only operations that failed
+	AuditChanges  = "changes"  // every operation that changes something
+	AuditAll      = "all"      // changes and downloads
+)
+
+// auditQueueSize is how many events may wait to be written before new ones
+// are dropped
+const auditQueueSize = 1024
+
+// auditBodyLimit is how much of a JSON request body is read for the
+// storages and paths it names; the handler still gets all of it
+const auditBodyLimit = 64 << 10
+
+// auditErrorLimit is how much of a failed response is kept for its message
+const auditErrorLimit = 4 << 10
+
+// auditReadActions are recorded only at AuditAll
+var auditReadActions = map[string]bool{"download": true}
+
+// AuditEvent is one entry of the audit log
+type AuditEvent struct {
+	Time        time.Time `json:"time"`
+	Action      string    `json:"action"`
+	User        string    `json:"user,omitempty"`
+	ClientIP    string    `json:"client_ip"`
+	Storage     string    `json:"storage,omitempty"`
+	DstStorage  string    `json:"dst_storage,omitempty"`
+	Paths       []string  `json:"paths,omitempty"`
+	Destination string    `json:"destination,omitempty"`
+	Status      int       `json:"status"`
+	Outcome     string    `json:"outcome"` // "success" or "failure"
+	Error       string    `json:"error,omitempty"`
+}
+
+// AuditLogger records mutating API calls as JSON lines, and optionally
+// announces them to WebSocket clients. Events are written by a goroutine
+// of their own: a slow or failing log never holds up the operation, and
+// when the queue is full events are dropped and counted instead.
+type AuditLogger struct {
+	out       io.Writer
+	level     string
+	wsHandler *WebSocketHandler
+
+	// mu guards events against Close: handlers still running when the
+	// server gives up waiting for them may audit after it
+	mu      sync.RWMutex
+	closed  bool
+	events  chan AuditEvent
+	done    chan struct{}
+	dropped atomic.Int64
+}
+
+// ParseAuditLevel checks an audit level, defaulting to AuditChanges
+func ParseAuditLevel(level string) (string, error) {
+	switch level {
+	case "":
+		return AuditChanges, nil
+	case AuditFailures, AuditChanges, AuditAll:
+		return level, nil
+	}
+	return "", fmt.Errorf("invalid audit level %q: use failures, changes or all", level)
+}
+
+// NewAuditLogger starts an audit logger writing to out, which may be nil
+// to only broadcast events. Close stops it.
+func NewAuditLogger(out io.Writer, level string) *AuditLogger {
+	a := &AuditLogger{
+		out:    out,
+		level:  level,
+		events: make(chan AuditEvent, auditQueueSize),
+		done:   make(chan struct{}),
+	}
+	go a.run()
+	return a
+}
+
+// SetWebSocketHandler makes the logger announce each event as a
+// notification
+func (a *AuditLogger) SetWebSocketHandler(ws *WebSocketHandler) {
+	a.wsHandler = ws
+}
+
+// Close writes the events still queued and stops the logger. Events
+// audited after it are dropped.
+func (a *AuditLogger) Close() {
+	a.mu.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.events)
+	}
+	a.mu.Unlock()
+	<-a.done
+}
+
+// Audit queues an event, never waiting for it to be written
+func (a *AuditLogger) Audit(event AuditEvent) {
+	if event.Outcome == "success" && a.level == AuditFailures {
+		return
+	}
+	if auditReadActions[event.Action] && a.level != AuditAll {
+		return
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.closed {
+		a.dropped.Add(1)
+		return
+	}
+	select {
+	case a.events <- event:
+	default:
+		a.dropped.Add(1)
+	}
+}
+
+// run writes queued events until Close
+func (a *AuditLogger) run() {
+	defer close(a.done)
+	var buf *bufio.Writer
+	if a.out != nil {
+		buf = bufio.NewWriter(a.out)
+	}
+	for event := range a.events {
+		if n := a.dropped.Swap(0); n > 0 {
+			log.Printf("Warning: audit log queue full, %d events dropped", n)
+		}
+		if buf != nil {
+			line, _ := json.Marshal(event)
+			buf.Write(append(line, '\n'))
+			// Flush once the queue is drained, so bursts share a write
+			if len(a.events) == 0 {
+				if err := buf.Flush(); err != nil {
+					log.Printf("Error writing audit log: %v", err)
+					buf.Reset(a.out)
+				}
+			}
+		}
+		if a.wsHandler != nil {
+			a.wsHandler.SendNotificationWithData(event.summary(), map[string]string{
+				"audit":   event.Action,
+				"storage": event.Storage,
+				"outcome": event.Outcome,
+			})
+		}
+	}
+	if buf != nil {
+		if err := buf.Flush(); err != nil {
+			log.Printf("Error writing audit log: %v", err)
+		}
+	}
+}
+
+// summary describes an event in a sentence for notifications
+func (e AuditEvent) summary() string {
+	who := e.User
+	if who == "" {
+		who = e.ClientIP
+	}
+	what := e.Action
+	if len(e.Paths) > 0 {
+		what += " " + strings.Join(e.Paths, ", ")
+	}
+	if e.Destination != "" {
+		what += " to " + e.Destination
+	}
+	if e.Outcome != "success" {
+		return fmt.Sprintf("%s failed to %s: %s", who, what, e.Error)
+	}
+	return fmt.Sprintf("%s: %s", who, what)
+}
+
+// Wrap records each call of next as action. The storages and paths are
+// taken from the request's JSON body, query and form, and only from the
+// fields that name them, so nothing else a request carries, like storage
+// credentials, reaches the log; handlers whose requests name them some
+// other way call auditTarget. A nil logger returns next as is.
+func (a *AuditLogger) Wrap(action string, next http.HandlerFunc) http.HandlerFunc {
+	if a == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body []byte
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") || r.Header.Get("Content-Type") == "" {
+			body, _ = io.ReadAll(io.LimitReader(r.Body, auditBodyLimit))
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		}
+
+		// The handler may name the target itself, and may parse the form,
+		// on this copy of the request
+		target := &AuditEvent{}
+		r = r.WithContext(context.WithValue(r.Context(), auditKey{}, target))
+		rec := &auditRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r)
+
+		event := auditEventFor(r, body)
+		if target.Storage != "" {
+			event.Storage, event.Paths = target.Storage, target.Paths
+		}
+		event.Time = time.Now().UTC()
+		event.Action = action
+		event.Status = rec.status
+		event.Outcome = "success"
+		if rec.status >= http.StatusBadRequest {
+			event.Outcome = "failure"
+			event.Error = auditErrorMessage(rec.body.Bytes())
+		}
+		a.Audit(event)
+	}
+}
+
+// auditKey is the context key of the target a handler names with
+// auditTarget
+type auditKey struct{}
+
+// auditTarget names the storage and paths an audited request acts on, for
+// requests that don't carry them, like completing an upload by its ID
+func auditTarget(r *http.Request, storageID string, paths ...string) {
+	if target, ok := r.Context().Value(auditKey{}).(*AuditEvent); ok {
+		target.Storage, target.Paths = storageID, paths
+	}
+}
+
+// auditFields are the request fields, across handlers, that name the
+// storages and paths an operation touches
+type auditFields struct {
+	Storage            string   `json:"storage"`
+	SrcStorage         string   `json:"src_storage"`
+	SourceStorage      string   `json:"source_storage"`
+	DstStorage         string   `json:"dst_storage"`
+	DestinationStorage string   `json:"destination_storage"`
+	Path               string   `json:"path"`
+	SrcPath            string   `json:"src_path"`
+	SourcePath         string   `json:"source_path"`
+	BasePath           string   `json:"base_path"`
+	ArchivePath        string   `json:"archive_path"`
+	Files              []string `json:"files"`
+	DstPath            string   `json:"dst_path"`
+	DestinationPath    string   `json:"destination_path"`
+	OutputPath         string   `json:"output_path"`
+}
+
+// auditEventFor fills in who made a request and what it named. Form
+// values are only there if the handler parsed the form, which is why this
+// runs after it.
+func auditEventFor(r *http.Request, body []byte) AuditEvent {
+	var f auditFields
+	_ = json.Unmarshal(body, &f)
+	value := func(name string) string {
+		if v := r.URL.Query().Get(name); v != "" {
+			return v
+		}
+		if r.Form != nil {
+			if v := r.Form.Get(name); v != "" {
+				return v
+			}
+		}
+		if r.MultipartForm != nil && len(r.MultipartForm.Value[name]) > 0 {
+			return r.MultipartForm.Value[name][0]
+		}
+		return ""
+	}
+
+	event := AuditEvent{
+		User:        requestUser(r),
+		ClientIP:    r.RemoteAddr,
+		Storage:     firstNonEmpty(f.Storage, f.SrcStorage, f.SourceStorage, value("storage")),
+		DstStorage:  firstNonEmpty(f.DstStorage, f.DestinationStorage),
+		Destination: firstNonEmpty(f.DstPath, f.DestinationPath, f.OutputPath),
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		event.ClientIP = host
+	}
+
+	dir := firstNonEmpty(f.Path, f.SrcPath, f.SourcePath, f.BasePath, f.ArchivePath, value("path"))
+	if len(f.Files) > 0 {
+		for _, file := range f.Files {
+			event.Paths = append(event.Paths, path.Join(dir, file))
+		}
+	} else if dir != "" {
+		event.Paths = []string{dir}
+	}
+	return event
+}
+
+// auditErrorMessage takes the message out of an error response, which is
+// JSON from errorResponse or plain text from http.Error
+func auditErrorMessage(body []byte) string {
+	var resp struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(body, &resp) == nil && resp.Error.Message != "" {
+		return resp.Error.Message
+	}
+	return strings.TrimSpace(string(body))
+}
+
+// firstNonEmpty returns the first of values that isn't empty
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// auditRecorder remembers the status a wrapped handler responded with, and
+// the start of the body when it failed
+type auditRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (a *auditRecorder) WriteHeader(status int) {
+	if !a.wroteHeader {
+		a.status, a.wroteHeader = status, true
+	}
+	a.ResponseWriter.WriteHeader(status)
+}
+
+func (a *auditRecorder) Write(p []byte) (int, error) {
+	a.wroteHeader = true
+	if a.status >= http.StatusBadRequest && a.body.Len() < auditErrorLimit {
+		a.body.Write(p[:min(len(p), auditErrorLimit-a.body.Len())])
+	}
+	return a.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, for
+// flushing streamed downloads
+func (a *auditRecorder) Unwrap() http.ResponseWriter {
+	return a.ResponseWriter
+}
+
+// Flush passes flushes on to the underlying writer
+func (a *auditRecorder) Flush() {
+	if f, ok := a.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
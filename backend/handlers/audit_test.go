@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// auditLines runs requests through an audit logger at level and returns
+// the events it wrote
+func auditLines(t *testing.T, level string, run func(a *AuditLogger)) []AuditEvent {
+	var out bytes.Buffer
+	a := NewAuditLogger(&out, level)
+	run(a)
+	a.Close()
+
+	var events []AuditEvent
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var event AuditEvent
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("Audit log line is not JSON: %q: %v", line, err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestAuditLogger_Wrap(t *testing.T) {
+	var gotBody string
+	copyFiles := func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		gotBody = string(data)
+		successResponse(w, nil)
+	}
+	deleteFiles := func(w http.ResponseWriter, r *http.Request) {
+		errorResponse(w, "permission denied", http.StatusForbidden)
+	}
+	download := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("content"))
+	}
+
+	request := func(method, target, body string) *http.Request {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.RemoteAddr = "192.0.2.7:51234"
+		return r.WithContext(context.WithValue(r.Context(), userKey{}, "alice"))
+	}
+	body := `{"src_storage":"local","dst_storage":"s3","src_path":"/docs","dst_path":"/backup","files":["a.txt","b.txt"]}`
+
+	t.Run("Changes", func(t *testing.T) {
+		events := auditLines(t, AuditChanges, func(a *AuditLogger) {
+			a.Wrap("copy", copyFiles)(httptest.NewRecorder(), request("POST", "/api/fs/copy", body))
+			a.Wrap("delete", deleteFiles)(httptest.NewRecorder(), request("DELETE", "/api/fs/delete", `{"storage":"local","path":"/docs","files":["c.txt"]}`))
+			a.Wrap("download", download)(httptest.NewRecorder(), request("GET", "/api/fs/download?storage=local&path=/docs/a.txt", ""))
+		})
+		if gotBody != body {
+			t.Errorf("Expected the handler to read the whole body, got %q", gotBody)
+		}
+		if len(events) != 2 {
+			t.Fatalf("Expected 2 events without the download, got %d: %+v", len(events), events)
+		}
+
+		copied := events[0]
+		if copied.Action != "copy" || copied.User != "alice" || copied.ClientIP != "192.0.2.7" {
+			t.Errorf("Unexpected caller in %+v", copied)
+		}
+		if copied.Storage != "local" || copied.DstStorage != "s3" || copied.Destination != "/backup" {
+			t.Errorf("Unexpected target in %+v", copied)
+		}
+		if strings.Join(copied.Paths, ",") != "/docs/a.txt,/docs/b.txt" {
+			t.Errorf("Unexpected paths %v", copied.Paths)
+		}
+		if copied.Outcome != "success" || copied.Status != http.StatusOK || copied.Time.IsZero() {
+			t.Errorf("Unexpected outcome in %+v", copied)
+		}
+
+		deleted := events[1]
+		if deleted.Outcome != "failure" || deleted.Status != http.StatusForbidden || deleted.Error != "permission denied" {
+			t.Errorf("Unexpected failure in %+v", deleted)
+		}
+	})
+
+	t.Run("Failures", func(t *testing.T) {
+		events := auditLines(t, AuditFailures, func(a *AuditLogger) {
+			a.Wrap("copy", copyFiles)(httptest.NewRecorder(), request("POST", "/api/fs/copy", body))
+			a.Wrap("delete", deleteFiles)(httptest.NewRecorder(), request("DELETE", "/api/fs/delete", `{}`))
+		})
+		if len(events) != 1 || events[0].Action != "delete" {
+			t.Errorf("Expected only the failed delete, got %+v", events)
+		}
+	})
+
+	t.Run("All", func(t *testing.T) {
+		events := auditLines(t, AuditAll, func(a *AuditLogger) {
+			a.Wrap("download", download)(httptest.NewRecorder(), request("GET", "/api/fs/download?storage=local&path=/docs/a.txt", ""))
+		})
+		if len(events) != 1 || events[0].Storage != "local" || strings.Join(events[0].Paths, ",") != "/docs/a.txt" {
+			t.Errorf("Expected the download from the query, got %+v", events)
+		}
+	})
+
+	t.Run("Target named by the handler", func(t *testing.T) {
+		events := auditLines(t, AuditChanges, func(a *AuditLogger) {
+			complete := func(w http.ResponseWriter, r *http.Request) {
+				auditTarget(r, "local", "/uploads/big.iso")
+				successResponse(w, nil)
+			}
+			a.Wrap("upload", complete)(httptest.NewRecorder(), request("POST", "/api/fs/upload/complete", `{"upload_id":"abc"}`))
+		})
+		if len(events) != 1 || events[0].Storage != "local" || events[0].Paths[0] != "/uploads/big.iso" {
+			t.Errorf("Expected the handler's target, got %+v", events)
+		}
+	})
+
+	t.Run("Nil logger", func(t *testing.T) {
+		var a *AuditLogger
+		rr := httptest.NewRecorder()
+		a.Wrap("copy", copyFiles)(rr, request("POST", "/api/fs/copy", body))
+		if rr.Code != http.StatusOK {
+			t.Errorf("Expected the handler to run, got %d", rr.Code)
+		}
+	})
+}
+
+// blockingWriter never returns from Write until released
+type blockingWriter struct {
+	release chan struct{}
+}
+
+func (b *blockingWriter) Write(p []byte) (int, error) {
+	<-b.release
+	return len(p), nil
+}
+
+func TestAuditLogger_NeverBlocks(t *testing.T) {
+	out := &blockingWriter{release: make(chan struct{})}
+	a := NewAuditLogger(out, AuditChanges)
+	handler := a.Wrap("mkdir", func(w http.ResponseWriter, r *http.Request) {
+		successResponse(w, nil)
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < auditQueueSize*3; i++ {
+			handler(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/fs/mkdir", strings.NewReader(`{}`)))
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Requests blocked on a stuck audit log")
+	}
+	if a.dropped.Load() == 0 {
+		t.Error("Expected events to be dropped once the queue filled")
+	}
+
+	close(out.release)
+	a.Close()
+}
+
+func TestAuditLogger_AuditAfterClose(t *testing.T) {
+	var out bytes.Buffer
+	a := NewAuditLogger(&out, AuditChanges)
+	a.Close()
+
+	// A handler still running when the server gave up on it finishes late
+	handler := a.Wrap("delete", func(w http.ResponseWriter, r *http.Request) {
+		successResponse(w, nil)
+	})
+	handler(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/api/fs/delete", strings.NewReader(`{}`)))
+	a.Audit(AuditEvent{Action: "mkdir", Outcome: "success"})
+	a.Close()
+
+	if out.Len() != 0 {
+		t.Errorf("Expected nothing written after Close, got %q", out.String())
+	}
+	if a.dropped.Load() != 2 {
+		t.Errorf("Expected both late events dropped, got %d", a.dropped.Load())
+	}
+}
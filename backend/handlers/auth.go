@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// DefaultTokenTTL is how long a login token stays valid unless configured
+// otherwise
+const DefaultTokenTTL = 12 * time.Hour
+
+// maxLoginBody is the largest login request read
+const maxLoginBody = 64 << 10
+
+// errInvalidToken is returned for tokens that are malformed, carry a bad
+// signature or name an unknown user
+var errInvalidToken = errors.New("invalid token")
+
+// errTokenExpired is returned for well-formed tokens past their expiry
+var errTokenExpired = errors.New("token expired")
+
+// dummyPasswordHash is compared against for unknown users, so a failed
+// login takes as long whether or not the user exists
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("jacommander"), bcrypt.DefaultCost)
+	return hash
+})
+
+// UserStore holds the accounts allowed to log in, loaded from a JSON file
+// of bcrypt password hashes:
+//
+//	{"users": [{"username": "admin", "password_hash": "$2a$10$..."}]}
+type UserStore struct {
+	hashes map[string][]byte
+}
+
+// LoadUserStore reads the users file at path
+func LoadUserStore(path string) (*UserStore, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read users file: %w", err)
+	}
+
+	var file struct {
+		Users []struct {
+			Username     string `json:"username"`
+			PasswordHash string `json:"password_hash"`
+		} `json:"users"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse users file: %w", err)
+	}
+
+	store := &UserStore{hashes: make(map[string][]byte, len(file.Users))}
+	for _, user := range file.Users {
+		if user.Username == "" {
+			return nil, fmt.Errorf("users file has a user without a username")
+		}
+		if _, err := bcrypt.Cost([]byte(user.PasswordHash)); err != nil {
+			return nil, fmt.Errorf("user %s: password_hash is not a bcrypt hash", user.Username)
+		}
+		store.hashes[user.Username] = []byte(user.PasswordHash)
+	}
+	return store, nil
+}
+
+// Check reports whether password is the password of username
+func (s *UserStore) Check(username, password string) bool {
+	hash, ok := s.hashes[username]
+	if !ok {
+		_ = bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(password))
+		return false
+	}
+	return bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil
+}
+
+// Has reports whether username is a known user
+func (s *UserStore) Has(username string) bool {
+	_, ok := s.hashes[username]
+	return ok
+}
+
+// tokenClaims is the payload of a login token
+type tokenClaims struct {
+	Subject   string `json:"sub"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// tokenHeader is the only JWT header issued and accepted
+var tokenHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// AuthHandler logs users in and checks the JWTs it issues, signed with
+// HMAC-SHA256
+type AuthHandler struct {
+	users  *UserStore
+	secret []byte
+	ttl    time.Duration
+}
+
+// NewAuthHandler creates an auth handler issuing tokens signed with secret
+// that stay valid for ttl
+func NewAuthHandler(users *UserStore, secret []byte, ttl time.Duration) *AuthHandler {
+	return &AuthHandler{
+		users:  users,
+		secret: secret,
+		ttl:    ttl,
+	}
+}
+
+// issue returns a token for username and when it expires
+func (h *AuthHandler) issue(username string) (string, time.Time, error) {
+	now := time.Now()
+	expires := now.Add(h.ttl)
+	payload, err := json.Marshal(tokenClaims{Subject: username, IssuedAt: now.Unix(), ExpiresAt: expires.Unix()})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	unsigned := tokenHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return unsigned + "." + h.sign(unsigned), expires, nil
+}
+
+func (h *AuthHandler) sign(unsigned string) string {
+	mac := hmac.New(sha256.New, h.secret)
+	mac.Write([]byte(unsigned))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verify returns the user a token was issued to. Only tokens with the
+// header issue writes are accepted, so the algorithm can't be swapped.
+func (h *AuthHandler) verify(token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != tokenHeader {
+		return "", errInvalidToken
+	}
+	if !hmac.Equal([]byte(parts[2]), []byte(h.sign(parts[0]+"."+parts[1]))) {
+		return "", errInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", errInvalidToken
+	}
+	var claims tokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Subject == "" {
+		return "", errInvalidToken
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return "", errTokenExpired
+	}
+	// Removing a user from the file revokes their tokens on restart
+	if !h.users.Has(claims.Subject) {
+		return "", errInvalidToken
+	}
+	return claims.Subject, nil
+}
+
+// requestToken returns the bearer token of r. Browsers can't set headers
+// on a WebSocket handshake, so upgrades may pass it as the token query
+// parameter instead.
+func requestToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return r.URL.Query().Get("token")
+	}
+	return ""
+}
+
+// Require wraps next so it is only reachable with a valid token
+func (h *AuthHandler) Require(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := requestToken(r)
+		if token == "" {
+			errorResponse(w, "Authentication required", http.StatusUnauthorized)
+			return
+		}
+		username, err := h.verify(token)
+		if err != nil {
+			errorResponse(w, fmt.Sprintf("Unauthorized: %v", err), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey{}, username)))
+	})
+}
+
+// userKey is the context key Require stores the logged-in user under
+type userKey struct{}
+
+// requestUser returns the user a request was authenticated as, or "" when
+// logins aren't configured
+func requestUser(r *http.Request) string {
+	username, _ := r.Context().Value(userKey{}).(string)
+	return username
+}
+
+// Login checks a username and password and returns a token for them
+func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxLoginBody)).Decode(&request); err != nil {
+		errorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if !h.users.Check(request.Username, request.Password) {
+		errorResponse(w, "Invalid username or password", http.StatusUnauthorized)
+		return
+	}
+
+	h.tokenResponse(w, request.Username)
+}
+
+// Refresh exchanges a valid token for a new one, so a client can stay
+// logged in without sending the password again
+func (h *AuthHandler) Refresh(w http.ResponseWriter, r *http.Request) {
+	username, err := h.verify(requestToken(r))
+	if err != nil {
+		errorResponse(w, fmt.Sprintf("Unauthorized: %v", err), http.StatusUnauthorized)
+		return
+	}
+
+	h.tokenResponse(w, username)
+}
+
+func (h *AuthHandler) tokenResponse(w http.ResponseWriter, username string) {
+	token, expires, err := h.issue(username)
+	if err != nil {
+		errorResponse(w, fmt.Sprintf("Failed to issue token: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	successResponse(w, map[string]interface{}{
+		"token":      token,
+		"username":   username,
+		"expires_at": expires.UTC().Format(time.RFC3339),
+	})
+}
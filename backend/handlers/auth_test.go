@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// newTestAuthHandler returns an auth handler knowing user alice, whose
+// password is "secret", issuing tokens valid for ttl
+func newTestAuthHandler(t *testing.T, ttl time.Duration) *AuthHandler {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "users.json")
+	data := fmt.Sprintf(`{"users": [{"username": "alice", "password_hash": %q}]}`, hash)
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	users, err := LoadUserStore(path)
+	if err != nil {
+		t.Fatalf("Failed to load users: %v", err)
+	}
+	return NewAuthHandler(users, []byte("test-secret"), ttl)
+}
+
+func TestAuthHandler_Login(t *testing.T) {
+	h := newTestAuthHandler(t, time.Hour)
+
+	login := func(username, password string) (int, string) {
+		body := fmt.Sprintf(`{"username": %q, "password": %q}`, username, password)
+		rr := httptest.NewRecorder()
+		h.Login(rr, httptest.NewRequest("POST", "/api/auth/login", strings.NewReader(body)))
+		var resp struct {
+			Data struct {
+				Token string `json:"token"`
+			} `json:"data"`
+		}
+		_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+		return rr.Code, resp.Data.Token
+	}
+
+	if code, _ := login("alice", "wrong"); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a wrong password, got %d", code)
+	}
+	if code, _ := login("bob", "secret"); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for an unknown user, got %d", code)
+	}
+	code, token := login("alice", "secret")
+	if code != http.StatusOK || token == "" {
+		t.Fatalf("Expected a token, got %d", code)
+	}
+
+	protected := h.Require(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	call := func(req *http.Request) int {
+		rr := httptest.NewRecorder()
+		protected.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	bearer := func(token string) *http.Request {
+		req := httptest.NewRequest("GET", "/api/fs/list", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		return req
+	}
+
+	if got := call(httptest.NewRequest("GET", "/api/fs/list", nil)); got != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a token, got %d", got)
+	}
+	if got := call(bearer(token)); got != http.StatusNoContent {
+		t.Errorf("Expected the token to be accepted, got %d", got)
+	}
+
+	// A token from another secret, or with its payload changed, is refused
+	parts := strings.Split(token, ".")
+	forged := parts[0] + "." + parts[1] + "x." + parts[2]
+	other := NewAuthHandler(h.users, []byte("other-secret"), time.Hour)
+	otherToken, _, _ := other.issue("alice")
+	for _, bad := range []string{forged, otherToken, "not.a.token"} {
+		if got := call(bearer(bad)); got != http.StatusUnauthorized {
+			t.Errorf("Expected 401 for %q, got %d", bad, got)
+		}
+	}
+
+	// Expired tokens are refused
+	expired := NewAuthHandler(h.users, []byte("test-secret"), -time.Minute)
+	expiredToken, _, _ := expired.issue("alice")
+	if got := call(bearer(expiredToken)); got != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for an expired token, got %d", got)
+	}
+
+	// WebSocket upgrades may carry the token in the query, other requests
+	// may not
+	ws := httptest.NewRequest("GET", "/api/ws?token="+token, nil)
+	ws.Header.Set("Upgrade", "websocket")
+	if got := call(ws); got != http.StatusNoContent {
+		t.Errorf("Expected the WebSocket query token to be accepted, got %d", got)
+	}
+	if got := call(httptest.NewRequest("GET", "/api/fs/list?token="+token, nil)); got != http.StatusUnauthorized {
+		t.Errorf("Expected the query token to be refused outside WebSocket, got %d", got)
+	}
+}
+
+func TestAuthHandler_Refresh(t *testing.T) {
+	h := newTestAuthHandler(t, time.Hour)
+	token, _, err := h.issue("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refresh := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/auth/refresh", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		h.Refresh(rr, req)
+		return rr
+	}
+
+	rr := refresh(token)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp struct {
+		Data struct {
+			Token    string `json:"token"`
+			Username string `json:"username"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Data.Username != "alice" {
+		t.Errorf("Expected a token for alice, got %+v", resp.Data)
+	}
+	if _, err := h.verify(resp.Data.Token); err != nil {
+		t.Errorf("Expected the new token to be valid, got %v", err)
+	}
+
+	expired := NewAuthHandler(h.users, []byte("test-secret"), -time.Minute)
+	expiredToken, _, _ := expired.issue("alice")
+	if rr := refresh(expiredToken); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected an expired token not to be refreshed, got %d", rr.Code)
+	}
+}
+
+func TestLoadUserStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.json")
+	if err := os.WriteFile(path, []byte(`{"users": [{"username": "alice", "password_hash": "secret"}]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadUserStore(path); err == nil {
+		t.Error("Expected a plain text password to be rejected")
+	}
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+// DefaultBatchConcurrency is how many files of a copy, move or delete
+// request are handled at once unless configured otherwise
+const DefaultBatchConcurrency = 8
+
+// SetBatchConcurrency sets how many files of a copy, move or delete request
+// are handled at once. Values below 1 restore DefaultBatchConcurrency.
+func (h *FileHandlers) SetBatchConcurrency(n int) {
+	if n < 1 {
+		n = DefaultBatchConcurrency
+	}
+	h.batchConcurrency = n
+}
+
+// batchLimit returns how many files may be handled at once across fss,
+// lowered for backends that can't take that many requests
+func (h *FileHandlers) batchLimit(fss ...storage.FileSystem) int {
+	limit := h.batchConcurrency
+	if limit < 1 {
+		limit = DefaultBatchConcurrency
+	}
+	for _, fs := range fss {
+		if cl, ok := storage.As[storage.ConcurrencyLimiter](fs); ok {
+			if n := cl.MaxConcurrency(); n > 0 {
+				limit = min(limit, n)
+			}
+		}
+	}
+	return limit
+}
+
+// runBatch calls fn for every file and its index, up to batchLimit(fss...)
+// at a time. A failure doesn't stop the others; the errors are returned in
+// the order of files, nil for those that succeeded.
+func (h *FileHandlers) runBatch(files []string, fn func(i int, file string) error, fss ...storage.FileSystem) []error {
+	errs := make([]error, len(files))
+	var g errgroup.Group
+	g.SetLimit(h.batchLimit(fss...))
+	for i, file := range files {
+		g.Go(func() error {
+			errs[i] = fn(i, file)
+			return nil
+		})
+	}
+	_ = g.Wait()
+	return errs
+}
+
+// batchFailed reports the files of a batch that failed, if any. When none
+// succeeded the first error decides the status, as it would for a single
+// file; otherwise the failures are listed with 206 Partial Content, as
+// DeleteFiles does.
+func batchFailed(w http.ResponseWriter, verb, participle string, files []string, errs []error) bool {
+	var failures []string
+	first := -1
+	for i, err := range errs {
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", files[i], err))
+			if first < 0 {
+				first = i
+			}
+		}
+	}
+
+	switch {
+	case len(failures) == 0:
+		return false
+	case len(failures) == len(files):
+		storageErrorResponse(w, fmt.Sprintf("Failed to %s %s: %v", verb, files[first], errs[first]), errs[first])
+	default:
+		errorResponse(w, fmt.Sprintf("Some files could not be %s: %s", participle, strings.Join(failures, ", ")), http.StatusPartialContent)
+	}
+	return true
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+// slowCopyFileSystem takes a while over each copy, recording the most
+// copies in flight at once
+type slowCopyFileSystem struct {
+	*mockFileSystem
+	inFlight, peak atomic.Int32
+	maxConcurrency int
+}
+
+func (s *slowCopyFileSystem) Copy(src, dst string, progress storage.ProgressCallback) error {
+	n := s.inFlight.Add(1)
+	defer s.inFlight.Add(-1)
+	for {
+		peak := s.peak.Load()
+		if n <= peak || s.peak.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	return s.mockFileSystem.Copy(src, dst, progress)
+}
+
+func (s *slowCopyFileSystem) MaxConcurrency() int { return s.maxConcurrency }
+
+func TestFileHandlers_CopyBatch(t *testing.T) {
+	newFS := func(maxConcurrency int) *slowCopyFileSystem {
+		fs := &slowCopyFileSystem{mockFileSystem: newMockFileSystem(), maxConcurrency: maxConcurrency}
+		for i := 0; i < 20; i++ {
+			fs.files[fmt.Sprintf("/f%d.txt", i)] = []byte("x")
+		}
+		return fs
+	}
+	copyFiles := func(fs storage.FileSystem, files ...string) *httptest.ResponseRecorder {
+		mgr := storage.NewManager()
+		mgr.Register("mock", fs)
+		h := NewFileHandlers(mgr)
+		h.SetBatchConcurrency(4)
+		body, _ := json.Marshal(map[string]interface{}{
+			"src_storage": "mock", "dst_storage": "mock",
+			"src_path": "/", "dst_path": "/copy", "files": files,
+		})
+		rr := httptest.NewRecorder()
+		h.CopyFiles(rr, httptest.NewRequest("POST", "/api/fs/copy", strings.NewReader(string(body))))
+		return rr
+	}
+	all := make([]string, 20)
+	for i := range all {
+		all[i] = fmt.Sprintf("f%d.txt", i)
+	}
+
+	t.Run("Bounded parallel copies", func(t *testing.T) {
+		fs := newFS(0)
+		if rr := copyFiles(fs, all...); rr.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if peak := fs.peak.Load(); peak < 2 || peak > 4 {
+			t.Errorf("Expected between 2 and 4 copies at once, got %d", peak)
+		}
+		if _, ok := fs.files["/copy/f19.txt"]; !ok {
+			t.Error("Expected every file to be copied")
+		}
+	})
+
+	t.Run("Backend limit", func(t *testing.T) {
+		fs := newFS(1)
+		copyFiles(fs, all[:5]...)
+		if peak := fs.peak.Load(); peak != 1 {
+			t.Errorf("Expected one copy at a time, got %d", peak)
+		}
+	})
+
+	t.Run("Failures don't stop the batch", func(t *testing.T) {
+		fs := newFS(0)
+		rr := copyFiles(fs, "f1.txt", "missing.txt", "f2.txt")
+		if rr.Code != http.StatusPartialContent || !strings.Contains(rr.Body.String(), "missing.txt") {
+			t.Errorf("Expected 206 naming the missing file, got %d: %s", rr.Code, rr.Body.String())
+		}
+		for _, name := range []string{"/copy/f1.txt", "/copy/f2.txt"} {
+			if _, ok := fs.files[name]; !ok {
+				t.Errorf("Expected %s to be copied", name)
+			}
+		}
+	})
+
+	t.Run("Every file failing", func(t *testing.T) {
+		if rr := copyFiles(newFS(0), "missing.txt"); rr.Code == http.StatusOK || rr.Code == http.StatusPartialContent {
+			t.Errorf("Expected an error status, got %d", rr.Code)
+		}
+	})
+}
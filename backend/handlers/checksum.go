@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+// checksumAlgorithms are the hashes /fs/checksum offers
+var checksumAlgorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"crc32":  func() hash.Hash { return crc32.NewIEEE() },
+}
+
+// Checksum hashes a file with the requested algorithm. The content is
+// streamed through the hash, so any backend works and large files aren't
+// held in memory.
+func (h *FileHandlers) Checksum(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	storageID := query.Get("storage")
+	path := query.Get("path")
+	algo := query.Get("algo")
+	if algo == "" {
+		algo = "sha256"
+	}
+
+	newHash, ok := checksumAlgorithms[algo]
+	if !ok {
+		errorResponse(w, fmt.Sprintf("Unknown algorithm %q: use md5, sha1, sha256 or crc32", algo), http.StatusBadRequest)
+		return
+	}
+
+	fs, ok := h.storageManager.Get(storageID)
+	if !ok {
+		errorResponse(w, "Storage not found", http.StatusNotFound)
+		return
+	}
+	fs = archiveView(fs, path)
+
+	info, err := fs.Stat(path)
+	if err != nil {
+		errorResponse(w, "File not found", http.StatusNotFound)
+		return
+	}
+	if info.IsDir {
+		errorResponse(w, "Path is a directory", http.StatusBadRequest)
+		return
+	}
+
+	digest, size, err := checksumFile(r.Context(), fs, path, newHash())
+	if err != nil {
+		if r.Context().Err() != nil {
+			return
+		}
+		log.Printf("Error hashing %s: %v", path, err)
+		storageErrorResponse(w, "Failed to read file", err)
+		return
+	}
+
+	successResponse(w, map[string]interface{}{
+		"path":     path,
+		"algo":     algo,
+		"checksum": digest,
+		"size":     size,
+	})
+}
+
+// contextReader fails reads once ctx is done
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr *contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
+
+// checksumFile reads path through h, stopping early when ctx ends
+func checksumFile(ctx context.Context, fs storage.FileSystem, path string, h hash.Hash) (string, int64, error) {
+	reader, err := fs.Read(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer reader.Close()
+
+	size, err := io.Copy(h, &contextReader{ctx: ctx, r: reader})
+	if err != nil {
+		return "", size, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+func TestFileHandlers_Checksum(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "hello.txt"), []byte("hello world\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(root, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	mgr := storage.NewManager()
+	mgr.Register("local", storage.NewLocalStorage(root))
+	handler := NewFileHandlers(mgr)
+
+	checksum := func(query string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler.Checksum(rr, httptest.NewRequest("GET", "/api/fs/checksum?"+query, nil))
+		return rr
+	}
+
+	// Reference digests of "hello world\n"
+	tests := []struct {
+		algo string
+		want string
+	}{
+		{"md5", "6f5902ac237024bdd0c176cb93063dc4"},
+		{"sha1", "22596363b3de40b06f981fb85d82312e8c0ed511"},
+		{"sha256", "a948904f2f0f479b8f8197694b30184b0d2ed1c1cd2a1ec0fb85d299a192a447"},
+		{"", "a948904f2f0f479b8f8197694b30184b0d2ed1c1cd2a1ec0fb85d299a192a447"},
+		{"crc32", "af083b2d"},
+	}
+	for _, tt := range tests {
+		rr := checksum("storage=local&path=/hello.txt&algo=" + tt.algo)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: expected status 200, got %d: %s", tt.algo, rr.Code, rr.Body.String())
+		}
+		var resp struct {
+			Data struct {
+				Checksum string `json:"checksum"`
+				Size     int64  `json:"size"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Invalid response: %v", err)
+		}
+		if resp.Data.Checksum != tt.want || resp.Data.Size != 12 {
+			t.Errorf("%s: expected %s over 12 bytes, got %s over %d", tt.algo, tt.want, resp.Data.Checksum, resp.Data.Size)
+		}
+	}
+
+	errorCases := []struct {
+		query string
+		code  int
+	}{
+		{"storage=local&path=/hello.txt&algo=sha512", http.StatusBadRequest},
+		{"storage=local&path=/missing.txt", http.StatusNotFound},
+		{"storage=local&path=/dir", http.StatusBadRequest},
+		{"storage=nope&path=/hello.txt", http.StatusNotFound},
+	}
+	for _, tc := range errorCases {
+		if rr := checksum(tc.query); rr.Code != tc.code {
+			t.Errorf("%s: expected status %d, got %d", tc.query, tc.code, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// defaultUploadChunkSize is the chunk size of a chunked upload that doesn't
+// ask for one
+const defaultUploadChunkSize = 8 << 20
+
+// maxUploadChunks caps the number of chunks an upload may be split into
+const maxUploadChunks = 100_000
+
+// chunkCount returns the number of chunks the file is sent in
+func (u *rangeUpload) chunkCount() int {
+	return int((u.size + u.chunkSize - 1) / u.chunkSize)
+}
+
+// chunkRange returns the bytes chunk index covers
+func (u *rangeUpload) chunkRange(index int) byteRange {
+	start := int64(index) * u.chunkSize
+	return byteRange{Start: start, End: min(start+u.chunkSize, u.size) - 1}
+}
+
+// missingChunks returns the indexes of the chunks not fully received yet
+func (u *rangeUpload) missingChunks() []int {
+	missing := []int{}
+	for index := range u.chunkCount() {
+		chunk := u.chunkRange(index)
+		received := false
+		for _, r := range u.received {
+			if r.Start <= chunk.Start && r.End >= chunk.End {
+				received = true
+				break
+			}
+		}
+		if !received {
+			missing = append(missing, index)
+		}
+	}
+	return missing
+}
+
+// InitUploadRequest starts a chunked upload
+type InitUploadRequest struct {
+	Storage     string `json:"storage"`
+	Path        string `json:"path"`
+	Size        int64  `json:"size"`
+	ChunkSize   int64  `json:"chunk_size"`
+	ContentType string `json:"content_type"`
+}
+
+// InitUpload starts a chunked upload and returns its ID. The file is then
+// sent as numbered chunks of chunk_size bytes, the last one shorter, with
+// UploadChunk and stored by CompleteUpload.
+func (uh *UploadHandler) InitUpload(w http.ResponseWriter, r *http.Request) {
+	var req InitUploadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Path == "" {
+		errorResponse(w, "path is required", http.StatusBadRequest)
+		return
+	}
+	if req.Size <= 0 {
+		errorResponse(w, "size must be positive; send empty files with POST /fs/upload", http.StatusBadRequest)
+		return
+	}
+	if req.ChunkSize == 0 {
+		req.ChunkSize = defaultUploadChunkSize
+	}
+	if req.ChunkSize < 0 || (req.Size+req.ChunkSize-1)/req.ChunkSize > maxUploadChunks {
+		errorResponse(w, fmt.Sprintf("chunk_size must be positive and split the file into at most %d chunks", maxUploadChunks), http.StatusBadRequest)
+		return
+	}
+
+	fs, ok := uh.storageManager.Get(req.Storage)
+	if !ok {
+		errorResponse(w, "Storage not found", http.StatusNotFound)
+		return
+	}
+
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		errorResponse(w, "Failed to create upload ID", http.StatusInternalServerError)
+		return
+	}
+	id := hex.EncodeToString(random)
+
+	upload, err := uh.session(id, fs, req.Storage, req.Path, req.Size)
+	if err != nil {
+		storageErrorResponse(w, fmt.Sprintf("Failed to start upload: %v", err), err)
+		return
+	}
+	upload.mu.Lock()
+	defer upload.mu.Unlock()
+	upload.chunkSize = req.ChunkSize
+	upload.contentType = req.ContentType
+
+	status := upload.status(id)
+	status["upload_id"] = id
+	successResponse(w, status)
+}
+
+// chunkedUpload returns the chunked upload named by the upload_id parameter
+// or writes an error response
+func (uh *UploadHandler) chunkedUpload(w http.ResponseWriter, id string) (*rangeUpload, bool) {
+	uh.mu.Lock()
+	upload, ok := uh.uploads[id]
+	uh.mu.Unlock()
+	if !ok {
+		errorResponse(w, "Upload not found", http.StatusNotFound)
+		return nil, false
+	}
+	upload.mu.Lock()
+	if upload.chunkSize == 0 {
+		upload.mu.Unlock()
+		errorResponse(w, "Upload was not started with /fs/upload/init", http.StatusConflict)
+		return nil, false
+	}
+	return upload, true
+}
+
+// UploadChunk receives chunk index of a chunked upload as the request body.
+// Chunks may arrive in any order and sending one again overwrites it with
+// the same bytes, so a client can retry any chunk it isn't sure arrived.
+func (uh *UploadHandler) UploadChunk(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("upload_id")
+	index, err := strconv.Atoi(r.URL.Query().Get("index"))
+	if err != nil || index < 0 {
+		errorResponse(w, "index must be a non-negative integer", http.StatusBadRequest)
+		return
+	}
+
+	upload, ok := uh.chunkedUpload(w, id)
+	if !ok {
+		return
+	}
+	defer upload.mu.Unlock()
+	upload.lastActive = time.Now()
+
+	if upload.completed {
+		successResponse(w, upload.status(id))
+		return
+	}
+	if upload.file == nil {
+		errorResponse(w, "Upload not found", http.StatusNotFound)
+		return
+	}
+	if index >= upload.chunkCount() {
+		errorResponse(w, fmt.Sprintf("index must be below %d", upload.chunkCount()), http.StatusBadRequest)
+		return
+	}
+
+	chunk := upload.chunkRange(index)
+	length := chunk.End - chunk.Start + 1
+	n, err := io.Copy(io.NewOffsetWriter(upload.file, chunk.Start), io.LimitReader(r.Body, length))
+	if err != nil {
+		storageErrorResponse(w, fmt.Sprintf("Failed to receive chunk: %v", err), err)
+		return
+	}
+	// A body longer than the chunk has a byte left over
+	extra, _ := r.Body.Read(make([]byte, 1))
+	if n != length || extra > 0 {
+		errorResponse(w, fmt.Sprintf("Chunk %d must be %d bytes", index, length), http.StatusBadRequest)
+		return
+	}
+
+	upload.addRange(chunk)
+	if upload.multipart != nil {
+		if err := upload.writeParts(upload.contentType, false); err != nil {
+			storageErrorResponse(w, fmt.Sprintf("Failed to send part to storage: %v", err), err)
+			return
+		}
+	}
+	successResponse(w, upload.status(id))
+}
+
+// CompleteUpload stores a chunked upload once every chunk has arrived.
+// Completing it again returns the same result.
+func (uh *UploadHandler) CompleteUpload(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		UploadID string `json:"upload_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	upload, ok := uh.chunkedUpload(w, req.UploadID)
+	if !ok {
+		return
+	}
+	auditTarget(r, upload.storageID, upload.path)
+	defer upload.mu.Unlock()
+	upload.lastActive = time.Now()
+
+	if upload.completed {
+		successResponse(w, upload.status(req.UploadID))
+		return
+	}
+	if upload.file == nil {
+		errorResponse(w, "Upload not found", http.StatusNotFound)
+		return
+	}
+	if missing := upload.missingChunks(); len(missing) > 0 {
+		errorResponse(w, fmt.Sprintf("%d chunks have not been received, starting with chunk %d", len(missing), missing[0]), http.StatusConflict)
+		return
+	}
+
+	fs, ok := uh.storageManager.Get(upload.storageID)
+	if !ok {
+		errorResponse(w, "Storage not found", http.StatusNotFound)
+		return
+	}
+	if err := upload.finish(fs, upload.contentType); err != nil {
+		storageErrorResponse(w, fmt.Sprintf("Failed to save file: %v", err), err)
+		return
+	}
+	successResponse(w, upload.status(req.UploadID))
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+func TestUploadHandler_Chunked(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+
+	fs := &multipartFileSystem{mockFileSystem: newMockFileSystem(), partSize: 10, uploads: make(map[string][][]byte)}
+	mgr := storage.NewManager()
+	plain := newMockFileSystem()
+	mgr.Register("mock", plain)
+	mgr.Register("mp", fs)
+	handler := NewUploadHandler(mgr)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/api/fs/upload/init", handler.InitUpload).Methods("POST")
+	router.HandleFunc("/api/fs/upload/chunk", handler.UploadChunk).Methods("POST")
+	router.HandleFunc("/api/fs/upload/complete", handler.CompleteUpload).Methods("POST")
+	router.HandleFunc("/api/fs/upload/{id}", handler.UploadStatus).Methods("GET")
+
+	post := func(url, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", url, strings.NewReader(body)))
+		return rr
+	}
+	data := func(rr *httptest.ResponseRecorder) map[string]interface{} {
+		var resp struct {
+			Data map[string]interface{} `json:"data"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return resp.Data
+	}
+	content := "0123456789abcdefghijklmno" // three chunks of 10, the last of 5
+	start := func(storageID string) string {
+		rr := post("/api/fs/upload/init", fmt.Sprintf(`{"storage":%q,"path":"/big.bin","size":%d,"chunk_size":10}`, storageID, len(content)))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected 200 starting the upload, got %d: %s", rr.Code, rr.Body.String())
+		}
+		resp := data(rr)
+		if resp["chunks"] != float64(3) {
+			t.Errorf("Expected 3 chunks, got %v", resp["chunks"])
+		}
+		return resp["upload_id"].(string)
+	}
+	chunk := func(id string, index int, body string) *httptest.ResponseRecorder {
+		return post(fmt.Sprintf("/api/fs/upload/chunk?upload_id=%s&index=%d", id, index), body)
+	}
+	complete := func(id string) *httptest.ResponseRecorder {
+		return post("/api/fs/upload/complete", fmt.Sprintf(`{"upload_id":%q}`, id))
+	}
+
+	t.Run("Chunks in any order", func(t *testing.T) {
+		id := start("mock")
+		if rr := chunk(id, 2, content[20:]); rr.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		chunk(id, 0, content[:10])
+		if rr := complete(id); rr.Code != http.StatusConflict {
+			t.Errorf("Expected 409 completing with a chunk missing, got %d", rr.Code)
+		}
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/fs/upload/"+id, nil))
+		if missing := data(rr)["missing_chunks"]; fmt.Sprint(missing) != "[1]" {
+			t.Errorf("Expected chunk 1 to be missing, got %v", missing)
+		}
+
+		for _, body := range []string{content[10:15], content[10:20] + "x"} {
+			if rr := chunk(id, 1, body); rr.Code != http.StatusBadRequest {
+				t.Errorf("Expected 400 for a chunk of %d bytes, got %d", len(body), rr.Code)
+			}
+		}
+		if rr := chunk(id, 3, "x"); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for an index past the end, got %d", rr.Code)
+		}
+
+		chunk(id, 1, content[10:20])
+		chunk(id, 0, content[:10]) // resending is harmless
+		if rr := complete(id); rr.Code != http.StatusOK {
+			t.Fatalf("Expected 200 completing, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if got := string(plain.files["/big.bin"]); got != content {
+			t.Errorf("Expected %q stored, got %q", content, got)
+		}
+		if rr := complete(id); rr.Code != http.StatusOK {
+			t.Errorf("Expected completing again to succeed, got %d", rr.Code)
+		}
+		if entries, _ := os.ReadDir(os.TempDir()); len(entries) != 0 {
+			t.Errorf("Expected the temp file to be removed, found %d", len(entries))
+		}
+	})
+
+	t.Run("Multipart", func(t *testing.T) {
+		id := start("mp")
+		chunk(id, 0, content[:10])
+		chunk(id, 1, content[10:20])
+		if parts := fs.uploads["mp1"]; len(parts) != 2 {
+			t.Fatalf("Expected the whole chunks to be sent as parts, got %d", len(parts))
+		}
+		chunk(id, 2, content[20:])
+		if rr := complete(id); rr.Code != http.StatusOK {
+			t.Fatalf("Expected 200 completing, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if got := fs.files["/big.bin"]; !bytes.Equal(got, []byte(content)) {
+			t.Errorf("Expected %q stored, got %q", content, got)
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		for body, want := range map[string]int{
+			`{"storage":"mock","size":10}`:                                 http.StatusBadRequest,
+			`{"storage":"mock","path":"/x","size":0}`:                      http.StatusBadRequest,
+			`{"storage":"mock","path":"/x","size":1000000,"chunk_size":1}`: http.StatusBadRequest,
+			`{"storage":"missing","path":"/x","size":10}`:                  http.StatusNotFound,
+		} {
+			if rr := post("/api/fs/upload/init", body); rr.Code != want {
+				t.Errorf("%s: expected %d, got %d", body, want, rr.Code)
+			}
+		}
+		if rr := chunk("nope", 0, "x"); rr.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 for an unknown upload, got %d", rr.Code)
+		}
+		if rr := complete("nope"); rr.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 for an unknown upload, got %d", rr.Code)
+		}
+	})
+}
@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"path"
+	"sync"
+	"time"
+)
+
+// Clipboard modes
+const (
+	clipboardCopy = "copy"
+	clipboardCut  = "cut"
+)
+
+// clipboardTTL is how long a clipboard is kept without being touched
+const clipboardTTL = 24 * time.Hour
+
+// ClipboardEntry is a selection waiting to be pasted
+type ClipboardEntry struct {
+	Storage string    `json:"storage"`
+	Path    string    `json:"path"`
+	Files   []string  `json:"files"`
+	Mode    string    `json:"mode"` // "copy" or "cut"
+	Updated time.Time `json:"updated"`
+}
+
+// clipboardStore holds one clipboard per client
+type clipboardStore struct {
+	mu      sync.Mutex
+	entries map[string]*ClipboardEntry
+}
+
+func newClipboardStore() *clipboardStore {
+	return &clipboardStore{entries: make(map[string]*ClipboardEntry)}
+}
+
+// get returns the client's clipboard, or nil when it is empty
+func (c *clipboardStore) get(client string) *ClipboardEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[client]
+	if !ok || time.Since(entry.Updated) > clipboardTTL {
+		delete(c.entries, client)
+		return nil
+	}
+	return entry
+}
+
+// set replaces the client's clipboard, dropping expired ones on the way
+func (c *clipboardStore) set(client string, entry *ClipboardEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, e := range c.entries {
+		if time.Since(e.Updated) > clipboardTTL {
+			delete(c.entries, id)
+		}
+	}
+	c.entries[client] = entry
+}
+
+// clear empties the client's clipboard. With only set, it is cleared only
+// if it still holds that entry.
+func (c *clipboardStore) clear(client string, only *ClipboardEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if only == nil || c.entries[client] == only {
+		delete(c.entries, client)
+	}
+}
+
+// clipboardOwner identifies whose clipboard a request uses: the signed-in
+// user when authentication is on, and otherwise the client ID the caller
+// sends. Addresses aren't used, since everyone behind a proxy shares one.
+// It reports false when the request names no owner.
+func clipboardOwner(r *http.Request) (string, bool) {
+	if user := requestUser(r); user != "" {
+		return "user:" + user, true
+	}
+	if id := r.Header.Get("X-Client-ID"); id != "" {
+		return "client:" + id, true
+	}
+	return "", false
+}
+
+// requireClipboardOwner returns the request's clipboard owner, responding
+// with an error when it has none
+func requireClipboardOwner(w http.ResponseWriter, r *http.Request) (string, bool) {
+	owner, ok := clipboardOwner(r)
+	if !ok {
+		errorResponse(w, "X-Client-ID header is required", http.StatusBadRequest)
+	}
+	return owner, ok
+}
+
+// SetClipboard stores a cut or copied selection for the client to paste
+// later, wherever it has navigated by then
+func (h *FileHandlers) SetClipboard(w http.ResponseWriter, r *http.Request) {
+	owner, ok := requireClipboardOwner(w, r)
+	if !ok {
+		return
+	}
+	var entry ClipboardEntry
+	if err := json.NewDecoder(r.Body).Decode(&entry); err != nil {
+		errorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if entry.Mode != clipboardCopy && entry.Mode != clipboardCut {
+		errorResponse(w, "mode must be copy or cut", http.StatusBadRequest)
+		return
+	}
+	if len(entry.Files) == 0 {
+		errorResponse(w, "files is required", http.StatusBadRequest)
+		return
+	}
+	if _, ok := h.storageManager.Get(entry.Storage); !ok {
+		errorResponse(w, "Storage not found", http.StatusNotFound)
+		return
+	}
+
+	entry.Updated = time.Now()
+	h.clipboards.set(owner, &entry)
+	successResponse(w, entry)
+}
+
+// GetClipboard returns the client's clipboard, or null when it is empty
+func (h *FileHandlers) GetClipboard(w http.ResponseWriter, r *http.Request) {
+	owner, ok := requireClipboardOwner(w, r)
+	if !ok {
+		return
+	}
+	successResponse(w, h.clipboards.get(owner))
+}
+
+// ClearClipboard empties the client's clipboard
+func (h *FileHandlers) ClearClipboard(w http.ResponseWriter, r *http.Request) {
+	owner, ok := requireClipboardOwner(w, r)
+	if !ok {
+		return
+	}
+	h.clipboards.clear(owner, nil)
+	successResponse(w, nil)
+}
+
+// PasteClipboard copies or moves the clipboard's files into a directory,
+// through the same code as /fs/copy and /fs/move. A cut is pasted once:
+// the clipboard is cleared when the move succeeds.
+func (h *FileHandlers) PasteClipboard(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Storage    string `json:"storage"`
+		Path       string `json:"path"`
+		OnConflict string `json:"on_conflict"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	owner, ok := requireClipboardOwner(w, r)
+	if !ok {
+		return
+	}
+	entry := h.clipboards.get(owner)
+	if entry == nil {
+		errorResponse(w, "Clipboard is empty", http.StatusNotFound)
+		return
+	}
+	if req.Storage == entry.Storage && path.Clean("/"+req.Path) == path.Clean("/"+entry.Path) {
+		errorResponse(w, "Cannot paste into the folder the files came from", http.StatusBadRequest)
+		return
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"src_storage": entry.Storage,
+		"dst_storage": req.Storage,
+		"files":       entry.Files,
+		"src_path":    entry.Path,
+		"dst_path":    req.Path,
+		"on_conflict": req.OnConflict,
+	})
+	if err != nil {
+		errorResponse(w, "Failed to build paste request", http.StatusInternalServerError)
+		return
+	}
+	inner := r.Clone(r.Context())
+	inner.Body = io.NopCloser(bytes.NewReader(body))
+	inner.ContentLength = int64(len(body))
+
+	rec := &auditRecorder{ResponseWriter: w, status: http.StatusOK}
+	if entry.Mode == clipboardCut {
+		h.MoveFiles(rec, inner)
+		if rec.status == http.StatusOK {
+			h.clipboards.clear(owner, entry)
+		}
+		return
+	}
+	h.CopyFiles(rec, inner)
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+func TestFileHandlers_ClipboardCutPaste(t *testing.T) {
+	fs := newMockFileSystem()
+	fs.dirs["/inbox"] = true
+	fs.dirs["/archive"] = true
+	fs.files["/inbox/report.pdf"] = []byte("report")
+	fs.files["/inbox/notes.txt"] = []byte("notes")
+	mgr := storage.NewManager()
+	mgr.Register("local", fs)
+	handler := NewFileHandlers(mgr)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/api/fs/clipboard", handler.GetClipboard).Methods("GET")
+	router.HandleFunc("/api/fs/clipboard", handler.SetClipboard).Methods("POST")
+	router.HandleFunc("/api/fs/clipboard/paste", handler.PasteClipboard).Methods("POST")
+
+	do := func(client, method, url, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("X-Client-ID", client)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	clipboard := func(client string) *ClipboardEntry {
+		rr := do(client, "GET", "/api/fs/clipboard", "")
+		var resp struct {
+			Data *ClipboardEntry `json:"data"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Failed to decode clipboard: %v", err)
+		}
+		return resp.Data
+	}
+
+	rr := do("alice", "POST", "/api/fs/clipboard", `{"storage":"local","path":"/inbox","files":["report.pdf"],"mode":"cut"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if entry := clipboard("alice"); entry == nil || entry.Mode != "cut" || entry.Path != "/inbox" {
+		t.Fatalf("Expected the cut selection, got %+v", entry)
+	}
+	if entry := clipboard("bob"); entry != nil {
+		t.Errorf("Expected another client's clipboard to be empty, got %+v", entry)
+	}
+
+	// Pasting back where the files came from is refused
+	if rr := do("alice", "POST", "/api/fs/clipboard/paste", `{"storage":"local","path":"/inbox/"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 pasting into the source folder, got %d", rr.Code)
+	}
+
+	rr = do("alice", "POST", "/api/fs/clipboard/paste", `{"storage":"local","path":"/archive"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if _, ok := fs.files["/archive/report.pdf"]; !ok {
+		t.Error("Expected report.pdf in /archive")
+	}
+	if _, ok := fs.files["/inbox/report.pdf"]; ok {
+		t.Error("Expected report.pdf to be gone from /inbox")
+	}
+	if _, ok := fs.files["/inbox/notes.txt"]; !ok {
+		t.Error("Expected notes.txt to stay in /inbox")
+	}
+	if entry := clipboard("alice"); entry != nil {
+		t.Errorf("Expected the clipboard to be cleared after a cut-paste, got %+v", entry)
+	}
+	if rr := do("alice", "POST", "/api/fs/clipboard/paste", `{"storage":"local","path":"/archive"}`); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 pasting an empty clipboard, got %d", rr.Code)
+	}
+
+	// A copy can be pasted again and again
+	do("alice", "POST", "/api/fs/clipboard", `{"storage":"local","path":"/inbox","files":["notes.txt"],"mode":"copy"}`)
+	for _, dir := range []string{"/archive", "/"} {
+		if rr := do("alice", "POST", "/api/fs/clipboard/paste", `{"storage":"local","path":"`+dir+`"}`); rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 pasting into %s, got %d: %s", dir, rr.Code, rr.Body.String())
+		}
+	}
+	if _, ok := fs.files["/inbox/notes.txt"]; !ok {
+		t.Error("Expected the copied notes.txt to stay in /inbox")
+	}
+	if _, ok := fs.files["/archive/notes.txt"]; !ok {
+		t.Error("Expected notes.txt to be copied to /archive")
+	}
+	if entry := clipboard("alice"); entry == nil {
+		t.Error("Expected the clipboard to survive a copy-paste")
+	}
+
+	if rr := do("alice", "POST", "/api/fs/clipboard", `{"storage":"local","path":"/","files":["x"],"mode":"paste"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an unknown mode, got %d", rr.Code)
+	}
+	// Without authentication a client ID is required, whatever the address
+	if rr := do("", "GET", "/api/fs/clipboard", ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 without a client ID, got %d", rr.Code)
+	}
+
+	// With it, the clipboard follows the user rather than the client
+	signedIn := func(user, client, method, url, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), userKey{}, user))
+		req.Header.Set("X-Client-ID", client)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	signedIn("carol", "tab-1", "POST", "/api/fs/clipboard", `{"storage":"local","path":"/inbox","files":["notes.txt"],"mode":"cut"}`)
+	if rr := signedIn("carol", "tab-2", "GET", "/api/fs/clipboard", ""); !strings.Contains(rr.Body.String(), "notes.txt") {
+		t.Errorf("Expected the user's clipboard in another tab, got %s", rr.Body.String())
+	}
+	if rr := signedIn("dave", "tab-1", "GET", "/api/fs/clipboard", ""); strings.Contains(rr.Body.String(), "notes.txt") {
+		t.Errorf("Expected another user's clipboard to be empty, got %s", rr.Body.String())
+	}
+}
@@ -3,13 +3,17 @@ package handlers
 import (
 	"archive/tar"
 	"archive/zip"
-	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 	"time"
@@ -21,12 +25,14 @@ import (
 type CompressionHandler struct {
 	storageManager *storage.Manager
 	wsHandler      *WebSocketHandler
+	operations     *OperationRegistry
 }
 
 // NewCompressionHandler creates a new compression handler
 func NewCompressionHandler(manager *storage.Manager) *CompressionHandler {
 	return &CompressionHandler{
 		storageManager: manager,
+		operations:     NewOperationRegistry(),
 	}
 }
 
@@ -35,15 +41,38 @@ func (ch *CompressionHandler) SetWebSocketHandler(ws *WebSocketHandler) {
 	ch.wsHandler = ws
 }
 
+// SetOperationRegistry sets the registry background operations are tracked in
+func (ch *CompressionHandler) SetOperationRegistry(operations *OperationRegistry) {
+	ch.operations = operations
+}
+
 // CompressRequest represents a compression request
 type CompressRequest struct {
 	Storage    string   `json:"storage"`
 	Files      []string `json:"files"`
 	BasePath   string   `json:"base_path"`
 	OutputPath string   `json:"output_path"`
-	Format     string   `json:"format"` // zip, tar, tar.gz, tar.bz2
+	Format     string   `json:"format"`   // zip, tar, tar.gz, tar.bz2, tar.xz, tar.zst
+	Symlinks   string   `json:"symlinks"` // follow, store, skip; defaults to store for tar, skip for zip
+
+	// Exclude overrides the server-wide exclusion patterns when set
+	Exclude *[]string `json:"exclude,omitempty"`
+
+	// WriteChecksum stores the archive's SHA-256 in <output_path>.sha256
+	WriteChecksum bool `json:"write_checksum"`
+
+	// OnOutputExists says what to do when OutputPath is taken: overwrite
+	// (the default), rename to the first free "name (n).ext", or fail
+	OnOutputExists string `json:"on_output_exists"`
 }
 
+// Policies for an existing compression output
+const (
+	outputOverwrite = "overwrite"
+	outputRename    = "rename"
+	outputFail      = "fail"
+)
+
 // DecompressRequest represents a decompression request
 type DecompressRequest struct {
 	Storage      string `json:"storage"`
@@ -60,6 +89,40 @@ func (ch *CompressionHandler) Compress(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if req.Symlinks == "" {
+		req.Symlinks = defaultSymlinkPolicy(req.Format)
+	}
+	if err := validateSymlinkPolicy(req.Symlinks); err != nil {
+		errorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	exclude, err := resolveExcludes(req.Exclude)
+	if err != nil {
+		errorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if codec, ok := tarCodecForFormat(req.Format); ok {
+		if codec != nil && codec.available != nil {
+			if err := codec.available(); err != nil {
+				errorResponse(w, err.Error(), http.StatusNotImplemented)
+				return
+			}
+		}
+	} else if strings.ToLower(req.Format) != "zip" {
+		errorResponse(w, fmt.Sprintf("Unsupported format: %s", req.Format), http.StatusBadRequest)
+		return
+	}
+
+	if req.OnOutputExists == "" {
+		req.OnOutputExists = outputOverwrite
+	}
+	switch req.OnOutputExists {
+	case outputOverwrite, outputRename, outputFail:
+	default:
+		errorResponse(w, fmt.Sprintf("Invalid on_output_exists %q: use overwrite, rename or fail", req.OnOutputExists), http.StatusBadRequest)
+		return
+	}
+
 	// Get storage backend
 	fs, ok := ch.storageManager.Get(req.Storage)
 	if !ok {
@@ -67,15 +130,31 @@ func (ch *CompressionHandler) Compress(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Generate operation ID for progress tracking
-	operationID := fmt.Sprintf("compress-%d", time.Now().UnixNano())
+	// Settle the output name before any work is done
+	if _, err := fs.Stat(req.OutputPath); err == nil {
+		switch req.OnOutputExists {
+		case outputFail:
+			errorResponse(w, fmt.Sprintf("Output already exists: %s", req.OutputPath), http.StatusConflict)
+			return
+		case outputRename:
+			free, err := freePath(fs, req.OutputPath, nil)
+			if err != nil {
+				errorResponse(w, err.Error(), http.StatusConflict)
+				return
+			}
+			req.OutputPath = free
+		}
+	}
+
+	// Register the operation for progress tracking and cancellation
+	op := ch.operations.Start("compress", clientFromRequest(r), req.Storage, req.Files)
 
 	// Start compression in background
-	go ch.performCompression(fs, req, operationID)
+	go ch.performCompression(op, fs, req, exclude)
 
 	successResponse(w, map[string]interface{}{
 		"message":      "Compression started",
-		"operation_id": operationID,
+		"operation_id": op.ID,
 		"output_path":  req.OutputPath,
 	})
 }
@@ -95,99 +174,115 @@ func (ch *CompressionHandler) Decompress(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Generate operation ID for progress tracking
-	operationID := fmt.Sprintf("decompress-%d", time.Now().UnixNano())
+	// Register the operation for progress tracking and cancellation
+	op := ch.operations.Start("decompress", clientFromRequest(r), req.Storage, []string{req.ArchivePath})
 
 	// Start decompression in background
-	go ch.performDecompression(fs, req, operationID)
+	go ch.performDecompression(op, fs, req)
 
 	successResponse(w, map[string]interface{}{
 		"message":      "Decompression started",
-		"operation_id": operationID,
+		"operation_id": op.ID,
 		"output_path":  req.OutputPath,
 	})
 }
 
-// performCompression performs the actual compression
-func (ch *CompressionHandler) performCompression(fs storage.FileSystem, req CompressRequest, operationID string) {
-	// Create progress tracker if WebSocket handler is available
-	var tracker *ProgressTracker
-	if ch.wsHandler != nil {
-		// Calculate total size for progress tracking
-		totalSize := ch.calculateTotalSize(fs, req.Files, req.BasePath)
-		tracker = NewProgressTracker(ch.wsHandler, operationID, "compress", totalSize)
-	}
+// errArchiveOutputClosed stops an archive whose storage write has ended
+var errArchiveOutputClosed = errors.New("archive output closed")
 
-	// Create temporary file for archive
-	tmpFile, err := os.CreateTemp("", "archive-*.tmp")
-	if err != nil {
-		if tracker != nil {
-			tracker.Error(err)
-		}
-		return
-	}
-	defer func() {
-		if err := tmpFile.Close(); err != nil {
-			log.Printf("Error closing temp file: %v", err)
-		}
-		if err := os.Remove(tmpFile.Name()); err != nil {
-			log.Printf("Error removing temp file: %v", err)
-		}
+// performCompression performs the actual compression
+func (ch *CompressionHandler) performCompression(op *Operation, fs storage.FileSystem, req CompressRequest, exclude *storage.ExcludeFilter) {
+	defer ch.operations.Finish(op.ID)
+	ctx := op.Context()
+
+	// Calculate total size for progress tracking
+	totalSize := ch.calculateTotalSize(fs, req.Files, req.BasePath, exclude)
+	tracker := NewProgressTracker(ch.wsHandler, op.ID, "compress", totalSize)
+	tracker.SetOperation(op)
+
+	// Stream the archive straight into the storage as it's built, hashing
+	// it on the way when a checksum was asked for
+	opts := newArchiveOptions(req.Symlinks)
+	opts.exclude = exclude
+	_, statErr := fs.Stat(req.OutputPath)
+	isNew := statErr != nil
+	hasher := sha256.New()
+	pr, pw := io.Pipe()
+	archiveDone := make(chan error, 1)
+	go func() {
+		var output io.Writer = pw
+		if req.WriteChecksum {
+			output = io.MultiWriter(pw, hasher)
+		}
+		err := ch.writeArchive(ctx, fs, output, req, opts, tracker)
+		if err == nil {
+			err = ctx.Err()
+		}
+		pw.CloseWithError(err)
+		archiveDone <- err
 	}()
 
-	// Perform compression based on format
-	switch strings.ToLower(req.Format) {
-	case "zip":
-		err = ch.createZipArchive(fs, tmpFile, req.Files, req.BasePath, tracker)
-	case "tar":
-		err = ch.createTarArchive(fs, tmpFile, req.Files, req.BasePath, false, tracker)
-	case "tar.gz", "tgz":
-		err = ch.createTarArchive(fs, tmpFile, req.Files, req.BasePath, true, tracker)
-	default:
-		err = fmt.Errorf("unsupported format: %s", req.Format)
-	}
-
-	if err != nil {
-		if tracker != nil {
-			tracker.Error(err)
-		}
-		return
-	}
-
-	// Seek to beginning of temp file
-	if _, err := tmpFile.Seek(0, 0); err != nil {
-		log.Printf("Error seeking temp file: %v", err)
-		if tracker != nil {
-			tracker.Error(err)
+	writeErr := fs.Write(req.OutputPath, pr)
+	// Unblock the archiver if the backend gave up before reading it all
+	pr.CloseWithError(errArchiveOutputClosed)
+	archiveErr := <-archiveDone
+	if archiveErr != nil || writeErr != nil {
+		// Backends that write in place may have kept what was streamed
+		if isNew {
+			_ = fs.Delete(req.OutputPath)
+		}
+		if writeErr == nil || (archiveErr != nil && !errors.Is(archiveErr, errArchiveOutputClosed)) {
+			tracker.Fail(archiveErr)
+		} else {
+			tracker.Error(writeErr)
 		}
 		return
 	}
 
-	// Write the archive to the storage
-	if err := fs.Write(req.OutputPath, tmpFile); err != nil {
-		if tracker != nil {
-			tracker.Error(err)
+	var details map[string]string
+	if req.WriteChecksum {
+		digest := hex.EncodeToString(hasher.Sum(nil))
+		if err := writeChecksumSidecar(fs, req.OutputPath, digest); err != nil {
+			tracker.Error(fmt.Errorf("archive written but checksum failed: %w", err))
+			return
 		}
-		return
+		details = map[string]string{"sha256": digest, "checksum_path": req.OutputPath + ".sha256"}
 	}
 
 	// Mark as complete
-	if tracker != nil {
-		tracker.Complete()
-	}
+	tracker.Complete()
 
 	// Send notification
 	if ch.wsHandler != nil {
-		ch.wsHandler.SendNotification(fmt.Sprintf("Compression completed: %s", req.OutputPath))
+		ch.wsHandler.SendNotificationWithData(fmt.Sprintf("Compression completed: %s", req.OutputPath), details)
 	}
 }
 
+// writeArchive writes the requested files to output in req.Format
+func (ch *CompressionHandler) writeArchive(ctx context.Context, fs storage.FileSystem, output io.Writer, req CompressRequest, opts *archiveOptions, tracker *ProgressTracker) error {
+	if strings.ToLower(req.Format) == "zip" {
+		return ch.createZipArchive(ctx, fs, output, req.Files, req.BasePath, opts, tracker)
+	}
+	if codec, ok := tarCodecForFormat(req.Format); ok {
+		return ch.createTarArchive(ctx, fs, output, req.Files, req.BasePath, codec, opts, tracker)
+	}
+	return fmt.Errorf("unsupported format: %s", req.Format)
+}
+
+// writeChecksumSidecar writes an archive's SHA-256 digest next to it as
+// <archivePath>.sha256, in the format `shasum -c` reads
+func writeChecksumSidecar(fs storage.FileSystem, archivePath, digest string) error {
+	line := fmt.Sprintf("%s  %s\n", digest, path.Base(filepath.ToSlash(archivePath)))
+	return fs.Write(archivePath+".sha256", strings.NewReader(line))
+}
+
 // createZipArchive creates a ZIP archive
-func (ch *CompressionHandler) createZipArchive(fs storage.FileSystem, output io.Writer, files []string, basePath string, tracker *ProgressTracker) error {
+func (ch *CompressionHandler) createZipArchive(ctx context.Context, fs storage.FileSystem, output io.Writer, files []string, basePath string, opts *archiveOptions, tracker *ProgressTracker) (err error) {
 	zipWriter := zip.NewWriter(output)
+	// Closing writes the central directory, without which the archive is unreadable
 	defer func() {
-		if err := zipWriter.Close(); err != nil {
-			log.Printf("Error closing zip writer: %v", err)
+		if closeErr := zipWriter.Close(); err == nil {
+			err = closeErr
 		}
 	}()
 
@@ -202,24 +297,44 @@ func (ch *CompressionHandler) createZipArchive(fs storage.FileSystem, output io.
 			return fmt.Errorf("failed to stat %s: %w", file, err)
 		}
 
-		if info.IsDir {
-			// Add directory recursively
-			err = ch.addDirectoryToZip(fs, zipWriter, fullPath, file, &currentSize, tracker)
-		} else {
-			// Add single file
-			err = ch.addFileToZip(fs, zipWriter, fullPath, file, &currentSize, tracker)
+		if err := ch.addEntryToZip(ctx, fs, zipWriter, fullPath, file, info, opts, &currentSize, tracker); err != nil {
+			return err
 		}
+	}
 
+	return nil
+}
+
+// addEntryToZip adds a file, directory or symlink to a ZIP archive
+// according to the symlink policy
+func (ch *CompressionHandler) addEntryToZip(ctx context.Context, fs storage.FileSystem, zipWriter *zip.Writer, fullPath, archivePath string, info storage.FileInfo, opts *archiveOptions, currentSize *int64, tracker *ProgressTracker) error {
+	kind, sourcePath := opts.classifyEntry(fs, fullPath, info)
+
+	switch kind {
+	case entryDir:
+		return ch.addDirectoryToZip(ctx, fs, zipWriter, sourcePath, archivePath, opts, currentSize, tracker)
+	case entryFile:
+		return ch.addFileToZip(ctx, fs, zipWriter, sourcePath, archivePath, currentSize, tracker)
+	case entrySymlink:
+		header := &zip.FileHeader{
+			Name:     archivePath,
+			Method:   zip.Store,
+			Modified: info.ModTime,
+		}
+		header.SetMode(os.ModeSymlink | 0777)
+		writer, err := zipWriter.CreateHeader(header)
 		if err != nil {
 			return err
 		}
+		_, err = io.WriteString(writer, info.LinkTarget)
+		return err
 	}
 
 	return nil
 }
 
 // addFileToZip adds a single file to a ZIP archive
-func (ch *CompressionHandler) addFileToZip(fs storage.FileSystem, zipWriter *zip.Writer, fullPath, archivePath string, currentSize *int64, tracker *ProgressTracker) error {
+func (ch *CompressionHandler) addFileToZip(ctx context.Context, fs storage.FileSystem, zipWriter *zip.Writer, fullPath, archivePath string, currentSize *int64, tracker *ProgressTracker) error {
 	// Get file info
 	info, err := fs.Stat(fullPath)
 	if err != nil {
@@ -252,36 +367,44 @@ func (ch *CompressionHandler) addFileToZip(fs storage.FileSystem, zipWriter *zip
 	}()
 
 	// Copy with progress tracking
-	if tracker != nil {
-		written, err := ch.copyWithProgress(writer, reader, currentSize, tracker)
-		*currentSize += written
-		return err
-	}
-
-	_, err = io.Copy(writer, reader)
+	_, err = ch.copyWithProgress(ctx, writer, reader, currentSize, tracker)
 	return err
 }
 
 // addDirectoryToZip recursively adds a directory to a ZIP archive
-func (ch *CompressionHandler) addDirectoryToZip(fs storage.FileSystem, zipWriter *zip.Writer, dirPath, archivePath string, currentSize *int64, tracker *ProgressTracker) error {
+func (ch *CompressionHandler) addDirectoryToZip(ctx context.Context, fs storage.FileSystem, zipWriter *zip.Writer, dirPath, archivePath string, opts *archiveOptions, currentSize *int64, tracker *ProgressTracker) error {
+	leave, ok := opts.enterDir(dirPath)
+	if !ok {
+		log.Printf("Skipping %s: symlink cycle detected", dirPath)
+		return nil
+	}
+	defer leave()
+
 	// List directory contents
 	files, err := fs.List(dirPath)
 	if err != nil {
 		return err
 	}
 
+	// Zip needs no entries for directories, except to keep empty ones
+	if len(files) == 0 {
+		_, err := zipWriter.CreateHeader(&zip.FileHeader{Name: archivePath + "/", Modified: time.Now()})
+		return err
+	}
+
 	// Add each file/subdirectory
 	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if opts.exclude.Excluded(file.Name) {
+			continue
+		}
+
 		fullPath := filepath.Join(dirPath, file.Name)
 		archiveFilePath := filepath.Join(archivePath, file.Name)
 
-		if file.IsDir {
-			err = ch.addDirectoryToZip(fs, zipWriter, fullPath, archiveFilePath, currentSize, tracker)
-		} else {
-			err = ch.addFileToZip(fs, zipWriter, fullPath, archiveFilePath, currentSize, tracker)
-		}
-
-		if err != nil {
+		if err := ch.addEntryToZip(ctx, fs, zipWriter, fullPath, archiveFilePath, file, opts, currentSize, tracker); err != nil {
 			return err
 		}
 	}
@@ -289,29 +412,25 @@ func (ch *CompressionHandler) addDirectoryToZip(fs storage.FileSystem, zipWriter
 	return nil
 }
 
-// createTarArchive creates a TAR archive (optionally gzipped)
-func (ch *CompressionHandler) createTarArchive(fs storage.FileSystem, output io.Writer, files []string, basePath string, compress bool, tracker *ProgressTracker) error {
-	var tarWriter *tar.Writer
-
-	if compress {
-		// Create gzip writer
-		gzWriter := gzip.NewWriter(output)
+// createTarArchive creates a TAR archive, compressed by codec unless it's nil
+func (ch *CompressionHandler) createTarArchive(ctx context.Context, fs storage.FileSystem, output io.Writer, files []string, basePath string, codec *tarCodec, opts *archiveOptions, tracker *ProgressTracker) (err error) {
+	if codec != nil {
+		compressed, err := codec.newWriter(output)
+		if err != nil {
+			return err
+		}
+		// Closing flushes the compressor, so its error counts
 		defer func() {
-			if err := gzWriter.Close(); err != nil {
-				// Ignore harmless errors
-				if !strings.Contains(err.Error(), "does not allow body") &&
-				   !strings.Contains(err.Error(), "Content-Length") {
-					log.Printf("Error closing gzip writer: %v", err)
-				}
+			if closeErr := compressed.Close(); err == nil && closeErr != nil {
+				err = fmt.Errorf("failed to finish %s stream: %w", codec.name, closeErr)
 			}
 		}()
-		tarWriter = tar.NewWriter(gzWriter)
-	} else {
-		tarWriter = tar.NewWriter(output)
+		output = compressed
 	}
+	tarWriter := tar.NewWriter(output)
 	defer func() {
-		if err := tarWriter.Close(); err != nil {
-			log.Printf("Error closing tar writer: %v", err)
+		if closeErr := tarWriter.Close(); err == nil {
+			err = closeErr
 		}
 	}()
 
@@ -326,15 +445,7 @@ func (ch *CompressionHandler) createTarArchive(fs storage.FileSystem, output io.
 			return fmt.Errorf("failed to stat %s: %w", file, err)
 		}
 
-		if info.IsDir {
-			// Add directory recursively
-			err = ch.addDirectoryToTar(fs, tarWriter, fullPath, file, &currentSize, tracker)
-		} else {
-			// Add single file
-			err = ch.addFileToTar(fs, tarWriter, fullPath, file, &currentSize, tracker)
-		}
-
-		if err != nil {
+		if err := ch.addEntryToTar(ctx, fs, tarWriter, fullPath, file, info, opts, &currentSize, tracker); err != nil {
 			return err
 		}
 	}
@@ -342,8 +453,31 @@ func (ch *CompressionHandler) createTarArchive(fs storage.FileSystem, output io.
 	return nil
 }
 
+// addEntryToTar adds a file, directory or symlink to a TAR archive
+// according to the symlink policy
+func (ch *CompressionHandler) addEntryToTar(ctx context.Context, fs storage.FileSystem, tarWriter *tar.Writer, fullPath, archivePath string, info storage.FileInfo, opts *archiveOptions, currentSize *int64, tracker *ProgressTracker) error {
+	kind, sourcePath := opts.classifyEntry(fs, fullPath, info)
+
+	switch kind {
+	case entryDir:
+		return ch.addDirectoryToTar(ctx, fs, tarWriter, sourcePath, archivePath, opts, currentSize, tracker)
+	case entryFile:
+		return ch.addFileToTar(ctx, fs, tarWriter, sourcePath, archivePath, currentSize, tracker)
+	case entrySymlink:
+		return tarWriter.WriteHeader(&tar.Header{
+			Name:     archivePath,
+			Linkname: info.LinkTarget,
+			Typeflag: tar.TypeSymlink,
+			Mode:     0777,
+			ModTime:  info.ModTime,
+		})
+	}
+
+	return nil
+}
+
 // addFileToTar adds a single file to a TAR archive
-func (ch *CompressionHandler) addFileToTar(fs storage.FileSystem, tarWriter *tar.Writer, fullPath, archivePath string, currentSize *int64, tracker *ProgressTracker) error {
+func (ch *CompressionHandler) addFileToTar(ctx context.Context, fs storage.FileSystem, tarWriter *tar.Writer, fullPath, archivePath string, currentSize *int64, tracker *ProgressTracker) error {
 	// Get file info
 	info, err := fs.Stat(fullPath)
 	if err != nil {
@@ -375,18 +509,19 @@ func (ch *CompressionHandler) addFileToTar(fs storage.FileSystem, tarWriter *tar
 	}()
 
 	// Copy with progress tracking
-	if tracker != nil {
-		written, err := ch.copyWithProgress(tarWriter, reader, currentSize, tracker)
-		*currentSize += written
-		return err
-	}
-
-	_, err = io.Copy(tarWriter, reader)
+	_, err = ch.copyWithProgress(ctx, tarWriter, reader, currentSize, tracker)
 	return err
 }
 
 // addDirectoryToTar recursively adds a directory to a TAR archive
-func (ch *CompressionHandler) addDirectoryToTar(fs storage.FileSystem, tarWriter *tar.Writer, dirPath, archivePath string, currentSize *int64, tracker *ProgressTracker) error {
+func (ch *CompressionHandler) addDirectoryToTar(ctx context.Context, fs storage.FileSystem, tarWriter *tar.Writer, dirPath, archivePath string, opts *archiveOptions, currentSize *int64, tracker *ProgressTracker) error {
+	leave, ok := opts.enterDir(dirPath)
+	if !ok {
+		log.Printf("Skipping %s: symlink cycle detected", dirPath)
+		return nil
+	}
+	defer leave()
+
 	// List directory contents
 	files, err := fs.List(dirPath)
 	if err != nil {
@@ -406,16 +541,17 @@ func (ch *CompressionHandler) addDirectoryToTar(fs storage.FileSystem, tarWriter
 
 	// Add each file/subdirectory
 	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if opts.exclude.Excluded(file.Name) {
+			continue
+		}
+
 		fullPath := filepath.Join(dirPath, file.Name)
 		archiveFilePath := filepath.Join(archivePath, file.Name)
 
-		if file.IsDir {
-			err = ch.addDirectoryToTar(fs, tarWriter, fullPath, archiveFilePath, currentSize, tracker)
-		} else {
-			err = ch.addFileToTar(fs, tarWriter, fullPath, archiveFilePath, currentSize, tracker)
-		}
-
-		if err != nil {
+		if err := ch.addEntryToTar(ctx, fs, tarWriter, fullPath, archiveFilePath, file, opts, currentSize, tracker); err != nil {
 			return err
 		}
 	}
@@ -424,21 +560,19 @@ func (ch *CompressionHandler) addDirectoryToTar(fs storage.FileSystem, tarWriter
 }
 
 // performDecompression performs the actual decompression
-func (ch *CompressionHandler) performDecompression(fs storage.FileSystem, req DecompressRequest, operationID string) {
-	// Create progress tracker if WebSocket handler is available
-	var tracker *ProgressTracker
-	if ch.wsHandler != nil {
-		// Get archive size for progress tracking
-		info, _ := fs.Stat(req.ArchivePath)
-		tracker = NewProgressTracker(ch.wsHandler, operationID, "decompress", info.Size)
-	}
+func (ch *CompressionHandler) performDecompression(op *Operation, fs storage.FileSystem, req DecompressRequest) {
+	defer ch.operations.Finish(op.ID)
+	ctx := op.Context()
+
+	// Get archive size for progress tracking
+	info, _ := fs.Stat(req.ArchivePath)
+	tracker := NewProgressTracker(ch.wsHandler, op.ID, "decompress", info.Size)
+	tracker.SetOperation(op)
 
 	// Open archive file
 	reader, err := fs.Read(req.ArchivePath)
 	if err != nil {
-		if tracker != nil {
-			tracker.Error(err)
-		}
+		tracker.Error(err)
 		return
 	}
 	defer func() {
@@ -447,14 +581,20 @@ func (ch *CompressionHandler) performDecompression(fs storage.FileSystem, req De
 		}
 	}()
 
-	// Determine archive format by extension
-	ext := strings.ToLower(filepath.Ext(req.ArchivePath))
+	// Determine archive format by extension. Tar flavours match on their
+	// whole suffix, so "x.tar.gz" extracts into "x".
+	suffix := filepath.Ext(req.ArchivePath)
+	ext := strings.ToLower(suffix)
+	codec, tarSuffix, isTar := tarCodecForArchive(req.ArchivePath)
+	if isTar {
+		suffix = tarSuffix
+	}
 
 	// Create output directory if needed
 	outputPath := req.OutputPath
 	if req.CreateFolder {
 		// Create a folder with the archive name (without extension)
-		baseName := strings.TrimSuffix(filepath.Base(req.ArchivePath), ext)
+		baseName := strings.TrimSuffix(filepath.Base(req.ArchivePath), suffix)
 		outputPath = filepath.Join(outputPath, baseName)
 		if err := fs.MkDir(outputPath); err != nil {
 			log.Printf("Error creating output directory: %v", err)
@@ -462,32 +602,22 @@ func (ch *CompressionHandler) performDecompression(fs storage.FileSystem, req De
 	}
 
 	// Perform extraction based on format
-	switch ext {
-	case ".zip":
-		err = ch.extractZipArchive(fs, reader, outputPath, tracker)
-	case ".tar":
-		err = ch.extractTarArchive(fs, reader, outputPath, false, tracker)
-	case ".gz", ".tgz":
-		if strings.HasSuffix(strings.ToLower(req.ArchivePath), ".tar.gz") || ext == ".tgz" {
-			err = ch.extractTarArchive(fs, reader, outputPath, true, tracker)
-		} else {
-			err = fmt.Errorf("unsupported format: %s", ext)
-		}
+	switch {
+	case ext == ".zip":
+		err = ch.extractZipArchive(ctx, fs, reader, outputPath, tracker)
+	case isTar:
+		err = ch.extractTarArchive(ctx, fs, reader, outputPath, codec, tracker)
 	default:
 		err = fmt.Errorf("unsupported format: %s", ext)
 	}
 
 	if err != nil {
-		if tracker != nil {
-			tracker.Error(err)
-		}
+		tracker.Fail(err)
 		return
 	}
 
 	// Mark as complete
-	if tracker != nil {
-		tracker.Complete()
-	}
+	tracker.Complete()
 
 	// Send notification
 	if ch.wsHandler != nil {
@@ -495,28 +625,37 @@ func (ch *CompressionHandler) performDecompression(fs storage.FileSystem, req De
 	}
 }
 
-// extractZipArchive extracts a ZIP archive
-func (ch *CompressionHandler) extractZipArchive(fs storage.FileSystem, reader io.Reader, outputPath string, tracker *ProgressTracker) error {
-	// ZIP extraction requires seeking, so we need to copy to a temporary file first
+// spoolArchive copies an archive to a temp file for formats that need
+// random access. cleanup closes and removes the file.
+func (ch *CompressionHandler) spoolArchive(ctx context.Context, reader io.Reader) (*os.File, func(), error) {
 	tmpFile, err := os.CreateTemp("", "extract-*.zip")
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
-	defer func() {
-		if err := os.Remove(tmpFile.Name()); err != nil {
-			log.Printf("Error removing temp file: %v", err)
-		}
-	}()
-	defer func() {
+	cleanup := func() {
 		if err := tmpFile.Close(); err != nil {
 			log.Printf("Error closing temp file: %v", err)
 		}
-	}()
+		if err := os.Remove(tmpFile.Name()); err != nil {
+			log.Printf("Error removing temp file: %v", err)
+		}
+	}
 
-	// Copy archive to temp file
-	if _, err := io.Copy(tmpFile, reader); err != nil {
+	if _, err := ch.copyWithProgress(ctx, tmpFile, reader, new(int64), nil); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return tmpFile, cleanup, nil
+}
+
+// extractZipArchive extracts a ZIP archive
+func (ch *CompressionHandler) extractZipArchive(ctx context.Context, fs storage.FileSystem, reader io.Reader, outputPath string, tracker *ProgressTracker) error {
+	// ZIP extraction requires seeking, so we need to copy to a temporary file first
+	tmpFile, cleanup, err := ch.spoolArchive(ctx, reader)
+	if err != nil {
 		return err
 	}
+	defer cleanup()
 
 	// Open as ZIP
 	zipReader, err := zip.OpenReader(tmpFile.Name())
@@ -533,6 +672,10 @@ func (ch *CompressionHandler) extractZipArchive(fs storage.FileSystem, reader io
 
 	// Extract each file
 	for _, file := range zipReader.File {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
 		filePath := filepath.Join(outputPath, file.Name)
 
 		if file.FileInfo().IsDir() {
@@ -558,8 +701,7 @@ func (ch *CompressionHandler) extractZipArchive(fs storage.FileSystem, reader io
 		if tracker != nil {
 			// Create temp buffer for progress tracking
 			tmpOut, _ := os.CreateTemp("", "extract-file-*.tmp")
-			written, err := ch.copyWithProgress(tmpOut, rc, &currentSize, tracker)
-			currentSize += written
+			_, err := ch.copyWithProgress(ctx, tmpOut, rc, &currentSize, tracker)
 			if _, seekErr := tmpOut.Seek(0, 0); seekErr != nil {
 				log.Printf("Error seeking temp file: %v", seekErr)
 			}
@@ -593,30 +735,31 @@ func (ch *CompressionHandler) extractZipArchive(fs storage.FileSystem, reader io
 	return nil
 }
 
-// extractTarArchive extracts a TAR archive (optionally gzipped)
-func (ch *CompressionHandler) extractTarArchive(fs storage.FileSystem, reader io.Reader, outputPath string, compressed bool, tracker *ProgressTracker) error {
-	var tarReader *tar.Reader
-
-	if compressed {
-		// Create gzip reader
-		gzReader, err := gzip.NewReader(reader)
+// extractTarArchive extracts a TAR archive, decompressing it with codec
+// unless it's nil
+func (ch *CompressionHandler) extractTarArchive(ctx context.Context, fs storage.FileSystem, reader io.Reader, outputPath string, codec *tarCodec, tracker *ProgressTracker) error {
+	if codec != nil {
+		decompressed, err := codec.newReader(reader)
 		if err != nil {
-			return err
+			return fmt.Errorf("invalid %s stream: %w", codec.name, err)
 		}
 		defer func() {
-			if err := gzReader.Close(); err != nil {
-				log.Printf("Error closing gzip reader: %v", err)
+			if err := decompressed.Close(); err != nil {
+				log.Printf("Error closing %s reader: %v", codec.name, err)
 			}
 		}()
-		tarReader = tar.NewReader(gzReader)
-	} else {
-		tarReader = tar.NewReader(reader)
+		reader = decompressed
 	}
+	tarReader := tar.NewReader(reader)
 
 	var currentSize int64
 
 	// Extract each file
 	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
 		header, err := tarReader.Next()
 		if err == io.EOF {
 			break
@@ -644,8 +787,7 @@ func (ch *CompressionHandler) extractTarArchive(fs storage.FileSystem, reader io
 			if tracker != nil {
 				// Create temp buffer for progress tracking
 				tmpOut, _ := os.CreateTemp("", "extract-file-*.tmp")
-				written, err := ch.copyWithProgress(tmpOut, tarReader, &currentSize, tracker)
-				currentSize += written
+				_, err := ch.copyWithProgress(ctx, tmpOut, tarReader, &currentSize, tracker)
 				if _, seekErr := tmpOut.Seek(0, 0); seekErr != nil {
 					log.Printf("Error seeking temp file: %v", seekErr)
 				}
@@ -673,12 +815,17 @@ func (ch *CompressionHandler) extractTarArchive(fs storage.FileSystem, reader io
 	return nil
 }
 
-// copyWithProgress copies data with progress tracking
-func (ch *CompressionHandler) copyWithProgress(dst io.Writer, src io.Reader, currentSize *int64, tracker *ProgressTracker) (int64, error) {
+// copyWithProgress copies data with progress tracking, stopping early when
+// the context is cancelled
+func (ch *CompressionHandler) copyWithProgress(ctx context.Context, dst io.Writer, src io.Reader, currentSize *int64, tracker *ProgressTracker) (int64, error) {
 	buf := make([]byte, 32*1024) // 32KB buffer
 	var written int64
 
 	for {
+		if err := ctx.Err(); err != nil {
+			return written, err
+		}
+
 		n, err := src.Read(buf)
 		if n > 0 {
 			if _, writeErr := dst.Write(buf[:n]); writeErr != nil {
@@ -702,20 +849,21 @@ func (ch *CompressionHandler) copyWithProgress(dst io.Writer, src io.Reader, cur
 }
 
 // calculateTotalSize calculates the total size of files to be compressed
-func (ch *CompressionHandler) calculateTotalSize(fs storage.FileSystem, files []string, basePath string) int64 {
+func (ch *CompressionHandler) calculateTotalSize(fs storage.FileSystem, files []string, basePath string, exclude *storage.ExcludeFilter) int64 {
 	var totalSize int64
 
 	for _, file := range files {
 		fullPath := filepath.Join(basePath, file)
-		size := ch.getFileOrDirSize(fs, fullPath)
+		size := ch.getFileOrDirSize(fs, fullPath, exclude)
 		totalSize += size
 	}
 
 	return totalSize
 }
 
-// getFileOrDirSize gets the size of a file or directory (recursive)
-func (ch *CompressionHandler) getFileOrDirSize(fs storage.FileSystem, path string) int64 {
+// getFileOrDirSize gets the size of a file or directory (recursive), not
+// counting excluded entries
+func (ch *CompressionHandler) getFileOrDirSize(fs storage.FileSystem, path string, exclude *storage.ExcludeFilter) int64 {
 	info, err := fs.Stat(path)
 	if err != nil {
 		return 0
@@ -725,16 +873,19 @@ func (ch *CompressionHandler) getFileOrDirSize(fs storage.FileSystem, path strin
 		return info.Size
 	}
 
-	// For directories, calculate size recursively
+	// For directories, add up everything below
 	var size int64
-	files, err := fs.List(path)
+	err = storage.Walk(fs, path, func(file storage.FileInfo) error {
+		if exclude.Excluded(file.Name) {
+			return storage.SkipDir
+		}
+		if !file.IsDir {
+			size += file.Size
+		}
+		return nil
+	})
 	if err != nil {
-		return 0
-	}
-
-	for _, file := range files {
-		fullPath := filepath.Join(path, file.Name)
-		size += ch.getFileOrDirSize(fs, fullPath)
+		log.Printf("Error calculating size of %s: %v", path, err)
 	}
 
 	return size
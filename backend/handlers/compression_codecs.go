@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// tarCodec is the compression layer wrapped around a tar stream
+type tarCodec struct {
+	name      string
+	newWriter func(io.Writer) (io.WriteCloser, error)
+	newReader func(io.Reader) (io.ReadCloser, error)
+
+	// available, when set, reports why the codec can't write on this server
+	available func() error
+}
+
+var (
+	gzipCodec = &tarCodec{
+		name: "gzip",
+		newWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return gzip.NewWriter(w), nil
+		},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+	}
+
+	// The standard library only reads bzip2, so writing goes through the
+	// bzip2 program
+	bzip2Codec = &tarCodec{
+		name:      "bzip2",
+		newWriter: newBzip2Writer,
+		available: func() error {
+			if _, err := exec.LookPath("bzip2"); err != nil {
+				return errNoBzip2
+			}
+			return nil
+		},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			return io.NopCloser(bzip2.NewReader(r)), nil
+		},
+	}
+
+	xzCodec = &tarCodec{
+		name: "xz",
+		newWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return xz.NewWriter(w)
+		},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			reader, err := xz.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return io.NopCloser(reader), nil
+		},
+	}
+
+	zstdCodec = &tarCodec{
+		name: "zstd",
+		newWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return zstd.NewWriter(w)
+		},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			decoder, err := zstd.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return decoder.IOReadCloser(), nil
+		},
+	}
+)
+
+// tarFormats lists the tar flavours by request format and by the archive
+// name suffixes that identify them on extraction
+var tarFormats = []struct {
+	formats  []string
+	suffixes []string
+	codec    *tarCodec // nil for a plain tar
+}{
+	{[]string{"tar"}, []string{".tar"}, nil},
+	{[]string{"tar.gz", "tgz"}, []string{".tar.gz", ".tgz"}, gzipCodec},
+	{[]string{"tar.bz2", "tbz2"}, []string{".tar.bz2", ".tbz2", ".tbz"}, bzip2Codec},
+	{[]string{"tar.xz", "txz"}, []string{".tar.xz", ".txz"}, xzCodec},
+	{[]string{"tar.zst", "tzst"}, []string{".tar.zst", ".tzst"}, zstdCodec},
+}
+
+// tarCodecForFormat looks up a CompressRequest format. ok is false when
+// the format isn't a tar flavour.
+func tarCodecForFormat(format string) (codec *tarCodec, ok bool) {
+	format = strings.ToLower(format)
+	for _, f := range tarFormats {
+		for _, name := range f.formats {
+			if name == format {
+				return f.codec, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// tarCodecForArchive picks the codec from an archive's name, also
+// returning the suffix that matched so it can be trimmed off
+func tarCodecForArchive(name string) (codec *tarCodec, suffix string, ok bool) {
+	lower := strings.ToLower(name)
+	for _, f := range tarFormats {
+		for _, s := range f.suffixes {
+			if strings.HasSuffix(lower, s) {
+				return f.codec, name[len(name)-len(s):], true
+			}
+		}
+	}
+	return nil, "", false
+}
+
+// errNoBzip2 is returned for tar.bz2 compression on servers without bzip2
+var errNoBzip2 = errors.New("tar.bz2 compression needs the bzip2 program, which was not found on the server")
+
+// commandWriter feeds what is written to it through an external filter
+// program whose output goes to the underlying writer
+type commandWriter struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stderr bytes.Buffer
+}
+
+func newBzip2Writer(w io.Writer) (io.WriteCloser, error) {
+	program, err := exec.LookPath("bzip2")
+	if err != nil {
+		return nil, errNoBzip2
+	}
+
+	cw := &commandWriter{cmd: exec.Command(program, "-c")}
+	cw.cmd.Stdout = w
+	cw.cmd.Stderr = &cw.stderr
+	if cw.stdin, err = cw.cmd.StdinPipe(); err != nil {
+		return nil, err
+	}
+	if err := cw.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start bzip2: %w", err)
+	}
+	return cw, nil
+}
+
+func (cw *commandWriter) Write(p []byte) (int, error) {
+	return cw.stdin.Write(p)
+}
+
+// Close ends the input and waits for the program to flush its output
+func (cw *commandWriter) Close() error {
+	closeErr := cw.stdin.Close()
+	if err := cw.cmd.Wait(); err != nil {
+		if msg := strings.TrimSpace(cw.stderr.String()); msg != "" {
+			return fmt.Errorf("bzip2 failed: %w: %s", err, msg)
+		}
+		return fmt.Errorf("bzip2 failed: %w", err)
+	}
+	return closeErr
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"strings"
+
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+// Symlink policies for archive creation
+const (
+	SymlinkFollow = "follow" // archive the link target's content
+	SymlinkStore  = "store"  // archive the link itself
+	SymlinkSkip   = "skip"   // leave links out of the archive
+)
+
+// maxSymlinkHops bounds how many followed links may be nested when the
+// backend can't resolve links to canonical paths
+const maxSymlinkHops = 40
+
+// archiveOptions holds per-archive settings and traversal state
+type archiveOptions struct {
+	symlinks string
+
+	// active holds the directories currently being walked, used to detect
+	// cycles when following symlinks
+	active map[string]bool
+	hops   int
+
+	// exclude lists entries left out of directories being archived
+	exclude *storage.ExcludeFilter
+}
+
+// entryKind tells how an entry is written to an archive
+type entryKind int
+
+const (
+	entrySkip entryKind = iota
+	entryFile
+	entryDir
+	entrySymlink
+)
+
+// defaultSymlinkPolicy returns the policy used when a request doesn't set
+// one. Tar can represent links natively; zip support for them is spotty.
+func defaultSymlinkPolicy(format string) string {
+	if strings.ToLower(format) == "zip" {
+		return SymlinkSkip
+	}
+	return SymlinkStore
+}
+
+// validateSymlinkPolicy checks a requested symlink policy
+func validateSymlinkPolicy(policy string) error {
+	switch policy {
+	case SymlinkFollow, SymlinkStore, SymlinkSkip:
+		return nil
+	default:
+		return fmt.Errorf("unsupported symlink policy: %s", policy)
+	}
+}
+
+func newArchiveOptions(symlinks string) *archiveOptions {
+	return &archiveOptions{
+		symlinks: symlinks,
+		active:   make(map[string]bool),
+	}
+}
+
+// classifyEntry decides how to archive the entry at fullPath. For followed
+// links it returns the path whose content should be archived instead.
+func (opts *archiveOptions) classifyEntry(fs storage.FileSystem, fullPath string, info storage.FileInfo) (entryKind, string) {
+	if !info.IsLink {
+		if info.IsDir {
+			return entryDir, fullPath
+		}
+		return entryFile, fullPath
+	}
+
+	switch opts.symlinks {
+	case SymlinkStore:
+		return entrySymlink, fullPath
+	case SymlinkFollow:
+		target, err := resolveLinkTarget(fs, fullPath, info)
+		if err != nil {
+			log.Printf("Skipping symlink %s: %v", fullPath, err)
+			return entrySkip, ""
+		}
+		targetInfo, err := fs.Stat(target)
+		if err != nil {
+			log.Printf("Skipping broken symlink %s: %v", fullPath, err)
+			return entrySkip, ""
+		}
+		if targetInfo.IsLink {
+			// Chains of links are resolved one hop at a time
+			return opts.classifyChain(fs, target, targetInfo)
+		}
+		if targetInfo.IsDir {
+			return entryDir, target
+		}
+		return entryFile, target
+	default:
+		return entrySkip, ""
+	}
+}
+
+// classifyChain follows a link that points to another link
+func (opts *archiveOptions) classifyChain(fs storage.FileSystem, target string, info storage.FileInfo) (entryKind, string) {
+	if opts.hops >= maxSymlinkHops {
+		log.Printf("Skipping symlink %s: too many levels of links", target)
+		return entrySkip, ""
+	}
+	opts.hops++
+	defer func() { opts.hops-- }()
+	return opts.classifyEntry(fs, target, info)
+}
+
+// enterDir marks a directory as being walked. It returns false if the
+// directory is already on the current walk, which means a symlink cycle.
+func (opts *archiveOptions) enterDir(dirPath string) (func(), bool) {
+	if opts.symlinks != SymlinkFollow {
+		return func() {}, true
+	}
+
+	dirPath = path.Clean(dirPath)
+	if opts.active[dirPath] {
+		return nil, false
+	}
+	opts.active[dirPath] = true
+	return func() { delete(opts.active, dirPath) }, true
+}
+
+// resolveLinkTarget returns the storage path a symlink points to
+func resolveLinkTarget(fs storage.FileSystem, linkPath string, info storage.FileInfo) (string, error) {
+	if resolver, ok := storage.As[storage.LinkResolver](fs); ok {
+		return resolver.ResolveLink(linkPath)
+	}
+
+	if info.LinkTarget == "" {
+		return "", fmt.Errorf("unknown link target")
+	}
+	if path.IsAbs(info.LinkTarget) {
+		return path.Clean(info.LinkTarget), nil
+	}
+	return path.Join(path.Dir(linkPath), info.LinkTarget), nil
+}
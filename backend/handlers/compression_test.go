@@ -0,0 +1,382 @@
+package handlers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+// setupSymlinkTree creates root/tree with a regular file, a file symlink and
+// a directory symlink pointing back at its parent
+func setupSymlinkTree(t *testing.T) *storage.LocalStorage {
+	root := t.TempDir()
+	tree := filepath.Join(root, "tree")
+	if err := os.MkdirAll(filepath.Join(tree, "sub"), 0755); err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tree, "sub", "a.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	if err := os.Symlink("sub/a.txt", filepath.Join(tree, "link.txt")); err != nil {
+		t.Fatalf("Failed to create file symlink: %v", err)
+	}
+	if err := os.Symlink("..", filepath.Join(tree, "sub", "loop")); err != nil {
+		t.Fatalf("Failed to create dir symlink: %v", err)
+	}
+	return storage.NewLocalStorage(root)
+}
+
+func tarEntries(t *testing.T, data []byte) map[string]*tar.Header {
+	entries := make(map[string]*tar.Header)
+	tr := tar.NewReader(bytes.NewReader(data))
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to read tar: %v", err)
+		}
+		entries[header.Name] = header
+	}
+	return entries
+}
+
+func names(entries map[string]*tar.Header) []string {
+	var result []string
+	for name := range entries {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func TestCompression_SymlinkPolicy(t *testing.T) {
+	fs := setupSymlinkTree(t)
+	ch := NewCompressionHandler(storage.NewManager())
+	ctx := context.Background()
+
+	t.Run("Tar store", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := ch.createTarArchive(ctx, fs, &buf, []string{"tree"}, "/", nil, newArchiveOptions(SymlinkStore), nil); err != nil {
+			t.Fatalf("Failed to create archive: %v", err)
+		}
+		entries := tarEntries(t, buf.Bytes())
+
+		link, ok := entries["tree/link.txt"]
+		if !ok || link.Typeflag != tar.TypeSymlink || link.Linkname != "sub/a.txt" {
+			t.Errorf("Expected stored symlink to sub/a.txt, got %+v", link)
+		}
+		loop, ok := entries["tree/sub/loop"]
+		if !ok || loop.Typeflag != tar.TypeSymlink {
+			t.Errorf("Expected stored directory symlink, got %v", names(entries))
+		}
+	})
+
+	t.Run("Tar skip", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := ch.createTarArchive(ctx, fs, &buf, []string{"tree"}, "/", nil, newArchiveOptions(SymlinkSkip), nil); err != nil {
+			t.Fatalf("Failed to create archive: %v", err)
+		}
+		entries := tarEntries(t, buf.Bytes())
+
+		if _, ok := entries["tree/link.txt"]; ok {
+			t.Error("Symlink should have been skipped")
+		}
+		if _, ok := entries["tree/sub/a.txt"]; !ok {
+			t.Errorf("Regular file missing, got %v", names(entries))
+		}
+	})
+
+	t.Run("Tar follow with cycle", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := ch.createTarArchive(ctx, fs, &buf, []string{"tree"}, "/", nil, newArchiveOptions(SymlinkFollow), nil); err != nil {
+			t.Fatalf("Failed to create archive: %v", err)
+		}
+		entries := tarEntries(t, buf.Bytes())
+
+		link, ok := entries["tree/link.txt"]
+		if !ok || link.Typeflag != tar.TypeReg || link.Size != 5 {
+			t.Errorf("Expected followed symlink stored as regular file, got %+v", link)
+		}
+		// The loop points at tree/, which is already being walked
+		for name := range entries {
+			if strings.HasPrefix(name, "tree/sub/loop/") {
+				t.Errorf("Cycle was followed: %s", name)
+			}
+		}
+	})
+
+	t.Run("Zip skip and store", func(t *testing.T) {
+		for _, policy := range []string{SymlinkSkip, SymlinkStore} {
+			var buf bytes.Buffer
+			if err := ch.createZipArchive(ctx, fs, &buf, []string{"tree"}, "/", newArchiveOptions(policy), nil); err != nil {
+				t.Fatalf("Failed to create %s archive: %v", policy, err)
+			}
+			zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			if err != nil {
+				t.Fatalf("Failed to read zip: %v", err)
+			}
+
+			var link *zip.File
+			for _, f := range zr.File {
+				if f.Name == "tree/link.txt" {
+					link = f
+				}
+			}
+			switch policy {
+			case SymlinkSkip:
+				if link != nil {
+					t.Error("Symlink should have been skipped in zip")
+				}
+			case SymlinkStore:
+				if link == nil || link.Mode()&os.ModeSymlink == 0 {
+					t.Errorf("Expected symlink entry in zip, got %+v", link)
+				}
+			}
+		}
+	})
+
+	t.Run("Defaults", func(t *testing.T) {
+		if got := defaultSymlinkPolicy("zip"); got != SymlinkSkip {
+			t.Errorf("Expected skip for zip, got %s", got)
+		}
+		if got := defaultSymlinkPolicy("tar.gz"); got != SymlinkStore {
+			t.Errorf("Expected store for tar.gz, got %s", got)
+		}
+		if err := validateSymlinkPolicy("bogus"); err == nil {
+			t.Error("Expected error for unknown policy")
+		}
+	})
+}
+
+func TestCompressionHandler_WriteChecksum(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "a.txt"), []byte("checksum me"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	fs := storage.NewLocalStorage(root)
+	ch := NewCompressionHandler(storage.NewManager())
+
+	req := CompressRequest{
+		Files:         []string{"a.txt"},
+		BasePath:      "/",
+		OutputPath:    "/out.tar.gz",
+		Format:        "tar.gz",
+		WriteChecksum: true,
+	}
+	op := ch.operations.Start("compress", "test", "local", req.Files)
+	ch.performCompression(op, fs, req, nil)
+
+	archive, err := os.ReadFile(filepath.Join(root, "out.tar.gz"))
+	if err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+	sidecar, err := os.ReadFile(filepath.Join(root, "out.tar.gz.sha256"))
+	if err != nil {
+		t.Fatalf("Failed to read checksum file: %v", err)
+	}
+
+	sum := sha256.Sum256(archive)
+	want := hex.EncodeToString(sum[:]) + "  out.tar.gz\n"
+	if string(sidecar) != want {
+		t.Errorf("Expected checksum file %q, got %q", want, sidecar)
+	}
+}
+
+func TestCompressionHandler_TarFormatsRoundTrip(t *testing.T) {
+	root := t.TempDir()
+	tree := map[string][]byte{
+		"tree/readme.txt":       []byte("round trip"),
+		"tree/sub/data.bin":     bytes.Repeat([]byte{0, 1, 2, 0xfe, 0xff}, 40000),
+		"tree/sub/deep/empty":   {},
+		"tree/sub/deep/log.txt": []byte(strings.Repeat("line\n", 1000)),
+	}
+	for name, content := range tree {
+		full := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fs := storage.NewLocalStorage(root)
+	ch := NewCompressionHandler(storage.NewManager())
+
+	for _, format := range []string{"tar", "tar.gz", "tgz", "tar.bz2", "tar.xz", "txz", "tar.zst"} {
+		t.Run(format, func(t *testing.T) {
+			codec, _ := tarCodecForFormat(format)
+			if codec != nil && codec.available != nil && codec.available() != nil {
+				t.Skipf("%s is not available: %v", codec.name, codec.available())
+			}
+
+			archivePath := "/out." + format
+			req := CompressRequest{Files: []string{"tree"}, BasePath: "/", OutputPath: archivePath, Format: format}
+			ch.performCompression(ch.operations.Start("compress", "test", "local", req.Files), fs, req, nil)
+			if _, err := os.Stat(filepath.Join(root, archivePath)); err != nil {
+				t.Fatalf("Archive was not written: %v", err)
+			}
+
+			dest := "/extract-" + strings.ReplaceAll(format, ".", "-")
+			dreq := DecompressRequest{ArchivePath: archivePath, OutputPath: dest, CreateFolder: true}
+			ch.performDecompression(ch.operations.Start("decompress", "test", "local", nil), fs, dreq)
+
+			// The whole archive suffix is dropped from the folder name
+			for name, want := range tree {
+				got, err := os.ReadFile(filepath.Join(root, dest, "out", name))
+				if err != nil {
+					t.Fatalf("Missing %s after extraction: %v", name, err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Errorf("%s differs after the round trip", name)
+				}
+			}
+		})
+	}
+}
+
+func TestCompressionHandler_Formats(t *testing.T) {
+	root := t.TempDir()
+	mgr := storage.NewManager()
+	mgr.Register("local", storage.NewLocalStorage(root))
+	ch := NewCompressionHandler(mgr)
+
+	compress := func(format string) *httptest.ResponseRecorder {
+		body := `{"storage":"local","files":["a.txt"],"base_path":"/","output_path":"/out","format":"` + format + `"}`
+		rr := httptest.NewRecorder()
+		ch.Compress(rr, httptest.NewRequest("POST", "/api/fs/compress", strings.NewReader(body)))
+		return rr
+	}
+
+	if rr := compress("rar"); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown format, got %d", rr.Code)
+	}
+
+	// Without the bzip2 program tar.bz2 is refused up front
+	t.Setenv("PATH", "")
+	rr := compress("tar.bz2")
+	if rr.Code != http.StatusNotImplemented || !strings.Contains(rr.Body.String(), "bzip2 program") {
+		t.Errorf("Expected 501 naming the bzip2 program, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestCompressionHandler_FailedArchiveLeavesNoOutput(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "a.txt"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fs := storage.NewLocalStorage(root)
+	ch := NewCompressionHandler(storage.NewManager())
+
+	req := CompressRequest{Files: []string{"a.txt", "missing.txt"}, BasePath: "/", OutputPath: "/out.zip", Format: "zip"}
+	ch.performCompression(ch.operations.Start("compress", "test", "local", req.Files), fs, req, nil)
+
+	if _, err := os.Stat(filepath.Join(root, "out.zip")); !os.IsNotExist(err) {
+		t.Errorf("Expected no archive after a failure, got %v", err)
+	}
+}
+
+func TestCompressionHandler_OnOutputExists(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"a.txt", "archive.zip", "archive (1).zip", "backup.tar.gz"} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte("existing"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mgr := storage.NewManager()
+	mgr.Register("local", storage.NewLocalStorage(root))
+	ch := NewCompressionHandler(mgr)
+
+	compress := func(output, format, policy string) (*httptest.ResponseRecorder, string) {
+		body := fmt.Sprintf(`{"storage":"local","files":["a.txt"],"base_path":"/","output_path":%q,"format":%q,"on_output_exists":%q}`, output, format, policy)
+		rr := httptest.NewRecorder()
+		ch.Compress(rr, httptest.NewRequest("POST", "/api/fs/compress", strings.NewReader(body)))
+
+		var resp struct {
+			Data struct {
+				OperationID string `json:"operation_id"`
+				OutputPath  string `json:"output_path"`
+			} `json:"data"`
+		}
+		if rr.Code != http.StatusOK {
+			return rr, ""
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			if _, running := ch.operations.Get(resp.Data.OperationID); !running {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("Compression did not finish")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return rr, resp.Data.OutputPath
+	}
+	isArchive := func(name string) bool {
+		data, err := os.ReadFile(filepath.Join(root, name))
+		return err == nil && string(data) != "existing"
+	}
+
+	t.Run("fail", func(t *testing.T) {
+		rr, _ := compress("/archive.zip", "zip", "fail")
+		if rr.Code != http.StatusConflict {
+			t.Errorf("Expected 409, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if isArchive("archive.zip") {
+			t.Error("Expected the existing file to be left alone")
+		}
+	})
+
+	t.Run("rename", func(t *testing.T) {
+		if _, output := compress("/archive.zip", "zip", "rename"); output != "/archive (2).zip" {
+			t.Errorf("Expected /archive (2).zip, got %q", output)
+		}
+		if _, output := compress("/backup.tar.gz", "tar.gz", "rename"); output != "/backup (1).tar.gz" {
+			t.Errorf("Expected /backup (1).tar.gz, got %q", output)
+		}
+		if _, output := compress("/new.zip", "zip", "rename"); output != "/new.zip" {
+			t.Errorf("Expected a free name to be kept, got %q", output)
+		}
+		for _, name := range []string{"archive (2).zip", "backup (1).tar.gz", "new.zip"} {
+			if !isArchive(name) {
+				t.Errorf("Expected an archive at %s", name)
+			}
+		}
+		if isArchive("archive.zip") || isArchive("archive (1).zip") || isArchive("backup.tar.gz") {
+			t.Error("Expected the existing files to be left alone")
+		}
+	})
+
+	t.Run("overwrite by default", func(t *testing.T) {
+		if _, output := compress("/archive.zip", "zip", ""); output != "/archive.zip" || !isArchive("archive.zip") {
+			t.Errorf("Expected archive.zip to be overwritten, got %q", output)
+		}
+	})
+
+	t.Run("unknown policy", func(t *testing.T) {
+		if rr, _ := compress("/archive.zip", "zip", "skip"); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400, got %d", rr.Code)
+		}
+	})
+}
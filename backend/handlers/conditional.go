@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+// maxHashETagSize is the largest file whose ETag is a hash of its content
+// when the backend keeps none. Larger files get one from their size and
+// modification time, which is cheaper but can miss a same-size change made
+// within the backend's timestamp resolution.
+const maxHashETagSize = 4 << 20
+
+// fileETag returns the strong ETag of a file: the backend's own when it
+// keeps one, otherwise a content hash or, for large files, one built from
+// size and modification time. When the file had to be read to hash it,
+// its content is returned too.
+func fileETag(fs storage.FileSystem, path string, info storage.FileInfo) (string, []byte, error) {
+	if tagger, ok := storage.As[storage.ETagger](fs); ok {
+		if etag, err := tagger.ETag(path); err == nil && etag != "" {
+			return `"` + etag + `"`, nil, nil
+		}
+	}
+	if info.Size > maxHashETagSize {
+		return fmt.Sprintf(`"%x-%x"`, info.ModTime.UnixNano(), info.Size), nil, nil
+	}
+
+	reader, err := fs.Read(path)
+	if err != nil {
+		return "", nil, err
+	}
+	defer reader.Close()
+	content, err := io.ReadAll(io.LimitReader(reader, maxHashETagSize+1))
+	if err != nil {
+		return "", nil, err
+	}
+	sum := sha256.Sum256(content)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`, content, nil
+}
+
+// etagListMatches reports whether an If-Match or If-None-Match header
+// lists etag, or is "*". Weak tags never match, as If-Match requires a
+// strong comparison.
+func etagListMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified reports whether a download can be answered with 304 Not
+// Modified. If-None-Match uses the weak comparison reads are allowed, so a
+// W/ tag from a proxy still matches; If-Modified-Since is only looked at
+// when there is no If-None-Match, and at the one-second resolution of
+// HTTP dates.
+func notModified(r *http.Request, etag string, modTime time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	ims := r.Header.Get("If-Modified-Since")
+	if ims == "" || modTime.IsZero() {
+		return false
+	}
+	since, err := http.ParseTime(ims)
+	if err != nil {
+		return false
+	}
+	return !modTime.Truncate(time.Second).After(since)
+}
+
+// writeCondition is what a conditional write requires of the file when it
+// is finally written
+type writeCondition struct {
+	etag string // the ETag the file must still have; "" when it must not exist
+}
+
+// checkWritePreconditions evaluates a write's If-Match and If-None-Match
+// headers against the file at path. It returns ErrPreconditionFailed when
+// they don't hold, and the condition to enforce again at write time when
+// the backend can do that atomically. Without either header it returns nil.
+func checkWritePreconditions(r *http.Request, fs storage.FileSystem, path string) (*writeCondition, error) {
+	ifMatch := r.Header.Get("If-Match")
+	ifNoneMatch := r.Header.Get("If-None-Match")
+	if ifMatch == "" && ifNoneMatch == "" {
+		return nil, nil
+	}
+
+	// Not every backend wraps os.ErrNotExist, so any Stat failure counts as
+	// a missing file. That fails If-Match, which is the safe outcome.
+	info, err := fs.Stat(path)
+	exists := err == nil
+
+	var etag string
+	if exists {
+		if info.IsDir {
+			return nil, fmt.Errorf("%s is a directory: %w", path, os.ErrExist)
+		}
+		if etag, _, err = fileETag(fs, path, info); err != nil {
+			return nil, err
+		}
+	}
+
+	if ifMatch != "" && (!exists || !etagListMatches(ifMatch, etag)) {
+		return nil, fmt.Errorf("%w: %s has changed", storage.ErrPreconditionFailed, path)
+	}
+	if ifNoneMatch != "" && exists && etagListMatches(ifNoneMatch, etag) {
+		return nil, fmt.Errorf("%w: %s already exists", storage.ErrPreconditionFailed, path)
+	}
+	return &writeCondition{etag: etag}, nil
+}
+
+// writeChecked writes a file like writeWithContentType. With a condition
+// and a backend that can check it atomically, the write also fails with
+// ErrPreconditionFailed if the file changed after the preconditions were
+// checked.
+func writeChecked(fs storage.FileSystem, path string, data io.Reader, contentType string, cond *writeCondition) error {
+	if cond != nil {
+		if cw, ok := storage.As[storage.ConditionalWriter](fs); ok {
+			return cw.WriteConditional(path, data, contentType, cond.etag)
+		}
+	}
+	return writeWithContentType(fs, path, data, contentType)
+}
@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+func TestFileHandlers_ConditionalWrite(t *testing.T) {
+	fs := newMockFileSystem()
+	fs.files["/notes.txt"] = []byte("original")
+	mgr := storage.NewManager()
+	mgr.Register("mock", fs)
+	handler := NewFileHandlers(mgr)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/api/fs/download", handler.DownloadFile).Methods("GET")
+	router.HandleFunc("/api/fs/upload", handler.UploadFile).Methods("POST")
+
+	download := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/fs/download?storage=mock&path=/notes.txt", nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	save := func(content string, headers map[string]string) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		_ = mw.WriteField("storage", "mock")
+		_ = mw.WriteField("path", "/")
+		part, _ := mw.CreateFormFile("file", "notes.txt")
+		_, _ = part.Write([]byte(content))
+		_ = mw.Close()
+
+		req := httptest.NewRequest("POST", "/api/fs/upload", &body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Two users open the file
+	etag := download("").Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("Expected the download to carry an ETag")
+	}
+	if rr := download(etag); rr.Code != http.StatusNotModified {
+		t.Errorf("Expected 304 for a matching If-None-Match, got %d", rr.Code)
+	}
+
+	// The first save wins and returns the new ETag
+	rr := save("first edit", map[string]string{"If-Match": etag})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("First save: expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	newETag := rr.Header().Get("ETag")
+	if newETag == "" || newETag == etag {
+		t.Errorf("Expected a new ETag after saving, got %q", newETag)
+	}
+
+	// The second save still carries the ETag it read, and is refused
+	rr = save("second edit", map[string]string{"If-Match": etag})
+	if rr.Code != http.StatusPreconditionFailed {
+		t.Fatalf("Second save: expected 412, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+	if resp.Error.Code != CodePreconditionFailed {
+		t.Errorf("Expected code %s, got %q", CodePreconditionFailed, resp.Error.Code)
+	}
+	if got := string(fs.files["/notes.txt"]); got != "first edit" {
+		t.Errorf("Expected the first edit to survive, got %q", got)
+	}
+
+	// Saving with the current ETag works again
+	if rr := save("merged edit", map[string]string{"If-Match": newETag}); rr.Code != http.StatusOK {
+		t.Errorf("Save with current ETag: expected 200, got %d", rr.Code)
+	}
+
+	if rr := save("new", map[string]string{"If-None-Match": "*"}); rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("If-None-Match * on an existing file: expected 412, got %d", rr.Code)
+	}
+	delete(fs.files, "/notes.txt")
+	if rr := save("recreated", map[string]string{"If-Match": newETag}); rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("If-Match on a deleted file: expected 412, got %d", rr.Code)
+	}
+	if rr := save("recreated", map[string]string{"If-None-Match": "*"}); rr.Code != http.StatusOK {
+		t.Errorf("If-None-Match * on a missing file: expected 200, got %d", rr.Code)
+	}
+}
+
+func TestFileHandlers_DownloadCaching(t *testing.T) {
+	root := t.TempDir()
+	file := filepath.Join(root, "report.txt")
+	if err := os.WriteFile(file, []byte("quarterly numbers"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Date(2024, 1, 15, 10, 30, 0, 500, time.UTC)
+	if err := os.Chtimes(file, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	tagged := &etagFileSystem{countingFileSystem: countingFileSystem{mockFileSystem: newMockFileSystem()}}
+	tagged.files["/report.txt"] = []byte("quarterly numbers")
+
+	mgr := storage.NewManager()
+	mgr.Register("local", storage.NewLocalStorage(root))
+	mgr.Register("tagged", tagged)
+	handler := NewFileHandlers(mgr)
+
+	download := func(storageID string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/fs/download?storage="+storageID+"&path=/report.txt", nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rr := httptest.NewRecorder()
+		handler.DownloadFile(rr, req)
+		return rr
+	}
+
+	first := download("local", nil)
+	etag, lastModified := first.Header().Get("ETag"), first.Header().Get("Last-Modified")
+	if first.Code != http.StatusOK || etag == "" {
+		t.Fatalf("Expected 200 with an ETag, got %d %q", first.Code, etag)
+	}
+	if lastModified != "Mon, 15 Jan 2024 10:30:00 GMT" {
+		t.Errorf("Unexpected Last-Modified %q", lastModified)
+	}
+
+	for name, tc := range map[string]struct {
+		headers map[string]string
+		want    int
+	}{
+		"matching ETag":      {map[string]string{"If-None-Match": etag}, http.StatusNotModified},
+		"weak ETag":          {map[string]string{"If-None-Match": `"other", W/` + etag}, http.StatusNotModified},
+		"other ETag":         {map[string]string{"If-None-Match": `"other"`}, http.StatusOK},
+		"ETag before date":   {map[string]string{"If-None-Match": `"other"`, "If-Modified-Since": lastModified}, http.StatusOK},
+		"not modified since": {map[string]string{"If-Modified-Since": lastModified}, http.StatusNotModified},
+		"modified since":     {map[string]string{"If-Modified-Since": "Mon, 15 Jan 2024 10:29:59 GMT"}, http.StatusOK},
+		"unparseable date":   {map[string]string{"If-Modified-Since": "yesterday"}, http.StatusOK},
+		"no validators":      {nil, http.StatusOK},
+	} {
+		rr := download("local", tc.headers)
+		if rr.Code != tc.want {
+			t.Errorf("%s: expected %d, got %d", name, tc.want, rr.Code)
+		}
+		if rr.Code == http.StatusNotModified && rr.Body.Len() != 0 {
+			t.Errorf("%s: expected no body with 304, got %d bytes", name, rr.Body.Len())
+		}
+		if rr.Header().Get("ETag") != etag {
+			t.Errorf("%s: expected the ETag on every response", name)
+		}
+	}
+
+	// A changed file is downloaded again
+	later := modTime.Add(time.Hour)
+	if err := os.WriteFile(file, []byte("revised numbers!!"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(file, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if rr := download("local", map[string]string{"If-None-Match": etag}); rr.Code != http.StatusOK {
+		t.Errorf("Expected 200 once the file changed, got %d", rr.Code)
+	}
+	if rr := download("local", map[string]string{"If-Modified-Since": lastModified}); rr.Code != http.StatusOK {
+		t.Errorf("Expected 200 once the file changed, got %d", rr.Code)
+	}
+
+	// Backends that keep an ETag, like S3, have theirs used
+	rr := download("tagged", nil)
+	sum := md5.Sum([]byte("quarterly numbers"))
+	nativeETag := `"` + hex.EncodeToString(sum[:]) + `"`
+	if got := rr.Header().Get("ETag"); got != nativeETag {
+		t.Errorf("Expected the backend's ETag %s, got %s", nativeETag, got)
+	}
+	if rr := download("tagged", map[string]string{"If-None-Match": nativeETag}); rr.Code != http.StatusNotModified {
+		t.Errorf("Expected 304 for the backend's ETag, got %d", rr.Code)
+	}
+}
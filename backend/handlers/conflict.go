@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+// Policies for a copy or move whose destination exists
+const (
+	conflictOverwrite = "overwrite"
+	conflictSkip      = "skip"
+	conflictRename    = "rename"
+	conflictFail      = "fail"
+)
+
+// Actions reported for each file of a copy or move
+const (
+	actionCreated     = "created"
+	actionOverwritten = "overwritten"
+	actionRenamed     = "renamed"
+	actionSkipped     = "skipped"
+)
+
+// resolveConflictPolicy checks an on_conflict value, defaulting to
+// overwrite as copies and moves did before it existed
+func resolveConflictPolicy(policy string) (string, error) {
+	switch policy {
+	case "":
+		return conflictOverwrite, nil
+	case conflictOverwrite, conflictSkip, conflictRename, conflictFail:
+		return policy, nil
+	}
+	return "", fmt.Errorf("invalid on_conflict %q: use overwrite, skip, rename or fail", policy)
+}
+
+// conflictReport is what a copy or move did with one file
+type conflictReport struct {
+	File        string `json:"file"`
+	Action      string `json:"action"`
+	Destination string `json:"destination,omitempty"`
+}
+
+// countCopied returns how many of reports weren't skipped
+func countCopied(reports []conflictReport) int {
+	n := 0
+	for _, report := range reports {
+		if report.Action != actionSkipped {
+			n++
+		}
+	}
+	return n
+}
+
+// batchDestinations keeps the paths the files of one copy or move are
+// written to, so that files handled at the same time never pick the same
+// name. Each file's own name in the destination directory is claimed up
+// front, so a renamed file never takes the name another file of the batch
+// arrives under. A nil *batchDestinations claims nothing.
+type batchDestinations struct {
+	mu     sync.Mutex
+	owners map[string]int
+}
+
+// newBatchDestinations claims dstDir/file for each of files
+func newBatchDestinations(dstDir string, files []string) *batchDestinations {
+	d := &batchDestinations{owners: make(map[string]int, len(files))}
+	for i, file := range files {
+		key := path.Clean(path.Join(dstDir, file))
+		if _, ok := d.owners[key]; !ok {
+			d.owners[key] = i
+		}
+	}
+	return d
+}
+
+// claim records p as the destination of file i. A path another file of
+// the batch already goes to is refused.
+func (d *batchDestinations) claim(p string, i int) error {
+	if d == nil {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	key := path.Clean(p)
+	if owner, ok := d.owners[key]; ok && owner != i {
+		return fmt.Errorf("%s is the destination of another file too: %w", p, os.ErrExist)
+	}
+	d.owners[key] = i
+	return nil
+}
+
+// rename finds a free name for p, as freePath does, that no other file of
+// the batch goes to, and claims it for file i
+func (d *batchDestinations) rename(fs storage.FileSystem, p string, i int) (string, error) {
+	if d == nil {
+		return freePath(fs, p, nil)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	free, err := freePath(fs, p, func(candidate string) bool {
+		_, ok := d.owners[path.Clean(candidate)]
+		return ok
+	})
+	if err != nil {
+		return "", err
+	}
+	d.owners[path.Clean(free)] = i
+	return free, nil
+}
+
+// resolveConflict checks whether dstPath exists on dstFS and applies
+// policy. It returns the path to write to, empty when the file is skipped,
+// and the action taken. Backends differ in what writing over an entry
+// does: some replace it, some keep both under one name. So an overwritten
+// file is deleted here first, and a file is never put in place of a
+// directory or the other way around. The destination is claimed in dests
+// for file i of the batch.
+func resolveConflict(srcFS storage.FileSystem, srcPath string, dstFS storage.FileSystem, dstPath, policy string, dests *batchDestinations, i int) (string, string, error) {
+	dst, action, replace, err := planConflict(srcFS, srcPath, dstFS, dstPath, policy, dests, i)
+	if err != nil || !replace {
+		return dst, action, err
+	}
+	if err := dstFS.Delete(dst); err != nil {
+		return "", "", fmt.Errorf("failed to remove %s to overwrite it: %w", dst, err)
+	}
+	return dst, action, nil
+}
+
+// planConflict is resolveConflict without changing anything: replace
+// reports that the file at the returned path must be deleted before
+// writing over it.
+func planConflict(srcFS storage.FileSystem, srcPath string, dstFS storage.FileSystem, dstPath, policy string, dests *batchDestinations, i int) (dst, action string, replace bool, err error) {
+	existing, err := dstFS.Stat(dstPath)
+	if err != nil {
+		if err := dests.claim(dstPath, i); err != nil {
+			return "", "", false, err
+		}
+		return dstPath, actionCreated, false, nil
+	}
+
+	switch policy {
+	case conflictSkip:
+		return "", actionSkipped, false, nil
+	case conflictRename:
+		free, err := dests.rename(dstFS, dstPath, i)
+		if err != nil {
+			return "", "", false, err
+		}
+		return free, actionRenamed, false, nil
+	case conflictFail:
+		return "", "", false, fmt.Errorf("%s already exists: %w", dstPath, os.ErrExist)
+	}
+
+	if srcFS == dstFS && path.Clean(srcPath) == path.Clean(dstPath) {
+		return "", "", false, fmt.Errorf("%s can't be overwritten with itself: %w", dstPath, os.ErrExist)
+	}
+	src, err := srcFS.Stat(srcPath)
+	if err != nil {
+		return "", "", false, err
+	}
+	if err := checkReplaceable(src.IsDir, existing.IsDir, dstPath); err != nil {
+		return "", "", false, err
+	}
+	if err := dests.claim(dstPath, i); err != nil {
+		return "", "", false, err
+	}
+	// Directories are merged, keeping what the destination already holds
+	return dstPath, actionOverwritten, !existing.IsDir, nil
+}
+
+// checkReplaceable refuses to put a file in place of a directory or the
+// other way around
+func checkReplaceable(srcIsDir, dstIsDir bool, dstPath string) error {
+	switch {
+	case srcIsDir && !dstIsDir:
+		return fmt.Errorf("%s is a file and can't be overwritten with a directory: %w", dstPath, os.ErrExist)
+	case !srcIsDir && dstIsDir:
+		return fmt.Errorf("%s is a directory and can't be overwritten with a file: %w", dstPath, os.ErrExist)
+	}
+	return nil
+}
+
+// freePath finds the first "name (n).ext" next to p that doesn't exist,
+// keeping compound suffixes like .tar.gz together. Names taken reports as
+// taken are passed over too.
+func freePath(fs storage.FileSystem, p string, taken func(string) bool) (string, error) {
+	dir, base := path.Split(p)
+	ext := path.Ext(base)
+	if _, suffix, ok := tarCodecForArchive(base); ok {
+		ext = suffix
+	}
+	stem := strings.TrimSuffix(base, ext)
+
+	for n := 1; n <= 1000; n++ {
+		candidate := fmt.Sprintf("%s%s (%d)%s", dir, stem, n, ext)
+		if taken != nil && taken(candidate) {
+			continue
+		}
+		if _, err := fs.Stat(candidate); err != nil {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("no free name found for %s", p)
+}
@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+func TestFileHandlers_CopyOnConflict(t *testing.T) {
+	setup := func(t *testing.T) (*FileHandlers, string) {
+		root := t.TempDir()
+		for name, content := range map[string]string{
+			"src/report.pdf":     "new",
+			"src/photos/cat.jpg": "cat",
+			"dst/report.pdf":     "old",
+			"dst/photos/dog.jpg": "dog",
+		} {
+			if err := os.MkdirAll(filepath.Dir(filepath.Join(root, name)), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		mgr := storage.NewManager()
+		mgr.Register("local", storage.NewLocalStorage(root))
+		return NewFileHandlers(mgr), root
+	}
+	run := func(h *FileHandlers, handler string, policy string, files ...string) (*httptest.ResponseRecorder, []conflictReport) {
+		body, _ := json.Marshal(map[string]interface{}{
+			"src_storage": "local", "dst_storage": "local", "src_path": "/src", "dst_path": "/dst",
+			"files": files, "on_conflict": policy,
+		})
+		req := httptest.NewRequest("POST", "/", strings.NewReader(string(body)))
+		rr := httptest.NewRecorder()
+		if handler == "move" {
+			h.MoveFiles(rr, req)
+		} else {
+			h.CopyFiles(rr, req)
+		}
+		var resp struct {
+			Data struct {
+				Results []conflictReport `json:"results"`
+			} `json:"data"`
+		}
+		_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+		return rr, resp.Data.Results
+	}
+	read := func(root, name string) string {
+		data, err := os.ReadFile(filepath.Join(root, name))
+		if err != nil {
+			return ""
+		}
+		return string(data)
+	}
+
+	t.Run("Overwrite", func(t *testing.T) {
+		h, root := setup(t)
+		_, results := run(h, "copy", "", "report.pdf", "photos")
+		if len(results) != 2 || results[0].Action != actionOverwritten || results[1].Action != actionOverwritten {
+			t.Fatalf("Expected both to be overwritten, got %+v", results)
+		}
+		if read(root, "dst/report.pdf") != "new" {
+			t.Error("Expected the file to be replaced")
+		}
+		if read(root, "dst/photos/dog.jpg") != "dog" || read(root, "dst/photos/cat.jpg") != "cat" {
+			t.Error("Expected the directories to be merged")
+		}
+	})
+
+	t.Run("Skip", func(t *testing.T) {
+		h, root := setup(t)
+		rr, results := run(h, "move", "skip", "report.pdf")
+		if rr.Code != http.StatusOK || len(results) != 1 || results[0].Action != actionSkipped {
+			t.Fatalf("Expected the file to be skipped, got %d %+v", rr.Code, results)
+		}
+		if read(root, "dst/report.pdf") != "old" || read(root, "src/report.pdf") != "new" {
+			t.Error("Expected both files to be left alone")
+		}
+	})
+
+	t.Run("Rename", func(t *testing.T) {
+		h, root := setup(t)
+		_, results := run(h, "copy", "rename", "report.pdf")
+		if len(results) != 1 || results[0].Action != actionRenamed || results[0].Destination != "/dst/report (1).pdf" {
+			t.Fatalf("Expected a renamed copy, got %+v", results)
+		}
+		_, results = run(h, "move", "rename", "report.pdf")
+		if len(results) != 1 || results[0].Destination != "/dst/report (2).pdf" {
+			t.Fatalf("Expected the next free name, got %+v", results)
+		}
+		if read(root, "dst/report.pdf") != "old" || read(root, "dst/report (2).pdf") != "new" {
+			t.Error("Expected the existing file kept and the new one renamed")
+		}
+	})
+
+	t.Run("Rename within a batch", func(t *testing.T) {
+		for _, handler := range []string{"copy", "move"} {
+			h, root := setup(t)
+			if err := os.WriteFile(filepath.Join(root, "src", "report (1).pdf"), []byte("one"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			rr, results := run(h, handler, "rename", "report.pdf", "report (1).pdf")
+			if rr.Code != http.StatusOK || len(results) != 2 {
+				t.Fatalf("%s: expected both files, got %d %s", handler, rr.Code, rr.Body.String())
+			}
+			if results[0].Destination != "/dst/report (2).pdf" || results[1].Action != actionCreated {
+				t.Errorf("%s: expected the renamed file to pass over the other's name, got %+v", handler, results)
+			}
+			if read(root, "dst/report.pdf") != "old" || read(root, "dst/report (1).pdf") != "one" || read(root, "dst/report (2).pdf") != "new" {
+				t.Errorf("%s: expected all three files in the destination", handler)
+			}
+		}
+	})
+
+	t.Run("Fail", func(t *testing.T) {
+		h, root := setup(t)
+		rr, _ := run(h, "copy", "fail", "report.pdf")
+		if rr.Code != http.StatusConflict {
+			t.Errorf("Expected 409, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if read(root, "dst/report.pdf") != "old" {
+			t.Error("Expected the existing file to be left alone")
+		}
+	})
+
+	t.Run("No conflict", func(t *testing.T) {
+		h, _ := setup(t)
+		if _, results := run(h, "copy", "fail", "photos/cat.jpg"); len(results) != 1 || results[0].Action != actionCreated {
+			t.Errorf("Expected a new file, got %+v", results)
+		}
+	})
+
+	t.Run("Invalid policy", func(t *testing.T) {
+		h, _ := setup(t)
+		if rr, _ := run(h, "copy", "merge", "report.pdf"); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400, got %d", rr.Code)
+		}
+	})
+}
+
+// replacingCopyFS copies like a WebDAV server: an overwriting copy replaces
+// a directory whole, and one told not to overwrite fails with os.ErrExist.
+// racer, when set, is written to the destination just before the next
+// copy, as if by another client.
+type replacingCopyFS struct {
+	storage.FileSystem
+	racer string
+}
+
+func (r *replacingCopyFS) Copy(src, dst string, progress storage.ProgressCallback) error {
+	return r.CopyOverwrite(src, dst, true, progress)
+}
+
+func (r *replacingCopyFS) CopyOverwrite(src, dst string, overwrite bool, progress storage.ProgressCallback) error {
+	if r.racer != "" {
+		if err := r.FileSystem.Write(dst, strings.NewReader(r.racer)); err != nil {
+			return err
+		}
+		r.racer = ""
+	}
+	if _, err := r.Stat(dst); err == nil {
+		if !overwrite {
+			return os.ErrExist
+		}
+		if _, err := storage.DeleteTree(r.FileSystem, dst, nil); err != nil {
+			return err
+		}
+	}
+	return r.FileSystem.Copy(src, dst, progress)
+}
+
+func TestFileHandlers_CopyNoOverwrite(t *testing.T) {
+	setup := func(t *testing.T) (*FileHandlers, *replacingCopyFS, string) {
+		root := t.TempDir()
+		for name, content := range map[string]string{
+			"src/report.pdf":     "new",
+			"src/photos/cat.jpg": "cat",
+			"dst/photos/dog.jpg": "dog",
+		} {
+			if err := os.MkdirAll(filepath.Dir(filepath.Join(root, name)), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		fs := &replacingCopyFS{FileSystem: storage.NewLocalStorage(root)}
+		mgr := storage.NewManager()
+		mgr.Register("dav", fs)
+		return NewFileHandlers(mgr), fs, root
+	}
+	copyFiles := func(h *FileHandlers, policy string, files ...string) []conflictReport {
+		body, _ := json.Marshal(map[string]interface{}{
+			"src_storage": "dav", "dst_storage": "dav", "src_path": "/src", "dst_path": "/dst",
+			"files": files, "on_conflict": policy,
+		})
+		rr := httptest.NewRecorder()
+		h.CopyFiles(rr, httptest.NewRequest("POST", "/", strings.NewReader(string(body))))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var resp struct {
+			Data struct {
+				Results []conflictReport `json:"results"`
+			} `json:"data"`
+		}
+		_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+		return resp.Data.Results
+	}
+	read := func(root, name string) string {
+		data, _ := os.ReadFile(filepath.Join(root, name))
+		return string(data)
+	}
+
+	t.Run("Directories are merged", func(t *testing.T) {
+		h, _, root := setup(t)
+		copyFiles(h, "overwrite", "photos")
+		if read(root, "dst/photos/dog.jpg") != "dog" || read(root, "dst/photos/cat.jpg") != "cat" {
+			t.Error("Expected the copy to merge into the directory rather than replace it")
+		}
+	})
+
+	t.Run("Skip a file that appeared", func(t *testing.T) {
+		h, fs, root := setup(t)
+		fs.racer = "theirs"
+		results := copyFiles(h, "skip", "report.pdf")
+		if len(results) != 1 || results[0].Action != actionSkipped {
+			t.Errorf("Expected the file to be skipped, got %+v", results)
+		}
+		if read(root, "dst/report.pdf") != "theirs" {
+			t.Error("Expected the other client's file to be kept")
+		}
+	})
+
+	t.Run("Rename past a file that appeared", func(t *testing.T) {
+		h, fs, root := setup(t)
+		fs.racer = "theirs"
+		results := copyFiles(h, "rename", "report.pdf")
+		if len(results) != 1 || results[0].Action != actionRenamed || results[0].Destination != "/dst/report (1).pdf" {
+			t.Errorf("Expected a renamed copy, got %+v", results)
+		}
+		if read(root, "dst/report.pdf") != "theirs" || read(root, "dst/report (1).pdf") != "new" {
+			t.Error("Expected both files to be kept")
+		}
+	})
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+// sniffLen is how much of a file is read to classify it, the same amount
+// http.DetectContentType looks at
+const sniffLen = 512
+
+// sniffCacheEntries is how many classified files are remembered
+const sniffCacheEntries = 4096
+
+// sniffResult is what sniffing a file's first bytes tells about it
+type sniffResult struct {
+	mimeType string
+	isText   bool
+}
+
+// sniffCache remembers sniffed files by path, modification time and size,
+// so unchanged files aren't read again
+type sniffCache struct {
+	mu      sync.Mutex
+	results map[string]sniffResult
+	order   []string // keys, oldest first
+}
+
+func newSniffCache() *sniffCache {
+	return &sniffCache{results: make(map[string]sniffResult)}
+}
+
+// detect sniffs the file at path, or returns the cached result when it
+// hasn't changed
+func (c *sniffCache) detect(fs storage.FileSystem, storageID, path string, info storage.FileInfo) (sniffResult, error) {
+	key := fmt.Sprintf("%s:%s:%d:%d", storageID, path, info.ModTime.UnixNano(), info.Size)
+	c.mu.Lock()
+	result, ok := c.results[key]
+	c.mu.Unlock()
+	if ok {
+		return result, nil
+	}
+
+	reader, err := fs.Read(path)
+	if err != nil {
+		return sniffResult{}, err
+	}
+	sample := make([]byte, sniffLen)
+	n, err := io.ReadFull(reader, sample)
+	reader.Close()
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return sniffResult{}, err
+	}
+	result = classifyContent(sample[:n], n == sniffLen)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.results[key]; !ok {
+		for len(c.order) >= sniffCacheEntries {
+			delete(c.results, c.order[0])
+			c.order = c.order[1:]
+		}
+		c.results[key] = result
+		c.order = append(c.order, key)
+	}
+	return result, nil
+}
+
+// classifyContent guesses the MIME type of a file from its first bytes
+// and whether it is text. Text may be UTF-8 or a single-byte encoding such
+// as Latin-1; a NUL byte or a high share of control characters means
+// binary. truncated says the sample stops short of the end of the file.
+func classifyContent(sample []byte, truncated bool) sniffResult {
+	mimeType := http.DetectContentType(sample)
+	if len(sample) == 0 {
+		return sniffResult{mimeType: "text/plain; charset=utf-8", isText: true}
+	}
+	if bytes.IndexByte(sample, 0) >= 0 {
+		return sniffResult{mimeType: mimeType, isText: false}
+	}
+
+	// A multi-byte character may have been cut off at the end of the sample
+	if truncated {
+		for i := len(sample) - 1; i >= 0 && i >= len(sample)-utf8.UTFMax; i-- {
+			if utf8.RuneStart(sample[i]) {
+				if !utf8.FullRune(sample[i:]) {
+					sample = sample[:i]
+				}
+				break
+			}
+		}
+	}
+	if utf8.Valid(sample) {
+		return sniffResult{mimeType: mimeType, isText: isTextMIME(mimeType)}
+	}
+
+	control := 0
+	for _, b := range sample {
+		if (b < 0x20 && b != '\t' && b != '\n' && b != '\r' && b != '\f' && b != 0x1b) || b == 0x7f {
+			control++
+		}
+	}
+	if control*10 > len(sample) || !isTextMIME(mimeType) {
+		return sniffResult{mimeType: mimeType, isText: false}
+	}
+	// DetectContentType assumes UTF-8 for anything textual
+	return sniffResult{mimeType: "text/plain; charset=iso-8859-1", isText: true}
+}
+
+// isTextMIME reports whether a sniffed type is textual. DetectContentType
+// only returns text/* and a few structured types for text content.
+func isTextMIME(mimeType string) bool {
+	return strings.HasPrefix(mimeType, "text/") ||
+		mimeType == "application/json" || mimeType == "application/xml"
+}
+
+// withSniffedContent fills in IsText and, when the backend reports none or
+// only the generic octet-stream, MimeType from the first bytes of the file
+// at path. Directories and
+// files that can't be read are returned unchanged.
+func (h *FileHandlers) withSniffedContent(fs storage.FileSystem, storageID, path string, info storage.FileInfo) storage.FileInfo {
+	if info.IsDir {
+		return info
+	}
+	result, err := h.sniffed.detect(fs, storageID, path, info)
+	if err != nil {
+		return info
+	}
+	isText := result.isText
+	info.IsText = &isText
+	if info.MimeType == "" || info.MimeType == "application/octet-stream" {
+		info.MimeType = result.mimeType
+	}
+	return info
+}
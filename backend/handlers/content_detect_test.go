@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+func TestClassifyContent(t *testing.T) {
+	utf8Text := strings.Repeat("Grüße aus Köln — 東京 ", 40)
+	tests := []struct {
+		name      string
+		sample    []byte
+		truncated bool
+		isText    bool
+		mimeType  string
+	}{
+		{name: "utf-8", sample: []byte(utf8Text), isText: true, mimeType: "text/plain; charset=utf-8"},
+		// Cut mid-character, as a 512-byte sample often is
+		{name: "utf-8 truncated", sample: []byte(utf8Text)[:sniffLen-1], truncated: true, isText: true, mimeType: "text/plain; charset=utf-8"},
+		{name: "latin-1", sample: []byte("Caf\xe9 cr\xe8me, na\xefve r\xe9sum\xe9\n"), isText: true, mimeType: "text/plain; charset=iso-8859-1"},
+		{name: "binary", sample: []byte{0x7f, 'E', 'L', 'F', 2, 1, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 3, 0}, isText: false, mimeType: "application/octet-stream"},
+		{name: "png", sample: []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), isText: false, mimeType: "image/png"},
+		{name: "control bytes", sample: []byte("\x01\x02\x03\x04\x05\xff\xfe\x06\x07\x08"), isText: false},
+		{name: "empty", sample: nil, isText: true, mimeType: "text/plain; charset=utf-8"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := classifyContent(tt.sample, tt.truncated)
+			if got.isText != tt.isText {
+				t.Errorf("Expected isText %v, got %v", tt.isText, got.isText)
+			}
+			if tt.mimeType != "" && got.mimeType != tt.mimeType {
+				t.Errorf("Expected %s, got %s", tt.mimeType, got.mimeType)
+			}
+		})
+	}
+}
+
+func TestFileHandlers_DetectMIME(t *testing.T) {
+	root := t.TempDir()
+	for name, content := range map[string]string{
+		"notes":    "plain text without an extension\n",
+		"blob.bin": "\x00\x01\x02binary",
+	} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(root, "dir"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+
+	mgr := storage.NewManager()
+	mgr.Register("local", storage.NewLocalStorage(root))
+	h := NewFileHandlers(mgr)
+
+	t.Run("Listing", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		h.ListDirectory(rr, httptest.NewRequest("GET", "/api/fs/list?storage=local&path=/&detect_mime=true", nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var resp struct {
+			Data struct {
+				Files []storage.FileInfo `json:"files"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Failed to decode listing: %v", err)
+		}
+		for _, f := range resp.Data.Files {
+			switch f.Name {
+			case "notes":
+				if f.IsText == nil || !*f.IsText || !strings.HasPrefix(f.MimeType, "text/plain") {
+					t.Errorf("Expected notes to be text/plain, got %+v", f)
+				}
+			case "blob.bin":
+				if f.IsText == nil || *f.IsText {
+					t.Errorf("Expected blob.bin to be binary, got %+v", f)
+				}
+			case "dir":
+				if f.IsText != nil {
+					t.Errorf("Expected no is_text for a directory, got %v", *f.IsText)
+				}
+			}
+		}
+	})
+
+	t.Run("Stat", func(t *testing.T) {
+		stat := func(query string) map[string]interface{} {
+			rr := httptest.NewRecorder()
+			h.StatFile(rr, httptest.NewRequest("GET", "/api/fs/stat?"+query, nil))
+			var resp struct {
+				Data struct {
+					Info map[string]interface{} `json:"info"`
+				} `json:"data"`
+			}
+			json.Unmarshal(rr.Body.Bytes(), &resp)
+			return resp.Data.Info
+		}
+		if info := stat("storage=local&path=/notes&detect_mime=true"); info["is_text"] != true {
+			t.Errorf("Expected is_text true, got %v", info)
+		}
+		if info := stat("storage=local&path=/notes"); info["is_text"] != nil {
+			t.Errorf("Expected no is_text without detect_mime, got %v", info["is_text"])
+		}
+	})
+}
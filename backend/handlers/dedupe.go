@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+// md5ETagPattern matches ETags that are a plain MD5 of the content. ETags
+// of multipart uploads carry a "-<parts>" suffix and can't be compared.
+var md5ETagPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// md5ETag returns the file's ETag if it is a content MD5
+func md5ETag(fs storage.FileSystem, filePath string) (string, bool) {
+	tagger, ok := storage.As[storage.ETagger](fs)
+	if !ok {
+		return "", false
+	}
+	etag, err := tagger.ETag(filePath)
+	if err != nil {
+		return "", false
+	}
+	etag = strings.ToLower(etag)
+	return etag, md5ETagPattern.MatchString(etag)
+}
+
+// hashReader returns the hex digest of everything read from r
+func hashReader(h hash.Hash, r io.Reader) (string, error) {
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// uploadIsDuplicate reports whether dstPath already holds exactly the
+// content of src. Sizes are compared first; then the stored MD5 ETag when
+// the backend has one, or SHA-256 otherwise. clientSHA256, when given,
+// saves hashing src. src is rewound before returning.
+func uploadIsDuplicate(fs storage.FileSystem, dstPath string, src io.ReadSeeker, size int64, clientSHA256 string) (bool, error) {
+	info, err := fs.Stat(dstPath)
+	if err != nil || info.IsDir || info.Size != size {
+		return false, nil
+	}
+	defer func() {
+		if _, err := src.Seek(0, io.SeekStart); err != nil {
+			log.Printf("Error rewinding upload: %v", err)
+		}
+	}()
+
+	if etag, ok := md5ETag(fs, dstPath); ok {
+		sum, err := hashReader(md5.New(), src)
+		if err != nil {
+			return false, err
+		}
+		return sum == etag, nil
+	}
+
+	expected := strings.ToLower(clientSHA256)
+	if expected == "" {
+		if expected, err = hashReader(sha256.New(), src); err != nil {
+			return false, err
+		}
+	}
+
+	actual, err := fileChecksum(fs, dstPath)
+	if err != nil {
+		return false, err
+	}
+	return actual == expected, nil
+}
+
+// filesIdentical reports whether two files, possibly on different
+// storages, have the same content
+func filesIdentical(srcFS storage.FileSystem, srcPath string, dstFS storage.FileSystem, dstPath string) (bool, error) {
+	dstInfo, err := dstFS.Stat(dstPath)
+	if err != nil {
+		return false, nil
+	}
+	srcInfo, err := srcFS.Stat(srcPath)
+	if err != nil {
+		return false, err
+	}
+	if srcInfo.IsDir || dstInfo.IsDir || srcInfo.Size != dstInfo.Size {
+		return false, nil
+	}
+
+	if srcTag, ok := md5ETag(srcFS, srcPath); ok {
+		if dstTag, ok := md5ETag(dstFS, dstPath); ok {
+			return srcTag == dstTag, nil
+		}
+	}
+
+	reason, err := compareFiles(srcFS, dstFS, srcPath, dstPath, srcInfo, dstInfo, CompareByChecksum)
+	if err != nil {
+		return false, err
+	}
+	return reason == "", nil
+}
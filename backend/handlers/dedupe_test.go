@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+// countingFileSystem counts writes to tell whether an upload was skipped
+type countingFileSystem struct {
+	*mockFileSystem
+	writes int
+}
+
+func (c *countingFileSystem) Write(path string, data io.Reader) error {
+	c.writes++
+	return c.mockFileSystem.Write(path, data)
+}
+
+// etagFileSystem reports content MD5s as ETags, like single-part S3 objects
+type etagFileSystem struct {
+	countingFileSystem
+	etagCalls int
+}
+
+func (e *etagFileSystem) ETag(path string) (string, error) {
+	e.etagCalls++
+	sum := md5.Sum(e.files[path])
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func TestFileHandlers_UploadDedupe(t *testing.T) {
+	plain := &countingFileSystem{mockFileSystem: newMockFileSystem()}
+	tagged := &etagFileSystem{countingFileSystem: countingFileSystem{mockFileSystem: newMockFileSystem()}}
+	mgr := storage.NewManager()
+	mgr.Register("plain", plain)
+	mgr.Register("tagged", tagged)
+	handler := NewFileHandlers(mgr)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/api/fs/upload", handler.UploadFile).Methods("POST")
+	router.HandleFunc("/api/fs/copy", handler.CopyFiles).Methods("POST")
+
+	upload := func(storageID, content string, dedupe bool) bool {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		_ = mw.WriteField("storage", storageID)
+		_ = mw.WriteField("path", "/")
+		if dedupe {
+			_ = mw.WriteField("dedupe", "true")
+		}
+		part, _ := mw.CreateFormFile("file", "logo.png")
+		_, _ = part.Write([]byte(content))
+		_ = mw.Close()
+
+		req := httptest.NewRequest("POST", "/api/fs/upload", &body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Upload failed: %d %s", rr.Code, rr.Body.String())
+		}
+
+		var resp struct {
+			Data struct {
+				Deduplicated bool `json:"deduplicated"`
+			} `json:"data"`
+		}
+		_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+		return resp.Data.Deduplicated
+	}
+
+	for _, tc := range []struct {
+		name string
+		fs   *countingFileSystem
+	}{
+		{"plain", plain},
+		{"tagged", &tagged.countingFileSystem},
+	} {
+		t.Run("Identical upload skipped/"+tc.name, func(t *testing.T) {
+			if upload(tc.name, "PNG data", true) {
+				t.Error("First upload should not be deduplicated")
+			}
+			if !upload(tc.name, "PNG data", true) {
+				t.Error("Second identical upload should be deduplicated")
+			}
+			if tc.fs.writes != 1 {
+				t.Errorf("Expected 1 write, got %d", tc.fs.writes)
+			}
+
+			// Same size, different content is written
+			if upload(tc.name, "PNG dat4", true) {
+				t.Error("Changed content should not be deduplicated")
+			}
+			if tc.fs.writes != 2 || string(tc.fs.files["/logo.png"]) != "PNG dat4" {
+				t.Errorf("Expected changed content to be written, writes=%d", tc.fs.writes)
+			}
+		})
+	}
+
+	if tagged.etagCalls == 0 {
+		t.Error("Expected ETags to be used for comparison")
+	}
+
+	t.Run("Without dedupe", func(t *testing.T) {
+		before := plain.writes
+		upload("plain", "PNG dat4", false)
+		if plain.writes != before+1 {
+			t.Error("Expected upload without dedupe to be written")
+		}
+	})
+
+	t.Run("Copy", func(t *testing.T) {
+		plain.files["/a.txt"] = []byte("same")
+		plain.files["/b.txt"] = []byte("new")
+		tagged.files["/a.txt"] = []byte("same")
+
+		body := `{"src_storage": "plain", "dst_storage": "tagged", "src_path": "/", "dst_path": "/", "files": ["a.txt", "b.txt"], "dedupe": true}`
+		req := httptest.NewRequest("POST", "/api/fs/copy", strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		var resp struct {
+			Data struct {
+				Count        int      `json:"count"`
+				Deduplicated []string `json:"deduplicated"`
+			} `json:"data"`
+		}
+		_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+		if resp.Data.Count != 1 || len(resp.Data.Deduplicated) != 1 || resp.Data.Deduplicated[0] != "a.txt" {
+			t.Errorf("Unexpected copy result: %s", rr.Body.String())
+		}
+		if string(tagged.files["/b.txt"]) != "new" {
+			t.Error("Expected b.txt to be copied")
+		}
+	})
+}
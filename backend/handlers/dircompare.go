@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+// Comparison modes for directory compare
+const (
+	CompareBySize     = "size"
+	CompareByModTime  = "modtime"
+	CompareByChecksum = "checksum"
+)
+
+// modTimeTolerance absorbs timestamp precision differences between
+// backends, such as FAT's two-second resolution
+const modTimeTolerance = 2 * time.Second
+
+// compareSide identifies one of the trees being compared
+type compareSide struct {
+	Storage string `json:"storage"`
+	Path    string `json:"path"`
+}
+
+// compareEntry describes a file that exists on one side only or on both
+type compareEntry struct {
+	Path  string            `json:"path"`
+	IsDir bool              `json:"is_dir"`
+	Left  *storage.FileInfo `json:"left,omitempty"`
+	Right *storage.FileInfo `json:"right,omitempty"`
+	Diff  string            `json:"reason,omitempty"`
+}
+
+// walkTree lists everything below root, keyed by path relative to root.
+// Symlinks are recorded but not descended into; excluded entries are left
+// out entirely.
+func walkTree(fs storage.FileSystem, root string, exclude *storage.ExcludeFilter) (map[string]storage.FileInfo, error) {
+	entries := make(map[string]storage.FileInfo)
+
+	err := storage.Walk(fs, root, func(file storage.FileInfo) error {
+		if exclude.Excluded(file.Name) {
+			return storage.SkipDir
+		}
+		relPath, err := filepath.Rel(root, file.Path)
+		if err != nil {
+			return err
+		}
+		entries[filepath.ToSlash(relPath)] = file
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// fileChecksum returns the hex SHA-256 of a file's content
+func fileChecksum(fs storage.FileSystem, filePath string) (string, error) {
+	reader, err := fs.Read(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err := reader.Close(); err != nil {
+			log.Printf("Error closing file: %v", err)
+		}
+	}()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, reader); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// compareFiles returns why two entries differ, or "" if they're considered
+// identical under the given mode
+func compareFiles(leftFS, rightFS storage.FileSystem, leftPath, rightPath string, left, right storage.FileInfo, compareBy string) (string, error) {
+	if left.IsDir != right.IsDir {
+		return "type", nil
+	}
+	if left.IsDir {
+		return "", nil
+	}
+	if left.Size != right.Size {
+		return "size", nil
+	}
+
+	switch compareBy {
+	case CompareByModTime:
+		delta := left.ModTime.Sub(right.ModTime)
+		if delta > modTimeTolerance || delta < -modTimeTolerance {
+			return "modtime", nil
+		}
+	case CompareByChecksum:
+		var leftSum, rightSum string
+		var leftErr, rightErr error
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			leftSum, leftErr = fileChecksum(leftFS, leftPath)
+		}()
+		rightSum, rightErr = fileChecksum(rightFS, rightPath)
+		wg.Wait()
+
+		if leftErr != nil {
+			return "", leftErr
+		}
+		if rightErr != nil {
+			return "", rightErr
+		}
+		if leftSum != rightSum {
+			return "checksum", nil
+		}
+	}
+	return "", nil
+}
+
+// CompareDirectories compares two directory trees, possibly on different
+// storages, and reports which files exist on one side only, which differ
+// and which are identical
+func (h *FileHandlers) CompareDirectories(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Left      compareSide `json:"left"`
+		Right     compareSide `json:"right"`
+		CompareBy string      `json:"compare_by"`
+		Exclude   *[]string   `json:"exclude"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	exclude, err := resolveExcludes(req.Exclude)
+	if err != nil {
+		errorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.CompareBy == "" {
+		req.CompareBy = CompareByModTime
+	}
+	switch req.CompareBy {
+	case CompareBySize, CompareByModTime, CompareByChecksum:
+	default:
+		errorResponse(w, fmt.Sprintf("Unsupported compare_by: %s", req.CompareBy), http.StatusBadRequest)
+		return
+	}
+
+	leftFS, ok := h.storageManager.Get(req.Left.Storage)
+	if !ok {
+		errorResponse(w, "Left storage not found", http.StatusNotFound)
+		return
+	}
+	rightFS, ok := h.storageManager.Get(req.Right.Storage)
+	if !ok {
+		errorResponse(w, "Right storage not found", http.StatusNotFound)
+		return
+	}
+
+	// List both trees at the same time; they're often on different backends
+	var leftTree, rightTree map[string]storage.FileInfo
+	var leftErr, rightErr error
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		leftTree, leftErr = walkTree(leftFS, req.Left.Path, exclude)
+	}()
+	rightTree, rightErr = walkTree(rightFS, req.Right.Path, exclude)
+	wg.Wait()
+
+	if leftErr != nil {
+		errorResponse(w, fmt.Sprintf("Failed to list left directory: %v", leftErr), http.StatusInternalServerError)
+		return
+	}
+	if rightErr != nil {
+		errorResponse(w, fmt.Sprintf("Failed to list right directory: %v", rightErr), http.StatusInternalServerError)
+		return
+	}
+
+	leftOnly := []compareEntry{}
+	rightOnly := []compareEntry{}
+	different := []compareEntry{}
+	identical := []compareEntry{}
+
+	for rel, left := range leftTree {
+		right, ok := rightTree[rel]
+		if !ok {
+			leftOnly = append(leftOnly, compareEntry{Path: rel, IsDir: left.IsDir, Left: &left})
+			continue
+		}
+
+		reason, err := compareFiles(leftFS, rightFS,
+			path.Join(req.Left.Path, rel), path.Join(req.Right.Path, rel),
+			left, right, req.CompareBy)
+		if err != nil {
+			storageErrorResponse(w, fmt.Sprintf("Failed to compare %s: %v", rel, err), err)
+			return
+		}
+
+		entry := compareEntry{Path: rel, IsDir: left.IsDir && right.IsDir, Left: &left, Right: &right, Diff: reason}
+		if reason != "" {
+			different = append(different, entry)
+		} else {
+			identical = append(identical, entry)
+		}
+	}
+	for rel, right := range rightTree {
+		if _, ok := leftTree[rel]; !ok {
+			rightOnly = append(rightOnly, compareEntry{Path: rel, IsDir: right.IsDir, Right: &right})
+		}
+	}
+
+	for _, list := range [][]compareEntry{leftOnly, rightOnly, different, identical} {
+		sort.Slice(list, func(i, j int) bool { return list[i].Path < list[j].Path })
+	}
+
+	successResponse(w, map[string]interface{}{
+		"compare_by": req.CompareBy,
+		"left_only":  leftOnly,
+		"right_only": rightOnly,
+		"different":  different,
+		"identical":  identical,
+		"summary": map[string]int{
+			"left_only":  len(leftOnly),
+			"right_only": len(rightOnly),
+			"different":  len(different),
+			"identical":  len(identical),
+		},
+	})
+}
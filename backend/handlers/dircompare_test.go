@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+func TestFileHandlers_CompareDirectories(t *testing.T) {
+	left := t.TempDir()
+	right := t.TempDir()
+	stamp := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	write := func(root, name, content string, modTime time.Time) {
+		fullPath := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			t.Fatalf("Failed to create dir for %s: %v", name, err)
+		}
+		if err := os.WriteFile(fullPath, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+		if err := os.Chtimes(fullPath, modTime, modTime); err != nil {
+			t.Fatalf("Failed to set times on %s: %v", name, err)
+		}
+	}
+
+	write(left, "same.txt", "hello", stamp)
+	write(right, "same.txt", "hello", stamp)
+	write(left, "only-left.txt", "l", stamp)
+	write(right, "only-right.txt", "r", stamp)
+	write(left, "sub/size.txt", "short", stamp)
+	write(right, "sub/size.txt", "much longer", stamp)
+	write(left, "newer.txt", "abc", stamp)
+	write(right, "newer.txt", "abc", stamp.Add(time.Minute))
+	write(left, "content.txt", "aaaa", stamp)
+	write(right, "content.txt", "bbbb", stamp)
+
+	mgr := storage.NewManager()
+	mgr.Register("left", storage.NewLocalStorage(left))
+	mgr.Register("right", storage.NewLocalStorage(right))
+	handler := NewFileHandlers(mgr)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/api/fs/dir-compare", handler.CompareDirectories).Methods("POST")
+
+	type entry struct {
+		Path   string `json:"path"`
+		Reason string `json:"reason"`
+	}
+	type result struct {
+		LeftOnly  []entry `json:"left_only"`
+		RightOnly []entry `json:"right_only"`
+		Different []entry `json:"different"`
+		Identical []entry `json:"identical"`
+	}
+
+	compare := func(compareBy string) (int, result) {
+		body := `{"left": {"storage": "left", "path": "/"}, "right": {"storage": "right", "path": "/"}, "compare_by": "` + compareBy + `"}`
+		req := httptest.NewRequest("POST", "/api/fs/dir-compare", strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		var resp struct {
+			Data result `json:"data"`
+		}
+		_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+		return rr.Code, resp.Data
+	}
+
+	paths := func(entries []entry) string {
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Path+":"+e.Reason)
+		}
+		return strings.Join(names, ",")
+	}
+
+	tests := []struct {
+		compareBy string
+		different string
+		identical string
+	}{
+		{"size", "sub/size.txt:size", "content.txt:,newer.txt:,same.txt:,sub:"},
+		{"modtime", "newer.txt:modtime,sub/size.txt:size", "content.txt:,same.txt:,sub:"},
+		{"checksum", "content.txt:checksum,sub/size.txt:size", "newer.txt:,same.txt:,sub:"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.compareBy, func(t *testing.T) {
+			code, res := compare(tt.compareBy)
+			if code != http.StatusOK {
+				t.Fatalf("Expected 200, got %d", code)
+			}
+			if got := paths(res.LeftOnly); got != "only-left.txt:" {
+				t.Errorf("left_only = %s", got)
+			}
+			if got := paths(res.RightOnly); got != "only-right.txt:" {
+				t.Errorf("right_only = %s", got)
+			}
+			if got := paths(res.Different); got != tt.different {
+				t.Errorf("different = %s, want %s", got, tt.different)
+			}
+			if got := paths(res.Identical); got != tt.identical {
+				t.Errorf("identical = %s, want %s", got, tt.identical)
+			}
+		})
+	}
+
+	t.Run("Invalid mode", func(t *testing.T) {
+		if code, _ := compare("color"); code != http.StatusBadRequest {
+			t.Errorf("Expected 400, got %d", code)
+		}
+	})
+}
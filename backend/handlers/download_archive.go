@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// downloadArchiveTypes are the formats /fs/download-archive streams, with
+// their Content-Type
+var downloadArchiveTypes = map[string]string{
+	"zip":    "application/zip",
+	"tar.gz": "application/gzip",
+}
+
+// DownloadArchive streams a directory as a zip or tar.gz archive built on
+// the fly. Nothing is staged on the server and the size isn't known up
+// front, so the response is chunked. The archive holds the directory
+// itself, so it unpacks into a folder of the same name.
+func (ch *CompressionHandler) DownloadArchive(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	storageID := query.Get("storage")
+	dirPath := query.Get("path")
+	format := strings.ToLower(query.Get("format"))
+	if format == "" {
+		format = "zip"
+	}
+
+	contentType, ok := downloadArchiveTypes[format]
+	if !ok {
+		errorResponse(w, fmt.Sprintf("Unsupported format: %s (use zip or tar.gz)", format), http.StatusBadRequest)
+		return
+	}
+	symlinks := query.Get("symlinks")
+	if symlinks == "" {
+		symlinks = defaultSymlinkPolicy(format)
+	}
+	if err := validateSymlinkPolicy(symlinks); err != nil {
+		errorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	exclude, err := queryExcludes(query)
+	if err != nil {
+		errorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	fs, ok := ch.storageManager.Get(storageID)
+	if !ok {
+		errorResponse(w, "Storage not found", http.StatusNotFound)
+		return
+	}
+	info, err := fs.Stat(dirPath)
+	if err != nil {
+		storageErrorResponse(w, "Directory not found", err)
+		return
+	}
+	if !info.IsDir {
+		errorResponse(w, "Path is not a directory; download files with /fs/download", http.StatusBadRequest)
+		return
+	}
+
+	// The storage root has no name of its own, so its entries go in at the
+	// top of the archive
+	clean := path.Clean("/" + dirPath)
+	name := path.Base(clean)
+	archive := CompressRequest{BasePath: path.Dir(clean), Files: []string{name}, Format: format}
+	if clean == "/" {
+		name = "download"
+		archive.BasePath = "/"
+		archive.Files = nil
+		entries, err := fs.List("/")
+		if err != nil {
+			storageErrorResponse(w, "Failed to list directory", err)
+			return
+		}
+		for _, entry := range entries {
+			archive.Files = append(archive.Files, entry.Name)
+		}
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", contentDisposition("attachment", name+"."+format))
+	w.Header().Set("Transfer-Encoding", "chunked")
+
+	opts := newArchiveOptions(symlinks)
+	opts.exclude = exclude
+	ch.streamArchive(r.Context(), w, fs, storageID, archive, opts, nil)
+}
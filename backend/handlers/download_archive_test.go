@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+func TestCompressionHandler_DownloadArchive(t *testing.T) {
+	root := t.TempDir()
+	for name, content := range map[string]string{
+		"site/index.html":     "<h1>hi</h1>",
+		"site/css/main.css":   "body{}",
+		"site/img/logo.svg":   "<svg/>",
+		"other/unrelated.txt": "no",
+	} {
+		full := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(root, "empty"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	mgr := storage.NewManager()
+	mgr.Register("local", storage.NewLocalStorage(root))
+	ch := NewCompressionHandler(mgr)
+	server := httptest.NewServer(http.HandlerFunc(ch.DownloadArchive))
+	defer server.Close()
+
+	get := func(query string) (*http.Response, []byte) {
+		resp, err := http.Get(server.URL + "/api/fs/download-archive?" + query)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("Failed to read response: %v", err)
+		}
+		return resp, body
+	}
+
+	t.Run("zip", func(t *testing.T) {
+		resp, body := get("storage=local&path=/site")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", resp.StatusCode, body)
+		}
+		if len(resp.TransferEncoding) == 0 || resp.TransferEncoding[0] != "chunked" || resp.ContentLength != -1 {
+			t.Errorf("Expected a chunked response without a length, got %v / %d", resp.TransferEncoding, resp.ContentLength)
+		}
+		if cd := resp.Header.Get("Content-Disposition"); cd != `attachment; filename="site.zip"` {
+			t.Errorf("Unexpected Content-Disposition: %s", cd)
+		}
+
+		zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+		if err != nil {
+			t.Fatalf("Failed to open zip: %v", err)
+		}
+		var files []string
+		for _, f := range zr.File {
+			if !strings.HasSuffix(f.Name, "/") {
+				files = append(files, f.Name)
+			}
+		}
+		sort.Strings(files)
+		want := "site/css/main.css,site/img/logo.svg,site/index.html"
+		if got := strings.Join(files, ","); got != want {
+			t.Errorf("Expected %s, got %s", want, got)
+		}
+	})
+
+	t.Run("tar.gz", func(t *testing.T) {
+		resp, body := get("storage=local&path=/site/img&format=tar.gz")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", resp.StatusCode, body)
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != "application/gzip" {
+			t.Errorf("Expected application/gzip, got %s", ct)
+		}
+		gz, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("Invalid gzip stream: %v", err)
+		}
+		tr := tar.NewReader(gz)
+		var names []string
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("Invalid tar stream: %v", err)
+			}
+			names = append(names, header.Name)
+		}
+		if strings.Join(names, ",") != "img/,img/logo.svg" {
+			t.Errorf("Unexpected entries %v", names)
+		}
+	})
+
+	t.Run("empty directory", func(t *testing.T) {
+		resp, body := get("storage=local&path=/empty")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", resp.StatusCode, body)
+		}
+		zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+		if err != nil {
+			t.Fatalf("Expected a valid zip for an empty directory: %v", err)
+		}
+		if len(zr.File) != 1 || zr.File[0].Name != "empty/" {
+			t.Errorf("Expected only the folder entry, got %d entries", len(zr.File))
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		for query, want := range map[string]int{
+			"storage=local&path=/site/index.html": http.StatusBadRequest,
+			"storage=local&path=/site&format=rar": http.StatusBadRequest,
+			"storage=local&path=/missing":         http.StatusNotFound,
+			"storage=nope&path=/site":             http.StatusNotFound,
+		} {
+			if resp, body := get(query); resp.StatusCode != want {
+				t.Errorf("%s: expected %d, got %d: %s", query, want, resp.StatusCode, body)
+			}
+		}
+	})
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// errRangeNotSatisfiable is returned for a Range that starts past the end
+// of the file
+var errRangeNotSatisfiable = errors.New("range not satisfiable")
+
+// parseRangeHeader returns the bytes a Range header asks for out of a file
+// of size bytes. It returns nil for a missing header, and for ones it
+// doesn't serve as a range (other units, several ranges, bad syntax), which
+// get the whole file as RFC 9110 allows.
+func parseRangeHeader(header string, size int64) (*byteRange, error) {
+	spec, ok := strings.CutPrefix(strings.TrimSpace(header), "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return nil, nil
+	}
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return nil, nil
+	}
+
+	if first == "" {
+		// The last n bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return nil, nil
+		}
+		if n == 0 || size == 0 {
+			return nil, errRangeNotSatisfiable
+		}
+		return &byteRange{Start: max(size-n, 0), End: size - 1}, nil
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return nil, nil
+	}
+	end := size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return nil, nil
+		}
+		end = min(end, size-1)
+	}
+	if start >= size {
+		return nil, errRangeNotSatisfiable
+	}
+	return &byteRange{Start: start, End: end}, nil
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+func TestFileHandlers_DownloadRange(t *testing.T) {
+	root := t.TempDir()
+	// Files over the size hashed for an ETag are read with ReadRange; small
+	// ones are sliced from the content read for the ETag
+	big := strings.Repeat("0123456789", (maxHashETagSize+10)/10)
+	small := "0123456789"
+	if err := os.WriteFile(filepath.Join(root, "big.txt"), []byte(big), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "small.txt"), []byte(small), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// The mock's reader can't seek, so the start of the file is skipped
+	mock := newMockFileSystem()
+	mock.files["/big.txt"] = []byte(big)
+
+	mgr := storage.NewManager()
+	mgr.Register("local", storage.NewLocalStorage(root))
+	mgr.Register("mock", mock)
+	h := NewFileHandlers(mgr)
+
+	download := func(storageID, path string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/fs/download?storage="+storageID+"&path="+path, nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rr := httptest.NewRecorder()
+		h.DownloadFile(rr, req)
+		return rr
+	}
+
+	for _, storageID := range []string{"local", "mock"} {
+		for _, file := range []struct {
+			path    string
+			content string
+		}{{"/big.txt", big}, {"/small.txt", small}} {
+			if storageID == "mock" && file.path == "/small.txt" {
+				continue
+			}
+			size := len(file.content)
+			for header, want := range map[string][2]int{
+				"bytes=2-5":                       {2, 5},
+				"bytes=7-":                        {7, size - 1},
+				"bytes=-3":                        {size - 3, size - 1},
+				fmt.Sprintf("bytes=5-%d", size*2): {5, size - 1},
+			} {
+				name := storageID + file.path + " " + header
+				rr := download(storageID, file.path, map[string]string{"Range": header})
+				if rr.Code != http.StatusPartialContent {
+					t.Errorf("%s: expected 206, got %d: %s", name, rr.Code, rr.Body.String())
+					continue
+				}
+				if got := rr.Body.String(); got != file.content[want[0]:want[1]+1] {
+					t.Errorf("%s: unexpected body of %d bytes", name, len(got))
+				}
+				if cr := rr.Header().Get("Content-Range"); cr != fmt.Sprintf("bytes %d-%d/%d", want[0], want[1], size) {
+					t.Errorf("%s: unexpected Content-Range %q", name, cr)
+				}
+				if cl := rr.Header().Get("Content-Length"); cl != fmt.Sprint(want[1]-want[0]+1) {
+					t.Errorf("%s: unexpected Content-Length %s", name, cl)
+				}
+			}
+		}
+	}
+
+	rr := download("local", "/small.txt", nil)
+	if rr.Code != http.StatusOK || rr.Body.String() != small || rr.Header().Get("Accept-Ranges") != "bytes" {
+		t.Errorf("Expected the whole file with Accept-Ranges, got %d %q %v", rr.Code, rr.Body.String(), rr.Header())
+	}
+
+	rr = download("local", "/small.txt", map[string]string{"Range": "bytes=10-"})
+	if rr.Code != http.StatusRequestedRangeNotSatisfiable || rr.Header().Get("Content-Range") != "bytes */10" {
+		t.Errorf("Expected 416 with the size, got %d %q", rr.Code, rr.Header().Get("Content-Range"))
+	}
+
+	// Ranges that aren't served as such get the whole file
+	for _, headers := range []map[string]string{
+		{"Range": "bytes=1-2,4-5"},
+		{"Range": "bytes=5-2"},
+		{"Range": "items=1-2"},
+		{"Range": "bytes=1-2", "If-Range": `"stale"`},
+	} {
+		if rr := download("local", "/small.txt", headers); rr.Code != http.StatusOK || rr.Body.String() != small {
+			t.Errorf("%v: expected the whole file, got %d %q", headers, rr.Code, rr.Body.String())
+		}
+	}
+
+	etag := download("local", "/small.txt", nil).Header().Get("ETag")
+	if rr := download("local", "/small.txt", map[string]string{"Range": "bytes=1-2", "If-Range": etag}); rr.Code != http.StatusPartialContent || rr.Body.String() != "12" {
+		t.Errorf("Expected the range with a matching If-Range, got %d %q", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+// downloadRetries is how many times a download is resumed after the
+// backend fails partway through it
+const downloadRetries = 3
+
+// downloadRetryDelay is the pause before resuming a download, doubled on
+// each further attempt
+var downloadRetryDelay = 500 * time.Millisecond
+
+// errClientWrite marks a failed write to the client, which resuming the
+// backend read can't help
+var errClientWrite = errors.New("client write failed")
+
+// closeOnceReader lets a reader be closed both to interrupt a blocked Read
+// and when it's done with
+type closeOnceReader struct {
+	io.ReadCloser
+	once sync.Once
+	err  error
+}
+
+func (c *closeOnceReader) Close() error {
+	c.once.Do(func() { c.err = c.ReadCloser.Close() })
+	return c.err
+}
+
+// isSeekable reports whether reader, or the one a closeOnceReader wraps,
+// can seek
+func isSeekable(reader io.Reader) bool {
+	if c, ok := reader.(*closeOnceReader); ok {
+		reader = c.ReadCloser
+	}
+	_, ok := reader.(io.Seeker)
+	return ok
+}
+
+// streamDownload copies the length bytes of path that start at offset to
+// w, reading them from reader. When the backend fails partway through on
+// a backend that can read ranges, or whose reader can seek, the rest is
+// read again from where it stopped. The read is abandoned as soon as ctx
+// is done by closing the reader, which must allow being closed twice; the
+// caller still closes it. It returns how many bytes were written.
+func streamDownload(ctx context.Context, w io.Writer, fs storage.FileSystem, path string, reader io.ReadCloser, offset, length int64) (int64, error) {
+	resumable := storage.HasRangeReads(fs) || isSeekable(reader)
+
+	var written int64
+	current := reader
+	for attempt := 0; ; attempt++ {
+		n, err := copyDownloadChunk(ctx, w, current, length-written)
+		written += n
+		if current != reader {
+			current.Close()
+		}
+		switch {
+		case ctx.Err() != nil:
+			return written, ctx.Err()
+		case err == nil, errors.Is(err, errClientWrite), !resumable, attempt == downloadRetries:
+			return written, err
+		}
+
+		log.Printf("Reading %s failed at byte %d, resuming: %v", path, offset+written, err)
+		select {
+		case <-time.After(downloadRetryDelay << attempt):
+		case <-ctx.Done():
+			return written, ctx.Err()
+		}
+		next, err := storage.ReadRange(fs, path, offset+written, length-written)
+		if err != nil {
+			// Counted as another failed attempt from the same place
+			next = io.NopCloser(errReader{err})
+		}
+		current = &closeOnceReader{ReadCloser: next}
+	}
+}
+
+// copyDownloadChunk copies up to length bytes from reader to w, closing
+// reader if ctx is done first. A reader that ends early reports
+// io.ErrUnexpectedEOF; failed writes are wrapped in errClientWrite.
+func copyDownloadChunk(ctx context.Context, w io.Writer, reader io.ReadCloser, length int64) (int64, error) {
+	stop := context.AfterFunc(ctx, func() { reader.Close() })
+	defer stop()
+
+	buf := make([]byte, 32<<10)
+	var written int64
+	for written < length {
+		n, err := reader.Read(buf[:min(int64(len(buf)), length-written)])
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return written, errors.Join(errClientWrite, werr)
+			}
+			written += int64(n)
+		}
+		if err == io.EOF {
+			if written < length {
+				return written, io.ErrUnexpectedEOF
+			}
+			break
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// errReader fails every read with its error
+type errReader struct{ err error }
+
+func (e errReader) Read([]byte) (int, error) { return 0, e.err }
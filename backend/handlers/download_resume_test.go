@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+// failingReader returns its data and then fails, like a dropped connection
+type failingReader struct {
+	data []byte
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	if len(f.data) == 0 {
+		return 0, errors.New("connection reset by peer")
+	}
+	n := copy(p, f.data)
+	f.data = f.data[n:]
+	return n, nil
+}
+
+// flakyFileSystem serves files whose reads fail after the byte counts in
+// failAfter, one per read. It keeps an ETag so downloads aren't read ahead
+// to hash them.
+type flakyFileSystem struct {
+	*mockFileSystem
+	mu        sync.Mutex
+	failAfter []int
+	offsets   []int64
+}
+
+func (f *flakyFileSystem) ETag(path string) (string, error) { return "v1", nil }
+
+func (f *flakyFileSystem) reader(content []byte) io.ReadCloser {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.failAfter) == 0 {
+		return io.NopCloser(bytes.NewReader(content))
+	}
+	n := min(f.failAfter[0], len(content))
+	f.failAfter = f.failAfter[1:]
+	return io.NopCloser(&failingReader{data: content[:n]})
+}
+
+func (f *flakyFileSystem) Read(path string) (io.ReadCloser, error) {
+	return f.reader(f.files[path]), nil
+}
+
+// resumableFileSystem adds ranged reads to a flakyFileSystem
+type resumableFileSystem struct {
+	*flakyFileSystem
+}
+
+func (f *resumableFileSystem) ReadRange(path string, offset, length int64) (io.ReadCloser, error) {
+	f.mu.Lock()
+	f.offsets = append(f.offsets, offset)
+	f.mu.Unlock()
+	return f.reader(f.files[path][offset : offset+length]), nil
+}
+
+// blockingFileSystem serves reads that block until they're closed
+type blockingFileSystem struct {
+	*mockFileSystem
+	closed chan struct{}
+}
+
+func (b *blockingFileSystem) ETag(path string) (string, error) { return "v1", nil }
+
+func (b *blockingFileSystem) Read(path string) (io.ReadCloser, error) {
+	return &blockingReader{closed: b.closed}, nil
+}
+
+type blockingReader struct {
+	closed chan struct{}
+}
+
+func (b *blockingReader) Read(p []byte) (int, error) {
+	<-b.closed
+	return 0, errors.New("read on closed body")
+}
+
+func (b *blockingReader) Close() error {
+	close(b.closed)
+	return nil
+}
+
+func TestFileHandlers_DownloadResume(t *testing.T) {
+	defer func(delay time.Duration) { downloadRetryDelay = delay }(downloadRetryDelay)
+	downloadRetryDelay = time.Millisecond
+
+	content := []byte(strings.Repeat("0123456789", 10000))
+	newFlaky := func(failAfter ...int) *flakyFileSystem {
+		fs := &flakyFileSystem{mockFileSystem: newMockFileSystem(), failAfter: failAfter}
+		fs.files["/big.bin"] = content
+		return fs
+	}
+	download := func(fs storage.FileSystem, headers map[string]string) *httptest.ResponseRecorder {
+		mgr := storage.NewManager()
+		mgr.Register("flaky", fs)
+		h := NewFileHandlers(mgr)
+		req := httptest.NewRequest("GET", "/api/fs/download?storage=flaky&path=/big.bin", nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rr := httptest.NewRecorder()
+		h.DownloadFile(rr, req)
+		return rr
+	}
+
+	t.Run("Resumed with ranged reads", func(t *testing.T) {
+		fs := &resumableFileSystem{newFlaky(30000, 25000)}
+		rr := download(fs, nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", rr.Code)
+		}
+		if !bytes.Equal(rr.Body.Bytes(), content) {
+			t.Fatalf("Expected the whole file, got %d of %d bytes", rr.Body.Len(), len(content))
+		}
+		if len(fs.offsets) != 2 || fs.offsets[0] != 30000 || fs.offsets[1] != 55000 {
+			t.Errorf("Expected the download to resume at 30000 and 55000, got %v", fs.offsets)
+		}
+	})
+
+	t.Run("Resumed within a range", func(t *testing.T) {
+		fs := &resumableFileSystem{newFlaky(0, 100)}
+		rr := download(fs, map[string]string{"Range": "bytes=1000-1999"})
+		if rr.Code != http.StatusPartialContent {
+			t.Fatalf("Expected 206, got %d", rr.Code)
+		}
+		if !bytes.Equal(rr.Body.Bytes(), content[1000:2000]) {
+			t.Fatalf("Expected bytes 1000-1999, got %d bytes", rr.Body.Len())
+		}
+		// The first read of the range fails straight away
+		if len(fs.offsets) != 3 || fs.offsets[1] != 1000 || fs.offsets[2] != 1100 {
+			t.Errorf("Expected the range to resume at 1000 and 1100, got %v", fs.offsets)
+		}
+	})
+
+	t.Run("Gives up after the retries", func(t *testing.T) {
+		fs := &resumableFileSystem{newFlaky(100, 100, 100, 100, 100)}
+		rr := download(fs, nil)
+		if rr.Body.Len() != 400 {
+			t.Errorf("Expected the download to stop after %d attempts, got %d bytes", downloadRetries+1, rr.Body.Len())
+		}
+	})
+
+	t.Run("Truncated without ranged reads", func(t *testing.T) {
+		rr := download(newFlaky(30000), nil)
+		if rr.Body.Len() != 30000 {
+			t.Errorf("Expected the download to stop where the read failed, got %d bytes", rr.Body.Len())
+		}
+	})
+
+	t.Run("Client disconnect", func(t *testing.T) {
+		fs := &blockingFileSystem{mockFileSystem: newMockFileSystem(), closed: make(chan struct{})}
+		fs.files["/big.bin"] = content
+		mgr := storage.NewManager()
+		mgr.Register("blocking", fs)
+		h := NewFileHandlers(mgr)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		req := httptest.NewRequest("GET", "/api/fs/download?storage=blocking&path=/big.bin", nil).WithContext(ctx)
+		done := make(chan struct{})
+		go func() {
+			h.DownloadFile(httptest.NewRecorder(), req)
+			close(done)
+		}()
+
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatal("Expected the download to stop when the client went away")
+		}
+		select {
+		case <-fs.closed:
+		default:
+			t.Error("Expected the backend read to be closed")
+		}
+	})
+}
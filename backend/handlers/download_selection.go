@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+// DownloadSelectionRequest names the entries to stream as one zip
+type DownloadSelectionRequest struct {
+	Storage  string    `json:"storage"`
+	BasePath string    `json:"base_path"`
+	Files    []string  `json:"files"`    // relative to BasePath, may be in subfolders
+	Symlinks string    `json:"symlinks"` // follow, store, skip; defaults to skip
+	Progress bool      `json:"progress"` // report progress over WebSocket
+	Exclude  *[]string `json:"exclude,omitempty"`
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w http.ResponseWriter
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// DownloadSelection streams the selected files and directories as a zip
+// built on the fly, keeping their paths relative to base_path
+func (ch *CompressionHandler) DownloadSelection(w http.ResponseWriter, r *http.Request) {
+	var req DownloadSelectionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(req.Files) == 0 {
+		errorResponse(w, "files is required", http.StatusBadRequest)
+		return
+	}
+
+	if req.Symlinks == "" {
+		req.Symlinks = defaultSymlinkPolicy("zip")
+	}
+	if err := validateSymlinkPolicy(req.Symlinks); err != nil {
+		errorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	exclude, err := resolveExcludes(req.Exclude)
+	if err != nil {
+		errorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Get storage backend
+	fs, ok := ch.storageManager.Get(req.Storage)
+	if !ok {
+		errorResponse(w, "Storage not found", http.StatusNotFound)
+		return
+	}
+
+	// Entries become archive paths, so they must stay below base_path. Each
+	// is checked up front, while an error can still be reported.
+	files := make([]string, 0, len(req.Files))
+	for _, file := range req.Files {
+		clean := filepath.Clean(strings.TrimPrefix(filepath.ToSlash(file), "/"))
+		if clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+			errorResponse(w, fmt.Sprintf("Invalid file path: %s", file), http.StatusBadRequest)
+			return
+		}
+		if _, err := fs.Stat(filepath.Join(req.BasePath, clean)); err != nil {
+			errorResponse(w, fmt.Sprintf("File not found: %s", file), http.StatusNotFound)
+			return
+		}
+		files = append(files, clean)
+	}
+
+	ctx := r.Context()
+	var tracker *ProgressTracker
+	if req.Progress {
+		op := ch.operations.Start("download", clientFromRequest(r), req.Storage, files)
+		defer ch.operations.Finish(op.ID)
+		stop := context.AfterFunc(r.Context(), func() { ch.operations.Cancel(op.ID) })
+		defer stop()
+
+		ctx = op.Context()
+		tracker = NewProgressTracker(ch.wsHandler, op.ID, "download", ch.calculateTotalSize(fs, files, req.BasePath, exclude))
+		tracker.SetOperation(op)
+		w.Header().Set("X-Operation-ID", op.ID)
+	}
+
+	name := "download"
+	if base := filepath.Base(req.BasePath); base != "/" && base != "." && base != "" {
+		name = base
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", contentDisposition("attachment", name+".zip"))
+
+	opts := newArchiveOptions(req.Symlinks)
+	opts.exclude = exclude
+	archive := CompressRequest{Files: files, BasePath: req.BasePath, Format: "zip"}
+	ch.streamArchive(ctx, w, fs, req.Storage, archive, opts, tracker)
+}
+
+// streamArchive builds an archive straight into the response. The headers
+// must already be set. An error before the first byte still gets an error
+// response; after that a truncated archive is all the client sees.
+func (ch *CompressionHandler) streamArchive(ctx context.Context, w http.ResponseWriter, fs storage.FileSystem, storageID string, archive CompressRequest, opts *archiveOptions, tracker *ProgressTracker) {
+	out := &countingWriter{w: w}
+	err := ch.writeArchive(ctx, fs, out, archive, opts, tracker)
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		if tracker != nil {
+			tracker.Fail(err)
+		}
+		if out.n == 0 {
+			w.Header().Del("Content-Disposition")
+			w.Header().Del("Transfer-Encoding")
+			storageErrorResponse(w, fmt.Sprintf("Failed to create archive: %v", err), err)
+			return
+		}
+		// The status line is gone; a truncated archive is all the client sees
+		log.Printf("Error streaming archive from %s after %d bytes: %v", storageID, out.n, err)
+		return
+	}
+	if tracker != nil {
+		tracker.Complete()
+	}
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+func TestCompressionHandler_DownloadSelection(t *testing.T) {
+	root := t.TempDir()
+	for path, content := range map[string]string{
+		"projects/docs/spec.md":        "spec",
+		"projects/docs/notes.txt":      "notes",
+		"projects/img/logo.png":        "png",
+		"projects/img/icons/small.png": "icon",
+		"projects/readme.txt":          "readme",
+	} {
+		full := filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+
+	mgr := storage.NewManager()
+	mgr.Register("local", storage.NewLocalStorage(root))
+	handler := NewCompressionHandler(mgr)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/api/fs/download-selection", handler.DownloadSelection).Methods("POST")
+
+	download := func(req DownloadSelectionRequest) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(req)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", "/api/fs/download-selection", bytes.NewReader(body)))
+		return rr
+	}
+
+	rr := download(DownloadSelectionRequest{
+		Storage:  "local",
+		BasePath: "/projects",
+		Files:    []string{"docs/spec.md", "img", "readme.txt"},
+	})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/zip" {
+		t.Errorf("Expected application/zip, got %s", ct)
+	}
+	if cd := rr.Header().Get("Content-Disposition"); cd != `attachment; filename="projects.zip"` {
+		t.Errorf("Unexpected Content-Disposition: %s", cd)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len()))
+	if err != nil {
+		t.Fatalf("Failed to open zip: %v", err)
+	}
+	contents := make(map[string]string)
+	var files []string
+	for _, f := range zr.File {
+		if strings.HasSuffix(f.Name, "/") {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("Failed to open %s: %v", f.Name, err)
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		contents[f.Name] = string(data)
+		files = append(files, f.Name)
+	}
+	sort.Strings(files)
+	want := []string{"docs/spec.md", "img/icons/small.png", "img/logo.png", "readme.txt"}
+	if strings.Join(files, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected %v in the zip, got %v", want, files)
+	}
+	if contents["img/icons/small.png"] != "icon" || contents["docs/spec.md"] != "spec" {
+		t.Errorf("Unexpected file contents: %v", contents)
+	}
+
+	t.Run("Path outside base", func(t *testing.T) {
+		rr := download(DownloadSelectionRequest{Storage: "local", BasePath: "/projects/docs", Files: []string{"../img/logo.png"}})
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400, got %d", rr.Code)
+		}
+	})
+
+	t.Run("Missing file", func(t *testing.T) {
+		rr := download(DownloadSelectionRequest{Storage: "local", BasePath: "/projects", Files: []string{"readme.txt", "gone.txt"}})
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("Expected 404, got %d", rr.Code)
+		}
+		if rr.Header().Get("Content-Disposition") != "" {
+			t.Error("Expected no attachment for an error response")
+		}
+	})
+}
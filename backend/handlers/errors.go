@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+// Error codes sent with every error response, so clients can react to the
+// kind of failure without parsing the message
+const (
+	CodeInvalidRequest     = "INVALID_REQUEST"
+	CodeUnauthorized       = "UNAUTHORIZED"
+	CodePermissionDenied   = "PERMISSION_DENIED"
+	CodeNotFound           = "NOT_FOUND"
+	CodeConflict           = "CONFLICT"
+	CodePreconditionFailed = "PRECONDITION_FAILED"
+	CodeTooLarge           = "TOO_LARGE"
+	CodeUnsupportedType    = "UNSUPPORTED_TYPE"
+	CodeReadOnly           = "READ_ONLY"
+	CodeQuotaExceeded      = "QUOTA_EXCEEDED"
+	CodeRateLimited        = "RATE_LIMITED"
+	CodeUpstreamTimeout    = "UPSTREAM_TIMEOUT"
+	CodeNotSupported       = "NOT_SUPPORTED"
+	CodePartialFailure     = "PARTIAL_FAILURE"
+	CodeInternal           = "INTERNAL"
+)
+
+// statusCodes gives the error code for responses sent with a plain status
+var statusCodes = map[int]string{
+	http.StatusPartialContent:        CodePartialFailure,
+	http.StatusBadRequest:            CodeInvalidRequest,
+	http.StatusUnauthorized:          CodeUnauthorized,
+	http.StatusForbidden:             CodePermissionDenied,
+	http.StatusNotFound:              CodeNotFound,
+	http.StatusMethodNotAllowed:      CodeInvalidRequest,
+	http.StatusConflict:              CodeConflict,
+	http.StatusPreconditionFailed:    CodePreconditionFailed,
+	http.StatusRequestEntityTooLarge: CodeTooLarge,
+	http.StatusUnsupportedMediaType:  CodeUnsupportedType,
+	http.StatusTooManyRequests:       CodeRateLimited,
+	http.StatusNotImplemented:        CodeNotSupported,
+	http.StatusGatewayTimeout:        CodeUpstreamTimeout,
+	http.StatusInsufficientStorage:   CodeQuotaExceeded,
+}
+
+// codeForStatus returns the error code matching an HTTP status
+func codeForStatus(status int) string {
+	if code, ok := statusCodes[status]; ok {
+		return code
+	}
+	if status >= 500 {
+		return CodeInternal
+	}
+	return CodeInvalidRequest
+}
+
+// classifyError maps a storage error to an error code and HTTP status.
+// Errors of no known kind are internal errors.
+func classifyError(err error) (string, int) {
+	var locked *storage.ObjectLockedError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return CodeNotFound, http.StatusNotFound
+	case errors.Is(err, storage.ErrReadOnly):
+		return CodeReadOnly, http.StatusForbidden
+	case errors.Is(err, os.ErrPermission), errors.Is(err, storage.ErrRootNotAllowed), errors.As(err, &locked):
+		return CodePermissionDenied, http.StatusForbidden
+	case errors.Is(err, os.ErrExist):
+		return CodeConflict, http.StatusConflict
+	case errors.Is(err, storage.ErrPreconditionFailed):
+		return CodePreconditionFailed, http.StatusPreconditionFailed
+	case errors.Is(err, storage.ErrQuotaExceeded), errors.Is(err, syscall.ENOSPC), errors.Is(err, syscall.EDQUOT):
+		return CodeQuotaExceeded, http.StatusInsufficientStorage
+	case errors.Is(err, storage.ErrRateLimited):
+		return CodeRateLimited, http.StatusTooManyRequests
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return CodeUpstreamTimeout, http.StatusGatewayTimeout
+	case errors.Is(err, storage.ErrNotSupported):
+		return CodeNotSupported, http.StatusNotImplemented
+	case errors.Is(err, storage.ErrSymlink):
+		return CodeInvalidRequest, http.StatusBadRequest
+	}
+	return CodeInternal, http.StatusInternalServerError
+}
+
+// errorResponse sends an error with the code matching its status
+func errorResponse(w http.ResponseWriter, message string, status int) {
+	codedErrorResponse(w, message, codeForStatus(status), status)
+}
+
+// storageErrorResponse sends an error for a failed storage call, with the
+// code and status classifyError picks for err
+func storageErrorResponse(w http.ResponseWriter, message string, err error) {
+	code, status := classifyError(err)
+	codedErrorResponse(w, message, code, status)
+}
+
+// codedErrorResponse sends an error with an explicit code
+func codedErrorResponse(w http.ResponseWriter, message, code string, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": false,
+		"error": map[string]string{
+			"code":    code,
+			"message": message,
+		},
+	}); err != nil {
+		log.Printf("Error encoding error response: %v", err)
+	}
+}
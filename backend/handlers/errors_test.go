@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		code   string
+		status int
+	}{
+		{"missing file", &os.PathError{Op: "open", Path: "/a", Err: syscall.ENOENT}, CodeNotFound, http.StatusNotFound},
+		{"permission", fmt.Errorf("write: %w", os.ErrPermission), CodePermissionDenied, http.StatusForbidden},
+		{"object lock", &storage.ObjectLockedError{Path: "/a", Mode: "COMPLIANCE"}, CodePermissionDenied, http.StatusForbidden},
+		{"root not allowed", storage.ErrRootNotAllowed, CodePermissionDenied, http.StatusForbidden},
+		{"read-only", fmt.Errorf("NFS share is mounted read-only: %w", storage.ErrReadOnly), CodeReadOnly, http.StatusForbidden},
+		{"exists", &os.PathError{Op: "mkdir", Path: "/a", Err: syscall.EEXIST}, CodeConflict, http.StatusConflict},
+		{"disk full", &os.PathError{Op: "write", Path: "/a", Err: syscall.ENOSPC}, CodeQuotaExceeded, http.StatusInsufficientStorage},
+		{"quota", fmt.Errorf("upload: %w", storage.ErrQuotaExceeded), CodeQuotaExceeded, http.StatusInsufficientStorage},
+		{"rate limited", fmt.Errorf("delete: %w", storage.ErrRateLimited), CodeRateLimited, http.StatusTooManyRequests},
+		{"deadline", fmt.Errorf("list: %w", context.DeadlineExceeded), CodeUpstreamTimeout, http.StatusGatewayTimeout},
+		{"not supported", storage.ErrNotSupported, CodeNotSupported, http.StatusNotImplemented},
+		{"unknown", errors.New("boom"), CodeInternal, http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, status := classifyError(tt.err)
+			if code != tt.code || status != tt.status {
+				t.Errorf("Expected %s/%d, got %s/%d", tt.code, tt.status, code, status)
+			}
+		})
+	}
+}
+
+func TestErrorResponseCodes(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Mkdir(root+"/docs", 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	mgr := storage.NewManager()
+	mgr.Register("local", storage.NewLocalStorage(root))
+	h := NewFileHandlers(mgr)
+
+	errorOf := func(rr *httptest.ResponseRecorder) (string, string) {
+		t.Helper()
+		var resp struct {
+			Error struct {
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Failed to decode error: %v", err)
+		}
+		return resp.Error.Code, resp.Error.Message
+	}
+
+	t.Run("Derived from status", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		h.StatFile(rr, httptest.NewRequest("GET", "/api/fs/stat?storage=missing&path=/", nil))
+		if code, msg := errorOf(rr); rr.Code != http.StatusNotFound || code != CodeNotFound || msg == "" {
+			t.Errorf("Expected 404 %s with a message, got %d %s %q", CodeNotFound, rr.Code, code, msg)
+		}
+	})
+
+	t.Run("Derived from storage error", func(t *testing.T) {
+		body, _ := json.Marshal(map[string]interface{}{
+			"src_storage": "local", "dst_storage": "local", "src_path": "/", "dst_path": "/docs", "files": []string{"gone.txt"},
+		})
+		rr := httptest.NewRecorder()
+		h.CopyFiles(rr, httptest.NewRequest("POST", "/api/fs/copy", bytes.NewReader(body)))
+		if code, _ := errorOf(rr); code != CodeNotFound || rr.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 %s for a missing source, got %d %s: %s", CodeNotFound, rr.Code, code, rr.Body.String())
+		}
+	})
+}
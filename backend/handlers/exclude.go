@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"net/url"
+
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+// resolveExcludes returns the exclusions for a request: its own pattern
+// list when it sent one (an empty list disables exclusions), the
+// server-wide default otherwise
+func resolveExcludes(patterns *[]string) (*storage.ExcludeFilter, error) {
+	if patterns == nil {
+		return storage.DefaultExcludes(), nil
+	}
+	return storage.NewExcludeFilter(*patterns)
+}
+
+// queryExcludes resolves exclusions from a comma-separated query parameter
+func queryExcludes(query url.Values) (*storage.ExcludeFilter, error) {
+	list, ok := query["exclude"]
+	if !ok {
+		return storage.DefaultExcludes(), nil
+	}
+	var patterns []string
+	for _, value := range list {
+		patterns = append(patterns, storage.ParseExcludePatterns(value)...)
+	}
+	return storage.NewExcludeFilter(patterns)
+}
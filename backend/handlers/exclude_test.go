@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+// setupJunkTree creates root/tree holding two regular files alongside
+// .DS_Store, Thumbs.db and a .git directory
+func setupJunkTree(t *testing.T) string {
+	root := t.TempDir()
+	files := map[string]string{
+		"tree/a.txt":          "aaaa",
+		"tree/.DS_Store":      "junk",
+		"tree/.git/config":    "[core]",
+		"tree/sub/b.txt":      "bb",
+		"tree/sub/Thumbs.db":  "junk",
+		"tree/sub/.git/HEAD":  "ref",
+		"tree/sub/deep/c.tmp": "tmp",
+	}
+	for name, content := range files {
+		full := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+	return root
+}
+
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+func TestExcludePatterns(t *testing.T) {
+	patterns := []string{".DS_Store", "Thumbs.db", ".git", "*.tmp"}
+	junk := []string{".DS_Store", ".git/config", "sub/Thumbs.db", "sub/.git/HEAD", "sub/deep/c.tmp"}
+
+	if err := storage.SetDefaultExcludes(patterns); err != nil {
+		t.Fatalf("Failed to set default excludes: %v", err)
+	}
+	t.Cleanup(func() { storage.SetDefaultExcludes(nil) })
+
+	t.Run("Copy", func(t *testing.T) {
+		srcRoot := setupJunkTree(t)
+		dstRoot := t.TempDir()
+
+		mgr := storage.NewManager()
+		mgr.Register("src", storage.NewLocalStorage(srcRoot))
+		mgr.Register("dst", storage.NewLocalStorage(dstRoot))
+		router := mux.NewRouter()
+		router.HandleFunc("/api/fs/copy", NewFileHandlers(mgr).CopyFiles).Methods("POST")
+
+		body := `{"src_storage": "src", "dst_storage": "dst", "files": ["tree"], "src_path": "/", "dst_path": "/"}`
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", "/api/fs/copy", strings.NewReader(body)))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+
+		for _, name := range []string{"a.txt", "sub/b.txt"} {
+			if !exists(filepath.Join(dstRoot, "tree", name)) {
+				t.Errorf("Expected %s to be copied", name)
+			}
+		}
+		for _, name := range junk {
+			if exists(filepath.Join(dstRoot, "tree", name)) {
+				t.Errorf("Excluded %s was copied", name)
+			}
+		}
+	})
+
+	t.Run("Delete keeps excluded entries", func(t *testing.T) {
+		root := setupJunkTree(t)
+
+		mgr := storage.NewManager()
+		mgr.Register("local", storage.NewLocalStorage(root))
+		router := mux.NewRouter()
+		router.HandleFunc("/api/fs/delete", NewFileHandlers(mgr).DeleteFiles).Methods("DELETE")
+
+		body := `{"storage": "local", "files": ["tree"], "path": "/"}`
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("DELETE", "/api/fs/delete", strings.NewReader(body)))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+
+		for _, name := range []string{"a.txt", "sub/b.txt"} {
+			if exists(filepath.Join(root, "tree", name)) {
+				t.Errorf("Expected %s to be deleted", name)
+			}
+		}
+		for _, name := range junk {
+			if !exists(filepath.Join(root, "tree", name)) {
+				t.Errorf("Excluded %s was deleted", name)
+			}
+		}
+	})
+
+	t.Run("Request overrides default", func(t *testing.T) {
+		root := setupJunkTree(t)
+
+		mgr := storage.NewManager()
+		mgr.Register("local", storage.NewLocalStorage(root))
+		router := mux.NewRouter()
+		router.HandleFunc("/api/fs/delete", NewFileHandlers(mgr).DeleteFiles).Methods("DELETE")
+
+		body := `{"storage": "local", "files": ["tree"], "path": "/", "exclude": []}`
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("DELETE", "/api/fs/delete", strings.NewReader(body)))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if exists(filepath.Join(root, "tree")) {
+			t.Error("Expected the whole tree to be deleted with an empty exclude list")
+		}
+	})
+
+	t.Run("Compress and size", func(t *testing.T) {
+		fs := storage.NewLocalStorage(setupJunkTree(t))
+		ch := NewCompressionHandler(storage.NewManager())
+		exclude := storage.DefaultExcludes()
+
+		if size := ch.getFileOrDirSize(fs, "/tree", exclude); size != 6 {
+			t.Errorf("Expected size 6 without excluded files, got %d", size)
+		}
+
+		opts := newArchiveOptions(SymlinkSkip)
+		opts.exclude = exclude
+		var buf bytes.Buffer
+		if err := ch.createZipArchive(context.Background(), fs, &buf, []string{"tree"}, "/", opts, nil); err != nil {
+			t.Fatalf("Failed to create archive: %v", err)
+		}
+		zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatalf("Failed to read zip: %v", err)
+		}
+
+		var files []string
+		for _, f := range zr.File {
+			if !strings.HasSuffix(f.Name, "/") {
+				files = append(files, f.Name)
+			}
+		}
+		if len(files) != 2 {
+			t.Errorf("Expected only tree/a.txt and tree/sub/b.txt, got %v", files)
+		}
+	})
+
+	t.Run("Invalid pattern", func(t *testing.T) {
+		mgr := storage.NewManager()
+		router := mux.NewRouter()
+		router.HandleFunc("/api/fs/delete", NewFileHandlers(mgr).DeleteFiles).Methods("DELETE")
+
+		body := `{"storage": "local", "files": ["tree"], "path": "/", "exclude": ["[a-"]}`
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("DELETE", "/api/fs/delete", strings.NewReader(body)))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400, got %d", rr.Code)
+		}
+	})
+}
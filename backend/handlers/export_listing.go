@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+// exportFlushEvery is the number of rows written between flushes of a
+// listing export
+const exportFlushEvery = 500
+
+// exportWalkLimits keeps a recursive export from running away on an
+// enormous or looping tree. It allows longer than a search, as checksums
+// read every file.
+var exportWalkLimits = storage.WalkLimits{MaxEntries: 5000000, Timeout: 30 * time.Minute}
+
+// exportTruncatedTrailer is the trailer that says why a recursive export
+// stopped before the end of the tree
+const exportTruncatedTrailer = "X-Export-Truncated"
+
+// exportRow is one entry in a listing export
+type exportRow struct {
+	Name     string `json:"name"`
+	Path     string `json:"path"`
+	Size     int64  `json:"size"`
+	ModTime  string `json:"modtime"`
+	Type     string `json:"type"`
+	Checksum string `json:"checksum,omitempty"`
+}
+
+// listingExporter writes export rows in a particular format
+type listingExporter interface {
+	Begin() error
+	Write(row exportRow) error
+	End() error
+}
+
+type csvExporter struct {
+	w        *csv.Writer
+	checksum bool
+}
+
+func (e *csvExporter) Begin() error {
+	header := []string{"name", "path", "size", "modtime", "type"}
+	if e.checksum {
+		header = append(header, "checksum")
+	}
+	return e.w.Write(header)
+}
+
+func (e *csvExporter) Write(row exportRow) error {
+	record := []string{row.Name, row.Path, strconv.FormatInt(row.Size, 10), row.ModTime, row.Type}
+	if e.checksum {
+		record = append(record, row.Checksum)
+	}
+	return e.w.Write(record)
+}
+
+func (e *csvExporter) End() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+type jsonExporter struct {
+	w     http.ResponseWriter
+	enc   *json.Encoder
+	count int
+}
+
+func (e *jsonExporter) Begin() error {
+	_, err := e.w.Write([]byte("[\n"))
+	return err
+}
+
+func (e *jsonExporter) Write(row exportRow) error {
+	if e.count > 0 {
+		if _, err := e.w.Write([]byte(",")); err != nil {
+			return err
+		}
+	}
+	e.count++
+	return e.enc.Encode(row)
+}
+
+func (e *jsonExporter) End() error {
+	_, err := e.w.Write([]byte("]\n"))
+	return err
+}
+
+// entryType names the kind of a file for exports
+func entryType(info storage.FileInfo) string {
+	switch {
+	case info.IsLink:
+		return "symlink"
+	case info.IsDir:
+		return "dir"
+	default:
+		return "file"
+	}
+}
+
+// ExportListing streams a directory listing as a CSV or JSON download
+func (h *FileHandlers) ExportListing(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	storageID := query.Get("storage")
+	dirPath := query.Get("path")
+	format := query.Get("format")
+	recursive := query.Get("recursive") == "true"
+	withChecksum := query.Get("checksum") == "true"
+	if dirPath == "" {
+		dirPath = "/"
+	}
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "json" {
+		errorResponse(w, fmt.Sprintf("Unsupported export format: %s", format), http.StatusBadRequest)
+		return
+	}
+
+	exclude, err := queryExcludes(query)
+	if err != nil {
+		errorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Get storage backend
+	fs, ok := h.storageManager.Get(storageID)
+	if !ok {
+		errorResponse(w, "Storage not found", http.StatusNotFound)
+		return
+	}
+
+	info, err := fs.Stat(dirPath)
+	if err != nil {
+		errorResponse(w, "Directory not found", http.StatusNotFound)
+		return
+	}
+	if !info.IsDir {
+		errorResponse(w, "Path is not a directory", http.StatusBadRequest)
+		return
+	}
+
+	var exporter listingExporter
+	contentType := "text/csv; charset=utf-8"
+	if format == "json" {
+		contentType = "application/json"
+		exporter = &jsonExporter{w: w, enc: json.NewEncoder(w)}
+	} else {
+		exporter = &csvExporter{w: csv.NewWriter(w), checksum: withChecksum}
+	}
+
+	name := path.Base(dirPath)
+	if name == "/" || name == "." {
+		name = storageID
+	}
+	w.Header().Set("Content-Type", contentType)
+	if recursive {
+		w.Header().Set("Trailer", exportTruncatedTrailer)
+	}
+	w.Header().Set("Content-Disposition", contentDisposition("attachment", name+"-listing."+format))
+
+	rc := http.NewResponseController(w)
+	rows := 0
+
+	emit := func(entry storage.FileInfo, entryPath string) error {
+		row := exportRow{
+			Name:    entry.Name,
+			Path:    entryPath,
+			Size:    entry.Size,
+			ModTime: entry.ModTime.UTC().Format(time.RFC3339),
+			Type:    entryType(entry),
+		}
+		if withChecksum && !entry.IsDir && !entry.IsLink {
+			sum, err := fileChecksum(fs, entryPath)
+			if err != nil {
+				log.Printf("Error checksumming %s for export: %v", entryPath, err)
+			}
+			row.Checksum = sum
+		}
+		if err := exporter.Write(row); err != nil {
+			return err
+		}
+
+		rows++
+		if rows%exportFlushEvery == 0 {
+			if csvExp, ok := exporter.(*csvExporter); ok {
+				csvExp.w.Flush()
+			}
+			_ = rc.Flush()
+		}
+		return nil
+	}
+
+	ctx := r.Context()
+	walk := func(dir string) error {
+		if !recursive {
+			return storage.ListFunc(fs, dir, func(entry storage.FileInfo) error {
+				if exclude.Excluded(entry.Name) {
+					return nil
+				}
+				return emit(entry, path.Join(dir, entry.Name))
+			})
+		}
+		return storage.WalkLimited(fs, dir, exportWalkLimits, func(entry storage.FileInfo) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if exclude.Excluded(entry.Name) {
+				return storage.SkipDir
+			}
+			return emit(entry, entry.Path)
+		})
+	}
+
+	if err := exporter.Begin(); err != nil {
+		log.Printf("Error writing listing export: %v", err)
+		return
+	}
+	err = walk(dirPath)
+	switch {
+	case errors.Is(err, storage.ErrWalkTruncated):
+		// Finish the document so what was exported can be read, and say
+		// in the trailer that it stops short
+		w.Header().Set(exportTruncatedTrailer, err.Error())
+	case err != nil:
+		// The download has already started, so the best we can do is
+		// leave it truncated
+		log.Printf("Error exporting listing of %s: %v", dirPath, err)
+		return
+	}
+	if err := exporter.End(); err != nil {
+		log.Printf("Error writing listing export: %v", err)
+	}
+}
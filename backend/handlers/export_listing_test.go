@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+func TestFileHandlers_ExportListing(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "docs", "sub"), 0755); err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+	files := map[string]string{
+		"docs/readme.txt":   "hello",
+		"docs/sub/data.csv": "a,b\n",
+		"top.txt":           "x",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+	}
+
+	mgr := storage.NewManager()
+	mgr.Register("local", storage.NewLocalStorage(root))
+	handler := NewFileHandlers(mgr)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/api/fs/export-listing", handler.ExportListing).Methods("GET")
+
+	export := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/fs/export-listing?storage=local&"+query, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("Recursive CSV", func(t *testing.T) {
+		rr := export("path=/&format=csv&recursive=true&checksum=true")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if !strings.Contains(rr.Header().Get("Content-Disposition"), "attachment") {
+			t.Errorf("Expected a download, got Content-Disposition %q", rr.Header().Get("Content-Disposition"))
+		}
+
+		records, err := csv.NewReader(rr.Body).ReadAll()
+		if err != nil {
+			t.Fatalf("Failed to parse CSV: %v", err)
+		}
+		if got := strings.Join(records[0], ","); got != "name,path,size,modtime,type,checksum" {
+			t.Errorf("Unexpected header: %s", got)
+		}
+
+		var rows []string
+		for _, record := range records[1:] {
+			rows = append(rows, record[1]+" "+record[2]+" "+record[4]+" "+record[5])
+		}
+		sort.Strings(rows)
+		expected := []string{
+			"/docs 0 dir ",
+			"/docs/readme.txt 5 file 2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824",
+			"/docs/sub 0 dir ",
+			"/docs/sub/data.csv 4 file 5be08c9684a1d25efcee09318204824278b08bbfb4aef973ffefd0b9d7478313",
+			"/top.txt 1 file 2d711642b726b04401627ca9fbac32f5c8530fb1903cc4db02258717921a4881",
+		}
+		// Directory sizes are backend specific, so only compare what matters
+		for i := range rows {
+			if strings.HasSuffix(expected[i], "dir ") {
+				fields := strings.Fields(rows[i])
+				rows[i] = fields[0] + " 0 dir "
+			}
+		}
+		if strings.Join(rows, "\n") != strings.Join(expected, "\n") {
+			t.Errorf("Unexpected rows:\n%s", strings.Join(rows, "\n"))
+		}
+	})
+
+	t.Run("Non-recursive JSON", func(t *testing.T) {
+		rr := export("path=/docs&format=json")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", rr.Code)
+		}
+
+		var rows []exportRow
+		if err := json.Unmarshal(rr.Body.Bytes(), &rows); err != nil {
+			t.Fatalf("Failed to parse JSON export: %v", err)
+		}
+		if len(rows) != 2 {
+			t.Errorf("Expected 2 rows, got %d", len(rows))
+		}
+	})
+
+	t.Run("Truncated", func(t *testing.T) {
+		defer func(limits storage.WalkLimits) { exportWalkLimits = limits }(exportWalkLimits)
+		exportWalkLimits = storage.WalkLimits{MaxEntries: 2}
+
+		rr := export("path=/&format=json&recursive=true")
+		var rows []exportRow
+		if err := json.Unmarshal(rr.Body.Bytes(), &rows); err != nil {
+			t.Fatalf("Expected the export to be finished, got %v: %s", err, rr.Body.String())
+		}
+		if len(rows) != 2 {
+			t.Errorf("Expected 2 rows, got %d", len(rows))
+		}
+		if reason := rr.Result().Trailer.Get("X-Export-Truncated"); !strings.Contains(reason, "more than 2 entries") {
+			t.Errorf("Expected the trailer to say why the export stopped, got %q", reason)
+		}
+	})
+
+	t.Run("Awkward directory name", func(t *testing.T) {
+		if err := os.Mkdir(filepath.Join(root, "a\"b;\r\nX-Bad: 1 é"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		rr := export("path=" + url.QueryEscape("/a\"b;\r\nX-Bad: 1 é"))
+		want := contentDisposition("attachment", "a\"b;\r\nX-Bad: 1 é-listing.csv")
+		if got := rr.Header().Get("Content-Disposition"); got != want || strings.ContainsAny(got, "\r\n") {
+			t.Errorf("Expected %q, got %q", want, got)
+		}
+	})
+
+	t.Run("Invalid format", func(t *testing.T) {
+		if rr := export("path=/&format=xml"); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400, got %d", rr.Code)
+		}
+	})
+}
@@ -1,12 +1,19 @@
 package handlers
 
 import (
+	"archive/zip"
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -14,8 +21,10 @@ import (
 	"github.com/jacommander/jacommander/backend/storage"
 )
 
-// mockFileSystem implements storage.FileSystem for testing
+// mockFileSystem implements storage.FileSystem for testing. Its methods
+// may be called concurrently, as batch operations do.
 type mockFileSystem struct {
+	mu    sync.Mutex
 	files map[string][]byte
 	dirs  map[string]bool
 }
@@ -29,6 +38,9 @@ func newMockFileSystem() *mockFileSystem {
 
 // FileSystem interface methods
 func (m *mockFileSystem) List(path string) ([]storage.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	var entries []storage.FileInfo
 
 	for filePath, content := range m.files {
@@ -51,6 +63,9 @@ func (m *mockFileSystem) List(path string) ([]storage.FileInfo, error) {
 }
 
 func (m *mockFileSystem) Stat(path string) (storage.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	if content, ok := m.files[path]; ok {
 		return storage.FileInfo{
 			Name:    path,
@@ -72,6 +87,9 @@ func (m *mockFileSystem) Stat(path string) (storage.FileInfo, error) {
 }
 
 func (m *mockFileSystem) Read(path string) (io.ReadCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	if content, ok := m.files[path]; ok {
 		return io.NopCloser(bytes.NewReader(content)), nil
 	}
@@ -83,11 +101,16 @@ func (m *mockFileSystem) Write(path string, data io.Reader) error {
 	if err != nil {
 		return err
 	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	m.files[path] = content
 	return nil
 }
 
 func (m *mockFileSystem) Delete(path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	if _, ok := m.files[path]; ok {
 		delete(m.files, path)
 		return nil
@@ -100,11 +123,17 @@ func (m *mockFileSystem) Delete(path string) error {
 }
 
 func (m *mockFileSystem) MkDir(path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	m.dirs[path] = true
 	return nil
 }
 
 func (m *mockFileSystem) Move(src, dst string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	if content, ok := m.files[src]; ok {
 		m.files[dst] = content
 		delete(m.files, src)
@@ -114,6 +143,9 @@ func (m *mockFileSystem) Move(src, dst string) error {
 }
 
 func (m *mockFileSystem) Copy(src, dst string, progress storage.ProgressCallback) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	if content, ok := m.files[src]; ok {
 		m.files[dst] = content
 		return nil
@@ -245,3 +277,381 @@ func TestFileHandlers_InvalidRequests(t *testing.T) {
 //
 // The old test file had extensive tests but was written for a different API.
 // This version provides basic smoke tests to ensure handlers compile and respond.
+
+func TestFileHandlers_BrowseArchive(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range map[string]string{
+		"app/index.html":          "<html></html>",
+		"app/assets/img/logo.svg": "<svg/>",
+		"app/assets/app.js":       "console.log(1)",
+	} {
+		w, _ := zw.Create(name)
+		_, _ = w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Failed to build zip: %v", err)
+	}
+
+	// The mock has no random access, so this also covers spooling
+	mockFS := newMockFileSystem()
+	mockFS.files["/bundle.zip"] = buf.Bytes()
+	mgr := storage.NewManager()
+	mgr.Register("mock", mockFS)
+	handler := NewFileHandlers(mgr)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/api/fs/list", handler.ListDirectory).Methods("GET")
+	router.HandleFunc("/api/fs/download", handler.DownloadFile).Methods("GET")
+
+	t.Run("List nested directory", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/fs/list?storage=mock&path=/bundle.zip!/app/assets", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		var resp struct {
+			Data struct {
+				Path  string             `json:"path"`
+				Files []storage.FileInfo `json:"files"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Failed to decode listing (%d): %v", rr.Code, err)
+		}
+		if resp.Data.Path != "/bundle.zip!/app/assets" || len(resp.Data.Files) != 2 {
+			t.Fatalf("Unexpected listing: %+v", resp.Data)
+		}
+		if f := resp.Data.Files[0]; f.Name != "app.js" || f.IsDir || f.Path != "/bundle.zip!/app/assets/app.js" {
+			t.Errorf("Unexpected first entry: %+v", f)
+		}
+		if f := resp.Data.Files[1]; f.Name != "img" || !f.IsDir {
+			t.Errorf("Unexpected second entry: %+v", f)
+		}
+	})
+
+	t.Run("Download entry", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/fs/download?storage=mock&path=/bundle.zip!/app/assets/img/logo.svg", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK || rr.Body.String() != "<svg/>" {
+			t.Errorf("Unexpected download: %d %q", rr.Code, rr.Body.String())
+		}
+		if !strings.Contains(rr.Header().Get("Content-Disposition"), "logo.svg") {
+			t.Errorf("Unexpected Content-Disposition: %s", rr.Header().Get("Content-Disposition"))
+		}
+	})
+}
+
+func TestFileHandlers_DownloadDisposition(t *testing.T) {
+	mockFS := newMockFileSystem()
+	mockFS.files["/report.pdf"] = []byte("%PDF-1.4")
+	mockFS.files["/page.html"] = []byte("<script>alert(1)</script>")
+	mockFS.files["/résumé.pdf"] = []byte("%PDF-1.4")
+	mgr := storage.NewManager()
+	mgr.Register("mock", mockFS)
+	handler := NewFileHandlers(mgr)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/api/fs/download", handler.DownloadFile).Methods("GET")
+
+	download := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/fs/download?storage=mock&"+query, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	tests := []struct {
+		name        string
+		query       string
+		disposition string
+		contentType string
+	}{
+		{"Default is attachment", "path=/report.pdf", `attachment; filename="report.pdf"`, "application/pdf"},
+		{"Inline PDF", "path=/report.pdf&disposition=inline", `inline; filename="report.pdf"`, "application/pdf"},
+		{"Inline HTML is refused", "path=/page.html&disposition=inline", `attachment; filename="page.html"`, "text/html; charset=utf-8"},
+		{"Non-ASCII name", "path=/r%C3%A9sum%C3%A9.pdf&disposition=inline", `inline; filename="r_sum_.pdf"; filename*=UTF-8''r%C3%A9sum%C3%A9.pdf`, "application/pdf"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := download(tt.query)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+			}
+			if got := rr.Header().Get("Content-Disposition"); got != tt.disposition {
+				t.Errorf("Content-Disposition = %s, want %s", got, tt.disposition)
+			}
+			if got := rr.Header().Get("Content-Type"); got != tt.contentType {
+				t.Errorf("Content-Type = %s, want %s", got, tt.contentType)
+			}
+		})
+	}
+
+	t.Run("Unsafe inline when allowed", func(t *testing.T) {
+		handler.SetAllowUnsafeInline(true)
+		defer handler.SetAllowUnsafeInline(false)
+
+		rr := download("path=/page.html&disposition=inline")
+		if got := rr.Header().Get("Content-Disposition"); got != `inline; filename="page.html"` {
+			t.Errorf("Expected inline HTML with override, got %s", got)
+		}
+	})
+
+	t.Run("Invalid disposition", func(t *testing.T) {
+		if rr := download("path=/report.pdf&disposition=embed"); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400, got %d", rr.Code)
+		}
+	})
+}
+
+// serverSideFS is a mock storage that copies from other mock storages
+// itself, recording each server-side copy
+type serverSideFS struct {
+	*mockFileSystem
+	copied []string
+}
+
+func (s *serverSideFS) CopyFrom(src storage.FileSystem, srcPath, dstPath string) error {
+	other, ok := storage.As[*serverSideFS](src)
+	if !ok {
+		return storage.ErrNotSupported
+	}
+	other.mu.Lock()
+	content, ok := other.files[srcPath]
+	other.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("not found: %s", srcPath)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[dstPath] = content
+	s.copied = append(s.copied, srcPath)
+	return nil
+}
+
+func TestFileHandlers_CopyServerSide(t *testing.T) {
+	src := &serverSideFS{mockFileSystem: newMockFileSystem()}
+	src.files["/report.pdf"] = []byte("report")
+	src.dirs["/photos"] = true
+	src.files["/photos/cat.jpg"] = []byte("cat")
+	dst := &serverSideFS{mockFileSystem: newMockFileSystem()}
+	plain := newMockFileSystem()
+	plain.files["/notes.txt"] = []byte("notes")
+
+	mgr := storage.NewManager()
+	mgr.Register("bucket-a", src)
+	mgr.Register("bucket-b", dst)
+	mgr.Register("local", plain)
+	handler := NewFileHandlers(mgr)
+
+	copyFiles := func(srcStorage, srcPath string, files ...string) {
+		t.Helper()
+		body, _ := json.Marshal(map[string]interface{}{
+			"src_storage": srcStorage, "dst_storage": "bucket-b",
+			"src_path": srcPath, "dst_path": "/backup", "files": files,
+		})
+		rr := httptest.NewRecorder()
+		handler.CopyFiles(rr, httptest.NewRequest("POST", "/api/fs/copy", bytes.NewReader(body)))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+	}
+
+	copyFiles("bucket-a", "/", "report.pdf", "photos")
+	// The files are copied in parallel, so in no particular order
+	sort.Strings(dst.copied)
+	if strings.Join(dst.copied, ",") != "/photos/cat.jpg,/report.pdf" {
+		t.Errorf("Expected both files copied server-side, got %v", dst.copied)
+	}
+	if string(dst.files["/backup/photos/cat.jpg"]) != "cat" {
+		t.Errorf("Expected the directory's file at the destination, got %v", dst.files)
+	}
+
+	t.Run("Falls back to streaming", func(t *testing.T) {
+		dst.copied = nil
+		copyFiles("local", "/", "notes.txt")
+		if len(dst.copied) != 0 {
+			t.Errorf("Expected no server-side copy from another provider, got %v", dst.copied)
+		}
+		if string(dst.files["/backup/notes.txt"]) != "notes" {
+			t.Errorf("Expected the file streamed to the destination, got %v", dst.files)
+		}
+	})
+}
+
+// exportingFS lists its documents without a size and exports them on
+// read, like Google Drive
+type exportingFS struct {
+	*mockFileSystem
+}
+
+func (e *exportingFS) Stat(path string) (storage.FileInfo, error) {
+	info, err := e.mockFileSystem.Stat(path)
+	info.Size = 0
+	return info, err
+}
+
+func (e *exportingFS) ExactSize(info storage.FileInfo) bool {
+	return false
+}
+
+// sizedFS writes as many bytes as it is told to, like OneDrive
+type sizedFS struct {
+	*mockFileSystem
+}
+
+func (s *sizedFS) WriteSized(path string, data io.Reader, size int64, progress storage.ProgressCallback) error {
+	return s.Write(path, io.LimitReader(data, size))
+}
+
+func TestFileHandlers_CopyExportedFile(t *testing.T) {
+	src := &exportingFS{mockFileSystem: newMockFileSystem()}
+	src.files["/report.gdoc"] = []byte("exported report")
+	dst := &sizedFS{mockFileSystem: newMockFileSystem()}
+
+	mgr := storage.NewManager()
+	mgr.Register("gdrive", src)
+	mgr.Register("onedrive", dst)
+	handler := NewFileHandlers(mgr)
+
+	body := `{"src_storage": "gdrive", "dst_storage": "onedrive", "src_path": "/", "dst_path": "/", "files": ["report.gdoc"]}`
+	rr := httptest.NewRecorder()
+	handler.CopyFiles(rr, httptest.NewRequest("POST", "/api/fs/copy", strings.NewReader(body)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if got := string(dst.files["/report.gdoc"]); got != "exported report" {
+		t.Errorf("Expected the whole export to be copied, got %q", got)
+	}
+}
+
+func TestFileHandlers_MovePruneEmptyDirs(t *testing.T) {
+	srcRoot, dstRoot := t.TempDir(), t.TempDir()
+	for _, dir := range []string{"archive/2024/q1/sub", "old/full", "old/empty", "kept/dir"} {
+		if err := os.MkdirAll(filepath.Join(srcRoot, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range []string{"archive/2024/q1/a.txt", "archive/2024/q1/sub/b.txt", "old/full/c.txt", "kept/dir/d.txt"} {
+		if err := os.WriteFile(filepath.Join(srcRoot, file), []byte(file), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mgr := storage.NewManager()
+	mgr.Register("src", storage.NewLocalStorage(srcRoot))
+	mgr.Register("dst", storage.NewLocalStorage(dstRoot))
+	handler := NewFileHandlers(mgr)
+	router := mux.NewRouter()
+	router.HandleFunc("/api/fs/move", handler.MoveFiles).Methods("POST")
+
+	move := func(body string) []string {
+		req := httptest.NewRequest("POST", "/api/fs/move", strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var resp struct {
+			Data struct {
+				Pruned []string `json:"pruned"`
+			} `json:"data"`
+		}
+		_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+		return resp.Data.Pruned
+	}
+	exists := func(rel string) bool {
+		_, err := os.Stat(filepath.Join(srcRoot, rel))
+		return err == nil
+	}
+
+	pruned := move(`{"src_storage":"src","dst_storage":"dst","src_path":"/archive/2024/q1","dst_path":"/","files":["a.txt","sub"],"prune_empty_dirs":true}`)
+	if want := []string{"/archive/2024/q1", "/archive/2024", "/archive"}; fmt.Sprint(pruned) != fmt.Sprint(want) {
+		t.Errorf("Expected %v pruned, got %v", want, pruned)
+	}
+	if exists("archive") {
+		t.Error("Expected the emptied source tree to be gone")
+	}
+	if _, err := os.Stat(filepath.Join(dstRoot, "sub", "b.txt")); err != nil {
+		t.Errorf("Expected the moved tree at the destination: %v", err)
+	}
+
+	// A directory that was already empty keeps its parent in place
+	move(`{"src_storage":"src","dst_storage":"src","src_path":"/old/full","dst_path":"/","files":["c.txt"],"prune_empty_dirs":true}`)
+	if exists("old/full") || !exists("old/empty") {
+		t.Error("Expected /old/full pruned and the pre-existing /old/empty kept")
+	}
+
+	// Without the option nothing is pruned
+	if pruned := move(`{"src_storage":"src","dst_storage":"dst","src_path":"/kept/dir","dst_path":"/","files":["d.txt"]}`); pruned != nil {
+		t.Errorf("Expected no pruning, got %v", pruned)
+	}
+	if !exists("kept/dir") {
+		t.Error("Expected /kept/dir to stay without prune_empty_dirs")
+	}
+}
+
+func TestFileHandlers_UploadUniqueNames(t *testing.T) {
+	root := t.TempDir()
+	mgr := storage.NewManager()
+	mgr.Register("local", storage.NewLocalStorage(root))
+	handler := NewFileHandlers(mgr)
+
+	type result struct {
+		Filename     string `json:"filename"`
+		OriginalName string `json:"original_name"`
+		Path         string `json:"path"`
+	}
+	upload := func(name, content string) result {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		_ = mw.WriteField("storage", "local")
+		_ = mw.WriteField("path", "/drop")
+		_ = mw.WriteField("unique_names", "true")
+		part, _ := mw.CreateFormFile("file", name)
+		_, _ = part.Write([]byte(content))
+		_ = mw.Close()
+
+		req := httptest.NewRequest("POST", "/api/fs/upload", &body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		rr := httptest.NewRecorder()
+		handler.UploadFile(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Upload failed: %d %s", rr.Code, rr.Body.String())
+		}
+		var resp struct {
+			Data result `json:"data"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return resp.Data
+	}
+
+	first := upload("report.PDF", "one")
+	second := upload("report.PDF", "two")
+	if first.Path == second.Path {
+		t.Fatalf("Expected distinct stored names, both got %s", first.Path)
+	}
+	for content, r := range map[string]result{"one": first, "two": second} {
+		if r.OriginalName != "report.PDF" || !strings.HasSuffix(r.Filename, ".pdf") || strings.Contains(r.Filename, "report") {
+			t.Errorf("Unexpected names %+v", r)
+		}
+		if r.Path != "/drop/"+r.Filename {
+			t.Errorf("Expected the path to use the stored name, got %+v", r)
+		}
+		data, err := os.ReadFile(filepath.Join(root, r.Path))
+		if err != nil || string(data) != content {
+			t.Errorf("Expected %s to hold %q, got %q, %v", r.Path, content, data, err)
+		}
+	}
+
+	// Nothing of an untrusted name but a cleaned extension is kept
+	if r := upload(`..\evil name.<sc>"`, "x"); strings.ContainsAny(r.Filename, `\ <>"`) || strings.Contains(r.Filename, "evil") {
+		t.Errorf("Expected a clean generated name, got %q", r.Filename)
+	}
+}
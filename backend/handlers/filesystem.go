@@ -1,13 +1,20 @@
 package handlers
 
 import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"mime"
 	"net/http"
+	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/jacommander/jacommander/backend/storage"
 )
@@ -15,15 +22,55 @@ import (
 // FileHandlers handles all file operation HTTP requests
 type FileHandlers struct {
 	storageManager *storage.Manager
+
+	// allowUnsafeInline lets downloads of active content (HTML, SVG, ...)
+	// be served inline when asked for
+	allowUnsafeInline bool
+
+	// sniffed caches the content checks made for detect_mime
+	sniffed *sniffCache
+
+	// systemFiles matches the entries hide_system_files leaves out
+	systemFiles *storage.ExcludeFilter
+
+	// presignMaxExpiry and presignMaxSize bound presigned uploads
+	presignMaxExpiry time.Duration
+	presignMaxSize   int64
+
+	// clipboards holds each client's cut or copied selection
+	clipboards *clipboardStore
+
+	// batchConcurrency is how many files of one copy, move or delete
+	// request are handled at once
+	batchConcurrency int
+
+	// maxTreeDepth is the deepest tree /fs/tree returns
+	maxTreeDepth int
 }
 
 // NewFileHandlers creates a new FileHandlers instance
 func NewFileHandlers(manager *storage.Manager) *FileHandlers {
+	systemFiles, _ := storage.NewExcludeFilter(DefaultSystemFilePatterns)
 	return &FileHandlers{
 		storageManager: manager,
+		sniffed:        newSniffCache(),
+		systemFiles:    systemFiles,
+
+		presignMaxExpiry: DefaultPresignMaxExpiry,
+		presignMaxSize:   DefaultPresignMaxSize,
+		clipboards:       newClipboardStore(),
+		batchConcurrency: DefaultBatchConcurrency,
+		maxTreeDepth:     DefaultMaxTreeDepth,
 	}
 }
 
+// SetAllowUnsafeInline allows disposition=inline for content types that
+// can run script in the browser. Only enable this when the storages hold
+// trusted content.
+func (h *FileHandlers) SetAllowUnsafeInline(allow bool) {
+	h.allowUnsafeInline = allow
+}
+
 // ListStorages returns all available storage backends
 func (h *FileHandlers) ListStorages(w http.ResponseWriter, r *http.Request) {
 	storages := h.storageManager.GetAll()
@@ -32,13 +79,20 @@ func (h *FileHandlers) ListStorages(w http.ResponseWriter, r *http.Request) {
 	for id, fs := range storages {
 		available, total, _ := fs.GetAvailableSpace()
 
-		result = append(result, map[string]interface{}{
+		entry := map[string]interface{}{
 			"id":        id,
 			"type":      fs.GetType(),
 			"root_path": fs.GetRootPath(),
 			"available": available,
 			"total":     total,
-		})
+			"read_only": isReadOnly(fs),
+		}
+		// Object stores that add up their objects know what they hold
+		// without a total to subtract from
+		if used, ok := storage.Usage(fs); ok {
+			entry["used"] = used
+		}
+		result = append(result, entry)
 	}
 
 	successResponse(w, result)
@@ -50,10 +104,31 @@ func (h *FileHandlers) ListDirectory(w http.ResponseWriter, r *http.Request) {
 	storageID := r.URL.Query().Get("storage")
 	path := r.URL.Query().Get("path")
 	calcSizes := r.URL.Query().Get("calc_sizes") == "true"
+	detectMIME := r.URL.Query().Get("detect_mime") == "true"
+	hideSystemFiles := r.URL.Query().Get("hide_system_files") == "true"
+	dirsOnly := r.URL.Query().Get("dirs_only") == "true"
+	followLinks := r.URL.Query().Get("follow_links") == "true"
+	showOwner := r.URL.Query().Get("with_owner") == "true"
 	if path == "" {
 		path = "/"
 	}
 
+	fields, err := parseFieldProjection(r.URL.Query().Get("fields"))
+	if err != nil {
+		errorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	page, err := parseListingPage(r.URL.Query())
+	if err != nil {
+		errorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	hashes, err := parseListingHash(r.URL.Query())
+	if err != nil {
+		errorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Get storage backend
 	fs, ok := h.storageManager.Get(storageID)
 	if !ok {
@@ -61,37 +136,157 @@ func (h *FileHandlers) ListDirectory(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	fs = archiveView(fs, path)
+
+	var links *linkFollower
+	if followLinks {
+		links, err = newLinkFollower(fs, path)
+		if errors.Is(err, errLinkOutsideRoot) {
+			errorResponse(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			storageErrorResponse(w, fmt.Sprintf("Failed to list directory: %v", err), err)
+			return
+		}
+	}
+
+	// keep drops entries the listing options leave out
+	keep := func(info storage.FileInfo) (storage.FileInfo, bool) {
+		info = links.follow(info)
+		if dirsOnly && !info.IsDir {
+			return info, false
+		}
+		if hideSystemFiles && h.isSystemFile(info) {
+			return info, false
+		}
+		return info, true
+	}
+	// fill adds what's read from the content and metadata of a kept entry
+	fill := func(info storage.FileInfo) storage.FileInfo {
+		if detectMIME {
+			info = h.withSniffedContent(fs, storageID, info.Path, info)
+		}
+		if showOwner {
+			info = withOwner(fs, info)
+		}
+		return info
+	}
+
+	list := storage.ListFunc
+	// Listing only directories skips symlinks, which may be followed
+	if dirsOnly && links == nil {
+		list = storage.ListDirsFunc
+	}
+
+	// Plain listings are streamed so huge directories aren't buffered
+	if !calcSizes && page == nil && hashes == nil {
+		h.streamDirectory(w, fs, path, list, fields, func(info storage.FileInfo) (storage.FileInfo, bool) {
+			info, ok := keep(info)
+			if ok {
+				info = fill(info)
+			}
+			return info, ok
+		})
+		return
+	}
+
 	// List directory
 	var files []storage.FileInfo
-	var err error
-
-	// Check if storage supports directory size calculation
 	if calcSizes {
-		if localFS, ok := fs.(*storage.LocalStorage); ok {
+		// Check if storage supports directory size calculation
+		if localFS, ok := storage.As[*storage.LocalStorage](fs); ok {
 			files, err = localFS.ListWithDirSizes(path)
 		} else {
 			// Fallback to regular list for other storage types
 			files, err = fs.List(path)
 		}
+		kept := files[:0]
+		for _, file := range files {
+			if file, ok := keep(file); ok {
+				kept = append(kept, file)
+			}
+		}
+		files = kept
 	} else {
-		files, err = fs.List(path)
+		err = list(fs, path, func(info storage.FileInfo) error {
+			if info, ok := keep(info); ok {
+				files = append(files, info)
+			}
+			return nil
+		})
 	}
 
 	if err != nil {
-		errorResponse(w, fmt.Sprintf("Failed to list directory: %v", err), http.StatusInternalServerError)
+		storageErrorResponse(w, fmt.Sprintf("Failed to list directory: %v", err), err)
 		return
 	}
 
+	// Sorting and slicing happen on the whole listing, however the backend
+	// paged it, and only the entries returned have their content sniffed
+	// or hashed
+	count := len(files)
+	hasMore := false
+	if page != nil {
+		files, count, hasMore = page.apply(files)
+	}
+
+	if hashes != nil {
+		hashes.apply(r.Context(), fs, path, files)
+	}
+
 	// Get space information
 	available, total, _ := fs.GetAvailableSpace()
 
-	successResponse(w, map[string]interface{}{
+	entries := make([]interface{}, 0, len(files))
+	for _, file := range files {
+		entries = append(entries, fields.apply(fill(file)))
+	}
+
+	data := map[string]interface{}{
 		"path":      path,
-		"files":     files,
-		"count":     len(files),
+		"files":     entries,
+		"count":     len(entries),
 		"available": available,
 		"total":     total,
-	})
+	}
+	if page != nil {
+		// total is taken by the storage's size, so the entry count has
+		// its own name
+		data["total_entries"] = count
+		data["has_more"] = hasMore
+	}
+	if hashes != nil {
+		data["hash_algo"] = hashes.algo
+	}
+	successResponse(w, data)
+}
+
+// streamDirectory writes the entries list produces as they come, passing
+// each through prepare first and leaving out those it rejects
+func (h *FileHandlers) streamDirectory(w http.ResponseWriter, fs storage.FileSystem, path string, list func(storage.FileSystem, string, func(storage.FileInfo) error) error, fields *fieldProjection, prepare func(storage.FileInfo) (storage.FileInfo, bool)) {
+	stream := newListingStream(w, path, fields)
+
+	add := func(info storage.FileInfo) error {
+		if info, keep := prepare(info); keep {
+			return stream.Add(info)
+		}
+		return nil
+	}
+	if err := list(fs, path, add); err != nil {
+		if !stream.Started() {
+			storageErrorResponse(w, fmt.Sprintf("Failed to list directory: %v", err), err)
+			return
+		}
+		// Headers are already sent; leave the JSON unterminated so the
+		// client sees a broken response instead of a silently short one
+		log.Printf("Error streaming directory listing %s: %v", path, err)
+		return
+	}
+
+	// Get space information
+	available, total, _ := fs.GetAvailableSpace()
+	stream.Finish(available, total)
 }
 
 // CreateDirectory creates a new directory
@@ -116,7 +311,7 @@ func (h *FileHandlers) CreateDirectory(w http.ResponseWriter, r *http.Request) {
 
 	// Create directory
 	if err := fs.MkDir(req.Path); err != nil {
-		errorResponse(w, fmt.Sprintf("Failed to create directory: %v", err), http.StatusInternalServerError)
+		storageErrorResponse(w, fmt.Sprintf("Failed to create directory: %v", err), err)
 		return
 	}
 
@@ -130,11 +325,17 @@ func (h *FileHandlers) CreateDirectory(w http.ResponseWriter, r *http.Request) {
 func (h *FileHandlers) CopyFiles(w http.ResponseWriter, r *http.Request) {
 	// Parse request body
 	var req struct {
-		SrcStorage string   `json:"src_storage"`
-		DstStorage string   `json:"dst_storage"`
-		Files      []string `json:"files"`
-		SrcPath    string   `json:"src_path"`
-		DstPath    string   `json:"dst_path"`
+		SrcStorage string    `json:"src_storage"`
+		DstStorage string    `json:"dst_storage"`
+		Files      []string  `json:"files"`
+		SrcPath    string    `json:"src_path"`
+		DstPath    string    `json:"dst_path"`
+		Dedupe     bool      `json:"dedupe"`
+		Exclude    *[]string `json:"exclude"`
+
+		// OnConflict says what to do with a destination that exists:
+		// overwrite (the default), skip, rename to "name (n).ext", or fail
+		OnConflict string `json:"on_conflict"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -142,6 +343,17 @@ func (h *FileHandlers) CopyFiles(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	exclude, err := resolveExcludes(req.Exclude)
+	if err != nil {
+		errorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	onConflict, err := resolveConflictPolicy(req.OnConflict)
+	if err != nil {
+		errorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Get storage backends
 	srcFS, ok := h.storageManager.Get(req.SrcStorage)
 	if !ok {
@@ -155,116 +367,207 @@ func (h *FileHandlers) CopyFiles(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// If same storage backend, use native copy
-	if req.SrcStorage == req.DstStorage {
+	// With dedupe, files whose destination already has identical content
+	// are left alone
+	deduplicated := []string{}
+	files := req.Files
+	if req.Dedupe {
+		files = nil
 		for _, file := range req.Files {
-			srcPath := filepath.Join(req.SrcPath, file)
-			dstPath := filepath.Join(req.DstPath, file)
-
-			if err := srcFS.Copy(srcPath, dstPath, nil); err != nil {
-				errorResponse(w, fmt.Sprintf("Failed to copy %s: %v", file, err), http.StatusInternalServerError)
-				return
+			same, err := filesIdentical(srcFS, filepath.Join(req.SrcPath, file), dstFS, filepath.Join(req.DstPath, file))
+			if err != nil {
+				log.Printf("Error comparing %s for dedupe: %v", file, err)
+			}
+			if same {
+				deduplicated = append(deduplicated, file)
+			} else {
+				files = append(files, file)
 			}
 		}
-	} else {
-		// Cross-storage copy: read from source, write to destination
-		for _, file := range req.Files {
-			srcPath := filepath.Join(req.SrcPath, file)
-			dstPath := filepath.Join(req.DstPath, file)
+	}
 
-			// Check if source is directory
-			srcInfo, err := srcFS.Stat(srcPath)
-			if err != nil {
-				errorResponse(w, fmt.Sprintf("Failed to stat %s: %v", file, err), http.StatusInternalServerError)
-				return
-			}
+	// Native copies within one storage; otherwise read from the source
+	// and write to the destination
+	reports := make([]conflictReport, len(files))
+	dests := newBatchDestinations(req.DstPath, files)
+	copyFile := func(i int, file string) error {
+		srcPath := filepath.Join(req.SrcPath, file)
+		dstPath, action, err := resolveConflict(srcFS, srcPath, dstFS, filepath.Join(req.DstPath, file), onConflict, dests, i)
+		if err != nil {
+			return err
+		}
+		reports[i] = conflictReport{File: file, Action: action, Destination: dstPath}
+		if action == actionSkipped {
+			return nil
+		}
 
-			if srcInfo.IsDir {
-				// For directories, we need recursive copy
-				if err := h.copyDirectoryCrossStorage(srcFS, dstFS, srcPath, dstPath); err != nil {
-					errorResponse(w, fmt.Sprintf("Failed to copy directory %s: %v", file, err), http.StatusInternalServerError)
-					return
-				}
-			} else {
-				// For files, simple read and write
-				reader, err := srcFS.Read(srcPath)
-				if err != nil {
-					errorResponse(w, fmt.Sprintf("Failed to read %s: %v", file, err), http.StatusInternalServerError)
-					return
-				}
-				defer func() {
-					if err := reader.Close(); err != nil {
-						log.Printf("Error closing reader: %v", err)
-					}
-				}()
-
-				if err := dstFS.Write(dstPath, reader); err != nil {
-					errorResponse(w, fmt.Sprintf("Failed to write %s: %v", file, err), http.StatusInternalServerError)
-					return
+		if req.SrcStorage == req.DstStorage {
+			// Native copies take whole trees, so directories are walked
+			// here when some of their entries must be left out
+			if !exclude.Empty() {
+				if info, err := srcFS.Stat(srcPath); err == nil && info.IsDir {
+					return h.copyDirectoryCrossStorage(srcFS, srcFS, srcPath, dstPath, exclude)
 				}
 			}
+			report, err := h.copyNative(srcFS, srcPath, dstPath, onConflict, dests, i)
+			if report != nil {
+				report.File = file
+				reports[i] = *report
+			}
+			return err
 		}
+
+		srcInfo, err := srcFS.Stat(srcPath)
+		if err != nil {
+			return err
+		}
+		if srcInfo.IsDir {
+			return h.copyDirectoryCrossStorage(srcFS, dstFS, srcPath, dstPath, exclude)
+		}
+		return copyFileCrossStorage(srcFS, dstFS, srcPath, dstPath)
+	}
+	if batchFailed(w, "copy", "copied", files, h.runBatch(files, copyFile, srcFS, dstFS)) {
+		return
 	}
 
-	successResponse(w, map[string]interface{}{
+	result := map[string]interface{}{
 		"message": "Files copied successfully",
-		"count":   len(req.Files),
-	})
+		"count":   countCopied(reports),
+		"results": reports,
+	}
+	if req.Dedupe {
+		result["deduplicated"] = deduplicated
+	}
+	successResponse(w, result)
 }
 
-// copyDirectoryCrossStorage recursively copies a directory across different storage backends
-func (h *FileHandlers) copyDirectoryCrossStorage(srcFS, dstFS storage.FileSystem, srcPath, dstPath string) error {
+// copyDirectoryCrossStorage recursively copies a directory across different storage backends,
+// skipping excluded entries
+func (h *FileHandlers) copyDirectoryCrossStorage(srcFS, dstFS storage.FileSystem, srcPath, dstPath string, exclude *storage.ExcludeFilter) error {
 	// Create destination directory
-	if err := dstFS.MkDir(dstPath); err != nil {
+	if err := ensureDir(dstFS, dstPath); err != nil {
 		return err
 	}
 
-	// List source directory
-	files, err := srcFS.List(srcPath)
+	return storage.Walk(srcFS, srcPath, func(file storage.FileInfo) error {
+		if exclude.Excluded(file.Name) {
+			return storage.SkipDir
+		}
+
+		rel, err := filepath.Rel(srcPath, file.Path)
+		if err != nil {
+			return err
+		}
+		dstFilePath := filepath.Join(dstPath, rel)
+
+		if file.IsDir && !file.IsLink {
+			return ensureDir(dstFS, dstFilePath)
+		}
+		return copyFileCrossStorage(srcFS, dstFS, file.Path, dstFilePath)
+	})
+}
+
+// ensureDir creates a directory, accepting one that's already there so a
+// copy can merge into it. Backends differ on whether creating an existing
+// directory is an error.
+func ensureDir(fs storage.FileSystem, dirPath string) error {
+	err := fs.MkDir(dirPath)
+	if err == nil {
+		return nil
+	}
+	if info, serr := fs.Stat(dirPath); serr == nil && info.IsDir {
+		return nil
+	}
+	return err
+}
+
+// copyFileCrossStorage copies one file from srcFS to dstFS, server-side
+// when the destination can fetch it from the source itself, and by
+// streaming it through here otherwise
+func copyFileCrossStorage(srcFS, dstFS storage.FileSystem, srcPath, dstPath string) error {
+	if copier, ok := storage.As[storage.ServerSideCopier](dstFS); ok {
+		err := copier.CopyFrom(srcFS, srcPath, dstPath)
+		if !errors.Is(err, storage.ErrNotSupported) {
+			return err
+		}
+	}
+
+	reader, err := srcFS.Read(srcPath)
 	if err != nil {
 		return err
 	}
+	defer func() {
+		if err := reader.Close(); err != nil {
+			log.Printf("Error closing reader in copyDirectoryCrossStorage: %v", err)
+		}
+	}()
 
-	// Copy each item
-	for _, file := range files {
-		srcFilePath := filepath.Join(srcPath, file.Name)
-		dstFilePath := filepath.Join(dstPath, file.Name)
+	// Backends that need the size up front can then stream the copy, as
+	// long as the source reads back at the size it reports
+	if _, ok := storage.As[storage.SizedWriter](dstFS); ok {
+		if info, err := srcFS.Stat(srcPath); err == nil && !info.IsDir && exactSize(srcFS, info) {
+			return storage.WriteSized(dstFS, dstPath, reader, info.Size, nil)
+		}
+	}
+	return dstFS.Write(dstPath, reader)
+}
 
-		if file.IsDir {
-			// Recursive copy for subdirectories
-			if err := h.copyDirectoryCrossStorage(srcFS, dstFS, srcFilePath, dstFilePath); err != nil {
-				return err
-			}
-		} else {
-			// Copy file
-			reader, err := srcFS.Read(srcFilePath)
-			if err != nil {
-				return err
-			}
-			defer func() {
-				if err := reader.Close(); err != nil {
-					log.Printf("Error closing reader in copyDirectoryCrossStorage: %v", err)
-				}
-			}()
+// exactSize reports whether reading info from fs returns info.Size bytes
+func exactSize(fs storage.FileSystem, info storage.FileInfo) bool {
+	if sizer, ok := storage.As[storage.ExactSizer](fs); ok {
+		return sizer.ExactSize(info)
+	}
+	return true
+}
 
-			if err := dstFS.Write(dstFilePath, reader); err != nil {
-				return err
-			}
-		}
+// copyNative copies srcPath to dstPath within fs, asking the backend not
+// to replace anything at dstPath. Where it can refuse, a destination that
+// turned up since the conflict was resolved is handled by policy here, and
+// a directory copied onto another is merged into it entry by entry rather
+// than replacing it. A new name is claimed in dests for file i. It returns
+// a new report when the outcome changed.
+func (h *FileHandlers) copyNative(fs storage.FileSystem, srcPath, dstPath, policy string, dests *batchDestinations, i int) (*conflictReport, error) {
+	err := storage.CopyNoOverwrite(fs, srcPath, dstPath, nil)
+	if !errors.Is(err, os.ErrExist) {
+		return nil, err
 	}
 
-	return nil
+	src, serr := fs.Stat(srcPath)
+	dst, derr := fs.Stat(dstPath)
+	switch {
+	case serr != nil || derr != nil:
+		return nil, err
+	case policy == conflictOverwrite && src.IsDir && dst.IsDir:
+		return nil, h.copyDirectoryCrossStorage(fs, fs, srcPath, dstPath, nil)
+	case policy == conflictSkip:
+		return &conflictReport{Action: actionSkipped}, nil
+	case policy == conflictRename:
+		free, ferr := dests.rename(fs, dstPath, i)
+		if ferr != nil {
+			return nil, ferr
+		}
+		return &conflictReport{Action: actionRenamed, Destination: free}, storage.CopyNoOverwrite(fs, srcPath, free, nil)
+	}
+	return nil, err
 }
 
 // MoveFiles moves files from source to destination
 func (h *FileHandlers) MoveFiles(w http.ResponseWriter, r *http.Request) {
 	// Parse request body
 	var req struct {
-		SrcStorage string   `json:"src_storage"`
-		DstStorage string   `json:"dst_storage"`
-		Files      []string `json:"files"`
-		SrcPath    string   `json:"src_path"`
-		DstPath    string   `json:"dst_path"`
+		SrcStorage string    `json:"src_storage"`
+		DstStorage string    `json:"dst_storage"`
+		Files      []string  `json:"files"`
+		SrcPath    string    `json:"src_path"`
+		DstPath    string    `json:"dst_path"`
+		Exclude    *[]string `json:"exclude"`
+
+		// PruneEmptyDirs removes src_path, and then its parents, when the
+		// move leaves them empty
+		PruneEmptyDirs bool `json:"prune_empty_dirs"`
+
+		// OnConflict is as for CopyFiles; skipped files stay at the source
+		OnConflict string `json:"on_conflict"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -272,6 +575,17 @@ func (h *FileHandlers) MoveFiles(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	exclude, err := resolveExcludes(req.Exclude)
+	if err != nil {
+		errorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	onConflict, err := resolveConflictPolicy(req.OnConflict)
+	if err != nil {
+		errorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Get storage backends
 	srcFS, ok := h.storageManager.Get(req.SrcStorage)
 	if !ok {
@@ -285,80 +599,95 @@ func (h *FileHandlers) MoveFiles(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// If same storage backend, use native move
-	if req.SrcStorage == req.DstStorage {
-		for _, file := range req.Files {
-			srcPath := filepath.Join(req.SrcPath, file)
-			dstPath := filepath.Join(req.DstPath, file)
+	// Cross-storage moves copy each file and then delete its source
+	if req.SrcStorage != req.DstStorage && isReadOnly(srcFS) {
+		// The sources couldn't be deleted after copying them
+		codedErrorResponse(w, "Cannot move files out of a read-only storage", CodeReadOnly, http.StatusForbidden)
+		return
+	}
 
-			if err := srcFS.Move(srcPath, dstPath); err != nil {
-				errorResponse(w, fmt.Sprintf("Failed to move %s: %v", file, err), http.StatusInternalServerError)
-				return
-			}
+	var reports []conflictReport
+	if req.SrcStorage != req.DstStorage {
+		// All or nothing, as a half-finished move across storages leaves
+		// files in both places
+		reports, err = h.moveAcrossStorages(srcFS, dstFS, req.SrcPath, req.DstPath, req.Files, exclude, onConflict)
+		var moveErr *moveError
+		switch {
+		case errors.As(err, &moveErr) && moveErr.Placed:
+			storageErrorResponse(w, fmt.Sprintf("Failed to move files; some were put in place and could not be taken back, so they are in both storages: %v", err), err)
+			return
+		case err != nil:
+			storageErrorResponse(w, fmt.Sprintf("Failed to move files, nothing was moved: %v", err), err)
+			return
 		}
 	} else {
-		// Cross-storage move: copy then delete
-		// First copy all files
-		for _, file := range req.Files {
+		reports = make([]conflictReport, len(req.Files))
+		dests := newBatchDestinations(req.DstPath, req.Files)
+		moveFile := func(i int, file string) error {
 			srcPath := filepath.Join(req.SrcPath, file)
-			dstPath := filepath.Join(req.DstPath, file)
-
-			// Check if source is directory
-			srcInfo, err := srcFS.Stat(srcPath)
+			dstPath, action, err := resolveConflict(srcFS, srcPath, dstFS, filepath.Join(req.DstPath, file), onConflict, dests, i)
 			if err != nil {
-				errorResponse(w, fmt.Sprintf("Failed to stat %s: %v", file, err), http.StatusInternalServerError)
-				return
+				return err
 			}
-
-			if srcInfo.IsDir {
-				// For directories, recursive copy
-				if err := h.copyDirectoryCrossStorage(srcFS, dstFS, srcPath, dstPath); err != nil {
-					errorResponse(w, fmt.Sprintf("Failed to copy directory %s: %v", file, err), http.StatusInternalServerError)
-					return
-				}
-			} else {
-				// For files, simple read and write
-				reader, err := srcFS.Read(srcPath)
-				if err != nil {
-					errorResponse(w, fmt.Sprintf("Failed to read %s: %v", file, err), http.StatusInternalServerError)
-					return
-				}
-				defer func() {
-					if err := reader.Close(); err != nil {
-						log.Printf("Error closing reader: %v", err)
-					}
-				}()
-
-				if err := dstFS.Write(dstPath, reader); err != nil {
-					errorResponse(w, fmt.Sprintf("Failed to write %s: %v", file, err), http.StatusInternalServerError)
-					return
-				}
+			reports[i] = conflictReport{File: file, Action: action, Destination: dstPath}
+			if action == actionSkipped {
+				return nil
 			}
-		}
 
-		// Then delete source files
-		for _, file := range req.Files {
-			srcPath := filepath.Join(req.SrcPath, file)
-			if err := srcFS.Delete(srcPath); err != nil {
-				// Log error but continue
-				fmt.Printf("Warning: failed to delete source after move: %s: %v\n", srcPath, err)
+			// A rename can't leave entries behind, so directories with
+			// exclusions are copied and then deleted
+			if exclude.Empty() {
+				return storage.Move(srcFS, srcPath, dstPath)
+			}
+			if info, err := srcFS.Stat(srcPath); err != nil || !info.IsDir {
+				return storage.Move(srcFS, srcPath, dstPath)
+			}
+			if err := h.copyDirectoryCrossStorage(srcFS, srcFS, srcPath, dstPath, exclude); err != nil {
+				return err
+			}
+			if _, err := storage.DeleteTree(srcFS, srcPath, exclude); err != nil {
+				// The file arrived, so the move still counts
+				log.Printf("Warning: failed to delete source after move: %s: %v", srcPath, err)
 			}
+			return nil
+		}
+		if batchFailed(w, "move", "moved", req.Files, h.runBatch(req.Files, moveFile, srcFS)) {
+			return
 		}
 	}
 
-	successResponse(w, map[string]interface{}{
+	response := map[string]interface{}{
 		"message": "Files moved successfully",
-		"count":   len(req.Files),
-	})
+		"count":   countCopied(reports),
+		"results": reports,
+	}
+	// Anything left behind, like excluded entries or sources that failed
+	// to delete, keeps the directory from being empty, so it stays
+	if req.PruneEmptyDirs && len(req.Files) > 0 {
+		pruned, err := storage.PruneEmptyDirs(srcFS, req.SrcPath)
+		if err != nil {
+			log.Printf("Error pruning empty directories after move from %s: %v", req.SrcPath, err)
+		}
+		if pruned == nil {
+			pruned = []string{}
+		}
+		response["pruned"] = pruned
+	}
+	successResponse(w, response)
 }
 
 // DeleteFiles deletes files or directories
 func (h *FileHandlers) DeleteFiles(w http.ResponseWriter, r *http.Request) {
 	// Parse request body
 	var req struct {
-		Storage string   `json:"storage"`
-		Files   []string `json:"files"`
-		Path    string   `json:"path"`
+		Storage string    `json:"storage"`
+		Files   []string  `json:"files"`
+		Path    string    `json:"path"`
+		Exclude *[]string `json:"exclude"`
+
+		// Permanent bypasses the trash on backends that have one. It
+		// cannot be undone.
+		Permanent bool `json:"permanent"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -366,21 +695,40 @@ func (h *FileHandlers) DeleteFiles(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	exclude, err := resolveExcludes(req.Exclude)
+	if err != nil {
+		errorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Get storage backend
 	fs, ok := h.storageManager.Get(req.Storage)
 	if !ok {
 		errorResponse(w, "Storage not found", http.StatusNotFound)
 		return
 	}
+	if isReadOnly(fs) {
+		codedErrorResponse(w, "Cannot delete files from a read-only storage", CodeReadOnly, http.StatusForbidden)
+		return
+	}
+
+	if req.Permanent {
+		if deleter, ok := storage.As[storage.PermanentDeleter](fs); ok {
+			fs = permanentDeleteFS{FileSystem: fs, deleter: deleter}
+		}
+	}
+
+	// Delete the files, carrying on past failures
+	errs := h.runBatch(req.Files, func(_ int, file string) error {
+		_, err := storage.DeleteTree(fs, filepath.Join(req.Path, file), exclude)
+		return err
+	}, fs)
 
-	// Delete each file
 	var deleted []string
 	var errors []string
-
-	for _, file := range req.Files {
-		fullPath := filepath.Join(req.Path, file)
-		if err := fs.Delete(fullPath); err != nil {
-			errors = append(errors, fmt.Sprintf("%s: %v", file, err))
+	for i, file := range req.Files {
+		if errs[i] != nil {
+			errors = append(errors, fmt.Sprintf("%s: %v", file, errs[i]))
 		} else {
 			deleted = append(deleted, file)
 		}
@@ -392,17 +740,36 @@ func (h *FileHandlers) DeleteFiles(w http.ResponseWriter, r *http.Request) {
 	}
 
 	successResponse(w, map[string]interface{}{
-		"message": "Files deleted successfully",
-		"deleted": deleted,
-		"count":   len(deleted),
+		"message":   "Files deleted successfully",
+		"deleted":   deleted,
+		"count":     len(deleted),
+		"permanent": req.Permanent,
 	})
 }
 
+// permanentDeleteFS sends every Delete past the backend's trash
+type permanentDeleteFS struct {
+	storage.FileSystem
+	deleter storage.PermanentDeleter
+}
+
+func (p permanentDeleteFS) Delete(path string) error {
+	return p.deleter.DeletePermanently(path)
+}
+
 // DownloadFile handles file downloads
 func (h *FileHandlers) DownloadFile(w http.ResponseWriter, r *http.Request) {
 	// Get parameters
 	storageID := r.URL.Query().Get("storage")
 	path := r.URL.Query().Get("path")
+	disposition := r.URL.Query().Get("disposition")
+	if disposition == "" {
+		disposition = "attachment"
+	}
+	if disposition != "attachment" && disposition != "inline" {
+		errorResponse(w, "disposition must be inline or attachment", http.StatusBadRequest)
+		return
+	}
 
 	// Get storage backend
 	fs, ok := h.storageManager.Get(storageID)
@@ -411,6 +778,8 @@ func (h *FileHandlers) DownloadFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	fs = archiveView(fs, path)
+
 	// Get file info
 	info, err := fs.Stat(path)
 	if err != nil {
@@ -419,31 +788,100 @@ func (h *FileHandlers) DownloadFile(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if info.IsDir {
-		errorResponse(w, "Cannot download a directory", http.StatusBadRequest)
+		errorResponse(w, "Cannot download a directory; use /fs/download-archive", http.StatusBadRequest)
 		return
 	}
 
-	// Open file for reading
-	reader, err := fs.Read(path)
+	// The ETag lets editors send the file back with If-Match, and browsers
+	// revalidate their cached copy instead of downloading it again
+	etag, content, err := fileETag(fs, path, info)
 	if err != nil {
-		errorResponse(w, fmt.Sprintf("Failed to read file: %v", err), http.StatusInternalServerError)
+		storageErrorResponse(w, fmt.Sprintf("Failed to read file: %v", err), err)
 		return
 	}
+	w.Header().Set("ETag", etag)
+	if !info.ModTime.IsZero() {
+		w.Header().Set("Last-Modified", info.ModTime.UTC().Format(http.TimeFormat))
+	}
+	w.Header().Set("Cache-Control", "private, no-cache")
+	if notModified(r, etag, info.ModTime) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	// A Range asks for part of the file, unless If-Range names a version
+	// other than the current one
+	if content != nil {
+		info.Size = int64(len(content))
+	}
+	var rng *byteRange
+	if ifRange := r.Header.Get("If-Range"); ifRange == "" || ifRange == etag {
+		rng, err = parseRangeHeader(r.Header.Get("Range"), info.Size)
+		if err != nil {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", info.Size))
+			errorResponse(w, "Range starts past the end of the file", http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+	}
+	offset, length := int64(0), info.Size
+	if rng != nil {
+		offset, length = rng.Start, rng.End-rng.Start+1
+	}
+
+	// Open file for reading, unless it was already read for its ETag
+	var reader io.ReadCloser
+	if content != nil {
+		reader = io.NopCloser(bytes.NewReader(content[offset : offset+length]))
+	} else if rng != nil {
+		reader, err = storage.ReadRange(fs, path, offset, length)
+	} else {
+		reader, err = fs.Read(path)
+	}
+	if err != nil {
+		storageErrorResponse(w, fmt.Sprintf("Failed to read file: %v", err), err)
+		return
+	}
+	// Also closed to interrupt the read when the client goes away
+	reader = &closeOnceReader{ReadCloser: reader}
 	defer func() {
 		if err := reader.Close(); err != nil {
 			log.Printf("Error closing decompression reader: %v", err)
 		}
 	}()
 
+	contentType := info.MimeType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(info.Name))
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	// Active content is always downloaded unless explicitly allowed, since
+	// rendering it would run script with the app's origin
+	if disposition == "inline" && !h.allowUnsafeInline && !isSafeInlineType(contentType) {
+		disposition = "attachment"
+	}
+
 	// Set headers
-	w.Header().Set("Content-Type", info.MimeType)
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", info.Name))
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", info.Size))
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", contentDisposition(disposition, filepath.Base(info.Name)))
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", length))
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if rng != nil {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", rng.Start, rng.End, info.Size))
+		w.WriteHeader(http.StatusPartialContent)
+	}
 
-	// Stream the file
-	if _, err := io.Copy(w, reader); err != nil {
-		// Log error, but response is already being written
-		fmt.Printf("Error streaming file: %v\n", err)
+	// Stream the file. The status is already sent, so a download that
+	// can't be finished is only cut short.
+	written, err := streamDownload(r.Context(), w, fs, path, reader, offset, length)
+	switch {
+	case r.Context().Err() != nil:
+		log.Printf("Download of %s stopped after %d of %d bytes: client went away", path, written, length)
+	case err != nil:
+		log.Printf("Download of %s truncated after %d of %d bytes: %v", path, written, length, err)
 	}
 }
 
@@ -478,21 +916,176 @@ func (h *FileHandlers) UploadFile(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
-	// Construct full path
-	fullPath := filepath.Join(path, header.Filename)
+	// Construct full path. With unique_names the client's name is only
+	// reported back, never used on the storage.
+	filename := header.Filename
+	if r.FormValue("unique_names") == "true" {
+		if filename, err = uniqueUploadName(header.Filename); err != nil {
+			errorResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	fullPath := filepath.Join(path, filename)
+
+	// If-Match guards against overwriting changes made since the client
+	// read the file; If-None-Match: * against replacing an existing one
+	cond, err := checkWritePreconditions(r, fs, fullPath)
+	if err != nil {
+		storageErrorResponse(w, err.Error(), err)
+		return
+	}
+
+	if r.FormValue("dedupe") == "true" {
+		same, err := uploadIsDuplicate(fs, fullPath, file, header.Size, r.Header.Get("X-Content-SHA256"))
+		if err != nil {
+			log.Printf("Error checking %s for dedupe: %v", fullPath, err)
+		}
+		if same {
+			successResponse(w, map[string]interface{}{
+				"message":      "Identical file already exists",
+				"filename":     filename,
+				"size":         header.Size,
+				"path":         fullPath,
+				"deduplicated": true,
+			})
+			return
+		}
+	}
 
 	// Write file
-	if err := fs.Write(fullPath, file); err != nil {
-		errorResponse(w, fmt.Sprintf("Failed to save file: %v", err), http.StatusInternalServerError)
+	if err := writeChecked(fs, fullPath, file, r.FormValue("content_type"), cond); err != nil {
+		storageErrorResponse(w, fmt.Sprintf("Failed to save file: %v", err), err)
 		return
 	}
 
-	successResponse(w, map[string]interface{}{
+	result := map[string]interface{}{
 		"message":  "File uploaded successfully",
-		"filename": header.Filename,
+		"filename": filename,
 		"size":     header.Size,
 		"path":     fullPath,
-	})
+	}
+	if filename != header.Filename {
+		result["original_name"] = header.Filename
+	}
+	if cond != nil {
+		// Conditional writers save again with the new ETag
+		if info, err := fs.Stat(fullPath); err == nil {
+			if etag, _, err := fileETag(fs, fullPath, info); err == nil {
+				w.Header().Set("ETag", etag)
+				result["etag"] = etag
+			}
+		}
+	}
+	successResponse(w, result)
+}
+
+// uniqueUploadName generates a storage name for an upload from the time
+// and random bits. Only the original's extension is kept, reduced to
+// lower-case letters and digits, so nothing else of an untrusted name
+// reaches the storage.
+func uniqueUploadName(original string) (string, error) {
+	random := make([]byte, 8)
+	if _, err := rand.Read(random); err != nil {
+		return "", fmt.Errorf("failed to generate a file name: %v", err)
+	}
+	name := time.Now().UTC().Format("20060102T150405") + "-" + hex.EncodeToString(random)
+
+	ext := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, strings.ToLower(filepath.Ext(original)))
+	if len(ext) > 10 {
+		ext = ext[:10]
+	}
+	if ext != "" {
+		name += "." + ext
+	}
+	return name, nil
+}
+
+// safeInlineTypes are content types browsers render without executing
+// script
+var safeInlineTypes = map[string]bool{
+	"application/pdf":  true,
+	"application/json": true,
+	"text/plain":       true,
+	"text/csv":         true,
+	"text/markdown":    true,
+}
+
+// isSafeInlineType reports whether a file of this type may be shown inline
+func isSafeInlineType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if safeInlineTypes[mediaType] {
+		return true
+	}
+
+	major, minor, _ := strings.Cut(mediaType, "/")
+	switch major {
+	case "image":
+		// SVG can carry script
+		return !strings.Contains(minor, "svg")
+	case "audio", "video":
+		return true
+	}
+	return false
+}
+
+// contentDisposition builds a Content-Disposition header. Non-ASCII names
+// are encoded per RFC 6266 with an ASCII fallback for old clients.
+func contentDisposition(disposition, filename string) string {
+	fallback := strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e || r == '"' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, filename)
+
+	header := fmt.Sprintf("%s; filename=\"%s\"", disposition, fallback)
+	if fallback != filename {
+		header += "; filename*=UTF-8''" + encodeExtValue(filename)
+	}
+	return header
+}
+
+// encodeExtValue percent-encodes everything outside RFC 5987's attr-char
+func encodeExtValue(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') ||
+			strings.IndexByte("!#$&+-.^_`|~", c) >= 0 {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+// archiveView wraps fs with archive browsing when path points inside an
+// archive, e.g. "/backup.zip!/docs"
+func archiveView(fs storage.FileSystem, path string) storage.FileSystem {
+	if _, _, ok := storage.SplitArchivePath(path); ok {
+		return storage.NewArchiveFS(fs)
+	}
+	return fs
+}
+
+// writeWithContentType writes a file, passing an explicit Content-Type to
+// backends that store one. Other backends ignore it.
+func writeWithContentType(fs storage.FileSystem, path string, data io.Reader, contentType string) error {
+	if contentType != "" {
+		if ctw, ok := storage.As[storage.ContentTypeWriter](fs); ok {
+			return ctw.WriteContentType(path, data, contentType)
+		}
+	}
+	return fs.Write(path, data)
 }
 
 // Helper functions for responses
@@ -505,16 +1098,3 @@ func successResponse(w http.ResponseWriter, data interface{}) {
 		log.Printf("Error encoding JSON response: %v", err)
 	}
 }
-
-func errorResponse(w http.ResponseWriter, message string, code int) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(code)
-	if err := json.NewEncoder(w).Encode(map[string]interface{}{
-		"success": false,
-		"error": map[string]string{
-			"message": message,
-		},
-	}); err != nil {
-		log.Printf("Error encoding error response: %v", err)
-	}
-}
@@ -0,0 +1,341 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+// Limits for /fs/find
+const (
+	DefaultFindResults = 1000
+	MaxFindResults     = 100000
+
+	// findGrepMaxSize is the largest file whose content is searched for
+	// the contains filter; bigger files never match it
+	findGrepMaxSize = 64 << 20
+)
+
+// findWalkLimits keeps a search from running away on an enormous or
+// looping tree; one that hits them ends as truncated
+var findWalkLimits = storage.WalkLimits{MaxEntries: 5000000, Timeout: 10 * time.Minute}
+
+// findFilter is what an entry must satisfy to be reported by /fs/find
+type findFilter struct {
+	name           string // glob against the base name
+	regex          *regexp.Regexp
+	caseSensitive  bool
+	entryType      string // "file", "dir" or "" for both
+	minSize        int64
+	maxSize        int64 // -1 for no limit
+	modifiedAfter  time.Time
+	modifiedBefore time.Time
+	contains       []byte
+}
+
+// parseFindTime accepts an RFC 3339 timestamp or a plain date
+func parseFindTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+// parseFindFilter reads the filter from the /fs/find query
+func parseFindFilter(query url.Values) (*findFilter, error) {
+	f := &findFilter{
+		name:          query.Get("name"),
+		caseSensitive: query.Get("case_sensitive") == "true",
+		entryType:     query.Get("type"),
+		maxSize:       -1,
+	}
+	if !f.caseSensitive {
+		f.name = strings.ToLower(f.name)
+	}
+	if f.name != "" {
+		if _, err := path.Match(f.name, ""); err != nil {
+			return nil, fmt.Errorf("Invalid name pattern: %s", query.Get("name"))
+		}
+	}
+	if expr := query.Get("regex"); expr != "" {
+		if !f.caseSensitive {
+			expr = "(?i)" + expr
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid regex: %v", err)
+		}
+		f.regex = re
+	}
+	if f.entryType != "" && f.entryType != "file" && f.entryType != "dir" {
+		return nil, fmt.Errorf("type must be file or dir")
+	}
+
+	for key, dst := range map[string]*int64{"min_size": &f.minSize, "max_size": &f.maxSize} {
+		if value := query.Get(key); value != "" {
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("%s must be a non-negative number of bytes", key)
+			}
+			*dst = n
+		}
+	}
+	for key, dst := range map[string]*time.Time{"modified_after": &f.modifiedAfter, "modified_before": &f.modifiedBefore} {
+		if value := query.Get(key); value != "" {
+			t, err := parseFindTime(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s must be an RFC 3339 time or a YYYY-MM-DD date", key)
+			}
+			*dst = t
+		}
+	}
+
+	if contains := query.Get("contains"); contains != "" {
+		f.contains = []byte(contains)
+		if !f.caseSensitive {
+			f.contains = bytes.ToLower(f.contains)
+		}
+		// Only files have content
+		if f.entryType == "" {
+			f.entryType = "file"
+		}
+		if f.entryType == "dir" {
+			return nil, fmt.Errorf("contains can't be used with type=dir")
+		}
+	}
+	return f, nil
+}
+
+// matchesMetadata checks everything but the content
+func (f *findFilter) matchesMetadata(info storage.FileInfo) bool {
+	switch f.entryType {
+	case "file":
+		if info.IsDir {
+			return false
+		}
+	case "dir":
+		if !info.IsDir {
+			return false
+		}
+	}
+
+	name := info.Name
+	if !f.caseSensitive {
+		name = strings.ToLower(name)
+	}
+	if f.name != "" {
+		if ok, _ := path.Match(f.name, name); !ok {
+			return false
+		}
+	}
+	if f.regex != nil && !f.regex.MatchString(info.Name) {
+		return false
+	}
+
+	// Directories have no size of their own, so a size filter only finds files
+	if f.minSize > 0 || f.maxSize >= 0 {
+		if info.IsDir || info.Size < f.minSize || (f.maxSize >= 0 && info.Size > f.maxSize) {
+			return false
+		}
+	}
+	if !f.modifiedAfter.IsZero() && !info.ModTime.After(f.modifiedAfter) {
+		return false
+	}
+	if !f.modifiedBefore.IsZero() && !info.ModTime.Before(f.modifiedBefore) {
+		return false
+	}
+	return true
+}
+
+// matchesContent reports whether the file contains the filter's
+// text. It reads in chunks, stopping at the first hit or when ctx ends.
+func (f *findFilter) matchesContent(ctx context.Context, fs storage.FileSystem, info storage.FileInfo) (bool, error) {
+	if len(f.contains) == 0 {
+		return true, nil
+	}
+	if info.IsLink || info.Size > findGrepMaxSize {
+		return false, nil
+	}
+
+	reader, err := fs.Read(info.Path)
+	if err != nil {
+		return false, err
+	}
+	defer reader.Close()
+
+	// Keep the end of the previous chunk so matches across chunk
+	// boundaries are found
+	overlap := len(f.contains) - 1
+	buf := make([]byte, 0, 64<<10+overlap)
+	chunk := make([]byte, 64<<10)
+	for {
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		n, err := reader.Read(chunk)
+		if n > 0 {
+			data := chunk[:n]
+			if !f.caseSensitive {
+				data = bytes.ToLower(data)
+			}
+			buf = append(buf, data...)
+			if bytes.Contains(buf, f.contains) {
+				return true, nil
+			}
+			if len(buf) > overlap {
+				buf = append(buf[:0], buf[len(buf)-overlap:]...)
+			}
+		}
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+}
+
+// errFindFull stops a find walk once max_results matches are reported
+var errFindFull = errors.New("find result limit reached")
+
+// FindFiles walks a directory tree and streams the entries that match the
+// query filters as newline-delimited JSON while they're found, so clients
+// can show results of a slow search progressively. The last line is a
+// summary, or an error object when the walk failed after streaming began.
+// The walk stops when the client disconnects.
+func (h *FileHandlers) FindFiles(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	storageID := query.Get("storage")
+	root := query.Get("path")
+	if root == "" {
+		root = "/"
+	}
+
+	filter, err := parseFindFilter(query)
+	if err != nil {
+		errorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	exclude, err := queryExcludes(query)
+	if err != nil {
+		errorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	maxDepth := 0 // unlimited
+	if value := query.Get("max_depth"); value != "" {
+		if maxDepth, err = strconv.Atoi(value); err != nil || maxDepth < 1 {
+			errorResponse(w, "max_depth must be a positive integer", http.StatusBadRequest)
+			return
+		}
+	}
+	maxResults := DefaultFindResults
+	if value := query.Get("max_results"); value != "" {
+		if maxResults, err = strconv.Atoi(value); err != nil || maxResults < 1 || maxResults > MaxFindResults {
+			errorResponse(w, fmt.Sprintf("max_results must be between 1 and %d", MaxFindResults), http.StatusBadRequest)
+			return
+		}
+	}
+
+	fs, ok := h.storageManager.Get(storageID)
+	if !ok {
+		errorResponse(w, "Storage not found", http.StatusNotFound)
+		return
+	}
+	info, err := fs.Stat(root)
+	if err != nil {
+		storageErrorResponse(w, "Directory not found", err)
+		return
+	}
+	if !info.IsDir {
+		errorResponse(w, "Path is not a directory", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	// Flushing goes through any middleware wrapping w; a writer that
+	// can't flush just sends the results when it can
+	rc := http.NewResponseController(w)
+	enc := json.NewEncoder(w)
+	_ = rc.Flush()
+
+	ctx := r.Context()
+	rootDepth := pathDepth(root)
+	count := 0
+	err = storage.WalkLimited(fs, root, findWalkLimits, func(entry storage.FileInfo) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if exclude.Excluded(entry.Name) {
+			return storage.SkipDir
+		}
+
+		if filter.matchesMetadata(entry) {
+			matched, err := filter.matchesContent(ctx, fs, entry)
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				log.Printf("Error searching content of %s: %v", entry.Path, err)
+			}
+			if matched {
+				if err := enc.Encode(entry); err != nil {
+					return err
+				}
+				_ = rc.Flush()
+				count++
+				if count >= maxResults {
+					return errFindFull
+				}
+			}
+		}
+
+		if entry.IsDir && maxDepth > 0 && pathDepth(entry.Path)-rootDepth >= maxDepth {
+			return storage.SkipDir
+		}
+		return nil
+	})
+
+	writeFindSummary(ctx, enc, root, count, err)
+}
+
+// writeFindSummary writes the last line of a streamed search: how many
+// matches were sent and whether the walk stopped short, or the error that
+// ended it
+func writeFindSummary(ctx context.Context, enc *json.Encoder, root string, count int, err error) {
+	switch {
+	case err == nil || err == errFindFull:
+		_ = enc.Encode(map[string]interface{}{"done": true, "count": count, "truncated": err == errFindFull})
+	case errors.Is(err, storage.ErrWalkTruncated):
+		_ = enc.Encode(map[string]interface{}{"done": true, "count": count, "truncated": true, "reason": err.Error()})
+	case ctx.Err() != nil:
+		// The client is gone; nobody is left to tell
+	default:
+		log.Printf("Error searching files in %s: %v", root, err)
+		_ = enc.Encode(map[string]interface{}{"error": err.Error(), "count": count})
+	}
+}
+
+// pathDepth counts the elements of a slash-separated path
+func pathDepth(p string) int {
+	p = strings.Trim(path.Clean("/"+p), "/")
+	if p == "" {
+		return 0
+	}
+	return strings.Count(p, "/") + 1
+}
@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+// slowFileSystem lists a fixed tree in which /slow blocks until released
+type slowFileSystem struct {
+	*mockFileSystem
+	release chan struct{}
+
+	mu     sync.Mutex
+	listed []string
+}
+
+func (s *slowFileSystem) ListFunc(dir string, fn func(storage.FileInfo) error) error {
+	s.mu.Lock()
+	s.listed = append(s.listed, dir)
+	s.mu.Unlock()
+
+	var entries []storage.FileInfo
+	switch dir {
+	case "/":
+		entries = []storage.FileInfo{
+			{Name: "a.txt", Size: 1},
+			{Name: "slow", IsDir: true},
+			{Name: "z", IsDir: true},
+		}
+	case "/slow":
+		<-s.release
+		entries = []storage.FileInfo{{Name: "b.txt", Size: 1}}
+	case "/z":
+		entries = []storage.FileInfo{{Name: "c.txt", Size: 1}}
+	}
+	for _, entry := range entries {
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *slowFileSystem) wasListed(dir string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, listed := range s.listed {
+		if listed == dir {
+			return true
+		}
+	}
+	return false
+}
+
+func TestFileHandlers_FindFiltersLocal(t *testing.T) {
+	root := t.TempDir()
+	write := func(name, content string) {
+		full := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("notes.txt", "remember the milk")
+	write("docs/Report.TXT", "quarterly numbers")
+	write("docs/deep/todo.txt", "buy MILK")
+	write("docs/image.png", strings.Repeat("x", 2048))
+	write("node_modules/pkg/readme.txt", "milk")
+
+	mgr := storage.NewManager()
+	mgr.Register("local", storage.NewLocalStorage(root))
+	handler := NewFileHandlers(mgr)
+	router := mux.NewRouter()
+	router.HandleFunc("/api/fs/find", handler.FindFiles).Methods("GET")
+
+	find := func(params string) ([]string, map[string]interface{}) {
+		req := httptest.NewRequest("GET", "/api/fs/find?storage=local&exclude=node_modules&"+params, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Find %s: expected status 200, got %d: %s", params, rr.Code, rr.Body.String())
+		}
+		if ct := rr.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+			t.Errorf("Expected NDJSON, got %s", ct)
+		}
+
+		var paths []string
+		var summary map[string]interface{}
+		for _, line := range strings.Split(strings.TrimSpace(rr.Body.String()), "\n") {
+			var obj map[string]interface{}
+			if err := json.Unmarshal([]byte(line), &obj); err != nil {
+				t.Fatalf("Invalid NDJSON line %q: %v", line, err)
+			}
+			if p, ok := obj["path"].(string); ok {
+				paths = append(paths, p)
+			} else {
+				summary = obj
+			}
+		}
+		sort.Strings(paths)
+		return paths, summary
+	}
+
+	tests := []struct {
+		params string
+		want   []string
+	}{
+		{"name=*.txt", []string{"/docs/Report.TXT", "/docs/deep/todo.txt", "/notes.txt"}},
+		{"name=*.txt&case_sensitive=true", []string{"/docs/deep/todo.txt", "/notes.txt"}},
+		{"regex=^(report|todo)", []string{"/docs/Report.TXT", "/docs/deep/todo.txt"}},
+		{"type=dir", []string{"/docs", "/docs/deep"}},
+		{"min_size=1024", []string{"/docs/image.png"}},
+		{"contains=milk", []string{"/docs/deep/todo.txt", "/notes.txt"}},
+		{"contains=milk&case_sensitive=true", []string{"/notes.txt"}},
+		{"name=*.txt&max_depth=2", []string{"/docs/Report.TXT", "/notes.txt"}},
+		{"path=/docs&max_depth=1&name=*.txt", []string{"/docs/Report.TXT"}},
+	}
+	for _, tt := range tests {
+		got, summary := find(tt.params)
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("Find %s: expected %v, got %v", tt.params, tt.want, got)
+		}
+		if summary["done"] != true || summary["truncated"] != false {
+			t.Errorf("Find %s: unexpected summary %v", tt.params, summary)
+		}
+	}
+
+	got, summary := find("name=*.txt&max_results=2")
+	if len(got) != 2 || summary["truncated"] != true {
+		t.Errorf("Expected 2 results and a truncated summary, got %v %v", got, summary)
+	}
+
+	for _, params := range []string{"regex=(", "type=link", "max_depth=0", "min_size=-1", "modified_after=yesterday"} {
+		req := httptest.NewRequest("GET", "/api/fs/find?storage=local&"+params, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Find %s: expected status 400, got %d", params, rr.Code)
+		}
+	}
+}
+
+func TestFileHandlers_FindStreamsAndCancels(t *testing.T) {
+	fs := &slowFileSystem{mockFileSystem: newMockFileSystem(), release: make(chan struct{})}
+	mgr := storage.NewManager()
+	mgr.Register("slow", fs)
+	handler := NewFileHandlers(mgr)
+	router := mux.NewRouter()
+	router.HandleFunc("/api/fs/find", handler.FindFiles).Methods("GET")
+
+	serverCtx := make(chan context.Context, 1)
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(done)
+		serverCtx <- r.Context()
+		router.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL+"/api/fs/find?storage=slow&type=file", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// The first match arrives while the walk is still stuck in /slow
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil {
+		t.Fatalf("Failed to read the first result: %v", err)
+	}
+	var first storage.FileInfo
+	if err := json.Unmarshal([]byte(line), &first); err != nil || first.Path != "/a.txt" {
+		t.Fatalf("Expected /a.txt first, got %q", line)
+	}
+
+	cancel()
+	select {
+	case <-(<-serverCtx).Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Server never noticed the client went away")
+	}
+	close(fs.release)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Find kept running after the client disconnected")
+	}
+	if fs.wasListed("/z") {
+		t.Error("Expected the walk to stop before listing /z")
+	}
+}
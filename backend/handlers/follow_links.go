@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"errors"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+// errLinkOutsideRoot is returned for a listing reached through a symlink
+// that leads out of the storage root
+var errLinkOutsideRoot = errors.New("path leads outside the storage root through a symlink")
+
+// linkFollower marks symlinks to directories as directories in a listing,
+// so they can be navigated into. Only links that stay inside the storage
+// root and don't lead back up the path being listed are followed.
+type linkFollower struct {
+	resolver storage.LinkResolver
+
+	// visited holds the real location of the listed directory, of each
+	// directory on the way to it and of everything above those. A link to
+	// any of them would let the path grow forever.
+	visited map[string]bool
+}
+
+// newLinkFollower prepares to follow links in dirPath. It returns nil when
+// the backend has no symlinks to follow.
+func newLinkFollower(fs storage.FileSystem, dirPath string) (*linkFollower, error) {
+	resolver, ok := storage.As[storage.LinkResolver](fs)
+	if !ok {
+		return nil, nil
+	}
+
+	lf := &linkFollower{resolver: resolver, visited: map[string]bool{"/": true}}
+	logical := "/"
+	for _, part := range strings.Split(strings.Trim(path.Clean("/"+dirPath), "/"), "/") {
+		if part == "" {
+			continue
+		}
+		logical = path.Join(logical, part)
+		real, err := resolver.ResolveLink(logical)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		if err != nil {
+			return nil, errLinkOutsideRoot
+		}
+		for dir := real; dir != "/"; dir = path.Dir(dir) {
+			lf.visited[dir] = true
+		}
+	}
+	return lf, nil
+}
+
+// follow returns info marked as a directory if it is a link that can be
+// followed
+func (lf *linkFollower) follow(info storage.FileInfo) storage.FileInfo {
+	if lf == nil || !info.IsLink || !info.LinkTargetIsDir {
+		return info
+	}
+	target, err := lf.resolver.ResolveLink(info.Path)
+	if err != nil || lf.visited[target] {
+		return info
+	}
+	info.IsDir = true
+	return info
+}
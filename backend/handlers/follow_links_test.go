@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+func TestFileHandlers_ListDirectoryFollowLinks(t *testing.T) {
+	outside := t.TempDir()
+	root := t.TempDir()
+	for _, dir := range []string{"projects/site/css", "shared"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(root, "projects/site/index.html"), []byte("<h1/>"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	for link, target := range map[string]string{
+		"shared/site":     "../projects/site", // a directory inside the root
+		"shared/external": outside,            // leaves the root
+		"shared/up":       "..",               // back to the root: a cycle
+		"shared/page":     "../projects/site/index.html",
+	} {
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Fatalf("Failed to create symlink: %v", err)
+		}
+	}
+
+	mgr := storage.NewManager()
+	mgr.Register("local", storage.NewLocalStorage(root))
+	h := NewFileHandlers(mgr)
+
+	list := func(query string) (int, map[string]storage.FileInfo) {
+		rr := httptest.NewRecorder()
+		h.ListDirectory(rr, httptest.NewRequest("GET", "/api/fs/list?storage=local&"+query, nil))
+		if rr.Code != http.StatusOK {
+			return rr.Code, nil
+		}
+		var resp struct {
+			Data struct {
+				Files []storage.FileInfo `json:"files"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Failed to decode listing: %v", err)
+		}
+		files := make(map[string]storage.FileInfo)
+		for _, f := range resp.Data.Files {
+			files[f.Name] = f
+		}
+		return rr.Code, files
+	}
+
+	// Without the flag links are reported as they are
+	_, files := list("path=/shared")
+	if site := files["site"]; site.IsDir || !site.IsLink || !site.LinkTargetIsDir {
+		t.Errorf("Expected an unfollowed link to a directory, got %+v", site)
+	}
+
+	_, files = list("path=/shared&follow_links=true")
+	if !files["site"].IsDir {
+		t.Errorf("Expected the link inside the root to be navigable, got %+v", files["site"])
+	}
+	for _, name := range []string{"external", "up", "page"} {
+		if files[name].IsDir {
+			t.Errorf("Expected %s not to be followed, got %+v", name, files[name])
+		}
+	}
+
+	// Navigate into the followed link
+	code, files := list("path=/shared/site&follow_links=true")
+	if code != http.StatusOK {
+		t.Fatalf("Expected 200 listing through the link, got %d", code)
+	}
+	if !files["css"].IsDir || files["index.html"].Path != "/shared/site/index.html" {
+		t.Errorf("Unexpected listing through the link: %+v", files)
+	}
+
+	// dirs_only keeps followed links
+	_, files = list("path=/shared&follow_links=true&dirs_only=true")
+	if _, ok := files["site"]; !ok || len(files) != 1 {
+		t.Errorf("Expected only the followed link with dirs_only, got %v", files)
+	}
+
+	if code, _ := list("path=/shared/external&follow_links=true"); code != http.StatusForbidden {
+		t.Errorf("Expected 403 listing through a link out of the root, got %d", code)
+	}
+}
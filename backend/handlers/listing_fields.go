@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+// fileInfoFields maps the JSON name of each FileInfo field to its index
+var fileInfoFields = func() map[string]int {
+	fields := make(map[string]int)
+	t := reflect.TypeOf(storage.FileInfo{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			fields[name] = i
+		}
+	}
+	return fields
+}()
+
+// fieldProjection selects which FileInfo fields a listing returns
+type fieldProjection struct {
+	names   []string
+	indexes []int
+}
+
+// parseFieldProjection parses a comma-separated list of FileInfo JSON
+// field names. An empty list means all fields, reported as a nil
+// projection.
+func parseFieldProjection(list string) (*fieldProjection, error) {
+	if strings.TrimSpace(list) == "" {
+		return nil, nil
+	}
+
+	p := &fieldProjection{}
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		index, ok := fileInfoFields[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown field: %s", name)
+		}
+		seen[name] = true
+		p.names = append(p.names, name)
+		p.indexes = append(p.indexes, index)
+	}
+	return p, nil
+}
+
+// apply returns info with only the selected fields. A nil projection
+// returns info unchanged.
+func (p *fieldProjection) apply(info storage.FileInfo) interface{} {
+	if p == nil {
+		return info
+	}
+	v := reflect.ValueOf(info)
+	projected := make(map[string]interface{}, len(p.names))
+	for i, name := range p.names {
+		projected[name] = v.Field(p.indexes[i]).Interface()
+	}
+	return projected
+}
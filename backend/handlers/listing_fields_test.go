@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+func TestFileHandlers_ListDirectoryFields(t *testing.T) {
+	mgr := storage.NewManager()
+	mgr.Register("gen", &generatedFileSystem{mockFileSystem: newMockFileSystem(), n: 3})
+	handler := NewFileHandlers(mgr)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/api/fs/list", handler.ListDirectory).Methods("GET")
+
+	list := func(query string) (int, []map[string]interface{}) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/fs/list?storage=gen&path=/big"+query, nil))
+		var resp struct {
+			Data struct {
+				Files []map[string]interface{} `json:"files"`
+			} `json:"data"`
+		}
+		if rr.Code == http.StatusOK {
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("Failed to decode listing: %v", err)
+			}
+		}
+		return rr.Code, resp.Data.Files
+	}
+
+	keys := func(entry map[string]interface{}) string {
+		var names []string
+		for name := range entry {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return strings.Join(names, ",")
+	}
+
+	for _, query := range []string{"", "&calc_sizes=true"} {
+		code, files := list("&fields=name,size,is_dir" + query)
+		if code != http.StatusOK || len(files) != 3 {
+			t.Fatalf("Expected 3 entries, got %d (status %d)", len(files), code)
+		}
+		for _, file := range files {
+			if got := keys(file); got != "is_dir,name,size" {
+				t.Errorf("Expected only name, size and is_dir, got %s", got)
+			}
+		}
+		if files[1]["name"] != "file-000001.txt" || files[1]["size"] != float64(1) {
+			t.Errorf("Unexpected projected entry: %v", files[1])
+		}
+	}
+
+	t.Run("Default is all fields", func(t *testing.T) {
+		_, files := list("")
+		if len(files) != 3 {
+			t.Fatalf("Expected 3 entries, got %d", len(files))
+		}
+		for _, field := range []string{"name", "path", "size", "modified", "is_dir", "permissions", "mime_type"} {
+			if _, ok := files[0][field]; !ok {
+				t.Errorf("Expected %s in the default listing", field)
+			}
+		}
+	})
+
+	t.Run("Unknown field", func(t *testing.T) {
+		if code, _ := list("&fields=name,colour"); code != http.StatusBadRequest {
+			t.Errorf("Expected 400, got %d", code)
+		}
+	})
+}
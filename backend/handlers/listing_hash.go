@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"sync"
+
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+// defaultListingHashMaxSize is the largest file a listing hashes unless
+// hash_max_size says otherwise, and listingHashMaxSizeLimit the most it
+// may say
+const (
+	defaultListingHashMaxSize = 64 << 20
+	listingHashMaxSizeLimit   = 1 << 30
+)
+
+// listingHashWorkers is how many files of a listing are hashed at once
+const listingHashWorkers = 4
+
+// listingHash is the hashing a listing asks for with with_hash
+type listingHash struct {
+	algo    string
+	maxSize int64
+}
+
+// parseListingHash reads the with_hash and hash_max_size parameters,
+// returning nil when no hashes are wanted. Besides the /fs/checksum
+// algorithms, "quickxor" is accepted for the hashes OneDrive keeps; it is
+// never computed here.
+func parseListingHash(query url.Values) (*listingHash, error) {
+	algo := query.Get("with_hash")
+	if algo == "" {
+		return nil, nil
+	}
+	if _, ok := checksumAlgorithms[algo]; !ok && algo != "quickxor" {
+		return nil, fmt.Errorf("unknown with_hash algorithm %q: use md5, sha1, sha256, crc32 or quickxor", algo)
+	}
+
+	lh := &listingHash{algo: algo, maxSize: defaultListingHashMaxSize}
+	if value := query.Get("hash_max_size"); value != "" {
+		size, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || size < 0 || size > listingHashMaxSizeLimit {
+			return nil, fmt.Errorf("hash_max_size must be between 0 and %d", listingHashMaxSizeLimit)
+		}
+		lh.maxSize = size
+	}
+	return lh, nil
+}
+
+// apply fills in the hash of each file in files, the entries of dirPath.
+// Hashes the backend keeps are used as they are; other files up to maxSize
+// are read and hashed, a few at a time.
+func (lh *listingHash) apply(ctx context.Context, fs storage.FileSystem, dirPath string, files []storage.FileInfo) {
+	var native map[string]string
+	if hasher, ok := storage.As[storage.ContentHasher](fs); ok {
+		hashes, err := hasher.ContentHashes(dirPath, lh.algo)
+		if err != nil && !errors.Is(err, storage.ErrNotSupported) {
+			log.Printf("Error getting stored hashes of %s: %v", dirPath, err)
+		}
+		native = hashes
+	}
+
+	newHash, computable := checksumAlgorithms[lh.algo]
+	jobs := make(chan *storage.FileInfo)
+	var wg sync.WaitGroup
+	for range listingHashWorkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range jobs {
+				digest, _, err := checksumFile(ctx, fs, file.Path, newHash())
+				if err != nil {
+					file.HashSkipped = "error"
+					continue
+				}
+				file.Hash = digest
+			}
+		}()
+	}
+
+	for i := range files {
+		file := &files[i]
+		switch {
+		case file.IsDir:
+		case native[file.Name] != "":
+			file.Hash = native[file.Name]
+		case !computable:
+			file.HashSkipped = "unavailable"
+		case file.Size > lh.maxSize:
+			file.HashSkipped = "too_large"
+		default:
+			jobs <- file
+		}
+	}
+	close(jobs)
+	wg.Wait()
+}
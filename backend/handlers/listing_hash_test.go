@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+// storedHashFileSystem reports stored hashes for some files, which
+// deliberately differ from their content so tests can tell them apart
+type storedHashFileSystem struct {
+	storage.FileSystem
+	hashes map[string]string
+}
+
+func (s *storedHashFileSystem) Unwrap() storage.FileSystem { return s.FileSystem }
+
+func (s *storedHashFileSystem) ContentHashes(dirPath, algo string) (map[string]string, error) {
+	if algo != "sha256" {
+		return nil, storage.ErrNotSupported
+	}
+	return s.hashes, nil
+}
+
+func TestFileHandlers_ListDirectoryWithHash(t *testing.T) {
+	root := t.TempDir()
+	contents := map[string]string{
+		"a.txt":   "hello",
+		"b.txt":   "world",
+		"big.bin": strings.Repeat("x", 100),
+	}
+	for name, content := range contents {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(root, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	mgr := storage.NewManager()
+	mgr.Register("local", storage.NewLocalStorage(root))
+	mgr.Register("stored", &storedHashFileSystem{
+		FileSystem: storage.NewLocalStorage(root),
+		hashes:     map[string]string{"b.txt": "stored-b"},
+	})
+	h := NewFileHandlers(mgr)
+
+	var algo string
+	list := func(query string) (int, map[string]storage.FileInfo) {
+		rr := httptest.NewRecorder()
+		h.ListDirectory(rr, httptest.NewRequest("GET", "/api/fs/list?path=/&"+query, nil))
+		if rr.Code != http.StatusOK {
+			return rr.Code, nil
+		}
+		var resp struct {
+			Data struct {
+				Files    []storage.FileInfo `json:"files"`
+				HashAlgo string             `json:"hash_algo"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Failed to decode listing: %v", err)
+		}
+		algo = resp.Data.HashAlgo
+		files := make(map[string]storage.FileInfo)
+		for _, f := range resp.Data.Files {
+			files[f.Name] = f
+		}
+		return rr.Code, files
+	}
+	sha := func(s string) string {
+		sum := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+
+	_, files := list("storage=local&with_hash=sha256&hash_max_size=50")
+	if algo != "sha256" {
+		t.Errorf("Expected hash_algo sha256, got %q", algo)
+	}
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if got := files[name].Hash; got != sha(contents[name]) {
+			t.Errorf("%s: expected hash %s, got %q", name, sha(contents[name]), got)
+		}
+	}
+	if big := files["big.bin"]; big.Hash != "" || big.HashSkipped != "too_large" {
+		t.Errorf("Expected big.bin to be skipped as too large, got %+v", big)
+	}
+	if sub := files["sub"]; sub.Hash != "" || sub.HashSkipped != "" {
+		t.Errorf("Expected no hash for a directory, got %+v", sub)
+	}
+
+	// Stored hashes are used without reading the file
+	_, files = list("storage=stored&with_hash=sha256")
+	if files["b.txt"].Hash != "stored-b" || files["a.txt"].Hash != sha("hello") || files["big.bin"].Hash != sha(contents["big.bin"]) {
+		t.Errorf("Expected the stored hash for b.txt and computed ones for the rest, got %+v", files)
+	}
+
+	// quickxor can only come from the backend
+	_, files = list("storage=local&with_hash=quickxor")
+	if files["a.txt"].HashSkipped != "unavailable" {
+		t.Errorf("Expected quickxor to be unavailable on local storage, got %+v", files["a.txt"])
+	}
+
+	_, files = list("storage=local")
+	if files["a.txt"].Hash != "" {
+		t.Errorf("Expected no hashes without with_hash, got %+v", files["a.txt"])
+	}
+
+	for _, query := range []string{"with_hash=whirlpool", "with_hash=md5&hash_max_size=-1", "with_hash=md5&hash_max_size=99999999999"} {
+		if code, _ := list("storage=local&" + query); code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, code)
+		}
+	}
+}
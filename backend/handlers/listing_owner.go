@@ -0,0 +1,21 @@
+package handlers
+
+import (
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+// withOwner fills in the owner and group of info on backends that have
+// them. Entries whose owner can't be read are returned unchanged.
+func withOwner(fs storage.FileSystem, info storage.FileInfo) storage.FileInfo {
+	reader, ok := storage.As[storage.OwnerReader](fs)
+	if !ok {
+		return info
+	}
+	owner, group, err := reader.Owner(info.Path)
+	if err != nil {
+		return info
+	}
+	info.Owner = owner
+	info.Group = group
+	return info
+}
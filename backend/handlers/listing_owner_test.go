@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+func TestFileHandlers_ListDirectoryWithOwner(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "a.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	wantOwner := strconv.Itoa(os.Getuid())
+	if u, err := user.LookupId(wantOwner); err == nil {
+		wantOwner = u.Username
+	}
+	wantGroup := strconv.Itoa(os.Getgid())
+	if g, err := user.LookupGroupId(wantGroup); err == nil {
+		wantGroup = g.Name
+	}
+
+	mgr := storage.NewManager()
+	mgr.Register("local", storage.NewLocalStorage(root))
+	mock := newMockFileSystem()
+	mock.files["/a.txt"] = []byte("hello")
+	mgr.Register("mock", mock)
+	h := NewFileHandlers(mgr)
+
+	list := func(query string) storage.FileInfo {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		h.ListDirectory(rr, httptest.NewRequest("GET", "/api/fs/list?path=/&"+query, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var resp struct {
+			Data struct {
+				Files []storage.FileInfo `json:"files"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Failed to decode listing: %v", err)
+		}
+		if len(resp.Data.Files) != 1 {
+			t.Fatalf("Expected one entry, got %+v", resp.Data.Files)
+		}
+		return resp.Data.Files[0]
+	}
+
+	if file := list("storage=local"); file.Owner != "" || file.Group != "" {
+		t.Errorf("Expected no owner unless asked for, got %+v", file)
+	}
+	if file := list("storage=local&with_owner=true"); file.Owner != wantOwner || file.Group != wantGroup {
+		t.Errorf("Expected owner %s:%s, got %s:%s", wantOwner, wantGroup, file.Owner, file.Group)
+	}
+	// Paged listings are filled in the same way
+	if file := list("storage=local&with_owner=true&limit=10"); file.Owner != wantOwner || file.Group != wantGroup {
+		t.Errorf("Expected owner %s:%s in a paged listing, got %s:%s", wantOwner, wantGroup, file.Owner, file.Group)
+	}
+	if file := list("storage=mock&with_owner=true"); file.Owner != "" || file.Group != "" {
+		t.Errorf("Expected no owner from a backend without ownership, got %+v", file)
+	}
+}
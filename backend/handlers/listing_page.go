@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"cmp"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+// listingPage is the sorting and slicing asked of a directory listing with
+// the sort, order, offset and limit parameters
+type listingPage struct {
+	sortBy string // "name", "size" or "modified"
+	desc   bool
+	offset int
+	limit  int // 0 for no limit
+}
+
+// parseListingPage reads the paging parameters of a listing, returning nil
+// when none are set so the listing can be streamed as it's read
+func parseListingPage(query url.Values) (*listingPage, error) {
+	if query.Get("sort") == "" && query.Get("order") == "" && query.Get("offset") == "" && query.Get("limit") == "" {
+		return nil, nil
+	}
+
+	p := &listingPage{sortBy: "name"}
+	if value := query.Get("sort"); value != "" {
+		switch value {
+		case "name", "size", "modified":
+			p.sortBy = value
+		default:
+			return nil, fmt.Errorf("sort must be name, size or modified")
+		}
+	}
+	switch query.Get("order") {
+	case "", "asc":
+	case "desc":
+		p.desc = true
+	default:
+		return nil, fmt.Errorf("order must be asc or desc")
+	}
+
+	var err error
+	if value := query.Get("offset"); value != "" {
+		if p.offset, err = strconv.Atoi(value); err != nil || p.offset < 0 {
+			return nil, fmt.Errorf("offset must be a non-negative integer")
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		if p.limit, err = strconv.Atoi(value); err != nil || p.limit < 1 {
+			return nil, fmt.Errorf("limit must be a positive integer")
+		}
+	}
+	return p, nil
+}
+
+// less orders two entries by the page's sort field, falling back to the
+// name on ties. Sorting by name keeps directories ahead of files whichever
+// the order.
+func (p *listingPage) less(a, b storage.FileInfo) bool {
+	if p.sortBy == "name" && a.IsDir != b.IsDir {
+		return a.IsDir
+	}
+
+	var order int
+	switch p.sortBy {
+	case "size":
+		order = cmp.Compare(a.Size, b.Size)
+	case "modified":
+		order = a.ModTime.Compare(b.ModTime)
+	}
+	if order == 0 {
+		order = strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+	}
+	if order == 0 {
+		order = strings.Compare(a.Name, b.Name)
+	}
+	if p.desc {
+		return order > 0
+	}
+	return order < 0
+}
+
+// apply sorts files and returns the requested page of them, with the
+// number of entries before slicing and whether any follow the page
+func (p *listingPage) apply(files []storage.FileInfo) (page []storage.FileInfo, total int, hasMore bool) {
+	sort.SliceStable(files, func(i, j int) bool { return p.less(files[i], files[j]) })
+
+	total = len(files)
+	start := p.offset
+	if start > total {
+		start = total
+	}
+	end := total
+	if p.limit > 0 && p.limit < total-start {
+		end = start + p.limit
+	}
+	return files[start:end], total, end < total
+}
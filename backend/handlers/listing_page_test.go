@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+func TestFileHandlers_ListDirectoryPaging(t *testing.T) {
+	root := t.TempDir()
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, name := range []string{"b.txt", "Zeta", "a.txt", "alpha", "c.txt"} {
+		full := filepath.Join(root, name)
+		if strings.HasSuffix(name, ".txt") {
+			if err := os.WriteFile(full, []byte(strings.Repeat("x", 10*(i+1))), 0644); err != nil {
+				t.Fatal(err)
+			}
+		} else if err := os.Mkdir(full, 0755); err != nil {
+			t.Fatal(err)
+		}
+		modTime := base.Add(time.Duration(i) * time.Hour)
+		if err := os.Chtimes(full, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mgr := storage.NewManager()
+	mgr.Register("local", storage.NewLocalStorage(root))
+	h := NewFileHandlers(mgr)
+
+	type listing struct {
+		Files []struct {
+			Name string `json:"name"`
+		} `json:"files"`
+		Count        int  `json:"count"`
+		TotalEntries int  `json:"total_entries"`
+		HasMore      bool `json:"has_more"`
+	}
+	list := func(query string) (int, listing) {
+		rr := httptest.NewRecorder()
+		h.ListDirectory(rr, httptest.NewRequest("GET", "/api/fs/list?storage=local&path=/&"+query, nil))
+		var resp struct {
+			Data listing `json:"data"`
+		}
+		if rr.Code == http.StatusOK {
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("Failed to decode listing: %v", err)
+			}
+		}
+		return rr.Code, resp.Data
+	}
+	names := func(l listing) string {
+		var out []string
+		for _, f := range l.Files {
+			out = append(out, f.Name)
+		}
+		return strings.Join(out, ",")
+	}
+
+	for _, tt := range []struct {
+		query   string
+		want    string
+		hasMore bool
+	}{
+		{"sort=name", "alpha,Zeta,a.txt,b.txt,c.txt", false},
+		{"sort=name&order=desc", "Zeta,alpha,c.txt,b.txt,a.txt", false},
+		{"sort=size&order=desc&calc_sizes=true", "c.txt,a.txt,b.txt,Zeta,alpha", false},
+		{"sort=modified", "b.txt,Zeta,a.txt,alpha,c.txt", false},
+		{"limit=2", "alpha,Zeta", true},
+		{"limit=2&offset=2", "a.txt,b.txt", true},
+		{"limit=2&offset=4", "c.txt", false},
+		{"offset=10", "", false},
+		{"offset=1&limit=9223372036854775807", "Zeta,a.txt,b.txt,c.txt", false},
+		{"sort=name&limit=2&offset=1&calc_sizes=true", "Zeta,a.txt", true},
+	} {
+		code, l := list(tt.query)
+		if code != http.StatusOK {
+			t.Errorf("%s: expected 200, got %d", tt.query, code)
+			continue
+		}
+		if got := names(l); got != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.query, tt.want, got)
+		}
+		if l.TotalEntries != 5 || l.HasMore != tt.hasMore || l.Count != len(l.Files) {
+			t.Errorf("%s: expected total_entries 5 and has_more %v, got %+v", tt.query, tt.hasMore, l)
+		}
+	}
+
+	for _, query := range []string{"sort=type", "order=up", "limit=0", "limit=x", "offset=-1"} {
+		if code, _ := list(query); code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, code)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+// listingFlushEvery is the number of entries written between flushes of a
+// streamed directory listing
+const listingFlushEvery = 500
+
+// listingStream writes the ListDirectory response envelope incrementally so
+// large listings don't have to be built in memory before encoding. The
+// envelope is only started once the first entry arrives, which lets errors
+// that happen before that still be reported with a proper status code.
+type listingStream struct {
+	w       http.ResponseWriter
+	enc     *json.Encoder
+	rc      *http.ResponseController
+	path    string
+	fields  *fieldProjection
+	started bool
+	count   int
+	err     error
+}
+
+func newListingStream(w http.ResponseWriter, path string, fields *fieldProjection) *listingStream {
+	return &listingStream{
+		w:      w,
+		enc:    json.NewEncoder(w),
+		rc:     http.NewResponseController(w),
+		path:   path,
+		fields: fields,
+	}
+}
+
+// write writes raw JSON, remembering the first error
+func (ls *listingStream) write(s string) {
+	if ls.err != nil {
+		return
+	}
+	_, ls.err = ls.w.Write([]byte(s))
+}
+
+// start writes the response headers and the envelope up to the files array
+func (ls *listingStream) start() {
+	ls.started = true
+	ls.w.Header().Set("Content-Type", "application/json")
+	ls.w.WriteHeader(http.StatusOK)
+
+	pathJSON, _ := json.Marshal(ls.path)
+	ls.write(`{"success":true,"data":{"path":` + string(pathJSON) + `,"files":[`)
+}
+
+// Add encodes a single entry
+func (ls *listingStream) Add(info storage.FileInfo) error {
+	if !ls.started {
+		ls.start()
+	}
+	if ls.count > 0 {
+		ls.write(",")
+	}
+	if ls.err == nil {
+		ls.err = ls.enc.Encode(ls.fields.apply(info))
+	}
+	ls.count++
+
+	// A writer that can't flush sends the listing when it can
+	if ls.count%listingFlushEvery == 0 && ls.err == nil {
+		_ = ls.rc.Flush()
+	}
+	return ls.err
+}
+
+// Started reports whether any part of the response has been written
+func (ls *listingStream) Started() bool {
+	return ls.started
+}
+
+// Finish closes the files array and writes the remaining envelope fields
+func (ls *listingStream) Finish(available, total int64) {
+	if !ls.started {
+		ls.start()
+	}
+
+	tail, _ := json.Marshal(map[string]interface{}{
+		"count":     ls.count,
+		"available": available,
+		"total":     total,
+	})
+	// Splice the trailing fields into the open data object
+	ls.write("]," + string(tail[1:len(tail)-1]) + "}}\n")
+
+	if ls.err != nil {
+		log.Printf("Error writing listing response: %v", ls.err)
+	}
+}
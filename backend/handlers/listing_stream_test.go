@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+// generatedFileSystem produces n synthetic entries for any directory
+type generatedFileSystem struct {
+	*mockFileSystem
+	n int
+}
+
+func (g *generatedFileSystem) entry(i int) storage.FileInfo {
+	return storage.FileInfo{
+		Name:        fmt.Sprintf("file-%06d.txt", i),
+		Path:        fmt.Sprintf("/big/file-%06d.txt", i),
+		Size:        int64(i),
+		ModTime:     time.Unix(1700000000, 0),
+		Permissions: "-rw-r--r--",
+		MimeType:    "text/plain",
+	}
+}
+
+func (g *generatedFileSystem) List(path string) ([]storage.FileInfo, error) {
+	files := make([]storage.FileInfo, 0, g.n)
+	for i := 0; i < g.n; i++ {
+		files = append(files, g.entry(i))
+	}
+	return files, nil
+}
+
+func (g *generatedFileSystem) ListFunc(path string, fn func(storage.FileInfo) error) error {
+	for i := 0; i < g.n; i++ {
+		if err := fn(g.entry(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// discardResponseWriter drops the body so benchmarks only measure the
+// handler. It samples the live heap every few writes to track peak usage.
+type discardResponseWriter struct {
+	header   http.Header
+	writes   int
+	peakHeap uint64
+}
+
+func (d *discardResponseWriter) Header() http.Header { return d.header }
+func (d *discardResponseWriter) WriteHeader(int)     {}
+
+func (d *discardResponseWriter) Write(p []byte) (int, error) {
+	if d.writes%1000 == 0 || len(p) > 1<<20 {
+		var stats runtime.MemStats
+		runtime.ReadMemStats(&stats)
+		if stats.HeapAlloc > d.peakHeap {
+			d.peakHeap = stats.HeapAlloc
+		}
+	}
+	d.writes++
+	return len(p), nil
+}
+
+func TestFileHandlers_ListDirectoryStreamed(t *testing.T) {
+	mgr := storage.NewManager()
+	mgr.Register("gen", &generatedFileSystem{mockFileSystem: newMockFileSystem(), n: 1234})
+	mgr.Register("empty", &generatedFileSystem{mockFileSystem: newMockFileSystem()})
+	handler := NewFileHandlers(mgr)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/api/fs/list", handler.ListDirectory).Methods("GET")
+
+	var resp struct {
+		Success bool `json:"success"`
+		Data    struct {
+			Path      string             `json:"path"`
+			Files     []storage.FileInfo `json:"files"`
+			Count     int                `json:"count"`
+			Available int64              `json:"available"`
+		} `json:"data"`
+	}
+
+	t.Run("Large listing", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/fs/list?storage=gen&path=/big", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Failed to decode streamed listing: %v", err)
+		}
+		if !resp.Success || resp.Data.Path != "/big" {
+			t.Errorf("Unexpected envelope: success=%v path=%s", resp.Success, resp.Data.Path)
+		}
+		if resp.Data.Count != 1234 || len(resp.Data.Files) != 1234 {
+			t.Errorf("Expected 1234 entries, got count=%d files=%d", resp.Data.Count, len(resp.Data.Files))
+		}
+		if resp.Data.Files[42].Name != "file-000042.txt" {
+			t.Errorf("Unexpected entry: %+v", resp.Data.Files[42])
+		}
+		if resp.Data.Available != 1000000 {
+			t.Errorf("Expected available space in envelope, got %d", resp.Data.Available)
+		}
+	})
+
+	t.Run("Empty listing", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/fs/list?storage=empty&path=/", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Failed to decode empty listing: %v", err)
+		}
+		if resp.Data.Count != 0 {
+			t.Errorf("Expected 0 entries, got %d", resp.Data.Count)
+		}
+	})
+}
+
+const benchListingSize = 100000
+
+func BenchmarkListDirectory_Streamed(b *testing.B) {
+	mgr := storage.NewManager()
+	mgr.Register("gen", &generatedFileSystem{mockFileSystem: newMockFileSystem(), n: benchListingSize})
+	handler := NewFileHandlers(mgr)
+	req := httptest.NewRequest("GET", "/api/fs/list?storage=gen&path=/big", nil)
+
+	var peak uint64
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		runtime.GC()
+		w := &discardResponseWriter{header: http.Header{}}
+		handler.ListDirectory(w, req)
+		if w.peakHeap > peak {
+			peak = w.peakHeap
+		}
+	}
+	b.ReportMetric(float64(peak)/(1<<20), "peak-heap-MB")
+}
+
+func BenchmarkListDirectory_Buffered(b *testing.B) {
+	fs := &generatedFileSystem{mockFileSystem: newMockFileSystem(), n: benchListingSize}
+
+	var peak uint64
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		runtime.GC()
+		w := &discardResponseWriter{header: http.Header{}}
+		files, _ := fs.List("/big")
+		available, total, _ := fs.GetAvailableSpace()
+		successResponse(w, map[string]interface{}{
+			"path":      "/big",
+			"files":     files,
+			"count":     len(files),
+			"available": available,
+			"total":     total,
+		})
+		if w.peakHeap > peak {
+			peak = w.peakHeap
+		}
+	}
+	b.ReportMetric(float64(peak)/(1<<20), "peak-heap-MB")
+}
+
+func TestFileHandlers_ListDirectoryDirsOnly(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"docs", "photos", "photos/2024"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+	}
+	for _, file := range []string{"readme.txt", "docs/spec.md", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(root, file), []byte("x"), 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+	if err := os.Symlink("docs", filepath.Join(root, "docs-link")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	mgr := storage.NewManager()
+	mgr.Register("local", storage.NewLocalStorage(root))
+	h := NewFileHandlers(mgr)
+
+	for _, query := range []string{"dirs_only=true", "dirs_only=true&calc_sizes=true"} {
+		rr := httptest.NewRecorder()
+		h.ListDirectory(rr, httptest.NewRequest("GET", "/api/fs/list?storage=local&path=/&"+query, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d: %s", query, rr.Code, rr.Body.String())
+		}
+		var resp struct {
+			Data struct {
+				Files []storage.FileInfo `json:"files"`
+				Count int                `json:"count"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s: failed to decode listing: %v", query, err)
+		}
+		var names []string
+		for _, f := range resp.Data.Files {
+			if !f.IsDir {
+				t.Errorf("%s: expected only directories, got file %s", query, f.Name)
+			}
+			names = append(names, f.Name)
+		}
+		sort.Strings(names)
+		if strings.Join(names, ",") != "docs,photos" || resp.Data.Count != 2 {
+			t.Errorf("%s: expected docs and photos, got %v (count %d)", query, names, resp.Data.Count)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+// moveError names the file a cross-storage move failed on. Placed is set
+// when some files were put in place and couldn't be taken back, so they
+// are now in both storages.
+type moveError struct {
+	File   string
+	Err    error
+	Placed bool
+}
+
+func (e *moveError) Error() string {
+	return fmt.Sprintf("%s: %v", e.File, e.Err)
+}
+
+func (e *moveError) Unwrap() error {
+	return e.Err
+}
+
+// stagedMove is one file of a cross-storage move. replace is set when dst
+// existed and is to be overwritten.
+type stagedMove struct {
+	src, staged, dst string
+	replace          bool
+}
+
+// moveAcrossStorages moves files from srcDir on srcFS to dstDir on dstFS
+// as one unit. Everything is first copied into a staging directory next
+// to the destination; only once every copy succeeded is it put in place
+// and are the sources deleted. If a copy fails, the staged copies are
+// removed and the sources left alone, so a failed move changes nothing.
+// Putting staged files in place is a rename on the destination and rarely
+// fails; files it replaces are renamed into the staging directory rather
+// than deleted, so if it does fail everything placed so far is taken back.
+// The error is a *moveError naming the file that failed.
+func (h *FileHandlers) moveAcrossStorages(srcFS, dstFS storage.FileSystem, srcDir, dstDir string, files []string, exclude *storage.ExcludeFilter, policy string) ([]conflictReport, error) {
+	reports := make([]conflictReport, len(files))
+	if len(files) == 0 {
+		return reports, nil
+	}
+	moves := make([]stagedMove, len(files))
+	staging := filepath.Join(dstDir, fmt.Sprintf(".jacommander-move-%d", time.Now().UnixNano()))
+	if err := dstFS.MkDir(staging); err != nil {
+		return nil, fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	defer func() {
+		if _, err := storage.DeleteTree(dstFS, staging, nil); err != nil {
+			log.Printf("Warning: failed to remove staging directory %s: %v", staging, err)
+		}
+	}()
+
+	dests := newBatchDestinations(dstDir, files)
+	errs := h.runBatch(files, func(i int, file string) error {
+		srcPath := filepath.Join(srcDir, file)
+		dst, action, _, err := planConflict(srcFS, srcPath, dstFS, filepath.Join(dstDir, file), policy, dests, i)
+		if err != nil {
+			return err
+		}
+		reports[i] = conflictReport{File: file, Action: action, Destination: dst}
+		if action == actionSkipped {
+			return nil
+		}
+
+		info, err := srcFS.Stat(srcPath)
+		if err != nil {
+			return err
+		}
+		staged := filepath.Join(staging, strconv.Itoa(i))
+		if info.IsDir {
+			err = h.copyDirectoryCrossStorage(srcFS, dstFS, srcPath, staged, exclude)
+		} else {
+			err = copyFileCrossStorage(srcFS, dstFS, srcPath, staged)
+		}
+		moves[i] = stagedMove{src: srcPath, staged: staged, dst: dst, replace: action == actionOverwritten}
+		return err
+	}, srcFS, dstFS)
+	for i, err := range errs {
+		if err != nil {
+			return nil, &moveError{File: files[i], Err: err}
+		}
+	}
+
+	placed := &placement{fs: dstFS, aside: staging}
+	for i, move := range moves {
+		if move.staged == "" {
+			continue
+		}
+		if err := placed.place(move); err != nil {
+			if undoErr := placed.undo(); undoErr != nil {
+				log.Printf("Error taking back a failed move: %v", undoErr)
+				return nil, &moveError{File: files[i], Err: err, Placed: true}
+			}
+			return nil, &moveError{File: files[i], Err: err}
+		}
+	}
+
+	for _, move := range moves {
+		if move.staged == "" {
+			continue
+		}
+		if _, err := storage.DeleteTree(srcFS, move.src, exclude); err != nil {
+			// The file arrived, so the move still counts
+			log.Printf("Warning: failed to delete source after move: %s: %v", move.src, err)
+		}
+	}
+	return reports, nil
+}
+
+// placement puts staged copies in place on fs, keeping what it did so a
+// failed move can be taken back. Entries it replaces are renamed into
+// aside, which is removed with the staging directory once the move is
+// done.
+type placement struct {
+	fs    storage.FileSystem
+	aside string
+	done  []placementStep
+}
+
+// placementStep is one rename made by a placement
+type placementStep struct {
+	from, to string
+}
+
+// rename moves from to to, failing rather than replacing anything that
+// is at to by now
+func (p *placement) rename(from, to string) error {
+	if _, err := p.fs.Stat(to); err == nil {
+		return fmt.Errorf("%s was created during the move: %w", to, os.ErrExist)
+	}
+	if err := storage.Move(p.fs, from, to); err != nil {
+		return err
+	}
+	p.done = append(p.done, placementStep{from: from, to: to})
+	return nil
+}
+
+// replace renames the file at to aside and moves from into its place
+func (p *placement) replace(from, to string) error {
+	aside := filepath.Join(p.aside, fmt.Sprintf("replaced-%d", len(p.done)))
+	if err := p.rename(to, aside); err != nil {
+		return fmt.Errorf("failed to move %s aside to overwrite it: %w", to, err)
+	}
+	return p.rename(from, to)
+}
+
+// place moves a staged copy to its destination. A file that existed when
+// the move was planned is replaced; a directory moved onto an existing one
+// is merged into it entry by entry, and every clash is checked before
+// anything is moved.
+func (p *placement) place(move stagedMove) error {
+	existing, err := p.fs.Stat(move.dst)
+	if err != nil {
+		return p.rename(move.staged, move.dst)
+	}
+	if !existing.IsDir {
+		if !move.replace {
+			return fmt.Errorf("%s was created during the move: %w", move.dst, os.ErrExist)
+		}
+		return p.replace(move.staged, move.dst)
+	}
+
+	// Entries missing from the destination are moved whole; files that
+	// exist there are replaced
+	type step struct {
+		from, to string
+		replace  bool
+	}
+	var steps []step
+	err = storage.Walk(p.fs, move.staged, func(file storage.FileInfo) error {
+		rel, err := filepath.Rel(move.staged, file.Path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(move.dst, rel)
+		isDir := file.IsDir && !file.IsLink
+		existing, err := p.fs.Stat(target)
+		if err != nil {
+			steps = append(steps, step{from: file.Path, to: target})
+			if isDir {
+				return storage.SkipDir
+			}
+			return nil
+		}
+		if err := checkReplaceable(isDir, existing.IsDir, target); err != nil {
+			return err
+		}
+		if !isDir {
+			steps = append(steps, step{from: file.Path, to: target, replace: true})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, step := range steps {
+		if step.replace {
+			err = p.replace(step.from, step.to)
+		} else {
+			err = p.rename(step.from, step.to)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// undo takes back every rename in reverse, so the destination holds what
+// it did before and the staged copies are back in staging
+func (p *placement) undo() error {
+	var failed []string
+	for i := len(p.done) - 1; i >= 0; i-- {
+		step := p.done[i]
+		if err := storage.Move(p.fs, step.to, step.from); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", step.to, err))
+		}
+	}
+	p.done = nil
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to take back %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
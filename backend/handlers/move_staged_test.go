@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+// failingWriteFileSystem fails writes of files with a given name, and
+// moves onto files named failMove
+type failingWriteFileSystem struct {
+	storage.FileSystem
+	failName string
+	failMove string
+}
+
+func (f *failingWriteFileSystem) Move(src, dst string) error {
+	if f.failMove != "" && filepath.Base(dst) == f.failMove {
+		return errors.New("device busy")
+	}
+	return f.FileSystem.Move(src, dst)
+}
+
+func (f *failingWriteFileSystem) Write(path string, data io.Reader) error {
+	if filepath.Base(path) == f.failName {
+		return errors.New("disk full")
+	}
+	return f.FileSystem.Write(path, data)
+}
+
+func TestFileHandlers_MoveAcrossStorages(t *testing.T) {
+	setup := func(t *testing.T, failName, failMove string) (*FileHandlers, string, string) {
+		srcRoot, dstRoot := t.TempDir(), t.TempDir()
+		for root, files := range map[string]map[string]string{
+			srcRoot: {
+				"inbox/notes.txt":        "notes",
+				"inbox/photos/a.jpg":     "a",
+				"inbox/photos/b.jpg":     "b",
+				"inbox/photos/raw/c.raw": "c",
+			},
+			dstRoot: {
+				"archive/notes.txt":    "old notes",
+				"archive/photos/a.jpg": "old a",
+				"archive/photos/z.jpg": "z",
+			},
+		} {
+			for name, content := range files {
+				path := filepath.Join(root, name)
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		mgr := storage.NewManager()
+		mgr.Register("src", storage.NewLocalStorage(srcRoot))
+		mgr.Register("dst", &failingWriteFileSystem{FileSystem: storage.NewLocalStorage(dstRoot), failName: failName, failMove: failMove})
+		return NewFileHandlers(mgr), srcRoot, dstRoot
+	}
+	moveBody := `{"src_storage": "src", "dst_storage": "dst", "src_path": "/inbox", "dst_path": "/archive", "files": ["notes.txt", "photos"]}`
+	move := func(h *FileHandlers, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		h.MoveFiles(rr, httptest.NewRequest("POST", "/api/fs/move", strings.NewReader(body)))
+		return rr
+	}
+	read := func(root, name string) string {
+		data, err := os.ReadFile(filepath.Join(root, name))
+		if err != nil {
+			return ""
+		}
+		return string(data)
+	}
+	entries := func(root string) []string {
+		list, _ := os.ReadDir(filepath.Join(root, "archive"))
+		var names []string
+		for _, entry := range list {
+			names = append(names, entry.Name())
+		}
+		return names
+	}
+
+	t.Run("Moved", func(t *testing.T) {
+		h, srcRoot, dstRoot := setup(t, "", "")
+		if rr := move(h, moveBody); rr.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		for name, want := range map[string]string{
+			"archive/notes.txt":        "notes",
+			"archive/photos/a.jpg":     "a",
+			"archive/photos/b.jpg":     "b",
+			"archive/photos/z.jpg":     "z",
+			"archive/photos/raw/c.raw": "c",
+		} {
+			if got := read(dstRoot, name); got != want {
+				t.Errorf("Expected %s to hold %q, got %q", name, want, got)
+			}
+		}
+		if got := strings.Join(entries(dstRoot), ","); got != "notes.txt,photos" {
+			t.Errorf("Expected no staging directory left, got %s", got)
+		}
+		if _, err := os.Stat(filepath.Join(srcRoot, "inbox", "photos")); !os.IsNotExist(err) {
+			t.Error("Expected the sources to be deleted")
+		}
+	})
+
+	t.Run("Rolled back", func(t *testing.T) {
+		h, srcRoot, dstRoot := setup(t, "b.jpg", "")
+		rr := move(h, moveBody)
+		if rr.Code == http.StatusOK || rr.Code == http.StatusPartialContent {
+			t.Fatalf("Expected the move to fail, got %d", rr.Code)
+		}
+		var resp struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+		if !strings.Contains(resp.Error.Message, "photos: ") || !strings.Contains(resp.Error.Message, "disk full") {
+			t.Errorf("Expected the error to name the file that failed, got %q", resp.Error.Message)
+		}
+
+		if read(dstRoot, "archive/notes.txt") != "old notes" || read(dstRoot, "archive/photos/a.jpg") != "old a" {
+			t.Error("Expected the destination to be left as it was")
+		}
+		if got := strings.Join(entries(dstRoot), ","); got != "notes.txt,photos" {
+			t.Errorf("Expected the staged copies to be removed, got %s", got)
+		}
+		if read(srcRoot, "inbox/notes.txt") != "notes" || read(srcRoot, "inbox/photos/b.jpg") != "b" {
+			t.Error("Expected the sources to be left alone")
+		}
+	})
+
+	t.Run("Placement taken back", func(t *testing.T) {
+		h, srcRoot, dstRoot := setup(t, "", "b.jpg")
+		rr := move(h, moveBody)
+		if rr.Code == http.StatusOK || !strings.Contains(rr.Body.String(), "nothing was moved") {
+			t.Fatalf("Expected the move to fail with nothing moved, got %d: %s", rr.Code, rr.Body.String())
+		}
+		for name, want := range map[string]string{
+			"archive/notes.txt":    "old notes",
+			"archive/photos/a.jpg": "old a",
+			"archive/photos/z.jpg": "z",
+			"archive/photos/b.jpg": "",
+		} {
+			if got := read(dstRoot, name); got != want {
+				t.Errorf("Expected %s to hold %q, got %q", name, want, got)
+			}
+		}
+		if got := strings.Join(entries(dstRoot), ","); got != "notes.txt,photos" {
+			t.Errorf("Expected the staging directory removed, got %s", got)
+		}
+		if read(srcRoot, "inbox/notes.txt") != "notes" || read(srcRoot, "inbox/photos/b.jpg") != "b" {
+			t.Error("Expected the sources to be left alone")
+		}
+	})
+
+	t.Run("Renamed files keep apart", func(t *testing.T) {
+		h, srcRoot, dstRoot := setup(t, "", "")
+		if err := os.WriteFile(filepath.Join(srcRoot, "inbox", "notes (1).txt"), []byte("notes 1"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		body := `{"src_storage": "src", "dst_storage": "dst", "src_path": "/inbox", "dst_path": "/archive", "files": ["notes.txt", "notes (1).txt"], "on_conflict": "rename"}`
+		if rr := move(h, body); rr.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		for name, want := range map[string]string{
+			"archive/notes.txt":     "old notes",
+			"archive/notes (1).txt": "notes 1",
+			"archive/notes (2).txt": "notes",
+		} {
+			if got := read(dstRoot, name); got != want {
+				t.Errorf("Expected %s to hold %q, got %q", name, want, got)
+			}
+		}
+	})
+
+	t.Run("Duplicate destination", func(t *testing.T) {
+		h, srcRoot, dstRoot := setup(t, "", "")
+		body := `{"src_storage": "src", "dst_storage": "dst", "src_path": "/inbox", "dst_path": "/archive", "files": ["photos", "photos/"]}`
+		if rr := move(h, body); rr.Code != http.StatusConflict {
+			t.Errorf("Expected 409, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if read(dstRoot, "archive/photos/b.jpg") != "" || read(srcRoot, "inbox/photos/b.jpg") != "b" {
+			t.Error("Expected nothing to be moved")
+		}
+	})
+}
@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Operation is a long-running background operation tracked by the registry
+type Operation struct {
+	ID        string
+	Type      string
+	Client    string
+	Storage   string
+	Paths     []string
+	StartedAt time.Time
+
+	ctx      context.Context
+	cancel   context.CancelFunc
+	finished chan struct{} // closed by Finish
+
+	mu      sync.Mutex
+	current int64
+	total   int64
+}
+
+// OperationInfo is a point-in-time snapshot of an operation
+type OperationInfo struct {
+	ID         string   `json:"id"`
+	Type       string   `json:"type"`
+	Client     string   `json:"client"`
+	Storage    string   `json:"storage"`
+	Paths      []string `json:"paths"`
+	StartedAt  int64    `json:"started_at"`
+	Runtime    float64  `json:"runtime_seconds"`
+	Current    int64    `json:"current"`
+	Total      int64    `json:"total"`
+	Percentage float64  `json:"percentage"`
+}
+
+// Context returns the context that is cancelled when the operation is killed
+func (op *Operation) Context() context.Context {
+	return op.ctx
+}
+
+// SetProgress records the operation's progress
+func (op *Operation) SetProgress(current, total int64) {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	op.current = current
+	op.total = total
+}
+
+// Info returns a snapshot of the operation
+func (op *Operation) Info() OperationInfo {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+
+	percentage := float64(0)
+	if op.total > 0 {
+		percentage = float64(op.current) / float64(op.total) * 100
+	}
+
+	return OperationInfo{
+		ID:         op.ID,
+		Type:       op.Type,
+		Client:     op.Client,
+		Storage:    op.Storage,
+		Paths:      op.Paths,
+		StartedAt:  op.StartedAt.Unix(),
+		Runtime:    time.Since(op.StartedAt).Seconds(),
+		Current:    op.current,
+		Total:      op.total,
+		Percentage: percentage,
+	}
+}
+
+// OperationRegistry keeps track of active background operations
+type OperationRegistry struct {
+	mu  sync.RWMutex
+	ops map[string]*Operation
+}
+
+// NewOperationRegistry creates a new operation registry
+func NewOperationRegistry() *OperationRegistry {
+	return &OperationRegistry{
+		ops: make(map[string]*Operation),
+	}
+}
+
+// Start registers a new operation and returns it. The caller must call
+// Finish when the operation ends, whatever the outcome.
+func (r *OperationRegistry) Start(opType, client, storageID string, paths []string) *Operation {
+	ctx, cancel := context.WithCancel(context.Background())
+	op := &Operation{
+		ID:        opType + "-" + randomString(16),
+		Type:      opType,
+		Client:    client,
+		Storage:   storageID,
+		Paths:     paths,
+		StartedAt: time.Now(),
+		ctx:       ctx,
+		cancel:    cancel,
+		finished:  make(chan struct{}),
+	}
+
+	r.mu.Lock()
+	r.ops[op.ID] = op
+	r.mu.Unlock()
+
+	return op
+}
+
+// Finish removes an operation from the registry and releases its context
+func (r *OperationRegistry) Finish(id string) {
+	r.mu.Lock()
+	op, ok := r.ops[id]
+	delete(r.ops, id)
+	r.mu.Unlock()
+
+	if ok {
+		op.cancel()
+		close(op.finished)
+	}
+}
+
+// Drain waits for the active operations to finish, for use on shutdown.
+// Those still running when ctx ends are cancelled, which makes them remove
+// their partial output, and given up to cleanup more to stop. It returns
+// how many finished on their own and how many were cancelled, with an
+// error if some of those never stopped.
+func (r *OperationRegistry) Drain(ctx context.Context, cleanup time.Duration) (drained, cancelled int, err error) {
+	r.mu.RLock()
+	ops := make([]*Operation, 0, len(r.ops))
+	for _, op := range r.ops {
+		ops = append(ops, op)
+	}
+	r.mu.RUnlock()
+
+	running := waitFinished(ctx, ops)
+	drained = len(ops) - len(running)
+	if len(running) == 0 {
+		return drained, 0, nil
+	}
+
+	for _, op := range running {
+		op.cancel()
+	}
+	cleanupCtx, cancel := context.WithTimeout(context.Background(), cleanup)
+	defer cancel()
+	if stuck := waitFinished(cleanupCtx, running); len(stuck) > 0 {
+		err = fmt.Errorf("%d cancelled operations did not stop within %s", len(stuck), cleanup)
+	}
+	return drained, len(running), err
+}
+
+// waitFinished waits for ops to finish until ctx ends, returning those
+// still running
+func waitFinished(ctx context.Context, ops []*Operation) []*Operation {
+	var running []*Operation
+	for _, op := range ops {
+		select {
+		case <-op.finished:
+			continue
+		default:
+		}
+		select {
+		case <-op.finished:
+		case <-ctx.Done():
+			running = append(running, op)
+		}
+	}
+	return running
+}
+
+// Cancel cancels an active operation. It reports whether the operation
+// was found.
+func (r *OperationRegistry) Cancel(id string) bool {
+	r.mu.RLock()
+	op, ok := r.ops[id]
+	r.mu.RUnlock()
+
+	if ok {
+		op.cancel()
+	}
+	return ok
+}
+
+// Get returns an active operation by ID
+func (r *OperationRegistry) Get(id string) (*Operation, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	op, ok := r.ops[id]
+	return op, ok
+}
+
+// List returns snapshots of all active operations, oldest first
+func (r *OperationRegistry) List() []OperationInfo {
+	r.mu.RLock()
+	ops := make([]*Operation, 0, len(r.ops))
+	for _, op := range r.ops {
+		ops = append(ops, op)
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(ops, func(i, j int) bool {
+		return ops[i].StartedAt.Before(ops[j].StartedAt)
+	})
+
+	infos := make([]OperationInfo, 0, len(ops))
+	for _, op := range ops {
+		infos = append(infos, op.Info())
+	}
+	return infos
+}
+
+// clientFromRequest identifies the client that started an operation
+func clientFromRequest(r *http.Request) string {
+	if id := r.Header.Get("X-Client-ID"); id != "" {
+		return id
+	}
+	return r.RemoteAddr
+}
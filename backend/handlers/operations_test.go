@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+func TestOperationRegistry_Drain(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+
+	fs := &endlessFileSystem{newMockFileSystem()}
+	fs.files["/big.bin"] = []byte("placeholder")
+	mgr := storage.NewManager()
+	mgr.Register("mock", fs)
+
+	operations := NewOperationRegistry()
+	compression := NewCompressionHandler(mgr)
+	compression.SetOperationRegistry(operations)
+
+	// A compression that only stops when cancelled
+	body := `{"storage": "mock", "files": ["big.bin"], "base_path": "/", "output_path": "/out.zip", "format": "zip"}`
+	rr := httptest.NewRecorder()
+	compression.Compress(rr, httptest.NewRequest("POST", "/api/fs/compress", strings.NewReader(body)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Failed to start compression: %d %s", rr.Code, rr.Body.String())
+	}
+
+	// An operation that finishes before the deadline
+	quick := operations.Start("transfer", "test", "mock", nil)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		operations.Finish(quick.ID)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	drained, cancelled, err := operations.Drain(ctx, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Drain failed: %v", err)
+	}
+	if drained != 1 || cancelled != 1 {
+		t.Errorf("Expected 1 drained and 1 cancelled, got %d and %d", drained, cancelled)
+	}
+	if len(operations.List()) != 0 {
+		t.Errorf("Expected no operations left, got %v", operations.List())
+	}
+	if _, ok := fs.files["/out.zip"]; ok {
+		t.Error("Expected the partial archive to be removed")
+	}
+
+	// One that ignores cancellation is reported
+	stuck := operations.Start("transfer", "test", "mock", nil)
+	defer operations.Finish(stuck.ID)
+	expired, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, cancelled, err := operations.Drain(expired, 10*time.Millisecond); cancelled != 1 || err == nil {
+		t.Errorf("Expected a stuck operation to be reported, got %d cancelled and %v", cancelled, err)
+	}
+	if stuck.Context().Err() == nil {
+		t.Error("Expected the stuck operation to have been cancelled")
+	}
+}
+
+func TestOperationRegistry_UniqueIDs(t *testing.T) {
+	operations := NewOperationRegistry()
+	var mu sync.Mutex
+	seen := make(map[string]bool)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				op := operations.Start("copy", "test", "mock", nil)
+				mu.Lock()
+				if seen[op.ID] {
+					t.Errorf("Expected a new ID, got %s twice", op.ID)
+				}
+				seen[op.ID] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if got := len(operations.List()); got != 800 {
+		t.Errorf("Expected 800 operations registered, got %d", got)
+	}
+}
@@ -0,0 +1,352 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+// Unix setuid, setgid and sticky bits, which os.FileMode keeps elsewhere
+const (
+	unixSetuid = 04000
+	unixSetgid = 02000
+	unixSticky = 01000
+)
+
+// parseFileMode parses an octal permission string such as "755", "0644"
+// or "1777". The setuid, setgid and sticky bits are accepted and mapped to
+// their os.FileMode flags.
+func parseFileMode(mode string) (os.FileMode, error) {
+	mode = strings.TrimPrefix(strings.TrimSpace(mode), "0o")
+	if mode == "" {
+		return 0, fmt.Errorf("mode is required")
+	}
+
+	value, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || value > 07777 {
+		return 0, fmt.Errorf("invalid mode %q: expected octal permissions between 0000 and 7777", mode)
+	}
+
+	fileMode := os.FileMode(value & 0777)
+	if value&unixSetuid != 0 {
+		fileMode |= os.ModeSetuid
+	}
+	if value&unixSetgid != 0 {
+		fileMode |= os.ModeSetgid
+	}
+	if value&unixSticky != 0 {
+		fileMode |= os.ModeSticky
+	}
+	return fileMode, nil
+}
+
+// formatFileMode is the inverse of parseFileMode
+func formatFileMode(mode os.FileMode) string {
+	value := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		value |= unixSetuid
+	}
+	if mode&os.ModeSetgid != 0 {
+		value |= unixSetgid
+	}
+	if mode&os.ModeSticky != 0 {
+		value |= unixSticky
+	}
+	return fmt.Sprintf("%04o", value)
+}
+
+// ChangeMode changes the permissions of files and directories
+func (h *FileHandlers) ChangeMode(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Storage   string    `json:"storage"`
+		Files     []string  `json:"files"`
+		Path      string    `json:"path"`
+		Mode      string    `json:"mode"`
+		Recursive bool      `json:"recursive"`
+		Exclude   *[]string `json:"exclude"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	mode, err := parseFileMode(req.Mode)
+	if err != nil {
+		errorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	exclude, err := resolveExcludes(req.Exclude)
+	if err != nil {
+		errorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Without files, path itself is changed
+	if len(req.Files) == 0 {
+		if req.Path == "" {
+			errorResponse(w, "No files specified", http.StatusBadRequest)
+			return
+		}
+		req.Files = []string{""}
+	}
+
+	// Get storage backend
+	fs, ok := h.storageManager.Get(req.Storage)
+	if !ok {
+		errorResponse(w, "Storage not found", http.StatusNotFound)
+		return
+	}
+
+	chmoder, ok := storage.As[storage.Chmoder](fs)
+	if !ok {
+		errorResponse(w, "Storage does not support permissions", http.StatusNotImplemented)
+		return
+	}
+
+	var changed int
+	var failures []string
+
+	for _, file := range req.Files {
+		fullPath := filepath.Join(req.Path, file)
+		count, err := applyRecursive(fs, fullPath, req.Recursive, false, exclude, func(path string) error {
+			return chmoder.Chmod(path, mode)
+		})
+		changed += count
+		if errors.Is(err, storage.ErrNotSupported) {
+			errorResponse(w, "Storage does not support permissions", http.StatusNotImplemented)
+			return
+		}
+		if errors.Is(err, storage.ErrSymlink) {
+			errorResponse(w, fmt.Sprintf("Cannot change the mode of symlink %s", file), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", file, err))
+		}
+	}
+
+	if len(failures) > 0 {
+		errorResponse(w, fmt.Sprintf("Some permissions could not be changed: %s", strings.Join(failures, ", ")), http.StatusPartialContent)
+		return
+	}
+
+	successResponse(w, map[string]interface{}{
+		"message": "Permissions changed successfully",
+		"mode":    formatFileMode(mode),
+		"count":   changed,
+	})
+}
+
+// applyRecursive calls apply on fullPath and, when recursive, on everything
+// below it. The tree is walked before anything changes, and children are
+// handled before their directory, so a change removing read or search
+// permission doesn't block the rest. Symlinks and excluded entries inside
+// the tree are skipped; fullPath itself being a symlink is an ErrSymlink
+// unless links is set, for changes that act on the link and not its
+// target. It returns the number of entries changed.
+func applyRecursive(fs storage.FileSystem, fullPath string, recursive, links bool, exclude *storage.ExcludeFilter, apply func(path string) error) (int, error) {
+	paths := []string{fullPath}
+
+	if recursive || !links {
+		info, err := fs.Stat(fullPath)
+		if err != nil {
+			return 0, err
+		}
+		if info.IsLink && !links {
+			return 0, fmt.Errorf("%s: %w", fullPath, storage.ErrSymlink)
+		}
+
+		if recursive && info.IsDir && !info.IsLink {
+			err := storage.Walk(fs, fullPath, func(entry storage.FileInfo) error {
+				if exclude.Excluded(entry.Name) {
+					return storage.SkipDir
+				}
+				if !entry.IsLink {
+					paths = append(paths, entry.Path)
+				}
+				return nil
+			})
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	// Walk reports directories before their contents, so going backwards
+	// reaches every child before its parent
+	changed := 0
+	for i := len(paths) - 1; i >= 0; i-- {
+		if err := apply(paths[i]); err != nil {
+			return changed, err
+		}
+		changed++
+	}
+	return changed, nil
+}
+
+// ownerID is a user or group given either as a numeric ID or a name
+type ownerID string
+
+// UnmarshalJSON accepts both JSON numbers and strings
+func (o *ownerID) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*o = ownerID(name)
+		return nil
+	}
+
+	var id int
+	if err := json.Unmarshal(data, &id); err != nil {
+		return fmt.Errorf("owner must be a name or numeric ID")
+	}
+	*o = ownerID(strconv.Itoa(id))
+	return nil
+}
+
+// resolveOwnerID converts a user or group to a numeric ID. Empty means
+// "leave unchanged" and resolves to -1.
+func resolveOwnerID(value ownerID, lookup func(string) (string, error)) (int, error) {
+	if value == "" {
+		return -1, nil
+	}
+	if id, err := strconv.Atoi(string(value)); err == nil {
+		if id < 0 {
+			return 0, fmt.Errorf("invalid ID %d", id)
+		}
+		return id, nil
+	}
+
+	idStr, err := lookup(string(value))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(idStr)
+}
+
+func lookupUserID(name string) (string, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return "", err
+	}
+	return u.Uid, nil
+}
+
+func lookupGroupID(name string) (string, error) {
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return "", err
+	}
+	return g.Gid, nil
+}
+
+// remoteOwnerName refuses names on storages whose owners live on another
+// machine, where this host's user database doesn't apply
+func remoteOwnerName(name string) (string, error) {
+	return "", errors.New("names can't be resolved on this storage, use a numeric ID")
+}
+
+// ChangeOwner changes the owning user and/or group of files and directories
+func (h *FileHandlers) ChangeOwner(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Storage   string    `json:"storage"`
+		Files     []string  `json:"files"`
+		Path      string    `json:"path"`
+		UID       ownerID   `json:"uid"`
+		GID       ownerID   `json:"gid"`
+		Recursive bool      `json:"recursive"`
+		Exclude   *[]string `json:"exclude"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Files) == 0 {
+		errorResponse(w, "No files specified", http.StatusBadRequest)
+		return
+	}
+	if req.UID == "" && req.GID == "" {
+		errorResponse(w, "uid or gid is required", http.StatusBadRequest)
+		return
+	}
+
+	exclude, err := resolveExcludes(req.Exclude)
+	if err != nil {
+		errorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Get storage backend
+	fs, ok := h.storageManager.Get(req.Storage)
+	if !ok {
+		errorResponse(w, "Storage not found", http.StatusNotFound)
+		return
+	}
+
+	chowner, ok := storage.As[storage.Chowner](fs)
+	if !ok {
+		errorResponse(w, "Storage does not support file ownership", http.StatusNotImplemented)
+		return
+	}
+
+	// Names are only meaningful where the owners are this host's users;
+	// elsewhere the same name may stand for a different ID
+	lookupUser, lookupGroup := lookupUserID, lookupGroupID
+	if local, ok := storage.As[storage.LocalOwner](fs); !ok || !local.LocalOwners() {
+		lookupUser, lookupGroup = remoteOwnerName, remoteOwnerName
+	}
+	uid, err := resolveOwnerID(req.UID, lookupUser)
+	if err != nil {
+		errorResponse(w, fmt.Sprintf("Unknown user %q: %v", req.UID, err), http.StatusBadRequest)
+		return
+	}
+	gid, err := resolveOwnerID(req.GID, lookupGroup)
+	if err != nil {
+		errorResponse(w, fmt.Sprintf("Unknown group %q: %v", req.GID, err), http.StatusBadRequest)
+		return
+	}
+
+	var changed int
+	var failures []string
+
+	for _, file := range req.Files {
+		fullPath := filepath.Join(req.Path, file)
+		count, err := applyRecursive(fs, fullPath, req.Recursive, true, exclude, func(path string) error {
+			return chowner.Chown(path, uid, gid)
+		})
+		changed += count
+
+		switch {
+		case errors.Is(err, storage.ErrNotSupported):
+			errorResponse(w, "Storage does not support file ownership", http.StatusNotImplemented)
+			return
+		case errors.Is(err, os.ErrPermission):
+			errorResponse(w, "The server is not permitted to change ownership (it usually needs to run as root)", http.StatusForbidden)
+			return
+		case err != nil:
+			failures = append(failures, fmt.Sprintf("%s: %v", file, err))
+		}
+	}
+
+	if len(failures) > 0 {
+		errorResponse(w, fmt.Sprintf("Some owners could not be changed: %s", strings.Join(failures, ", ")), http.StatusPartialContent)
+		return
+	}
+
+	successResponse(w, map[string]interface{}{
+		"message": "Ownership changed successfully",
+		"uid":     uid,
+		"gid":     gid,
+		"count":   changed,
+	})
+}
@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+func TestFileHandlers_ChangeMode(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "tree", "sub"), 0755); err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+	for _, name := range []string{"single.txt", "tree/a.txt", "tree/sub/b.txt"} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte("x"), 0644); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+	}
+
+	mgr := storage.NewManager()
+	mgr.Register("local", storage.NewLocalStorage(root))
+	mgr.Register("mock", newMockFileSystem())
+	handler := NewFileHandlers(mgr)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/api/fs/chmod", handler.ChangeMode).Methods("POST")
+
+	chmod := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/fs/chmod", strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	modeOf := func(name string) os.FileMode {
+		info, err := os.Stat(filepath.Join(root, name))
+		if err != nil {
+			t.Fatalf("Failed to stat %s: %v", name, err)
+		}
+		return info.Mode().Perm()
+	}
+
+	t.Run("Single file", func(t *testing.T) {
+		rr := chmod(`{"storage": "local", "files": ["/single.txt"], "mode": "600"}`)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if got := modeOf("single.txt"); got != 0600 {
+			t.Errorf("Expected mode 0600, got %o", got)
+		}
+	})
+
+	t.Run("Recursive tree", func(t *testing.T) {
+		rr := chmod(`{"storage": "local", "files": ["tree"], "path": "/", "mode": "0750", "recursive": true}`)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		for _, name := range []string{"tree", "tree/sub", "tree/a.txt", "tree/sub/b.txt"} {
+			if got := modeOf(name); got != 0750 {
+				t.Errorf("Expected %s mode 0750, got %o", name, got)
+			}
+		}
+	})
+
+	t.Run("Sticky bit on path", func(t *testing.T) {
+		rr := chmod(`{"storage": "local", "path": "/tree", "mode": "1777"}`)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		info, err := os.Stat(filepath.Join(root, "tree"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0777 || info.Mode()&os.ModeSticky == 0 {
+			t.Errorf("Expected mode 1777, got %v", info.Mode())
+		}
+		var resp struct {
+			Data struct {
+				Mode string `json:"mode"`
+			} `json:"data"`
+		}
+		_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+		if resp.Data.Mode != "1777" {
+			t.Errorf("Expected the mode reported as 1777, got %q", resp.Data.Mode)
+		}
+	})
+
+	t.Run("Symlink out of the root", func(t *testing.T) {
+		outside := filepath.Join(t.TempDir(), "secret")
+		if err := os.WriteFile(outside, []byte("x"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(filepath.Join(root, "escape"))
+
+		for _, body := range []string{
+			`{"storage": "local", "files": ["/escape"], "mode": "777"}`,
+			`{"storage": "local", "path": "/escape", "mode": "777", "recursive": true}`,
+		} {
+			if rr := chmod(body); rr.Code != http.StatusBadRequest {
+				t.Errorf("Expected 400 for %s, got %d: %s", body, rr.Code, rr.Body.String())
+			}
+		}
+		if err := storage.NewLocalStorage(root).Chmod("/escape", 0777); !errors.Is(err, storage.ErrSymlink) {
+			t.Errorf("Expected the storage to refuse the symlink, got %v", err)
+		}
+		info, err := os.Stat(outside)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0600 {
+			t.Errorf("Expected the target to keep mode 0600, got %o", info.Mode().Perm())
+		}
+	})
+
+	t.Run("Invalid mode", func(t *testing.T) {
+		for _, mode := range []string{"", "999", "rwx", "17777"} {
+			rr := chmod(`{"storage": "local", "files": ["/single.txt"], "mode": "` + mode + `"}`)
+			if rr.Code != http.StatusBadRequest {
+				t.Errorf("Expected 400 for mode %q, got %d", mode, rr.Code)
+			}
+		}
+	})
+
+	t.Run("Unsupported storage", func(t *testing.T) {
+		rr := chmod(`{"storage": "mock", "files": ["/x"], "mode": "644"}`)
+		if rr.Code != http.StatusNotImplemented {
+			t.Errorf("Expected 501, got %d", rr.Code)
+		}
+	})
+}
+
+// remoteChownFS records ownership changes like an SFTP server would apply
+// them, with IDs that mean nothing on this host
+type remoteChownFS struct {
+	*mockFileSystem
+	owners map[string][2]int
+}
+
+func (r *remoteChownFS) Chown(path string, uid, gid int) error {
+	r.owners[path] = [2]int{uid, gid}
+	return nil
+}
+
+func TestFileHandlers_ChangeOwner(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "tree", "sub"), 0755); err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+	for _, name := range []string{"single.txt", "tree/a.txt", "tree/sub/b.txt"} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte("x"), 0644); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+	}
+
+	mgr := storage.NewManager()
+	mgr.Register("local", storage.NewLocalStorage(root))
+	mgr.Register("mock", newMockFileSystem())
+	remote := &remoteChownFS{mockFileSystem: newMockFileSystem(), owners: map[string][2]int{}}
+	mgr.Register("sftp", remote)
+	handler := NewFileHandlers(mgr)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/api/fs/chown", handler.ChangeOwner).Methods("POST")
+
+	chown := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/fs/chown", strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	ownerOf := func(name string) (uint32, uint32) {
+		info, err := os.Stat(filepath.Join(root, name))
+		if err != nil {
+			t.Fatalf("Failed to stat %s: %v", name, err)
+		}
+		stat := info.Sys().(*syscall.Stat_t)
+		return stat.Uid, stat.Gid
+	}
+
+	t.Run("Unsupported storage", func(t *testing.T) {
+		rr := chown(`{"storage": "mock", "files": ["/a.txt"], "uid": 1}`)
+		if rr.Code != http.StatusNotImplemented {
+			t.Errorf("Expected 501, got %d", rr.Code)
+		}
+	})
+
+	t.Run("Unknown user", func(t *testing.T) {
+		rr := chown(`{"storage": "local", "files": ["/single.txt"], "uid": "no-such-user-jacommander"}`)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400, got %d", rr.Code)
+		}
+	})
+
+	t.Run("Remote storage", func(t *testing.T) {
+		rr := chown(`{"storage": "sftp", "files": ["/a.txt"], "uid": "root"}`)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected a name to be refused with 400, got %d", rr.Code)
+		}
+		rr = chown(`{"storage": "sftp", "files": ["/a.txt"], "uid": 1001, "gid": "1002"}`)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if got := remote.owners["/a.txt"]; got != [2]int{1001, 1002} {
+			t.Errorf("Expected the IDs to be passed through, got %v", got)
+		}
+	})
+
+	t.Run("Missing owner", func(t *testing.T) {
+		rr := chown(`{"storage": "local", "files": ["/single.txt"]}`)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400, got %d", rr.Code)
+		}
+	})
+
+	if os.Geteuid() != 0 {
+		t.Run("Not permitted", func(t *testing.T) {
+			rr := chown(`{"storage": "local", "files": ["/single.txt"], "uid": 0}`)
+			if rr.Code != http.StatusForbidden {
+				t.Errorf("Expected 403, got %d: %s", rr.Code, rr.Body.String())
+			}
+		})
+		t.Skip("Changing ownership requires root")
+	}
+
+	t.Run("Single file", func(t *testing.T) {
+		rr := chown(`{"storage": "local", "files": ["/single.txt"], "uid": 1, "gid": "2"}`)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if uid, gid := ownerOf("single.txt"); uid != 1 || gid != 2 {
+			t.Errorf("Expected 1:2, got %d:%d", uid, gid)
+		}
+	})
+
+	t.Run("Group unchanged", func(t *testing.T) {
+		rr := chown(`{"storage": "local", "files": ["/single.txt"], "uid": 3}`)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if uid, gid := ownerOf("single.txt"); uid != 3 || gid != 2 {
+			t.Errorf("Expected 3:2, got %d:%d", uid, gid)
+		}
+	})
+
+	t.Run("Recursive by name", func(t *testing.T) {
+		rr := chown(`{"storage": "local", "files": ["tree"], "path": "/", "uid": "root", "gid": 5, "recursive": true}`)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		for _, name := range []string{"tree", "tree/sub", "tree/a.txt", "tree/sub/b.txt"} {
+			if uid, gid := ownerOf(name); uid != 0 || gid != 5 {
+				t.Errorf("Expected %s to be 0:5, got %d:%d", name, uid, gid)
+			}
+		}
+	})
+}
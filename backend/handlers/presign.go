@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+// Defaults for presigned uploads. S3 rejects POST uploads over 5GB anyway.
+const (
+	DefaultPresignExpiry    = 15 * time.Minute
+	DefaultPresignMaxExpiry = time.Hour
+	DefaultPresignMaxSize   = 5 << 30
+)
+
+// PresignUploadRequest asks for a direct upload of one file
+type PresignUploadRequest struct {
+	Storage     string `json:"storage"`
+	Path        string `json:"path"`
+	ExpiresIn   int64  `json:"expires_in"`   // seconds; defaults to 15 minutes
+	MaxSize     int64  `json:"max_size"`     // bytes; defaults to the server maximum
+	ContentType string `json:"content_type"` // exact type, or a prefix such as "image/*"
+}
+
+// SetPresignLimits bounds the expiry and size clients may ask for in
+// presigned uploads
+func (h *FileHandlers) SetPresignLimits(maxExpiry time.Duration, maxSize int64) {
+	h.presignMaxExpiry = maxExpiry
+	h.presignMaxSize = maxSize
+}
+
+// validatePresignContentType accepts a MIME type or a "type/*" wildcard
+func validatePresignContentType(contentType string) error {
+	if contentType == "" {
+		return nil
+	}
+	if prefix, ok := strings.CutSuffix(contentType, "/*"); ok {
+		if prefix == "" || strings.ContainsAny(prefix, "/*; ") {
+			return fmt.Errorf("Invalid content_type: %s", contentType)
+		}
+		return nil
+	}
+	if _, _, err := mime.ParseMediaType(contentType); err != nil {
+		return fmt.Errorf("Invalid content_type: %s", contentType)
+	}
+	return nil
+}
+
+// PresignUpload returns the URL and form fields for uploading one file
+// straight to the backend. The signed policy limits the upload to the
+// requested path, size and content type until it expires.
+func (h *FileHandlers) PresignUpload(w http.ResponseWriter, r *http.Request) {
+	var req PresignUploadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Path == "" {
+		errorResponse(w, "path is required", http.StatusBadRequest)
+		return
+	}
+
+	expires := DefaultPresignExpiry
+	if req.ExpiresIn != 0 {
+		expires = time.Duration(req.ExpiresIn) * time.Second
+	}
+	if expires <= 0 || expires > h.presignMaxExpiry {
+		errorResponse(w, fmt.Sprintf("expires_in must be between 1 and %d seconds", int64(h.presignMaxExpiry/time.Second)), http.StatusBadRequest)
+		return
+	}
+	maxSize := req.MaxSize
+	if maxSize == 0 {
+		maxSize = h.presignMaxSize
+	}
+	if maxSize < 0 || maxSize > h.presignMaxSize {
+		errorResponse(w, fmt.Sprintf("max_size must be between 1 and %d bytes", h.presignMaxSize), http.StatusBadRequest)
+		return
+	}
+	if err := validatePresignContentType(req.ContentType); err != nil {
+		errorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	fs, ok := h.storageManager.Get(req.Storage)
+	if !ok {
+		errorResponse(w, "Storage not found", http.StatusNotFound)
+		return
+	}
+	presigner, ok := storage.As[storage.UploadPresigner](fs)
+	if !ok {
+		errorResponse(w, "Storage does not support presigned uploads", http.StatusNotImplemented)
+		return
+	}
+	if isReadOnly(fs) {
+		codedErrorResponse(w, "Storage is configured read-only", CodeReadOnly, http.StatusForbidden)
+		return
+	}
+
+	upload, err := presigner.PresignUpload(req.Path, storage.PresignOptions{
+		Expires:     expires,
+		MaxSize:     maxSize,
+		ContentType: req.ContentType,
+	})
+	if err != nil {
+		log.Printf("Error presigning upload of %s: %v", req.Path, err)
+		errorResponse(w, fmt.Sprintf("Failed to presign upload: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	successResponse(w, upload)
+}
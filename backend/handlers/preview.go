@@ -0,0 +1,367 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif" // registers the GIF decoder
+	"image/jpeg"
+	_ "image/png" // registers the PNG decoder
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+// previewSize is the longest side of a generated preview, in pixels
+const previewSize = 512
+
+// maxPreviewSourceSize is the largest file handed to a preview generator
+const maxPreviewSourceSize = 512 << 20
+
+// maxPreviewPixels bounds the images decoded for a preview, so a small
+// file declaring huge dimensions can't exhaust memory
+const maxPreviewPixels = 50_000_000
+
+// previewCacheEntries is how many previews are kept in memory
+const previewCacheEntries = 256
+
+// previewTimeout bounds how long an external tool may run
+const previewTimeout = 30 * time.Second
+
+// Preview kinds, each served by one generator
+const (
+	previewImage = "image"
+	previewPDF   = "pdf"
+	previewVideo = "video"
+)
+
+// PreviewGenerator renders a JPEG preview of a file
+type PreviewGenerator interface {
+	Generate(ctx context.Context, src io.Reader, dst io.Writer) error
+}
+
+// PreviewGeneratorFunc adapts a function to PreviewGenerator
+type PreviewGeneratorFunc func(ctx context.Context, src io.Reader, dst io.Writer) error
+
+// Generate calls f
+func (f PreviewGeneratorFunc) Generate(ctx context.Context, src io.Reader, dst io.Writer) error {
+	return f(ctx, src, dst)
+}
+
+// previewKind maps a MIME type to the generator that handles it, or ""
+// when previews aren't supported for it
+func previewKind(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	switch {
+	case mediaType == "image/jpeg", mediaType == "image/png", mediaType == "image/gif":
+		return previewImage
+	case mediaType == "application/pdf":
+		return previewPDF
+	case strings.HasPrefix(mediaType, "video/"):
+		return previewVideo
+	}
+	return ""
+}
+
+// PreviewHandler serves JPEG previews of images, PDFs and videos
+type PreviewHandler struct {
+	storageManager *storage.Manager
+	generators     map[string]PreviewGenerator
+	videoTool      string // ffmpeg, once SetVideoTool found it
+	thumbnailDir   string // where thumbnails are cached; empty keeps none
+
+	mu    sync.Mutex
+	cache map[string][]byte
+	order []string // cache keys, oldest first
+}
+
+// NewPreviewHandler creates a preview handler. Images are previewed out of
+// the box; PDFs and videos need SetPDFTool and SetVideoTool.
+func NewPreviewHandler(manager *storage.Manager) *PreviewHandler {
+	return &PreviewHandler{
+		storageManager: manager,
+		generators: map[string]PreviewGenerator{
+			previewImage: PreviewGeneratorFunc(imagePreview),
+		},
+		cache: make(map[string][]byte),
+	}
+}
+
+// SetGenerator sets the generator for a preview kind ("image", "pdf" or
+// "video"). A nil generator disables that kind.
+func (ph *PreviewHandler) SetGenerator(kind string, gen PreviewGenerator) {
+	if gen == nil {
+		delete(ph.generators, kind)
+		return
+	}
+	ph.generators[kind] = gen
+}
+
+// SetPDFTool enables PDF previews rendered by pdftoppm at the given path
+// or command name
+func (ph *PreviewHandler) SetPDFTool(tool string) error {
+	path, err := exec.LookPath(tool)
+	if err != nil {
+		return err
+	}
+	ph.SetGenerator(previewPDF, &commandPreview{
+		args: func(in, out string) []string {
+			// pdftoppm appends .jpg to the output prefix
+			return []string{path, "-jpeg", "-f", "1", "-l", "1", "-singlefile",
+				"-scale-to", strconv.Itoa(previewSize), in, strings.TrimSuffix(out, ".jpg")}
+		},
+	})
+	return nil
+}
+
+// SetVideoTool enables video previews grabbed by ffmpeg at the given path
+// or command name
+func (ph *PreviewHandler) SetVideoTool(tool string) error {
+	path, err := exec.LookPath(tool)
+	if err != nil {
+		return err
+	}
+	ph.videoTool = path
+	ph.SetGenerator(previewVideo, &commandPreview{
+		args: func(in, out string) []string {
+			// The thumbnail filter picks a representative frame from the
+			// start rather than a black first frame
+			scale := fmt.Sprintf("thumbnail,scale=%d:%d:force_original_aspect_ratio=decrease", previewSize, previewSize)
+			return []string{path, "-y", "-loglevel", "error", "-i", in,
+				"-vf", scale, "-frames:v", "1", out}
+		},
+	})
+	return nil
+}
+
+// Preview returns a JPEG preview of a file, generated by the generator for
+// its MIME type and cached until the file changes
+func (ph *PreviewHandler) Preview(w http.ResponseWriter, r *http.Request) {
+	storageID := r.URL.Query().Get("storage")
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		errorResponse(w, "path is required", http.StatusBadRequest)
+		return
+	}
+
+	fs, ok := ph.storageManager.Get(storageID)
+	if !ok {
+		errorResponse(w, "Storage not found", http.StatusNotFound)
+		return
+	}
+
+	info, err := fs.Stat(path)
+	if err != nil {
+		errorResponse(w, fmt.Sprintf("File not found: %s", path), http.StatusNotFound)
+		return
+	}
+	if info.IsDir {
+		errorResponse(w, "Cannot preview a directory", http.StatusBadRequest)
+		return
+	}
+
+	contentType := info.MimeType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(info.Name))
+	}
+	kind := previewKind(contentType)
+	if kind == "" {
+		errorResponse(w, fmt.Sprintf("No preview available for %s", info.Name), http.StatusUnsupportedMediaType)
+		return
+	}
+	gen, ok := ph.generators[kind]
+	if !ok {
+		errorResponse(w, fmt.Sprintf("%s previews are not enabled", kind), http.StatusNotImplemented)
+		return
+	}
+	if info.Size > maxPreviewSourceSize {
+		errorResponse(w, "File is too large to preview", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	key := fmt.Sprintf("%s:%s:%d:%d", storageID, path, info.ModTime.UnixNano(), info.Size)
+	data, ok := ph.cached(key)
+	if !ok {
+		data, err = ph.generate(r.Context(), fs, path, gen)
+		if err != nil {
+			log.Printf("Error generating preview of %s: %v", path, err)
+			storageErrorResponse(w, fmt.Sprintf("Failed to generate preview: %v", err), err)
+			return
+		}
+		ph.store(key, data)
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("Cache-Control", "private, max-age=300")
+	if _, err := w.Write(data); err != nil {
+		log.Printf("Error writing preview of %s: %v", path, err)
+	}
+}
+
+// generate reads path and runs it through gen
+func (ph *PreviewHandler) generate(ctx context.Context, fs storage.FileSystem, path string, gen PreviewGenerator) ([]byte, error) {
+	reader, err := fs.Read(path)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	var buf bytes.Buffer
+	if err := gen.Generate(ctx, reader, &buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// cached returns the preview stored under key
+func (ph *PreviewHandler) cached(key string) ([]byte, bool) {
+	ph.mu.Lock()
+	defer ph.mu.Unlock()
+	data, ok := ph.cache[key]
+	return data, ok
+}
+
+// store caches a preview, dropping the oldest once the cache is full
+func (ph *PreviewHandler) store(key string, data []byte) {
+	ph.mu.Lock()
+	defer ph.mu.Unlock()
+	if _, ok := ph.cache[key]; ok {
+		return
+	}
+	for len(ph.order) >= previewCacheEntries {
+		delete(ph.cache, ph.order[0])
+		ph.order = ph.order[1:]
+	}
+	ph.cache[key] = data
+	ph.order = append(ph.order, key)
+}
+
+// errTooManyPixels is returned for images over maxPreviewPixels
+var errTooManyPixels = errors.New("image is too large to preview")
+
+// imagePreview decodes a JPEG, PNG or GIF and re-encodes it scaled down to
+// fit previewSize
+func imagePreview(ctx context.Context, src io.Reader, dst io.Writer) error {
+	img, err := decodeBounded(src)
+	if err != nil {
+		return err
+	}
+	return jpeg.Encode(dst, scaleToFit(img, previewSize, previewSize), &jpeg.Options{Quality: 80})
+}
+
+// decodeBounded decodes an image, reading its dimensions first so that one
+// over maxPreviewPixels is refused before its pixels are allocated
+func decodeBounded(src io.Reader) (image.Image, error) {
+	var header bytes.Buffer
+	cfg, _, err := image.DecodeConfig(io.TeeReader(src, &header))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width*cfg.Height > maxPreviewPixels {
+		return nil, fmt.Errorf("%w: %dx%d", errTooManyPixels, cfg.Width, cfg.Height)
+	}
+
+	img, _, err := image.Decode(io.MultiReader(&header, src))
+	return img, err
+}
+
+// scaleToFit shrinks img to fit width×height, keeping its aspect ratio and
+// averaging the source pixels that fall into each destination pixel
+func scaleToFit(img image.Image, width, height int) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w <= width && h <= height {
+		return img
+	}
+
+	dw, dh := width, h*width/w
+	if dh > height {
+		dw, dh = w*height/h, height
+	}
+	dw, dh = max(dw, 1), max(dh, 1)
+
+	src := image.NewRGBA(bounds)
+	draw.Draw(src, bounds, img, bounds.Min, draw.Src)
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := y*h/dh, max((y+1)*h/dh, y*h/dh+1)
+		for x := 0; x < dw; x++ {
+			x0, x1 := x*w/dw, max((x+1)*w/dw, x*w/dw+1)
+			var r, g, b, a, n int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					r, g, b, a = r+int(p[0]), g+int(p[1]), b+int(p[2]), a+int(p[3])
+					n++
+				}
+			}
+			i := dst.PixOffset(x, y)
+			dst.Pix[i], dst.Pix[i+1], dst.Pix[i+2], dst.Pix[i+3] = uint8(r/n), uint8(g/n), uint8(b/n), uint8(a/n)
+		}
+	}
+	return dst
+}
+
+// commandPreview runs an external tool on a temporary copy of the file and
+// returns the JPEG it writes
+type commandPreview struct {
+	// args returns the command line reading in and writing out
+	args func(in, out string) []string
+}
+
+// Generate implements PreviewGenerator
+func (c *commandPreview) Generate(ctx context.Context, src io.Reader, dst io.Writer) error {
+	dir, err := os.MkdirTemp("", "jacommander-preview-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	in := filepath.Join(dir, "source")
+	out := filepath.Join(dir, "preview.jpg")
+	file, err := os.Create(in)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, src)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, previewTimeout)
+	defer cancel()
+	args := c.args(in, out)
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s failed: %v: %s", filepath.Base(args[0]), err, bytes.TrimSpace(output))
+	}
+
+	preview, err := os.Open(out)
+	if err != nil {
+		return fmt.Errorf("%s wrote no preview", filepath.Base(args[0]))
+	}
+	defer preview.Close()
+	_, err = io.Copy(dst, preview)
+	return err
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+func TestPreviewHandler_Preview(t *testing.T) {
+	root := t.TempDir()
+	img := image.NewRGBA(image.Rect(0, 0, 1024, 256))
+	for x := 0; x < 1024; x++ {
+		for y := 0; y < 256; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(x / 4), A: 255})
+		}
+	}
+	var pngData bytes.Buffer
+	if err := png.Encode(&pngData, img); err != nil {
+		t.Fatalf("Failed to encode PNG: %v", err)
+	}
+	for name, content := range map[string][]byte{
+		"photo.png":  pngData.Bytes(),
+		"manual.pdf": []byte("%PDF-1.4"),
+		"clip.mp4":   []byte("video"),
+		"notes.txt":  []byte("notes"),
+	} {
+		if err := os.WriteFile(filepath.Join(root, name), content, 0644); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+	}
+
+	mgr := storage.NewManager()
+	mgr.Register("local", storage.NewLocalStorage(root))
+	handler := NewPreviewHandler(mgr)
+
+	// The PDF tool is stubbed; video previews stay unconfigured
+	var pdfCalls int
+	handler.SetGenerator(previewPDF, PreviewGeneratorFunc(func(ctx context.Context, src io.Reader, dst io.Writer) error {
+		pdfCalls++
+		data, _ := io.ReadAll(src)
+		if string(data) != "%PDF-1.4" {
+			t.Errorf("Expected the PDF content, got %q", data)
+		}
+		_, err := dst.Write([]byte("jpeg"))
+		return err
+	}))
+
+	preview := func(path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler.Preview(rr, httptest.NewRequest("GET", "/api/fs/preview?storage=local&path="+path, nil))
+		return rr
+	}
+
+	t.Run("Image", func(t *testing.T) {
+		rr := preview("/photo.png")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if ct := rr.Header().Get("Content-Type"); ct != "image/jpeg" {
+			t.Errorf("Expected image/jpeg, got %s", ct)
+		}
+		decoded, err := jpeg.Decode(rr.Body)
+		if err != nil {
+			t.Fatalf("Failed to decode preview: %v", err)
+		}
+		if b := decoded.Bounds(); b.Dx() != 512 || b.Dy() != 128 {
+			t.Errorf("Expected a 512x128 preview, got %dx%d", b.Dx(), b.Dy())
+		}
+	})
+
+	t.Run("PDF is cached until modified", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			if rr := preview("/manual.pdf"); rr.Code != http.StatusOK || rr.Body.String() != "jpeg" {
+				t.Fatalf("Expected the stub preview, got %d: %s", rr.Code, rr.Body.String())
+			}
+		}
+		if pdfCalls != 1 {
+			t.Errorf("Expected one generator call, got %d", pdfCalls)
+		}
+
+		later := time.Now().Add(time.Minute)
+		if err := os.Chtimes(filepath.Join(root, "manual.pdf"), later, later); err != nil {
+			t.Fatalf("Failed to touch file: %v", err)
+		}
+		preview("/manual.pdf")
+		if pdfCalls != 2 {
+			t.Errorf("Expected a modified file to be previewed again, got %d calls", pdfCalls)
+		}
+	})
+
+	t.Run("Tool not configured", func(t *testing.T) {
+		if rr := preview("/clip.mp4"); rr.Code != http.StatusNotImplemented {
+			t.Errorf("Expected 501, got %d", rr.Code)
+		}
+	})
+
+	t.Run("Unsupported type", func(t *testing.T) {
+		if rr := preview("/notes.txt"); rr.Code != http.StatusUnsupportedMediaType {
+			t.Errorf("Expected 415, got %d", rr.Code)
+		}
+	})
+
+	t.Run("Missing file", func(t *testing.T) {
+		if rr := preview("/gone.png"); rr.Code != http.StatusNotFound {
+			t.Errorf("Expected 404, got %d", rr.Code)
+		}
+	})
+}
+
+func TestCommandPreview(t *testing.T) {
+	// A stand-in tool that copies its input to the output path
+	gen := &commandPreview{args: func(in, out string) []string {
+		return []string{"/bin/sh", "-c", `cp "$0" "$1"`, in, out}
+	}}
+	var out bytes.Buffer
+	if err := gen.Generate(context.Background(), bytes.NewReader([]byte("frame")), &out); err != nil {
+		t.Fatalf("Failed to generate: %v", err)
+	}
+	if out.String() != "frame" {
+		t.Errorf("Expected the tool's output, got %q", out.String())
+	}
+
+	failing := &commandPreview{args: func(in, out string) []string {
+		return []string{"/bin/sh", "-c", "echo broken >&2; exit 1"}
+	}}
+	if err := failing.Generate(context.Background(), bytes.NewReader(nil), io.Discard); err == nil {
+		t.Error("Expected an error when the tool fails")
+	}
+}
@@ -0,0 +1,654 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+// completedUploadTTL is how long a finished upload is remembered so that
+// retransmitted ranges still get a success response
+const completedUploadTTL = 10 * time.Minute
+
+// staleUploadTTL is how long an unfinished upload may sit idle before its
+// temp file is discarded
+const staleUploadTTL = 24 * time.Hour
+
+var contentRangePattern = regexp.MustCompile(`^bytes (\d+)-(\d+)/(\d+)$`)
+
+// byteRange is an inclusive range of byte offsets
+type byteRange struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+// rangeUpload is an upload assembled from Content-Range requests
+type rangeUpload struct {
+	mu         sync.Mutex
+	storageID  string
+	path       string
+	size       int64
+	file       *os.File
+	received   []byteRange // sorted and merged
+	completed  bool
+	started    time.Time
+	lastActive time.Time
+
+	// chunkSize and contentType are set for uploads started with
+	// /fs/upload/init, which send numbered chunks and complete explicitly
+	chunkSize   int64
+	contentType string
+
+	// multipart is set when the storage takes the file in parts, which
+	// are then sent as soon as the start of the file has arrived
+	multipart storage.MultipartWriter
+	uploadID  string
+	parts     []string // tokens of the parts sent so far
+}
+
+// UploadHandler handles uploads that arrive in several requests
+type UploadHandler struct {
+	storageManager *storage.Manager
+
+	mu      sync.Mutex
+	uploads map[string]*rangeUpload
+}
+
+// NewUploadHandler creates a new upload handler
+func NewUploadHandler(manager *storage.Manager) *UploadHandler {
+	return &UploadHandler{
+		storageManager: manager,
+		uploads:        make(map[string]*rangeUpload),
+	}
+}
+
+// parseContentRange parses a "bytes start-end/total" header
+func parseContentRange(header string) (byteRange, int64, error) {
+	m := contentRangePattern.FindStringSubmatch(header)
+	if m == nil {
+		return byteRange{}, 0, fmt.Errorf("invalid Content-Range %q: expected \"bytes start-end/total\"", header)
+	}
+
+	start, _ := strconv.ParseInt(m[1], 10, 64)
+	end, _ := strconv.ParseInt(m[2], 10, 64)
+	total, _ := strconv.ParseInt(m[3], 10, 64)
+	if start > end || end >= total {
+		return byteRange{}, 0, fmt.Errorf("invalid Content-Range %q: range outside of total size", header)
+	}
+
+	return byteRange{Start: start, End: end}, total, nil
+}
+
+// addRange records a received range, merging it with adjacent and
+// overlapping ones
+func (u *rangeUpload) addRange(r byteRange) {
+	ranges := append(u.received, r)
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].Start < ranges[j].Start })
+
+	merged := ranges[:1]
+	for _, next := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if next.Start <= last.End+1 {
+			if next.End > last.End {
+				last.End = next.End
+			}
+			continue
+		}
+		merged = append(merged, next)
+	}
+	u.received = merged
+}
+
+// receivedBytes returns the number of distinct bytes received so far
+func (u *rangeUpload) receivedBytes() int64 {
+	var n int64
+	for _, r := range u.received {
+		n += r.End - r.Start + 1
+	}
+	return n
+}
+
+// isComplete reports whether every byte of the file has arrived
+func (u *rangeUpload) isComplete() bool {
+	return len(u.received) == 1 && u.received[0].Start == 0 && u.received[0].End == u.size-1
+}
+
+// writeParts sends the received start of the file to the backend in whole
+// parts. Once final is set the remainder goes too, as the last part.
+func (u *rangeUpload) writeParts(contentType string, final bool) error {
+	partSize := u.multipart.MultipartPartSize()
+	var contiguous int64
+	if len(u.received) > 0 && u.received[0].Start == 0 {
+		contiguous = u.received[0].End + 1
+	}
+
+	for {
+		offset := int64(len(u.parts)) * partSize
+		n := min(partSize, contiguous-offset)
+		if n <= 0 || (n < partSize && !final) {
+			return nil
+		}
+		if u.uploadID == "" {
+			id, err := u.multipart.StartMultipart(u.path, contentType)
+			if err != nil {
+				return err
+			}
+			u.uploadID = id
+		}
+		token, err := u.multipart.WritePart(u.path, u.uploadID, len(u.parts)+1, io.NewSectionReader(u.file, offset, n))
+		if err != nil {
+			return err
+		}
+		u.parts = append(u.parts, token)
+	}
+}
+
+// abort discards the temp file and any parts already sent to the backend
+func (u *rangeUpload) abort() error {
+	u.discard()
+	if u.uploadID == "" {
+		return nil
+	}
+	if err := u.multipart.AbortMultipart(u.path, u.uploadID); err != nil {
+		return err
+	}
+	u.uploadID = ""
+	u.parts = nil
+	return nil
+}
+
+// discard closes and removes the temp file
+func (u *rangeUpload) discard() {
+	if u.file == nil {
+		return
+	}
+	if err := u.file.Close(); err != nil {
+		log.Printf("Error closing upload temp file: %v", err)
+	}
+	if err := os.Remove(u.file.Name()); err != nil {
+		log.Printf("Error removing upload temp file: %v", err)
+	}
+	u.file = nil
+}
+
+func (u *rangeUpload) status(id string) map[string]interface{} {
+	status := map[string]interface{}{
+		"id":       id,
+		"path":     u.path,
+		"size":     u.size,
+		"received": u.receivedBytes(),
+		"ranges":   u.received,
+		"complete": u.completed,
+	}
+	if u.chunkSize > 0 {
+		status["chunk_size"] = u.chunkSize
+		status["chunks"] = u.chunkCount()
+		status["missing_chunks"] = u.missingChunks()
+	}
+	return status
+}
+
+// finish stores the assembled file: the remaining parts and completion of
+// a multipart upload, or the temp file in a single write
+func (u *rangeUpload) finish(fs storage.FileSystem, contentType string) error {
+	if u.multipart != nil {
+		if err := u.writeParts(contentType, true); err != nil {
+			return err
+		}
+		if err := u.multipart.CompleteMultipart(u.path, u.uploadID, u.parts); err != nil {
+			return err
+		}
+		u.uploadID = ""
+	} else {
+		if _, err := u.file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if err := writeWithContentType(fs, u.path, u.file, contentType); err != nil {
+			return err
+		}
+	}
+
+	u.completed = true
+	u.discard()
+	return nil
+}
+
+// expire drops uploads idle for longer than their TTL, aborting the
+// unfinished ones
+func (uh *UploadHandler) expire(now time.Time) {
+	expired := uh.evict(func(u *rangeUpload) bool {
+		ttl := staleUploadTTL
+		if u.completed {
+			ttl = completedUploadTTL
+		}
+		return now.Sub(u.lastActive) > ttl
+	})
+
+	// Aborting may call the backend, so it happens outside uh.mu
+	for _, u := range expired {
+		if err := u.abort(); err != nil {
+			log.Printf("Error aborting expired upload of %s: %v", u.path, err)
+		}
+		u.mu.Unlock()
+	}
+}
+
+// evict removes the uploads match selects and returns them still locked,
+// keyed by ID. Uploads busy with a request are left alone.
+func (uh *UploadHandler) evict(match func(u *rangeUpload) bool) map[string]*rangeUpload {
+	evicted := make(map[string]*rangeUpload)
+	uh.mu.Lock()
+	defer uh.mu.Unlock()
+	for id, u := range uh.uploads {
+		if !u.mu.TryLock() {
+			continue
+		}
+		if match(u) {
+			delete(uh.uploads, id)
+			evicted[id] = u
+			continue
+		}
+		u.mu.Unlock()
+	}
+	return evicted
+}
+
+// StartCleanup expires idle uploads every interval, so abandoned temp
+// files and multipart parts don't wait for the next upload to be removed.
+// It returns a function that stops the cleanup.
+func (uh *UploadHandler) StartCleanup(interval time.Duration) func() {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				uh.expire(now)
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// session returns the upload for id, creating it on first use. Expired
+// uploads are dropped on the way.
+func (uh *UploadHandler) session(id string, fs storage.FileSystem, storageID, path string, size int64) (*rangeUpload, error) {
+	now := time.Now()
+	uh.expire(now)
+
+	uh.mu.Lock()
+	defer uh.mu.Unlock()
+
+	if u, ok := uh.uploads[id]; ok {
+		if u.storageID != storageID || u.path != path || u.size != size {
+			return nil, fmt.Errorf("upload %s was started for a different file", id)
+		}
+		return u, nil
+	}
+
+	file, err := os.CreateTemp("", "jacommander-upload-*")
+	if err != nil {
+		return nil, err
+	}
+
+	u := &rangeUpload{
+		storageID:  storageID,
+		path:       path,
+		size:       size,
+		file:       file,
+		started:    now,
+		lastActive: now,
+	}
+	if mw, ok := storage.As[storage.MultipartWriter](fs); ok && mw.MultipartPartSize() > 0 && size > mw.MultipartPartSize() {
+		u.multipart = mw
+	}
+	uh.uploads[id] = u
+	return u, nil
+}
+
+// UploadRange receives one Content-Range chunk of a resumable upload.
+// Chunks may arrive in any order and may be repeated; the file is written
+// to storage once every byte has been received.
+func (uh *UploadHandler) UploadRange(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	storageID := r.URL.Query().Get("storage")
+	path := r.URL.Query().Get("path")
+
+	if path == "" {
+		errorResponse(w, "path is required", http.StatusBadRequest)
+		return
+	}
+
+	rng, total, err := parseContentRange(r.Header.Get("Content-Range"))
+	if err != nil {
+		errorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	fs, ok := uh.storageManager.Get(storageID)
+	if !ok {
+		errorResponse(w, "Storage not found", http.StatusNotFound)
+		return
+	}
+
+	upload, err := uh.session(id, fs, storageID, path, total)
+	if err != nil {
+		errorResponse(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	upload.mu.Lock()
+	defer upload.mu.Unlock()
+	upload.lastActive = time.Now()
+
+	if upload.completed {
+		// A retransmission after the file was already stored
+		successResponse(w, upload.status(id))
+		return
+	}
+	if upload.file == nil {
+		// Cancelled or expired while this request waited for the lock
+		errorResponse(w, "Upload not found", http.StatusNotFound)
+		return
+	}
+
+	length := rng.End - rng.Start + 1
+	n, err := io.Copy(io.NewOffsetWriter(upload.file, rng.Start), io.LimitReader(r.Body, length))
+	if err != nil {
+		storageErrorResponse(w, fmt.Sprintf("Failed to receive range: %v", err), err)
+		return
+	}
+	if n != length {
+		errorResponse(w, fmt.Sprintf("Body has %d bytes, Content-Range expects %d", n, length), http.StatusBadRequest)
+		return
+	}
+
+	upload.addRange(rng)
+	contentType := r.URL.Query().Get("content_type")
+	if upload.multipart != nil {
+		if err := upload.writeParts(contentType, false); err != nil {
+			storageErrorResponse(w, fmt.Sprintf("Failed to send part to storage: %v", err), err)
+			return
+		}
+	}
+	if !upload.isComplete() {
+		successResponse(w, upload.status(id))
+		return
+	}
+
+	if err := upload.finish(fs, contentType); err != nil {
+		storageErrorResponse(w, fmt.Sprintf("Failed to save file: %v", err), err)
+		return
+	}
+	successResponse(w, upload.status(id))
+}
+
+// UploadStatus reports which ranges of an upload have been received, so a
+// client can resume after an interruption
+func (uh *UploadHandler) UploadStatus(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	uh.mu.Lock()
+	upload, ok := uh.uploads[id]
+	uh.mu.Unlock()
+	if !ok {
+		errorResponse(w, "Upload not found", http.StatusNotFound)
+		return
+	}
+
+	upload.mu.Lock()
+	defer upload.mu.Unlock()
+	successResponse(w, upload.status(id))
+}
+
+// CancelUpload aborts an unfinished upload, removing its temp file and any
+// parts already stored by the backend
+func (uh *UploadHandler) CancelUpload(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	uh.mu.Lock()
+	upload, ok := uh.uploads[id]
+	uh.mu.Unlock()
+	if !ok {
+		errorResponse(w, "Upload not found", http.StatusNotFound)
+		return
+	}
+
+	upload.mu.Lock()
+	defer upload.mu.Unlock()
+	if upload.completed {
+		errorResponse(w, "Upload is already complete", http.StatusConflict)
+		return
+	}
+	if err := upload.abort(); err != nil {
+		log.Printf("Error aborting upload %s: %v", id, err)
+		storageErrorResponse(w, fmt.Sprintf("Failed to abort upload: %v", err), err)
+		return
+	}
+
+	uh.mu.Lock()
+	if uh.uploads[id] == upload {
+		delete(uh.uploads, id)
+	}
+	uh.mu.Unlock()
+
+	successResponse(w, map[string]interface{}{
+		"id":        id,
+		"cancelled": true,
+	})
+}
+
+// pendingUpload is an unfinished upload, either a session of this server
+// or a multipart upload left on a storage
+type pendingUpload struct {
+	Source     string     `json:"source"` // "session" or "storage"
+	ID         string     `json:"id,omitempty"`
+	Storage    string     `json:"storage"`
+	Path       string     `json:"path"`
+	UploadID   string     `json:"upload_id,omitempty"`
+	Size       int64      `json:"size"` // bytes received or stored so far
+	Total      int64      `json:"total,omitempty"`
+	Started    time.Time  `json:"started"`
+	LastActive *time.Time `json:"last_active,omitempty"`
+	AgeSeconds int64      `json:"age_seconds"`
+}
+
+// pendingUploads collects the unfinished uploads on storageID, or on every
+// storage when it is empty. Multipart uploads owned by a session are only
+// reported with the session. Storages that fail to list are returned with
+// their error.
+func (uh *UploadHandler) pendingUploads(storageID string, now time.Time) ([]pendingUpload, map[string]error) {
+	uh.mu.Lock()
+	sessions := make(map[string]*rangeUpload, len(uh.uploads))
+	for id, u := range uh.uploads {
+		sessions[id] = u
+	}
+	uh.mu.Unlock()
+
+	pending := []pendingUpload{}
+	owned := make(map[string]bool)
+	for id, u := range sessions {
+		u.mu.Lock()
+		if !u.completed && (storageID == "" || u.storageID == storageID) {
+			lastActive := u.lastActive
+			pending = append(pending, pendingUpload{
+				Source:     "session",
+				ID:         id,
+				Storage:    u.storageID,
+				Path:       u.path,
+				UploadID:   u.uploadID,
+				Size:       u.receivedBytes(),
+				Total:      u.size,
+				Started:    u.started,
+				LastActive: &lastActive,
+				AgeSeconds: int64(now.Sub(u.started).Seconds()),
+			})
+		}
+		if u.uploadID != "" {
+			owned[u.storageID+"\x00"+u.uploadID] = true
+		}
+		u.mu.Unlock()
+	}
+
+	storageIDs := uh.storageManager.List()
+	if storageID != "" {
+		storageIDs = []string{storageID}
+	}
+	failed := make(map[string]error)
+	for _, id := range storageIDs {
+		fs, ok := uh.storageManager.Get(id)
+		if !ok {
+			continue
+		}
+		lister, ok := storage.As[storage.MultipartLister](fs)
+		if !ok {
+			continue
+		}
+		uploads, err := lister.ListMultipart()
+		if err != nil {
+			failed[id] = err
+			continue
+		}
+		for _, upload := range uploads {
+			if owned[id+"\x00"+upload.UploadID] {
+				continue
+			}
+			pending = append(pending, pendingUpload{
+				Source:     "storage",
+				Storage:    id,
+				Path:       upload.Path,
+				UploadID:   upload.UploadID,
+				Size:       upload.Size,
+				Started:    upload.Started,
+				AgeSeconds: int64(now.Sub(upload.Started).Seconds()),
+			})
+		}
+	}
+
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Started.Before(pending[j].Started) })
+	return pending, failed
+}
+
+// ListUploads lists unfinished uploads: the resumable sessions of this
+// server and the multipart uploads storages hold parts for, which may have
+// been left by an earlier run or another client
+func (uh *UploadHandler) ListUploads(w http.ResponseWriter, r *http.Request) {
+	storageID := r.URL.Query().Get("storage")
+	if storageID != "" {
+		if _, ok := uh.storageManager.Get(storageID); !ok {
+			errorResponse(w, "Storage not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	pending, failed := uh.pendingUploads(storageID, time.Now())
+	response := map[string]interface{}{
+		"uploads": pending,
+		"total":   len(pending),
+	}
+	if len(failed) > 0 {
+		errs := make(map[string]string, len(failed))
+		for id, err := range failed {
+			errs[id] = err.Error()
+		}
+		response["errors"] = errs
+	}
+	successResponse(w, response)
+}
+
+// CleanupUploads aborts unfinished uploads older than the older_than
+// duration, 24h by default: sessions idle for that long, as CancelUpload
+// would, and storage multipart uploads started before then
+func (uh *UploadHandler) CleanupUploads(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	storageID := query.Get("storage")
+	olderThan := staleUploadTTL
+	if value := query.Get("older_than"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			errorResponse(w, "older_than must be a non-negative duration such as 1h or 30m", http.StatusBadRequest)
+			return
+		}
+		olderThan = d
+	}
+	if storageID != "" {
+		if _, ok := uh.storageManager.Get(storageID); !ok {
+			errorResponse(w, "Storage not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	now := time.Now()
+	cutoff := now.Add(-olderThan)
+	removed := []pendingUpload{}
+	errs := make(map[string]string)
+
+	sessions := uh.evict(func(u *rangeUpload) bool {
+		return !u.completed && !u.lastActive.After(cutoff) && (storageID == "" || u.storageID == storageID)
+	})
+	for id, u := range sessions {
+		entry := pendingUpload{
+			Source:     "session",
+			ID:         id,
+			Storage:    u.storageID,
+			Path:       u.path,
+			UploadID:   u.uploadID,
+			Size:       u.receivedBytes(),
+			Total:      u.size,
+			Started:    u.started,
+			AgeSeconds: int64(now.Sub(u.started).Seconds()),
+		}
+		if err := u.abort(); err != nil {
+			log.Printf("Error aborting upload %s: %v", id, err)
+			errs[u.storageID+":"+u.path] = err.Error()
+		} else {
+			removed = append(removed, entry)
+		}
+		u.mu.Unlock()
+	}
+
+	// Sessions still running keep their multipart uploads
+	pending, failed := uh.pendingUploads(storageID, now)
+	for id, err := range failed {
+		errs[id] = err.Error()
+	}
+	for _, upload := range pending {
+		if upload.Source != "storage" || upload.Started.After(cutoff) {
+			continue
+		}
+		fs, _ := uh.storageManager.Get(upload.Storage)
+		mw, ok := storage.As[storage.MultipartWriter](fs)
+		if !ok {
+			continue
+		}
+		if err := mw.AbortMultipart(upload.Path, upload.UploadID); err != nil {
+			log.Printf("Error aborting multipart upload of %s on %s: %v", upload.Path, upload.Storage, err)
+			errs[upload.Storage+":"+upload.Path] = err.Error()
+			continue
+		}
+		removed = append(removed, upload)
+	}
+
+	response := map[string]interface{}{
+		"removed":    removed,
+		"count":      len(removed),
+		"older_than": olderThan.String(),
+	}
+	if len(errs) > 0 {
+		response["errors"] = errs
+	}
+	successResponse(w, response)
+}
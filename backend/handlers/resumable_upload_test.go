@@ -0,0 +1,396 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+func TestUploadHandler_UploadRange(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+
+	fs := newMockFileSystem()
+	mgr := storage.NewManager()
+	mgr.Register("mock", fs)
+	handler := NewUploadHandler(mgr)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/api/fs/upload/{id}", handler.UploadRange).Methods("PUT")
+	router.HandleFunc("/api/fs/upload/{id}", handler.UploadStatus).Methods("GET")
+
+	content := "0123456789abcdefghijklmnopqrstuvwxyz"
+	total := len(content)
+
+	type status struct {
+		Received int64 `json:"received"`
+		Complete bool  `json:"complete"`
+	}
+
+	put := func(id, path string, start, end int) (*httptest.ResponseRecorder, status) {
+		url := fmt.Sprintf("/api/fs/upload/%s?storage=mock&path=%s", id, path)
+		req := httptest.NewRequest("PUT", url, strings.NewReader(content[start:end+1]))
+		req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, total))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		var resp struct {
+			Data status `json:"data"`
+		}
+		_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+		return rr, resp.Data
+	}
+
+	t.Run("Out of order with duplicate", func(t *testing.T) {
+		steps := []struct {
+			start, end int
+			received   int64
+		}{
+			{24, 35, 12},
+			{0, 11, 24},
+			{0, 11, 24}, // duplicate
+			{8, 15, 28}, // overlaps a received range
+		}
+		for _, step := range steps {
+			rr, st := put("up1", "/out.txt", step.start, step.end)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("Range %d-%d: expected 200, got %d: %s", step.start, step.end, rr.Code, rr.Body.String())
+			}
+			if st.Complete || st.Received != step.received {
+				t.Errorf("Range %d-%d: expected %d bytes pending, got %+v", step.start, step.end, step.received, st)
+			}
+		}
+		if _, ok := fs.files["/out.txt"]; ok {
+			t.Fatal("File written before all ranges arrived")
+		}
+
+		rr, st := put("up1", "/out.txt", 16, 23)
+		if rr.Code != http.StatusOK || !st.Complete {
+			t.Fatalf("Expected upload to complete, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if got := string(fs.files["/out.txt"]); got != content {
+			t.Errorf("Content mismatch: got %q", got)
+		}
+
+		// Retransmitting after completion is harmless
+		rr, st = put("up1", "/out.txt", 0, 11)
+		if rr.Code != http.StatusOK || !st.Complete {
+			t.Errorf("Expected retransmission to report completion, got %d: %s", rr.Code, rr.Body.String())
+		}
+
+		entries, _ := os.ReadDir(os.TempDir())
+		if len(entries) != 0 {
+			t.Errorf("Expected temp files to be removed, found %d", len(entries))
+		}
+	})
+
+	t.Run("Status", func(t *testing.T) {
+		put("up2", "/status.txt", 0, 9)
+
+		req := httptest.NewRequest("GET", "/api/fs/upload/up2", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"ranges":[{"start":0,"end":9}]`) {
+			t.Errorf("Unexpected status: %d %s", rr.Code, rr.Body.String())
+		}
+
+		req = httptest.NewRequest("GET", "/api/fs/upload/unknown", nil)
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("Expected 404, got %d", rr.Code)
+		}
+	})
+
+	t.Run("Different file for same ID", func(t *testing.T) {
+		rr, _ := put("up2", "/other.txt", 10, 19)
+		if rr.Code != http.StatusConflict {
+			t.Errorf("Expected 409, got %d", rr.Code)
+		}
+	})
+
+	t.Run("Invalid ranges", func(t *testing.T) {
+		for _, header := range []string{"", "bytes 5-2/10", "bytes 0-10/10", "bytes */10"} {
+			req := httptest.NewRequest("PUT", "/api/fs/upload/up3?storage=mock&path=/x", strings.NewReader("x"))
+			if header != "" {
+				req.Header.Set("Content-Range", header)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			if rr.Code != http.StatusBadRequest {
+				t.Errorf("%q: expected 400, got %d", header, rr.Code)
+			}
+		}
+
+		req := httptest.NewRequest("PUT", "/api/fs/upload/up3?storage=mock&path=/x", strings.NewReader("short"))
+		req.Header.Set("Content-Range", "bytes 0-9/10")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Short body: expected 400, got %d", rr.Code)
+		}
+	})
+}
+
+// multipartFileSystem stores uploads in parts of partSize bytes
+type multipartFileSystem struct {
+	*mockFileSystem
+	partSize int64
+	uploads  map[string][][]byte
+	aborted  []string
+	started  int
+	// begun records when each upload was started
+	begun map[string]storage.MultipartUpload
+}
+
+func (m *multipartFileSystem) MultipartPartSize() int64 { return m.partSize }
+
+func (m *multipartFileSystem) StartMultipart(path, contentType string) (string, error) {
+	m.started++
+	id := fmt.Sprintf("mp%d", m.started)
+	m.uploads[id] = nil
+	if m.begun == nil {
+		m.begun = make(map[string]storage.MultipartUpload)
+	}
+	m.begun[id] = storage.MultipartUpload{Path: path, UploadID: id, Started: time.Now()}
+	return id, nil
+}
+
+func (m *multipartFileSystem) ListMultipart() ([]storage.MultipartUpload, error) {
+	var uploads []storage.MultipartUpload
+	for id, parts := range m.uploads {
+		upload := m.begun[id]
+		for _, part := range parts {
+			upload.Size += int64(len(part))
+		}
+		uploads = append(uploads, upload)
+	}
+	return uploads, nil
+}
+
+func (m *multipartFileSystem) WritePart(path, uploadID string, n int, data io.ReadSeeker) (string, error) {
+	part, err := io.ReadAll(data)
+	if err != nil {
+		return "", err
+	}
+	if n != len(m.uploads[uploadID])+1 {
+		return "", fmt.Errorf("part %d sent out of order", n)
+	}
+	m.uploads[uploadID] = append(m.uploads[uploadID], part)
+	return fmt.Sprintf("etag%d", n), nil
+}
+
+func (m *multipartFileSystem) CompleteMultipart(path, uploadID string, parts []string) error {
+	stored := m.uploads[uploadID]
+	if len(parts) != len(stored) {
+		return fmt.Errorf("expected %d parts, got %d", len(stored), len(parts))
+	}
+	m.files[path] = bytes.Join(stored, nil)
+	delete(m.uploads, uploadID)
+	return nil
+}
+
+func (m *multipartFileSystem) AbortMultipart(path, uploadID string) error {
+	delete(m.uploads, uploadID)
+	m.aborted = append(m.aborted, uploadID)
+	return nil
+}
+
+func TestUploadHandler_Multipart(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+
+	fs := &multipartFileSystem{mockFileSystem: newMockFileSystem(), partSize: 10, uploads: make(map[string][][]byte)}
+	mgr := storage.NewManager()
+	mgr.Register("mp", fs)
+	handler := NewUploadHandler(mgr)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/api/fs/upload/{id}", handler.UploadRange).Methods("PUT")
+	router.HandleFunc("/api/fs/upload/{id}", handler.UploadStatus).Methods("GET")
+	router.HandleFunc("/api/fs/upload/{id}", handler.CancelUpload).Methods("DELETE")
+
+	content := "0123456789abcdefghijklmnopqrstuvwxyz"
+	put := func(id string, start, end int) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/api/fs/upload/"+id+"?storage=mp&path=/big.bin", strings.NewReader(content[start:end+1]))
+		req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(content)))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Range %d-%d: expected 200, got %d: %s", start, end, rr.Code, rr.Body.String())
+		}
+		return rr
+	}
+	do := func(method, id string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(method, "/api/fs/upload/"+id, nil))
+		return rr
+	}
+	tempFiles := func() int {
+		entries, _ := os.ReadDir(os.TempDir())
+		return len(entries)
+	}
+
+	t.Run("Parts sent as they arrive", func(t *testing.T) {
+		put("m1", 25, 35)
+		if len(fs.uploads) != 0 {
+			t.Fatal("Expected no parts before the start of the file arrives")
+		}
+		put("m1", 0, 19)
+		if parts := fs.uploads["mp1"]; len(parts) != 2 {
+			t.Fatalf("Expected 2 whole parts sent, got %d", len(parts))
+		}
+		if _, ok := fs.files["/big.bin"]; ok {
+			t.Fatal("File completed before all ranges arrived")
+		}
+
+		put("m1", 0, 9) // retransmission sends nothing new
+		put("m1", 20, 24)
+		if got := string(fs.files["/big.bin"]); got != content {
+			t.Errorf("Content mismatch: got %q", got)
+		}
+		if len(fs.uploads) != 0 || tempFiles() != 0 {
+			t.Errorf("Expected no leftover parts or temp files, got %d uploads and %d files", len(fs.uploads), tempFiles())
+		}
+	})
+
+	t.Run("Cancel", func(t *testing.T) {
+		put("m2", 0, 24)
+		if len(fs.uploads["mp2"]) != 2 {
+			t.Fatalf("Expected 2 parts sent, got %d", len(fs.uploads["mp2"]))
+		}
+
+		if rr := do("DELETE", "m2"); rr.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if len(fs.aborted) != 1 || fs.aborted[0] != "mp2" {
+			t.Errorf("Expected mp2 to be aborted, got %v", fs.aborted)
+		}
+		if tempFiles() != 0 {
+			t.Errorf("Expected the temp file to be removed, found %d", tempFiles())
+		}
+		if rr := do("GET", "m2"); rr.Code != http.StatusNotFound {
+			t.Errorf("Expected cancelled upload to be gone, got %d", rr.Code)
+		}
+		if rr := do("DELETE", "m2"); rr.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 cancelling twice, got %d", rr.Code)
+		}
+		if rr := do("DELETE", "m1"); rr.Code != http.StatusConflict {
+			t.Errorf("Expected 409 cancelling a completed upload, got %d", rr.Code)
+		}
+	})
+
+	t.Run("Expired uploads are aborted", func(t *testing.T) {
+		put("m3", 0, 14)
+		handler.expire(time.Now().Add(staleUploadTTL + time.Minute))
+		if len(fs.aborted) != 2 || fs.aborted[1] != "mp3" {
+			t.Errorf("Expected mp3 to be aborted, got %v", fs.aborted)
+		}
+		if tempFiles() != 0 {
+			t.Errorf("Expected the temp file to be removed, found %d", tempFiles())
+		}
+		if rr := do("GET", "m3"); rr.Code != http.StatusNotFound {
+			t.Errorf("Expected expired upload to be gone, got %d", rr.Code)
+		}
+	})
+}
+
+func TestUploadHandler_ListAndCleanup(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+
+	fs := &multipartFileSystem{mockFileSystem: newMockFileSystem(), partSize: 10, uploads: make(map[string][][]byte)}
+	mgr := storage.NewManager()
+	mgr.Register("mp", fs)
+	handler := NewUploadHandler(mgr)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/api/fs/uploads", handler.ListUploads).Methods("GET")
+	router.HandleFunc("/api/fs/uploads/cleanup", handler.CleanupUploads).Methods("DELETE")
+	router.HandleFunc("/api/fs/upload/{id}", handler.UploadRange).Methods("PUT")
+
+	do := func(method, url string, body io.Reader, contentRange string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, body)
+		if contentRange != "" {
+			req.Header.Set("Content-Range", contentRange)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	decode := func(rr *httptest.ResponseRecorder, key string) []pendingUpload {
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var resp struct {
+			Data map[string]json.RawMessage `json:"data"`
+		}
+		var uploads []pendingUpload
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if err := json.Unmarshal(resp.Data[key], &uploads); err != nil {
+			t.Fatalf("Failed to decode %s: %v", key, err)
+		}
+		return uploads
+	}
+
+	// A session that has sent its first part, and a multipart upload left
+	// behind by an earlier run two days ago
+	if rr := do("PUT", "/api/fs/upload/s1?storage=mp&path=/big.bin", strings.NewReader("0123456789abcde"), "bytes 0-14/40"); rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	orphan, _ := fs.StartMultipart("/left.bin", "")
+	if _, err := fs.WritePart("/left.bin", orphan, 1, strings.NewReader("leftover")); err != nil {
+		t.Fatal(err)
+	}
+	old := fs.begun[orphan]
+	old.Started = time.Now().Add(-48 * time.Hour)
+	fs.begun[orphan] = old
+
+	uploads := decode(do("GET", "/api/fs/uploads", nil, ""), "uploads")
+	if len(uploads) != 2 {
+		t.Fatalf("Expected the session and the orphaned upload, got %+v", uploads)
+	}
+	left, session := uploads[0], uploads[1]
+	if left.Source != "storage" || left.Path != "/left.bin" || left.Size != 8 || left.AgeSeconds < 47*3600 {
+		t.Errorf("Unexpected orphaned upload %+v", left)
+	}
+	if session.Source != "session" || session.ID != "s1" || session.UploadID != "mp1" || session.Size != 15 || session.Total != 40 {
+		t.Errorf("Unexpected session %+v", session)
+	}
+
+	if rr := do("DELETE", "/api/fs/uploads/cleanup?older_than=soon", nil, ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid duration, got %d", rr.Code)
+	}
+
+	// The default age only catches the orphan
+	removed := decode(do("DELETE", "/api/fs/uploads/cleanup", nil, ""), "removed")
+	if len(removed) != 1 || removed[0].UploadID != orphan {
+		t.Errorf("Expected only the orphan to be removed, got %+v", removed)
+	}
+	if len(fs.aborted) != 1 || fs.aborted[0] != orphan {
+		t.Errorf("Expected %s to be aborted, got %v", orphan, fs.aborted)
+	}
+
+	removed = decode(do("DELETE", "/api/fs/uploads/cleanup?older_than=0s", nil, ""), "removed")
+	if len(removed) != 1 || removed[0].ID != "s1" {
+		t.Errorf("Expected the session to be removed, got %+v", removed)
+	}
+	if len(fs.uploads) != 0 {
+		t.Errorf("Expected the session's parts to be aborted, got %v", fs.uploads)
+	}
+	if entries, _ := os.ReadDir(os.TempDir()); len(entries) != 0 {
+		t.Errorf("Expected the temp file to be removed, found %d", len(entries))
+	}
+	if uploads := decode(do("GET", "/api/fs/uploads", nil, ""), "uploads"); len(uploads) != 0 {
+		t.Errorf("Expected no uploads left, got %+v", uploads)
+	}
+}
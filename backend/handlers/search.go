@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+// DefaultSearchResults is the number of matches /fs/search returns unless
+// max_results asks for another
+const DefaultSearchResults = 100
+
+// Search finds the entries below a directory whose names match a pattern
+// and streams them as newline-delimited JSON while they're found, ending
+// with the same summary line as FindFiles. It works on every backend:
+// those with a native search use it, the rest are walked. With content,
+// it reports the text files that contain it instead, with the matching
+// lines.
+func (h *FileHandlers) Search(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	storageID := query.Get("storage")
+	root := query.Get("path")
+	if root == "" {
+		root = "/"
+	}
+	pattern := query.Get("pattern")
+	content := query.Get("content")
+	if pattern == "" && content == "" {
+		errorResponse(w, "pattern or content is required", http.StatusBadRequest)
+		return
+	}
+
+	opts := storage.SearchOptions{
+		CaseSensitive: query.Get("case_sensitive") == "true",
+		Regex:         query.Get("regex") == "true",
+		Limits:        findWalkLimits,
+		Context:       r.Context(),
+	}
+	if _, err := storage.NewNameMatcher(pattern, opts); err != nil {
+		errorResponse(w, fmt.Sprintf("Invalid pattern: %v", err), http.StatusBadRequest)
+		return
+	}
+	exclude, err := queryExcludes(query)
+	if err != nil {
+		errorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts.Exclude = exclude
+
+	contentMaxSize := int64(findGrepMaxSize)
+	if value := query.Get("content_max_size"); value != "" {
+		if contentMaxSize, err = strconv.ParseInt(value, 10, 64); err != nil || contentMaxSize < 1 {
+			errorResponse(w, "content_max_size must be a positive number of bytes", http.StatusBadRequest)
+			return
+		}
+	}
+	maxResults := DefaultSearchResults
+	if value := query.Get("max_results"); value != "" {
+		if maxResults, err = strconv.Atoi(value); err != nil || maxResults < 1 || maxResults > MaxFindResults {
+			errorResponse(w, fmt.Sprintf("max_results must be between 1 and %d", MaxFindResults), http.StatusBadRequest)
+			return
+		}
+	}
+
+	fs, ok := h.storageManager.Get(storageID)
+	if !ok {
+		errorResponse(w, "Storage not found", http.StatusNotFound)
+		return
+	}
+	info, err := fs.Stat(root)
+	if err != nil {
+		storageErrorResponse(w, "Directory not found", err)
+		return
+	}
+	if !info.IsDir {
+		errorResponse(w, "Path is not a directory", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	enc := json.NewEncoder(w)
+	_ = rc.Flush()
+
+	ctx := r.Context()
+	count := 0
+	send := func(entry interface{}) error {
+		if err := enc.Encode(entry); err != nil {
+			return err
+		}
+		_ = rc.Flush()
+		count++
+		if count >= maxResults {
+			return errFindFull
+		}
+		return nil
+	}
+	if content != "" {
+		cs := newContentSearch(fs, content, opts.CaseSensitive, contentMaxSize)
+		err = cs.search(ctx, root, pattern, opts, func(hit contentHit) error {
+			return send(hit)
+		})
+	} else {
+		err = storage.Search(fs, root, pattern, opts, func(entry storage.FileInfo) error {
+			return send(entry)
+		})
+	}
+	writeFindSummary(ctx, enc, root, count, err)
+}
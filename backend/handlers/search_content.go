@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+// Limits of the content mode of /fs/search
+const (
+	// searchContentWorkers is how many files are read at once
+	searchContentWorkers = 4
+
+	// maxContentMatches is how many matching lines are reported per file
+	maxContentMatches = 20
+
+	// maxContentLine is how much of a matching line is reported
+	maxContentLine = 500
+
+	// maxScanLine is the longest line that can be searched. The rest of a
+	// file is skipped after a longer one.
+	maxScanLine = 1 << 20
+)
+
+// contentHit is a file found by a content search, with the lines that
+// contain the text
+type contentHit struct {
+	storage.FileInfo
+	Matches []storage.SearchResult `json:"matches"`
+	// MatchesTruncated is set when the file has more matching lines than
+	// are listed
+	MatchesTruncated bool `json:"matches_truncated,omitempty"`
+}
+
+// contentSearch looks for text inside the files a name search finds
+type contentSearch struct {
+	fs            storage.FileSystem
+	text          []byte // lower-cased unless caseSensitive
+	caseSensitive bool
+	maxSize       int64
+}
+
+func newContentSearch(fs storage.FileSystem, text string, caseSensitive bool, maxSize int64) *contentSearch {
+	cs := &contentSearch{fs: fs, text: []byte(text), caseSensitive: caseSensitive, maxSize: maxSize}
+	if !caseSensitive {
+		cs.text = bytes.ToLower(cs.text)
+	}
+	return cs
+}
+
+// search greps the files below root whose names match pattern, reading
+// searchContentWorkers of them at a time, and calls emit for each file
+// with matching lines. emit is never called concurrently; the first error
+// it returns stops the search and is returned.
+func (cs *contentSearch) search(ctx context.Context, root, pattern string, opts storage.SearchOptions, emit func(contentHit) error) error {
+	files := make(chan storage.FileInfo)
+	var mu sync.Mutex
+	var emitErr error
+	stopped := func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		return emitErr
+	}
+
+	var wg sync.WaitGroup
+	for range searchContentWorkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for info := range files {
+				if stopped() != nil {
+					continue
+				}
+				matches, truncated, err := cs.grep(ctx, info)
+				if err != nil {
+					if ctx.Err() == nil {
+						log.Printf("Error searching content of %s: %v", info.Path, err)
+					}
+					continue
+				}
+				if len(matches) == 0 {
+					continue
+				}
+				mu.Lock()
+				if emitErr == nil {
+					emitErr = emit(contentHit{FileInfo: info, Matches: matches, MatchesTruncated: truncated})
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	err := storage.Search(cs.fs, root, pattern, opts, func(info storage.FileInfo) error {
+		if info.IsDir {
+			return nil
+		}
+		if err := stopped(); err != nil {
+			return err
+		}
+		select {
+		case files <- info:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	close(files)
+	wg.Wait()
+
+	if emitErr != nil {
+		return emitErr
+	}
+	return err
+}
+
+// grep returns the lines of a file that contain the text, and whether
+// there were more than are returned. Binary files, symlinks and files over
+// the size limit are skipped.
+func (cs *contentSearch) grep(ctx context.Context, info storage.FileInfo) ([]storage.SearchResult, bool, error) {
+	if info.IsLink || info.Size > cs.maxSize {
+		return nil, false, nil
+	}
+
+	reader, err := cs.fs.Read(info.Path)
+	if err != nil {
+		return nil, false, err
+	}
+	defer reader.Close()
+
+	sample := make([]byte, sniffLen)
+	n, err := io.ReadFull(reader, sample)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, false, err
+	}
+	if !classifyContent(sample[:n], n == sniffLen).isText {
+		return nil, false, nil
+	}
+
+	scanner := bufio.NewScanner(io.MultiReader(bytes.NewReader(sample[:n]), reader))
+	scanner.Buffer(make([]byte, 64<<10), maxScanLine)
+	var matches []storage.SearchResult
+	for line := 1; scanner.Scan(); line++ {
+		if err := ctx.Err(); err != nil {
+			return nil, false, err
+		}
+		text := scanner.Bytes()
+		haystack := text
+		if !cs.caseSensitive {
+			haystack = bytes.ToLower(text)
+		}
+		if !bytes.Contains(haystack, cs.text) {
+			continue
+		}
+		if len(matches) == maxContentMatches {
+			return matches, true, nil
+		}
+		matches = append(matches, storage.SearchResult{Path: info.Path, Line: line, Content: reportedLine(text)})
+	}
+	if err := scanner.Err(); err != nil && err != bufio.ErrTooLong {
+		return nil, false, err
+	}
+	return matches, false, nil
+}
+
+// reportedLine returns a matching line as reported, cut to maxContentLine
+// bytes without splitting a character
+func reportedLine(line []byte) string {
+	line = bytes.TrimRight(line, "\r")
+	if len(line) > maxContentLine {
+		return strings.ToValidUTF8(string(line[:maxContentLine]), "")
+	}
+	return string(line)
+}
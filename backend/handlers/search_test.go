@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+func TestFileHandlers_Search(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"notes.txt", "docs/Report.TXT", "docs/deep/todo.txt", "node_modules/report.txt"} {
+		full := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mgr := storage.NewManager()
+	mgr.Register("local", storage.NewLocalStorage(root))
+	handler := NewFileHandlers(mgr)
+	router := mux.NewRouter()
+	router.HandleFunc("/api/fs/search", handler.Search).Methods("GET")
+
+	search := func(params string) ([]string, map[string]interface{}) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/fs/search?storage=local&exclude=node_modules&"+params, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Search %s: expected status 200, got %d: %s", params, rr.Code, rr.Body.String())
+		}
+		var paths []string
+		var summary map[string]interface{}
+		for _, line := range strings.Split(strings.TrimSpace(rr.Body.String()), "\n") {
+			var obj map[string]interface{}
+			if err := json.Unmarshal([]byte(line), &obj); err != nil {
+				t.Fatalf("Invalid NDJSON line %q: %v", line, err)
+			}
+			if p, ok := obj["path"].(string); ok {
+				paths = append(paths, p)
+			} else {
+				summary = obj
+			}
+		}
+		sort.Strings(paths)
+		return paths, summary
+	}
+
+	tests := []struct {
+		params string
+		want   []string
+	}{
+		{"pattern=report", []string{"/docs/Report.TXT"}},
+		{"pattern=Report&case_sensitive=true", []string{"/docs/Report.TXT"}},
+		{"pattern=report&case_sensitive=true", nil},
+		{"pattern=^(notes|todo)\\.&regex=true", []string{"/docs/deep/todo.txt", "/notes.txt"}},
+		{"pattern=o&path=/docs", []string{"/docs/Report.TXT", "/docs/deep/todo.txt"}},
+	}
+	for _, tt := range tests {
+		got, summary := search(tt.params)
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("Search %s: expected %v, got %v", tt.params, tt.want, got)
+		}
+		if summary["done"] != true || summary["truncated"] != false {
+			t.Errorf("Search %s: unexpected summary %v", tt.params, summary)
+		}
+	}
+
+	got, summary := search("pattern=t&max_results=2")
+	if len(got) != 2 || summary["truncated"] != true {
+		t.Errorf("Expected 2 results and a truncated summary, got %v %v", got, summary)
+	}
+
+	for params, want := range map[string]int{
+		"pattern=":                  http.StatusBadRequest,
+		"pattern=(&regex=true":      http.StatusBadRequest,
+		"pattern=a&max_results=0":   http.StatusBadRequest,
+		"pattern=a&path=/notes.txt": http.StatusBadRequest,
+		"pattern=a&path=/missing":   http.StatusNotFound,
+	} {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/fs/search?storage=local&"+params, nil))
+		if rr.Code != want {
+			t.Errorf("Search %s: expected status %d, got %d", params, want, rr.Code)
+		}
+	}
+}
+
+// openCountingFileSystem tracks how many files are open for reading at once
+type openCountingFileSystem struct {
+	storage.FileSystem
+	open, peak atomic.Int32
+}
+
+func (c *openCountingFileSystem) Read(path string) (io.ReadCloser, error) {
+	reader, err := c.FileSystem.Read(path)
+	if err != nil {
+		return nil, err
+	}
+	open := c.open.Add(1)
+	for peak := c.peak.Load(); open > peak && !c.peak.CompareAndSwap(peak, open); peak = c.peak.Load() {
+	}
+	time.Sleep(5 * time.Millisecond)
+	return &openCountedReader{ReadCloser: reader, fs: c}, nil
+}
+
+type openCountedReader struct {
+	io.ReadCloser
+	fs *openCountingFileSystem
+}
+
+func (r *openCountedReader) Close() error {
+	r.fs.open.Add(-1)
+	return r.ReadCloser.Close()
+}
+
+func TestFileHandlers_SearchContent(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"match1.txt":        "hello world\nnothing here\nHello again\n",
+		"match2.md":         "say hello",
+		"nomatch.txt":       "goodbye",
+		"subdir/match3.txt": "first\r\nsecond hello\r\n",
+		"binary.bin":        "hello\x00\x01\x02",
+		"big.log":           strings.Repeat("hello\n", 200),
+	}
+	for i := 0; i < 30; i++ {
+		files[fmt.Sprintf("many/%02d.txt", i)] = "x\nhello\n"
+	}
+	for name, content := range files {
+		full := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fs := &openCountingFileSystem{FileSystem: storage.NewLocalStorage(root)}
+	mgr := storage.NewManager()
+	mgr.Register("local", fs)
+	handler := NewFileHandlers(mgr)
+
+	search := func(params string) (map[string]contentHit, map[string]interface{}) {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		handler.Search(rr, httptest.NewRequest("GET", "/api/fs/search?storage=local&exclude=many&"+params, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Search %s: expected status 200, got %d: %s", params, rr.Code, rr.Body.String())
+		}
+		hits := make(map[string]contentHit)
+		var summary map[string]interface{}
+		for _, line := range strings.Split(strings.TrimSpace(rr.Body.String()), "\n") {
+			var hit contentHit
+			if err := json.Unmarshal([]byte(line), &hit); err == nil && hit.Path != "" {
+				hits[hit.Path] = hit
+				continue
+			}
+			if err := json.Unmarshal([]byte(line), &summary); err != nil {
+				t.Fatalf("Invalid NDJSON line %q: %v", line, err)
+			}
+		}
+		return hits, summary
+	}
+
+	hits, summary := search("content=hello&content_max_size=1000")
+	if len(hits) != 3 || summary["done"] != true {
+		t.Fatalf("Expected 3 text files under the size limit, got %v %v", hits, summary)
+	}
+	want := []storage.SearchResult{{Path: "/match1.txt", Line: 1, Content: "hello world"}, {Path: "/match1.txt", Line: 3, Content: "Hello again"}}
+	if got := hits["/match1.txt"].Matches; len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if got := hits["/subdir/match3.txt"].Matches; len(got) != 1 || got[0].Line != 2 || got[0].Content != "second hello" {
+		t.Errorf("Unexpected matches in match3.txt: %v", got)
+	}
+
+	// Case-sensitive, narrowed by name
+	hits, _ = search("content=Hello&case_sensitive=true&pattern=.txt")
+	if len(hits) != 1 || len(hits["/match1.txt"].Matches) != 1 {
+		t.Errorf("Expected only the capitalised line, got %v", hits)
+	}
+
+	// Without a size limit the big file is searched, with its lines capped
+	hits, _ = search("content=hello&pattern=big")
+	if big := hits["/big.log"]; len(big.Matches) != maxContentMatches || !big.MatchesTruncated {
+		t.Errorf("Expected %d matches and a truncation flag, got %d %v", maxContentMatches, len(big.Matches), big.MatchesTruncated)
+	}
+
+	// Reading is bounded and stops at max_results
+	rr := httptest.NewRecorder()
+	handler.Search(rr, httptest.NewRequest("GET", "/api/fs/search?storage=local&path=/many&content=hello", nil))
+	if n := strings.Count(rr.Body.String(), "\n"); n != 31 {
+		t.Errorf("Expected 30 files and a summary, got %d lines", n)
+	}
+	if peak := fs.peak.Load(); peak > searchContentWorkers {
+		t.Errorf("Expected at most %d files open at once, got %d", searchContentWorkers, peak)
+	}
+	_, summary = search("content=hello&path=/many&max_results=5")
+	if summary["count"] != float64(5) || summary["truncated"] != true {
+		t.Errorf("Expected a truncated search of 5 files, got %v", summary)
+	}
+}
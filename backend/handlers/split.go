@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+// maxSplitParts bounds how many parts one split may create
+const maxSplitParts = 10000
+
+// SplitRequest names a file to cut into parts of PartSize bytes
+type SplitRequest struct {
+	Storage  string `json:"storage"`
+	Path     string `json:"path"`
+	PartSize int64  `json:"part_size"`
+
+	// OutputPrefix is where the parts go, as <prefix>.part001 and so on;
+	// defaults to the source path
+	OutputPrefix string `json:"output_prefix"`
+}
+
+// progressReader reports the bytes read through it to a tracker and stops
+// once ctx is cancelled
+type progressReader struct {
+	ctx     context.Context
+	r       io.Reader
+	read    *int64
+	tracker *ProgressTracker
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	if err := p.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := p.r.Read(b)
+	*p.read += int64(n)
+	p.tracker.Update(*p.read)
+	return n, err
+}
+
+// splitPartName returns the name of part n (from 1) of a split into count
+// parts. Suffixes are zero-padded so the parts sort in order.
+func splitPartName(prefix string, n, count int) string {
+	width := max(3, len(strconv.Itoa(count)))
+	return fmt.Sprintf("%s.part%0*d", prefix, width, n)
+}
+
+// Split cuts a file into sequential parts of part_size bytes, the last
+// holding the remainder, and returns the paths of the parts
+func (ch *CompressionHandler) Split(w http.ResponseWriter, r *http.Request) {
+	var req SplitRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Path == "" {
+		errorResponse(w, "path is required", http.StatusBadRequest)
+		return
+	}
+	if req.PartSize <= 0 {
+		errorResponse(w, "part_size must be greater than 0", http.StatusBadRequest)
+		return
+	}
+	if req.OutputPrefix == "" {
+		req.OutputPrefix = req.Path
+	}
+
+	fs, ok := ch.storageManager.Get(req.Storage)
+	if !ok {
+		errorResponse(w, "Storage not found", http.StatusNotFound)
+		return
+	}
+
+	info, err := fs.Stat(req.Path)
+	if err != nil {
+		errorResponse(w, fmt.Sprintf("File not found: %s", req.Path), http.StatusNotFound)
+		return
+	}
+	if info.IsDir {
+		errorResponse(w, "Cannot split a directory", http.StatusBadRequest)
+		return
+	}
+	if info.Size == 0 {
+		errorResponse(w, "Cannot split an empty file", http.StatusBadRequest)
+		return
+	}
+	count := int((info.Size + req.PartSize - 1) / req.PartSize)
+	if count > maxSplitParts {
+		errorResponse(w, fmt.Sprintf("part_size would create %d parts, more than the %d allowed", count, maxSplitParts), http.StatusBadRequest)
+		return
+	}
+
+	op := ch.operations.Start("split", clientFromRequest(r), req.Storage, []string{req.Path})
+	defer ch.operations.Finish(op.ID)
+	stop := context.AfterFunc(r.Context(), func() { ch.operations.Cancel(op.ID) })
+	defer stop()
+
+	tracker := NewProgressTracker(ch.wsHandler, op.ID, "split", info.Size)
+	tracker.SetOperation(op)
+	w.Header().Set("X-Operation-ID", op.ID)
+
+	parts, err := splitFile(op.Context(), fs, req.Path, req.OutputPrefix, req.PartSize, count, tracker)
+	if err != nil {
+		tracker.Fail(err)
+		for _, part := range parts {
+			if delErr := fs.Delete(part); delErr != nil {
+				log.Printf("Error removing part %s of failed split: %v", part, delErr)
+			}
+		}
+		storageErrorResponse(w, fmt.Sprintf("Failed to split %s: %v", req.Path, err), err)
+		return
+	}
+	tracker.Complete()
+
+	successResponse(w, map[string]interface{}{
+		"operation_id": op.ID,
+		"parts":        parts,
+		"count":        len(parts),
+	})
+}
+
+// spooledFile is a downloaded copy of a file, removed when closed
+type spooledFile struct {
+	*os.File
+}
+
+func (s spooledFile) Close() error {
+	err := s.File.Close()
+	if removeErr := os.Remove(s.Name()); removeErr != nil {
+		log.Printf("Error removing spooled file: %v", removeErr)
+	}
+	return err
+}
+
+// spoolDownload copies reader to a temporary file and closes it, returning
+// the copy to read from
+func spoolDownload(reader io.ReadCloser) (io.ReadCloser, error) {
+	defer reader.Close()
+	tmp, err := os.CreateTemp("", "jacommander-split-*")
+	if err != nil {
+		return nil, err
+	}
+	spooled := spooledFile{File: tmp}
+	if _, err := io.Copy(tmp, reader); err != nil {
+		spooled.Close()
+		return nil, fmt.Errorf("failed to download file: %w", err)
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		spooled.Close()
+		return nil, err
+	}
+	return spooled, nil
+}
+
+// splitFile writes count parts of src, returning the parts written so far
+// when it fails
+func splitFile(ctx context.Context, fs storage.FileSystem, src, prefix string, partSize int64, count int, tracker *ProgressTracker) ([]string, error) {
+	reader, err := fs.Read(src)
+	if err != nil {
+		return nil, err
+	}
+	if st, ok := storage.As[storage.SingleTransferer](fs); ok && st.SingleTransfer() {
+		// The parts can't be written while the download is open, so the
+		// file is fetched whole first
+		if reader, err = spoolDownload(reader); err != nil {
+			return nil, err
+		}
+	}
+	defer reader.Close()
+
+	var read int64
+	source := &progressReader{ctx: ctx, r: reader, read: &read, tracker: tracker}
+
+	parts := make([]string, 0, count)
+	for n := 1; n <= count; n++ {
+		part := splitPartName(prefix, n, count)
+		start := read
+		if err := fs.Write(part, io.LimitReader(source, partSize)); err != nil {
+			return parts, err
+		}
+		parts = append(parts, part)
+		if n < count && read-start != partSize {
+			return parts, fmt.Errorf("%s changed size while being split", src)
+		}
+	}
+	return parts, nil
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+func TestCompressionHandler_Split(t *testing.T) {
+	root := t.TempDir()
+	original := bytes.Repeat([]byte("0123456789"), 250) // 2500 bytes
+	if err := os.WriteFile(filepath.Join(root, "disk.img"), original, 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(root, "folder"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+
+	mgr := storage.NewManager()
+	mgr.Register("local", storage.NewLocalStorage(root))
+	handler := NewCompressionHandler(mgr)
+
+	split := func(req SplitRequest) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(req)
+		rr := httptest.NewRecorder()
+		handler.Split(rr, httptest.NewRequest("POST", "/api/fs/split", bytes.NewReader(body)))
+		return rr
+	}
+
+	rr := split(SplitRequest{Storage: "local", Path: "/disk.img", PartSize: 1000, OutputPrefix: "/out/disk.img"})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp struct {
+		Data struct {
+			Parts []string `json:"parts"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	want := []string{"/out/disk.img.part001", "/out/disk.img.part002", "/out/disk.img.part003"}
+	sizes := []int{1000, 1000, 500}
+	if len(resp.Data.Parts) != len(want) {
+		t.Fatalf("Expected parts %v, got %v", want, resp.Data.Parts)
+	}
+	var joined []byte
+	for i, part := range resp.Data.Parts {
+		if part != want[i] {
+			t.Errorf("Expected part %s, got %s", want[i], part)
+		}
+		data, err := os.ReadFile(filepath.Join(root, part))
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", part, err)
+		}
+		if len(data) != sizes[i] {
+			t.Errorf("Expected %s to hold %d bytes, got %d", part, sizes[i], len(data))
+		}
+		joined = append(joined, data...)
+	}
+	if !bytes.Equal(joined, original) {
+		t.Error("Expected the concatenated parts to reproduce the original")
+	}
+
+	t.Run("Default prefix", func(t *testing.T) {
+		rr := split(SplitRequest{Storage: "local", Path: "/disk.img", PartSize: 5000})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if _, err := os.Stat(filepath.Join(root, "disk.img.part001")); err != nil {
+			t.Errorf("Expected the part next to the source: %v", err)
+		}
+	})
+
+	t.Run("Invalid requests", func(t *testing.T) {
+		for name, req := range map[string]SplitRequest{
+			"zero part size": {Storage: "local", Path: "/disk.img", PartSize: 0},
+			"directory":      {Storage: "local", Path: "/folder", PartSize: 1000},
+		} {
+			if rr := split(req); rr.Code != http.StatusBadRequest {
+				t.Errorf("%s: expected 400, got %d", name, rr.Code)
+			}
+		}
+	})
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+// extendedMetadata gathers the backend-specific details of a file that
+// aren't part of FileInfo. Capabilities the backend lacks are left out.
+func extendedMetadata(fs storage.FileSystem, path string) map[string]interface{} {
+	extended := make(map[string]interface{})
+
+	if ider, ok := storage.As[storage.NativeIDer](fs); ok {
+		id, err := ider.NativeID(path)
+		switch {
+		case err == nil:
+			extended["native_id"] = id
+		case !errors.Is(err, storage.ErrNotSupported):
+			log.Printf("Error getting native ID for %s: %v", path, err)
+		}
+	}
+
+	return extended
+}
+
+// StatFile returns the metadata of a single file or directory
+func (h *FileHandlers) StatFile(w http.ResponseWriter, r *http.Request) {
+	storageID := r.URL.Query().Get("storage")
+	path := r.URL.Query().Get("path")
+	detectMIME := r.URL.Query().Get("detect_mime") == "true"
+	if path == "" {
+		errorResponse(w, "path is required", http.StatusBadRequest)
+		return
+	}
+
+	// Get storage backend
+	fs, ok := h.storageManager.Get(storageID)
+	if !ok {
+		errorResponse(w, "Storage not found", http.StatusNotFound)
+		return
+	}
+
+	fs = archiveView(fs, path)
+
+	info, err := fs.Stat(path)
+	if err != nil {
+		errorResponse(w, "File not found", http.StatusNotFound)
+		return
+	}
+	if detectMIME {
+		info = h.withSniffedContent(fs, storageID, path, info)
+	}
+
+	successResponse(w, map[string]interface{}{
+		"info":     info,
+		"extended": extendedMetadata(fs, path),
+	})
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+func TestFileHandlers_StatFile(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "a.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	mockFS := newMockFileSystem()
+	mockFS.files["/b.txt"] = []byte("mock")
+
+	mgr := storage.NewManager()
+	mgr.Register("local", storage.NewLocalStorage(root))
+	mgr.Register("mock", mockFS)
+	router := mux.NewRouter()
+	router.HandleFunc("/api/fs/stat", NewFileHandlers(mgr).StatFile).Methods("GET")
+
+	stat := func(query string) (int, map[string]interface{}) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/fs/stat?"+query, nil))
+		var resp struct {
+			Data struct {
+				Info     storage.FileInfo       `json:"info"`
+				Extended map[string]interface{} `json:"extended"`
+			} `json:"data"`
+		}
+		json.Unmarshal(rr.Body.Bytes(), &resp)
+		return rr.Code, resp.Data.Extended
+	}
+
+	code, extended := stat("storage=local&path=/a.txt")
+	if code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	if id, _ := extended["native_id"].(string); id == "" {
+		t.Errorf("Expected a native_id for local storage, got %v", extended)
+	}
+
+	code, extended = stat("storage=mock&path=/b.txt")
+	if code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	if _, ok := extended["native_id"]; ok {
+		t.Errorf("Expected no native_id for a backend without one, got %v", extended)
+	}
+
+	if code, _ := stat("storage=local&path=/missing.txt"); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for missing file, got %d", code)
+	}
+}
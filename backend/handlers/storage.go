@@ -1,9 +1,14 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/jacommander/jacommander/backend/storage"
@@ -11,16 +16,40 @@ import (
 
 // StorageHandler handles storage-related HTTP requests
 type StorageHandler struct {
-	manager *storage.CloudManager
+	manager    *storage.CloudManager
+	wsHandler  *WebSocketHandler
+	operations *OperationRegistry
+
+	// spaceTimeout bounds each storage's answer to StorageSpace; zero
+	// means defaultSpaceTimeout
+	spaceTimeout time.Duration
+
+	// testTimeout bounds TestConnection; zero means defaultTestTimeout
+	testTimeout time.Duration
 }
 
+// defaultTestTimeout is how long TestConnection waits for a storage to be
+// reached before reporting it unreachable
+const defaultTestTimeout = 10 * time.Second
+
 // NewStorageHandler creates a new storage handler
 func NewStorageHandler(manager *storage.CloudManager) *StorageHandler {
 	return &StorageHandler{
-		manager: manager,
+		manager:    manager,
+		operations: NewOperationRegistry(),
 	}
 }
 
+// SetWebSocketHandler sets the WebSocket handler transfers report progress to
+func (h *StorageHandler) SetWebSocketHandler(ws *WebSocketHandler) {
+	h.wsHandler = ws
+}
+
+// SetOperationRegistry sets the registry transfers are tracked in
+func (h *StorageHandler) SetOperationRegistry(operations *OperationRegistry) {
+	h.operations = operations
+}
+
 // ListStorages returns all available storage configurations
 func (h *StorageHandler) ListStorages(w http.ResponseWriter, r *http.Request) {
 	storages := h.manager.ListStorages()
@@ -39,8 +68,13 @@ func (h *StorageHandler) AddStorage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	auditTarget(r, config.ID)
 	if err := h.manager.AddStorage(config); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		status := http.StatusInternalServerError
+		if errors.Is(err, storage.ErrRootNotAllowed) {
+			status = http.StatusForbidden
+		}
+		http.Error(w, err.Error(), status)
 		return
 	}
 
@@ -57,6 +91,7 @@ func (h *StorageHandler) AddStorage(w http.ResponseWriter, r *http.Request) {
 func (h *StorageHandler) RemoveStorage(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	storageID := vars["id"]
+	auditTarget(r, storageID)
 
 	if err := h.manager.RemoveStorage(storageID); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -72,10 +107,78 @@ func (h *StorageHandler) RemoveStorage(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// RetryInit attempts again to initialize a storage that failed at startup,
+// without restarting the server
+func (h *StorageHandler) RetryInit(w http.ResponseWriter, r *http.Request) {
+	storageID := mux.Vars(r)["id"]
+
+	if err := h.manager.RetryInit(storageID); err != nil {
+		status := http.StatusBadGateway
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			status = http.StatusNotFound
+		case errors.Is(err, os.ErrExist):
+			status = http.StatusConflict
+		case errors.Is(err, storage.ErrRootNotAllowed):
+			status = http.StatusForbidden
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]string{
+		"status":  "success",
+		"message": "Storage initialized",
+	}); err != nil {
+		log.Printf("Error encoding retry response: %v", err)
+	}
+}
+
+// StorageStats returns the bytes read and written through a storage and
+// the operations called on it since startup or the last reset
+func (h *StorageHandler) StorageStats(w http.ResponseWriter, r *http.Request) {
+	storageID := mux.Vars(r)["id"]
+
+	stats, ok := h.manager.Stats(storageID)
+	if !ok {
+		http.Error(w, "storage "+storageID+" not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(stats.Stats()); err != nil {
+		log.Printf("Error encoding storage stats response: %v", err)
+	}
+}
+
+// StorageInfo returns a storage's backend diagnostics, such as the state
+// of an NFS mount or the memory use of a Redis server
+func (h *StorageHandler) StorageInfo(w http.ResponseWriter, r *http.Request) {
+	storageID := mux.Vars(r)["id"]
+
+	fs, err := h.manager.GetStorage(storageID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":               storageID,
+		"type":             fs.GetType(),
+		"info":             storage.Info(fs),
+		"path_conventions": storage.PathConventionsOf(fs),
+	}); err != nil {
+		log.Printf("Error encoding storage info response: %v", err)
+	}
+}
+
 // SetDefaultStorage sets a storage as the default
 func (h *StorageHandler) SetDefaultStorage(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	storageID := vars["id"]
+	auditTarget(r, storageID)
 
 	if err := h.manager.SetDefault(storageID); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -105,30 +208,45 @@ func (h *StorageHandler) TransferFiles(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// TODO: Add progress tracking via WebSocket
+	// Progress goes out over the WebSocket under the operation ID the
+	// response carries
+	op := h.operations.Start("transfer", clientFromRequest(r), request.SourceStorage, []string{request.SourcePath})
+	defer h.operations.Finish(op.ID)
+	tracker := NewProgressTracker(h.wsHandler, op.ID, "transfer", 0)
+	tracker.SetOperation(op)
+	tracker.SetFile(request.SourcePath)
+	w.Header().Set("X-Operation-ID", op.ID)
+
 	err := h.manager.TransferBetweenStorages(
 		request.SourceStorage,
 		request.SourcePath,
 		request.DestinationStorage,
 		request.DestinationPath,
-		nil, // Progress callback - can be enhanced with WebSocket
+		func(current, total int64) {
+			tracker.SetTotal(total)
+			tracker.Update(current)
+		},
 	)
 
 	if err != nil {
+		tracker.Fail(err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	tracker.Complete()
 
 	w.WriteHeader(http.StatusOK)
 	if err := json.NewEncoder(w).Encode(map[string]string{
-		"status":  "success",
-		"message": "Transfer completed successfully",
+		"status":       "success",
+		"message":      "Transfer completed successfully",
+		"operation_id": op.ID,
 	}); err != nil {
 		log.Printf("Error encoding response: %v", err)
 	}
 }
 
-// TestConnection tests a storage configuration
+// TestConnection tests a storage configuration by creating its backend and
+// listing the root, without adding it
 func (h *StorageHandler) TestConnection(w http.ResponseWriter, r *http.Request) {
 	var config storage.StorageConfig
 	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
@@ -136,37 +254,34 @@ func (h *StorageHandler) TestConnection(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Try to create a storage backend with the given config
 	var testResult struct {
 		Success bool   `json:"success"`
 		Message string `json:"message"`
 		Details string `json:"details,omitempty"`
 	}
 
-	// Create a temporary storage to test the connection
-	switch config.Type {
-	case "s3":
-		bucket, _ := config.Config["bucket"].(string)
-		region, _ := config.Config["region"].(string)
-		prefix, _ := config.Config["prefix"].(string)
-		accessKey, _ := config.Config["access_key"].(string)
-		secretKey, _ := config.Config["secret_key"].(string)
-		endpoint, _ := config.Config["endpoint"].(string)
-
-		_, err := storage.NewS3FileSystem(bucket, region, prefix, accessKey, secretKey, endpoint)
-		if err != nil {
-			testResult.Success = false
-			testResult.Message = "Connection failed"
-			testResult.Details = err.Error()
-		} else {
-			testResult.Success = true
-			testResult.Message = "Connection successful"
-		}
+	timeout := h.testTimeout
+	if timeout == 0 {
+		timeout = defaultTestTimeout
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
 
+	err := h.manager.TestStorage(ctx, config)
+	var configErr *storage.ConfigError
+	switch {
+	case err == nil:
+		testResult.Success = true
+		testResult.Message = "Connection successful"
+	case errors.As(err, &configErr):
+		testResult.Message = "Invalid configuration"
+		testResult.Details = configErr.Error()
+	case errors.Is(err, context.DeadlineExceeded):
+		testResult.Message = "Connection timed out"
+		testResult.Details = fmt.Sprintf("No answer from the %s storage within %s", config.Type, timeout)
 	default:
-		testResult.Success = false
-		testResult.Message = "Unsupported storage type"
-		testResult.Details = "Storage type " + config.Type + " is not supported"
+		testResult.Message = "Connection failed"
+		testResult.Details = err.Error()
 	}
 
 	w.Header().Set("Content-Type", "application/json")
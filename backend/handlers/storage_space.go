@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+// defaultSpaceTimeout is how long StorageSpace waits for one storage to
+// report its space
+const defaultSpaceTimeout = 5 * time.Second
+
+// storageSpace is the space of one storage in the StorageSpace response.
+// Unlimited storages, which report no quota or can't tell, have no figures
+// but Used, when they add up what they hold.
+type storageSpace struct {
+	ID        string `json:"id"`
+	Type      string `json:"type"`
+	Total     int64  `json:"total"`
+	Used      int64  `json:"used"`
+	Available int64  `json:"available"`
+	Unlimited bool   `json:"unlimited,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// spaceTotals adds up the storages that report their space
+type spaceTotals struct {
+	Total     int64 `json:"total"`
+	Used      int64 `json:"used"`
+	Available int64 `json:"available"`
+	// Counted is the number of storages in the sums. Unlimited is set when
+	// some storage has no limit, so more is available than the sums say.
+	Counted   int  `json:"counted"`
+	Unlimited bool `json:"unlimited"`
+	Errors    int  `json:"errors"`
+}
+
+// querySpace asks fs for its space, giving up after timeout. A backend
+// that doesn't answer in time is left to finish on its own.
+func querySpace(id string, fs storage.FileSystem, timeout time.Duration) storageSpace {
+	space := storageSpace{ID: id, Type: fs.GetType()}
+
+	type result struct {
+		available, total int64
+		used             int64
+		hasUsed          bool
+		err              error
+	}
+	done := make(chan result, 1)
+	go func() {
+		available, total, err := fs.GetAvailableSpace()
+		used, hasUsed := storage.Usage(fs)
+		done <- result{available, total, used, hasUsed, err}
+	}()
+
+	var res result
+	select {
+	case res = <-done:
+	case <-time.After(timeout):
+		space.Error = "timed out after " + timeout.String()
+		return space
+	}
+	switch {
+	case res.err != nil:
+		space.Error = res.err.Error()
+	case res.total < 0 || res.available < 0 || res.total >= storage.UnlimitedSpace:
+		space.Unlimited = true
+		if res.hasUsed {
+			space.Used = res.used
+		}
+	default:
+		space.Total = res.total
+		space.Available = res.available
+		space.Used = max(res.total-res.available, 0)
+	}
+	return space
+}
+
+// StorageSpace reports the space of every storage, queried concurrently,
+// along with the sums over those that have a limit
+func (h *StorageHandler) StorageSpace(w http.ResponseWriter, r *http.Request) {
+	timeout := h.spaceTimeout
+	if timeout == 0 {
+		timeout = defaultSpaceTimeout
+	}
+
+	all := h.manager.GetManager().GetAll()
+	ids := make([]string, 0, len(all))
+	for id := range all {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	spaces := make([]storageSpace, len(ids))
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			spaces[i] = querySpace(id, all[id], timeout)
+		}()
+	}
+	wg.Wait()
+
+	var totals spaceTotals
+	for _, space := range spaces {
+		switch {
+		case space.Error != "":
+			totals.Errors++
+		case space.Unlimited:
+			totals.Unlimited = true
+		default:
+			totals.Total += space.Total
+			totals.Used += space.Used
+			totals.Available += space.Available
+			totals.Counted++
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"storages": spaces,
+		"totals":   totals,
+	}); err != nil {
+		log.Printf("Error encoding storage space response: %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+// spaceFileSystem reports fixed space, after an optional delay, and the
+// bytes it holds when used is set
+type spaceFileSystem struct {
+	*mockFileSystem
+	available, total int64
+	used             int64
+	err              error
+	delay            time.Duration
+}
+
+func (s *spaceFileSystem) Usage() (int64, error) {
+	if s.used == 0 {
+		return 0, storage.ErrNotSupported
+	}
+	return s.used, nil
+}
+
+func (s *spaceFileSystem) GetAvailableSpace() (available, total int64, err error) {
+	time.Sleep(s.delay)
+	return s.available, s.total, s.err
+}
+
+func TestStorageHandler_StorageSpace(t *testing.T) {
+	manager := storage.NewCloudManager()
+	for id, fs := range map[string]*spaceFileSystem{
+		"disk":  {available: 300, total: 1000},
+		"nas":   {available: 50, total: 200},
+		"ftp":   {available: -1, total: -1},
+		"s3":    {available: storage.UnlimitedSpace, total: storage.UnlimitedSpace},
+		"minio": {available: -1, total: -1, used: 42},
+		"drive": {err: errors.New("token expired")},
+		"slow":  {available: 1, total: 1, delay: time.Second},
+	} {
+		fs.mockFileSystem = newMockFileSystem()
+		manager.GetManager().Register(id, fs)
+	}
+	h := NewStorageHandler(manager)
+	h.spaceTimeout = 100 * time.Millisecond
+
+	rr := httptest.NewRecorder()
+	start := time.Now()
+	h.StorageSpace(rr, httptest.NewRequest("GET", "/api/storages/space", nil))
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected the slow storage to be abandoned, took %v", elapsed)
+	}
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var resp struct {
+		Storages []storageSpace `json:"storages"`
+		Totals   spaceTotals    `json:"totals"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	want := map[string]storageSpace{
+		"disk":  {ID: "disk", Total: 1000, Used: 700, Available: 300},
+		"drive": {ID: "drive", Error: "token expired"},
+		"ftp":   {ID: "ftp", Unlimited: true},
+		"nas":   {ID: "nas", Total: 200, Used: 150, Available: 50},
+		"s3":    {ID: "s3", Unlimited: true},
+		"minio": {ID: "minio", Used: 42, Unlimited: true},
+	}
+	if len(resp.Storages) != 7 {
+		t.Fatalf("Expected 7 storages, got %+v", resp.Storages)
+	}
+	for i, space := range resp.Storages {
+		if i > 0 && resp.Storages[i-1].ID > space.ID {
+			t.Errorf("Expected storages sorted by ID, got %s after %s", space.ID, resp.Storages[i-1].ID)
+		}
+		space.Type = ""
+		if space.ID == "slow" {
+			if space.Error == "" {
+				t.Errorf("Expected the slow storage to time out, got %+v", space)
+			}
+			continue
+		}
+		if space != want[space.ID] {
+			t.Errorf("Expected %+v, got %+v", want[space.ID], space)
+		}
+	}
+
+	wantTotals := spaceTotals{Total: 1200, Used: 850, Available: 350, Counted: 2, Unlimited: true, Errors: 2}
+	if resp.Totals != wantTotals {
+		t.Errorf("Expected totals %+v, got %+v", wantTotals, resp.Totals)
+	}
+}
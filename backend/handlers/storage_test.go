@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+func TestStorageHandler_TestConnection(t *testing.T) {
+	h := NewStorageHandler(storage.NewCloudManager())
+	root := t.TempDir()
+	file := filepath.Join(root, "file.txt")
+	if err := os.WriteFile(file, []byte("not a directory"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		body    string
+		success bool
+		message string
+	}{
+		{"Reachable", `{"id": "t", "type": "local", "config": {"root_path": "` + root + `"}}`, true, "Connection successful"},
+		{"Unreachable", `{"id": "t", "type": "local", "config": {"root_path": "` + file + `"}}`, false, "Connection failed"},
+		{"Invalid", `{"id": "t", "type": "s3", "config": {"region": "eu-west-1"}}`, false, "Invalid configuration"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			h.TestConnection(rr, httptest.NewRequest("POST", "/api/storages/test", strings.NewReader(tt.body)))
+			var resp struct {
+				Success bool   `json:"success"`
+				Message string `json:"message"`
+				Details string `json:"details"`
+			}
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if resp.Success != tt.success || resp.Message != tt.message {
+				t.Errorf("Expected %v %q, got %+v", tt.success, tt.message, resp)
+			}
+			if !tt.success && resp.Details == "" {
+				t.Error("Expected the underlying error in details")
+			}
+		})
+	}
+
+	if _, ok := h.manager.Get("t"); ok {
+		t.Error("Expected the tested storage not to be added")
+	}
+}
@@ -0,0 +1,30 @@
+package handlers
+
+import "github.com/jacommander/jacommander/backend/storage"
+
+// DefaultSystemFilePatterns are the files operating systems leave behind
+// that hide_system_files filters out of listings
+var DefaultSystemFilePatterns = []string{
+	// macOS
+	".DS_Store", "._*", ".Spotlight-V100", ".fseventsd", ".TemporaryItems", ".Trash*", ".VolumeIcon.icns",
+	// Windows
+	"Thumbs.db", "ehthumbs.db", "desktop.ini", "$RECYCLE.BIN", "System Volume Information",
+	// Linux desktops
+	".directory",
+}
+
+// SetSystemFilePatterns replaces the glob patterns hide_system_files
+// filters out, matched against entry names
+func (h *FileHandlers) SetSystemFilePatterns(patterns []string) error {
+	filter, err := storage.NewExcludeFilter(patterns)
+	if err != nil {
+		return err
+	}
+	h.systemFiles = filter
+	return nil
+}
+
+// isSystemFile reports whether an entry is operating system clutter
+func (h *FileHandlers) isSystemFile(info storage.FileInfo) bool {
+	return h.systemFiles.Excluded(info.Name)
+}
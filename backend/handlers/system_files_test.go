@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+func TestFileHandlers_HideSystemFiles(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"report.pdf", ".bashrc", ".DS_Store", "._report.pdf", "Thumbs.db", "desktop.ini", ".Trash-1000/", ".Spotlight-V100/", "photos/"} {
+		full := filepath.Join(root, name)
+		var err error
+		if strings.HasSuffix(name, "/") {
+			err = os.Mkdir(full, 0755)
+		} else {
+			err = os.WriteFile(full, []byte("x"), 0644)
+		}
+		if err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+	}
+
+	mgr := storage.NewManager()
+	mgr.Register("local", storage.NewLocalStorage(root))
+	h := NewFileHandlers(mgr)
+
+	list := func(query string) []string {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		h.ListDirectory(rr, httptest.NewRequest("GET", "/api/fs/list?storage=local&path=/"+query, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var resp struct {
+			Data struct {
+				Files []storage.FileInfo `json:"files"`
+				Count int                `json:"count"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Failed to decode listing: %v", err)
+		}
+		if resp.Data.Count != len(resp.Data.Files) {
+			t.Errorf("Expected count %d, got %d", len(resp.Data.Files), resp.Data.Count)
+		}
+		var names []string
+		for _, f := range resp.Data.Files {
+			names = append(names, f.Name)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	if got := list(""); len(got) != 9 {
+		t.Errorf("Expected every entry by default, got %v", got)
+	}
+	want := ".bashrc,photos,report.pdf"
+	for _, query := range []string{"&hide_system_files=true", "&hide_system_files=true&calc_sizes=true"} {
+		if got := strings.Join(list(query), ","); got != want {
+			t.Errorf("%s: expected %s, got %s", query, want, got)
+		}
+	}
+
+	t.Run("Custom patterns", func(t *testing.T) {
+		if err := h.SetSystemFilePatterns([]string{"*.pdf"}); err != nil {
+			t.Fatalf("Failed to set patterns: %v", err)
+		}
+		want := ".DS_Store,.Spotlight-V100,.Trash-1000,.bashrc,Thumbs.db,desktop.ini,photos"
+		if got := strings.Join(list("&hide_system_files=true"), ","); got != want {
+			t.Errorf("Expected the patterns to replace the defaults: want %s, got %s", want, got)
+		}
+	})
+}
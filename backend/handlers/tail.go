@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+// tailPollInterval is how often backends without change notification are
+// checked for new content
+const tailPollInterval = time.Second
+
+// tailMinInterval caps how often a tailed file is read, however fast it
+// grows
+const tailMinInterval = 250 * time.Millisecond
+
+// tailMaxChunk is the largest amount of content sent in one message
+const tailMaxChunk = 64 << 10
+
+// tailChunk is the payload of a MessageTypeLog message
+type tailChunk struct {
+	Storage string `json:"storage"`
+	Path    string `json:"path"`
+	Offset  int64  `json:"offset"`
+	Content string `json:"content"`
+}
+
+// tailKey identifies a tail within a client
+func tailKey(storageID, path string) string {
+	return storageID + ":" + path
+}
+
+// startTail begins streaming content appended to a file to the client. A
+// file that is already being tailed is left alone.
+func (c *Client) startTail(message WebSocketMessage) {
+	if c.handler == nil || c.handler.storageManager == nil {
+		c.sendError("Tailing is not available")
+		return
+	}
+	fs, ok := c.handler.storageManager.Get(message.Storage)
+	if !ok {
+		c.sendError("Storage not found")
+		return
+	}
+	info, err := fs.Stat(message.Path)
+	if err != nil {
+		c.sendError(fmt.Sprintf("File not found: %s", message.Path))
+		return
+	}
+	if info.IsDir {
+		c.sendError(fmt.Sprintf("Cannot tail a directory: %s", message.Path))
+		return
+	}
+
+	key := tailKey(message.Storage, message.Path)
+	c.tailMu.Lock()
+	defer c.tailMu.Unlock()
+	if c.tails == nil {
+		c.tails = make(map[string]context.CancelFunc)
+	}
+	if _, ok := c.tails[key]; ok {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.tails[key] = cancel
+	c.tailWG.Add(1)
+	go func() {
+		defer c.tailWG.Done()
+		c.tailFile(ctx, fs, message.Storage, message.Path, info.Size)
+	}()
+}
+
+// stopTail stops tailing one file, or every file when path is empty
+func (c *Client) stopTail(storageID, path string) {
+	c.tailMu.Lock()
+	defer c.tailMu.Unlock()
+	for key, cancel := range c.tails {
+		if path == "" || key == tailKey(storageID, path) {
+			cancel()
+			delete(c.tails, key)
+		}
+	}
+}
+
+// stopAllTails stops every tail and waits for them to finish, so nothing
+// is sent once the client is gone
+func (c *Client) stopAllTails() {
+	c.stopTail("", "")
+	c.tailWG.Wait()
+}
+
+// tailFile sends whatever is appended to path after offset until ctx is
+// cancelled. Local files are watched with fsnotify; other backends are
+// polled.
+func (c *Client) tailFile(ctx context.Context, fs storage.FileSystem, storageID, path string, offset int64) {
+	var events <-chan fsnotify.Event
+	poll := tailPollInterval
+	if local, ok := storage.As[*storage.LocalStorage](fs); ok {
+		watcher, err := fsnotify.NewWatcher()
+		if err == nil {
+			defer watcher.Close()
+			if err := watcher.Add(local.ResolvePath(path)); err == nil {
+				events = watcher.Events
+				// Keep a slow poll in case the watch misses a change,
+				// e.g. when the file is replaced
+				poll = 5 * tailPollInterval
+			}
+		}
+	}
+
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case _, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+		}
+
+		next, err := c.sendAppended(ctx, fs, storageID, path, offset)
+		if err != nil {
+			log.Printf("Error tailing %s: %v", path, err)
+			c.sendError(fmt.Sprintf("Stopped tailing %s: %v", path, err))
+			c.stopTail(storageID, path)
+			return
+		}
+		offset = next
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(tailMinInterval):
+		}
+	}
+}
+
+// sendAppended sends the complete lines written after offset and returns
+// the offset to continue from. A file that shrank is taken to have been
+// truncated and is followed from its start again.
+func (c *Client) sendAppended(ctx context.Context, fs storage.FileSystem, storageID, path string, offset int64) (int64, error) {
+	info, err := fs.Stat(path)
+	if err != nil {
+		return offset, err
+	}
+	if info.Size < offset {
+		offset = 0
+	}
+
+	for offset < info.Size && ctx.Err() == nil {
+		chunk, err := readRange(fs, path, offset, tailMaxChunk)
+		if err != nil {
+			return offset, err
+		}
+		if len(chunk) == 0 {
+			break
+		}
+
+		// Hold back a trailing partial line unless it fills a whole chunk
+		if len(chunk) < tailMaxChunk {
+			end := bytes.LastIndexByte(chunk, '\n')
+			if end < 0 {
+				break
+			}
+			chunk = chunk[:end+1]
+		}
+
+		sent := c.enqueue(WebSocketMessage{
+			Type: MessageTypeLog,
+			ID:   tailKey(storageID, path),
+			Data: tailChunk{
+				Storage: storageID,
+				Path:    path,
+				Offset:  offset,
+				Content: string(chunk),
+			},
+			Timestamp: time.Now().Unix(),
+		})
+		if !sent {
+			return offset, ctx.Err()
+		}
+		offset += int64(len(chunk))
+	}
+	return offset, nil
+}
+
+// readRange reads up to limit bytes of a file starting at offset
+func readRange(fs storage.FileSystem, path string, offset, limit int64) ([]byte, error) {
+	reader, err := storage.ReadRange(fs, path, offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := reader.Close(); err != nil {
+			log.Printf("Error closing reader in readRange: %v", err)
+		}
+	}()
+	return io.ReadAll(reader)
+}
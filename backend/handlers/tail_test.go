@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+// pollingFileSystem hides the concrete storage type so tailing falls back
+// to polling
+type pollingFileSystem struct {
+	storage.FileSystem
+}
+
+// readTail collects tailed content until it contains want or the deadline
+// passes
+func readTail(t *testing.T, conn *websocket.Conn, want string) string {
+	t.Helper()
+	var received strings.Builder
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(received.String(), want) {
+		_ = conn.SetReadDeadline(deadline)
+		var message struct {
+			Type  string    `json:"type"`
+			Error string    `json:"error"`
+			Data  tailChunk `json:"data"`
+		}
+		if err := conn.ReadJSON(&message); err != nil {
+			t.Fatalf("Expected %q to be streamed, got %q before: %v", want, received.String(), err)
+		}
+		switch message.Type {
+		case MessageTypeLog:
+			received.WriteString(message.Data.Content)
+		case MessageTypeError:
+			t.Fatalf("Unexpected error message: %s", message.Error)
+		}
+	}
+	return received.String()
+}
+
+func TestWebSocket_Tail(t *testing.T) {
+	root := t.TempDir()
+	logPath := filepath.Join(root, "app.log")
+	if err := os.WriteFile(logPath, []byte("existing line\n"), 0644); err != nil {
+		t.Fatalf("Failed to create log: %v", err)
+	}
+
+	local := storage.NewLocalStorage(root)
+	mgr := storage.NewManager()
+	mgr.Register("local", local)
+	mgr.Register("polled", &pollingFileSystem{FileSystem: local})
+
+	wsh := NewWebSocketHandler()
+	wsh.SetStorageManager(mgr)
+	server := httptest.NewServer(http.HandlerFunc(wsh.Handle))
+	defer server.Close()
+
+	appendLog := func(content string) {
+		f, err := os.OpenFile(logPath, os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatalf("Failed to open log: %v", err)
+		}
+		defer f.Close()
+		if _, err := f.WriteString(content); err != nil {
+			t.Fatalf("Failed to append: %v", err)
+		}
+	}
+
+	for _, storageID := range []string{"local", "polled"} {
+		t.Run(storageID, func(t *testing.T) {
+			conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+			if err != nil {
+				t.Fatalf("Failed to connect: %v", err)
+			}
+			defer conn.Close()
+
+			if err := conn.WriteJSON(WebSocketMessage{Type: MessageTypeTail, Storage: storageID, Path: "/app.log"}); err != nil {
+				t.Fatalf("Failed to send tail request: %v", err)
+			}
+			// Give the tail time to start before the file grows
+			time.Sleep(100 * time.Millisecond)
+
+			appendLog(storageID + " first\n" + storageID + " partial")
+			got := readTail(t, conn, storageID+" first\n")
+			if strings.Contains(got, "existing line") {
+				t.Errorf("Content from before the tail started was sent: %q", got)
+			}
+			if strings.Contains(got, "partial") {
+				t.Errorf("Incomplete line was sent: %q", got)
+			}
+
+			appendLog(" line\n")
+			readTail(t, conn, storageID+" partial line\n")
+
+			if err := conn.WriteJSON(WebSocketMessage{Type: MessageTypeTailStop, Storage: storageID, Path: "/app.log"}); err != nil {
+				t.Fatalf("Failed to send tail-stop: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,274 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image/jpeg"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+// defaultThumbnailSize is the box a thumbnail fits when no size is given
+const defaultThumbnailSize = 256
+
+// maxThumbnailSize is the largest width or height a thumbnail may have
+const maxThumbnailSize = 1024
+
+// thumbnailKind reports whether a MIME type is an image thumbnails are
+// made of, and whether it's WebP, which is decoded by ffmpeg
+func thumbnailKind(contentType string) (ok, webp bool) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false, false
+	}
+	switch mediaType {
+	case "image/jpeg", "image/png", "image/gif":
+		return true, false
+	case "image/webp":
+		return true, true
+	}
+	return false, false
+}
+
+// SetThumbnailCache keeps generated thumbnails in dir, creating it if
+// needed. Entries are named after the file's path, size and modification
+// time, so a changed file gets a new thumbnail; the directory may be
+// cleared at any time.
+func (ph *PreviewHandler) SetThumbnailCache(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	ph.thumbnailDir = dir
+	return nil
+}
+
+// Thumbnail returns a JPEG thumbnail of an image, scaled down to fit width
+// by height with its aspect ratio kept. It only needs Stat and Read, so it
+// works on any storage.
+func (ph *PreviewHandler) Thumbnail(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	storageID := query.Get("storage")
+	path := query.Get("path")
+	if path == "" {
+		errorResponse(w, "path is required", http.StatusBadRequest)
+		return
+	}
+	width, err := thumbnailDimension(query.Get("width"))
+	if err != nil {
+		errorResponse(w, fmt.Sprintf("Invalid width: %v", err), http.StatusBadRequest)
+		return
+	}
+	height, err := thumbnailDimension(query.Get("height"))
+	if err != nil {
+		errorResponse(w, fmt.Sprintf("Invalid height: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	fs, ok := ph.storageManager.Get(storageID)
+	if !ok {
+		errorResponse(w, "Storage not found", http.StatusNotFound)
+		return
+	}
+
+	info, err := fs.Stat(path)
+	if err != nil {
+		errorResponse(w, fmt.Sprintf("File not found: %s", path), http.StatusNotFound)
+		return
+	}
+	if info.IsDir {
+		errorResponse(w, "Cannot make a thumbnail of a directory", http.StatusBadRequest)
+		return
+	}
+
+	contentType := info.MimeType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(info.Name))
+	}
+	isImage, webp := thumbnailKind(contentType)
+	if !isImage {
+		errorResponse(w, fmt.Sprintf("%s is not an image", info.Name), http.StatusUnsupportedMediaType)
+		return
+	}
+	if webp && ph.videoTool == "" {
+		errorResponse(w, "WebP thumbnails need ffmpeg, which is not enabled", http.StatusNotImplemented)
+		return
+	}
+	if info.Size > maxPreviewSourceSize {
+		errorResponse(w, "File is too large to preview", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	key := fmt.Sprintf("%s\x00%s\x00%d\x00%d\x00%dx%d", storageID, path, info.ModTime.UnixNano(), info.Size, width, height)
+	sum := sha256.Sum256([]byte(key))
+	name := hex.EncodeToString(sum[:]) + ".jpg"
+
+	data, ok := ph.cachedThumbnail(name)
+	if !ok {
+		data, err = ph.generateThumbnail(r.Context(), fs, path, webp, width, height)
+		if errors.Is(err, errTooManyPixels) {
+			errorResponse(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			log.Printf("Error generating thumbnail of %s: %v", path, err)
+			storageErrorResponse(w, fmt.Sprintf("Failed to generate thumbnail: %v", err), err)
+			return
+		}
+		ph.storeThumbnail(name, data)
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("Cache-Control", "private, max-age=300")
+	if _, err := w.Write(data); err != nil {
+		log.Printf("Error writing thumbnail of %s: %v", path, err)
+	}
+}
+
+// thumbnailDimension parses a width or height, defaulting when empty
+func thumbnailDimension(value string) (int, error) {
+	if value == "" {
+		return defaultThumbnailSize, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 || n > maxThumbnailSize {
+		return 0, fmt.Errorf("%q is not between 1 and %d", value, maxThumbnailSize)
+	}
+	return n, nil
+}
+
+// generateThumbnail reads path and scales it to fit width×height
+func (ph *PreviewHandler) generateThumbnail(ctx context.Context, fs storage.FileSystem, path string, webp bool, width, height int) ([]byte, error) {
+	reader, err := fs.Read(path)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	var buf bytes.Buffer
+	if webp {
+		err = ph.webpThumbnail(ctx, reader, &buf, width, height)
+	} else {
+		err = imageThumbnail(reader, &buf, width, height)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// imageThumbnail decodes a JPEG, PNG or GIF and re-encodes it scaled down
+// to fit width×height
+func imageThumbnail(src io.Reader, dst io.Writer, width, height int) error {
+	img, err := decodeBounded(src)
+	if err != nil {
+		return err
+	}
+	return jpeg.Encode(dst, scaleToFit(img, width, height), &jpeg.Options{Quality: 80})
+}
+
+// webpThumbnail has ffmpeg scale a WebP image down to fit width×height,
+// after checking its dimensions from the header
+func (ph *PreviewHandler) webpThumbnail(ctx context.Context, src io.Reader, dst io.Writer, width, height int) error {
+	var header bytes.Buffer
+	w, h, err := webpSize(io.TeeReader(src, &header))
+	if err != nil {
+		return err
+	}
+	if w*h > maxPreviewPixels {
+		return fmt.Errorf("%w: %dx%d", errTooManyPixels, w, h)
+	}
+
+	tool := &commandPreview{
+		args: func(in, out string) []string {
+			// min() keeps small images from being scaled up
+			scale := fmt.Sprintf("scale='min(iw,%d)':'min(ih,%d)':force_original_aspect_ratio=decrease", width, height)
+			return []string{ph.videoTool, "-y", "-loglevel", "error", "-i", in,
+				"-vf", scale, "-frames:v", "1", out}
+		},
+	}
+	return tool.Generate(ctx, io.MultiReader(&header, src), dst)
+}
+
+// webpSize reads the canvas size from a WebP file's header, which is in
+// the first chunk whichever of the three encodings it uses
+func webpSize(r io.Reader) (width, height int, err error) {
+	var head [30]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return 0, 0, fmt.Errorf("not a WebP image: %v", err)
+	}
+	if string(head[0:4]) != "RIFF" || string(head[8:12]) != "WEBP" {
+		return 0, 0, errors.New("not a WebP image")
+	}
+
+	chunk := head[20:]
+	switch string(head[12:16]) {
+	case "VP8X":
+		// Extended format: 24-bit canvas width and height, minus one
+		width = (int(chunk[4]) | int(chunk[5])<<8 | int(chunk[6])<<16) + 1
+		height = (int(chunk[7]) | int(chunk[8])<<8 | int(chunk[9])<<16) + 1
+	case "VP8 ":
+		// Lossy: a key frame's start code, then 14-bit width and height
+		if chunk[3] != 0x9d || chunk[4] != 0x01 || chunk[5] != 0x2a {
+			return 0, 0, errors.New("malformed WebP image")
+		}
+		width = int(binary.LittleEndian.Uint16(chunk[6:8]) & 0x3fff)
+		height = int(binary.LittleEndian.Uint16(chunk[8:10]) & 0x3fff)
+	case "VP8L":
+		// Lossless: a signature byte, then 14-bit width and height, minus one
+		if chunk[0] != 0x2f {
+			return 0, 0, errors.New("malformed WebP image")
+		}
+		bits := binary.LittleEndian.Uint32(chunk[1:5])
+		width = int(bits&0x3fff) + 1
+		height = int(bits>>14&0x3fff) + 1
+	default:
+		return 0, 0, errors.New("malformed WebP image")
+	}
+	return width, height, nil
+}
+
+// cachedThumbnail returns the thumbnail cached under name
+func (ph *PreviewHandler) cachedThumbnail(name string) ([]byte, bool) {
+	if ph.thumbnailDir == "" {
+		return nil, false
+	}
+	data, err := os.ReadFile(filepath.Join(ph.thumbnailDir, name))
+	return data, err == nil
+}
+
+// storeThumbnail caches a thumbnail, writing it under a temporary name
+// first so a concurrent request never reads half of it
+func (ph *PreviewHandler) storeThumbnail(name string, data []byte) {
+	if ph.thumbnailDir == "" {
+		return
+	}
+	tmp, err := os.CreateTemp(ph.thumbnailDir, ".thumbnail-*")
+	if err != nil {
+		log.Printf("Error caching thumbnail: %v", err)
+		return
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(ph.thumbnailDir, name))
+	}
+	if err != nil {
+		log.Printf("Error caching thumbnail: %v", err)
+		os.Remove(tmp.Name())
+	}
+}
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+// hugePNG is a PNG whose header declares width×height but holds no pixels
+func hugePNG(t *testing.T, width, height uint32) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	// IHDR follows the 8-byte signature: length, type, then the data
+	binary.BigEndian.PutUint32(data[16:20], width)
+	binary.BigEndian.PutUint32(data[20:24], height)
+	binary.BigEndian.PutUint32(data[29:33], crc32.ChecksumIEEE(data[12:29]))
+	return data
+}
+
+func TestPreviewHandler_Thumbnail(t *testing.T) {
+	root := t.TempDir()
+	img := image.NewRGBA(image.Rect(0, 0, 400, 200))
+	for x := 0; x < 400; x++ {
+		for y := 0; y < 200; y++ {
+			img.Set(x, y, color.RGBA{G: uint8(x / 2), A: 255})
+		}
+	}
+	var pngData bytes.Buffer
+	if err := png.Encode(&pngData, img); err != nil {
+		t.Fatalf("Failed to encode PNG: %v", err)
+	}
+	for name, content := range map[string][]byte{
+		"photo.png": pngData.Bytes(),
+		"bomb.png":  hugePNG(t, 100000, 100000),
+		"notes.txt": []byte("notes"),
+		"pic.webp":  []byte("RIFF\x00\x00\x00\x00WEBP"),
+	} {
+		if err := os.WriteFile(filepath.Join(root, name), content, 0644); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+	}
+
+	mgr := storage.NewManager()
+	mgr.Register("local", storage.NewLocalStorage(root))
+	handler := NewPreviewHandler(mgr)
+	cacheDir := filepath.Join(t.TempDir(), "thumbnails")
+	if err := handler.SetThumbnailCache(cacheDir); err != nil {
+		t.Fatal(err)
+	}
+
+	thumbnail := func(query string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler.Thumbnail(rr, httptest.NewRequest("GET", "/api/fs/thumbnail?storage=local&"+query, nil))
+		return rr
+	}
+	cached := func() int {
+		entries, _ := os.ReadDir(cacheDir)
+		return len(entries)
+	}
+
+	t.Run("Scaled to fit", func(t *testing.T) {
+		for query, want := range map[string]image.Point{
+			"width=100&height=100": {100, 50},
+			"width=300&height=60":  {120, 60},
+			"":                     {256, 128},
+			"width=800&height=800": {400, 200},
+		} {
+			rr := thumbnail("path=/photo.png&" + query)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("%s: expected 200, got %d: %s", query, rr.Code, rr.Body.String())
+			}
+			decoded, err := jpeg.Decode(rr.Body)
+			if err != nil {
+				t.Fatalf("%s: failed to decode thumbnail: %v", query, err)
+			}
+			if got := decoded.Bounds().Size(); got != want {
+				t.Errorf("%s: expected %v, got %v", query, want, got)
+			}
+		}
+	})
+
+	t.Run("Cached until the file changes", func(t *testing.T) {
+		before := cached()
+		first := thumbnail("path=/photo.png&width=50&height=50")
+		if cached() != before+1 {
+			t.Fatalf("Expected the thumbnail to be cached on disk")
+		}
+
+		// A cached thumbnail is served as stored, without decoding the file
+		entries, _ := os.ReadDir(cacheDir)
+		for _, entry := range entries {
+			data, _ := os.ReadFile(filepath.Join(cacheDir, entry.Name()))
+			if bytes.Equal(data, first.Body.Bytes()) {
+				os.WriteFile(filepath.Join(cacheDir, entry.Name()), []byte("cached"), 0600)
+			}
+		}
+		if rr := thumbnail("path=/photo.png&width=50&height=50"); rr.Body.String() != "cached" {
+			t.Error("Expected the cached thumbnail to be served")
+		}
+
+		later := time.Now().Add(time.Minute)
+		if err := os.Chtimes(filepath.Join(root, "photo.png"), later, later); err != nil {
+			t.Fatal(err)
+		}
+		if rr := thumbnail("path=/photo.png&width=50&height=50"); rr.Body.String() == "cached" {
+			t.Error("Expected a new thumbnail once the file changed")
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		for query, want := range map[string]int{
+			"path=/notes.txt":              http.StatusUnsupportedMediaType,
+			"path=/bomb.png":               http.StatusRequestEntityTooLarge,
+			"path=/pic.webp":               http.StatusNotImplemented,
+			"path=/photo.png&width=0":      http.StatusBadRequest,
+			"path=/photo.png&height=10000": http.StatusBadRequest,
+			"path=/missing.png":            http.StatusNotFound,
+			"path=/":                       http.StatusBadRequest,
+		} {
+			if rr := thumbnail(query); rr.Code != want {
+				t.Errorf("%s: expected %d, got %d: %s", query, want, rr.Code, rr.Body.String())
+			}
+		}
+	})
+}
+
+func TestWebpSize(t *testing.T) {
+	header := func(chunk string, payload ...byte) []byte {
+		data := append([]byte("RIFF\x00\x00\x00\x00WEBP"+chunk+"\x00\x00\x00\x00"), payload...)
+		return append(data, make([]byte, 30)...)
+	}
+	for name, tc := range map[string]struct {
+		data          []byte
+		width, height int
+	}{
+		// 640x480 key frame
+		"lossy": {header("VP8 ", 0, 0, 0, 0x9d, 0x01, 0x2a, 0x80, 0x02, 0xe0, 0x01), 640, 480},
+		// 100x50, stored minus one
+		"lossless": {header("VP8L", 0x2f, 0x63, 0x40, 0x0c, 0), 100, 50},
+		// 16383x20000, stored minus one in 24 bits
+		"extended": {header("VP8X", 0, 0, 0, 0, 0xfe, 0x3f, 0, 0x1f, 0x4e, 0), 16383, 20000},
+	} {
+		width, height, err := webpSize(bytes.NewReader(tc.data))
+		if err != nil || width != tc.width || height != tc.height {
+			t.Errorf("%s: expected %dx%d, got %dx%d (%v)", name, tc.width, tc.height, width, height, err)
+		}
+	}
+	if _, _, err := webpSize(bytes.NewReader([]byte("not a webp image at all, surely"))); err == nil {
+		t.Error("Expected other data to be rejected")
+	}
+}
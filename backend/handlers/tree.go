@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+// Limits for /fs/tree
+const (
+	DefaultTreeDepth    = 3
+	DefaultMaxTreeDepth = 10
+
+	// maxTreeNodes bounds how many entries one tree holds; a bigger tree
+	// is cut short and marked truncated
+	maxTreeNodes = 10000
+)
+
+// treeWalkLimits keeps a tree from taking forever on a slow backend. Only
+// entries within depth count against it, so an object store walked in one
+// listing can still spend its time on keys far below.
+var treeWalkLimits = storage.WalkLimits{Timeout: 30 * time.Second}
+
+// errTreeFull stops a tree walk once it holds maxTreeNodes entries
+var errTreeFull = errors.New("tree node limit reached")
+
+// SetMaxTreeDepth sets the deepest tree /fs/tree returns. Values below 1
+// restore DefaultMaxTreeDepth.
+func (h *FileHandlers) SetMaxTreeDepth(n int) {
+	if n < 1 {
+		n = DefaultMaxTreeDepth
+	}
+	h.maxTreeDepth = n
+}
+
+// TreeNode is one entry of a directory tree. Expanded marks directories
+// whose contents were read; symlinked directories, which are not followed,
+// and directories at the depth limit are not.
+type TreeNode struct {
+	Name     string      `json:"name"`
+	Path     string      `json:"path"`
+	IsDir    bool        `json:"is_dir"`
+	IsLink   bool        `json:"is_link,omitempty"`
+	Size     int64       `json:"size,omitempty"`
+	ModTime  time.Time   `json:"mod_time"`
+	Expanded bool        `json:"expanded,omitempty"`
+	Children []*TreeNode `json:"children,omitempty"`
+}
+
+// TreeResponse is what /fs/tree returns
+type TreeResponse struct {
+	Root      *TreeNode `json:"root"`
+	Depth     int       `json:"depth"`
+	Nodes     int       `json:"nodes"`
+	Truncated bool      `json:"truncated"`
+	Reason    string    `json:"reason,omitempty"`
+}
+
+// GetTree returns the directories below path, nested, down to depth
+// levels, with files too when include_files is set. The tree is read with
+// one storage.Walk, which is a single recursive listing on backends that
+// have one and follows no symlinked directories. A tree that passes
+// maxTreeNodes entries is returned as far as it got, marked truncated.
+func (h *FileHandlers) GetTree(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	storageID := query.Get("storage")
+	root := query.Get("path")
+	if root == "" {
+		root = "/"
+	}
+	includeFiles := query.Get("include_files") == "true"
+
+	depth := DefaultTreeDepth
+	if value := query.Get("depth"); value != "" {
+		var err error
+		if depth, err = strconv.Atoi(value); err != nil || depth < 1 || depth > h.maxTreeDepth {
+			errorResponse(w, fmt.Sprintf("depth must be between 1 and %d", h.maxTreeDepth), http.StatusBadRequest)
+			return
+		}
+	}
+	exclude, err := queryExcludes(query)
+	if err != nil {
+		errorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	fs, ok := h.storageManager.Get(storageID)
+	if !ok {
+		errorResponse(w, "Storage not found", http.StatusNotFound)
+		return
+	}
+	info, err := fs.Stat(root)
+	if err != nil {
+		storageErrorResponse(w, "Directory not found", err)
+		return
+	}
+	if !info.IsDir {
+		errorResponse(w, "Path is not a directory", http.StatusBadRequest)
+		return
+	}
+
+	resp := TreeResponse{
+		Root:  &TreeNode{Name: info.Name, Path: root, IsDir: true, ModTime: info.ModTime, Expanded: true},
+		Depth: depth,
+	}
+	// Walk reports each directory before its contents, so a parent is
+	// always here by the time its entries arrive
+	dirs := map[string]*TreeNode{path.Clean("/" + root): resp.Root}
+	rootDepth := pathDepth(root)
+
+	ctx := r.Context()
+	err = storage.WalkLimited(fs, root, treeWalkLimits, func(entry storage.FileInfo) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if exclude.Excluded(entry.Name) {
+			return storage.SkipDir
+		}
+		level := pathDepth(entry.Path) - rootDepth
+		if level > depth {
+			return storage.SkipDir
+		}
+		// A symlink to a directory is shown as one, but not followed
+		isDir := entry.IsDir || (entry.IsLink && entry.LinkTargetIsDir)
+		if !isDir && !includeFiles {
+			return nil
+		}
+		parent, ok := dirs[path.Dir(path.Clean("/"+entry.Path))]
+		if !ok {
+			return storage.SkipDir
+		}
+		if resp.Nodes >= maxTreeNodes {
+			return errTreeFull
+		}
+
+		node := &TreeNode{
+			Name:    entry.Name,
+			Path:    entry.Path,
+			IsDir:   isDir,
+			IsLink:  entry.IsLink,
+			ModTime: entry.ModTime,
+		}
+		if !isDir {
+			node.Size = entry.Size
+		}
+		parent.Children = append(parent.Children, node)
+		resp.Nodes++
+
+		if !isDir {
+			return nil
+		}
+		if entry.IsLink || level >= depth {
+			return storage.SkipDir
+		}
+		node.Expanded = true
+		dirs[path.Clean("/"+entry.Path)] = node
+		return nil
+	})
+
+	switch {
+	case err == nil:
+	case err == errTreeFull:
+		resp.Truncated = true
+		resp.Reason = fmt.Sprintf("more than %d entries", maxTreeNodes)
+	case errors.Is(err, storage.ErrWalkTruncated):
+		resp.Truncated = true
+		resp.Reason = err.Error()
+	case ctx.Err() != nil:
+		return
+	default:
+		log.Printf("Error building tree of %s: %v", root, err)
+		storageErrorResponse(w, fmt.Sprintf("Failed to read tree: %v", err), err)
+		return
+	}
+	sortTree(resp.Root)
+	successResponse(w, resp)
+}
+
+// sortTree orders each directory's entries by name, as walks on some
+// backends report them in listing order
+func sortTree(node *TreeNode) {
+	sort.Slice(node.Children, func(i, j int) bool {
+		return node.Children[i].Name < node.Children[j].Name
+	})
+	for _, child := range node.Children {
+		sortTree(child)
+	}
+}
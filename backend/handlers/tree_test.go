@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+// flatten lists a tree's paths, marking expanded directories with a
+// trailing slash
+func flatten(node *TreeNode, out *[]string) {
+	for _, child := range node.Children {
+		name := child.Path
+		if child.Expanded {
+			name += "/"
+		}
+		*out = append(*out, name)
+		flatten(child, out)
+	}
+}
+
+// walkCountingFS is a storage that walks itself, counting the listings
+// made outside its walk
+type walkCountingFS struct {
+	storage.FileSystem
+	lists int
+}
+
+func (w *walkCountingFS) List(path string) ([]storage.FileInfo, error) {
+	w.lists++
+	return w.FileSystem.List(path)
+}
+
+func (w *walkCountingFS) Walk(root string, fn func(storage.FileInfo) error) error {
+	return storage.Walk(w.FileSystem, root, fn)
+}
+
+func TestFileHandlers_GetTree(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"a/b/c/d", "a/e", "node_modules/pkg", "loop"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range []string{"top.txt", "a/in-a.txt", "a/b/c/deep.txt"} {
+		if err := os.WriteFile(filepath.Join(root, file), []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(root, filepath.Join(root, "loop", "back")); err != nil {
+		t.Fatal(err)
+	}
+
+	walker := &walkCountingFS{FileSystem: storage.NewLocalStorage(root)}
+	mgr := storage.NewManager()
+	mgr.Register("local", storage.NewLocalStorage(root))
+	mgr.Register("walker", walker)
+	handler := NewFileHandlers(mgr)
+
+	tree := func(query string) (TreeResponse, *httptest.ResponseRecorder) {
+		rr := httptest.NewRecorder()
+		handler.GetTree(rr, httptest.NewRequest("GET", "/api/fs/tree?exclude=node_modules&"+query, nil))
+		var resp struct {
+			Data TreeResponse `json:"data"`
+		}
+		if rr.Code == http.StatusOK {
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("Invalid response: %v", err)
+			}
+		}
+		return resp.Data, rr
+	}
+	paths := func(resp TreeResponse) string {
+		var out []string
+		flatten(resp.Root, &out)
+		sort.Strings(out)
+		return strings.Join(out, " ")
+	}
+
+	t.Run("Directories to depth", func(t *testing.T) {
+		resp, rr := tree("storage=local&depth=2")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		want := "/a/ /a/b /a/e /loop/ /loop/back"
+		if got := paths(resp); got != want {
+			t.Errorf("Expected %s, got %s", want, got)
+		}
+		if resp.Nodes != 5 || resp.Truncated {
+			t.Errorf("Unexpected summary %+v", resp)
+		}
+		if back := resp.Root.Children[1].Children[0]; !back.IsDir || !back.IsLink {
+			t.Errorf("Expected the symlinked directory as an unfollowed directory, got %+v", back)
+		}
+	})
+
+	t.Run("With files", func(t *testing.T) {
+		resp, _ := tree("storage=local&path=/a&include_files=true")
+		want := "/a/b/ /a/b/c/ /a/b/c/d /a/b/c/deep.txt /a/e/ /a/in-a.txt"
+		if got := paths(resp); got != want {
+			t.Errorf("Expected %s, got %s", want, got)
+		}
+	})
+
+	t.Run("One walk", func(t *testing.T) {
+		resp, _ := tree("storage=walker&depth=3")
+		if walker.lists != 0 {
+			t.Errorf("Expected the backend's own walk, got %d listings", walker.lists)
+		}
+		if !strings.Contains(paths(resp), "/a/b/c") {
+			t.Errorf("Expected /a/b/c in %s", paths(resp))
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		for query, want := range map[string]int{
+			"storage=local&depth=0":         http.StatusBadRequest,
+			"storage=local&depth=11":        http.StatusBadRequest,
+			"storage=local&path=/top.txt":   http.StatusBadRequest,
+			"storage=local&path=/missing":   http.StatusNotFound,
+			"storage=missing":               http.StatusNotFound,
+			"storage=local&depth=something": http.StatusBadRequest,
+		} {
+			if _, rr := tree(query); rr.Code != want {
+				t.Errorf("%s: expected %d, got %d", query, want, rr.Code)
+			}
+		}
+	})
+
+	t.Run("Configured depth", func(t *testing.T) {
+		handler.SetMaxTreeDepth(20)
+		defer handler.SetMaxTreeDepth(0)
+		if _, rr := tree("storage=local&depth=15"); rr.Code != http.StatusOK {
+			t.Errorf("Expected depth 15 to be allowed, got %d", rr.Code)
+		}
+	})
+}
+
+func TestFileHandlers_GetTreeNodeCap(t *testing.T) {
+	root := t.TempDir()
+	for i := 0; i < maxTreeNodes+10; i++ {
+		if err := os.Mkdir(filepath.Join(root, fmt.Sprintf("dir%05d", i)), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mgr := storage.NewManager()
+	mgr.Register("local", storage.NewLocalStorage(root))
+	handler := NewFileHandlers(mgr)
+
+	rr := httptest.NewRecorder()
+	handler.GetTree(rr, httptest.NewRequest("GET", "/api/fs/tree?storage=local&depth=1", nil))
+	var resp struct {
+		Data TreeResponse `json:"data"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Invalid response %d: %v", rr.Code, err)
+	}
+	if !resp.Data.Truncated || resp.Data.Nodes != maxTreeNodes || len(resp.Data.Root.Children) != maxTreeNodes {
+		t.Errorf("Expected a tree cut at %d nodes, got %d (truncated %v)", maxTreeNodes, resp.Data.Nodes, resp.Data.Truncated)
+	}
+}
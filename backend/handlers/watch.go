@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+// maxWatchesPerClient bounds how many paths one connection can watch, so
+// that one client can't use up the host's inotify watches
+const maxWatchesPerClient = 32
+
+// fileEventData is the payload of a MessageTypeFileEvent message
+type fileEventData struct {
+	Storage string `json:"storage"`
+	Watched string `json:"watched"`
+	Type    string `json:"type"`
+	Path    string `json:"path"`
+}
+
+// startWatch begins streaming changes to a directory or file to the
+// client that asked, and no other. A path that is already being watched
+// is left alone.
+func (c *Client) startWatch(message WebSocketMessage) {
+	if c.handler == nil || c.handler.storageManager == nil {
+		c.sendError("Watching is not available")
+		return
+	}
+	fs, ok := c.handler.storageManager.Get(message.Storage)
+	if !ok {
+		c.sendError("Storage not found")
+		return
+	}
+	watcher, ok := storage.As[storage.Watcher](fs)
+	if !ok {
+		c.sendError(fmt.Sprintf("Storage %s does not support watching", message.Storage))
+		return
+	}
+
+	key := tailKey(message.Storage, message.Path)
+	c.watchMu.Lock()
+	defer c.watchMu.Unlock()
+	if c.watches == nil {
+		c.watches = make(map[string]context.CancelFunc)
+	}
+	if _, ok := c.watches[key]; ok {
+		return
+	}
+	if len(c.watches) >= maxWatchesPerClient {
+		c.enqueue(WebSocketMessage{
+			Type:      MessageTypeError,
+			Error:     fmt.Sprintf("Cannot watch more than %d paths at once", maxWatchesPerClient),
+			Data:      map[string]interface{}{"code": CodeRateLimited, "status": http.StatusTooManyRequests},
+			Timestamp: time.Now().Unix(),
+		})
+		return
+	}
+
+	events, stop, err := watcher.Watch(message.Path)
+	if err != nil {
+		c.sendError(fmt.Sprintf("Cannot watch %s: %v", message.Path, err))
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.watches[key] = cancel
+	c.watchWG.Add(1)
+	go func() {
+		defer c.watchWG.Done()
+		defer stop()
+		c.forwardEvents(ctx, events, message.Storage, message.Path)
+	}()
+}
+
+// forwardEvents sends events to the client until ctx is cancelled or the
+// watch ends
+func (c *Client) forwardEvents(ctx context.Context, events <-chan storage.FileEvent, storageID, watched string) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				log.Printf("Watch on %s ended", watched)
+				c.stopWatch(storageID, watched)
+				return
+			}
+			sent := c.enqueue(WebSocketMessage{
+				Type: MessageTypeFileEvent,
+				ID:   tailKey(storageID, watched),
+				Data: fileEventData{
+					Storage: storageID,
+					Watched: watched,
+					Type:    event.Type,
+					Path:    event.Path,
+				},
+				Timestamp: time.Now().Unix(),
+			})
+			if !sent {
+				return
+			}
+		}
+	}
+}
+
+// stopWatch stops watching one path, or every path when path is empty
+func (c *Client) stopWatch(storageID, path string) {
+	c.watchMu.Lock()
+	defer c.watchMu.Unlock()
+	for key, cancel := range c.watches {
+		if path == "" || key == tailKey(storageID, path) {
+			cancel()
+			delete(c.watches, key)
+		}
+	}
+}
+
+// stopAllWatches stops every watch and waits for their watchers to be
+// released
+func (c *Client) stopAllWatches() {
+	c.stopWatch("", "")
+	c.watchWG.Wait()
+}
@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+// readFileEvent returns the next file event sent to conn, skipping other
+// messages, or fails once wait passes
+func readFileEvent(t *testing.T, conn *websocket.Conn, wait time.Duration) (fileEventData, bool) {
+	t.Helper()
+	deadline := time.Now().Add(wait)
+	for {
+		_ = conn.SetReadDeadline(deadline)
+		var message struct {
+			Type  string        `json:"type"`
+			Error string        `json:"error"`
+			Data  fileEventData `json:"data"`
+		}
+		if err := conn.ReadJSON(&message); err != nil {
+			return fileEventData{}, false
+		}
+		switch message.Type {
+		case MessageTypeFileEvent:
+			return message.Data, true
+		case MessageTypeError:
+			t.Fatalf("Unexpected error message: %s", message.Error)
+		}
+	}
+}
+
+func TestWebSocket_Watch(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "inbox"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	mgr := storage.NewManager()
+	mgr.Register("local", storage.NewLocalStorage(root))
+	mgr.Register("polled", &pollingFileSystem{FileSystem: storage.NewLocalStorage(root)})
+
+	wsh := NewWebSocketHandler()
+	defer wsh.Close()
+	wsh.SetStorageManager(mgr)
+	server := httptest.NewServer(http.HandlerFunc(wsh.Handle))
+	defer server.Close()
+
+	dial := func() *websocket.Conn {
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		return conn
+	}
+	watcher, bystander := dial(), dial()
+	defer watcher.Close()
+	defer bystander.Close()
+
+	if err := watcher.WriteJSON(WebSocketMessage{Type: MessageTypeWatch, Storage: "local", Path: "/inbox"}); err != nil {
+		t.Fatal(err)
+	}
+	// Give the watch time to start
+	time.Sleep(100 * time.Millisecond)
+
+	if err := os.WriteFile(filepath.Join(root, "inbox", "new.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	event, ok := readFileEvent(t, watcher, 5*time.Second)
+	if !ok {
+		t.Fatal("Expected a file event")
+	}
+	if event.Type != storage.FileEventCreate || event.Path != "/inbox/new.txt" || event.Watched != "/inbox" || event.Storage != "local" {
+		t.Errorf("Expected the file to be reported created, got %+v", event)
+	}
+
+	if err := os.Remove(filepath.Join(root, "inbox", "new.txt")); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		event, ok := readFileEvent(t, watcher, 5*time.Second)
+		if !ok {
+			t.Fatal("Expected the deletion to be reported")
+		}
+		if event.Type == storage.FileEventDelete {
+			break
+		}
+	}
+
+	if _, ok := readFileEvent(t, bystander, 200*time.Millisecond); ok {
+		t.Error("Expected events to reach only the watching client")
+	}
+
+	t.Run("Unsupported storage", func(t *testing.T) {
+		conn := dial()
+		defer conn.Close()
+		if err := conn.WriteJSON(WebSocketMessage{Type: MessageTypeWatch, Storage: "polled", Path: "/inbox"}); err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		for {
+			var message WebSocketMessage
+			if err := conn.ReadJSON(&message); err != nil {
+				t.Fatalf("Expected an error message: %v", err)
+			}
+			if message.Type == MessageTypeError {
+				break
+			}
+		}
+	})
+
+	t.Run("Too many watches", func(t *testing.T) {
+		conn := dial()
+		defer conn.Close()
+		for i := 0; i <= maxWatchesPerClient; i++ {
+			dir := filepath.Join(root, "many", strconv.Itoa(i))
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := conn.WriteJSON(WebSocketMessage{Type: MessageTypeWatch, Storage: "local", Path: "/many/" + strconv.Itoa(i)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		for {
+			var message struct {
+				Type  string `json:"type"`
+				Error string `json:"error"`
+				Data  struct {
+					Status int `json:"status"`
+				} `json:"data"`
+			}
+			if err := conn.ReadJSON(&message); err != nil {
+				t.Fatalf("Expected an error message: %v", err)
+			}
+			if message.Type == MessageTypeError {
+				if message.Data.Status != http.StatusTooManyRequests {
+					t.Errorf("Expected a 429 error, got %q %d", message.Error, message.Data.Status)
+				}
+				break
+			}
+		}
+	})
+
+	t.Run("Stopped on disconnect", func(t *testing.T) {
+		conn := dial()
+		if err := conn.WriteJSON(WebSocketMessage{Type: MessageTypeWatch, Storage: "local", Path: "/inbox"}); err != nil {
+			t.Fatal(err)
+		}
+		var client *Client
+		deadline := time.Now().Add(5 * time.Second)
+		for client == nil && time.Now().Before(deadline) {
+			wsh.hub.mu.RLock()
+			for c := range wsh.hub.clients {
+				c.watchMu.Lock()
+				if len(c.watches) > 0 && c.conn.RemoteAddr().String() == conn.LocalAddr().String() {
+					client = c
+				}
+				c.watchMu.Unlock()
+			}
+			wsh.hub.mu.RUnlock()
+			time.Sleep(10 * time.Millisecond)
+		}
+		if client == nil {
+			t.Fatal("Expected the watch to be registered")
+		}
+
+		conn.Close()
+		deadline = time.Now().Add(5 * time.Second)
+		for {
+			client.watchMu.Lock()
+			n := len(client.watches)
+			client.watchMu.Unlock()
+			if n == 0 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("Expected the watch to stop when the client disconnected")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+}
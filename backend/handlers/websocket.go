@@ -1,6 +1,11 @@
 package handlers
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
@@ -8,6 +13,7 @@ import (
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/jacommander/jacommander/backend/storage"
 )
 
 var upgrader = websocket.Upgrader{
@@ -34,6 +40,9 @@ var upgrader = websocket.Upgrader{
 	WriteBufferSize: 1024,
 }
 
+// writeWait is how long a write to a client may take
+const writeWait = 10 * time.Second
+
 // Message types for WebSocket communication
 const (
 	MessageTypeProgress     = "progress"
@@ -42,6 +51,12 @@ const (
 	MessageTypeError        = "error"
 	MessageTypePing         = "ping"
 	MessageTypePong         = "pong"
+	MessageTypeTail         = "tail"
+	MessageTypeTailStop     = "tail-stop"
+	MessageTypeLog          = "log"
+	MessageTypeWatch        = "watch"
+	MessageTypeWatchStop    = "watch-stop"
+	MessageTypeFileEvent    = "file-event"
 )
 
 // WebSocketMessage represents a message sent via WebSocket
@@ -52,6 +67,11 @@ type WebSocketMessage struct {
 	Data      interface{} `json:"data,omitempty"`
 	Error     string      `json:"error,omitempty"`
 	Timestamp int64       `json:"timestamp"`
+
+	// Storage and Path name the file of a tail request, or what a watch
+	// request is for
+	Storage string `json:"storage,omitempty"`
+	Path    string `json:"path,omitempty"`
 }
 
 // ProgressData represents progress information
@@ -69,12 +89,38 @@ type ProgressData struct {
 
 // Client represents a connected WebSocket client
 type Client struct {
-	conn *websocket.Conn
-	send chan WebSocketMessage
-	hub  *Hub
-	id   string
+	conn    *websocket.Conn
+	send    chan WebSocketMessage
+	hub     *Hub
+	handler *WebSocketHandler
+	id      string
+
+	// tails holds the cancel function of each file being tailed
+	tailMu sync.Mutex
+	tails  map[string]context.CancelFunc
+	tailWG sync.WaitGroup
+
+	// watches holds the cancel function of each path being watched
+	watchMu sync.Mutex
+	watches map[string]context.CancelFunc
+	watchWG sync.WaitGroup
+
+	// queueMu guards closing send and the backlog of messages waiting for
+	// room in it
+	queueMu      sync.Mutex
+	closed       bool
+	backlog      []queuedMessage
+	progress     map[string]WebSocketMessage // latest progress per queued operation
+	stalledSince time.Time
+	kicked       chan struct{} // closed when the client is to be disconnected
+	kickCode     int
+	kickReason   string
 }
 
+// DefaultProgressInterval is how often the hub sends the progress it has
+// gathered, unless SetProgressInterval changes it
+const DefaultProgressInterval = 100 * time.Millisecond
+
 // Hub maintains the set of active clients
 type Hub struct {
 	clients    map[*Client]bool
@@ -82,20 +128,36 @@ type Hub struct {
 	register   chan *Client
 	unregister chan *Client
 	mu         sync.RWMutex
+
+	// progressInterval carries new flush intervals to run
+	progressInterval chan time.Duration
+
+	// done is closed to stop run, which closes stopped once it has
+	// disconnected every client. pumps counts the clients' write pumps
+	// still running.
+	done      chan struct{}
+	closeOnce sync.Once
+	stopped   chan struct{}
+	pumps     sync.WaitGroup
 }
 
 // WebSocketHandler handles WebSocket connections
 type WebSocketHandler struct {
-	hub *Hub
+	hub            *Hub
+	storageManager *storage.Manager
+	operations     *OperationRegistry
 }
 
 // NewWebSocketHandler creates a new WebSocket handler
 func NewWebSocketHandler() *WebSocketHandler {
 	hub := &Hub{
-		clients:    make(map[*Client]bool),
-		broadcast:  make(chan WebSocketMessage),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
+		clients:          make(map[*Client]bool),
+		broadcast:        make(chan WebSocketMessage),
+		register:         make(chan *Client),
+		unregister:       make(chan *Client),
+		progressInterval: make(chan time.Duration),
+		done:             make(chan struct{}),
+		stopped:          make(chan struct{}),
 	}
 
 	// Start the hub
@@ -106,6 +168,59 @@ func NewWebSocketHandler() *WebSocketHandler {
 	}
 }
 
+// SetStorageManager gives clients access to storages, which tailing
+// and watching files need
+func (wsh *WebSocketHandler) SetStorageManager(manager *storage.Manager) {
+	wsh.storageManager = manager
+}
+
+// SetOperationRegistry lets clients cancel background operations
+func (wsh *WebSocketHandler) SetOperationRegistry(operations *OperationRegistry) {
+	wsh.operations = operations
+}
+
+// SetProgressInterval sets how often progress is sent. Updates arriving
+// in between are merged so only the latest per operation goes out; zero
+// sends every update as it comes. Progress held back so far is sent right
+// away.
+func (wsh *WebSocketHandler) SetProgressInterval(interval time.Duration) {
+	select {
+	case wsh.hub.progressInterval <- interval:
+	case <-wsh.hub.done:
+	}
+}
+
+// Shutdown stops the hub and disconnects every client with a going-away
+// close frame, waiting until their connections are closed or ctx ends.
+// Messages sent after it are dropped and new connections are refused.
+func (wsh *WebSocketHandler) Shutdown(ctx context.Context) error {
+	h := wsh.hub
+	h.closeOnce.Do(func() { close(h.done) })
+
+	select {
+	case <-h.stopped:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	pumpsDone := make(chan struct{})
+	go func() {
+		h.pumps.Wait()
+		close(pumpsDone)
+	}()
+	select {
+	case <-pumpsDone:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close is Shutdown without a deadline
+func (wsh *WebSocketHandler) Close() error {
+	return wsh.Shutdown(context.Background())
+}
+
 // Handle handles WebSocket connections
 func (wsh *WebSocketHandler) Handle(w http.ResponseWriter, r *http.Request) {
 	conn, err := upgrader.Upgrade(w, r, nil)
@@ -115,25 +230,35 @@ func (wsh *WebSocketHandler) Handle(w http.ResponseWriter, r *http.Request) {
 	}
 
 	client := &Client{
-		conn: conn,
-		send: make(chan WebSocketMessage, 256),
-		hub:  wsh.hub,
-		id:   generateClientID(),
+		conn:    conn,
+		send:    make(chan WebSocketMessage, 256),
+		hub:     wsh.hub,
+		handler: wsh,
+		id:      generateClientID(),
+		kicked:  make(chan struct{}),
 	}
 
 	// Register the client
-	client.hub.register <- client
+	select {
+	case client.hub.register <- client:
+	case <-client.hub.done:
+		_ = conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"),
+			time.Now().Add(writeWait))
+		_ = conn.Close()
+		return
+	}
 
 	// Start goroutines for reading and writing
 	go client.writePump()
 	go client.readPump()
 
 	// Send initial connection success message
-	client.send <- WebSocketMessage{
+	client.enqueue(WebSocketMessage{
 		Type:      MessageTypeNotification,
 		Data:      map[string]string{"message": "Connected to JaCommander WebSocket"},
 		Timestamp: time.Now().Unix(),
-	}
+	})
 }
 
 // SendProgress sends progress update to all connected clients
@@ -143,17 +268,27 @@ func (wsh *WebSocketHandler) SendProgress(progress ProgressData) {
 		Data:      progress,
 		Timestamp: time.Now().Unix(),
 	}
-	wsh.hub.broadcast <- message
+	wsh.hub.publish(message)
 }
 
 // SendNotification sends a notification to all connected clients
 func (wsh *WebSocketHandler) SendNotification(notification string) {
+	wsh.SendNotificationWithData(notification, nil)
+}
+
+// SendNotificationWithData sends a notification carrying extra fields
+// next to the message
+func (wsh *WebSocketHandler) SendNotificationWithData(notification string, data map[string]string) {
+	payload := map[string]string{"message": notification}
+	for key, value := range data {
+		payload[key] = value
+	}
 	message := WebSocketMessage{
 		Type:      MessageTypeNotification,
-		Data:      map[string]string{"message": notification},
+		Data:      payload,
 		Timestamp: time.Now().Unix(),
 	}
-	wsh.hub.broadcast <- message
+	wsh.hub.publish(message)
 }
 
 // SendError sends an error message to all connected clients
@@ -163,14 +298,41 @@ func (wsh *WebSocketHandler) SendError(err string) {
 		Error:     err,
 		Timestamp: time.Now().Unix(),
 	}
-	wsh.hub.broadcast <- message
+	wsh.hub.publish(message)
+}
+
+// publish hands a message to run, dropping it once the hub is shut down
+func (h *Hub) publish(message WebSocketMessage) {
+	select {
+	case h.broadcast <- message:
+	case <-h.done:
+	}
 }
 
 // run starts the hub's main event loop
 func (h *Hub) run() {
+	defer close(h.stopped)
+	interval := DefaultProgressInterval
+
+	// Progress waiting for the next flush, latest per operation, and the
+	// order the operations first reported in
+	pending := make(map[string]WebSocketMessage)
+	var order []string
+	var flushTimer <-chan time.Time
+
+	flush := func() {
+		for _, id := range order {
+			h.send(pending[id])
+			delete(pending, id)
+		}
+		order = order[:0]
+		flushTimer = nil
+	}
+
 	for {
 		select {
 		case client := <-h.register:
+			h.pumps.Add(1)
 			h.mu.Lock()
 			h.clients[client] = true
 			h.mu.Unlock()
@@ -180,7 +342,7 @@ func (h *Hub) run() {
 			h.mu.Lock()
 			if _, ok := h.clients[client]; ok {
 				delete(h.clients, client)
-				close(client.send)
+				client.close()
 				h.mu.Unlock()
 				log.Printf("Client disconnected: %s", client.id)
 			} else {
@@ -188,25 +350,59 @@ func (h *Hub) run() {
 			}
 
 		case message := <-h.broadcast:
-			h.mu.RLock()
-			for client := range h.clients {
-				select {
-				case client.send <- message:
-				default:
-					// Client's send channel is full, close it
-					close(client.send)
-					delete(h.clients, client)
+			if id := progressOperationID(message); id != "" && interval > 0 {
+				if _, ok := pending[id]; !ok {
+					order = append(order, id)
+				}
+				pending[id] = message
+				if flushTimer == nil {
+					flushTimer = time.After(interval)
 				}
+				continue
+			}
+			// Anything else may report the end of an operation, so its
+			// progress goes out first
+			flush()
+			h.send(message)
+
+		case <-flushTimer:
+			flush()
+
+		case interval = <-h.progressInterval:
+			flush()
+
+		case <-h.done:
+			flush()
+			h.mu.Lock()
+			for client := range h.clients {
+				client.kick(websocket.CloseGoingAway, "server shutting down")
+				delete(h.clients, client)
 			}
-			h.mu.RUnlock()
+			h.mu.Unlock()
+			return
 		}
 	}
 }
 
+// send hands a message to every client. Slow clients queue what doesn't
+// fit; they are dropped by their own writePump, not here.
+func (h *Hub) send(message WebSocketMessage) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for client := range h.clients {
+		client.enqueue(message)
+	}
+}
+
 // readPump pumps messages from the websocket connection to the hub
 func (c *Client) readPump() {
 	defer func() {
-		c.hub.unregister <- c
+		c.stopAllTails()
+		c.stopAllWatches()
+		select {
+		case c.hub.unregister <- c:
+		case <-c.hub.done:
+		}
 		if err := c.conn.Close(); err != nil {
 			log.Printf("Error closing connection: %v", err)
 		}
@@ -233,15 +429,27 @@ func (c *Client) readPump() {
 		switch message.Type {
 		case MessageTypePing:
 			// Respond with pong
-			c.send <- WebSocketMessage{
+			c.enqueue(WebSocketMessage{
 				Type:      MessageTypePong,
 				Timestamp: time.Now().Unix(),
-			}
+			})
 
 		case MessageTypeOperation:
 			// Handle operation requests (e.g., cancel operation)
 			c.handleOperation(message)
 
+		case MessageTypeTail:
+			c.startTail(message)
+
+		case MessageTypeTailStop:
+			c.stopTail(message.Storage, message.Path)
+
+		case MessageTypeWatch:
+			c.startWatch(message)
+
+		case MessageTypeWatchStop:
+			c.stopWatch(message.Storage, message.Path)
+
 		default:
 			log.Printf("Unknown message type from client %s: %s", c.id, message.Type)
 		}
@@ -252,6 +460,7 @@ func (c *Client) readPump() {
 func (c *Client) writePump() {
 	ticker := time.NewTicker(54 * time.Second)
 	defer func() {
+		c.hub.pumps.Done()
 		ticker.Stop()
 		if err := c.conn.Close(); err != nil {
 			log.Printf("Error closing connection: %v", err)
@@ -260,8 +469,12 @@ func (c *Client) writePump() {
 
 	for {
 		select {
+		case <-c.kicked:
+			c.writeKick()
+			return
+
 		case message, ok := <-c.send:
-			_ = c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			_ = c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if !ok {
 				// The hub closed the channel
 				_ = c.conn.WriteMessage(websocket.CloseMessage, []byte{})
@@ -271,9 +484,10 @@ func (c *Client) writePump() {
 			if err := c.conn.WriteJSON(message); err != nil {
 				return
 			}
+			c.refill()
 
 		case <-ticker.C:
-			_ = c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			_ = c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
 				return
 			}
@@ -281,49 +495,69 @@ func (c *Client) writePump() {
 	}
 }
 
+// sendError sends an error message to this client only
+func (c *Client) sendError(err string) {
+	c.enqueue(WebSocketMessage{
+		Type:      MessageTypeError,
+		Error:     err,
+		Timestamp: time.Now().Unix(),
+	})
+}
+
 // handleOperation handles operation requests from the client
 func (c *Client) handleOperation(message WebSocketMessage) {
 	switch message.Operation {
 	case "cancel":
-		// Handle cancel operation
-		if id, ok := message.Data.(map[string]interface{})["operation_id"].(string); ok {
-			// TODO: Implement operation cancellation
-			log.Printf("Client %s requested to cancel operation: %s", c.id, id)
+		// The operation notices at its next read or write and reports
+		// itself cancelled through its progress tracker
+		data, _ := message.Data.(map[string]interface{})
+		id, _ := data["operation_id"].(string)
+		if id == "" {
+			c.sendError("Cancel needs an operation_id")
+			return
+		}
+		if c.handler == nil || c.handler.operations == nil || !c.handler.operations.Cancel(id) {
+			c.sendError(fmt.Sprintf("Operation not found: %s", id))
+			return
 		}
+		log.Printf("Client %s cancelled operation %s", c.id, id)
 
 	default:
 		log.Printf("Unknown operation from client %s: %s", c.id, message.Operation)
 	}
 }
 
-// generateClientID generates a unique client ID
+// generateClientID generates a unique client ID. IDs key the hub's client
+// map, so the random part is long enough that clients connecting in the
+// same second never collide.
 func generateClientID() string {
-	return time.Now().Format("20060102150405") + "-" + randomString(8)
+	return time.Now().Format("20060102150405") + "-" + randomString(16)
 }
 
-// randomString generates a random string of given length
+// randomString generates a random hex string of given length
 func randomString(length int) string {
-	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	b := make([]byte, length)
-	for i := range b {
-		b[i] = charset[time.Now().UnixNano()%int64(len(charset))]
-	}
-	return string(b)
+	b := make([]byte, (length+1)/2)
+	// crypto/rand.Read never fails; it crashes the program instead
+	rand.Read(b)
+	return hex.EncodeToString(b)[:length]
 }
 
 // ProgressTracker helps track and report progress for operations
 type ProgressTracker struct {
 	operationID string
 	operation   string
+	file        string
 	total       int64
 	current     int64
 	startTime   time.Time
 	lastUpdate  time.Time
 	handler     *WebSocketHandler
+	op          *Operation
 	mu          sync.Mutex
 }
 
-// NewProgressTracker creates a new progress tracker
+// NewProgressTracker creates a new progress tracker. The handler may be nil,
+// in which case progress is only recorded on the attached operation.
 func NewProgressTracker(handler *WebSocketHandler, operationID, operation string, total int64) *ProgressTracker {
 	return &ProgressTracker{
 		operationID: operationID,
@@ -335,12 +569,40 @@ func NewProgressTracker(handler *WebSocketHandler, operationID, operation string
 	}
 }
 
+// SetOperation attaches a registered operation whose progress is kept in sync
+func (pt *ProgressTracker) SetOperation(op *Operation) {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	pt.op = op
+}
+
+// SetTotal sets the total once it is known, for operations that only learn
+// it after the tracker is created
+func (pt *ProgressTracker) SetTotal(total int64) {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	pt.total = total
+}
+
+// SetFile names the file the progress is about
+func (pt *ProgressTracker) SetFile(file string) {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	pt.file = file
+}
+
 // Update updates the progress and sends an update if needed
 func (pt *ProgressTracker) Update(current int64) {
 	pt.mu.Lock()
 	defer pt.mu.Unlock()
 
 	pt.current = current
+	if pt.op != nil {
+		pt.op.SetProgress(current, pt.total)
+	}
+	if pt.handler == nil {
+		return
+	}
 
 	// Only send updates every 100ms to avoid flooding
 	if time.Since(pt.lastUpdate) < 100*time.Millisecond && current < pt.total {
@@ -377,6 +639,7 @@ func (pt *ProgressTracker) Update(current int64) {
 		Percentage:  percentage,
 		Speed:       speed,
 		Remaining:   remaining,
+		File:        pt.file,
 		Status:      "running",
 	}
 
@@ -398,15 +661,50 @@ func (pt *ProgressTracker) Error(err error) {
 	pt.mu.Lock()
 	defer pt.mu.Unlock()
 
-	progress := ProgressData{
+	if pt.handler == nil {
+		return
+	}
+
+	pt.handler.SendProgress(pt.snapshot("error"))
+	pt.handler.SendError(err.Error())
+}
+
+// Cancelled marks the operation as cancelled
+func (pt *ProgressTracker) Cancelled() {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	if pt.handler == nil {
+		return
+	}
+
+	pt.handler.SendProgress(pt.snapshot("cancelled"))
+}
+
+// Fail reports err as a cancellation if the operation's context was
+// cancelled, and as an error otherwise
+func (pt *ProgressTracker) Fail(err error) {
+	if errors.Is(err, context.Canceled) {
+		pt.Cancelled()
+		return
+	}
+	pt.Error(err)
+}
+
+// snapshot builds a progress message with the given status. pt.mu must be held.
+func (pt *ProgressTracker) snapshot(status string) ProgressData {
+	percentage := float64(0)
+	if pt.total > 0 {
+		percentage = float64(pt.current) / float64(pt.total) * 100
+	}
+
+	return ProgressData{
 		OperationID: pt.operationID,
 		Operation:   pt.operation,
 		Current:     pt.current,
 		Total:       pt.total,
-		Percentage:  float64(pt.current) / float64(pt.total) * 100,
-		Status:      "error",
+		Percentage:  percentage,
+		File:        pt.file,
+		Status:      status,
 	}
-
-	pt.handler.SendProgress(progress)
-	pt.handler.SendError(err.Error())
 }
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// maxClientBacklog is how many messages may wait for a client beyond its
+// send buffer before it is disconnected
+var maxClientBacklog = 1024
+
+// slowClientTimeout is how long a client may go without taking a message
+// from its backlog before it is disconnected
+var slowClientTimeout = 30 * time.Second
+
+// queuedMessage is a message waiting for room in a client's send buffer.
+// Progress messages only hold their operation ID; the latest progress of
+// that operation is sent in their place.
+type queuedMessage struct {
+	message     WebSocketMessage
+	operationID string
+}
+
+// progressOperationID returns the operation a progress message reports
+// on, or "" for other messages
+func progressOperationID(message WebSocketMessage) string {
+	if message.Type != MessageTypeProgress {
+		return ""
+	}
+	if progress, ok := message.Data.(ProgressData); ok {
+		return progress.OperationID
+	}
+	return ""
+}
+
+// enqueue hands a message to the client without blocking. Once the send
+// buffer is full, messages wait in a backlog where progress for the same
+// operation is merged, keeping only the latest. A client whose backlog
+// overflows or stalls is disconnected. enqueue returns false once the
+// client is closed.
+func (c *Client) enqueue(message WebSocketMessage) bool {
+	c.queueMu.Lock()
+	defer c.queueMu.Unlock()
+
+	if c.closed {
+		return false
+	}
+	if len(c.backlog) == 0 {
+		select {
+		case c.send <- message:
+			return true
+		default:
+		}
+		c.stalledSince = time.Now()
+	}
+
+	id := progressOperationID(message)
+	if id != "" {
+		if c.progress == nil {
+			c.progress = make(map[string]WebSocketMessage)
+		}
+		_, queued := c.progress[id]
+		c.progress[id] = message
+		if queued {
+			return true
+		}
+	}
+	c.backlog = append(c.backlog, queuedMessage{message: message, operationID: id})
+
+	if len(c.backlog) > maxClientBacklog || time.Since(c.stalledSince) > slowClientTimeout {
+		c.kickLocked(websocket.ClosePolicyViolation, "client too slow to keep up")
+	}
+	return true
+}
+
+// refill moves messages from the backlog into the send buffer as far as
+// there is room
+func (c *Client) refill() {
+	c.queueMu.Lock()
+	defer c.queueMu.Unlock()
+
+	for len(c.backlog) > 0 && !c.closed {
+		next := c.backlog[0]
+		message := next.message
+		if next.operationID != "" {
+			message = c.progress[next.operationID]
+		}
+		select {
+		case c.send <- message:
+		default:
+			return
+		}
+		if next.operationID != "" {
+			delete(c.progress, next.operationID)
+		}
+		c.backlog[0] = queuedMessage{}
+		c.backlog = c.backlog[1:]
+		c.stalledSince = time.Now()
+	}
+}
+
+// kick asks writePump to close the connection with a close frame carrying
+// code and reason
+func (c *Client) kick(code int, reason string) {
+	c.queueMu.Lock()
+	defer c.queueMu.Unlock()
+	c.kickLocked(code, reason)
+}
+
+// kickLocked is kick with queueMu held
+func (c *Client) kickLocked(code int, reason string) {
+	if c.kickReason != "" {
+		return
+	}
+	c.kickCode = code
+	c.kickReason = reason
+	close(c.kicked)
+}
+
+// writeKick sends the close frame for a kicked client
+func (c *Client) writeKick() {
+	c.queueMu.Lock()
+	code, reason := c.kickCode, c.kickReason
+	c.queueMu.Unlock()
+
+	log.Printf("Disconnecting client %s: %s", c.id, reason)
+	_ = c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	_ = c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason))
+}
+
+// close closes the send buffer. Later messages are dropped instead of
+// being sent on the closed channel.
+func (c *Client) close() {
+	c.queueMu.Lock()
+	defer c.queueMu.Unlock()
+
+	if c.closed {
+		return
+	}
+	c.closed = true
+	c.backlog = nil
+	c.progress = nil
+	close(c.send)
+}
@@ -0,0 +1,453 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+// newQueueClient returns a client with a small send buffer and no
+// connection, for exercising the backlog directly
+func newQueueClient(buffer int) *Client {
+	return &Client{send: make(chan WebSocketMessage, buffer), kicked: make(chan struct{})}
+}
+
+func progressMessage(operationID string, current int64) WebSocketMessage {
+	return WebSocketMessage{
+		Type: MessageTypeProgress,
+		Data: ProgressData{OperationID: operationID, Current: current, Total: 100, Status: "running"},
+	}
+}
+
+func noteMessage(text string) WebSocketMessage {
+	return WebSocketMessage{Type: MessageTypeNotification, Data: map[string]string{"message": text}}
+}
+
+// describe summarises a message for comparing delivery order
+func describe(message WebSocketMessage) string {
+	switch data := message.Data.(type) {
+	case ProgressData:
+		return fmt.Sprintf("%s=%d", data.OperationID, data.Current)
+	case map[string]string:
+		return data["message"]
+	}
+	return message.Type
+}
+
+func TestClient_Backlog(t *testing.T) {
+	t.Run("Progress is merged while the client is behind", func(t *testing.T) {
+		c := newQueueClient(2)
+		c.enqueue(noteMessage("first"))
+		c.enqueue(noteMessage("second"))
+		for i := int64(1); i <= 100; i++ {
+			c.enqueue(progressMessage("copy", i))
+			if i <= 50 {
+				c.enqueue(progressMessage("move", i))
+			}
+		}
+		c.enqueue(noteMessage("done"))
+
+		var got []string
+		for len(got) < 5 {
+			select {
+			case message := <-c.send:
+				got = append(got, describe(message))
+				c.refill()
+			default:
+				t.Fatalf("Expected 5 messages, got %v", got)
+			}
+		}
+		if want := "first,second,copy=100,move=50,done"; strings.Join(got, ",") != want {
+			t.Errorf("Expected %s, got %s", want, strings.Join(got, ","))
+		}
+		if len(c.send) != 0 || len(c.backlog) != 0 {
+			t.Errorf("Expected everything delivered, %d buffered and %d queued", len(c.send), len(c.backlog))
+		}
+		select {
+		case <-c.kicked:
+			t.Error("Expected the client to be kept")
+		default:
+		}
+	})
+
+	t.Run("Overflowing backlog disconnects", func(t *testing.T) {
+		defer func(n int) { maxClientBacklog = n }(maxClientBacklog)
+		maxClientBacklog = 3
+
+		c := newQueueClient(1)
+		for i := 0; i < 5; i++ {
+			c.enqueue(noteMessage(fmt.Sprint(i)))
+		}
+		select {
+		case <-c.kicked:
+		default:
+			t.Fatal("Expected the client to be disconnected")
+		}
+		if c.kickReason == "" {
+			t.Error("Expected a close reason")
+		}
+	})
+
+	t.Run("Stalled backlog disconnects", func(t *testing.T) {
+		defer func(d time.Duration) { slowClientTimeout = d }(slowClientTimeout)
+		slowClientTimeout = 10 * time.Millisecond
+
+		c := newQueueClient(1)
+		c.enqueue(noteMessage("fills the buffer"))
+		c.enqueue(noteMessage("waits"))
+		time.Sleep(20 * time.Millisecond)
+		c.enqueue(noteMessage("still waiting"))
+		select {
+		case <-c.kicked:
+		default:
+			t.Fatal("Expected a stalled client to be disconnected")
+		}
+	})
+
+	t.Run("Sending after close", func(t *testing.T) {
+		c := newQueueClient(1)
+		c.close()
+		if c.enqueue(noteMessage("late")) {
+			t.Error("Expected enqueue to report the client closed")
+		}
+		c.close()
+	})
+}
+
+// TestWebSocket_SlowClient floods a client that isn't reading with
+// progress from concurrent operations. It should stay connected and end up
+// with the final progress of every operation. Run with -race.
+func TestWebSocket_SlowClient(t *testing.T) {
+	wsh := NewWebSocketHandler()
+	// Send every update so the client's own backlog does the merging
+	wsh.SetProgressInterval(0)
+	server := httptest.NewServer(http.HandlerFunc(wsh.Handle))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	const operations, updates = 4, 3000
+	var wg sync.WaitGroup
+	for op := 0; op < operations; op++ {
+		wg.Add(1)
+		go func(op int) {
+			defer wg.Done()
+			id := fmt.Sprintf("op-%d", op)
+			for i := int64(1); i <= updates; i++ {
+				wsh.SendProgress(ProgressData{
+					OperationID: id,
+					Current:     i,
+					Total:       updates,
+					File:        strings.Repeat("x", 200),
+					Status:      "running",
+				})
+			}
+		}(op)
+	}
+	wg.Wait()
+
+	latest := make(map[string]int64)
+	received := 0
+	deadline := time.Now().Add(10 * time.Second)
+	for len(latest) < operations || !allAt(latest, updates) {
+		_ = conn.SetReadDeadline(deadline)
+		var message struct {
+			Type string       `json:"type"`
+			Data ProgressData `json:"data"`
+		}
+		if err := conn.ReadJSON(&message); err != nil {
+			t.Fatalf("Connection ended before all progress arrived (%v): %v", latest, err)
+		}
+		if message.Type != MessageTypeProgress {
+			continue
+		}
+		received++
+		if message.Data.Current < latest[message.Data.OperationID] {
+			t.Fatalf("Progress for %s went backwards", message.Data.OperationID)
+		}
+		latest[message.Data.OperationID] = message.Data.Current
+	}
+	t.Logf("Received %d of %d progress messages", received, operations*updates)
+}
+
+func allAt(latest map[string]int64, want int64) bool {
+	for _, current := range latest {
+		if current != want {
+			return false
+		}
+	}
+	return true
+}
+
+func TestWebSocket_ProgressCoalescing(t *testing.T) {
+	wsh := NewWebSocketHandler()
+	server := httptest.NewServer(http.HandlerFunc(wsh.Handle))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	type received struct {
+		Type string                 `json:"type"`
+		Data map[string]interface{} `json:"data"`
+	}
+	read := func() received {
+		t.Helper()
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var message received
+		if err := conn.ReadJSON(&message); err != nil {
+			t.Fatalf("Failed to read: %v", err)
+		}
+		return message
+	}
+	read() // the greeting, so the client is registered
+
+	t.Run("Latest per operation", func(t *testing.T) {
+		// Hold everything back until the notification below
+		wsh.SetProgressInterval(time.Hour)
+		defer wsh.SetProgressInterval(DefaultProgressInterval)
+
+		var wg sync.WaitGroup
+		for op := 0; op < 3; op++ {
+			wg.Add(1)
+			go func(op int) {
+				defer wg.Done()
+				for i := int64(1); i <= 500; i++ {
+					wsh.SendProgress(ProgressData{OperationID: fmt.Sprintf("op-%d", op), Current: i, Total: 500, Status: "running"})
+				}
+			}(op)
+		}
+		wg.Wait()
+		wsh.SendNotification("batch done")
+
+		seen := make(map[string]bool)
+		for i := 0; i < 3; i++ {
+			message := read()
+			if message.Type != MessageTypeProgress {
+				t.Fatalf("Expected progress before the notification, got %s", message.Type)
+			}
+			id := message.Data["operation_id"].(string)
+			if seen[id] {
+				t.Errorf("Expected one progress message for %s", id)
+			}
+			seen[id] = true
+			if message.Data["current"] != float64(500) {
+				t.Errorf("Expected the latest progress of %s, got %v", id, message.Data["current"])
+			}
+		}
+		if message := read(); message.Type != MessageTypeNotification {
+			t.Errorf("Expected the notification after the progress, got %s", message.Type)
+		}
+	})
+
+	t.Run("Flushed on the interval", func(t *testing.T) {
+		wsh.SetProgressInterval(20 * time.Millisecond)
+		defer wsh.SetProgressInterval(DefaultProgressInterval)
+
+		for i := int64(1); i <= 10; i++ {
+			wsh.SendProgress(ProgressData{OperationID: "tick", Current: i, Total: 10, Status: "running"})
+		}
+		message := read()
+		if message.Type != MessageTypeProgress || message.Data["current"] != float64(10) {
+			t.Errorf("Expected the latest progress after the interval, got %+v", message)
+		}
+	})
+}
+
+func TestWebSocket_CancelOperation(t *testing.T) {
+	fs := &endlessFileSystem{newMockFileSystem()}
+	fs.files["/big.bin"] = []byte("placeholder")
+
+	operations := NewOperationRegistry()
+	wsh := NewWebSocketHandler()
+	wsh.SetOperationRegistry(operations)
+	ch := NewCompressionHandler(storage.NewManager())
+	ch.SetWebSocketHandler(wsh)
+	ch.SetOperationRegistry(operations)
+
+	server := httptest.NewServer(http.HandlerFunc(wsh.Handle))
+	defer server.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	// waitFor reads messages until one satisfies match
+	waitFor := func(what string, match func(message WebSocketMessage, progress ProgressData) bool) {
+		t.Helper()
+		_ = conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+		for {
+			var raw struct {
+				WebSocketMessage
+				Data json.RawMessage `json:"data"`
+			}
+			if err := conn.ReadJSON(&raw); err != nil {
+				t.Fatalf("Never got %s: %v", what, err)
+			}
+			var progress ProgressData
+			if raw.Type == MessageTypeProgress {
+				_ = json.Unmarshal(raw.Data, &progress)
+			}
+			if match(raw.WebSocketMessage, progress) {
+				return
+			}
+		}
+	}
+	waitFor("the welcome message", func(m WebSocketMessage, _ ProgressData) bool {
+		return m.Type == MessageTypeNotification
+	})
+
+	cancel := func(id string) {
+		t.Helper()
+		if err := conn.WriteJSON(WebSocketMessage{
+			Type:      MessageTypeOperation,
+			Operation: "cancel",
+			Data:      map[string]string{"operation_id": id},
+		}); err != nil {
+			t.Fatalf("Failed to send cancel: %v", err)
+		}
+	}
+
+	cancel("compress-0")
+	waitFor("an error for an unknown operation", func(m WebSocketMessage, _ ProgressData) bool {
+		return m.Type == MessageTypeError && strings.Contains(m.Error, "compress-0")
+	})
+
+	req := CompressRequest{Files: []string{"big.bin"}, BasePath: "/", OutputPath: "/out.zip", Format: "zip"}
+	op := operations.Start("compress", "test", "local", req.Files)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ch.performCompression(op, fs, req, nil)
+	}()
+
+	waitFor("progress", func(_ WebSocketMessage, p ProgressData) bool {
+		return p.OperationID == op.ID
+	})
+	cancel(op.ID)
+	waitFor("a cancelled status", func(_ WebSocketMessage, p ProgressData) bool {
+		return p.OperationID == op.ID && p.Status == "cancelled"
+	})
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Compression kept running after it was cancelled")
+	}
+	if _, ok := fs.files["/out.zip"]; ok {
+		t.Error("Expected no output after cancelling")
+	}
+	if _, ok := operations.Get(op.ID); ok {
+		t.Error("Expected the cancelled operation to leave the registry")
+	}
+}
+
+func TestWebSocket_Shutdown(t *testing.T) {
+	wsh := NewWebSocketHandler()
+	server := httptest.NewServer(http.HandlerFunc(wsh.Handle))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	var conns []*websocket.Conn
+	for i := 0; i < 3; i++ {
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := wsh.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+
+	select {
+	case <-wsh.hub.stopped:
+	default:
+		t.Error("Expected the hub to have stopped")
+	}
+	wsh.hub.mu.RLock()
+	if len(wsh.hub.clients) != 0 {
+		t.Errorf("Expected no clients left, got %d", len(wsh.hub.clients))
+	}
+	wsh.hub.mu.RUnlock()
+
+	// Each client reads up to a going-away close frame
+	for i, conn := range conns {
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		for {
+			_, _, err := conn.ReadMessage()
+			if err == nil {
+				continue
+			}
+			if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+				t.Errorf("Client %d: expected a going-away close, got %v", i, err)
+			}
+			break
+		}
+	}
+
+	// Nothing blocks once the hub is gone, and shutting down again is fine
+	done := make(chan struct{})
+	go func() {
+		wsh.SendNotification("too late")
+		wsh.SetProgressInterval(time.Second)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Sending after shutdown blocked")
+	}
+	if err := wsh.Close(); err != nil {
+		t.Errorf("Second shutdown failed: %v", err)
+	}
+
+	// New connections are turned away
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("Expected a connection after shutdown to be closed, got %v", err)
+	}
+}
+
+func TestGenerateClientID_Unique(t *testing.T) {
+	// All of these are made within a second or two, so only the random
+	// part tells them apart
+	seen := make(map[string]bool)
+	for i := 0; i < 100000; i++ {
+		id := generateClientID()
+		if seen[id] {
+			t.Fatalf("Client ID %s generated twice after %d IDs", id, i)
+		}
+		seen[id] = true
+	}
+
+	if got := randomString(7); len(got) != 7 {
+		t.Errorf("Expected 7 characters, got %q", got)
+	}
+}
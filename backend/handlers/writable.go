@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+// writeTestPrefix names the hidden marker files created by write tests
+const writeTestPrefix = ".jacommander-write-test-"
+
+// isReadOnly reports whether fs is configured read-only
+func isReadOnly(fs storage.FileSystem) bool {
+	ro, ok := storage.As[storage.ReadOnlyReporter](fs)
+	return ok && ro.IsReadOnly()
+}
+
+// checkWritable reports whether files can be created in dirPath, and why
+// not if they can't. Configuration and free space are checked first; a
+// marker file is then written and removed to catch permission errors.
+func checkWritable(fs storage.FileSystem, dirPath string) (bool, string) {
+	if isReadOnly(fs) {
+		return false, "Storage is configured read-only"
+	}
+
+	if available, total, err := fs.GetAvailableSpace(); err == nil && total > 0 && available <= 0 {
+		return false, "No free space left on storage"
+	}
+
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return false, fmt.Sprintf("Failed to generate test file name: %v", err)
+	}
+	marker := filepath.Join(dirPath, writeTestPrefix+hex.EncodeToString(suffix))
+
+	if err := fs.Write(marker, bytes.NewReader(nil)); err != nil {
+		switch {
+		case errors.Is(err, os.ErrPermission):
+			return false, "Permission denied"
+		case errors.Is(err, storage.ErrNotSupported):
+			return false, "Storage does not support writing here"
+		default:
+			return false, fmt.Sprintf("Write test failed: %v", err)
+		}
+	}
+
+	if err := fs.Delete(marker); err != nil {
+		log.Printf("Warning: failed to remove write test marker %s: %v", marker, err)
+	}
+	return true, ""
+}
+
+// CheckWritable reports whether a directory is writable, so the UI can
+// disable write actions up front
+func (h *FileHandlers) CheckWritable(w http.ResponseWriter, r *http.Request) {
+	storageID := r.URL.Query().Get("storage")
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		path = "/"
+	}
+
+	// Get storage backend
+	fs, ok := h.storageManager.Get(storageID)
+	if !ok {
+		errorResponse(w, "Storage not found", http.StatusNotFound)
+		return
+	}
+
+	fs = archiveView(fs, path)
+
+	info, err := fs.Stat(path)
+	if err != nil {
+		errorResponse(w, "Directory not found", http.StatusNotFound)
+		return
+	}
+	if !info.IsDir {
+		errorResponse(w, "Path is not a directory", http.StatusBadRequest)
+		return
+	}
+
+	writable, reason := checkWritable(fs, path)
+	result := map[string]interface{}{
+		"path":     path,
+		"writable": writable,
+	}
+	if reason != "" {
+		result["reason"] = reason
+	}
+	successResponse(w, result)
+}
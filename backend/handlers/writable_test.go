@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+// readOnlyFileSystem is a mock storage configured read-only
+type readOnlyFileSystem struct {
+	*mockFileSystem
+}
+
+func (r *readOnlyFileSystem) IsReadOnly() bool { return true }
+
+// fullFileSystem is a mock storage with no free space
+type fullFileSystem struct {
+	*mockFileSystem
+}
+
+func (f *fullFileSystem) GetAvailableSpace() (available, total int64, err error) {
+	return 0, 1000, nil
+}
+
+func TestFileHandlers_CheckWritable(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "locked"), 0555); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+
+	mgr := storage.NewManager()
+	mgr.Register("local", storage.NewLocalStorage(root))
+	mgr.Register("readonly", &readOnlyFileSystem{newMockFileSystem()})
+	mgr.Register("full", &fullFileSystem{newMockFileSystem()})
+	handler := NewFileHandlers(mgr)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/api/fs/writable", handler.CheckWritable).Methods("GET")
+
+	check := func(storageID, path string) (int, bool, string) {
+		req := httptest.NewRequest("GET", "/api/fs/writable?storage="+storageID+"&path="+path, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		var resp struct {
+			Data struct {
+				Writable bool   `json:"writable"`
+				Reason   string `json:"reason"`
+			} `json:"data"`
+		}
+		_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+		return rr.Code, resp.Data.Writable, resp.Data.Reason
+	}
+
+	t.Run("Writable directory", func(t *testing.T) {
+		code, writable, reason := check("local", "/")
+		if code != http.StatusOK || !writable {
+			t.Fatalf("Expected writable, got %d %v %q", code, writable, reason)
+		}
+		entries, _ := os.ReadDir(root)
+		if len(entries) != 1 {
+			t.Errorf("Expected the write test to leave no marker behind, found %d entries", len(entries))
+		}
+	})
+
+	t.Run("Read-only storage", func(t *testing.T) {
+		code, writable, reason := check("readonly", "/")
+		if code != http.StatusOK || writable || reason != "Storage is configured read-only" {
+			t.Errorf("Unexpected result: %d %v %q", code, writable, reason)
+		}
+	})
+
+	t.Run("No free space", func(t *testing.T) {
+		_, writable, reason := check("full", "/")
+		if writable || reason != "No free space left on storage" {
+			t.Errorf("Unexpected result: %v %q", writable, reason)
+		}
+	})
+
+	t.Run("Permission denied", func(t *testing.T) {
+		if os.Geteuid() == 0 {
+			t.Skip("root can write to any directory")
+		}
+		_, writable, reason := check("local", "/locked")
+		if writable || reason != "Permission denied" {
+			t.Errorf("Unexpected result: %v %q", writable, reason)
+		}
+	})
+
+	t.Run("Missing directory", func(t *testing.T) {
+		if code, _, _ := check("local", "/missing"); code != http.StatusNotFound {
+			t.Errorf("Expected 404, got %d", code)
+		}
+	})
+}
+
+func TestFileHandlers_ReadOnlyStorage(t *testing.T) {
+	root, other := t.TempDir(), t.TempDir()
+	for _, dir := range []string{root, other} {
+		if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("hello"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mgr := storage.NewManager()
+	mgr.Register("archive", storage.NewReadOnlyFileSystem("archive", storage.NewLocalStorage(root)))
+	mgr.Register("local", storage.NewLocalStorage(other))
+	handler := NewFileHandlers(mgr)
+
+	call := func(h http.HandlerFunc, body string) (int, string) {
+		rr := httptest.NewRecorder()
+		h(rr, httptest.NewRequest("POST", "/", strings.NewReader(body)))
+		var resp struct {
+			Error struct {
+				Code string `json:"code"`
+			} `json:"error"`
+		}
+		_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+		return rr.Code, resp.Error.Code
+	}
+
+	requests := map[string]struct {
+		handler http.HandlerFunc
+		body    string
+	}{
+		"mkdir":    {handler.CreateDirectory, `{"storage": "archive", "path": "/dir"}`},
+		"delete":   {handler.DeleteFiles, `{"storage": "archive", "path": "/", "files": ["a.txt"]}`},
+		"move out": {handler.MoveFiles, `{"src_storage": "archive", "dst_storage": "local", "src_path": "/", "dst_path": "/", "files": ["a.txt"]}`},
+		"copy in":  {handler.CopyFiles, `{"src_storage": "local", "dst_storage": "archive", "src_path": "/", "dst_path": "/", "files": ["a.txt"]}`},
+	}
+	for name, req := range requests {
+		if code, errCode := call(req.handler, req.body); code != http.StatusForbidden || errCode != CodeReadOnly {
+			t.Errorf("%s: expected 403 %s, got %d %s", name, CodeReadOnly, code, errCode)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "a.txt")); err != nil {
+		t.Errorf("Expected the file to be left in place, got %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ListStorages(rr, httptest.NewRequest("GET", "/api/storages", nil))
+	var resp struct {
+		Data []struct {
+			ID       string `json:"id"`
+			ReadOnly bool   `json:"read_only"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range resp.Data {
+		if s.ReadOnly != (s.ID == "archive") {
+			t.Errorf("Expected only archive to be read-only, got %+v", s)
+		}
+	}
+}
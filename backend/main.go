@@ -2,12 +2,19 @@ package main
 
 import (
 	"compress/gzip"
+	"context"
+	"crypto/rand"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -27,6 +34,70 @@ type Config struct {
 	LocalStorages []string
 	MaxUploadSize int64
 	EnableGzip    bool
+	AdminToken    string
+
+	// AllowUnsafeInline permits inline downloads of HTML, SVG and other
+	// active content
+	AllowUnsafeInline bool
+
+	// ExcludePatterns are skipped by recursive operations unless a
+	// request sends its own list
+	ExcludePatterns []string
+
+	// DeleteConcurrency is the number of delete requests kept in flight
+	// when a directory is removed item by item
+	DeleteConcurrency int
+
+	// BatchConcurrency is the number of files of one copy, move or delete
+	// request handled at once
+	BatchConcurrency int
+
+	// TreeMaxDepth is the deepest directory tree /api/fs/tree returns
+	TreeMaxDepth int
+
+	// PreviewPDFTool and PreviewVideoTool are the pdftoppm and ffmpeg
+	// commands used for previews; empty leaves those previews disabled
+	PreviewPDFTool   string
+	PreviewVideoTool string
+
+	// ThumbnailCacheDir is where generated thumbnails are kept
+	ThumbnailCacheDir string
+
+	// LocalRootAllowlist holds the directories local storages may be
+	// rooted in
+	LocalRootAllowlist []string
+
+	// ProgressInterval is how often progress is sent over WebSocket
+	ProgressInterval time.Duration
+
+	// SystemFilePatterns are the OS clutter files hide_system_files
+	// leaves out of listings
+	SystemFilePatterns []string
+
+	// PresignMaxExpiry is the longest a presigned upload may stay valid
+	PresignMaxExpiry time.Duration
+
+	// AuthUsersFile is the JSON file of users who may log in. Empty leaves
+	// the API open to anyone who can reach it.
+	AuthUsersFile string
+
+	// AuthSecret signs login tokens; empty picks a random one at startup,
+	// so tokens don't survive a restart
+	AuthSecret string
+
+	// AuthTokenTTL is how long a login token stays valid
+	AuthTokenTTL time.Duration
+
+	// AuditLogFile is where the audit log is appended as JSON lines;
+	// empty keeps no file
+	AuditLogFile string
+
+	// AuditLogLevel is "failures", "changes" or "all"
+	AuditLogLevel string
+
+	// AuditBroadcast also sends each audit event to WebSocket clients as a
+	// notification
+	AuditBroadcast bool
 }
 
 // LoadConfig loads configuration from environment variables
@@ -36,6 +107,78 @@ func LoadConfig() *Config {
 		Host:          getEnv("HOST", "0.0.0.0"),
 		MaxUploadSize: 5 << 30, // 5GB default
 		EnableGzip:    true,
+		AdminToken:    os.Getenv("ADMIN_TOKEN"),
+
+		AllowUnsafeInline: os.Getenv("ALLOW_UNSAFE_INLINE") == "true",
+		ExcludePatterns:   storage.ParseExcludePatterns(os.Getenv("EXCLUDE_PATTERNS")),
+
+		PreviewPDFTool:    os.Getenv("PREVIEW_PDFTOPPM"),
+		PreviewVideoTool:  os.Getenv("PREVIEW_FFMPEG"),
+		ThumbnailCacheDir: getEnv("THUMBNAIL_CACHE_DIR", filepath.Join(os.TempDir(), "jacommander-thumbnails")),
+		ProgressInterval:  handlers.DefaultProgressInterval,
+
+		SystemFilePatterns: handlers.DefaultSystemFilePatterns,
+		PresignMaxExpiry:   handlers.DefaultPresignMaxExpiry,
+
+		AuthUsersFile: os.Getenv("AUTH_USERS_FILE"),
+		AuthSecret:    os.Getenv("AUTH_SECRET"),
+		AuthTokenTTL:  handlers.DefaultTokenTTL,
+
+		AuditLogFile:   os.Getenv("AUDIT_LOG_FILE"),
+		AuditLogLevel:  os.Getenv("AUDIT_LOG_LEVEL"),
+		AuditBroadcast: os.Getenv("AUDIT_BROADCAST") == "true",
+	}
+
+	if value := os.Getenv("SYSTEM_FILE_PATTERNS"); value != "" {
+		config.SystemFilePatterns = storage.ParseExcludePatterns(value)
+	}
+
+	if value := os.Getenv("WS_PROGRESS_INTERVAL"); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+			config.ProgressInterval = d
+		} else {
+			log.Printf("Ignoring invalid WS_PROGRESS_INTERVAL %q", value)
+		}
+	}
+
+	if value := os.Getenv("PRESIGN_MAX_EXPIRY"); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			config.PresignMaxExpiry = d
+		} else {
+			log.Printf("Ignoring invalid PRESIGN_MAX_EXPIRY %q", value)
+		}
+	}
+
+	if value := os.Getenv("AUTH_TOKEN_TTL"); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			config.AuthTokenTTL = d
+		} else {
+			log.Printf("Ignoring invalid AUTH_TOKEN_TTL %q", value)
+		}
+	}
+
+	if value := os.Getenv("BATCH_CONCURRENCY"); value != "" {
+		if n, err := strconv.Atoi(value); err == nil {
+			config.BatchConcurrency = n
+		} else {
+			log.Printf("Ignoring invalid BATCH_CONCURRENCY %q: %v", value, err)
+		}
+	}
+
+	if value := os.Getenv("TREE_MAX_DEPTH"); value != "" {
+		if n, err := strconv.Atoi(value); err == nil {
+			config.TreeMaxDepth = n
+		} else {
+			log.Printf("Ignoring invalid TREE_MAX_DEPTH %q: %v", value, err)
+		}
+	}
+
+	if value := os.Getenv("DELETE_CONCURRENCY"); value != "" {
+		if n, err := strconv.Atoi(value); err == nil {
+			config.DeleteConcurrency = n
+		} else {
+			log.Printf("Ignoring invalid DELETE_CONCURRENCY %q: %v", value, err)
+		}
 	}
 
 	// Parse local storage paths
@@ -51,9 +194,42 @@ func LoadConfig() *Config {
 		config.LocalStorages = []string{"/data"}
 	}
 
+	// Without an explicit list, storages added later must live beside
+	// the ones configured here
+	if value := os.Getenv("LOCAL_ROOT_ALLOWLIST"); value != "" {
+		for _, dir := range strings.Split(value, ",") {
+			if dir = strings.TrimSpace(dir); dir != "" {
+				config.LocalRootAllowlist = append(config.LocalRootAllowlist, dir)
+			}
+		}
+	} else {
+		config.LocalRootAllowlist = defaultLocalRootAllowlist(config.LocalStorages)
+	}
+
 	return config
 }
 
+// defaultLocalRootAllowlist returns the parents of the configured local
+// storages, so sibling roots can be added next to them. A storage just
+// below the filesystem root is allowed only itself, as its parent would
+// be the whole host.
+func defaultLocalRootAllowlist(storages []string) []string {
+	var dirs []string
+	seen := make(map[string]bool)
+	for _, root := range storages {
+		root = filepath.Clean(root)
+		dir := filepath.Dir(root)
+		if dir == filepath.Dir(dir) {
+			dir = root
+		}
+		if !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -70,38 +246,111 @@ func GzipMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		// Check if client accepts gzip
-		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		// Check if client accepts gzip. HEAD responses have no body to
+		// compress, only headers that must match the GET.
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") || r.Method == http.MethodHead {
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		// Wrap response writer with gzip writer
-		w.Header().Set("Content-Encoding", "gzip")
-		gz := gzip.NewWriter(w)
+		// Whether to compress is decided when the handler sends its headers
+		gzipWriter := &gzipResponseWriter{ResponseWriter: w}
 		defer func() {
-			if err := gz.Close(); err != nil {
-				// Ignore harmless errors like "http: request method or response status code does not allow body"
-				// or "wrote more than the declared Content-Length"
-				if !strings.Contains(err.Error(), "does not allow body") &&
-				   !strings.Contains(err.Error(), "Content-Length") {
-					log.Printf("Error closing gzip writer: %v", err)
-				}
+			if gzipWriter.gz == nil {
+				return
+			}
+			if err := gzipWriter.gz.Close(); err != nil {
+				log.Printf("Error closing gzip writer: %v", err)
 			}
 		}()
-
-		gzipWriter := &gzipResponseWriter{Writer: gz, ResponseWriter: w}
 		next.ServeHTTP(gzipWriter, r)
 	})
 }
 
+// compressibleTypes are the content types worth compressing, besides
+// text/* and the +json and +xml suffixes. Archives, images and video are
+// compressed already.
+var compressibleTypes = map[string]bool{
+	"application/json":       true,
+	"application/x-ndjson":   true,
+	"application/javascript": true,
+	"application/xml":        true,
+	"application/wasm":       true,
+	"image/svg+xml":          true,
+}
+
+// isCompressible reports whether a response of the given Content-Type is
+// worth compressing
+func isCompressible(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	return strings.HasPrefix(mediaType, "text/") ||
+		strings.HasSuffix(mediaType, "+json") ||
+		strings.HasSuffix(mediaType, "+xml") ||
+		compressibleTypes[mediaType]
+}
+
+// gzipResponseWriter compresses the body when the status and headers the
+// handler sends allow it, and passes it through unchanged otherwise
 type gzipResponseWriter struct {
 	http.ResponseWriter
-	Writer *gzip.Writer
+	gz          *gzip.Writer
+	wroteHeader bool
+}
+
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+
+	h := w.Header()
+	bodyless := status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified
+	// Partial content is a byte range of the uncompressed file
+	if !bodyless && status != http.StatusPartialContent &&
+		h.Get("Content-Encoding") == "" && isCompressible(h.Get("Content-Type")) {
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", "gzip")
+		h.Add("Vary", "Accept-Encoding")
+		w.gz = gzip.NewWriter(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(status)
 }
 
 func (w *gzipResponseWriter) Write(b []byte) (int, error) {
-	return w.Writer.Write(b)
+	if !w.wroteHeader {
+		// net/http would sniff the type on this first write; it's needed
+		// here before then to decide on compression
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.gz != nil {
+		return w.gz.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush sends what the handler wrote so far, compressed, to the client, so
+// streamed responses arrive as they are written
+func (w *gzipResponseWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.gz != nil {
+		if err := w.gz.Flush(); err != nil {
+			log.Printf("Error flushing gzip writer: %v", err)
+		}
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap gives http.ResponseController the underlying writer
+func (w *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
 }
 
 // CORSMiddleware adds CORS headers to responses
@@ -127,7 +376,7 @@ func CORSMiddleware(next http.Handler) http.Handler {
 		}
 
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Client-ID")
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
 
 		if r.Method == "OPTIONS" {
@@ -139,6 +388,95 @@ func CORSMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// authProtectedPaths are the API paths, and everything below them, that
+// need a login when one is configured
+var authProtectedPaths = []string{"/api/fs", "/api/storages", "/api/security", "/api/ws"}
+
+// AuthMiddleware requires a valid login token on the protected paths.
+// Health, config and the login endpoints themselves stay open.
+func AuthMiddleware(auth *handlers.AuthHandler, next http.Handler) http.Handler {
+	protected := auth.Require(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, prefix := range authProtectedPaths {
+			if r.URL.Path == prefix || strings.HasPrefix(r.URL.Path, prefix+"/") {
+				protected.ServeHTTP(w, r)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// newAuthHandler sets up logins from the configured users file, or
+// returns nil when there is none
+func newAuthHandler(config *Config) (*handlers.AuthHandler, error) {
+	if config.AuthUsersFile == "" {
+		return nil, nil
+	}
+	users, err := handlers.LoadUserStore(config.AuthUsersFile)
+	if err != nil {
+		return nil, err
+	}
+
+	secret := []byte(config.AuthSecret)
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, fmt.Errorf("failed to generate token secret: %w", err)
+		}
+		log.Printf("AUTH_SECRET is not set; logins won't survive a restart")
+	}
+	return handlers.NewAuthHandler(users, secret, config.AuthTokenTTL), nil
+}
+
+// newAuditLogger starts the audit log, or returns nil when neither a file
+// nor broadcasting is configured. The returned function stops it.
+func newAuditLogger(config *Config, wsHandler *handlers.WebSocketHandler) (*handlers.AuditLogger, func(), error) {
+	if config.AuditLogFile == "" && !config.AuditBroadcast {
+		return nil, func() {}, nil
+	}
+	level, err := handlers.ParseAuditLevel(config.AuditLogLevel)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var file *os.File
+	var out io.Writer
+	if config.AuditLogFile != "" {
+		file, err = os.OpenFile(config.AuditLogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, nil, err
+		}
+		out = file
+	}
+	audit := handlers.NewAuditLogger(out, level)
+	if config.AuditBroadcast {
+		audit.SetWebSocketHandler(wsHandler)
+	}
+	return audit, func() {
+		audit.Close()
+		if file != nil {
+			if err := file.Close(); err != nil {
+				log.Printf("Error closing audit log: %v", err)
+			}
+		}
+	}, nil
+}
+
+// withMiddleware wraps the router in the middleware every request passes
+// through: authentication, then CORS, then compression
+func withMiddleware(router http.Handler, config *Config, auth *handlers.AuthHandler) http.Handler {
+	handler := router
+	if auth != nil {
+		handler = AuthMiddleware(auth, handler)
+	}
+	handler = CORSMiddleware(handler)
+	if config.EnableGzip {
+		handler = GzipMiddleware(handler)
+	}
+	return handler
+}
+
 // JSONResponse sends a JSON response
 func JSONResponse(w http.ResponseWriter, data interface{}, status int) {
 	w.Header().Set("Content-Type", "application/json")
@@ -171,6 +509,15 @@ func main() {
 	config := LoadConfig()
 	log.Printf("[STARTUP] Config loaded: %d local storage paths", len(config.LocalStorages))
 
+	if err := storage.SetDefaultExcludes(config.ExcludePatterns); err != nil {
+		log.Fatalf("Invalid EXCLUDE_PATTERNS: %v", err)
+	}
+	storage.SetDeleteConcurrency(config.DeleteConcurrency)
+	if err := storage.SetLocalRootAllowlist(config.LocalRootAllowlist); err != nil {
+		log.Fatalf("Invalid LOCAL_ROOT_ALLOWLIST: %v", err)
+	}
+	log.Printf("[STARTUP] Local storages allowed under: %s", strings.Join(config.LocalRootAllowlist, ", "))
+
 	// Initialize storage manager with cloud support
 	storageManager := storage.NewCloudManager()
 	log.Printf("[STARTUP] Storage manager initialized")
@@ -215,13 +562,57 @@ func main() {
 	// Create handlers with storage manager
 	log.Printf("[STARTUP] Creating handlers...")
 	fileHandlers := handlers.NewFileHandlers(storageManager.GetManager())
+	fileHandlers.SetAllowUnsafeInline(config.AllowUnsafeInline)
+	fileHandlers.SetBatchConcurrency(config.BatchConcurrency)
+	fileHandlers.SetMaxTreeDepth(config.TreeMaxDepth)
+	if err := fileHandlers.SetSystemFilePatterns(config.SystemFilePatterns); err != nil {
+		log.Fatalf("Invalid SYSTEM_FILE_PATTERNS: %v", err)
+	}
+	fileHandlers.SetPresignLimits(config.PresignMaxExpiry, min(config.MaxUploadSize, handlers.DefaultPresignMaxSize))
 	wsHandler := handlers.NewWebSocketHandler()
+	wsHandler.SetStorageManager(storageManager.GetManager())
+	wsHandler.SetProgressInterval(config.ProgressInterval)
 	compressionHandler := handlers.NewCompressionHandler(storageManager.GetManager())
 	storageHandler := handlers.NewStorageHandler(storageManager)
 	securityHandler := handlers.NewSecurityHandler(storageManager)
+	uploadHandler := handlers.NewUploadHandler(storageManager.GetManager())
+	stopUploadCleanup := uploadHandler.StartCleanup(time.Hour)
+	defer stopUploadCleanup()
+	previewHandler := handlers.NewPreviewHandler(storageManager.GetManager())
+	if config.PreviewPDFTool != "" {
+		if err := previewHandler.SetPDFTool(config.PreviewPDFTool); err != nil {
+			log.Printf("PDF previews disabled: %v", err)
+		}
+	}
+	if config.PreviewVideoTool != "" {
+		if err := previewHandler.SetVideoTool(config.PreviewVideoTool); err != nil {
+			log.Printf("Video previews disabled: %v", err)
+		}
+	}
+	if err := previewHandler.SetThumbnailCache(config.ThumbnailCacheDir); err != nil {
+		log.Printf("Thumbnails will not be cached: %v", err)
+	}
+	operations := handlers.NewOperationRegistry()
+	adminHandler := handlers.NewAdminHandler(operations, config.AdminToken)
+	adminHandler.SetStorageManager(storageManager.GetManager())
+	authHandler, err := newAuthHandler(config)
+	if err != nil {
+		log.Fatalf("Invalid AUTH_USERS_FILE: %v", err)
+	}
+	if authHandler == nil {
+		log.Printf("Warning: AUTH_USERS_FILE is not set, the API is open to anyone who can reach it")
+	}
+	audit, closeAudit, err := newAuditLogger(config, wsHandler)
+	if err != nil {
+		log.Fatalf("Failed to start the audit log: %v", err)
+	}
 
 	// Connect WebSocket handler to compression handler for progress tracking
 	compressionHandler.SetWebSocketHandler(wsHandler)
+	compressionHandler.SetOperationRegistry(operations)
+	wsHandler.SetOperationRegistry(operations)
+	storageHandler.SetWebSocketHandler(wsHandler)
+	storageHandler.SetOperationRegistry(operations)
 
 	// Setup routes
 	router := mux.NewRouter()
@@ -241,35 +632,79 @@ func main() {
 		}
 	}).Methods("GET")
 
+	// Login
+	if authHandler != nil {
+		api.HandleFunc("/auth/login", authHandler.Login).Methods("POST")
+		api.HandleFunc("/auth/refresh", authHandler.Refresh).Methods("POST")
+	}
+
 	// Filesystem operations
 	api.HandleFunc("/fs/list", fileHandlers.ListDirectory).Methods("GET")
-	api.HandleFunc("/fs/mkdir", fileHandlers.CreateDirectory).Methods("POST")
-	api.HandleFunc("/fs/copy", fileHandlers.CopyFiles).Methods("POST")
-	api.HandleFunc("/fs/move", fileHandlers.MoveFiles).Methods("POST")
-	api.HandleFunc("/fs/delete", fileHandlers.DeleteFiles).Methods("DELETE")
-	api.HandleFunc("/fs/download", fileHandlers.DownloadFile).Methods("GET")
-	api.HandleFunc("/fs/upload", fileHandlers.UploadFile).Methods("POST")
+	api.HandleFunc("/fs/stat", fileHandlers.StatFile).Methods("GET")
+	api.HandleFunc("/fs/mkdir", audit.Wrap("create", fileHandlers.CreateDirectory)).Methods("POST")
+	api.HandleFunc("/fs/copy", audit.Wrap("copy", fileHandlers.CopyFiles)).Methods("POST")
+	api.HandleFunc("/fs/move", audit.Wrap("move", fileHandlers.MoveFiles)).Methods("POST")
+	api.HandleFunc("/fs/clipboard", fileHandlers.GetClipboard).Methods("GET")
+	api.HandleFunc("/fs/clipboard", fileHandlers.SetClipboard).Methods("POST")
+	api.HandleFunc("/fs/clipboard", fileHandlers.ClearClipboard).Methods("DELETE")
+	api.HandleFunc("/fs/clipboard/paste", audit.Wrap("paste", fileHandlers.PasteClipboard)).Methods("POST")
+	api.HandleFunc("/fs/delete", audit.Wrap("delete", fileHandlers.DeleteFiles)).Methods("DELETE")
+	api.HandleFunc("/fs/download", audit.Wrap("download", fileHandlers.DownloadFile)).Methods("GET")
+	api.HandleFunc("/fs/preview", previewHandler.Preview).Methods("GET")
+	api.HandleFunc("/fs/thumbnail", previewHandler.Thumbnail).Methods("GET")
+	api.HandleFunc("/fs/download-selection", audit.Wrap("download", compressionHandler.DownloadSelection)).Methods("POST")
+	api.HandleFunc("/fs/download-archive", audit.Wrap("download", compressionHandler.DownloadArchive)).Methods("GET")
+	api.HandleFunc("/fs/upload", audit.Wrap("upload", fileHandlers.UploadFile)).Methods("POST")
+	api.HandleFunc("/fs/upload/init", uploadHandler.InitUpload).Methods("POST")
+	api.HandleFunc("/fs/upload/chunk", uploadHandler.UploadChunk).Methods("POST")
+	api.HandleFunc("/fs/upload/complete", audit.Wrap("upload", uploadHandler.CompleteUpload)).Methods("POST")
+	api.HandleFunc("/fs/uploads", uploadHandler.ListUploads).Methods("GET")
+	api.HandleFunc("/fs/uploads/cleanup", audit.Wrap("upload-cleanup", uploadHandler.CleanupUploads)).Methods("DELETE")
+	api.HandleFunc("/fs/upload/{id}", audit.Wrap("upload", uploadHandler.UploadRange)).Methods("PUT")
+	api.HandleFunc("/fs/upload/{id}", uploadHandler.UploadStatus).Methods("GET")
+	api.HandleFunc("/fs/upload/{id}", audit.Wrap("upload-cancel", uploadHandler.CancelUpload)).Methods("DELETE")
+	api.HandleFunc("/fs/presign-upload", audit.Wrap("presign-upload", fileHandlers.PresignUpload)).Methods("POST")
+	api.HandleFunc("/fs/chmod", audit.Wrap("chmod", fileHandlers.ChangeMode)).Methods("POST")
+	api.HandleFunc("/fs/chown", audit.Wrap("chown", fileHandlers.ChangeOwner)).Methods("POST")
+	api.HandleFunc("/fs/dir-compare", fileHandlers.CompareDirectories).Methods("POST")
+	api.HandleFunc("/fs/export-listing", fileHandlers.ExportListing).Methods("GET")
+	api.HandleFunc("/fs/find", fileHandlers.FindFiles).Methods("GET")
+	api.HandleFunc("/fs/tree", fileHandlers.GetTree).Methods("GET")
+	api.HandleFunc("/fs/search", fileHandlers.Search).Methods("GET")
+	api.HandleFunc("/fs/checksum", fileHandlers.Checksum).Methods("GET")
+	api.HandleFunc("/fs/writable", fileHandlers.CheckWritable).Methods("GET")
 
 	// Compression operations
-	api.HandleFunc("/fs/compress", compressionHandler.Compress).Methods("POST")
-	api.HandleFunc("/fs/decompress", compressionHandler.Decompress).Methods("POST")
+	api.HandleFunc("/fs/compress", audit.Wrap("compress", compressionHandler.Compress)).Methods("POST")
+	api.HandleFunc("/fs/decompress", audit.Wrap("decompress", compressionHandler.Decompress)).Methods("POST")
+	api.HandleFunc("/fs/split", audit.Wrap("split", compressionHandler.Split)).Methods("POST")
+	api.HandleFunc("/fs/archive/verify", compressionHandler.VerifyArchive).Methods("POST")
 
 	// WebSocket endpoint for progress tracking
 	api.HandleFunc("/ws", wsHandler.Handle)
 
 	// Storage management endpoints
 	api.HandleFunc("/storages", storageHandler.ListStorages).Methods("GET")
-	api.HandleFunc("/storages", storageHandler.AddStorage).Methods("POST")
-	api.HandleFunc("/storages/{id}", storageHandler.RemoveStorage).Methods("DELETE")
-	api.HandleFunc("/storages/{id}/default", storageHandler.SetDefaultStorage).Methods("PUT")
+	api.HandleFunc("/storages", audit.Wrap("storage-add", storageHandler.AddStorage)).Methods("POST")
+	api.HandleFunc("/storages/space", storageHandler.StorageSpace).Methods("GET")
+	api.HandleFunc("/storages/{id}", audit.Wrap("storage-remove", storageHandler.RemoveStorage)).Methods("DELETE")
+	api.HandleFunc("/storages/{id}/default", audit.Wrap("storage-default", storageHandler.SetDefaultStorage)).Methods("PUT")
+	api.HandleFunc("/storages/{id}/retry-init", audit.Wrap("storage-retry", storageHandler.RetryInit)).Methods("POST")
+	api.HandleFunc("/storages/{id}/stats", storageHandler.StorageStats).Methods("GET")
+	api.HandleFunc("/storages/{id}/info", storageHandler.StorageInfo).Methods("GET")
 	api.HandleFunc("/storages/test", storageHandler.TestConnection).Methods("POST")
-	api.HandleFunc("/storages/transfer", storageHandler.TransferFiles).Methods("POST")
+	api.HandleFunc("/storages/transfer", audit.Wrap("transfer", storageHandler.TransferFiles)).Methods("POST")
 
 	// Security configuration endpoints
 	api.HandleFunc("/security/config", securityHandler.GetSecurityConfig).Methods("GET")
-	api.HandleFunc("/security/config", securityHandler.SetSecurityConfig).Methods("POST")
+	api.HandleFunc("/security/config", audit.Wrap("security-config", securityHandler.SetSecurityConfig)).Methods("POST")
 	api.HandleFunc("/security/validate", securityHandler.ValidateEndpoint).Methods("POST")
 
+	// Admin endpoints
+	api.HandleFunc("/admin/operations", adminHandler.RequireAdmin(adminHandler.ListOperations)).Methods("GET")
+	api.HandleFunc("/admin/operations/{id}", adminHandler.RequireAdmin(adminHandler.CancelOperation)).Methods("DELETE")
+	api.HandleFunc("/admin/storages/{id}/stats", adminHandler.RequireAdmin(adminHandler.ResetStorageStats)).Methods("DELETE")
+
 	// Config endpoint - returns server configuration
 	api.HandleFunc("/config", func(w http.ResponseWriter, r *http.Request) {
 		JSONResponse(w, map[string]interface{}{
@@ -282,10 +717,7 @@ func main() {
 	router.PathPrefix("/").Handler(spa)
 
 	// Apply middleware
-	handler := CORSMiddleware(router)
-	if config.EnableGzip {
-		handler = GzipMiddleware(handler)
-	}
+	handler := withMiddleware(router, config, authHandler)
 
 	// Start server
 	addr := fmt.Sprintf("%s:%s", config.Host, config.Port)
@@ -300,8 +732,42 @@ func main() {
 		IdleTimeout:  60 * time.Second,
 	}
 
-	if err := server.ListenAndServe(); err != nil {
-		log.Fatal("Server failed to start: ", err)
+	// Serve until interrupted, then let requests and background operations
+	// in flight finish and disconnect WebSocket clients cleanly
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal("Server failed to start: ", err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	log.Printf("Shutting down")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Error shutting down server: %v", err)
+	}
+
+	// Let compressions, transfers and the like finish; those that don't
+	// in time are cancelled and remove their partial output
+	drained, cancelled, err := operations.Drain(shutdownCtx, 10*time.Second)
+	log.Printf("Drained %d operations, cancelled %d", drained, cancelled)
+	if err != nil {
+		log.Printf("Error draining operations: %v", err)
+	}
+
+	closeAudit()
+
+	// Clients get the last progress before being disconnected
+	wsCtx, wsCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer wsCancel()
+	if err := wsHandler.Shutdown(wsCtx); err != nil {
+		log.Printf("Error closing WebSocket connections: %v", err)
 	}
 }
 
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jacommander/jacommander/backend/handlers"
+	"github.com/jacommander/jacommander/backend/storage"
+)
+
+func TestGzipMiddleware_Flush(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(GzipMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Write([]byte("{\"name\":\"first\"}\n"))
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			t.Errorf("Expected the writer to flush, got %v", err)
+		}
+		<-release
+		w.Write([]byte("{\"name\":\"second\"}\n"))
+	})))
+	defer server.Close()
+	defer close(release)
+
+	// Headers and the first line should both arrive while the handler
+	// is still running
+	line := make(chan string, 1)
+	go func() {
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+		resp, err := client.Do(req)
+		if err != nil {
+			line <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		if resp.Header.Get("Content-Encoding") != "gzip" {
+			line <- "not gzipped"
+			return
+		}
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			line <- err.Error()
+			return
+		}
+		first, _ := bufio.NewReader(gz).ReadString('\n')
+		line <- first
+	}()
+	select {
+	case got := <-line:
+		if got != "{\"name\":\"first\"}\n" {
+			t.Errorf("Expected the first line, got %q", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("The first line was held back until the handler finished")
+	}
+}
+
+func TestStreamingHandlers_FlushThroughMiddleware(t *testing.T) {
+	root := t.TempDir()
+	for i := 0; i < 600; i++ {
+		if err := os.WriteFile(filepath.Join(root, fmt.Sprintf("file%03d.txt", i)), []byte("needle"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mgr := storage.NewManager()
+	mgr.Register("local", storage.NewLocalStorage(root))
+	fileHandlers := handlers.NewFileHandlers(mgr)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/api/fs/list", fileHandlers.ListDirectory).Methods("GET")
+	router.HandleFunc("/api/fs/find", fileHandlers.FindFiles).Methods("GET")
+	router.HandleFunc("/api/fs/search", fileHandlers.Search).Methods("GET")
+	router.HandleFunc("/api/fs/export-listing", fileHandlers.ExportListing).Methods("GET")
+	handler := withMiddleware(router, &Config{EnableGzip: true}, nil)
+
+	for _, target := range []string{
+		"/api/fs/list?storage=local&path=/",
+		"/api/fs/find?storage=local&name=*.txt",
+		"/api/fs/search?storage=local&pattern=file",
+		"/api/fs/export-listing?storage=local&path=/&format=csv",
+	} {
+		req := httptest.NewRequest("GET", target, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK || rr.Header().Get("Content-Encoding") != "gzip" {
+			t.Errorf("%s: expected a gzipped 200, got %d %q", target, rr.Code, rr.Header().Get("Content-Encoding"))
+			continue
+		}
+		if !rr.Flushed {
+			t.Errorf("%s: expected the response to be flushed while streaming", target)
+		}
+		gz, err := gzip.NewReader(rr.Body)
+		if err != nil {
+			t.Errorf("%s: %v", target, err)
+			continue
+		}
+		if body, err := io.ReadAll(gz); err != nil || !strings.Contains(string(body), ".txt") {
+			t.Errorf("%s: expected the entries, got %v", target, err)
+		}
+	}
+}
+
+func TestLoadConfig_LocalRootAllowlist(t *testing.T) {
+	t.Setenv("LOCAL_STORAGE_1", "/srv/shares/team")
+	t.Setenv("LOCAL_STORAGE_2", "/srv/shares/public/")
+	t.Setenv("LOCAL_STORAGE_3", "/data")
+	if got := strings.Join(LoadConfig().LocalRootAllowlist, ","); got != "/srv/shares,/data" {
+		t.Errorf("Expected the storages' parents, or the storage itself below /, got %s", got)
+	}
+
+	t.Setenv("LOCAL_ROOT_ALLOWLIST", "/mnt, /media")
+	if got := strings.Join(LoadConfig().LocalRootAllowlist, ","); got != "/mnt,/media" {
+		t.Errorf("Expected the explicit list, got %s", got)
+	}
+}
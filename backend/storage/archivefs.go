@@ -0,0 +1,444 @@
+package storage
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ArchiveSeparator separates an archive's path from a path inside it, as in
+// "/backups/site.zip!/css/main.css"
+const ArchiveSeparator = "!/"
+
+// SplitArchivePath splits a virtual path into the archive path and the
+// path inside the archive. ok is false for ordinary paths.
+func SplitArchivePath(p string) (archive, inner string, ok bool) {
+	idx := strings.Index(p, ArchiveSeparator)
+	if idx < 0 {
+		if strings.HasSuffix(p, "!") && archiveFormat(strings.TrimSuffix(p, "!")) != "" {
+			return strings.TrimSuffix(p, "!"), "/", true
+		}
+		return "", "", false
+	}
+	return p[:idx], path.Clean("/" + p[idx+len(ArchiveSeparator):]), true
+}
+
+// archiveFormat returns the archive format of a file name, or "" if it's
+// not an archive ArchiveFS can open
+func archiveFormat(name string) string {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".zip"), strings.HasSuffix(lower, ".jar"):
+		return "zip"
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return "tar.gz"
+	case strings.HasSuffix(lower, ".tar"):
+		return "tar"
+	default:
+		return ""
+	}
+}
+
+// ArchiveFS wraps a FileSystem so that paths containing ArchiveSeparator
+// browse inside zip and tar archives as if they were directories. Archive
+// contents are read-only; all other paths are passed through unchanged.
+type ArchiveFS struct {
+	FileSystem
+}
+
+// NewArchiveFS wraps fs with archive browsing
+func NewArchiveFS(fs FileSystem) *ArchiveFS {
+	return &ArchiveFS{FileSystem: fs}
+}
+
+// archiveEntry is a file or directory inside an archive
+type archiveEntry struct {
+	name    string // path inside the archive, without leading slash
+	size    int64
+	modTime time.Time
+	isDir   bool
+	mode    os.FileMode
+}
+
+// virtualInfo converts an entry to a FileInfo whose Path can be passed
+// back to ArchiveFS
+func (e archiveEntry) virtualInfo(archivePath string) FileInfo {
+	info := FileInfo{
+		Name:        path.Base(e.name),
+		Path:        archivePath + ArchiveSeparator + e.name,
+		Size:        e.size,
+		ModTime:     e.modTime,
+		IsDir:       e.isDir,
+		Permissions: e.mode.String(),
+	}
+	if !e.isDir {
+		info.MimeType = mime.TypeByExtension(path.Ext(e.name))
+	}
+	return info
+}
+
+// archiveFile is random access to an archive, as an *os.File provides
+type archiveFile interface {
+	io.ReaderAt
+	Stat() (os.FileInfo, error)
+}
+
+// openArchiveFile returns the archive as an io.ReaderAt, spooling it to a
+// temp file if the backend can't provide random access
+func (a *ArchiveFS) openArchiveFile(archivePath string) (archiveFile, func(), error) {
+	reader, err := a.FileSystem.Read(archivePath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if file, ok := reader.(archiveFile); ok {
+		return file, func() {
+			if err := reader.Close(); err != nil {
+				log.Printf("Error closing archive: %v", err)
+			}
+		}, nil
+	}
+
+	defer func() {
+		if err := reader.Close(); err != nil {
+			log.Printf("Error closing archive: %v", err)
+		}
+	}()
+
+	tmp, err := os.CreateTemp("", "jacommander-archive-*")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() {
+		if err := tmp.Close(); err != nil {
+			log.Printf("Error closing archive temp file: %v", err)
+		}
+		if err := os.Remove(tmp.Name()); err != nil {
+			log.Printf("Error removing archive temp file: %v", err)
+		}
+	}
+	if _, err := io.Copy(tmp, reader); err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("failed to buffer archive: %w", err)
+	}
+	return tmp, cleanup, nil
+}
+
+// openTar returns a tar reader over the archive
+func (a *ArchiveFS) openTar(archivePath, format string) (*tar.Reader, func(), error) {
+	reader, err := a.FileSystem.Read(archivePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	closeFn := func() {
+		if err := reader.Close(); err != nil {
+			log.Printf("Error closing archive: %v", err)
+		}
+	}
+
+	var src io.Reader = reader
+	if format == "tar.gz" {
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			closeFn()
+			return nil, nil, fmt.Errorf("failed to open gzip stream: %w", err)
+		}
+		src = gz
+	}
+	return tar.NewReader(src), closeFn, nil
+}
+
+// entries reads the archive's table of contents. Directories that are only
+// implied by file paths are added so every level can be listed.
+func (a *ArchiveFS) entries(archivePath string) (map[string]archiveEntry, error) {
+	format := archiveFormat(archivePath)
+	if format == "" {
+		return nil, fmt.Errorf("unsupported archive format: %s", path.Base(archivePath))
+	}
+
+	index := make(map[string]archiveEntry)
+	add := func(e archiveEntry) {
+		e.name = strings.Trim(path.Clean("/"+e.name), "/")
+		if e.name == "" {
+			return
+		}
+		index[e.name] = e
+		for dir := path.Dir(e.name); dir != "." && dir != "/"; dir = path.Dir(dir) {
+			if _, ok := index[dir]; ok {
+				break
+			}
+			index[dir] = archiveEntry{name: dir, isDir: true, modTime: e.modTime, mode: os.ModeDir | 0755}
+		}
+	}
+
+	if format == "zip" {
+		file, cleanup, err := a.openArchiveFile(archivePath)
+		if err != nil {
+			return nil, err
+		}
+		defer cleanup()
+
+		stat, err := file.Stat()
+		if err != nil {
+			return nil, err
+		}
+		zr, err := zip.NewReader(file, stat.Size())
+		if err != nil {
+			return nil, fmt.Errorf("failed to open zip archive: %w", err)
+		}
+		for _, f := range zr.File {
+			add(archiveEntry{
+				name:    f.Name,
+				size:    int64(f.UncompressedSize64),
+				modTime: f.Modified,
+				isDir:   f.FileInfo().IsDir(),
+				mode:    f.Mode(),
+			})
+		}
+		return index, nil
+	}
+
+	tr, cleanup, err := a.openTar(archivePath, format)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tar archive: %w", err)
+		}
+		add(archiveEntry{
+			name:    header.Name,
+			size:    header.Size,
+			modTime: header.ModTime,
+			isDir:   header.Typeflag == tar.TypeDir,
+			mode:    header.FileInfo().Mode(),
+		})
+	}
+	return index, nil
+}
+
+// List lists a directory, which may be inside an archive
+func (a *ArchiveFS) List(p string) ([]FileInfo, error) {
+	archivePath, inner, ok := SplitArchivePath(p)
+	if !ok {
+		return a.FileSystem.List(p)
+	}
+
+	index, err := a.entries(archivePath)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := strings.Trim(inner, "/")
+	if dir != "" {
+		if e, ok := index[dir]; !ok || !e.isDir {
+			return nil, fmt.Errorf("directory not found in archive: %s", inner)
+		}
+	}
+
+	files := []FileInfo{}
+	for name, e := range index {
+		parent := path.Dir(name)
+		if parent == "." {
+			parent = ""
+		}
+		if parent == dir {
+			files = append(files, e.virtualInfo(archivePath))
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return files, nil
+}
+
+// Stat returns information about a path, which may be inside an archive
+func (a *ArchiveFS) Stat(p string) (FileInfo, error) {
+	archivePath, inner, ok := SplitArchivePath(p)
+	if !ok {
+		return a.FileSystem.Stat(p)
+	}
+
+	if inner == "/" {
+		info, err := a.FileSystem.Stat(archivePath)
+		if err != nil {
+			return FileInfo{}, err
+		}
+		info.Path = archivePath + ArchiveSeparator
+		info.IsDir = true
+		info.MimeType = ""
+		return info, nil
+	}
+
+	index, err := a.entries(archivePath)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	e, ok := index[strings.Trim(inner, "/")]
+	if !ok {
+		return FileInfo{}, fmt.Errorf("file not found in archive: %s", inner)
+	}
+	return e.virtualInfo(archivePath), nil
+}
+
+// Read streams a single file out of an archive, or reads an ordinary file
+func (a *ArchiveFS) Read(p string) (io.ReadCloser, error) {
+	archivePath, inner, ok := SplitArchivePath(p)
+	if !ok {
+		return a.FileSystem.Read(p)
+	}
+
+	format := archiveFormat(archivePath)
+	name := strings.Trim(inner, "/")
+
+	switch format {
+	case "zip":
+		file, cleanup, err := a.openArchiveFile(archivePath)
+		if err != nil {
+			return nil, err
+		}
+		stat, err := file.Stat()
+		if err != nil {
+			cleanup()
+			return nil, err
+		}
+		zr, err := zip.NewReader(file, stat.Size())
+		if err != nil {
+			cleanup()
+			return nil, fmt.Errorf("failed to open zip archive: %w", err)
+		}
+		for _, f := range zr.File {
+			if strings.Trim(path.Clean("/"+f.Name), "/") != name || f.FileInfo().IsDir() {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				cleanup()
+				return nil, err
+			}
+			return &archiveEntryReader{Reader: rc, close: func() {
+				if err := rc.Close(); err != nil {
+					log.Printf("Error closing archive entry: %v", err)
+				}
+				cleanup()
+			}}, nil
+		}
+		cleanup()
+
+	case "tar", "tar.gz":
+		tr, cleanup, err := a.openTar(archivePath, format)
+		if err != nil {
+			return nil, err
+		}
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				cleanup()
+				return nil, fmt.Errorf("failed to read tar archive: %w", err)
+			}
+			if strings.Trim(path.Clean("/"+header.Name), "/") == name && header.Typeflag != tar.TypeDir {
+				return &archiveEntryReader{Reader: tr, close: cleanup}, nil
+			}
+		}
+		cleanup()
+
+	default:
+		return nil, fmt.Errorf("unsupported archive format: %s", path.Base(archivePath))
+	}
+
+	return nil, fmt.Errorf("file not found in archive: %s", inner)
+}
+
+// archiveEntryReader streams one archive entry and releases the archive
+// when closed
+type archiveEntryReader struct {
+	io.Reader
+	close func()
+}
+
+func (r *archiveEntryReader) Close() error {
+	r.close()
+	return nil
+}
+
+// errArchiveReadOnly is returned for writes inside an archive
+var errArchiveReadOnly = fmt.Errorf("archive contents are read-only: %w", ErrNotSupported)
+
+// inArchive reports whether any of the paths point inside an archive
+func inArchive(paths ...string) bool {
+	for _, p := range paths {
+		if strings.Contains(p, ArchiveSeparator) {
+			return true
+		}
+	}
+	return false
+}
+
+// Write writes a file; archive contents can't be modified
+func (a *ArchiveFS) Write(p string, data io.Reader) error {
+	if inArchive(p) {
+		return errArchiveReadOnly
+	}
+	return a.FileSystem.Write(p, data)
+}
+
+// Delete deletes a file; archive contents can't be modified
+func (a *ArchiveFS) Delete(p string) error {
+	if inArchive(p) {
+		return errArchiveReadOnly
+	}
+	return a.FileSystem.Delete(p)
+}
+
+// MkDir creates a directory; archive contents can't be modified
+func (a *ArchiveFS) MkDir(p string) error {
+	if inArchive(p) {
+		return errArchiveReadOnly
+	}
+	return a.FileSystem.MkDir(p)
+}
+
+// Move moves a file; archive contents can't be modified
+func (a *ArchiveFS) Move(src, dst string) error {
+	if inArchive(src, dst) {
+		return errArchiveReadOnly
+	}
+	return a.FileSystem.Move(src, dst)
+}
+
+// Copy copies a file. Copying out of an archive streams the entry.
+func (a *ArchiveFS) Copy(src, dst string, progress ProgressCallback) error {
+	if inArchive(dst) {
+		return errArchiveReadOnly
+	}
+	if !inArchive(src) {
+		return a.FileSystem.Copy(src, dst, progress)
+	}
+
+	reader, err := a.Read(src)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := reader.Close(); err != nil {
+			log.Printf("Error closing archive entry: %v", err)
+		}
+	}()
+	return a.FileSystem.Write(dst, reader)
+}
@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var archiveTestFiles = map[string]string{
+	"readme.txt":              "top level",
+	"docs/guide.md":           "# Guide",
+	"docs/api/v1/routes.json": `{"routes": []}`,
+	"docs/api/v1/notes.txt":   "nested notes",
+}
+
+func writeTestZip(t *testing.T, path string) {
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create zip: %v", err)
+	}
+	defer file.Close()
+
+	zw := zip.NewWriter(file)
+	for name, content := range archiveTestFiles {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("Failed to add %s: %v", name, err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Failed to finish zip: %v", err)
+	}
+}
+
+func writeTestTarGz(t *testing.T, path string) {
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create tar.gz: %v", err)
+	}
+	defer file.Close()
+
+	gw := gzip.NewWriter(file)
+	tw := tar.NewWriter(gw)
+	for name, content := range archiveTestFiles {
+		if err := tw.WriteHeader(&tar.Header{Name: "./" + name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatalf("Failed to add %s: %v", name, err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Failed to finish tar: %v", err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatalf("Failed to finish gzip: %v", err)
+	}
+}
+
+func TestArchiveFS(t *testing.T) {
+	tempDir, cleanup := setupTestDir(t)
+	defer cleanup()
+
+	writeTestZip(t, filepath.Join(tempDir, "site.zip"))
+	writeTestTarGz(t, filepath.Join(tempDir, "site.tar.gz"))
+	fs := NewArchiveFS(NewLocalStorage(tempDir))
+
+	names := func(files []FileInfo) string {
+		var out []string
+		for _, f := range files {
+			out = append(out, f.Name)
+		}
+		return strings.Join(out, ",")
+	}
+
+	for _, archive := range []string{"/site.zip", "/site.tar.gz"} {
+		t.Run(archive, func(t *testing.T) {
+			root, err := fs.List(archive + "!/")
+			if err != nil {
+				t.Fatalf("Failed to list archive root: %v", err)
+			}
+			if got := names(root); got != "docs,readme.txt" {
+				t.Errorf("Unexpected root entries: %s", got)
+			}
+
+			nested, err := fs.List(archive + "!/docs/api/v1")
+			if err != nil {
+				t.Fatalf("Failed to list nested dir: %v", err)
+			}
+			if got := names(nested); got != "notes.txt,routes.json" {
+				t.Errorf("Unexpected nested entries: %s", got)
+			}
+			if nested[0].Path != archive+"!/docs/api/v1/notes.txt" {
+				t.Errorf("Unexpected virtual path: %s", nested[0].Path)
+			}
+
+			info, err := fs.Stat(archive + "!/docs/api")
+			if err != nil || !info.IsDir {
+				t.Errorf("Expected implied directory, got %+v, %v", info, err)
+			}
+
+			reader, err := fs.Read(archive + "!/docs/api/v1/notes.txt")
+			if err != nil {
+				t.Fatalf("Failed to read entry: %v", err)
+			}
+			content, _ := io.ReadAll(reader)
+			reader.Close()
+			if string(content) != "nested notes" {
+				t.Errorf("Unexpected content: %q", content)
+			}
+
+			if _, err := fs.List(archive + "!/missing"); err == nil {
+				t.Error("Expected error listing a missing directory")
+			}
+			if err := fs.Write(archive+"!/new.txt", strings.NewReader("x")); err == nil {
+				t.Error("Expected writes inside an archive to fail")
+			}
+		})
+	}
+
+	t.Run("Ordinary paths pass through", func(t *testing.T) {
+		files, err := fs.List("/")
+		if err != nil {
+			t.Fatalf("Failed to list: %v", err)
+		}
+		if got := names(files); !strings.Contains(got, "site.zip") {
+			t.Errorf("Expected archives in plain listing, got %s", got)
+		}
+	})
+}
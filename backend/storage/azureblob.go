@@ -0,0 +1,604 @@
+//go:build !basic
+// +build !basic
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+)
+
+// azureBlob is a blob, or with a delimited listing a virtual folder, in a
+// container
+type azureBlob struct {
+	Name     string
+	Size     int64
+	ModTime  time.Time
+	IsPrefix bool
+}
+
+// azureContainer is the subset of the container client used by
+// AzureBlobStorage. Errors for missing blobs wrap os.ErrNotExist.
+type azureContainer interface {
+	// ListBlobs calls fn for each blob whose name starts with prefix. A
+	// delimited listing stops at the next "/", reporting the folders
+	// below prefix as IsPrefix entries instead of their blobs.
+	ListBlobs(ctx context.Context, prefix string, delimited bool, fn func(azureBlob) error) error
+	GetProperties(ctx context.Context, name string) (azureBlob, error)
+	Download(ctx context.Context, name string) (io.ReadCloser, error)
+	Upload(ctx context.Context, name string, data io.Reader, contentType string) error
+	Delete(ctx context.Context, name string) error
+	Copy(ctx context.Context, src, dst string) error
+}
+
+// azureBlockSize is the block size for uploads. A blob holds at most
+// 50,000 blocks, so this allows blobs of up to about 400GB.
+const azureBlockSize = 8 << 20
+
+// AzureBlobStorage implements FileSystem on an Azure Blob Storage
+// container, optionally confined to a prefix within it
+type AzureBlobStorage struct {
+	client    azureContainer
+	account   string
+	container string
+	prefix    string
+	endpoint  string // empty for the public Azure endpoint
+	auth      string // "shared_key" or "sas"
+}
+
+var (
+	// azureAccountName is Azure's rule for storage account names, which
+	// become the host name of the public endpoint
+	azureAccountName = regexp.MustCompile(`^[a-z0-9]{3,24}$`)
+
+	// azureContainerName is Azure's rule for container names, apart from
+	// the ban on consecutive hyphens, plus the two special containers
+	azureContainerName = regexp.MustCompile(`^([a-z0-9][a-z0-9-]{1,61}[a-z0-9]|\$root|\$web)$`)
+)
+
+// AzureServiceURL returns the blob service URL of an account: endpoint
+// when it is set, otherwise the account's public endpoint. The account
+// name is checked so it can't change the host the URL points at.
+func AzureServiceURL(account, endpoint string) (string, error) {
+	if !azureAccountName.MatchString(account) {
+		return "", fmt.Errorf("invalid storage account name %q: use 3 to 24 lowercase letters and digits", account)
+	}
+	if endpoint != "" {
+		return strings.TrimSuffix(endpoint, "/"), nil
+	}
+	return fmt.Sprintf("https://%s.blob.core.windows.net", account), nil
+}
+
+// NewAzureBlobStorage connects to a container with either the account key
+// or a SAS token. endpoint overrides the account's public blob endpoint,
+// e.g. for Azurite or a sovereign cloud.
+func NewAzureBlobStorage(account, containerName, accountKey, sasToken, prefix, endpoint string) (*AzureBlobStorage, error) {
+	serviceURL, err := AzureServiceURL(account, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if !azureContainerName.MatchString(containerName) || strings.Contains(containerName, "--") {
+		return nil, fmt.Errorf("invalid container name %q", containerName)
+	}
+	containerURL := serviceURL + "/" + containerName
+
+	var client *container.Client
+	var auth string
+	switch {
+	case accountKey != "":
+		var cred *container.SharedKeyCredential
+		cred, err = container.NewSharedKeyCredential(account, accountKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid account key: %w", err)
+		}
+		client, err = container.NewClientWithSharedKeyCredential(containerURL, cred, nil)
+		auth = "shared_key"
+	case sasToken != "":
+		client, err = container.NewClientWithNoCredential(containerURL+"?"+strings.TrimPrefix(sasToken, "?"), nil)
+		auth = "sas"
+	default:
+		return nil, errors.New("an account key or a SAS token is required")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create container client: %w", err)
+	}
+
+	s := &AzureBlobStorage{
+		client:    &azureSDKContainer{client: client},
+		account:   account,
+		container: containerName,
+		prefix:    strings.Trim(prefix, "/"),
+		endpoint:  endpoint,
+		auth:      auth,
+	}
+
+	// Test the connection with a listing, which a SAS token scoped to the
+	// container can do where reading the container's properties may not be
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	errStop := errors.New("stop")
+	err = s.client.ListBlobs(ctx, "", true, func(azureBlob) error { return errStop })
+	if err != nil && err != errStop {
+		return nil, fmt.Errorf("failed to access container %s: %w", containerName, err)
+	}
+
+	return s, nil
+}
+
+// Info reports the container and how it is reached, never the credentials
+func (s *AzureBlobStorage) Info() map[string]interface{} {
+	info := map[string]interface{}{
+		"account":   s.account,
+		"container": s.container,
+		"prefix":    s.prefix,
+		"auth":      s.auth,
+	}
+	if s.endpoint != "" {
+		info["endpoint"] = s.endpoint
+	}
+	return info
+}
+
+// PathConventions reports that blob names are case-sensitive, and that
+// Azure turns a backslash in one into "/"
+func (s *AzureBlobStorage) PathConventions() PathConventions {
+	conventions := unixPathConventions
+	conventions.ReservedChars = "/\\"
+	return conventions
+}
+
+// GetType returns the storage type
+func (s *AzureBlobStorage) GetType() string {
+	return "azureblob"
+}
+
+// getFullPath maps a storage path to a blob name under the prefix
+func (s *AzureBlobStorage) getFullPath(p string) string {
+	p = strings.TrimPrefix(path.Clean("/"+p), "/")
+	if s.prefix != "" {
+		if p != "" {
+			return s.prefix + "/" + p
+		}
+		return s.prefix
+	}
+	return p
+}
+
+// dirPrefix is the name prefix shared by the blobs inside a directory
+func (s *AzureBlobStorage) dirPrefix(p string) string {
+	if fullPath := s.getFullPath(p); fullPath != "" {
+		return fullPath + "/"
+	}
+	return ""
+}
+
+// storagePath maps a blob name back to a storage path
+func (s *AzureBlobStorage) storagePath(name string) string {
+	if s.prefix != "" {
+		name = strings.TrimPrefix(name, s.prefix+"/")
+	}
+	return "/" + strings.TrimSuffix(name, "/")
+}
+
+// List lists the files and directories at dirPath
+func (s *AzureBlobStorage) List(dirPath string) ([]FileInfo, error) {
+	files := []FileInfo{}
+	err := s.ListFunc(dirPath, func(info FileInfo) error {
+		files = append(files, info)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// ListFunc streams a directory listing page by page. Like S3, folders are
+// the prefixes of a listing delimited by "/", so folders that only exist
+// because blobs are stored below them show up too.
+func (s *AzureBlobStorage) ListFunc(dirPath string, fn func(FileInfo) error) error {
+	prefix := s.dirPrefix(dirPath)
+
+	err := s.client.ListBlobs(context.Background(), prefix, true, func(b azureBlob) error {
+		// Skip the directory marker itself
+		if b.Name == prefix {
+			return nil
+		}
+		name := strings.TrimSuffix(strings.TrimPrefix(b.Name, prefix), "/")
+		if b.IsPrefix {
+			return fn(FileInfo{Name: name, Path: s.storagePath(b.Name), IsDir: true})
+		}
+		return fn(FileInfo{Name: name, Path: s.storagePath(b.Name), Size: b.Size, ModTime: b.ModTime})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list blobs: %w", err)
+	}
+	return nil
+}
+
+// Stat returns information about a file or directory
+func (s *AzureBlobStorage) Stat(filePath string) (FileInfo, error) {
+	fullPath := s.getFullPath(filePath)
+	if fullPath == s.prefix {
+		return FileInfo{Name: "/", Path: "/", IsDir: true}, nil
+	}
+
+	ctx := context.Background()
+	b, err := s.client.GetProperties(ctx, fullPath)
+	if err == nil {
+		return FileInfo{
+			Name:    path.Base(fullPath),
+			Path:    s.storagePath(fullPath),
+			Size:    b.Size,
+			ModTime: b.ModTime,
+		}, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return FileInfo{}, fmt.Errorf("failed to get blob properties: %w", err)
+	}
+
+	isDir, err := s.isDirectory(ctx, fullPath)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	if !isDir {
+		return FileInfo{}, fmt.Errorf("file not found: %s: %w", filePath, os.ErrNotExist)
+	}
+	return FileInfo{Name: path.Base(fullPath), Path: s.storagePath(fullPath), IsDir: true}, nil
+}
+
+// isDirectory reports whether any blob, including a directory marker, is
+// stored below fullPath
+func (s *AzureBlobStorage) isDirectory(ctx context.Context, fullPath string) (bool, error) {
+	found := false
+	errFound := errors.New("found")
+	err := s.client.ListBlobs(ctx, fullPath+"/", true, func(azureBlob) error {
+		found = true
+		return errFound
+	})
+	if err != nil && err != errFound {
+		return false, fmt.Errorf("failed to list blobs: %w", err)
+	}
+	return found, nil
+}
+
+// Read streams the content of a file
+func (s *AzureBlobStorage) Read(filePath string) (io.ReadCloser, error) {
+	fullPath := s.getFullPath(filePath)
+	if fullPath == s.prefix {
+		return nil, fmt.Errorf("cannot read directory: %s", filePath)
+	}
+
+	reader, err := s.client.Download(context.Background(), fullPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	return reader, nil
+}
+
+// Write uploads data to a file as it is read, in blocks
+func (s *AzureBlobStorage) Write(filePath string, data io.Reader) error {
+	fullPath := s.getFullPath(filePath)
+	if fullPath == s.prefix || strings.HasSuffix(filePath, "/") {
+		return fmt.Errorf("cannot write a file at directory path: %s", filePath)
+	}
+
+	if err := s.client.Upload(context.Background(), fullPath, data, blobContentType(fullPath)); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	return nil
+}
+
+// blobContentType picks the Content-Type stored with an uploaded blob,
+// from the same extension table S3 storages use
+func blobContentType(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	if contentType, ok := s3ContentTypes[ext]; ok {
+		return contentType
+	}
+	if contentType := mime.TypeByExtension(ext); contentType != "" {
+		return contentType
+	}
+	return "application/octet-stream"
+}
+
+// MkDir creates a directory marker, the same zero-byte "name/" blob S3
+// storages use, so empty directories survive
+func (s *AzureBlobStorage) MkDir(dirPath string) error {
+	prefix := s.dirPrefix(dirPath)
+	if prefix == "" || prefix == s.prefix+"/" {
+		return nil
+	}
+
+	err := s.client.Upload(context.Background(), prefix, strings.NewReader(""), s3DirectoryContentType)
+	if err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	return nil
+}
+
+// Delete deletes a file, or a directory with every blob below it
+func (s *AzureBlobStorage) Delete(filePath string) error {
+	fullPath := s.getFullPath(filePath)
+	if fullPath == s.prefix {
+		return errors.New("cannot delete the storage root")
+	}
+
+	ctx := context.Background()
+	err := s.client.Delete(ctx, fullPath)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete file: %w", err)
+	}
+
+	// Not a blob, so delete it as a directory
+	var names []string
+	err = s.client.ListBlobs(ctx, fullPath+"/", false, func(b azureBlob) error {
+		names = append(names, b.Name)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list blobs for deletion: %w", err)
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("file not found: %s: %w", filePath, os.ErrNotExist)
+	}
+	for _, name := range names {
+		if err := s.client.Delete(ctx, name); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to delete %s: %w", s.storagePath(name), err)
+		}
+	}
+	return nil
+}
+
+// Copy copies a file or directory with server-side blob copies, so the
+// data never passes through this server
+func (s *AzureBlobStorage) Copy(src, dst string, progress ProgressCallback) error {
+	info, err := s.Stat(src)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	srcPath, dstPath := s.getFullPath(src), s.getFullPath(dst)
+	if !info.IsDir {
+		if err := s.client.Copy(ctx, srcPath, dstPath); err != nil {
+			return fmt.Errorf("failed to copy file: %w", err)
+		}
+		if progress != nil {
+			progress(info.Size, info.Size)
+		}
+		return nil
+	}
+
+	if srcPath == s.prefix || strings.HasPrefix(dstPath+"/", srcPath+"/") {
+		return fmt.Errorf("cannot copy %s into itself", src)
+	}
+	var blobs []azureBlob
+	var total int64
+	err = s.client.ListBlobs(ctx, srcPath+"/", false, func(b azureBlob) error {
+		blobs = append(blobs, b)
+		total += b.Size
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list blobs: %w", err)
+	}
+
+	var copied int64
+	for _, b := range blobs {
+		target := dstPath + "/" + strings.TrimPrefix(b.Name, srcPath+"/")
+		if err := s.client.Copy(ctx, b.Name, target); err != nil {
+			return fmt.Errorf("failed to copy %s: %w", s.storagePath(b.Name), err)
+		}
+		copied += b.Size
+		if progress != nil {
+			progress(copied, total)
+		}
+	}
+	return nil
+}
+
+// Move copies then deletes, as blob storage has no rename
+func (s *AzureBlobStorage) Move(src, dst string) error {
+	if err := s.Copy(src, dst, nil); err != nil {
+		return err
+	}
+	return s.Delete(src)
+}
+
+// GetRootPath returns the root path of the storage
+func (s *AzureBlobStorage) GetRootPath() string {
+	return "/"
+}
+
+// GetAvailableSpace reports unlimited space, as containers have no quota
+// of their own
+func (s *AzureBlobStorage) GetAvailableSpace() (available, total int64, err error) {
+	return UnlimitedSpace, UnlimitedSpace, nil
+}
+
+// IsValidPath checks a path against the characters blob names can't
+// safely hold
+func (s *AzureBlobStorage) IsValidPath(p string) bool {
+	if strings.ContainsAny(p, "\\?#") {
+		return false
+	}
+	// Each segment becomes part of a blob name, which is capped at 1024
+	return len(s.getFullPath(p)) <= 1024
+}
+
+// JoinPath joins path components
+func (s *AzureBlobStorage) JoinPath(parts ...string) string {
+	return path.Join(parts...)
+}
+
+// ResolvePath resolves a path to its absolute form
+func (s *AzureBlobStorage) ResolvePath(p string) string {
+	return path.Clean("/" + p)
+}
+
+// azureSDKContainer implements azureContainer with the Azure SDK
+type azureSDKContainer struct {
+	client *container.Client
+}
+
+// azureError wraps the errors for missing blobs and refused requests in
+// their os equivalents
+func azureError(err error) error {
+	var respErr *azcore.ResponseError
+	if !errors.As(err, &respErr) {
+		return err
+	}
+	switch respErr.StatusCode {
+	case http.StatusNotFound:
+		return fmt.Errorf("%w: %v", os.ErrNotExist, err)
+	case http.StatusForbidden:
+		return fmt.Errorf("%w: %v", os.ErrPermission, err)
+	}
+	return err
+}
+
+func (c *azureSDKContainer) ListBlobs(ctx context.Context, prefix string, delimited bool, fn func(azureBlob) error) error {
+	var prefixOpt *string
+	if prefix != "" {
+		prefixOpt = &prefix
+	}
+
+	if delimited {
+		pager := c.client.NewListBlobsHierarchyPager("/", &container.ListBlobsHierarchyOptions{Prefix: prefixOpt})
+		for pager.More() {
+			page, err := pager.NextPage(ctx)
+			if err != nil {
+				return azureError(err)
+			}
+			for _, p := range page.Segment.BlobPrefixes {
+				if err := fn(azureBlob{Name: *p.Name, IsPrefix: true}); err != nil {
+					return err
+				}
+			}
+			for _, item := range page.Segment.BlobItems {
+				if err := fn(blobFromItem(item)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	pager := c.client.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{Prefix: prefixOpt})
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return azureError(err)
+		}
+		for _, item := range page.Segment.BlobItems {
+			if err := fn(blobFromItem(item)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// blobFromItem converts a listed blob
+func blobFromItem(item *container.BlobItem) azureBlob {
+	b := azureBlob{Name: *item.Name}
+	if props := item.Properties; props != nil {
+		if props.ContentLength != nil {
+			b.Size = *props.ContentLength
+		}
+		if props.LastModified != nil {
+			b.ModTime = *props.LastModified
+		}
+	}
+	return b
+}
+
+func (c *azureSDKContainer) GetProperties(ctx context.Context, name string) (azureBlob, error) {
+	props, err := c.client.NewBlobClient(name).GetProperties(ctx, nil)
+	if err != nil {
+		return azureBlob{}, azureError(err)
+	}
+	b := azureBlob{Name: name}
+	if props.ContentLength != nil {
+		b.Size = *props.ContentLength
+	}
+	if props.LastModified != nil {
+		b.ModTime = *props.LastModified
+	}
+	return b, nil
+}
+
+func (c *azureSDKContainer) Download(ctx context.Context, name string) (io.ReadCloser, error) {
+	resp, err := c.client.NewBlobClient(name).DownloadStream(ctx, nil)
+	if err != nil {
+		return nil, azureError(err)
+	}
+	return resp.Body, nil
+}
+
+func (c *azureSDKContainer) Upload(ctx context.Context, name string, data io.Reader, contentType string) error {
+	_, err := c.client.NewBlockBlobClient(name).UploadStream(ctx, data, &blockblob.UploadStreamOptions{
+		BlockSize:   azureBlockSize,
+		HTTPHeaders: &blob.HTTPHeaders{BlobContentType: &contentType},
+	})
+	return azureError(err)
+}
+
+func (c *azureSDKContainer) Delete(ctx context.Context, name string) error {
+	// A blob with snapshots can only be deleted together with them
+	include := blob.DeleteSnapshotsOptionTypeInclude
+	_, err := c.client.NewBlobClient(name).Delete(ctx, &blob.DeleteOptions{DeleteSnapshots: &include})
+	return azureError(err)
+}
+
+// Copy starts a server-side copy and waits for it, since large blobs are
+// copied asynchronously
+func (c *azureSDKContainer) Copy(ctx context.Context, src, dst string) error {
+	// The source URL carries the SAS token when there is one
+	source := c.client.NewBlobClient(src).URL()
+	target := c.client.NewBlobClient(dst)
+
+	resp, err := target.StartCopyFromURL(ctx, source, nil)
+	if err != nil {
+		return azureError(err)
+	}
+	status := resp.CopyStatus
+	for status != nil && *status == blob.CopyStatusTypePending {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(500 * time.Millisecond):
+		}
+		props, err := target.GetProperties(ctx, nil)
+		if err != nil {
+			return azureError(err)
+		}
+		if props.CopyStatus != nil && *props.CopyStatus != blob.CopyStatusTypePending && *props.CopyStatus != blob.CopyStatusTypeSuccess {
+			description := ""
+			if props.CopyStatusDescription != nil {
+				description = *props.CopyStatusDescription
+			}
+			return fmt.Errorf("copy %s: %s", *props.CopyStatus, description)
+		}
+		status = props.CopyStatus
+	}
+	return nil
+}
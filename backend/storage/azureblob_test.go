@@ -0,0 +1,290 @@
+//go:build !basic
+// +build !basic
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var _ FileSystem = (*AzureBlobStorage)(nil)
+
+// fakeAzureContainer keeps blobs in memory, listing them in name order
+// the way the service does
+type fakeAzureContainer struct {
+	mu           sync.Mutex
+	blobs        map[string][]byte
+	contentTypes map[string]string
+}
+
+func newFakeAzureContainer(names ...string) *fakeAzureContainer {
+	f := &fakeAzureContainer{blobs: make(map[string][]byte), contentTypes: make(map[string]string)}
+	for _, name := range names {
+		f.blobs[name] = []byte("data:" + name)
+	}
+	return f
+}
+
+func (f *fakeAzureContainer) ListBlobs(ctx context.Context, prefix string, delimited bool, fn func(azureBlob) error) error {
+	f.mu.Lock()
+	names := make([]string, 0, len(f.blobs))
+	for name := range f.blobs {
+		names = append(names, name)
+	}
+	f.mu.Unlock()
+	sort.Strings(names)
+
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if delimited {
+			if idx := strings.Index(name[len(prefix):], "/"); idx >= 0 {
+				folder := name[:len(prefix)+idx+1]
+				if !seen[folder] {
+					seen[folder] = true
+					if err := fn(azureBlob{Name: folder, IsPrefix: true}); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+		}
+		f.mu.Lock()
+		size := len(f.blobs[name])
+		f.mu.Unlock()
+		if err := fn(azureBlob{Name: name, Size: int64(size), ModTime: time.Unix(1700000000, 0)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *fakeAzureContainer) GetProperties(ctx context.Context, name string) (azureBlob, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, ok := f.blobs[name]
+	if !ok {
+		return azureBlob{}, fmt.Errorf("%w: BlobNotFound", os.ErrNotExist)
+	}
+	return azureBlob{Name: name, Size: int64(len(data))}, nil
+}
+
+func (f *fakeAzureContainer) Download(ctx context.Context, name string) (io.ReadCloser, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, ok := f.blobs[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: BlobNotFound", os.ErrNotExist)
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (f *fakeAzureContainer) Upload(ctx context.Context, name string, data io.Reader, contentType string) error {
+	content, err := io.ReadAll(data)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.blobs[name] = content
+	f.contentTypes[name] = contentType
+	return nil
+}
+
+func (f *fakeAzureContainer) Delete(ctx context.Context, name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.blobs[name]; !ok {
+		return fmt.Errorf("%w: BlobNotFound", os.ErrNotExist)
+	}
+	delete(f.blobs, name)
+	return nil
+}
+
+func (f *fakeAzureContainer) Copy(ctx context.Context, src, dst string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, ok := f.blobs[src]
+	if !ok {
+		return fmt.Errorf("%w: BlobNotFound", os.ErrNotExist)
+	}
+	f.blobs[dst] = append([]byte(nil), data...)
+	return nil
+}
+
+func (f *fakeAzureContainer) names() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	names := make([]string, 0, len(f.blobs))
+	for name := range f.blobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestAzureBlobStorage_List(t *testing.T) {
+	fake := newFakeAzureContainer(
+		"other/outside.txt",
+		"backups/",
+		"backups/readme.txt",
+		"backups/2024/",
+		"backups/2024/jan.tar.gz",
+		"backups/2025/feb.tar.gz", // a folder with no marker blob
+		"backups/empty/",
+	)
+	s := &AzureBlobStorage{client: fake, container: "archives", prefix: "backups"}
+
+	files, err := s.List("/")
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	got := make([]string, 0, len(files))
+	for _, f := range files {
+		got = append(got, fmt.Sprintf("%s %s %v", f.Name, f.Path, f.IsDir))
+	}
+	want := []string{"2024 /2024 true", "2025 /2025 true", "empty /empty true", "readme.txt /readme.txt false"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+
+	files, err = s.List("/2024")
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(files) != 1 || files[0].Path != "/2024/jan.tar.gz" || files[0].Size != int64(len("data:backups/2024/jan.tar.gz")) {
+		t.Errorf("Expected only jan.tar.gz without the marker, got %+v", files)
+	}
+
+	for _, tt := range []struct {
+		path  string
+		isDir bool
+	}{
+		{"/", true},
+		{"/2025", true},
+		{"/empty", true},
+		{"/readme.txt", false},
+	} {
+		info, err := s.Stat(tt.path)
+		if err != nil {
+			t.Errorf("Stat(%s) failed: %v", tt.path, err)
+			continue
+		}
+		if info.IsDir != tt.isDir {
+			t.Errorf("Stat(%s): expected IsDir=%v, got %+v", tt.path, tt.isDir, info)
+		}
+	}
+	if _, err := s.Stat("/outside.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected ErrNotExist outside the prefix, got %v", err)
+	}
+}
+
+func TestAzureBlobStorage_ReadWrite(t *testing.T) {
+	fake := newFakeAzureContainer()
+	s := &AzureBlobStorage{client: fake, container: "archives", prefix: "backups"}
+
+	if err := s.MkDir("/docs"); err != nil {
+		t.Fatalf("MkDir failed: %v", err)
+	}
+	if err := s.Write("/docs/notes.txt", strings.NewReader("hello")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := s.Write("/docs/", strings.NewReader("x")); err == nil {
+		t.Error("Expected an error writing a file at a directory path")
+	}
+
+	if got := fake.names(); strings.Join(got, ",") != "backups/docs/,backups/docs/notes.txt" {
+		t.Errorf("Unexpected blobs %q", got)
+	}
+	if ct := fake.contentTypes["backups/docs/notes.txt"]; !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Expected a text/plain content type, got %q", ct)
+	}
+	if ct := fake.contentTypes["backups/docs/"]; ct != s3DirectoryContentType {
+		t.Errorf("Expected the directory content type for the marker, got %q", ct)
+	}
+
+	reader, err := s.Read("/docs/notes.txt")
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	defer reader.Close()
+	if data, _ := io.ReadAll(reader); string(data) != "hello" {
+		t.Errorf("Expected hello, got %q", data)
+	}
+
+	if _, err := s.Read("/missing.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected ErrNotExist, got %v", err)
+	}
+}
+
+func TestAzureBlobStorage_CopyMoveDelete(t *testing.T) {
+	fake := newFakeAzureContainer("src/", "src/a.txt", "src/sub/b.txt", "file.txt")
+	s := &AzureBlobStorage{client: fake, container: "archives"}
+
+	var last, total int64
+	if err := s.Copy("/src", "/dst", func(current, t int64) { last, total = current, t }); err != nil {
+		t.Fatalf("Copy failed: %v", err)
+	}
+	if last != total || total == 0 {
+		t.Errorf("Expected progress to reach the total, got %d/%d", last, total)
+	}
+	if err := s.Copy("/src", "/src/sub/again", nil); err == nil {
+		t.Error("Expected an error copying a directory into itself")
+	}
+
+	if err := s.Move("/file.txt", "/dst/file.txt"); err != nil {
+		t.Fatalf("Move failed: %v", err)
+	}
+	if err := s.Delete("/src"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	want := "dst/,dst/a.txt,dst/file.txt,dst/sub/b.txt"
+	if got := strings.Join(fake.names(), ","); got != want {
+		t.Errorf("Expected blobs %s, got %s", want, got)
+	}
+
+	if err := s.Delete("/missing"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected ErrNotExist deleting a missing path, got %v", err)
+	}
+	if err := s.Delete("/"); err == nil {
+		t.Error("Expected deleting the root to fail")
+	}
+}
+
+func TestNewAzureBlobStorage_RequiresCredentials(t *testing.T) {
+	if _, err := NewAzureBlobStorage("acme", "archives", "", "", "", ""); err == nil {
+		t.Error("Expected an error without an account key or SAS token")
+	}
+}
+
+func TestNewAzureBlobStorage_InvalidNames(t *testing.T) {
+	for _, tt := range []struct{ account, container string }{
+		{"10.0.0.1/x?", "archives"},
+		{"host#", "archives"},
+		{"Acme", "archives"},
+		{"acme", "Archives"},
+		{"acme", "arch--ives"},
+		{"acme", "-archives"},
+		{"acme", "a/b"},
+	} {
+		if _, err := NewAzureBlobStorage(tt.account, tt.container, "a2V5", "", "", ""); err == nil || !strings.Contains(err.Error(), "invalid") {
+			t.Errorf("Expected %s/%s to be refused, got %v", tt.account, tt.container, err)
+		}
+	}
+	if url, err := AzureServiceURL("acme", ""); err != nil || url != "https://acme.blob.core.windows.net" {
+		t.Errorf("Expected the public endpoint, got %q %v", url, err)
+	}
+}
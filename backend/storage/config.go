@@ -0,0 +1,271 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// CommonConfig holds the settings every backend accepts
+type CommonConfig struct {
+	CaseInsensitive bool `json:"case_insensitive"`
+}
+
+// LocalConfig configures a "local" storage
+type LocalConfig struct {
+	CommonConfig
+	RootPath       string `json:"root_path"`
+	PreserveXattrs bool   `json:"preserve_xattrs"`
+}
+
+// S3Config configures an "s3" storage
+type S3Config struct {
+	CommonConfig
+	Bucket           string            `json:"bucket" config:"required"`
+	Region           string            `json:"region"`
+	Prefix           string            `json:"prefix"`
+	AccessKey        string            `json:"access_key"`
+	SecretKey        string            `json:"secret_key"`
+	Endpoint         string            `json:"endpoint"`
+	BypassGovernance bool              `json:"bypass_governance"`
+	ContentTypes     map[string]string `json:"content_types"`
+
+	// ReportUsage adds up the objects under the prefix to report as used
+	// space, against Quota bytes when it is set
+	ReportUsage bool  `json:"report_usage"`
+	Quota       int64 `json:"quota"`
+}
+
+// AzureBlobConfig configures an "azureblob" storage. One of AccountKey
+// and SASToken is needed.
+type AzureBlobConfig struct {
+	CommonConfig
+	Account    string `json:"account" config:"required"`
+	Container  string `json:"container" config:"required"`
+	AccountKey string `json:"account_key"`
+	SASToken   string `json:"sas_token"`
+	Prefix     string `json:"prefix"`
+	Endpoint   string `json:"endpoint"`
+}
+
+// OAuthConfig holds the credentials of the "gdrive" and "onedrive" storages
+type OAuthConfig struct {
+	CommonConfig
+	ClientID     string `json:"client_id" config:"required"`
+	ClientSecret string `json:"client_secret" config:"required"`
+	RefreshToken string `json:"refresh_token" config:"required"`
+}
+
+// GDriveConfig configures a "gdrive" storage
+type GDriveConfig struct {
+	OAuthConfig
+	PermanentDelete bool `json:"permanent_delete"`
+}
+
+// OneDriveConfig configures a "onedrive" storage
+type OneDriveConfig struct {
+	OAuthConfig
+	UploadRetries int `json:"upload_retries"`
+}
+
+// FTPConfig configures the "ftp" and "sftp" storages
+type FTPConfig struct {
+	CommonConfig
+	Host             string `json:"host" config:"required"`
+	Port             string `json:"port"`
+	Username         string `json:"username"`
+	Password         string `json:"password"`
+	RootPath         string `json:"root_path"`
+	WriteConcurrency int    `json:"write_concurrency"`
+
+	// HostKeyFingerprint pins the SFTP server's key, e.g.
+	// "SHA256:uNiVztksCsDhcc0u9e8BujQXVUpKZIDTMczCvj3tD2s"
+	HostKeyFingerprint string `json:"host_key_fingerprint"`
+
+	// PrivateKey is a PEM private key for SFTP, tried before Password.
+	// Passphrase decrypts it if it is encrypted.
+	PrivateKey string `json:"private_key"`
+	Passphrase string `json:"passphrase"`
+}
+
+// WebDAVConfig configures a "webdav" storage
+type WebDAVConfig struct {
+	CommonConfig
+	BaseURL  string `json:"base_url" config:"required"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	RootPath string `json:"root_path"`
+}
+
+// NFSConfig configures an "nfs" storage
+type NFSConfig struct {
+	CommonConfig
+	Server     string `json:"server" config:"required"`
+	ExportPath string `json:"export_path" config:"required"`
+	MountPoint string `json:"mount_point"`
+	ReadOnly   bool   `json:"read_only"`
+
+	// IdleTimeout unmounts the share after this many seconds unused; it is
+	// mounted again on the next request. Zero keeps it mounted.
+	IdleTimeout int `json:"idle_timeout"`
+}
+
+// RDBConfig configures a "redis" or "rdb" storage
+type RDBConfig struct {
+	CommonConfig
+	Address   string `json:"address" config:"required"`
+	Password  string `json:"password"`
+	DB        int    `json:"db"`
+	Namespace string `json:"namespace"`
+}
+
+// ConfigError lists everything wrong with a storage's settings
+type ConfigError struct {
+	ID       string
+	Type     string
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("invalid %s config for storage %s: %s", e.Type, e.ID, strings.Join(e.Problems, "; "))
+}
+
+// newBackendConfig returns the typed config for a storage type with its
+// defaults filled in
+func newBackendConfig(storageType string) (interface{}, bool) {
+	switch storageType {
+	case "local":
+		return &LocalConfig{RootPath: "/"}, true
+	case "s3":
+		return &S3Config{}, true
+	case "azureblob":
+		return &AzureBlobConfig{}, true
+	case "gdrive":
+		return &GDriveConfig{}, true
+	case "onedrive":
+		return &OneDriveConfig{}, true
+	case "ftp", "sftp":
+		return &FTPConfig{}, true
+	case "webdav":
+		return &WebDAVConfig{}, true
+	case "nfs":
+		return &NFSConfig{}, true
+	case "redis", "rdb":
+		return &RDBConfig{}, true
+	}
+	return nil, false
+}
+
+// ParseConfig decodes cfg.Config into the typed config for cfg.Type, e.g.
+// *S3Config for "s3". Unknown keys, values of the wrong type and missing
+// required settings are all reported together in a *ConfigError.
+func ParseConfig(cfg StorageConfig) (interface{}, error) {
+	target, ok := newBackendConfig(cfg.Type)
+	if !ok {
+		return nil, fmt.Errorf("unknown storage type: %s", cfg.Type)
+	}
+
+	fields := configFields(reflect.ValueOf(target).Elem())
+	var problems []string
+	invalid := make(map[string]bool)
+
+	keys := make([]string, 0, len(cfg.Config))
+	for key := range cfg.Config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := cfg.Config[key]
+		field, ok := fields[key]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("unknown setting %q", key))
+			continue
+		}
+		if value == nil {
+			continue
+		}
+		raw, err := json.Marshal(value)
+		if err == nil {
+			err = json.Unmarshal(raw, field.Addr().Interface())
+		}
+		if err != nil {
+			invalid[key] = true
+			problems = append(problems, fmt.Sprintf("%s must be %s, got %s", key, describeKind(field.Type()), describeJSON(value)))
+		}
+	}
+
+	for key, field := range fields {
+		if field.required && field.IsZero() && !invalid[key] {
+			problems = append(problems, fmt.Sprintf("%s is required", key))
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return nil, &ConfigError{ID: cfg.ID, Type: cfg.Type, Problems: problems}
+	}
+	return target, nil
+}
+
+// configField is a settable field of a typed config
+type configField struct {
+	reflect.Value
+	required bool
+}
+
+// configFields maps the JSON names of v's fields, including those of
+// embedded structs, to the fields themselves
+func configFields(v reflect.Value) map[string]configField {
+	fields := make(map[string]configField)
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.Anonymous && sf.Type.Kind() == reflect.Struct {
+			for name, field := range configFields(v.Field(i)) {
+				fields[name] = field
+			}
+			continue
+		}
+		name, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		fields[name] = configField{Value: v.Field(i), required: sf.Tag.Get("config") == "required"}
+	}
+	return fields
+}
+
+// describeKind names the JSON type a config field expects
+func describeKind(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return "an integer"
+	case reflect.Map:
+		return "an object of " + strings.TrimPrefix(describeKind(t.Elem()), "a ") + "s"
+	}
+	return t.String()
+}
+
+// describeJSON names the JSON type of a decoded value
+func describeJSON(value interface{}) string {
+	switch value.(type) {
+	case string:
+		return "a string"
+	case bool:
+		return "a boolean"
+	case float64:
+		return fmt.Sprintf("the number %v", value)
+	case map[string]interface{}:
+		return "an object"
+	case []interface{}:
+		return "an array"
+	}
+	return fmt.Sprintf("%T", value)
+}
@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      StorageConfig
+		want     interface{}
+		problems []string
+	}{
+		{
+			name: "local defaults",
+			cfg:  StorageConfig{ID: "local", Type: "local"},
+			want: &LocalConfig{RootPath: "/"},
+		},
+		{
+			name: "s3",
+			cfg: StorageConfig{ID: "s3", Type: "s3", Config: map[string]interface{}{
+				"bucket": "media", "region": "eu-west-1", "bypass_governance": true,
+				"content_types": map[string]interface{}{".log": "text/plain"},
+			}},
+			want: &S3Config{Bucket: "media", Region: "eu-west-1", BypassGovernance: true, ContentTypes: map[string]string{".log": "text/plain"}},
+		},
+		{
+			name: "azureblob",
+			cfg: StorageConfig{ID: "archive", Type: "azureblob", Config: map[string]interface{}{
+				"account": "acme", "container": "archives", "sas_token": "sv=2022-11-02&sig=abc", "prefix": "backups",
+			}},
+			want: &AzureBlobConfig{Account: "acme", Container: "archives", SASToken: "sv=2022-11-02&sig=abc", Prefix: "backups"},
+		},
+		{
+			name: "sftp with numeric settings",
+			cfg: StorageConfig{ID: "sftp", Type: "sftp", Config: map[string]interface{}{
+				"host": "sftp.example.com", "port": "22", "write_concurrency": float64(16), "case_insensitive": true,
+				"host_key_fingerprint": "SHA256:uNiVztksCsDhcc0u9e8BujQXVUpKZIDTMczCvj3tD2s",
+			}},
+			want: &FTPConfig{CommonConfig: CommonConfig{CaseInsensitive: true}, Host: "sftp.example.com", Port: "22", WriteConcurrency: 16,
+				HostKeyFingerprint: "SHA256:uNiVztksCsDhcc0u9e8BujQXVUpKZIDTMczCvj3tD2s"},
+		},
+		{
+			name: "gdrive permanent delete",
+			cfg: StorageConfig{ID: "drive", Type: "gdrive", Config: map[string]interface{}{
+				"client_id": "id", "client_secret": "secret", "refresh_token": "token", "permanent_delete": true,
+			}},
+			want: &GDriveConfig{OAuthConfig: OAuthConfig{ClientID: "id", ClientSecret: "secret", RefreshToken: "token"}, PermanentDelete: true},
+		},
+		{
+			name: "onedrive upload retries",
+			cfg: StorageConfig{ID: "od", Type: "onedrive", Config: map[string]interface{}{
+				"client_id": "id", "client_secret": "secret", "refresh_token": "token", "upload_retries": float64(8),
+			}},
+			want: &OneDriveConfig{OAuthConfig: OAuthConfig{ClientID: "id", ClientSecret: "secret", RefreshToken: "token"}, UploadRetries: 8},
+		},
+		{
+			name:     "s3 missing bucket and misspelled key",
+			cfg:      StorageConfig{ID: "s3", Type: "s3", Config: map[string]interface{}{"acess_key": "AKIA"}},
+			problems: []string{"bucket is required", `unknown setting "acess_key"`},
+		},
+		{
+			name: "s3 content types not strings",
+			cfg: StorageConfig{ID: "s3", Type: "s3", Config: map[string]interface{}{
+				"bucket": "media", "content_types": map[string]interface{}{".log": float64(1)},
+			}},
+			problems: []string{"content_types must be an object of strings, got an object"},
+		},
+		{
+			name:     "azureblob missing container",
+			cfg:      StorageConfig{ID: "archive", Type: "azureblob", Config: map[string]interface{}{"account": "acme"}},
+			problems: []string{"container is required"},
+		},
+		{
+			name:     "gdrive missing credentials",
+			cfg:      StorageConfig{ID: "drive", Type: "gdrive", Config: map[string]interface{}{"client_id": "id"}},
+			problems: []string{"client_secret is required", "refresh_token is required"},
+		},
+		{
+			name:     "onedrive wrong types",
+			cfg:      StorageConfig{ID: "od", Type: "onedrive", Config: map[string]interface{}{"client_id": float64(42), "client_secret": "s", "refresh_token": true}},
+			problems: []string{"client_id must be a string, got the number 42", "refresh_token must be a string, got a boolean"},
+		},
+		{
+			name:     "ftp numeric port",
+			cfg:      StorageConfig{ID: "ftp", Type: "ftp", Config: map[string]interface{}{"host": "ftp.example.com", "port": float64(21)}},
+			problems: []string{"port must be a string, got the number 21"},
+		},
+		{
+			name:     "sftp fractional concurrency",
+			cfg:      StorageConfig{ID: "sftp", Type: "sftp", Config: map[string]interface{}{"host": "h", "write_concurrency": 2.5}},
+			problems: []string{"write_concurrency must be an integer, got the number 2.5"},
+		},
+		{
+			name:     "webdav missing url",
+			cfg:      StorageConfig{ID: "dav", Type: "webdav", Config: map[string]interface{}{"username": "u"}},
+			problems: []string{"base_url is required"},
+		},
+		{
+			name:     "nfs read_only as string",
+			cfg:      StorageConfig{ID: "nfs", Type: "nfs", Config: map[string]interface{}{"server": "nas", "read_only": "yes"}},
+			problems: []string{"export_path is required", "read_only must be a boolean, got a string"},
+		},
+		{
+			name:     "redis db as string",
+			cfg:      StorageConfig{ID: "rdb", Type: "redis", Config: map[string]interface{}{"address": "localhost:6379", "db": "1"}},
+			problems: []string{"db must be an integer, got a string"},
+		},
+		{
+			name:     "local case_insensitive as string",
+			cfg:      StorageConfig{ID: "local", Type: "local", Config: map[string]interface{}{"case_insensitive": "true"}},
+			problems: []string{"case_insensitive must be a boolean, got a string"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseConfig(tt.cfg)
+			if tt.problems == nil {
+				if err != nil {
+					t.Fatalf("Failed to parse config: %v", err)
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("Expected %+v, got %+v", tt.want, got)
+				}
+				return
+			}
+
+			var configErr *ConfigError
+			if !errors.As(err, &configErr) {
+				t.Fatalf("Expected a ConfigError, got %v", err)
+			}
+			if !reflect.DeepEqual(configErr.Problems, tt.problems) {
+				t.Errorf("Expected problems %q, got %q", tt.problems, configErr.Problems)
+			}
+			if !strings.Contains(err.Error(), tt.cfg.ID) {
+				t.Errorf("Expected the storage ID in %q", err.Error())
+			}
+		})
+	}
+
+	t.Run("unknown type", func(t *testing.T) {
+		if _, err := ParseConfig(StorageConfig{ID: "x", Type: "dropbox"}); err == nil {
+			t.Error("Expected an error for an unknown storage type")
+		}
+	})
+}
@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"log"
+)
+
+// testReleaser is implemented by backends that must not be closed outright
+// after a connection test, because they may share state with a configured
+// storage
+type testReleaser interface {
+	releaseTest() error
+}
+
+// testBackend builds a backend with build and lists its root to check it
+// can be reached, then releases it. It returns ctx's error if ctx is done
+// first; a backend still being built then is released once it's ready.
+func testBackend(ctx context.Context, build func() (FileSystem, error)) error {
+	done := make(chan error, 1)
+	go func() {
+		fs, err := build()
+		if err == nil {
+			_, err = fs.List("/")
+			releaseTestBackend(fs)
+		}
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// releaseTestBackend closes what a backend built for a test holds, like a
+// connection or a mount
+func releaseTestBackend(fs FileSystem) {
+	var err error
+	if r, ok := fs.(testReleaser); ok {
+		err = r.releaseTest()
+	} else if closer, ok := fs.(io.Closer); ok {
+		err = closer.Close()
+	}
+	if err != nil {
+		log.Printf("Error releasing %s storage after connection test: %v", fs.GetType(), err)
+	}
+}
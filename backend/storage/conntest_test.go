@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// closeCountingFileSystem reports when it is closed
+type closeCountingFileSystem struct {
+	*LocalStorage
+	closed chan struct{}
+}
+
+func (c *closeCountingFileSystem) Close() error {
+	close(c.closed)
+	return nil
+}
+
+func TestTestBackend(t *testing.T) {
+	fs := &closeCountingFileSystem{NewLocalStorage(t.TempDir()), make(chan struct{})}
+	if err := testBackend(context.Background(), func() (FileSystem, error) { return fs, nil }); err != nil {
+		t.Fatalf("Expected the test to pass, got %v", err)
+	}
+	select {
+	case <-fs.closed:
+	default:
+		t.Error("Expected the backend to be closed after the test")
+	}
+
+	refused := errors.New("connection refused")
+	if err := testBackend(context.Background(), func() (FileSystem, error) { return nil, refused }); !errors.Is(err, refused) {
+		t.Errorf("Expected the construction error, got %v", err)
+	}
+
+	// A backend that takes too long to build is abandoned, and closed once
+	// it's ready
+	slow := &closeCountingFileSystem{NewLocalStorage(t.TempDir()), make(chan struct{})}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := testBackend(ctx, func() (FileSystem, error) {
+		time.Sleep(200 * time.Millisecond)
+		return slow, nil
+	})
+	if !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > 150*time.Millisecond {
+		t.Errorf("Expected the test to time out early, got %v after %v", err, time.Since(start))
+	}
+	select {
+	case <-slow.closed:
+	case <-time.After(2 * time.Second):
+		t.Error("Expected the abandoned backend to be closed")
+	}
+}
@@ -0,0 +1,41 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+)
+
+// The constructors both builds provide, with the signatures both must
+// have. This file has no build tag, so a mismatch in either build fails to
+// compile.
+var (
+	_ func(clientID, clientSecret, refreshToken string) (FileSystem, error)                                                           = NewGDriveAdapter
+	_ func(clientID, clientSecret, refreshToken string) (FileSystem, error)                                                           = NewOneDriveAdapter
+	_ func(protocol, host, port, username, password, privateKey, passphrase, rootPath, hostKeyFingerprint string) (FileSystem, error) = NewFTPAdapter
+	_ func(baseURL, username, password, rootPath string) (FileSystem, error)                                                          = NewWebDAVAdapter
+	_ func(bucket, region, prefix, accessKey, secretKey, endpoint string) (*S3FileSystem, error)                                      = NewS3FileSystem
+	_ func(rootPath string) *LocalStorage                                                                                             = NewLocalStorage
+)
+
+func TestAdapterConstructors(t *testing.T) {
+	if !basicBuild {
+		t.Skip("The full build's adapters connect to real services")
+	}
+
+	constructors := map[string]func() (FileSystem, error){
+		"gdrive":   func() (FileSystem, error) { return NewGDriveAdapter("id", "secret", "token") },
+		"onedrive": func() (FileSystem, error) { return NewOneDriveAdapter("id", "secret", "token") },
+		"ftp":      func() (FileSystem, error) { return NewFTPAdapter("ftp", "host", "21", "user", "pass", "", "", "/", "") },
+		"webdav":   func() (FileSystem, error) { return NewWebDAVAdapter("https://dav.example.com", "user", "pass", "/") },
+	}
+	for name, construct := range constructors {
+		fs, err := construct()
+		if err == nil || fs != nil {
+			t.Errorf("%s: expected an error and no storage in the basic build, got %v, %v", name, fs, err)
+			continue
+		}
+		if !strings.Contains(err.Error(), "not available in basic build") {
+			t.Errorf("%s: unexpected error %v", name, err)
+		}
+	}
+}
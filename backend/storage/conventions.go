@@ -0,0 +1,58 @@
+package storage
+
+// PathConventions describes how a backend's paths are built, so clients
+// can join and validate them without assuming every storage behaves like
+// a Unix filesystem. Paths in the API always use Separator, whatever the
+// backend uses underneath.
+type PathConventions struct {
+	// Separator joins path components
+	Separator string `json:"separator"`
+
+	// CaseSensitive is false when names differing only in case refer to
+	// the same file
+	CaseSensitive bool `json:"case_sensitive"`
+
+	// Root is the path of the top of the storage
+	Root string `json:"root"`
+
+	// ReservedNames can't be used as a file or directory name, compared
+	// without regard to case. ReservedChars can't appear in a name.
+	ReservedNames []string `json:"reserved_names"`
+	ReservedChars string   `json:"reserved_chars"`
+}
+
+// PathConventionReporter is implemented by backends whose paths differ from
+// the defaults PathConventionsOf reports
+type PathConventionReporter interface {
+	PathConventions() PathConventions
+}
+
+// unixPathConventions are those of a POSIX filesystem, where only the
+// separator and NUL are off limits
+var unixPathConventions = PathConventions{
+	Separator:     "/",
+	CaseSensitive: true,
+	Root:          "/",
+	ReservedNames: []string{},
+	ReservedChars: "/\x00",
+}
+
+// windowsReservedNames are the device names Windows refuses as file names,
+// with or without an extension
+var windowsReservedNames = []string{
+	"CON", "PRN", "AUX", "NUL",
+	"COM1", "COM2", "COM3", "COM4", "COM5", "COM6", "COM7", "COM8", "COM9",
+	"LPT1", "LPT2", "LPT3", "LPT4", "LPT5", "LPT6", "LPT7", "LPT8", "LPT9",
+}
+
+// windowsReservedChars can't appear in a Windows file name
+const windowsReservedChars = "<>:\"/\\|?*\x00"
+
+// PathConventionsOf returns the path conventions of fs. Backends that
+// don't report their own are taken to follow Unix conventions.
+func PathConventionsOf(fs FileSystem) PathConventions {
+	if pr, ok := As[PathConventionReporter](fs); ok {
+		return pr.PathConventions()
+	}
+	return unixPathConventions
+}
@@ -0,0 +1,74 @@
+//go:build !basic
+// +build !basic
+
+package storage
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestPathConventions(t *testing.T) {
+	installFakeMounts(t)
+	nfs, err := NewNFSStorage("nas", "/export", t.TempDir(), false)
+	if err != nil {
+		t.Fatalf("Failed to create NFS storage: %v", err)
+	}
+	defer nfs.Close()
+
+	localCaseSensitive := runtime.GOOS != "windows" && runtime.GOOS != "darwin"
+
+	tests := []struct {
+		name          string
+		fs            FileSystem
+		caseSensitive bool
+		reservedChars string
+		reservedName  string
+	}{
+		{name: "local", fs: NewLocalStorage(t.TempDir()), caseSensitive: localCaseSensitive, reservedChars: "/"},
+		{name: "nfs", fs: nfs, caseSensitive: true, reservedChars: "/\x00"},
+		{name: "s3", fs: &S3FileSystem{S3Storage: &S3Storage{}}, caseSensitive: true, reservedChars: "/"},
+		{name: "s3 case-insensitive", fs: &S3FileSystem{S3Storage: &S3Storage{caseInsensitive: true}}, caseSensitive: false, reservedChars: "/"},
+		{name: "azureblob", fs: &AzureBlobStorage{}, caseSensitive: true, reservedChars: "/\\"},
+		{name: "gdrive", fs: &GDriveAdapter{&GDriveStorage{}}, caseSensitive: true, reservedChars: "/"},
+		{name: "gdrive case-insensitive", fs: &GDriveAdapter{&GDriveStorage{caseInsensitive: true}}, caseSensitive: false, reservedChars: "/"},
+		{name: "onedrive", fs: &OneDriveAdapter{&OneDriveStorage{}}, caseSensitive: false, reservedChars: `"*:<>?/\|`, reservedName: "desktop.ini"},
+		{name: "ftp", fs: &FTPAdapter{&FTPStorage{protocol: "ftp"}}, caseSensitive: true, reservedChars: "/\x00"},
+		{name: "webdav", fs: &WebDAVAdapter{&WebDAVStorage{rootPath: "/dav"}}, caseSensitive: true, reservedChars: "/\x00"},
+		{name: "redis", fs: &RDBStorage{}, caseSensitive: true, reservedChars: "/\x00"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Through the stats wrapper, as the manager hands storages out
+			conventions := PathConventionsOf(NewStatsFileSystem(tt.fs))
+			if conventions.Separator != "/" || conventions.Root != "/" {
+				t.Errorf("Expected API paths separated by / from root /, got %q and %q", conventions.Separator, conventions.Root)
+			}
+			if conventions.CaseSensitive != tt.caseSensitive {
+				t.Errorf("Expected case_sensitive=%v, got %v", tt.caseSensitive, conventions.CaseSensitive)
+			}
+			for _, c := range tt.reservedChars {
+				if !strings.ContainsRune(conventions.ReservedChars, c) {
+					t.Errorf("Expected %q among the reserved characters %q", c, conventions.ReservedChars)
+				}
+			}
+			if conventions.ReservedNames == nil {
+				t.Error("Expected reserved names to be a list, even an empty one")
+			}
+			if tt.reservedName != "" && !containsFold(conventions.ReservedNames, tt.reservedName) {
+				t.Errorf("Expected %s among the reserved names %v", tt.reservedName, conventions.ReservedNames)
+			}
+		})
+	}
+}
+
+func containsFold(names []string, name string) bool {
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,270 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultDeleteConcurrency is the number of delete requests kept in flight
+// when removing a directory tree item by item
+const DefaultDeleteConcurrency = 8
+
+var deleteConcurrency atomic.Int32
+
+// SetDeleteConcurrency sets how many delete requests DeleteTree issues in
+// parallel. Values below 1 restore DefaultDeleteConcurrency.
+func SetDeleteConcurrency(n int) {
+	if n < 1 {
+		n = DefaultDeleteConcurrency
+	}
+	deleteConcurrency.Store(int32(n))
+}
+
+// DeleteConcurrency returns the number of parallel delete requests
+func DeleteConcurrency() int {
+	if n := deleteConcurrency.Load(); n > 0 {
+		return int(n)
+	}
+	return DefaultDeleteConcurrency
+}
+
+// ErrRateLimited is wrapped by backend errors caused by the provider
+// throttling requests. Such calls are worth retrying after a pause.
+var ErrRateLimited = errors.New("rate limited by provider")
+
+// retryAttempts and retryBaseDelay bound withBackoff: the delay doubles
+// after every rate-limited attempt
+var (
+	retryAttempts  = 5
+	retryBaseDelay = 250 * time.Millisecond
+	retryMaxDelay  = 8 * time.Second
+)
+
+// withBackoff calls op until it succeeds, fails with an error other than
+// ErrRateLimited, or runs out of attempts
+func withBackoff(op func() error) error {
+	delay := retryBaseDelay
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = op()
+		if err == nil || !errors.Is(err, ErrRateLimited) || attempt >= retryAttempts {
+			return err
+		}
+		time.Sleep(delay)
+		delay = min(delay*2, retryMaxDelay)
+	}
+}
+
+// DeleteError reports the items a tree delete could not remove, keyed by
+// path
+type DeleteError struct {
+	Failed map[string]error
+}
+
+func (e *DeleteError) Error() string {
+	paths := make([]string, 0, len(e.Failed))
+	for p := range e.Failed {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	const shown = 3
+	var details []string
+	for _, p := range paths[:min(len(paths), shown)] {
+		details = append(details, fmt.Sprintf("%s: %v", p, e.Failed[p]))
+	}
+	if len(paths) > shown {
+		details = append(details, fmt.Sprintf("and %d more", len(paths)-shown))
+	}
+	return fmt.Sprintf("failed to delete %d items (%s)", len(paths), strings.Join(details, "; "))
+}
+
+// treeDeleter removes a directory tree one item at a time with a bounded
+// number of requests in flight
+type treeDeleter struct {
+	list        func(dirPath string) ([]FileInfo, error)
+	remove      func(itemPath string, isDir bool) error
+	exclude     *ExcludeFilter
+	concurrency int
+}
+
+// deleteDir removes everything under root and then root itself. Entries
+// matching the exclusions are left in place, as are the directories that
+// hold them; kept reports whether root is one of those.
+func (d *treeDeleter) deleteDir(root string) (kept bool, err error) {
+	var (
+		files []string
+		// dirs holds the directories below root by depth, deepest last
+		dirs     [][]string
+		keptDirs = make(map[string]bool)
+	)
+
+	level := []string{root}
+	for len(level) > 0 {
+		var next []string
+		for _, dir := range level {
+			entries, err := d.list(dir)
+			if err != nil {
+				return false, err
+			}
+			for _, entry := range entries {
+				if entry.Name == "." || entry.Name == ".." {
+					continue
+				}
+				entryPath := path.Join(dir, entry.Name)
+				if d.exclude.Excluded(entry.Name) {
+					markKept(keptDirs, root, dir)
+					continue
+				}
+				if entry.IsDir && !entry.IsLink {
+					next = append(next, entryPath)
+				} else {
+					files = append(files, entryPath)
+				}
+			}
+		}
+		if len(next) > 0 {
+			dirs = append(dirs, next)
+		}
+		level = next
+	}
+
+	failed := make(map[string]error)
+	d.removeAll(files, false, failed)
+
+	// Directories go deepest first so each is empty by the time it is
+	// removed. One holding a failed or kept entry is left alone.
+	for depth := len(dirs) - 1; depth >= 0; depth-- {
+		var removable []string
+		for _, dir := range dirs[depth] {
+			if !keptDirs[dir] && !hasFailureBelow(failed, dir) {
+				removable = append(removable, dir)
+			}
+		}
+		d.removeAll(removable, true, failed)
+	}
+
+	if len(failed) > 0 {
+		return false, &DeleteError{Failed: failed}
+	}
+	if keptDirs[root] {
+		return true, nil
+	}
+	return false, withBackoff(func() error { return d.remove(root, true) })
+}
+
+// removeAll deletes paths with up to d.concurrency requests in flight,
+// recording failures in failed
+func (d *treeDeleter) removeAll(paths []string, isDir bool, failed map[string]error) {
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		jobs = make(chan string)
+	)
+	for i := 0; i < max(1, min(d.concurrency, len(paths))); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range jobs {
+				if err := withBackoff(func() error { return d.remove(p, isDir) }); err != nil {
+					mu.Lock()
+					failed[p] = err
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, p := range paths {
+		jobs <- p
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// markKept records dir and its ancestors up to root as holding an excluded
+// entry
+func markKept(keptDirs map[string]bool, root, dir string) {
+	for {
+		keptDirs[dir] = true
+		if dir == root || dir == "/" || dir == "." {
+			return
+		}
+		dir = path.Dir(dir)
+	}
+}
+
+// hasFailureBelow reports whether any failed path lies under dir
+func hasFailureBelow(failed map[string]error, dir string) bool {
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+	for p := range failed {
+		if strings.HasPrefix(p, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// DeleteTree deletes itemPath on fs. A directory is emptied item by item
+// with DeleteConcurrency requests in flight, leaving entries that match
+// exclude in place along with the directories that hold them; kept
+// reports whether itemPath is one of those. Without exclusions this is a
+// plain Delete, which every backend already applies to whole directories.
+func DeleteTree(fs FileSystem, itemPath string, exclude *ExcludeFilter) (kept bool, err error) {
+	if exclude.Empty() {
+		return false, fs.Delete(itemPath)
+	}
+
+	info, err := fs.Stat(itemPath)
+	if err != nil {
+		return false, err
+	}
+	if !info.IsDir || info.IsLink {
+		return false, fs.Delete(itemPath)
+	}
+
+	d := &treeDeleter{
+		list:        fs.List,
+		remove:      func(p string, _ bool) error { return fs.Delete(p) },
+		exclude:     exclude,
+		concurrency: DeleteConcurrency(),
+	}
+	return d.deleteDir(itemPath)
+}
+
+// errDirNotEmpty stops a listing once a directory is known to have entries
+var errDirNotEmpty = errors.New("directory not empty")
+
+// PruneEmptyDirs removes dir if it is empty, then each parent left empty
+// by that in turn, stopping at the first directory that still has entries
+// and never removing the storage root. It returns the directories removed,
+// deepest first.
+func PruneEmptyDirs(fs FileSystem, dir string) ([]string, error) {
+	var removed []string
+	for dir = path.Clean("/" + dir); dir != "/"; dir = path.Dir(dir) {
+		empty := true
+		err := ListFunc(fs, dir, func(entry FileInfo) error {
+			if entry.Name == "." || entry.Name == ".." {
+				return nil
+			}
+			empty = false
+			return errDirNotEmpty
+		})
+		if err != nil && err != errDirNotEmpty {
+			return removed, err
+		}
+		if !empty {
+			break
+		}
+		if err := withBackoff(func() error { return fs.Delete(dir) }); err != nil {
+			return removed, err
+		}
+		removed = append(removed, dir)
+	}
+	return removed, nil
+}
@@ -0,0 +1,167 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowTreeFileSystem is an in-memory tree whose deletes take a while and
+// are throttled on their first attempt, like a cloud backend without batch
+// delete
+type slowTreeFileSystem struct {
+	FileSystem
+
+	mu       sync.Mutex
+	items    map[string]bool // path -> isDir
+	attempts map[string]int
+	inFlight int
+	peak     int
+}
+
+// newSlowTreeFileSystem builds /big with dirs directories of filesPerDir
+// files, each with a nested directory holding a .DS_Store when junk is set
+func newSlowTreeFileSystem(dirs, filesPerDir int, junk bool) *slowTreeFileSystem {
+	fs := &slowTreeFileSystem{
+		items:    map[string]bool{"/big": true},
+		attempts: make(map[string]int),
+	}
+	for d := 0; d < dirs; d++ {
+		dir := fmt.Sprintf("/big/dir-%02d", d)
+		fs.items[dir] = true
+		fs.items[dir+"/nested"] = true
+		for f := 0; f < filesPerDir; f++ {
+			fs.items[fmt.Sprintf("%s/file-%03d.txt", dir, f)] = false
+		}
+		if junk {
+			fs.items[dir+"/nested/.DS_Store"] = false
+		}
+	}
+	return fs
+}
+
+func (s *slowTreeFileSystem) Stat(p string) (FileInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	isDir, ok := s.items[p]
+	if !ok {
+		return FileInfo{}, os.ErrNotExist
+	}
+	return FileInfo{Name: path.Base(p), Path: p, IsDir: isDir}, nil
+}
+
+func (s *slowTreeFileSystem) List(dir string) ([]FileInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var entries []FileInfo
+	for p, isDir := range s.items {
+		if path.Dir(p) == dir && p != dir {
+			entries = append(entries, FileInfo{Name: path.Base(p), Path: p, IsDir: isDir})
+		}
+	}
+	return entries, nil
+}
+
+func (s *slowTreeFileSystem) Delete(p string) error {
+	s.mu.Lock()
+	s.attempts[p]++
+	if s.attempts[p] == 1 && strings.HasSuffix(p, "0.txt") {
+		s.mu.Unlock()
+		return fmt.Errorf("delete failed: %w", ErrRateLimited)
+	}
+	for other := range s.items {
+		if strings.HasPrefix(other, p+"/") {
+			s.mu.Unlock()
+			return fmt.Errorf("directory not empty: %s", p)
+		}
+	}
+	s.inFlight++
+	s.peak = max(s.peak, s.inFlight)
+	s.mu.Unlock()
+
+	time.Sleep(time.Millisecond)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inFlight--
+	if _, ok := s.items[p]; !ok {
+		return os.ErrNotExist
+	}
+	delete(s.items, p)
+	return nil
+}
+
+func TestDeleteTree_Concurrent(t *testing.T) {
+	defer func(delay time.Duration) { retryBaseDelay = delay }(retryBaseDelay)
+	retryBaseDelay = time.Millisecond
+	defer SetDeleteConcurrency(0)
+	SetDeleteConcurrency(8)
+
+	exclude, err := NewExcludeFilter([]string{".DS_Store"})
+	if err != nil {
+		t.Fatalf("Failed to build exclusions: %v", err)
+	}
+
+	t.Run("Removes everything", func(t *testing.T) {
+		// With exclusions in effect the tree is removed item by item
+		fs := newSlowTreeFileSystem(20, 50, false)
+		kept, err := DeleteTree(fs, "/big", exclude)
+		if err != nil {
+			t.Fatalf("Failed to delete tree: %v", err)
+		}
+		if kept {
+			t.Error("Expected nothing to be kept")
+		}
+		if len(fs.items) != 0 {
+			t.Errorf("Expected every item to be removed, %d left", len(fs.items))
+		}
+		if fs.peak < 2 || fs.peak > 8 {
+			t.Errorf("Expected between 2 and 8 deletes in flight, peak was %d", fs.peak)
+		}
+		if fs.attempts["/big/dir-03/file-010.txt"] != 2 {
+			t.Errorf("Expected the rate-limited delete to be retried once, got %d attempts", fs.attempts["/big/dir-03/file-010.txt"])
+		}
+	})
+
+	t.Run("Keeps excluded entries", func(t *testing.T) {
+		fs := newSlowTreeFileSystem(3, 10, true)
+		kept, err := DeleteTree(fs, "/big", exclude)
+		if err != nil {
+			t.Fatalf("Failed to delete tree: %v", err)
+		}
+		if !kept {
+			t.Error("Expected /big to be kept")
+		}
+		for p, isDir := range fs.items {
+			if !isDir && path.Base(p) != ".DS_Store" {
+				t.Errorf("Expected %s to be deleted", p)
+			}
+		}
+		if len(fs.items) != 1+3*3 {
+			t.Errorf("Expected the excluded files and their parents to remain, got %v", fs.items)
+		}
+	})
+
+	t.Run("Reports every failure", func(t *testing.T) {
+		fs := newSlowTreeFileSystem(2, 5, true)
+		defer func(attempts int) { retryAttempts = attempts }(retryAttempts)
+		retryAttempts = 1
+
+		_, err := DeleteTree(fs, "/big", exclude)
+		var deleteErr *DeleteError
+		if !errors.As(err, &deleteErr) {
+			t.Fatalf("Expected a DeleteError, got %v", err)
+		}
+		if len(deleteErr.Failed) != 2 {
+			t.Errorf("Expected the 2 throttled files to be reported, got %v", deleteErr.Failed)
+		}
+		if _, ok := fs.items["/big/dir-01/file-001.txt"]; ok {
+			t.Error("Expected the other files to be deleted")
+		}
+	})
+}
@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"sync/atomic"
+)
+
+// ExcludeFilter decides which entries recursive operations skip. Patterns
+// are shell globs (as in path.Match) matched against the entry's base name,
+// so ".git" or "*.tmp" apply at any depth.
+type ExcludeFilter struct {
+	patterns []string
+}
+
+// NewExcludeFilter creates a filter for the given glob patterns. Empty
+// patterns are ignored.
+func NewExcludeFilter(patterns []string) (*ExcludeFilter, error) {
+	f := &ExcludeFilter{}
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid exclude pattern %q: %w", pattern, err)
+		}
+		f.patterns = append(f.patterns, pattern)
+	}
+	return f, nil
+}
+
+// ParseExcludePatterns splits a comma-separated pattern list
+func ParseExcludePatterns(list string) []string {
+	var patterns []string
+	for _, pattern := range strings.Split(list, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
+
+// Empty reports whether the filter excludes nothing. A nil filter is empty.
+func (f *ExcludeFilter) Empty() bool {
+	return f == nil || len(f.patterns) == 0
+}
+
+// Patterns returns the filter's patterns
+func (f *ExcludeFilter) Patterns() []string {
+	if f == nil {
+		return nil
+	}
+	return append([]string(nil), f.patterns...)
+}
+
+// Excluded reports whether an entry with the given name or path is skipped
+func (f *ExcludeFilter) Excluded(name string) bool {
+	if f.Empty() {
+		return false
+	}
+	base := path.Base(strings.ReplaceAll(name, "\\", "/"))
+	for _, pattern := range f.patterns {
+		if ok, _ := path.Match(pattern, base); ok {
+			return true
+		}
+	}
+	return false
+}
+
+var defaultExcludes atomic.Pointer[ExcludeFilter]
+
+// SetDefaultExcludes sets the server-wide exclusions used when a request
+// doesn't supply its own
+func SetDefaultExcludes(patterns []string) error {
+	f, err := NewExcludeFilter(patterns)
+	if err != nil {
+		return err
+	}
+	defaultExcludes.Store(f)
+	return nil
+}
+
+// DefaultExcludes returns the server-wide exclusions. It is empty unless
+// SetDefaultExcludes was called.
+func DefaultExcludes() *ExcludeFilter {
+	if f := defaultExcludes.Load(); f != nil {
+		return f
+	}
+	return &ExcludeFilter{}
+}
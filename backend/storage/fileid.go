@@ -0,0 +1,15 @@
+package storage
+
+import "path/filepath"
+
+// realPathID identifies the file at fullPath by its path with every
+// symlink resolved
+func realPathID(fullPath string) (string, error) {
+	return filepath.EvalSymlinks(fullPath)
+}
+
+// DirID identifies the directory at path, whichever links it was reached
+// through
+func (ls *LocalStorage) DirID(path string) (string, error) {
+	return fileID(ls.ResolvePath(path))
+}
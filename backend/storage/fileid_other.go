@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package storage
+
+// fileID identifies the file at fullPath by its real path where inode
+// numbers aren't available
+func fileID(fullPath string) (string, error) {
+	return realPathID(fullPath)
+}
@@ -0,0 +1,24 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package storage
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// fileID identifies the file at fullPath, following symlinks, by its
+// device and inode, so the same directory is recognised when it's reached
+// again through a link or a bind mount
+func fileID(fullPath string) (string, error) {
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return "", err
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return fmt.Sprintf("%d:%d", st.Dev, st.Ino), nil
+	}
+	return realPathID(fullPath)
+}
@@ -4,13 +4,22 @@
 package storage
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"net/textproto"
 	"os"
 	"path"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/jlaffaye/ftp"
 	"github.com/pkg/sftp"
@@ -29,22 +38,86 @@ type FTPStorage struct {
 	username   string
 	password   string
 	rootPath   string
+
+	// writeConcurrency is the number of SFTP write requests kept in flight
+	// per file. 0 uses defaultSFTPWriteConcurrency; 1 writes sequentially.
+	writeConcurrency int
+
+	// hostKeyFingerprint pins the SFTP server's key by its SHA-256
+	// fingerprint. Empty falls back to getHostKeyCallback.
+	hostKeyFingerprint string
+
+	// signer is the parsed SFTP private key, tried before the password.
+	// The PEM itself isn't kept.
+	signer ssh.Signer
+
+	// mu guards the clients, which are replaced when the connection is
+	// found dead. Plain FTP commands run under it too, as the control
+	// connection carries one at a time.
+	mu         sync.Mutex
+	broken     bool // the connection is dead and is redialed on next use
+	closed     bool
+	generation int // counts connections, so one loss is only handled once
+	lastUsed   time.Time
+	reading    int // plain FTP downloads open, during which no command can be sent
+	readDone   sync.Cond
+	stopKeep   chan struct{}
+
+	// dial opens a connection, setting the clients; connect unless a test
+	// connects some other way
+	dial func() error
 }
 
-// NewFTPStorage creates a new FTP/SFTP filesystem
-func NewFTPStorage(protocol, host, port, username, password, rootPath string) (*FTPStorage, error) {
+// defaultSFTPWriteConcurrency matches pkg/sftp's default request limit
+const defaultSFTPWriteConcurrency = 64
+
+// ftpKeepAliveInterval is how long a connection may sit idle before it is
+// checked with a keep-alive, which also stops servers and firewalls from
+// dropping it for inactivity
+const ftpKeepAliveInterval = 30 * time.Second
+
+// ftpKeepAliveTimeout is how long a keep-alive may go unanswered before the
+// connection is taken for dead
+var ftpKeepAliveTimeout = 15 * time.Second
+
+// ftpDownloadWait is how long a plain FTP command waits for open downloads
+// to be closed before failing, so a caller that left one open gets an
+// error rather than hanging
+var ftpDownloadWait = 2 * time.Minute
+
+// NewFTPStorage creates a new FTP/SFTP filesystem. For SFTP, a non-empty
+// privateKey is a PEM key to log in with, decrypted with passphrase if it
+// is encrypted, and a non-empty hostKeyFingerprint is the only host key
+// accepted.
+func NewFTPStorage(protocol, host, port, username, password, privateKey, passphrase, rootPath, hostKeyFingerprint string) (*FTPStorage, error) {
+	var signer ssh.Signer
+	if privateKey != "" {
+		if protocol != "sftp" {
+			return nil, fmt.Errorf("a private key can only be used with SFTP")
+		}
+		var err error
+		if signer, err = parseSFTPPrivateKey(privateKey, passphrase); err != nil {
+			return nil, err
+		}
+	}
+
 	fs := &FTPStorage{
-		protocol: protocol,
-		host:     host,
-		port:     port,
-		username: username,
-		password: password,
-		rootPath: rootPath,
+		protocol:           protocol,
+		host:               host,
+		port:               port,
+		username:           username,
+		password:           password,
+		rootPath:           rootPath,
+		hostKeyFingerprint: hostKeyFingerprint,
+		signer:             signer,
 	}
+	fs.dial = fs.connect
 
-	if err := fs.connect(); err != nil {
+	if err := fs.dial(); err != nil {
 		return nil, err
 	}
+	fs.lastUsed = time.Now()
+	fs.startKeepAlive()
 
 	return fs, nil
 }
@@ -59,7 +132,9 @@ func (f *FTPStorage) connect() error {
 func (f *FTPStorage) connectFTP() error {
 	addr := fmt.Sprintf("%s:%s", f.host, f.port)
 
-	conn, err := ftp.Dial(addr)
+	// TCP keep-alives let a connection to a vanished server fail rather
+	// than hang
+	conn, err := ftp.Dial(addr, ftp.DialWithDialer(net.Dialer{Timeout: ftp.DefaultDialTimeout, KeepAlive: ftpKeepAliveInterval}))
 	if err != nil {
 		return fmt.Errorf("failed to connect to FTP server: %v", err)
 	}
@@ -114,15 +189,80 @@ func getHostKeyCallback() ssh.HostKeyCallback {
 	}
 }
 
+// pinnedHostKeyCallback accepts only the host key with the given SHA-256
+// fingerprint, written as ssh-keygen -l prints it: "SHA256:" and unpadded
+// base64. The prefix and padding are optional.
+func pinnedHostKeyCallback(fingerprint string) (ssh.HostKeyCallback, error) {
+	encoded := strings.TrimRight(strings.TrimPrefix(fingerprint, "SHA256:"), "=")
+	if sum, err := base64.RawStdEncoding.DecodeString(encoded); err != nil || len(sum) != sha256.Size {
+		return nil, fmt.Errorf("invalid host key fingerprint %q: expected SHA256:<base64>", fingerprint)
+	}
+	want := "SHA256:" + encoded
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if got := ssh.FingerprintSHA256(key); got != want {
+			return fmt.Errorf("host key for %s does not match the pinned fingerprint: got %s, want %s", hostname, got, want)
+		}
+		return nil
+	}, nil
+}
+
+// parseSFTPPrivateKey parses a PEM private key, with passphrase if it is
+// encrypted. Errors describe what's wrong without quoting the key.
+func parseSFTPPrivateKey(privateKey, passphrase string) (ssh.Signer, error) {
+	if passphrase == "" {
+		signer, err := ssh.ParsePrivateKey([]byte(privateKey))
+		var missing *ssh.PassphraseMissingError
+		if errors.As(err, &missing) {
+			return nil, fmt.Errorf("SFTP private key is encrypted: a passphrase is required")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid SFTP private key: %v", err)
+		}
+		return signer, nil
+	}
+
+	signer, err := ssh.ParsePrivateKeyWithPassphrase([]byte(privateKey), []byte(passphrase))
+	if errors.Is(err, x509.IncorrectPasswordError) {
+		return nil, fmt.Errorf("wrong passphrase for SFTP private key")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid SFTP private key: %v", err)
+	}
+	return signer, nil
+}
+
+// sftpAuthMethods offers the private key first, when there is one, then
+// the password
+func (f *FTPStorage) sftpAuthMethods() []ssh.AuthMethod {
+	var methods []ssh.AuthMethod
+	if f.signer != nil {
+		methods = append(methods, ssh.PublicKeys(f.signer))
+	}
+	if f.password != "" || f.signer == nil {
+		methods = append(methods, ssh.Password(f.password))
+	}
+	return methods
+}
+
 func (f *FTPStorage) connectSFTP() error {
 	addr := fmt.Sprintf("%s:%s", f.host, f.port)
 
+	var hostKeyCallback ssh.HostKeyCallback
+	if f.hostKeyFingerprint != "" {
+		callback, err := pinnedHostKeyCallback(f.hostKeyFingerprint)
+		if err != nil {
+			return err
+		}
+		hostKeyCallback = callback
+	} else {
+		hostKeyCallback = getHostKeyCallback()
+	}
+
 	config := &ssh.ClientConfig{
-		User: f.username,
-		Auth: []ssh.AuthMethod{
-			ssh.Password(f.password),
-		},
-		HostKeyCallback: getHostKeyCallback(),
+		User:            f.username,
+		Auth:            f.sftpAuthMethods(),
+		HostKeyCallback: hostKeyCallback,
 	}
 
 	sshClient, err := ssh.Dial("tcp", addr, config)
@@ -130,7 +270,7 @@ func (f *FTPStorage) connectSFTP() error {
 		return fmt.Errorf("failed to connect to SSH server: %v", err)
 	}
 
-	sftpClient, err := sftp.NewClient(sshClient)
+	sftpClient, err := sftp.NewClient(sshClient, f.sftpClientOptions()...)
 	if err != nil {
 		if err := sshClient.Close(); err != nil {
 			log.Printf("Error closing SSH client: %v", err)
@@ -143,20 +283,305 @@ func (f *FTPStorage) connectSFTP() error {
 	return nil
 }
 
+// sftpClientOptions returns the pkg/sftp options for the configured write
+// concurrency
+func (f *FTPStorage) sftpClientOptions() []sftp.ClientOption {
+	concurrency := f.sftpWriteConcurrency()
+	if concurrency == 1 {
+		return nil
+	}
+	return []sftp.ClientOption{
+		sftp.UseConcurrentWrites(true),
+		sftp.MaxConcurrentRequestsPerFile(concurrency),
+	}
+}
+
+func (f *FTPStorage) sftpWriteConcurrency() int {
+	if f.writeConcurrency <= 0 {
+		return defaultSFTPWriteConcurrency
+	}
+	return f.writeConcurrency
+}
+
+// SetWriteConcurrency sets how many SFTP write requests may be in flight
+// for a single upload. Pipelining writes hides the round trip per 32KB
+// packet, which dominates upload time on high-latency links; 1 disables it.
+// An open SFTP session is reopened to apply the new setting.
+func (f *FTPStorage) SetWriteConcurrency(n int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.writeConcurrency = n
+	if f.protocol != "sftp" || f.sshClient == nil {
+		return nil
+	}
+
+	sftpClient, err := sftp.NewClient(f.sshClient, f.sftpClientOptions()...)
+	if err != nil {
+		return fmt.Errorf("failed to create SFTP client: %v", err)
+	}
+	if f.sftpClient != nil {
+		if err := f.sftpClient.Close(); err != nil {
+			log.Printf("Error closing SFTP client: %v", err)
+		}
+	}
+	f.sftpClient = sftpClient
+	return nil
+}
+
+// ftpConn is one connection's clients; only the protocol's one is set
+type ftpConn struct {
+	ftp  *ftp.ServerConn
+	sftp *sftp.Client
+}
+
+// acquire returns the connection, redialing first if it was found dead,
+// along with its generation. For plain FTP, it first waits for open
+// downloads to be closed, and mu stays locked until release.
+func (f *FTPStorage) acquire() (ftpConn, int, error) {
+	f.mu.Lock()
+	if f.protocol != "sftp" {
+		if err := f.waitForReads(); err != nil {
+			f.mu.Unlock()
+			return ftpConn{}, 0, err
+		}
+	}
+	if f.closed {
+		f.mu.Unlock()
+		return ftpConn{}, 0, fmt.Errorf("connection to %s is closed", f.host)
+	}
+	if f.broken {
+		f.closeClients()
+		if err := f.dial(); err != nil {
+			f.mu.Unlock()
+			return ftpConn{}, 0, fmt.Errorf("failed to reconnect to %s: %w", f.host, err)
+		}
+		f.broken = false
+		f.generation++
+	}
+	f.lastUsed = time.Now()
+	c := ftpConn{ftp: f.ftpClient, sftp: f.sftpClient}
+	if f.protocol == "sftp" {
+		// SFTP requests are multiplexed over the connection
+		defer f.mu.Unlock()
+	}
+	return c, f.generation, nil
+}
+
+// waitForReads waits until no plain FTP download is open, as the server
+// confirms a transfer on the control connection only once it is closed.
+// Called with mu held.
+func (f *FTPStorage) waitForReads() error {
+	if f.reading == 0 {
+		return nil
+	}
+	if f.readDone.L == nil {
+		f.readDone.L = &f.mu
+	}
+	expired := false
+	timer := time.AfterFunc(ftpDownloadWait, func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		expired = true
+		f.readDone.Broadcast()
+	})
+	defer timer.Stop()
+	for f.reading > 0 && !f.closed {
+		if expired {
+			return fmt.Errorf("connection to %s is busy with a download", f.host)
+		}
+		f.readDone.Wait()
+	}
+	return nil
+}
+
+func (f *FTPStorage) release() {
+	if f.protocol != "sftp" {
+		f.mu.Unlock()
+	}
+}
+
+// withConn runs op on the connection. If op fails because the connection
+// was lost, which is usually the server dropping it while idle, it is run
+// once more on a new connection.
+func (f *FTPStorage) withConn(op func(c ftpConn) error) error {
+	return f.withConnRetry(nil, op)
+}
+
+// withConnRetry is withConn for operations that can't always be repeated:
+// op is only run again if canRetry reports it can be
+func (f *FTPStorage) withConnRetry(canRetry func() bool, op func(c ftpConn) error) error {
+	for attempt := 0; ; attempt++ {
+		c, generation, err := f.acquire()
+		if err != nil {
+			return err
+		}
+		err = op(c)
+		f.release()
+		if err == nil || !isConnectionLost(err) {
+			return err
+		}
+		f.markBroken(generation)
+		if attempt > 0 || (canRetry != nil && !canRetry()) {
+			return err
+		}
+		log.Printf("Connection to %s lost (%v), reconnecting", f.host, err)
+	}
+}
+
+// markBroken has the connection of the given generation redialed on next
+// use, unless that already happened
+func (f *FTPStorage) markBroken(generation int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if generation == f.generation {
+		f.broken = true
+	}
+}
+
+// isConnectionLost reports whether err means the connection is gone, as
+// opposed to the server refusing the operation
+func isConnectionLost(err error) bool {
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) {
+		return protoErr.Code == ftp.StatusNotAvailable
+	}
+	return errors.Is(err, sftp.ErrSSHFxConnectionLost) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.ErrClosedPipe) ||
+		errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, syscall.ECONNRESET)
+}
+
+// closeClients closes the clients of a connection that is being replaced
+// or shut down. Called with mu held.
+func (f *FTPStorage) closeClients() error {
+	var err error
+	if f.ftpClient != nil {
+		err = f.ftpClient.Quit()
+		f.ftpClient = nil
+	}
+	if f.sftpClient != nil {
+		if closeErr := f.sftpClient.Close(); closeErr != nil {
+			log.Printf("Error closing SFTP client: %v", closeErr)
+		}
+		f.sftpClient = nil
+	}
+	if f.sshClient != nil {
+		if closeErr := f.sshClient.Close(); closeErr != nil {
+			log.Printf("Error closing SSH client: %v", closeErr)
+		}
+		f.sshClient = nil
+	}
+	return err
+}
+
+// startKeepAlive checks the connection every keep-alive interval until
+// Close
+func (f *FTPStorage) startKeepAlive() {
+	stop := make(chan struct{})
+	f.stopKeep = stop
+	go func() {
+		ticker := time.NewTicker(ftpKeepAliveInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-ticker.C:
+				f.keepAlive(now)
+			}
+		}
+	}()
+}
+
+// keepAlive sends a keep-alive over a connection idle for a keep-alive
+// interval as of now: an SSH keep-alive request for SFTP, NOOP for FTP. A
+// connection that doesn't answer in time is closed and marked broken, so
+// the next operation redials instead of failing on it. It reports whether
+// the connection is alive.
+func (f *FTPStorage) keepAlive(now time.Time) bool {
+	f.mu.Lock()
+	if f.closed || f.broken {
+		f.mu.Unlock()
+		return false
+	}
+	var ping func() error
+	switch {
+	case f.reading > 0 || now.Sub(f.lastUsed) < ftpKeepAliveInterval:
+	case f.sshClient != nil:
+		client := f.sshClient
+		ping = func() error {
+			// Servers answer requests they don't know with a failure,
+			// which is as good as any answer here
+			_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+			return err
+		}
+	case f.ftpClient != nil:
+		ping = f.ftpClient.NoOp
+	}
+	if ping == nil {
+		f.mu.Unlock()
+		return true
+	}
+	generation := f.generation
+	if f.protocol == "sftp" {
+		// SSH requests don't get in the way of SFTP ones
+		f.mu.Unlock()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- ping()
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-time.After(ftpKeepAliveTimeout):
+		err = errors.New("no answer to keep-alive")
+	}
+
+	if f.protocol == "sftp" {
+		f.mu.Lock()
+	}
+	defer f.mu.Unlock()
+	if err == nil || generation != f.generation || f.closed {
+		return err == nil
+	}
+	log.Printf("Keep-alive to %s failed, reconnecting on next use: %v", f.host, err)
+	f.broken = true
+	if err := f.closeClients(); err != nil {
+		log.Printf("Error closing FTP connection: %v", err)
+	}
+	return false
+}
+
 // List lists files in a directory
 func (f *FTPStorage) List(dirPath string) ([]FileInfo, error) {
 	fullPath := f.getFullPath(dirPath)
 
-	if f.protocol == "sftp" {
-		return f.listSFTP(fullPath)
+	var files []FileInfo
+	err := f.withConn(func(c ftpConn) error {
+		var err error
+		if c.sftp != nil {
+			files, err = listSFTP(c.sftp, fullPath)
+		} else {
+			files, err = listFTP(c.ftp, fullPath)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list directory: %w", err)
 	}
-	return f.listFTP(fullPath)
+	return files, nil
 }
 
-func (f *FTPStorage) listFTP(dirPath string) ([]FileInfo, error) {
-	entries, err := f.ftpClient.List(dirPath)
+func listFTP(client *ftp.ServerConn, dirPath string) ([]FileInfo, error) {
+	entries, err := client.List(dirPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to list directory: %v", err)
+		return nil, err
 	}
 
 	var files []FileInfo
@@ -173,10 +598,10 @@ func (f *FTPStorage) listFTP(dirPath string) ([]FileInfo, error) {
 	return files, nil
 }
 
-func (f *FTPStorage) listSFTP(dirPath string) ([]FileInfo, error) {
-	files, err := f.sftpClient.ReadDir(dirPath)
+func listSFTP(client *sftp.Client, dirPath string) ([]FileInfo, error) {
+	files, err := client.ReadDir(dirPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to list directory: %v", err)
+		return nil, err
 	}
 
 	var result []FileInfo
@@ -197,64 +622,111 @@ func (f *FTPStorage) listSFTP(dirPath string) ([]FileInfo, error) {
 func (f *FTPStorage) Stat(filePath string) (FileInfo, error) {
 	fullPath := f.getFullPath(filePath)
 
-	if f.protocol == "sftp" {
-		stat, err := f.sftpClient.Stat(fullPath)
-		if err != nil {
-			return FileInfo{}, err
-		}
-
-		return FileInfo{
-			Name:    path.Base(fullPath),
-			Size:    stat.Size(),
-			IsDir:   stat.IsDir(),
-			ModTime: stat.ModTime(),
-			Path:    filePath,
-		}, nil
-	}
-
-	// FTP doesn't have a direct stat command, use list
-	dir := path.Dir(fullPath)
-	name := path.Base(fullPath)
-
-	entries, err := f.ftpClient.List(dir)
-	if err != nil {
-		return FileInfo{}, err
-	}
-
-	for _, entry := range entries {
-		if entry.Name == name {
-			return FileInfo{
-				Name:    entry.Name,
-				Size:    int64(entry.Size),
-				IsDir:   entry.Type == ftp.EntryTypeFolder,
-				ModTime: entry.Time,
+	var info FileInfo
+	err := f.withConn(func(c ftpConn) error {
+		if c.sftp != nil {
+			stat, err := c.sftp.Stat(fullPath)
+			if err != nil {
+				return err
+			}
+			info = FileInfo{
+				Name:    path.Base(fullPath),
+				Size:    stat.Size(),
+				IsDir:   stat.IsDir(),
+				ModTime: stat.ModTime(),
 				Path:    filePath,
-			}, nil
+			}
+			return nil
 		}
-	}
 
-	return FileInfo{}, fmt.Errorf("file not found: %s", filePath)
+		// FTP doesn't have a direct stat command, use list
+		name := path.Base(fullPath)
+		entries, err := c.ftp.List(path.Dir(fullPath))
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if entry.Name == name {
+				info = FileInfo{
+					Name:    entry.Name,
+					Size:    int64(entry.Size),
+					IsDir:   entry.Type == ftp.EntryTypeFolder,
+					ModTime: entry.Time,
+					Path:    filePath,
+				}
+				return nil
+			}
+		}
+		return fmt.Errorf("file not found: %s", filePath)
+	})
+	return info, err
 }
 
 // Read reads a file from the FTP/SFTP server
 func (f *FTPStorage) Read(filePath string) (io.ReadCloser, error) {
 	fullPath := f.getFullPath(filePath)
 
-	if f.protocol == "sftp" {
-		file, err := f.sftpClient.Open(fullPath)
+	var reader io.ReadCloser
+	err := f.withConn(func(c ftpConn) error {
+		if c.sftp != nil {
+			file, err := c.sftp.Open(fullPath)
+			if err != nil {
+				return err
+			}
+			reader = file
+			return nil
+		}
+
+		resp, err := c.ftp.Retr(fullPath)
 		if err != nil {
-			return nil, fmt.Errorf("failed to open file: %v", err)
+			return err
 		}
-		return file, nil
+		f.reading++
+		reader = &ftpDownload{Response: resp, f: f}
+		return nil
+	})
+	if err != nil {
+		if f.protocol == "sftp" {
+			return nil, fmt.Errorf("failed to open file: %w", err)
+		}
+		return nil, fmt.Errorf("failed to retrieve file: %w", err)
 	}
+	return reader, nil
+}
 
-	// FTP
-	reader, err := f.ftpClient.Retr(fullPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to retrieve file: %v", err)
+// ftpDownload is an open plain FTP download. The server confirms the
+// transfer on the control connection when it is closed, so other commands
+// wait until then. It closes itself once read to the end, so a caller that
+// reads the whole file can use the storage again before closing it.
+type ftpDownload struct {
+	*ftp.Response
+	f        *FTPStorage
+	once     sync.Once
+	closeErr error
+}
+
+func (d *ftpDownload) Read(p []byte) (int, error) {
+	n, err := d.Response.Read(p)
+	if err == io.EOF {
+		if closeErr := d.Close(); closeErr != nil {
+			return n, closeErr
+		}
 	}
+	return n, err
+}
 
-	return reader, nil
+func (d *ftpDownload) Close() error {
+	d.once.Do(func() {
+		d.f.mu.Lock()
+		defer d.f.mu.Unlock()
+		d.f.reading--
+		if d.f.reading == 0 && d.f.readDone.L != nil {
+			d.f.readDone.Broadcast()
+		}
+		d.f.lastUsed = time.Now()
+		d.closeErr = d.Response.Close()
+	})
+	return d.closeErr
 }
 
 // Write writes a file to the FTP/SFTP server
@@ -268,39 +740,67 @@ func (f *FTPStorage) Write(filePath string, data io.Reader) error {
 }
 
 func (f *FTPStorage) writeFTP(filePath string, data io.Reader) error {
-	// Read all data first (FTP requires this)
+	// Read all data first (FTP requires this), which also lets the upload
+	// be sent again on a new connection
 	content, err := io.ReadAll(data)
 	if err != nil {
 		return err
 	}
 
-	err = f.ftpClient.Stor(filePath, strings.NewReader(string(content)))
+	err = f.withConn(func(c ftpConn) error {
+		return c.ftp.Stor(filePath, bytes.NewReader(content))
+	})
 	if err != nil {
-		return fmt.Errorf("failed to store file: %v", err)
+		return fmt.Errorf("failed to store file: %w", err)
 	}
 
 	return nil
 }
 
 func (f *FTPStorage) writeSFTP(filePath string, data io.Reader) error {
-	file, err := f.sftpClient.Create(filePath)
-	if err != nil {
-		return fmt.Errorf("failed to create file: %v", err)
-	}
-	defer func() {
-		if err := file.Close(); err != nil {
-			log.Printf("Error closing SFTP file: %v", err)
+	// Only an upload that hasn't consumed any data yet can be retried
+	counted := &countingReader{r: data}
+	err := f.withConnRetry(func() bool { return counted.n == 0 }, func(c ftpConn) error {
+		file, err := c.sftp.Create(filePath)
+		if err != nil {
+			return err
 		}
-	}()
-
-	_, err = io.Copy(file, data)
+		defer func() {
+			if err := file.Close(); err != nil {
+				log.Printf("Error closing SFTP file: %v", err)
+			}
+		}()
+
+		concurrency := f.sftpWriteConcurrency()
+		if concurrency == 1 {
+			_, err = io.Copy(file, counted)
+		} else {
+			// ReadFrom only pipelines when it can tell the size of data,
+			// which request bodies don't expose, so ask for concurrency
+			// explicitly
+			_, err = file.ReadFromWithConcurrency(counted, concurrency)
+		}
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("failed to write file: %v", err)
+		return fmt.Errorf("failed to write file: %w", err)
 	}
 
 	return nil
 }
 
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
 // Delete deletes a file or directory
 func (f *FTPStorage) Delete(filePath string) error {
 	fullPath := f.getFullPath(filePath)
@@ -311,29 +811,92 @@ func (f *FTPStorage) Delete(filePath string) error {
 		return err
 	}
 
-	if f.protocol == "sftp" {
-		if info.IsDir {
-			return f.sftpClient.RemoveDirectory(fullPath)
-		}
-		return f.sftpClient.Remove(fullPath)
+	if !info.IsDir {
+		return f.removeItem(fullPath, false)
 	}
 
-	// FTP
-	if info.IsDir {
-		return f.ftpClient.RemoveDir(fullPath)
+	// Neither protocol removes a directory that still has entries, so the
+	// tree is emptied first. SFTP requests are pipelined over one
+	// connection; an FTP control connection handles one command at a time.
+	d := &treeDeleter{
+		list: f.List,
+		remove: func(itemPath string, isDir bool) error {
+			return f.removeItem(f.getFullPath(itemPath), isDir)
+		},
+		concurrency: 1,
 	}
-	return f.ftpClient.Delete(fullPath)
+	if f.protocol == "sftp" {
+		d.concurrency = DeleteConcurrency()
+	}
+	_, err = d.deleteDir(filePath)
+	return err
+}
+
+// removeItem removes a single file or empty directory
+func (f *FTPStorage) removeItem(fullPath string, isDir bool) error {
+	return f.withConn(func(c ftpConn) error {
+		switch {
+		case c.sftp != nil && isDir:
+			return c.sftp.RemoveDirectory(fullPath)
+		case c.sftp != nil:
+			return c.sftp.Remove(fullPath)
+		case isDir:
+			return c.ftp.RemoveDir(fullPath)
+		}
+		return c.ftp.Delete(fullPath)
+	})
 }
 
 // MkDir creates a directory
 func (f *FTPStorage) MkDir(dirPath string) error {
 	fullPath := f.getFullPath(dirPath)
 
-	if f.protocol == "sftp" {
-		return f.sftpClient.Mkdir(fullPath)
+	return f.withConn(func(c ftpConn) error {
+		if c.sftp != nil {
+			return c.sftp.Mkdir(fullPath)
+		}
+		return c.ftp.MakeDir(fullPath)
+	})
+}
+
+// Chmod changes the permission bits of a file or directory. Plain FTP has
+// no portable way to do this, so only SFTP is supported.
+func (f *FTPStorage) Chmod(filePath string, mode os.FileMode) error {
+	if f.protocol != "sftp" {
+		return ErrNotSupported
+	}
+	return f.withConn(func(c ftpConn) error {
+		return c.sftp.Chmod(f.getFullPath(filePath), mode)
+	})
+}
+
+// Chown changes the owner and group of a file or directory over SFTP. IDs
+// are interpreted by the remote server.
+func (f *FTPStorage) Chown(filePath string, uid, gid int) error {
+	if f.protocol != "sftp" {
+		return ErrNotSupported
 	}
 
-	return f.ftpClient.MakeDir(fullPath)
+	fullPath := f.getFullPath(filePath)
+	return f.withConn(func(c ftpConn) error {
+		uid, gid := uid, gid
+		if uid < 0 || gid < 0 {
+			// SFTP always sets both, so fill in the current values
+			info, err := c.sftp.Lstat(fullPath)
+			if err != nil {
+				return err
+			}
+			if stat, ok := info.Sys().(*sftp.FileStat); ok {
+				if uid < 0 {
+					uid = int(stat.UID)
+				}
+				if gid < 0 {
+					gid = int(stat.GID)
+				}
+			}
+		}
+		return c.sftp.Chown(fullPath, uid, gid)
+	})
 }
 
 // Move moves a file or directory
@@ -341,29 +904,26 @@ func (f *FTPStorage) Move(src, dst string) error {
 	srcPath := f.getFullPath(src)
 	dstPath := f.getFullPath(dst)
 
-	if f.protocol == "sftp" {
-		return f.sftpClient.Rename(srcPath, dstPath)
-	}
-
-	// FTP rename
-	return f.ftpClient.Rename(srcPath, dstPath)
+	return f.withConn(func(c ftpConn) error {
+		if c.sftp != nil {
+			return c.sftp.Rename(srcPath, dstPath)
+		}
+		return c.ftp.Rename(srcPath, dstPath)
+	})
 }
 
-// Copy copies a file
+// Copy copies a file. A plain FTP connection carries one transfer at a
+// time, so the file is read whole and the download closed before anything
+// else is sent.
 func (f *FTPStorage) Copy(src, dst string, progress ProgressCallback) error {
-	// Read source file
-	srcReader, err := f.Read(src)
+	// Get file size for progress
+	info, err := f.Stat(src)
 	if err != nil {
 		return err
 	}
-	defer func() {
-		if err := srcReader.Close(); err != nil {
-			log.Printf("Error closing source reader: %v", err)
-		}
-	}()
 
-	// Get file size for progress
-	info, err := f.Stat(src)
+	// Read source file
+	srcReader, err := f.Read(src)
 	if err != nil {
 		return err
 	}
@@ -375,6 +935,9 @@ func (f *FTPStorage) Copy(src, dst string, progress ProgressCallback) error {
 
 	// Read content
 	content, err := io.ReadAll(srcReader)
+	if closeErr := srcReader.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
 		return err
 	}
@@ -398,11 +961,26 @@ func (f *FTPStorage) Copy(src, dst string, progress ProgressCallback) error {
 	return nil
 }
 
+// SingleTransfer reports that a plain FTP connection carries one transfer
+// at a time; SFTP multiplexes them
+func (f *FTPStorage) SingleTransfer() bool {
+	return f.protocol != "sftp"
+}
+
 // GetType returns the storage type
 func (f *FTPStorage) GetType() string {
 	return f.protocol
 }
 
+// MaxConcurrency is 1 for plain FTP, whose control connection carries one
+// command at a time; SFTP multiplexes requests over its connection
+func (f *FTPStorage) MaxConcurrency() int {
+	if f.protocol == "ftp" {
+		return 1
+	}
+	return 0
+}
+
 // GetRootPath returns the root path
 func (f *FTPStorage) GetRootPath() string {
 	return f.rootPath
@@ -450,42 +1028,6 @@ func (f *FTPStorage) PutFileContent(filePath string, content []byte) error {
 	return f.Write(filePath, strings.NewReader(string(content)))
 }
 
-// Search searches for files
-func (f *FTPStorage) Search(query string, options map[string]interface{}) ([]FileInfo, error) {
-	// Start from root and search recursively
-	return f.searchRecursive(f.rootPath, query, options)
-}
-
-func (f *FTPStorage) searchRecursive(dirPath, query string, options map[string]interface{}) ([]FileInfo, error) {
-	files, err := f.List(dirPath)
-	if err != nil {
-		return nil, err
-	}
-
-	var results []FileInfo
-	queryLower := strings.ToLower(query)
-
-	for _, file := range files {
-		// Check if name matches
-		if strings.Contains(strings.ToLower(file.Name), queryLower) {
-			results = append(results, file)
-		}
-
-		// Recursively search directories
-		if file.IsDir {
-			subResults, _ := f.searchRecursive(file.Path, query, options)
-			results = append(results, subResults...)
-		}
-
-		// Limit results
-		if len(results) >= 100 {
-			break
-		}
-	}
-
-	return results, nil
-}
-
 // Helper functions
 
 func (f *FTPStorage) getFullPath(filePath string) string {
@@ -500,25 +1042,47 @@ func (f *FTPStorage) getFullPath(filePath string) string {
 	return path.Join(f.rootPath, "/", filePath)
 }
 
-// Close closes the connection
+// Close stops the keep-alives and closes the connection
 func (f *FTPStorage) Close() error {
-	if f.ftpClient != nil {
-		return f.ftpClient.Quit()
-	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
 
-	if f.sftpClient != nil {
-		if err := f.sftpClient.Close(); err != nil {
-			log.Printf("Error closing SFTP client: %v", err)
-		}
+	if f.stopKeep != nil {
+		close(f.stopKeep)
+		f.stopKeep = nil
 	}
-
-	if f.sshClient != nil {
-		if err := f.sshClient.Close(); err != nil {
-			log.Printf("Error closing SSH client: %v", err)
-		}
+	f.closed = true
+	if f.readDone.L != nil {
+		// Commands waiting for a download give up
+		f.readDone.Broadcast()
 	}
+	return f.closeClients()
+}
 
-	return nil
+// Info reports the connection. Plain FTP is unencrypted; SFTP runs over
+// SSH, with the server's key pinned when a fingerprint is configured.
+func (f *FTPStorage) Info() map[string]interface{} {
+	f.mu.Lock()
+	connected := !f.broken && !f.closed && (f.ftpClient != nil || f.sftpClient != nil)
+	f.mu.Unlock()
+
+	info := map[string]interface{}{
+		"protocol":  f.protocol,
+		"host":      f.host,
+		"port":      f.port,
+		"username":  f.username,
+		"rootPath":  f.rootPath,
+		"connected": connected,
+		"tls":       false,
+	}
+	if f.protocol == "sftp" {
+		info["encrypted"] = true
+		info["hostKeyPinned"] = f.hostKeyFingerprint != ""
+		info["writeConcurrency"] = f.writeConcurrency
+	} else {
+		info["encrypted"] = false
+	}
+	return info
 }
 
 // FTPAdapter adapts FTPStorage to implement FileSystem interface
@@ -527,8 +1091,8 @@ type FTPAdapter struct {
 }
 
 // NewFTPAdapter creates a new FTP/SFTP adapter
-func NewFTPAdapter(protocol, host, port, username, password, rootPath string) (FileSystem, error) {
-	storage, err := NewFTPStorage(protocol, host, port, username, password, rootPath)
+func NewFTPAdapter(protocol, host, port, username, password, privateKey, passphrase, rootPath, hostKeyFingerprint string) (FileSystem, error) {
+	storage, err := NewFTPStorage(protocol, host, port, username, password, privateKey, passphrase, rootPath, hostKeyFingerprint)
 	if err != nil {
 		return nil, err
 	}
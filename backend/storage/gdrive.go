@@ -5,17 +5,20 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"path"
 	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
 )
 
@@ -24,6 +27,15 @@ type GDriveStorage struct {
 	service *drive.Service
 	rootID  string
 	cache   map[string]*drive.File // Path to file cache
+	cacheMu sync.Mutex
+
+	// caseInsensitive makes path lookups fall back to matching names
+	// regardless of case
+	caseInsensitive bool
+
+	// permanentDelete makes Delete remove files outright instead of moving
+	// them to the trash
+	permanentDelete bool
 }
 
 // NewGDriveFileSystem creates a new Google Drive filesystem
@@ -86,7 +98,9 @@ func (g *GDriveStorage) List(dirPath string) ([]FileInfo, error) {
 
 		// Cache the file for later use
 		fullPath := path.Join(dirPath, f.Name)
+		g.cacheMu.Lock()
 		g.cache[fullPath] = f
+		g.cacheMu.Unlock()
 
 		files = append(files, FileInfo{
 			Name:     f.Name,
@@ -101,6 +115,77 @@ func (g *GDriveStorage) List(dirPath string) ([]FileInfo, error) {
 	return files, nil
 }
 
+// Walk implements Walker by following folder IDs down the tree, so no
+// directory below root has its path looked up again
+func (g *GDriveStorage) Walk(root string, fn func(FileInfo) error) error {
+	rootID, err := g.getFileID(root)
+	if err != nil {
+		return err
+	}
+	return g.walkFolder(rootID, root, 0, map[string]bool{rootID: true}, fn)
+}
+
+// walkFolder walks the folder with the given ID. A folder can have several
+// parents in Drive, so visited guards against entering one twice.
+func (g *GDriveStorage) walkFolder(folderID, dirPath string, depth int, visited map[string]bool, fn func(FileInfo) error) error {
+	if depth >= maxWalkDepth {
+		return fmt.Errorf("%w: %s is more than %d directory levels deep", ErrWalkTruncated, dirPath, maxWalkDepth)
+	}
+
+	type subfolder struct{ id, path string }
+	var subfolders []subfolder
+	var fnErr error
+
+	query := fmt.Sprintf("'%s' in parents and trashed = false", folderID)
+	err := g.service.Files.List().
+		Q(query).
+		Fields("nextPageToken, files(id, name, size, mimeType, modifiedTime, createdTime, parents)").
+		PageSize(1000).
+		Pages(context.Background(), func(page *drive.FileList) error {
+			for _, f := range page.Files {
+				isDir := f.MimeType == "application/vnd.google-apps.folder"
+				fullPath := path.Join(dirPath, f.Name)
+				g.cacheMu.Lock()
+				g.cache[fullPath] = f
+				g.cacheMu.Unlock()
+
+				err := fn(FileInfo{
+					Name:     f.Name,
+					Size:     f.Size,
+					IsDir:    isDir,
+					ModTime:  parseGoogleTime(f.ModifiedTime),
+					Path:     fullPath,
+					MimeType: f.MimeType,
+				})
+				if err == SkipDir {
+					continue
+				}
+				if err != nil {
+					fnErr = err
+					return err
+				}
+				if isDir && !visited[f.Id] {
+					visited[f.Id] = true
+					subfolders = append(subfolders, subfolder{f.Id, fullPath})
+				}
+			}
+			return nil
+		})
+	if fnErr != nil {
+		return fnErr
+	}
+	if err != nil {
+		return fmt.Errorf("unable to list files: %v", err)
+	}
+
+	for _, sub := range subfolders {
+		if err := g.walkFolder(sub.id, sub.path, depth+1, visited, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Stat returns information about a file
 func (g *GDriveStorage) Stat(filePath string) (FileInfo, error) {
 	fileID, err := g.getFileID(filePath)
@@ -128,6 +213,13 @@ func (g *GDriveStorage) Stat(filePath string) (FileInfo, error) {
 	}, nil
 }
 
+// ExactSize reports whether info reads back at its listed size, which
+// Google Docs, Sheets and Slides don't: they have no size and are
+// exported on read
+func (g *GDriveStorage) ExactSize(info FileInfo) bool {
+	return !strings.HasPrefix(info.MimeType, "application/vnd.google-apps.")
+}
+
 // Read reads a file from Google Drive
 func (g *GDriveStorage) Read(filePath string) (io.ReadCloser, error) {
 	fileID, err := g.getFileID(filePath)
@@ -171,23 +263,19 @@ func (g *GDriveStorage) Write(filePath string, data io.Reader) error {
 	// Check if file already exists
 	existingID, _ := g.getFileID(filePath)
 
-	// Read all data
-	content, err := io.ReadAll(data)
-	if err != nil {
-		return err
-	}
-
+	// Media streams data with a resumable upload, buffering one chunk at
+	// a time
 	if existingID != "" {
 		// Update existing file
 		_, err = g.service.Files.Update(existingID, &drive.File{
 			Name: fileName,
-		}).Media(strings.NewReader(string(content))).Do()
+		}).Media(data).Do()
 	} else {
 		// Create new file
 		_, err = g.service.Files.Create(&drive.File{
 			Name:    fileName,
 			Parents: []string{parentID},
-		}).Media(strings.NewReader(string(content))).Do()
+		}).Media(data).Do()
 	}
 
 	return err
@@ -195,6 +283,10 @@ func (g *GDriveStorage) Write(filePath string, data io.Reader) error {
 
 // Delete deletes a file or folder
 func (g *GDriveStorage) Delete(filePath string) error {
+	if g.permanentDelete {
+		return g.DeletePermanently(filePath)
+	}
+
 	fileID, err := g.getFileID(filePath)
 	if err != nil {
 		return err
@@ -206,11 +298,33 @@ func (g *GDriveStorage) Delete(filePath string) error {
 	}).Do()
 
 	if err != nil {
+		if isGoogleRateLimit(err) {
+			return fmt.Errorf("unable to delete file: %w: %v", ErrRateLimited, err)
+		}
 		return fmt.Errorf("unable to delete file: %v", err)
 	}
 
-	// Remove from cache
-	delete(g.cache, filePath)
+	g.invalidateCache(filePath)
+
+	return nil
+}
+
+// DeletePermanently removes a file or folder without going through the
+// trash, freeing its quota straight away. It cannot be undone.
+func (g *GDriveStorage) DeletePermanently(filePath string) error {
+	fileID, err := g.getFileID(filePath)
+	if err != nil {
+		return err
+	}
+
+	if err := g.service.Files.Delete(fileID).Do(); err != nil {
+		if isGoogleRateLimit(err) {
+			return fmt.Errorf("unable to delete file permanently: %w: %v", ErrRateLimited, err)
+		}
+		return fmt.Errorf("unable to delete file permanently: %v", err)
+	}
+
+	g.invalidateCache(filePath)
 
 	return nil
 }
@@ -262,8 +376,9 @@ func (g *GDriveStorage) Move(src, dst string) error {
 		return fmt.Errorf("unable to move file: %v", err)
 	}
 
-	// Update cache
-	delete(g.cache, src)
+	// Everything cached below either path now has a different ID or none
+	g.invalidateCache(src)
+	g.invalidateCache(dst)
 
 	return nil
 }
@@ -380,42 +495,38 @@ func (g *GDriveStorage) PutFileContent(filePath string, content []byte) error {
 	return g.Write(filePath, strings.NewReader(string(content)))
 }
 
-// Search searches for files
-func (g *GDriveStorage) Search(query string, options map[string]interface{}) ([]FileInfo, error) {
-	// Build search query
-	driveQuery := fmt.Sprintf("name contains '%s' and trashed = false", query)
+// Helper functions
 
-	if mimeType, ok := options["mimeType"].(string); ok {
-		driveQuery += fmt.Sprintf(" and mimeType = '%s'", mimeType)
+// isGoogleRateLimit reports whether err is Drive asking the client to slow
+// down
+func isGoogleRateLimit(err error) bool {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return false
 	}
-
-	fileList, err := g.service.Files.List().
-		Q(driveQuery).
-		Fields("files(id, name, size, mimeType, modifiedTime, parents)").
-		PageSize(100).
-		Do()
-
-	if err != nil {
-		return nil, err
+	if apiErr.Code == http.StatusTooManyRequests {
+		return true
 	}
-
-	var results []FileInfo
-	for _, f := range fileList.Files {
-		isDir := f.MimeType == "application/vnd.google-apps.folder"
-
-		results = append(results, FileInfo{
-			Name:     f.Name,
-			Size:     f.Size,
-			IsDir:    isDir,
-			ModTime:  parseGoogleTime(f.ModifiedTime),
-			MimeType: f.MimeType,
-		})
+	for _, item := range apiErr.Errors {
+		if item.Reason == "rateLimitExceeded" || item.Reason == "userRateLimitExceeded" {
+			return true
+		}
 	}
-
-	return results, nil
+	return false
 }
 
-// Helper functions
+// invalidateCache drops the cached entries for filePath and everything
+// below it
+func (g *GDriveStorage) invalidateCache(filePath string) {
+	prefix := strings.TrimSuffix(filePath, "/") + "/"
+	g.cacheMu.Lock()
+	defer g.cacheMu.Unlock()
+	for key := range g.cache {
+		if key == filePath || strings.HasPrefix(key, prefix) {
+			delete(g.cache, key)
+		}
+	}
+}
 
 func (g *GDriveStorage) getFileID(filePath string) (string, error) {
 	if filePath == "/" || filePath == "" {
@@ -423,7 +534,10 @@ func (g *GDriveStorage) getFileID(filePath string) (string, error) {
 	}
 
 	// Check cache first
-	if cached, ok := g.cache[filePath]; ok {
+	g.cacheMu.Lock()
+	cached, ok := g.cache[filePath]
+	g.cacheMu.Unlock()
+	if ok {
 		return cached.Id, nil
 	}
 
@@ -447,16 +561,87 @@ func (g *GDriveStorage) getFileID(filePath string) (string, error) {
 			return "", err
 		}
 
-		if len(fileList.Files) == 0 {
+		if len(fileList.Files) > 0 {
+			parentID = fileList.Files[0].Id
+			continue
+		}
+
+		if !g.caseInsensitive {
 			return "", fmt.Errorf("file not found: %s", filePath)
 		}
 
-		parentID = fileList.Files[0].Id
+		childID, err := g.findChildFold(parentID, part)
+		if err != nil {
+			return "", err
+		}
+		if childID == "" {
+			return "", fmt.Errorf("file not found: %s", filePath)
+		}
+		parentID = childID
 	}
 
 	return parentID, nil
 }
 
+// NativeID returns the Drive file ID, which stays the same when the file
+// is renamed or moved
+func (g *GDriveStorage) NativeID(filePath string) (string, error) {
+	return g.getFileID(filePath)
+}
+
+// PathConventions reports Drive's names as case-sensitive unless lookups
+// fall back to other cases. Drive itself accepts "/" in a name, but such a
+// file can't be reached by path.
+func (g *GDriveStorage) PathConventions() PathConventions {
+	conventions := unixPathConventions
+	conventions.CaseSensitive = !g.caseInsensitive
+	conventions.ReservedChars = "/"
+	return conventions
+}
+
+// SetCaseInsensitive enables case-insensitive path lookups
+func (g *GDriveStorage) SetCaseInsensitive(enabled bool) {
+	g.caseInsensitive = enabled
+}
+
+// SetPermanentDelete makes Delete bypass the trash
+func (g *GDriveStorage) SetPermanentDelete(enabled bool) {
+	g.permanentDelete = enabled
+}
+
+// findChildFold lists a folder's children and returns the ID of the one
+// whose name matches regardless of case
+func (g *GDriveStorage) findChildFold(parentID, name string) (string, error) {
+	query := fmt.Sprintf("'%s' in parents and trashed = false", parentID)
+	pageToken := ""
+
+	for {
+		call := g.service.Files.List().
+			Q(query).
+			Fields("nextPageToken, files(id, name)").
+			PageSize(1000)
+		if pageToken != "" {
+			call = call.PageToken(pageToken)
+		}
+
+		fileList, err := call.Do()
+		if err != nil {
+			return "", err
+		}
+
+		for _, file := range fileList.Files {
+			if strings.EqualFold(file.Name, name) {
+				return file.Id, nil
+			}
+		}
+
+		if fileList.NextPageToken == "" {
+			return "", nil
+		}
+		pageToken = fileList.NextPageToken
+	}
+}
+
 func (g *GDriveStorage) getOrCreatePath(dirPath string) (string, error) {
 	if dirPath == "/" || dirPath == "" {
 		return g.rootID, nil
@@ -0,0 +1,221 @@
+//go:build !basic
+// +build !basic
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/option"
+)
+
+// driveCall is a request received by the mock Drive API
+type driveCall struct {
+	method string
+	path   string
+	body   map[string]interface{}
+}
+
+// newMockGDrive returns a GDriveStorage talking to a server that accepts
+// any call and records it. /report.pdf resolves to file ID "file-1".
+func newMockGDrive(t *testing.T) (*GDriveStorage, func() []driveCall) {
+	var mu sync.Mutex
+	var calls []driveCall
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		call := driveCall{method: r.Method, path: r.URL.Path}
+		_ = json.NewDecoder(r.Body).Decode(&call.body)
+		mu.Lock()
+		calls = append(calls, call)
+		mu.Unlock()
+
+		if r.Method == http.MethodDelete {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id": "file-1"}`))
+	}))
+	t.Cleanup(server.Close)
+
+	service, err := drive.NewService(context.Background(),
+		option.WithEndpoint(server.URL+"/"),
+		option.WithHTTPClient(server.Client()))
+	if err != nil {
+		t.Fatalf("Failed to create Drive service: %v", err)
+	}
+
+	g := &GDriveStorage{
+		service: service,
+		rootID:  "root",
+		cache:   map[string]*drive.File{"/report.pdf": {Id: "file-1"}},
+	}
+	return g, func() []driveCall {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]driveCall(nil), calls...)
+	}
+}
+
+func TestGDriveStorage_Delete(t *testing.T) {
+	t.Run("Trash by default", func(t *testing.T) {
+		g, calls := newMockGDrive(t)
+		if err := g.Delete("/report.pdf"); err != nil {
+			t.Fatalf("Failed to delete: %v", err)
+		}
+
+		got := calls()
+		if len(got) != 1 || got[0].method != http.MethodPatch || got[0].path != "/files/file-1" {
+			t.Fatalf("Expected a single PATCH of /files/file-1, got %+v", got)
+		}
+		if got[0].body["trashed"] != true {
+			t.Errorf("Expected trashed=true, got %v", got[0].body)
+		}
+	})
+
+	t.Run("Permanent", func(t *testing.T) {
+		g, calls := newMockGDrive(t)
+		if err := g.DeletePermanently("/report.pdf"); err != nil {
+			t.Fatalf("Failed to delete permanently: %v", err)
+		}
+
+		got := calls()
+		if len(got) != 1 || got[0].method != http.MethodDelete || got[0].path != "/files/file-1" {
+			t.Fatalf("Expected a single DELETE of /files/file-1, got %+v", got)
+		}
+		if _, cached := g.cache["/report.pdf"]; cached {
+			t.Error("Expected the deleted file to leave the cache")
+		}
+	})
+
+	t.Run("Permanent by config", func(t *testing.T) {
+		g, calls := newMockGDrive(t)
+		g.SetPermanentDelete(true)
+		if err := g.Delete("/report.pdf"); err != nil {
+			t.Fatalf("Failed to delete: %v", err)
+		}
+		if got := calls(); len(got) != 1 || got[0].method != http.MethodDelete {
+			t.Errorf("Expected Delete to bypass the trash, got %+v", got)
+		}
+	})
+}
+
+func TestGDriveStorage_MoveInvalidatesCache(t *testing.T) {
+	// After the move, "new" is folder dir-1 in the root, holding sub-1,
+	// holding file-2
+	children := map[string]string{
+		"name = 'new' and 'root' in parents and trashed = false":       "dir-1",
+		"name = 'sub' and 'dir-1' in parents and trashed = false":      "sub-1",
+		"name = 'file.txt' and 'sub-1' in parents and trashed = false": "file-2",
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path != "/files" {
+			_, _ = w.Write([]byte(`{"id": "dir-1", "parents": ["root"]}`))
+			return
+		}
+		if id, ok := children[r.URL.Query().Get("q")]; ok {
+			fmt.Fprintf(w, `{"files": [{"id": %q}]}`, id)
+			return
+		}
+		_, _ = w.Write([]byte(`{"files": []}`))
+	}))
+	defer server.Close()
+
+	service, err := drive.NewService(context.Background(),
+		option.WithEndpoint(server.URL+"/"),
+		option.WithHTTPClient(server.Client()))
+	if err != nil {
+		t.Fatalf("Failed to create Drive service: %v", err)
+	}
+	g := &GDriveStorage{service: service, rootID: "root", cache: map[string]*drive.File{
+		"/old":              {Id: "dir-1"},
+		"/old/sub":          {Id: "sub-1"},
+		"/old/sub/file.txt": {Id: "file-2"},
+		"/new/sub/file.txt": {Id: "stale"},
+		"/older/file.txt":   {Id: "file-3"},
+	}}
+
+	if err := g.Move("/old", "/new"); err != nil {
+		t.Fatalf("Failed to move: %v", err)
+	}
+
+	for _, key := range []string{"/old", "/old/sub", "/old/sub/file.txt", "/new/sub/file.txt"} {
+		if _, cached := g.cache[key]; cached {
+			t.Errorf("Expected %s to leave the cache", key)
+		}
+	}
+	if _, cached := g.cache["/older/file.txt"]; !cached {
+		t.Error("Expected a sibling sharing the prefix to stay cached")
+	}
+
+	if id, err := g.getFileID("/new/sub/file.txt"); err != nil || id != "file-2" {
+		t.Errorf("Expected the moved child to resolve to file-2, got %q, %v", id, err)
+	}
+	if id, err := g.getFileID("/old/sub/file.txt"); err == nil {
+		t.Errorf("Expected the old path to be gone, got %q", id)
+	}
+}
+
+func TestGDriveStorage_WriteStreams(t *testing.T) {
+	var mu sync.Mutex
+	received := sha256.New()
+	var receivedLen int64
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Query().Get("uploadType") == "resumable":
+			w.Header().Set("Location", server.URL+"/session")
+		case r.URL.Path == "/session":
+			// Chunks are hashed, not kept, so the server's memory doesn't
+			// count against the client's
+			mu.Lock()
+			n, _ := io.Copy(received, r.Body)
+			receivedLen += n
+			total := receivedLen
+			mu.Unlock()
+			if strings.HasSuffix(r.Header.Get("Content-Range"), "/*") {
+				w.Header().Set("X-HTTP-Status-Code-Override", "308")
+				w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", total-1))
+				return
+			}
+		default:
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id": "file-1"}`))
+	}))
+	defer server.Close()
+
+	service, err := drive.NewService(context.Background(),
+		option.WithEndpoint(server.URL+"/"),
+		option.WithHTTPClient(server.Client()))
+	if err != nil {
+		t.Fatalf("Failed to create Drive service: %v", err)
+	}
+	g := &GDriveStorage{service: service, rootID: "root", cache: map[string]*drive.File{"/report.pdf": {Id: "file-1"}}}
+
+	size := int64(48 << 20)
+	source := newHeapWatcher(&patternReader{size: size})
+	if err := g.Write("/report.pdf", source); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if growth := source.growth(); growth > size/2 {
+		t.Errorf("Expected the upload to be streamed, heap grew by %d bytes", growth)
+	}
+	want := sha256.Sum256(patternBytes(size))
+	if receivedLen != size || !bytes.Equal(received.Sum(nil), want[:]) {
+		t.Errorf("Uploaded content differs (%d bytes, want %d)", receivedLen, size)
+	}
+}
@@ -0,0 +1,89 @@
+//go:build !basic
+// +build !basic
+
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+)
+
+func TestInfo(t *testing.T) {
+	installFakeMounts(t)
+	nfs, err := NewNFSStorage("nas", "/export", t.TempDir(), true)
+	if err != nil {
+		t.Fatalf("Failed to create NFS storage: %v", err)
+	}
+	defer nfs.Close()
+
+	// Nothing listens on port 1, so only the local settings are reported
+	rdb := &RDBStorage{
+		client:    redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1}),
+		ctx:       context.Background(),
+		namespace: "files",
+		maxSize:   100 << 20,
+	}
+	defer rdb.client.Close()
+
+	tests := []struct {
+		name string
+		fs   FileSystem
+		want map[string]interface{}
+	}{
+		{
+			name: "nfs",
+			fs:   nfs,
+			want: map[string]interface{}{"server": "nas", "exportPath": "/export", "mounted": true, "readOnly": true},
+		},
+		{
+			name: "redis",
+			fs:   rdb,
+			want: map[string]interface{}{"type": "redis", "namespace": "files", "maxSize": int64(100 << 20)},
+		},
+		{
+			name: "s3",
+			fs:   &S3FileSystem{S3Storage: &S3Storage{bucket: "media", region: "eu-west-1", endpoint: "https://minio.local", secretKey: "secret"}},
+			want: map[string]interface{}{"bucket": "media", "region": "eu-west-1", "endpoint": "https://minio.local"},
+		},
+		{
+			name: "azureblob",
+			fs:   &AzureBlobStorage{account: "acme", container: "archives", prefix: "backups", auth: "shared_key"},
+			want: map[string]interface{}{"account": "acme", "container": "archives", "prefix": "backups", "auth": "shared_key"},
+		},
+		{
+			name: "ftp",
+			fs:   &FTPAdapter{&FTPStorage{protocol: "ftp", host: "ftp.example.com", port: "21"}},
+			want: map[string]interface{}{"protocol": "ftp", "host": "ftp.example.com", "tls": false, "encrypted": false, "connected": false},
+		},
+		{
+			name: "sftp",
+			fs:   &FTPAdapter{&FTPStorage{protocol: "sftp", host: "sftp.example.com", hostKeyFingerprint: "SHA256:abc"}},
+			want: map[string]interface{}{"protocol": "sftp", "encrypted": true, "hostKeyPinned": true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Through the stats wrapper, as the manager hands storages out
+			info := Info(NewStatsFileSystem(tt.fs))
+			for key, want := range tt.want {
+				if got, ok := info[key]; !ok || got != want {
+					t.Errorf("Expected %s=%v, got %v", key, want, info[key])
+				}
+			}
+			for key, value := range info {
+				if s, ok := value.(string); ok && s == "secret" {
+					t.Errorf("Info leaks a credential in %s", key)
+				}
+			}
+		})
+	}
+
+	t.Run("local has none", func(t *testing.T) {
+		if info := Info(NewLocalStorage(t.TempDir())); info == nil || len(info) != 0 {
+			t.Errorf("Expected an empty map, got %v", info)
+		}
+	})
+}
@@ -1,10 +1,33 @@
 package storage
 
 import (
+	"errors"
+	"fmt"
 	"io"
+	"log"
+	"os"
+	"path"
 	"time"
 )
 
+// ErrNotSupported is returned when a backend doesn't support an operation
+var ErrNotSupported = errors.New("operation not supported by this storage")
+
+// ErrReadOnly is returned for writes to a storage that doesn't allow them
+var ErrReadOnly = errors.New("storage is read-only")
+
+// ErrPreconditionFailed is returned by conditional writes when the file
+// changed since the caller last saw it
+var ErrPreconditionFailed = errors.New("precondition failed")
+
+// ErrQuotaExceeded is returned when a write fails because the storage or
+// the account behind it is full
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+// ErrSymlink is returned by operations that refuse to act on a symlink
+// because they would change its target instead
+var ErrSymlink = errors.New("path is a symlink")
+
 // FileInfo represents information about a file or directory
 type FileInfo struct {
 	Name        string    `json:"name"`
@@ -16,8 +39,34 @@ type FileInfo struct {
 	MimeType    string    `json:"mime_type,omitempty"`
 	IsLink      bool      `json:"is_link,omitempty"`
 	LinkTarget  string    `json:"link_target,omitempty"`
+
+	// LinkTargetIsDir is set for a symlink whose target is a directory
+	LinkTargetIsDir bool `json:"link_target_is_dir,omitempty"`
+
+	// SizeTruncated is set when Size is a directory's total that stopped
+	// short of the whole tree
+	SizeTruncated bool `json:"size_truncated,omitempty"`
+
+	// IsText is set when the content has been sniffed: true when the file
+	// looks like editable text
+	IsText *bool `json:"is_text,omitempty"`
+
+	// Hash is the hex digest of the content when a listing asks for one.
+	// HashSkipped says why a file has none: "too_large", "unavailable" or
+	// "error".
+	Hash        string `json:"hash,omitempty"`
+	HashSkipped string `json:"hash_skipped,omitempty"`
+
+	// Owner and Group name the file's owner when a listing asks for them,
+	// on backends with Unix-style ownership
+	Owner string `json:"owner,omitempty"`
+	Group string `json:"group,omitempty"`
 }
 
+// UnlimitedSpace is reported as available and total space by backends
+// without a quota, such as object stores
+const UnlimitedSpace = int64(1 << 62)
+
 // ProgressCallback is called during long operations to report progress
 type ProgressCallback func(current, total int64)
 
@@ -46,6 +95,538 @@ type FileSystem interface {
 	ResolvePath(path string) string
 }
 
+// Unwrapper is implemented by decorators, returning the FileSystem they wrap
+type Unwrapper interface {
+	Unwrap() FileSystem
+}
+
+// As returns the first FileSystem in the chain of decorators around fs that
+// is a T, the way errors.As searches wrapped errors. Check for optional
+// capabilities with As rather than a type assertion, which only sees the
+// outermost decorator.
+func As[T any](fs FileSystem) (T, bool) {
+	for fs != nil {
+		if v, ok := fs.(T); ok {
+			return v, true
+		}
+		u, ok := fs.(Unwrapper)
+		if !ok {
+			break
+		}
+		fs = u.Unwrap()
+	}
+	var zero T
+	return zero, false
+}
+
+// RangeReader is implemented by backends that can read part of a file
+// without fetching what comes before it
+type RangeReader interface {
+	// ReadRange returns length bytes of the file starting at offset
+	ReadRange(path string, offset, length int64) (io.ReadCloser, error)
+}
+
+// ReadRange returns length bytes of path starting at offset. Backends
+// with ranged reads fetch only those bytes; otherwise the file is read
+// from the start, seeking to offset when the reader can and discarding
+// the bytes before it when it can't.
+func ReadRange(fs FileSystem, path string, offset, length int64) (io.ReadCloser, error) {
+	if rr, ok := As[RangeReader](fs); ok {
+		return rr.ReadRange(path, offset, length)
+	}
+
+	reader, err := fs.Read(path)
+	if err != nil {
+		return nil, err
+	}
+	if seeker, ok := reader.(io.Seeker); ok {
+		_, err = seeker.Seek(offset, io.SeekStart)
+	} else {
+		_, err = io.CopyN(io.Discard, reader, offset)
+	}
+	if err != nil {
+		reader.Close()
+		return nil, err
+	}
+	return rangeReadCloser{Reader: io.LimitReader(reader, length), Closer: reader}, nil
+}
+
+// HasRangeReads reports whether the backend beneath fs's decorators reads
+// ranges itself, so ReadRange doesn't fetch the bytes before the range.
+// Decorators pass ReadRange through, so As can't tell.
+func HasRangeReads(fs FileSystem) bool {
+	for {
+		u, ok := fs.(Unwrapper)
+		if !ok {
+			break
+		}
+		fs = u.Unwrap()
+	}
+	_, ok := fs.(RangeReader)
+	return ok
+}
+
+// rangeReadCloser reads a window of a file and closes the whole
+type rangeReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// StreamLister is implemented by backends that can yield directory entries
+// incrementally instead of returning the whole listing at once
+type StreamLister interface {
+	ListFunc(path string, fn func(FileInfo) error) error
+}
+
+// ListFunc calls fn for every entry in path, streaming when the backend
+// supports it and falling back to List otherwise. Iteration stops at the
+// first error returned by fn.
+func ListFunc(fs FileSystem, path string, fn func(FileInfo) error) error {
+	if sl, ok := As[StreamLister](fs); ok {
+		return sl.ListFunc(path, fn)
+	}
+
+	files, err := fs.List(path)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := fn(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DirLister is implemented by backends that can list just the
+// subdirectories of a directory more cheaply than listing everything
+type DirLister interface {
+	ListDirs(path string, fn func(FileInfo) error) error
+}
+
+// ListDirsFunc calls fn for every subdirectory of path. Backends without a
+// cheaper way are listed in full and their files skipped.
+func ListDirsFunc(fs FileSystem, path string, fn func(FileInfo) error) error {
+	if dl, ok := As[DirLister](fs); ok {
+		return dl.ListDirs(path, fn)
+	}
+	return ListFunc(fs, path, func(info FileInfo) error {
+		if !info.IsDir {
+			return nil
+		}
+		return fn(info)
+	})
+}
+
+// SkipDir can be returned by a Walk callback for a directory to skip its
+// contents. Returned for a file it is ignored.
+var SkipDir = errors.New("skip this directory")
+
+// Walker is implemented by backends that can enumerate a whole tree more
+// cheaply than by listing it one directory at a time
+type Walker interface {
+	Walk(root string, fn func(FileInfo) error) error
+}
+
+// maxWalkDepth bounds how far Walk descends, as a last defence against a
+// backend that reports a directory inside itself
+const maxWalkDepth = 256
+
+// ErrWalkTruncated is wrapped by the error a walk returns when it stops
+// at a limit rather than at the end of the tree
+var ErrWalkTruncated = errors.New("walk truncated")
+
+// WalkLimits bounds a walk that must not run away on a huge tree. Zero
+// values mean no limit.
+type WalkLimits struct {
+	MaxEntries int
+	Timeout    time.Duration
+}
+
+// DirIdentifier is implemented by backends where one directory can be
+// reached by several paths, through symlinks or bind mounts. DirID returns
+// the same value for each of them, so walks can tell when they come back
+// to a directory they have already been through.
+type DirIdentifier interface {
+	DirID(path string) (string, error)
+}
+
+// Walk calls fn for every entry below root, each directory before its
+// contents, with Path set to root joined with the entry's path relative to
+// it. Symlinked directories are reported but not entered, nor are
+// directories already walked under another path. Backends that implement
+// Walker supply their own traversal; others are walked with ListFunc.
+// Walking stops at the first error from fn other than SkipDir.
+func Walk(fs FileSystem, root string, fn func(FileInfo) error) error {
+	return WalkLimited(fs, root, WalkLimits{}, fn)
+}
+
+// WalkLimited is Walk stopped by limits, returning an error wrapping
+// ErrWalkTruncated once one is reached
+func WalkLimited(fs FileSystem, root string, limits WalkLimits, fn func(FileInfo) error) error {
+	if limits.MaxEntries > 0 || limits.Timeout > 0 {
+		var deadline time.Time
+		if limits.Timeout > 0 {
+			deadline = time.Now().Add(limits.Timeout)
+		}
+		entries := 0
+		unlimited := fn
+		fn = func(info FileInfo) error {
+			if limits.MaxEntries > 0 && entries >= limits.MaxEntries {
+				return fmt.Errorf("%w: more than %d entries", ErrWalkTruncated, limits.MaxEntries)
+			}
+			if !deadline.IsZero() && time.Now().After(deadline) {
+				return fmt.Errorf("%w: took longer than %s", ErrWalkTruncated, limits.Timeout)
+			}
+			entries++
+			return unlimited(info)
+		}
+	}
+
+	if w, ok := As[Walker](fs); ok {
+		return w.Walk(root, fn)
+	}
+	return walkList(fs, root, 0, fn)
+}
+
+// listWalk is the state of a generic walk over ListFunc
+type listWalk struct {
+	fs FileSystem
+	fn func(FileInfo) error
+
+	// ids and visited recognise directories reached a second time, when
+	// the backend can identify them
+	ids     DirIdentifier
+	visited map[string]bool
+}
+
+// walkList is the generic Walk over ListFunc
+func walkList(fs FileSystem, root string, depth int, fn func(FileInfo) error) error {
+	w := &listWalk{fs: fs, fn: fn}
+	if ids, ok := As[DirIdentifier](fs); ok {
+		w.ids = ids
+		w.visited = make(map[string]bool)
+		w.firstVisit(root)
+	}
+	return w.walk(root, depth)
+}
+
+// firstVisit records dir as walked, reporting false if it already was
+func (w *listWalk) firstVisit(dir string) bool {
+	if w.ids == nil {
+		return true
+	}
+	id, err := w.ids.DirID(dir)
+	if err != nil {
+		return true // let the listing report what's wrong
+	}
+	if w.visited[id] {
+		return false
+	}
+	w.visited[id] = true
+	return true
+}
+
+func (w *listWalk) walk(dir string, depth int) error {
+	if depth >= maxWalkDepth {
+		return fmt.Errorf("%w: %s is more than %d directory levels deep", ErrWalkTruncated, dir, maxWalkDepth)
+	}
+
+	// Subdirectories are entered once the listing is done, as some
+	// backends can't start a listing while another is in progress
+	var subdirs []string
+	err := ListFunc(w.fs, dir, func(entry FileInfo) error {
+		if entry.Name == "" || entry.Name == "." || entry.Name == ".." {
+			return nil
+		}
+		entry.Path = path.Join(dir, entry.Name)
+		if err := w.fn(entry); err != nil {
+			if err == SkipDir {
+				return nil
+			}
+			return err
+		}
+		if entry.IsDir && !entry.IsLink {
+			subdirs = append(subdirs, entry.Path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, subdir := range subdirs {
+		if !w.firstVisit(subdir) {
+			log.Printf("Walk: not entering %s, which was already walked under another path", subdir)
+			continue
+		}
+		if err := w.walk(subdir, depth+1); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// LinkResolver is implemented by backends that can resolve a symlink to the
+// canonical storage path it ultimately points to
+type LinkResolver interface {
+	ResolveLink(path string) (string, error)
+}
+
+// Chmoder is implemented by backends with Unix-style permissions
+type Chmoder interface {
+	Chmod(path string, mode os.FileMode) error
+}
+
+// Chowner is implemented by backends with Unix-style file ownership. A uid
+// or gid of -1 leaves that value unchanged.
+type Chowner interface {
+	Chown(path string, uid, gid int) error
+}
+
+// LocalOwner is implemented by backends whose files are owned by the
+// users and groups of this host, so owner names can be looked up in its
+// user database. Other backends, such as SFTP, number owners remotely.
+type LocalOwner interface {
+	LocalOwners() bool
+}
+
+// OwnerReader is implemented by backends with Unix-style file ownership,
+// reporting the user and group names of a file
+type OwnerReader interface {
+	Owner(path string) (owner, group string, err error)
+}
+
+// ContentTypeWriter is implemented by backends that store a Content-Type
+// with each file, allowing callers to override the detected type
+type ContentTypeWriter interface {
+	WriteContentType(path string, data io.Reader, contentType string) error
+}
+
+// SizedWriter is implemented by backends that need a file's size before
+// uploading it, like OneDrive's upload sessions. Told the size, they
+// stream the data straight through instead of spooling it to find out.
+// progress, when not nil, is called as the backend confirms each part.
+type SizedWriter interface {
+	WriteSized(path string, data io.Reader, size int64, progress ProgressCallback) error
+}
+
+// WriteSized writes the size bytes of data to path, passing the size on to
+// backends that can use it. A negative size is unknown, and so is the same
+// as Write.
+func WriteSized(fs FileSystem, path string, data io.Reader, size int64, progress ProgressCallback) error {
+	if sw, ok := As[SizedWriter](fs); ok && size >= 0 {
+		return sw.WriteSized(path, data, size, progress)
+	}
+	return fs.Write(path, data)
+}
+
+// remainingSize returns how many bytes are left to read from data, if it
+// can seek, leaving it where it was
+func remainingSize(data io.Reader) (int64, bool) {
+	seeker, ok := data.(io.Seeker)
+	if !ok {
+		return 0, false
+	}
+	current, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, false
+	}
+	end, err := seeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, false
+	}
+	if _, err := seeker.Seek(current, io.SeekStart); err != nil {
+		return 0, false
+	}
+	return end - current, true
+}
+
+// ExactSizer is implemented by backends whose files don't always read
+// back as many bytes as Stat reports, like the Google Docs that Google
+// Drive lists without a size and exports on read. ExactSize reports
+// whether reading info returns exactly info.Size bytes.
+type ExactSizer interface {
+	ExactSize(info FileInfo) bool
+}
+
+// ReadOnlyReporter is implemented by backends that can be configured
+// read-only
+type ReadOnlyReporter interface {
+	IsReadOnly() bool
+}
+
+// InfoReporter is implemented by backends with diagnostics worth showing
+// operators, such as the state of a mount or connection
+type InfoReporter interface {
+	Info() map[string]interface{}
+}
+
+// Info returns the backend's diagnostics, or an empty map for backends
+// without any
+func Info(fs FileSystem) map[string]interface{} {
+	if ir, ok := As[InfoReporter](fs); ok {
+		if info := ir.Info(); info != nil {
+			return info
+		}
+	}
+	return map[string]interface{}{}
+}
+
+// UsageReporter is implemented by backends that can tell how many bytes
+// they hold even without a capacity to report them against, such as an
+// object store configured to add up its objects
+type UsageReporter interface {
+	Usage() (int64, error)
+}
+
+// Usage returns the bytes fs reports holding, or false for backends that
+// can't tell
+func Usage(fs FileSystem) (int64, bool) {
+	if ur, ok := As[UsageReporter](fs); ok {
+		if used, err := ur.Usage(); err == nil {
+			return used, true
+		}
+	}
+	return 0, false
+}
+
+// ConcurrencyLimiter is implemented by backends that can only serve a few
+// requests at once, such as plain FTP over its single control connection.
+// MaxConcurrency returns that number, or 0 for no limit.
+type ConcurrencyLimiter interface {
+	MaxConcurrency() int
+}
+
+// File event types reported by Watcher
+const (
+	FileEventCreate = "create"
+	FileEventModify = "modify"
+	FileEventDelete = "delete"
+	FileEventRename = "rename"
+)
+
+// FileEvent is a change to a watched file or directory entry. A rename is
+// reported under the old path, with a create for the new one if it is
+// still being watched.
+type FileEvent struct {
+	Type string `json:"type"`
+	Path string `json:"path"`
+}
+
+// Watcher is implemented by backends that can notify of changes. Watch
+// reports changes to the entries of a directory, not descending into
+// subdirectories, or to a single file. The channel is closed after stop
+// is called.
+type Watcher interface {
+	Watch(path string) (events <-chan FileEvent, stop func(), err error)
+}
+
+// MoveFallbacker is implemented by backends whose native move can fail in
+// ways that copying the data gets around. MoveFallback reports whether Move
+// may then finish the job by copying and deleting.
+type MoveFallbacker interface {
+	MoveFallback() bool
+}
+
+// SingleTransferer is implemented by backends whose connection carries one
+// transfer at a time. SingleTransfer reports whether a file read from the
+// storage must be closed, or read to the end, before anything else is done
+// on it.
+type SingleTransferer interface {
+	SingleTransfer() bool
+}
+
+// ConditionalWriter is implemented by backends that can check a file's
+// ETag and write it in one atomic step
+type ConditionalWriter interface {
+	// WriteConditional writes path only if its current ETag is ifMatch, or,
+	// when ifMatch is empty, only if it doesn't exist yet. Otherwise it
+	// returns ErrPreconditionFailed. An empty contentType picks one from the
+	// file extension.
+	WriteConditional(path string, data io.Reader, contentType, ifMatch string) error
+}
+
+// ETagger is implemented by backends that keep an entity tag per file
+type ETagger interface {
+	ETag(path string) (string, error)
+}
+
+// ContentHasher is implemented by backends that store a hash of each
+// file's content, so it can be reported without reading the file
+type ContentHasher interface {
+	// ContentHashes returns the hex digests kept for the files directly in
+	// dirPath, keyed by name. algo is "md5", "sha1", "sha256" or
+	// "quickxor"; files without that kind of hash are left out, and
+	// ErrNotSupported is returned for algorithms the backend never keeps.
+	ContentHashes(dirPath, algo string) (map[string]string, error)
+}
+
+// NativeIDer is implemented by backends that have their own identifier for
+// a file, one that survives renames (an inode, a cloud file ID)
+type NativeIDer interface {
+	NativeID(path string) (string, error)
+}
+
+// PermanentDeleter is implemented by backends whose Delete moves files to
+// a trash, offering a way to remove them for good instead
+type PermanentDeleter interface {
+	DeletePermanently(path string) error
+}
+
+// ServerSideCopier is implemented by backends that can copy a file from
+// another storage of the same provider without the data passing through
+// the server. CopyFrom returns ErrNotSupported when src can't be reached
+// that way, and the caller then streams the file instead.
+type ServerSideCopier interface {
+	CopyFrom(src FileSystem, srcPath, dstPath string) error
+}
+
+// OverwriteCopier is implemented by backends whose native copy can be told
+// to leave an existing destination alone, checking for it and copying in
+// one step. With overwrite false, CopyOverwrite fails with an error
+// matching os.ErrExist when dst exists, and dst is left untouched.
+type OverwriteCopier interface {
+	CopyOverwrite(src, dst string, overwrite bool, progress ProgressCallback) error
+}
+
+// CopyNoOverwrite copies src to dst within fs without replacing dst, on
+// backends that can refuse to. Elsewhere it is fs.Copy, and the caller has
+// to have checked that dst is free.
+func CopyNoOverwrite(fs FileSystem, src, dst string, progress ProgressCallback) error {
+	if oc, ok := As[OverwriteCopier](fs); ok {
+		return oc.CopyOverwrite(src, dst, false, progress)
+	}
+	return fs.Copy(src, dst, progress)
+}
+
+// PresignOptions constrains a presigned upload
+type PresignOptions struct {
+	// Expires is how long the upload stays possible
+	Expires time.Duration
+	// MaxSize is the largest object accepted, in bytes; 0 leaves it to the
+	// backend's own limit
+	MaxSize int64
+	// ContentType is the Content-Type the upload must declare. A trailing
+	// "*", as in "image/*", accepts any type with that prefix.
+	ContentType string
+}
+
+// PresignedUpload describes a browser form upload straight to the backend:
+// POST the Fields, then the file as "file", as multipart/form-data to URL
+type PresignedUpload struct {
+	URL     string            `json:"url"`
+	Fields  map[string]string `json:"fields"`
+	Expires time.Time         `json:"expires"`
+}
+
+// UploadPresigner is implemented by backends that can authorize a client
+// to upload one file directly, without the data passing through the server
+type UploadPresigner interface {
+	PresignUpload(path string, opts PresignOptions) (*PresignedUpload, error)
+}
+
 // Manager manages multiple storage backends
 type Manager struct {
 	storages map[string]FileSystem
@@ -58,11 +639,23 @@ func NewManager() *Manager {
 	}
 }
 
-// Register adds a new storage backend
+// Register adds a new storage backend, counting its usage for Stats
 func (m *Manager) Register(id string, fs FileSystem) {
+	if _, ok := fs.(*StatsFileSystem); !ok {
+		fs = NewStatsFileSystem(fs)
+	}
 	m.storages[id] = fs
 }
 
+// Stats returns the usage counters of a storage
+func (m *Manager) Stats(id string) (*StatsFileSystem, bool) {
+	fs, ok := m.storages[id]
+	if !ok {
+		return nil, false
+	}
+	return As[*StatsFileSystem](fs)
+}
+
 // Get retrieves a storage backend by ID
 func (m *Manager) Get(id string) (FileSystem, bool) {
 	fs, ok := m.storages[id]
@@ -86,3 +679,36 @@ func (m *Manager) GetAll() map[string]FileSystem {
 	}
 	return result
 }
+
+// MultipartWriter is implemented by storages that take a file as separately
+// uploaded parts, so large uploads reach the backend while still arriving.
+// Every part but the last must be exactly MultipartPartSize bytes; a part
+// size of 0 means a wrapper found no multipart uploads in the backend.
+type MultipartWriter interface {
+	MultipartPartSize() int64
+	// StartMultipart begins an upload to path and returns its upload ID
+	StartMultipart(path, contentType string) (string, error)
+	// WritePart stores part n (from 1) and returns a token that
+	// CompleteMultipart needs for it
+	WritePart(path, uploadID string, n int, data io.ReadSeeker) (string, error)
+	// CompleteMultipart assembles the parts, given their tokens in order
+	CompleteMultipart(path, uploadID string, parts []string) error
+	// AbortMultipart discards the upload and any parts already stored
+	AbortMultipart(path, uploadID string) error
+}
+
+// MultipartUpload is an unfinished multipart upload held by a storage
+type MultipartUpload struct {
+	Path     string    `json:"path"`
+	UploadID string    `json:"upload_id"`
+	Started  time.Time `json:"started"`
+	// Size is the total of the parts stored so far
+	Size int64 `json:"size"`
+}
+
+// MultipartLister is implemented by storages that keep the parts of
+// unfinished multipart uploads, and charge for them, until the upload is
+// completed or aborted with AbortMultipart
+type MultipartLister interface {
+	ListMultipart() ([]MultipartUpload, error)
+}
@@ -7,13 +7,22 @@ import (
 	"mime"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"syscall"
+	"time"
+)
+
+// Limits on adding up the size of one directory for ListWithDirSizes
+const (
+	dirSizeMaxEntries = 1000000
+	dirSizeTimeout    = 30 * time.Second
 )
 
 // LocalStorage implements FileSystem for local filesystem access
 type LocalStorage struct {
-	rootPath string
+	rootPath       string
+	preserveXattrs bool
 }
 
 // NewLocalStorage creates a new local storage instance
@@ -32,11 +41,33 @@ func NewLocalStorage(rootPath string) *LocalStorage {
 	}
 }
 
+// SetPreserveXattrs makes copies carry over the extended attributes of
+// files and directories, such as macOS Finder tags and SELinux contexts
+func (ls *LocalStorage) SetPreserveXattrs(preserve bool) {
+	ls.preserveXattrs = preserve
+}
+
 // GetType returns the storage type
 func (ls *LocalStorage) GetType() string {
 	return "local"
 }
 
+// PathConventions reports the conventions of the host's filesystems. The
+// default filesystems of Windows and macOS ignore case; Windows also
+// refuses device names and a set of punctuation in names.
+func (ls *LocalStorage) PathConventions() PathConventions {
+	conventions := unixPathConventions
+	switch runtime.GOOS {
+	case "windows":
+		conventions.CaseSensitive = false
+		conventions.ReservedNames = windowsReservedNames
+		conventions.ReservedChars = windowsReservedChars
+	case "darwin":
+		conventions.CaseSensitive = false
+	}
+	return conventions
+}
+
 // GetRootPath returns the root path of this storage
 func (ls *LocalStorage) GetRootPath() string {
 	return ls.rootPath
@@ -67,6 +98,29 @@ func (ls *LocalStorage) IsValidPath(path string) bool {
 	return strings.HasPrefix(resolvedPath, ls.rootPath)
 }
 
+// ResolveLink follows all symlinks in path and returns the resulting
+// location relative to the root. Targets outside the root are rejected.
+func (ls *LocalStorage) ResolveLink(path string) (string, error) {
+	realPath, err := filepath.EvalSymlinks(ls.ResolvePath(path))
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve link: %w", err)
+	}
+
+	realRoot, err := filepath.EvalSymlinks(ls.rootPath)
+	if err != nil {
+		realRoot = ls.rootPath
+	}
+
+	relPath, err := filepath.Rel(realRoot, realPath)
+	if err != nil || relPath == ".." || strings.HasPrefix(relPath, "../") {
+		return "", fmt.Errorf("link target is outside the storage root")
+	}
+	if relPath == "." {
+		return "/", nil
+	}
+	return "/" + relPath, nil
+}
+
 // JoinPath joins path parts safely
 func (ls *LocalStorage) JoinPath(parts ...string) string {
 	return filepath.Join(parts...)
@@ -92,27 +146,87 @@ func (ls *LocalStorage) list(path string, calcDirSizes bool) ([]FileInfo, error)
 
 	var files []FileInfo
 	for _, entry := range entries {
-		// Get FileInfo from DirEntry
-		entryInfo, err := entry.Info()
-		if err != nil {
+		info, ok := ls.entryInfo(fullPath, entry, calcDirSizes)
+		if !ok {
 			continue // Skip entries we can't stat
 		}
+		files = append(files, info)
+	}
+
+	return files, nil
+}
+
+// ListFunc calls fn for each entry of a directory, reading it in batches so
+// very large directories never have to be held in memory at once. Entries
+// are yielded in directory order rather than sorted by name.
+func (ls *LocalStorage) ListFunc(path string, fn func(FileInfo) error) error {
+	return ls.listFunc(path, false, fn)
+}
 
-		info := ls.fileInfoFromOS(entryInfo, filepath.Join(fullPath, entry.Name()))
+// ListDirs calls fn for each subdirectory of a directory. Other entries are
+// skipped by their directory entry type, without a stat each.
+func (ls *LocalStorage) ListDirs(path string, fn func(FileInfo) error) error {
+	return ls.listFunc(path, true, fn)
+}
 
-		// Calculate directory size if requested
-		if calcDirSizes && info.IsDir {
-			info.Size = ls.calculateDirSize(filepath.Join(fullPath, entry.Name()))
+// listFunc reads a directory in batches, yielding every entry or, with
+// dirsOnly, only the directories
+func (ls *LocalStorage) listFunc(path string, dirsOnly bool, fn func(FileInfo) error) error {
+	fullPath := ls.ResolvePath(path)
+
+	dir, err := os.Open(fullPath)
+	if err != nil {
+		return fmt.Errorf("failed to read directory: %w", err)
+	}
+	defer func() {
+		if err := dir.Close(); err != nil {
+			log.Printf("Error closing directory: %v", err)
 		}
+	}()
 
-		// Make path relative to root for response
-		relPath, _ := filepath.Rel(ls.rootPath, info.Path)
-		info.Path = "/" + relPath
+	for {
+		entries, err := dir.ReadDir(256)
+		for _, entry := range entries {
+			if dirsOnly && !entry.IsDir() {
+				continue
+			}
+			info, ok := ls.entryInfo(fullPath, entry, false)
+			if !ok {
+				continue
+			}
+			if err := fn(info); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read directory: %w", err)
+		}
+	}
+}
 
-		files = append(files, info)
+// entryInfo converts a directory entry of dirPath into a FileInfo with a
+// root-relative path. It reports false if the entry can't be stat'ed.
+func (ls *LocalStorage) entryInfo(dirPath string, entry os.DirEntry, calcDirSizes bool) (FileInfo, bool) {
+	entryInfo, err := entry.Info()
+	if err != nil {
+		return FileInfo{}, false
 	}
 
-	return files, nil
+	info := ls.fileInfoFromOS(entryInfo, filepath.Join(dirPath, entry.Name()))
+
+	// Calculate directory size if requested
+	if calcDirSizes && info.IsDir {
+		info.Size, info.SizeTruncated = ls.calculateDirSize(filepath.Join(dirPath, entry.Name()))
+	}
+
+	// Make path relative to root for response
+	relPath, _ := filepath.Rel(ls.rootPath, info.Path)
+	info.Path = "/" + relPath
+
+	return info, true
 }
 
 // Stat returns information about a file or directory
@@ -145,6 +259,15 @@ func (ls *LocalStorage) Read(path string) (io.ReadCloser, error) {
 	return file, nil
 }
 
+// ReadRange opens a window of a file, reading only the requested bytes
+func (ls *LocalStorage) ReadRange(path string, offset, length int64) (io.ReadCloser, error) {
+	file, err := os.Open(ls.ResolvePath(path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	return rangeReadCloser{Reader: io.NewSectionReader(file, offset, length), Closer: file}, nil
+}
+
 // Write writes data to a file
 func (ls *LocalStorage) Write(path string, data io.Reader) error {
 	fullPath := ls.ResolvePath(path)
@@ -264,7 +387,7 @@ func (ls *LocalStorage) Copy(src, dst string, progress ProgressCallback) error {
 	}
 
 	if srcStat.IsDir() {
-		return ls.copyDirectory(srcPath, dstPath, progress)
+		return ls.copyDirectory(srcPath, dstPath, nil, progress)
 	}
 
 	return ls.copyFile(srcPath, dstPath, srcStat.Size(), progress)
@@ -300,11 +423,10 @@ func (ls *LocalStorage) copyFile(src, dst string, size int64, progress ProgressC
 
 	// Copy with progress callback if provided
 	if progress != nil {
-		return ls.copyWithProgress(srcFile, dstFile, size, progress)
-	}
-
-	// Simple copy without progress
-	if _, err := io.Copy(dstFile, srcFile); err != nil {
+		if err := ls.copyWithProgress(srcFile, dstFile, size, progress); err != nil {
+			return err
+		}
+	} else if _, err := io.Copy(dstFile, srcFile); err != nil {
 		return fmt.Errorf("failed to copy file: %w", err)
 	}
 
@@ -315,6 +437,7 @@ func (ls *LocalStorage) copyFile(src, dst string, size int64, progress ProgressC
 			log.Printf("Warning: failed to preserve file permissions: %v", err)
 		}
 	}
+	ls.preserveAttributes(src, dst)
 
 	return nil
 }
@@ -346,12 +469,30 @@ func (ls *LocalStorage) copyWithProgress(src io.Reader, dst io.Writer, total int
 	return nil
 }
 
-// copyDirectory recursively copies a directory
-func (ls *LocalStorage) copyDirectory(src, dst string, progress ProgressCallback) error {
+// copyDirectory recursively copies a directory. Symlinks inside it are
+// recreated rather than followed. visited holds the directories already
+// copied, by fileID, along with the copies made so far, so a directory
+// copied into itself or reachable twice through a bind mount is only
+// copied once.
+func (ls *LocalStorage) copyDirectory(src, dst string, visited map[string]bool, progress ProgressCallback) error {
+	if visited == nil {
+		visited = make(map[string]bool)
+	}
+	if id, err := fileID(src); err == nil {
+		if visited[id] {
+			log.Printf("Copy: skipping %s, which has already been copied", src)
+			return nil
+		}
+		visited[id] = true
+	}
+
 	// Create destination directory
 	if err := os.MkdirAll(dst, 0755); err != nil {
 		return fmt.Errorf("failed to create destination directory: %w", err)
 	}
+	if id, err := fileID(dst); err == nil {
+		visited[id] = true
+	}
 
 	// Read source directory
 	entries, err := os.ReadDir(src)
@@ -364,11 +505,20 @@ func (ls *LocalStorage) copyDirectory(src, dst string, progress ProgressCallback
 		srcPath := filepath.Join(src, entry.Name())
 		dstPath := filepath.Join(dst, entry.Name())
 
-		if entry.IsDir() {
-			if err := ls.copyDirectory(srcPath, dstPath, progress); err != nil {
+		switch {
+		case entry.Type()&os.ModeSymlink != 0:
+			target, err := os.Readlink(srcPath)
+			if err != nil {
+				continue // Skip links we can't read
+			}
+			if err := os.Symlink(target, dstPath); err != nil {
+				return fmt.Errorf("failed to create symlink: %w", err)
+			}
+		case entry.IsDir():
+			if err := ls.copyDirectory(srcPath, dstPath, visited, progress); err != nil {
 				return err
 			}
-		} else {
+		default:
 			// Get file info to determine size
 			entryInfo, err := entry.Info()
 			if err != nil {
@@ -387,10 +537,84 @@ func (ls *LocalStorage) copyDirectory(src, dst string, progress ProgressCallback
 			log.Printf("Warning: failed to preserve directory permissions: %v", err)
 		}
 	}
+	ls.preserveAttributes(src, dst)
+
+	return nil
+}
+
+// preserveAttributes copies the extended attributes of src to dst when
+// the storage is set to
+func (ls *LocalStorage) preserveAttributes(src, dst string) {
+	if !ls.preserveXattrs {
+		return
+	}
+	if err := copyXattrs(src, dst); err != nil {
+		log.Printf("Warning: failed to preserve extended attributes of %s: %v", src, err)
+	}
+}
+
+// Chmod changes the permission bits of a file or directory. Symlinks are
+// refused, as changing their mode would change their target's.
+func (ls *LocalStorage) Chmod(path string, mode os.FileMode) error {
+	fullPath := ls.ResolvePath(path)
+	if err := refuseSymlink(fullPath); err != nil {
+		return err
+	}
+	if err := os.Chmod(fullPath, mode); err != nil {
+		return fmt.Errorf("failed to change mode: %w", err)
+	}
+	return nil
+}
+
+// refuseSymlink returns ErrSymlink when fullPath is a symlink
+func refuseSymlink(fullPath string) error {
+	info, err := os.Lstat(fullPath)
+	if err != nil {
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		return fmt.Errorf("cannot change the mode of %s: %w", filepath.Base(fullPath), ErrSymlink)
+	}
+	return nil
+}
 
+// Chown changes the owner and group of a file or directory. Symlinks are
+// changed themselves rather than their targets.
+func (ls *LocalStorage) Chown(path string, uid, gid int) error {
+	if err := os.Lchown(ls.ResolvePath(path), uid, gid); err != nil {
+		return fmt.Errorf("failed to change owner: %w", err)
+	}
 	return nil
 }
 
+// LocalOwners reports that files are owned by the users of this host
+func (ls *LocalStorage) LocalOwners() bool {
+	return true
+}
+
+// Owner returns the names of the user and group owning a file
+func (ls *LocalStorage) Owner(path string) (owner, group string, err error) {
+	owner, group, err = fileOwner(ls.ResolvePath(path))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to stat file: %w", err)
+	}
+	return owner, group, nil
+}
+
+// NativeID returns the device and inode number of a file as "dev:ino".
+// Links are not followed.
+func (ls *LocalStorage) NativeID(path string) (string, error) {
+	info, err := os.Lstat(ls.ResolvePath(path))
+	if err != nil {
+		return "", fmt.Errorf("failed to stat file: %w", err)
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", ErrNotSupported
+	}
+	return fmt.Sprintf("%d:%d", stat.Dev, stat.Ino), nil
+}
+
 // GetAvailableSpace returns available and total space for the filesystem
 func (ls *LocalStorage) GetAvailableSpace() (available, total int64, err error) {
 	var stat syscall.Statfs_t
@@ -407,22 +631,41 @@ func (ls *LocalStorage) GetAvailableSpace() (available, total int64, err error)
 	return available, total, nil
 }
 
-// fileInfoFromOS converts os.FileInfo to our FileInfo
-// calculateDirSize recursively calculates the total size of a directory
-func (ls *LocalStorage) calculateDirSize(dirPath string) int64 {
-	var totalSize int64
-	_ = filepath.Walk(dirPath, func(path string, info os.FileInfo, err error) error {
+// calculateDirSize recursively calculates the total size of a directory.
+// Directories reached again through a bind mount are counted once, and a
+// tree too big to add up within the dirSize limits is reported as
+// truncated along with the size counted so far.
+func (ls *LocalStorage) calculateDirSize(dirPath string) (size int64, truncated bool) {
+	deadline := time.Now().Add(dirSizeTimeout)
+	visited := make(map[string]bool)
+	entries := 0
+	_ = filepath.WalkDir(dirPath, func(path string, d os.DirEntry, err error) error {
 		if err != nil {
 			return nil // Skip files/dirs we can't access
 		}
-		if !info.IsDir() {
-			totalSize += info.Size()
+		entries++
+		if entries > dirSizeMaxEntries || time.Now().After(deadline) {
+			truncated = true
+			return filepath.SkipAll
+		}
+		if d.IsDir() {
+			if id, err := fileID(path); err == nil {
+				if visited[id] {
+					return filepath.SkipDir
+				}
+				visited[id] = true
+			}
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			size += info.Size()
 		}
 		return nil
 	})
-	return totalSize
+	return size, truncated
 }
 
+// fileInfoFromOS converts os.FileInfo to our FileInfo
 func (ls *LocalStorage) fileInfoFromOS(info os.FileInfo, fullPath string) FileInfo {
 	fileInfo := FileInfo{
 		Name:        info.Name(),
@@ -439,6 +682,9 @@ func (ls *LocalStorage) fileInfoFromOS(info os.FileInfo, fullPath string) FileIn
 		if target, err := os.Readlink(fullPath); err == nil {
 			fileInfo.LinkTarget = target
 		}
+		if target, err := os.Stat(fullPath); err == nil {
+			fileInfo.LinkTargetIsDir = target.IsDir()
+		}
 	}
 
 	// Determine MIME type for files
@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+// ErrRootNotAllowed is wrapped by errors for local storages rooted outside
+// the allowed directories
+var ErrRootNotAllowed = errors.New("local root path not allowed")
+
+var localRootAllowlist atomic.Pointer[[]string]
+
+// SetLocalRootAllowlist restricts local storages to roots inside the given
+// directories. Symlinks in the directories are resolved. An empty list
+// lifts the restriction.
+func SetLocalRootAllowlist(dirs []string) error {
+	roots := make([]string, 0, len(dirs))
+	for _, dir := range dirs {
+		if strings.TrimSpace(dir) == "" {
+			continue
+		}
+		root, err := resolveLocalRoot(dir)
+		if err != nil {
+			return fmt.Errorf("invalid allowed root %q: %w", dir, err)
+		}
+		roots = append(roots, root)
+	}
+	localRootAllowlist.Store(&roots)
+	return nil
+}
+
+// LocalRootAllowlist returns the directories local storages must be rooted
+// in, or nil when any root is allowed
+func LocalRootAllowlist() []string {
+	if roots := localRootAllowlist.Load(); roots != nil && len(*roots) > 0 {
+		return append([]string(nil), (*roots)...)
+	}
+	return nil
+}
+
+// CheckLocalRoot returns an error wrapping ErrRootNotAllowed unless root
+// lies within an allowed directory. The check follows symlinks, so a link
+// inside an allowed directory can't point a storage elsewhere.
+func CheckLocalRoot(root string) error {
+	allowed := LocalRootAllowlist()
+	if allowed == nil {
+		return nil
+	}
+	resolved, err := resolveLocalRoot(root)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrRootNotAllowed, root, err)
+	}
+	for _, dir := range allowed {
+		rel, err := filepath.Rel(dir, resolved)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s is outside %s", ErrRootNotAllowed, root, strings.Join(allowed, ", "))
+}
+
+// resolveLocalRoot makes dir absolute and resolves the symlinks in the
+// part of it that exists; the rest is created later by NewLocalStorage
+func resolveLocalRoot(dir string) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	existing, missing := abs, ""
+	for {
+		resolved, err := filepath.EvalSymlinks(existing)
+		if err == nil {
+			return filepath.Join(resolved, missing), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			return abs, nil
+		}
+		missing = filepath.Join(filepath.Base(existing), missing)
+		existing = parent
+	}
+}
@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckLocalRoot(t *testing.T) {
+	defer SetLocalRootAllowlist(nil)
+
+	base := t.TempDir()
+	allowed := filepath.Join(base, "data")
+	outside := filepath.Join(base, "etc")
+	for _, dir := range []string{allowed, outside} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", dir, err)
+		}
+	}
+	if err := os.Symlink(outside, filepath.Join(allowed, "escape")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	if err := CheckLocalRoot("/"); err != nil {
+		t.Fatalf("Expected any root to be allowed without an allowlist, got %v", err)
+	}
+	if err := SetLocalRootAllowlist([]string{allowed}); err != nil {
+		t.Fatalf("Failed to set allowlist: %v", err)
+	}
+
+	for _, root := range []string{allowed, filepath.Join(allowed, "media"), filepath.Join(allowed, "new/not/created/yet")} {
+		if err := CheckLocalRoot(root); err != nil {
+			t.Errorf("Expected %s to be allowed, got %v", root, err)
+		}
+	}
+	for _, root := range []string{
+		"/",
+		outside,
+		base + "/data-other",
+		filepath.Join(allowed, "../etc"),
+		filepath.Join(allowed, "escape"),
+		filepath.Join(allowed, "escape/nested"),
+	} {
+		if err := CheckLocalRoot(root); !errors.Is(err, ErrRootNotAllowed) {
+			t.Errorf("Expected %s to be rejected, got %v", root, err)
+		}
+	}
+}
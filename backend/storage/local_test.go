@@ -68,6 +68,30 @@ func TestLocalStorage_List(t *testing.T) {
 			t.Errorf("Expected at least 4 entries, got %d", len(entries))
 		}
 	})
+
+	t.Run("ListFunc yields same entries", func(t *testing.T) {
+		entries, err := storage.List("/")
+		if err != nil {
+			t.Fatalf("Failed to list files: %v", err)
+		}
+
+		seen := make(map[string]bool)
+		if err := storage.ListFunc("/", func(info FileInfo) error {
+			seen[info.Path] = true
+			return nil
+		}); err != nil {
+			t.Fatalf("Failed to stream listing: %v", err)
+		}
+
+		if len(seen) != len(entries) {
+			t.Errorf("Expected %d streamed entries, got %d", len(entries), len(seen))
+		}
+		for _, entry := range entries {
+			if !seen[entry.Path] {
+				t.Errorf("Streamed listing missing %s", entry.Path)
+			}
+		}
+	})
 }
 
 func TestLocalStorage_Read(t *testing.T) {
@@ -357,3 +381,43 @@ func TestLocalStorage_Stat(t *testing.T) {
 
 // Note: Search, GetUsage, and Watch methods are not part of the current FileSystem interface
 // These tests have been removed as those methods don't exist in LocalStorage
+
+func TestLocalStorage_NativeID(t *testing.T) {
+	tempDir, cleanup := setupTestDir(t)
+	defer cleanup()
+
+	ls := NewLocalStorage(tempDir)
+	if err := os.WriteFile(filepath.Join(tempDir, "a.txt"), []byte("a"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, "b.txt"), []byte("b"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	idA, err := ls.NativeID("/a.txt")
+	if err != nil {
+		t.Fatalf("Failed to get native ID: %v", err)
+	}
+	idB, err := ls.NativeID("/b.txt")
+	if err != nil {
+		t.Fatalf("Failed to get native ID: %v", err)
+	}
+	if idA == "" || idA == idB {
+		t.Errorf("Expected distinct IDs, got %q and %q", idA, idB)
+	}
+
+	if err := ls.Move("/a.txt", "/renamed.txt"); err != nil {
+		t.Fatalf("Failed to rename: %v", err)
+	}
+	renamed, err := ls.NativeID("/renamed.txt")
+	if err != nil {
+		t.Fatalf("Failed to get native ID after rename: %v", err)
+	}
+	if renamed != idA {
+		t.Errorf("Expected ID to survive rename: %q became %q", idA, renamed)
+	}
+
+	if _, err := ls.NativeID("/missing.txt"); err == nil {
+		t.Error("Expected error for missing file")
+	}
+}
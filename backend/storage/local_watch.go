@@ -0,0 +1,207 @@
+package storage
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// localWatchHub shares one fsnotify watcher among every watch on local
+// storages, so watching costs a single inotify instance however many
+// clients do it, and a path watched twice is added once
+type localWatchHub struct {
+	mu      sync.Mutex
+	watcher *fsnotify.Watcher
+	paths   map[string]map[*localWatch]bool // by resolved path
+}
+
+// localWatch is one caller's watch on a path
+type localWatch struct {
+	ls     *LocalStorage
+	queue  chan FileEvent // filled by the hub
+	events chan FileEvent // handed to the caller
+	done   chan struct{}  // closed when the watch is removed
+}
+
+var localWatches = &localWatchHub{}
+
+// Watch reports changes to the directory or file at name as they happen,
+// using inotify or the platform's equivalent
+func (ls *LocalStorage) Watch(name string) (<-chan FileEvent, func(), error) {
+	fullPath := ls.ResolvePath(name)
+	lw, err := localWatches.add(ls, fullPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to watch %s: %w", name, err)
+	}
+
+	go func() {
+		defer close(lw.events)
+		for {
+			select {
+			case <-lw.done:
+				return
+			case event := <-lw.queue:
+				select {
+				case lw.events <- event:
+				case <-lw.done:
+					return
+				}
+			}
+		}
+	}()
+
+	stop := func() {
+		localWatches.remove(fullPath, lw)
+	}
+	return lw.events, stop, nil
+}
+
+// add registers a watch on fullPath, starting the shared watcher or
+// adding the path to it when needed
+func (h *localWatchHub) add(ls *LocalStorage, fullPath string) (*localWatch, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.watcher == nil {
+		watcher, err := fsnotify.NewWatcher()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create watcher: %w", err)
+		}
+		h.watcher = watcher
+		h.paths = make(map[string]map[*localWatch]bool)
+		go h.run(watcher)
+	}
+
+	subs := h.paths[fullPath]
+	if subs == nil {
+		if err := h.watcher.Add(fullPath); err != nil {
+			if len(h.paths) == 0 {
+				h.watcher.Close()
+				h.watcher = nil
+			}
+			return nil, err
+		}
+		subs = make(map[*localWatch]bool)
+		h.paths[fullPath] = subs
+	}
+
+	lw := &localWatch{
+		ls:     ls,
+		queue:  make(chan FileEvent, 64),
+		events: make(chan FileEvent, 64),
+		done:   make(chan struct{}),
+	}
+	subs[lw] = true
+	return lw, nil
+}
+
+// remove ends a watch, dropping the path from the shared watcher once
+// nobody watches it and closing the watcher once nothing is watched
+func (h *localWatchHub) remove(fullPath string, lw *localWatch) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	subs := h.paths[fullPath]
+	if !subs[lw] {
+		return
+	}
+	delete(subs, lw)
+	close(lw.done)
+	if len(subs) > 0 {
+		return
+	}
+	delete(h.paths, fullPath)
+	// The path may be gone already, taking its watch with it
+	_ = h.watcher.Remove(fullPath)
+	if len(h.paths) == 0 {
+		h.watcher.Close()
+		h.watcher = nil
+	}
+}
+
+// run hands the shared watcher's events to the watches they concern,
+// until the watcher is closed
+func (h *localWatchHub) run(watcher *fsnotify.Watcher) {
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				h.closed(watcher)
+				return
+			}
+			h.dispatch(event)
+		case _, ok := <-watcher.Errors:
+			// Overflows and the like; the events still flow
+			if !ok {
+				h.closed(watcher)
+				return
+			}
+		}
+	}
+}
+
+// dispatch sends an event to the watches on the entry it names and on the
+// directory holding it
+func (h *localWatchHub) dispatch(event fsnotify.Event) {
+	h.mu.Lock()
+	var targets []*localWatch
+	for _, p := range []string{event.Name, filepath.Dir(event.Name)} {
+		for lw := range h.paths[p] {
+			targets = append(targets, lw)
+		}
+	}
+	h.mu.Unlock()
+
+	for _, lw := range targets {
+		fileEvent, ok := lw.ls.fileEvent(event)
+		if !ok {
+			continue
+		}
+		select {
+		case lw.queue <- fileEvent:
+		case <-lw.done:
+		}
+	}
+}
+
+// closed ends every watch left when the shared watcher stops on its own
+func (h *localWatchHub) closed(watcher *fsnotify.Watcher) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.watcher != watcher {
+		return
+	}
+	for _, subs := range h.paths {
+		for lw := range subs {
+			close(lw.done)
+		}
+	}
+	h.watcher, h.paths = nil, nil
+}
+
+// fileEvent converts an fsnotify event into a FileEvent with a
+// root-relative path. Changes of only permissions or times are dropped.
+func (ls *LocalStorage) fileEvent(event fsnotify.Event) (FileEvent, bool) {
+	var eventType string
+	switch {
+	case event.Has(fsnotify.Create):
+		eventType = FileEventCreate
+	case event.Has(fsnotify.Write):
+		eventType = FileEventModify
+	case event.Has(fsnotify.Remove):
+		eventType = FileEventDelete
+	case event.Has(fsnotify.Rename):
+		eventType = FileEventRename
+	default:
+		return FileEvent{}, false
+	}
+
+	relPath, err := filepath.Rel(ls.rootPath, event.Name)
+	if err != nil {
+		return FileEvent{}, false
+	}
+	return FileEvent{Type: eventType, Path: path.Join("/", filepath.ToSlash(relPath))}, true
+}
@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLocalStorage_WatchShared(t *testing.T) {
+	root := t.TempDir()
+	ls := NewLocalStorage(root)
+
+	first, stopFirst, err := ls.Watch("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, stopSecond, err := ls.Watch("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	localWatches.mu.Lock()
+	paths := len(localWatches.paths)
+	localWatches.mu.Unlock()
+	if paths != 1 {
+		t.Errorf("Expected one path on the shared watcher, got %d", paths)
+	}
+
+	expect := func(events <-chan FileEvent, name string) {
+		t.Helper()
+		select {
+		case event := <-events:
+			if event.Path != name {
+				t.Errorf("Expected an event for %s, got %+v", name, event)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected an event for %s", name)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(root, "a.txt"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	expect(first, "/a.txt")
+	expect(second, "/a.txt")
+
+	// Stopping one watch leaves the other running
+	stopFirst()
+	if _, ok := <-first; ok {
+		t.Error("Expected the stopped watch's channel to be closed")
+	}
+	if err := os.WriteFile(filepath.Join(root, "b.txt"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	expect(second, "/b.txt")
+
+	stopSecond()
+	stopSecond()
+	localWatches.mu.Lock()
+	closed := localWatches.watcher == nil
+	localWatches.mu.Unlock()
+	if !closed {
+		t.Error("Expected the shared watcher to close once nothing is watched")
+	}
+}
@@ -4,11 +4,15 @@
 package storage
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/jacommander/jacommander/backend/config"
 	"github.com/jacommander/jacommander/backend/security"
@@ -17,18 +21,43 @@ import (
 // StorageConfig represents configuration for a storage backend
 type StorageConfig struct {
 	ID          string                 `json:"id"`
-	Type        string                 `json:"type"` // "local", "s3", "gdrive", "onedrive"
+	Type        string                 `json:"type"` // "local", "s3", "azureblob", "gdrive", "onedrive"
 	DisplayName string                 `json:"display_name"`
 	Icon        string                 `json:"icon"`
 	Config      map[string]interface{} `json:"config"`
 	IsDefault   bool                   `json:"is_default"`
+	ReadOnly    bool                   `json:"read_only"`
 }
 
+// Storage states reported by ListStorages
+const (
+	StorageStatusOK    = "ok"
+	StorageStatusError = "error"
+)
+
+// StorageEntry is a configured storage and whether it could be initialized
+type StorageEntry struct {
+	StorageConfig
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// failedStorage is a configured storage that failed to initialize
+type failedStorage struct {
+	config StorageConfig
+	err    error
+}
+
+// basicBuild reports whether this is the basic build, which only has the
+// local and S3 backends
+const basicBuild = false
+
 // CloudManager manages multiple storage backends including cloud storage
 type CloudManager struct {
 	*Manager
 	mu             sync.RWMutex
 	configs        map[string]*StorageConfig
+	failed         map[string]*failedStorage // kept so they can be retried
 	securityConfig *config.SecurityConfig
 	ipValidator    *security.IPValidator
 }
@@ -39,6 +68,7 @@ func NewCloudManager() *CloudManager {
 	return &CloudManager{
 		Manager:        NewManager(),
 		configs:        make(map[string]*StorageConfig),
+		failed:         make(map[string]*failedStorage),
 		securityConfig: secCfg,
 		ipValidator:    security.NewIPValidator(secCfg.GetAllowLocalIPs()),
 	}
@@ -73,8 +103,10 @@ func (sm *CloudManager) LoadConfig(path string) error {
 	// Initialize storages based on config
 	for _, cfg := range configs {
 		if err := sm.initializeStorage(cfg); err != nil {
-			// Log error but continue loading other storages
+			// Log error but continue loading other storages. The storage is
+			// listed with its error until RetryInit succeeds.
 			fmt.Printf("Warning: Failed to initialize storage %s: %v\n", cfg.ID, err)
+			sm.failed[cfg.ID] = &failedStorage{config: cfg, err: err}
 			continue
 		}
 	}
@@ -116,141 +148,198 @@ func (sm *CloudManager) createDefaultConfig(path string) error {
 	return nil
 }
 
-// initializeStorage creates a storage backend based on configuration
+// initializeStorage creates a storage backend based on configuration and
+// registers it
 func (sm *CloudManager) initializeStorage(cfg StorageConfig) error {
+	fs, err := newBackend(cfg, sm.ipValidator)
+	if err != nil {
+		return err
+	}
+
+	if cfg.ReadOnly {
+		fs = NewReadOnlyFileSystem(cfg.ID, fs)
+	}
+
+	sm.Register(cfg.ID, fs)
+	sm.configs[cfg.ID] = &cfg
+	return nil
+}
+
+// newBackend creates the backend a configuration describes, checking any
+// host it names against validator
+func newBackend(cfg StorageConfig, validator *security.IPValidator) (FileSystem, error) {
+	settings, err := ParseConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
 	var fs FileSystem
-	var err error
+	var common CommonConfig
+
+	switch c := settings.(type) {
+	case *LocalConfig:
+		common = c.CommonConfig
 
-	switch cfg.Type {
-	case "local":
-		rootPath := "/"
-		if rp, ok := cfg.Config["root_path"].(string); ok {
-			rootPath = rp
+		if err := CheckLocalRoot(c.RootPath); err != nil {
+			return nil, err
 		}
-		fs = NewLocalStorage(rootPath)
+		local := NewLocalStorage(c.RootPath)
+		local.SetPreserveXattrs(c.PreserveXattrs)
+		fs = local
 
-	case "s3":
-		bucket, _ := cfg.Config["bucket"].(string)
-		region, _ := cfg.Config["region"].(string)
-		prefix, _ := cfg.Config["prefix"].(string)
-		accessKey, _ := cfg.Config["access_key"].(string)
-		secretKey, _ := cfg.Config["secret_key"].(string)
-		endpoint, _ := cfg.Config["endpoint"].(string)
+	case *S3Config:
+		common = c.CommonConfig
 
 		// Validate custom S3 endpoint if provided
-		if endpoint != "" {
-			if err := sm.ipValidator.ValidateEndpoint(endpoint); err != nil {
-				return fmt.Errorf("S3 endpoint validation failed: %w", err)
+		if c.Endpoint != "" {
+			if err := validator.ValidateEndpoint(c.Endpoint); err != nil {
+				return nil, fmt.Errorf("S3 endpoint validation failed: %w", err)
 			}
 		}
 
-		s3fs, err := NewS3FileSystem(bucket, region, prefix, accessKey, secretKey, endpoint)
+		s3fs, err := NewS3FileSystem(c.Bucket, c.Region, c.Prefix, c.AccessKey, c.SecretKey, c.Endpoint)
 		if err != nil {
-			return fmt.Errorf("failed to create S3 storage: %w", err)
+			return nil, fmt.Errorf("failed to create S3 storage: %w", err)
+		}
+		s3fs.SetBypassGovernance(c.BypassGovernance)
+		s3fs.SetReportUsage(c.ReportUsage, c.Quota)
+		if c.ContentTypes != nil {
+			s3fs.SetContentTypes(c.ContentTypes)
 		}
 		fs = s3fs
 
-	case "gdrive":
-		clientID, _ := cfg.Config["client_id"].(string)
-		clientSecret, _ := cfg.Config["client_secret"].(string)
-		refreshToken, _ := cfg.Config["refresh_token"].(string)
+	case *AzureBlobConfig:
+		common = c.CommonConfig
+
+		// Validate the endpoint actually connected to, custom or not
+		serviceURL, err := AzureServiceURL(c.Account, c.Endpoint)
+		if err != nil {
+			return nil, err
+		}
+		if err := validator.ValidateEndpoint(serviceURL); err != nil {
+			return nil, fmt.Errorf("azure endpoint validation failed: %w", err)
+		}
 
-		gdrive, err := NewGDriveAdapter(clientID, clientSecret, refreshToken)
+		azure, err := NewAzureBlobStorage(c.Account, c.Container, c.AccountKey, c.SASToken, c.Prefix, c.Endpoint)
 		if err != nil {
-			return fmt.Errorf("failed to create Google Drive storage: %w", err)
+			return nil, fmt.Errorf("failed to create Azure Blob storage: %w", err)
+		}
+		fs = azure
+
+	case *GDriveConfig:
+		common = c.CommonConfig
+
+		gdrive, err := NewGDriveAdapter(c.ClientID, c.ClientSecret, c.RefreshToken)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Google Drive storage: %w", err)
+		}
+		if c.PermanentDelete {
+			log.Printf("Storage %s: permanent_delete is set, deleted files will not go to the Drive trash", cfg.ID)
+			gdrive.(*GDriveAdapter).SetPermanentDelete(true)
 		}
 		fs = gdrive
 
-	case "onedrive":
-		clientID, _ := cfg.Config["client_id"].(string)
-		clientSecret, _ := cfg.Config["client_secret"].(string)
-		refreshToken, _ := cfg.Config["refresh_token"].(string)
+	case *OneDriveConfig:
+		common = c.CommonConfig
 
-		onedrive, err := NewOneDriveAdapter(clientID, clientSecret, refreshToken)
+		onedrive, err := NewOneDriveAdapter(c.ClientID, c.ClientSecret, c.RefreshToken)
 		if err != nil {
-			return fmt.Errorf("failed to create OneDrive storage: %w", err)
+			return nil, fmt.Errorf("failed to create OneDrive storage: %w", err)
+		}
+		if c.UploadRetries > 0 {
+			onedrive.(*OneDriveAdapter).SetUploadRetries(c.UploadRetries)
 		}
 		fs = onedrive
 
-	case "ftp", "sftp":
-		host, _ := cfg.Config["host"].(string)
-		port, _ := cfg.Config["port"].(string)
-		username, _ := cfg.Config["username"].(string)
-		password, _ := cfg.Config["password"].(string)
-		rootPath, _ := cfg.Config["root_path"].(string)
+	case *FTPConfig:
+		common = c.CommonConfig
 
 		// Validate FTP/SFTP host
-		if err := sm.ipValidator.ValidateEndpoint(host); err != nil {
-			return fmt.Errorf("FTP/SFTP host validation failed: %w", err)
+		if err := validator.ValidateEndpoint(c.Host); err != nil {
+			return nil, fmt.Errorf("FTP/SFTP host validation failed: %w", err)
 		}
 
-		ftp, err := NewFTPAdapter(cfg.Type, host, port, username, password, rootPath)
+		ftp, err := NewFTPAdapter(cfg.Type, c.Host, c.Port, c.Username, c.Password, c.PrivateKey, c.Passphrase, c.RootPath, c.HostKeyFingerprint)
 		if err != nil {
-			return fmt.Errorf("failed to create FTP/SFTP storage: %w", err)
+			return nil, fmt.Errorf("failed to create FTP/SFTP storage: %w", err)
+		}
+		if c.WriteConcurrency != 0 && cfg.Type == "sftp" {
+			if sftpFS, ok := ftp.(interface{ SetWriteConcurrency(int) error }); ok {
+				if err := sftpFS.SetWriteConcurrency(c.WriteConcurrency); err != nil {
+					log.Printf("Storage %s: failed to apply write_concurrency: %v", cfg.ID, err)
+				}
+			}
 		}
 		fs = ftp
 
-	case "webdav":
-		baseURL, _ := cfg.Config["base_url"].(string)
-		username, _ := cfg.Config["username"].(string)
-		password, _ := cfg.Config["password"].(string)
-		rootPath, _ := cfg.Config["root_path"].(string)
+	case *WebDAVConfig:
+		common = c.CommonConfig
 
 		// Validate WebDAV endpoint
-		if err := sm.ipValidator.ValidateEndpoint(baseURL); err != nil {
-			return fmt.Errorf("WebDAV endpoint validation failed: %w", err)
+		if err := validator.ValidateEndpoint(c.BaseURL); err != nil {
+			return nil, fmt.Errorf("WebDAV endpoint validation failed: %w", err)
 		}
 
-		webdav, err := NewWebDAVAdapter(baseURL, username, password, rootPath)
+		webdav, err := NewWebDAVAdapter(c.BaseURL, c.Username, c.Password, c.RootPath)
 		if err != nil {
-			return fmt.Errorf("failed to create WebDAV storage: %w", err)
+			return nil, fmt.Errorf("failed to create WebDAV storage: %w", err)
 		}
 		fs = webdav
 
-	case "nfs":
-		server, _ := cfg.Config["server"].(string)
-		exportPath, _ := cfg.Config["export_path"].(string)
-		mountPoint, _ := cfg.Config["mount_point"].(string)
-		readOnly, _ := cfg.Config["read_only"].(bool)
+	case *NFSConfig:
+		common = c.CommonConfig
 
 		// Validate NFS server
-		if err := sm.ipValidator.ValidateEndpoint(server); err != nil {
-			return fmt.Errorf("NFS server validation failed: %w", err)
+		if err := validator.ValidateEndpoint(c.Server); err != nil {
+			return nil, fmt.Errorf("NFS server validation failed: %w", err)
 		}
 
-		nfs, err := NewNFSStorage(server, exportPath, mountPoint, readOnly)
+		nfs, err := NewNFSStorage(c.Server, c.ExportPath, c.MountPoint, c.ReadOnly)
 		if err != nil {
-			return fmt.Errorf("failed to create NFS storage: %w", err)
+			return nil, fmt.Errorf("failed to create NFS storage: %w", err)
+		}
+		if c.IdleTimeout > 0 {
+			nfs.SetIdleTimeout(time.Duration(c.IdleTimeout) * time.Second)
 		}
 		fs = nfs
 
-	case "redis", "rdb":
-		address, _ := cfg.Config["address"].(string)
-		password, _ := cfg.Config["password"].(string)
-		db := 0
-		if dbNum, ok := cfg.Config["db"].(float64); ok {
-			db = int(dbNum)
-		}
-		namespace, _ := cfg.Config["namespace"].(string)
+	case *RDBConfig:
+		common = c.CommonConfig
 
 		// Validate Redis server
-		if err := sm.ipValidator.ValidateEndpoint(address); err != nil {
-			return fmt.Errorf("redis server validation failed: %w", err)
+		if err := validator.ValidateEndpoint(c.Address); err != nil {
+			return nil, fmt.Errorf("redis server validation failed: %w", err)
 		}
 
-		rdb, err := NewRDBStorage(address, password, db, namespace)
+		rdb, err := NewRDBStorage(c.Address, c.Password, c.DB, c.Namespace)
 		if err != nil {
-			return fmt.Errorf("failed to create Redis storage: %w", err)
+			return nil, fmt.Errorf("failed to create Redis storage: %w", err)
 		}
 		fs = rdb
+	}
 
-	default:
-		return fmt.Errorf("unknown storage type: %s", cfg.Type)
+	if common.CaseInsensitive {
+		if cf, ok := fs.(interface{ SetCaseInsensitive(bool) }); ok {
+			cf.SetCaseInsensitive(true)
+		} else {
+			log.Printf("Storage %s (%s) does not support case_insensitive, ignoring", cfg.ID, cfg.Type)
+		}
 	}
 
-	sm.storages[cfg.ID] = fs
-	sm.configs[cfg.ID] = &cfg
-	return err
+	return fs, nil
+}
+
+// TestStorage checks that the storage cfg describes can be reached,
+// without adding it. The backend is created as AddStorage would and its
+// root listed, then it is closed again.
+func (sm *CloudManager) TestStorage(ctx context.Context, cfg StorageConfig) error {
+	sm.mu.RLock()
+	validator := sm.ipValidator
+	sm.mu.RUnlock()
+
+	return testBackend(ctx, func() (FileSystem, error) {
+		return newBackend(cfg, validator)
+	})
 }
 
 // AddStorage adds a new storage backend
@@ -261,6 +350,9 @@ func (sm *CloudManager) AddStorage(config StorageConfig) error {
 	if _, exists := sm.storages[config.ID]; exists {
 		return fmt.Errorf("storage with ID %s already exists", config.ID)
 	}
+	if _, exists := sm.failed[config.ID]; exists {
+		return fmt.Errorf("storage with ID %s already exists", config.ID)
+	}
 
 	if err := sm.initializeStorage(config); err != nil {
 		return err
@@ -279,8 +371,19 @@ func (sm *CloudManager) RemoveStorage(id string) error {
 		return fmt.Errorf("cannot remove local storage")
 	}
 
+	fs, ok := sm.storages[id]
 	delete(sm.storages, id)
 	delete(sm.configs, id)
+	delete(sm.failed, id)
+
+	// Release what the backend holds, like an NFS mount or a connection
+	if ok {
+		if closer, ok := As[io.Closer](fs); ok {
+			if err := closer.Close(); err != nil {
+				log.Printf("Error closing storage %s: %v", id, err)
+			}
+		}
+	}
 
 	return sm.saveConfig()
 }
@@ -292,11 +395,36 @@ func (sm *CloudManager) GetStorage(id string) (FileSystem, error) {
 
 	fs, ok := sm.storages[id]
 	if !ok {
+		if failed, ok := sm.failed[id]; ok {
+			return nil, fmt.Errorf("storage %s failed to initialize: %w", id, failed.err)
+		}
 		return nil, fmt.Errorf("storage %s not found", id)
 	}
 	return fs, nil
 }
 
+// RetryInit attempts again to initialize a storage that failed at startup
+func (sm *CloudManager) RetryInit(id string) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	failed, ok := sm.failed[id]
+	if !ok {
+		if _, exists := sm.storages[id]; exists {
+			return fmt.Errorf("storage %s is already initialized: %w", id, os.ErrExist)
+		}
+		return fmt.Errorf("storage %s not found: %w", id, os.ErrNotExist)
+	}
+
+	if err := sm.initializeStorage(failed.config); err != nil {
+		failed.err = err
+		return err
+	}
+	delete(sm.failed, id)
+	log.Printf("Storage %s initialized on retry", id)
+	return nil
+}
+
 // GetDefaultStorage returns the default storage backend
 func (sm *CloudManager) GetDefaultStorage() (FileSystem, error) {
 	sm.mu.RLock()
@@ -318,16 +446,24 @@ func (sm *CloudManager) GetDefaultStorage() (FileSystem, error) {
 	return nil, fmt.Errorf("no default storage found")
 }
 
-// ListStorages returns all available storage configurations
-func (sm *CloudManager) ListStorages() []StorageConfig {
+// ListStorages returns all configured storages, including those that
+// failed to initialize along with their error
+func (sm *CloudManager) ListStorages() []StorageEntry {
 	sm.mu.RLock()
 	defer sm.mu.RUnlock()
 
-	var configs []StorageConfig
+	var entries []StorageEntry
 	for _, cfg := range sm.configs {
-		configs = append(configs, *cfg)
+		entries = append(entries, StorageEntry{StorageConfig: *cfg, Status: StorageStatusOK})
+	}
+	for _, failed := range sm.failed {
+		entries = append(entries, StorageEntry{
+			StorageConfig: failed.config,
+			Status:        StorageStatusError,
+			Error:         failed.err.Error(),
+		})
 	}
-	return configs
+	return entries
 }
 
 // SetDefault sets a storage as the default
@@ -358,6 +494,10 @@ func (sm *CloudManager) saveConfig() error {
 	for _, cfg := range sm.configs {
 		configs = append(configs, *cfg)
 	}
+	// Storages that failed to initialize stay configured
+	for _, failed := range sm.failed {
+		configs = append(configs, failed.config)
+	}
 
 	data, err := json.MarshalIndent(configs, "", "  ")
 	if err != nil {
@@ -381,6 +521,25 @@ func (sm *CloudManager) TransferBetweenStorages(srcStorageID, srcPath, dstStorag
 		return fmt.Errorf("destination storage %s not found", dstStorageID)
 	}
 
+	info, err := srcStorage.Stat(srcPath)
+	if err != nil {
+		return fmt.Errorf("failed to stat source: %w", err)
+	}
+	if progress == nil {
+		progress = func(current, total int64) {}
+	}
+
+	// Same-provider storages may copy between themselves directly
+	if copier, ok := As[ServerSideCopier](dstStorage); ok {
+		err := copier.CopyFrom(srcStorage, srcPath, dstPath)
+		if err == nil {
+			progress(info.Size, info.Size)
+		}
+		if !errors.Is(err, ErrNotSupported) {
+			return err
+		}
+	}
+
 	// Read from source
 	reader, err := srcStorage.Read(srcPath)
 	if err != nil {
@@ -392,14 +551,34 @@ func (sm *CloudManager) TransferBetweenStorages(srcStorageID, srcPath, dstStorag
 		}
 	}()
 
-	// Write to destination
-	if err := dstStorage.Write(dstPath, reader); err != nil {
+	// Write to destination, reporting the bytes as the destination pulls
+	// them from the source
+	progress(0, info.Size)
+	counted := &transferReader{Reader: reader, total: info.Size, progress: progress}
+	if err := dstStorage.Write(dstPath, counted); err != nil {
 		return fmt.Errorf("failed to write to destination: %w", err)
 	}
 
 	return nil
 }
 
+// transferReader reports the bytes read through it to a progress callback
+type transferReader struct {
+	io.Reader
+	read     int64
+	total    int64
+	progress ProgressCallback
+}
+
+func (r *transferReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 {
+		r.read += int64(n)
+		r.progress(r.read, r.total)
+	}
+	return n, err
+}
+
 // GetSecurityConfig returns the current security configuration
 func (sm *CloudManager) GetSecurityConfig() map[string]interface{} {
 	sm.mu.RLock()
@@ -4,6 +4,7 @@
 package storage
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
@@ -74,6 +75,8 @@ func (cm *CloudManager) ListStorages() []map[string]interface{} {
 			"display_name": cfg.DisplayName,
 			"icon":         cfg.Icon,
 			"is_default":   cfg.IsDefault,
+			"read_only":    cfg.ReadOnly,
+			"status":       "ok",
 		})
 	}
 	return storages
@@ -95,12 +98,21 @@ func (cm *CloudManager) AddStorage(config StorageConfig) error {
 		return fmt.Errorf("only local storage supported in basic build")
 	}
 
-	rootPath := "/"
-	if rp, ok := config.Config["root_path"].(string); ok {
-		rootPath = rp
+	settings, err := ParseConfig(config)
+	if err != nil {
+		return err
 	}
 
-	fs := NewLocalStorage(rootPath)
+	local := settings.(*LocalConfig)
+	if err := CheckLocalRoot(local.RootPath); err != nil {
+		return err
+	}
+	localFS := NewLocalStorage(local.RootPath)
+	localFS.SetPreserveXattrs(local.PreserveXattrs)
+	var fs FileSystem = localFS
+	if config.ReadOnly {
+		fs = NewReadOnlyFileSystem(config.ID, fs)
+	}
 	cm.Register(config.ID, fs)
 
 	// Save config for ListStorages
@@ -108,6 +120,27 @@ func (cm *CloudManager) AddStorage(config StorageConfig) error {
 	return nil
 }
 
+// TestStorage checks that a local or S3 storage can be reached, without
+// adding it
+func (cm *CloudManager) TestStorage(ctx context.Context, config StorageConfig) error {
+	return testBackend(ctx, func() (FileSystem, error) {
+		settings, err := ParseConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		switch c := settings.(type) {
+		case *LocalConfig:
+			if err := CheckLocalRoot(c.RootPath); err != nil {
+				return nil, err
+			}
+			return NewLocalStorage(c.RootPath), nil
+		case *S3Config:
+			return NewS3FileSystem(c.Bucket, c.Region, c.Prefix, c.AccessKey, c.SecretKey, c.Endpoint)
+		}
+		return nil, fmt.Errorf("%s storage not supported in basic build", config.Type)
+	})
+}
+
 // RemoveStorage stub
 func (cm *CloudManager) RemoveStorage(id string) error {
 	if id == "local" {
@@ -116,6 +149,14 @@ func (cm *CloudManager) RemoveStorage(id string) error {
 	return nil
 }
 
+// RetryInit stub; the basic build's storages never fail to initialize
+func (cm *CloudManager) RetryInit(id string) error {
+	if _, ok := cm.storages[id]; ok {
+		return fmt.Errorf("storage %s is already initialized: %w", id, os.ErrExist)
+	}
+	return fmt.Errorf("storage %s not found: %w", id, os.ErrNotExist)
+}
+
 // SetDefault stub
 func (cm *CloudManager) SetDefault(id string) error {
 	if id != "local" {
@@ -137,54 +178,42 @@ type StorageConfig struct {
 	Icon        string                 `json:"icon"`
 	Config      map[string]interface{} `json:"config"`
 	IsDefault   bool                   `json:"is_default"`
+	ReadOnly    bool                   `json:"read_only"`
 }
 
-// Stub functions for basic build without external dependencies
+// basicBuild reports whether this is the basic build, which only has the
+// local and S3 backends
+const basicBuild = true
 
-func NewGDriveAdapter(credentialsJSON, clientID, clientSecret string) (FileSystem, error) {
-	return nil, fmt.Errorf("google Drive storage not available in basic build")
+// errNotInBasicBuild is returned by the constructors of the backends left
+// out of the basic build
+func errNotInBasicBuild(name string) error {
+	return fmt.Errorf("%s storage not available in basic build", name)
 }
 
-func NewOneDriveAdapter(accessToken, driveID string) (FileSystem, error) {
-	return nil, fmt.Errorf("OneDrive storage not available in basic build")
-}
-
-func NewFTPStorage(protocol, host, port, username, password, rootPath string) (FileSystem, error) {
-	return nil, fmt.Errorf("FTP/SFTP storage not available in basic build")
-}
+// The adapter constructors are the backend API common to both builds, so
+// their signatures must match the full build's exactly. The backends' own
+// constructors, such as NewNFSStorage, return their concrete types and
+// exist only in the full build.
 
-func NewWebDAVStorage(baseURL, username, password string) (FileSystem, error) {
-	return nil, fmt.Errorf("WebDAV storage not available in basic build")
+func NewGDriveAdapter(clientID, clientSecret, refreshToken string) (FileSystem, error) {
+	return nil, errNotInBasicBuild("Google Drive")
 }
 
-func NewNFSStorage(server, exportPath, mountPoint string, readOnly bool) (FileSystem, error) {
-	return nil, fmt.Errorf("NFS storage not available in basic build")
+func NewOneDriveAdapter(clientID, clientSecret, refreshToken string) (FileSystem, error) {
+	return nil, errNotInBasicBuild("OneDrive")
 }
 
-func NewRDBStorage(address, password string, db int, namespace string) (FileSystem, error) {
-	return nil, fmt.Errorf("redis storage not available in basic build")
-}
-
-// Stub implementations for additional functions
-func NewFTPAdapter(protocol, host, port, username, password, rootPath string) (FileSystem, error) {
-	return NewFTPStorage(protocol, host, port, username, password, rootPath)
+func NewFTPAdapter(protocol, host, port, username, password, privateKey, passphrase, rootPath, hostKeyFingerprint string) (FileSystem, error) {
+	return nil, errNotInBasicBuild("FTP/SFTP")
 }
 
 func NewWebDAVAdapter(baseURL, username, password, rootPath string) (FileSystem, error) {
-	return NewWebDAVStorage(baseURL, username, password)
+	return nil, errNotInBasicBuild("WebDAV")
 }
 
 // Stub type definitions to satisfy compilation
 type File = io.ReadCloser
-type FileEvent struct {
-	Type string
-	Path string
-}
-type SearchResult struct {
-	Path    string
-	Line    int
-	Content string
-}
 type UsageInfo struct {
 	Used  int64
 	Total int64
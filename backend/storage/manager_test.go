@@ -0,0 +1,158 @@
+//go:build !basic
+// +build !basic
+
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCloudManager_FailedInit(t *testing.T) {
+	dir := t.TempDir()
+	allowed := filepath.Join(dir, "allowed")
+	if err := SetLocalRootAllowlist([]string{allowed}); err != nil {
+		t.Fatal(err)
+	}
+	defer SetLocalRootAllowlist(nil)
+
+	configs := []StorageConfig{
+		{ID: "ok", Type: "local", Config: map[string]interface{}{"root_path": filepath.Join(allowed, "ok")}},
+		{ID: "later", Type: "local", Config: map[string]interface{}{"root_path": filepath.Join(dir, "later")}},
+		{ID: "broken", Type: "s3", Config: map[string]interface{}{"region": "eu-west-1"}},
+	}
+	data, _ := json.Marshal(configs)
+	path := filepath.Join(dir, "storage.json")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cm := NewCloudManager()
+	if err := cm.LoadConfig(path); err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+
+	status := func(id string) StorageEntry {
+		for _, entry := range cm.ListStorages() {
+			if entry.ID == id {
+				return entry
+			}
+		}
+		t.Fatalf("Storage %s not listed", id)
+		return StorageEntry{}
+	}
+
+	if entry := status("ok"); entry.Status != StorageStatusOK || entry.Error != "" {
+		t.Errorf("Expected ok to be initialized, got %+v", entry)
+	}
+	if entry := status("broken"); entry.Status != StorageStatusError || !strings.Contains(entry.Error, "bucket is required") {
+		t.Errorf("Expected broken to report its config error, got %+v", entry)
+	}
+	if entry := status("later"); entry.Status != StorageStatusError || !strings.Contains(entry.Error, "outside") {
+		t.Errorf("Expected later to report its root error, got %+v", entry)
+	}
+	if _, err := cm.GetStorage("later"); err == nil || !strings.Contains(err.Error(), "failed to initialize") {
+		t.Errorf("Expected GetStorage to explain the failure, got %v", err)
+	}
+
+	if err := cm.RetryInit("later"); !errors.Is(err, ErrRootNotAllowed) {
+		t.Errorf("Expected retry to fail while the root is disallowed, got %v", err)
+	}
+	if err := SetLocalRootAllowlist([]string{dir}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cm.RetryInit("later"); err != nil {
+		t.Fatalf("Expected retry to succeed, got %v", err)
+	}
+	if entry := status("later"); entry.Status != StorageStatusOK {
+		t.Errorf("Expected later to be initialized after retry, got %+v", entry)
+	}
+	if _, ok := cm.Get("later"); !ok {
+		t.Error("Expected later to be usable after retry")
+	}
+
+	if err := cm.RetryInit("broken"); err == nil || status("broken").Status != StorageStatusError {
+		t.Errorf("Expected broken to keep failing, got %v", err)
+	}
+	if err := cm.RetryInit("ok"); !errors.Is(err, os.ErrExist) {
+		t.Errorf("Expected ErrExist retrying an initialized storage, got %v", err)
+	}
+	if err := cm.RetryInit("missing"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected ErrNotExist for an unknown storage, got %v", err)
+	}
+}
+
+func TestCloudManager_ReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	configs := []StorageConfig{
+		{ID: "archive", Type: "local", Config: map[string]interface{}{"root_path": dir}, ReadOnly: true},
+	}
+	data, _ := json.Marshal(configs)
+	path := filepath.Join(dir, "storage.json")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cm := NewCloudManager()
+	if err := cm.LoadConfig(path); err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+
+	fs, err := cm.GetStorage("archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.MkDir("/dir"); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Expected MkDir to fail with ErrReadOnly, got %v", err)
+	}
+	entries := cm.ListStorages()
+	if len(entries) != 1 || !entries[0].ReadOnly {
+		t.Errorf("Expected the storage to be listed read-only, got %+v", entries)
+	}
+}
+
+func TestCloudManager_TransferProgress(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	content := strings.Repeat("0123456789", 100_000)
+	if err := os.WriteFile(filepath.Join(src, "big.txt"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cm := NewCloudManager()
+	cm.Register("src", NewLocalStorage(src))
+	cm.Register("dst", NewLocalStorage(dst))
+
+	var updates [][2]int64
+	err := cm.TransferBetweenStorages("src", "/big.txt", "dst", "/copy.txt", func(current, total int64) {
+		updates = append(updates, [2]int64{current, total})
+	})
+	if err != nil {
+		t.Fatalf("Transfer failed: %v", err)
+	}
+
+	if len(updates) < 3 {
+		t.Fatalf("Expected progress as the file is copied, got %v", updates)
+	}
+	for i, u := range updates {
+		if u[1] != int64(len(content)) {
+			t.Fatalf("Update %d: expected total %d, got %d", i, len(content), u[1])
+		}
+		if i > 0 && u[0] < updates[i-1][0] {
+			t.Fatalf("Progress went backwards: %v", updates)
+		}
+	}
+	if first, last := updates[0][0], updates[len(updates)-1][0]; first != 0 || last != int64(len(content)) {
+		t.Errorf("Expected progress from 0 to %d, got %d to %d", len(content), first, last)
+	}
+	if copied, _ := os.ReadFile(filepath.Join(dst, "copy.txt")); string(copied) != content {
+		t.Error("Copied content does not match")
+	}
+
+	if err := cm.TransferBetweenStorages("src", "/missing.txt", "dst", "/x.txt", nil); err == nil {
+		t.Error("Expected an error transferring a missing file")
+	}
+}
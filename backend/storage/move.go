@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// ErrNativeMoveFailed is wrapped by Move errors where the backend's own
+// move or rename couldn't be used, for example because the object is too
+// large for a server-side copy. The source is left as it was.
+var ErrNativeMoveFailed = errors.New("native move not possible")
+
+// Move moves src to dst with the backend's native move. If that fails with
+// ErrNativeMoveFailed and the backend opts in through MoveFallbacker, the
+// entry is copied with the backend's own Read and Write, the copy is
+// checked against the source, and only then is the source deleted.
+func Move(fs FileSystem, src, dst string) error {
+	err := fs.Move(src, dst)
+	if err == nil || !errors.Is(err, ErrNativeMoveFailed) {
+		return err
+	}
+	if fb, ok := As[MoveFallbacker](fs); !ok || !fb.MoveFallback() {
+		return err
+	}
+
+	if err := copyForMove(fs, src, dst); err != nil {
+		return fmt.Errorf("failed to move %s by copying: %w", src, err)
+	}
+	if err := fs.Delete(src); err != nil {
+		return fmt.Errorf("copied %s but failed to delete it: %w", src, err)
+	}
+	return nil
+}
+
+// copyForMove copies a file or directory tree within fs and verifies that
+// every file arrived with its full size
+func copyForMove(fs FileSystem, src, dst string) error {
+	info, err := fs.Stat(src)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir {
+		return copyVerified(fs, src, dst, info.Size)
+	}
+
+	if err := fs.MkDir(dst); err != nil {
+		return err
+	}
+	return Walk(fs, src, func(entry FileInfo) error {
+		rel := strings.TrimPrefix(entry.Path, path.Clean(src)+"/")
+		target := path.Join(dst, rel)
+		if entry.IsDir {
+			return fs.MkDir(target)
+		}
+		return copyVerified(fs, entry.Path, target, entry.Size)
+	})
+}
+
+// copyVerified copies one file and checks the copy has the source's size
+func copyVerified(fs FileSystem, src, dst string, size int64) error {
+	reader, err := fs.Read(src)
+	if err != nil {
+		return err
+	}
+	err = fs.Write(dst, reader)
+	if closeErr := reader.Close(); err == nil && closeErr != nil && closeErr != io.EOF {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	copied, err := fs.Stat(dst)
+	if err != nil {
+		return fmt.Errorf("failed to verify copy of %s: %w", src, err)
+	}
+	if copied.Size != size {
+		return fmt.Errorf("copy of %s has %d bytes, expected %d", src, copied.Size, size)
+	}
+	return nil
+}
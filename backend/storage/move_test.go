@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+)
+
+// noRenameFS is local storage whose native move always fails as if the
+// destination were out of the backend's reach
+type noRenameFS struct {
+	*LocalStorage
+	fallback bool
+}
+
+func (n *noRenameFS) Move(src, dst string) error {
+	return fmt.Errorf("%w: %s is on another volume", ErrNativeMoveFailed, dst)
+}
+
+func (n *noRenameFS) MoveFallback() bool {
+	return n.fallback
+}
+
+func TestMove_Fallback(t *testing.T) {
+	newTree := func(t *testing.T) (string, *LocalStorage) {
+		root := t.TempDir()
+		for file, content := range map[string]string{
+			"src/a.txt":       "alpha",
+			"src/sub/b.txt":   "bravo",
+			"src/sub/c/d.txt": "delta",
+			"single.txt":      "single",
+		} {
+			full := filepath.Join(root, file)
+			if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+				t.Fatalf("Failed to create directory: %v", err)
+			}
+			if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+				t.Fatalf("Failed to create %s: %v", file, err)
+			}
+		}
+		return root, NewLocalStorage(root)
+	}
+
+	t.Run("Directory", func(t *testing.T) {
+		root, local := newTree(t)
+		if err := Move(&noRenameFS{LocalStorage: local, fallback: true}, "/src", "/dst"); err != nil {
+			t.Fatalf("Failed to move: %v", err)
+		}
+		for file, want := range map[string]string{"dst/a.txt": "alpha", "dst/sub/b.txt": "bravo", "dst/sub/c/d.txt": "delta"} {
+			got, err := os.ReadFile(filepath.Join(root, file))
+			if err != nil || string(got) != want {
+				t.Errorf("Expected %s to hold %q, got %q (%v)", file, want, got, err)
+			}
+		}
+		if _, err := os.Stat(filepath.Join(root, "src")); !os.IsNotExist(err) {
+			t.Errorf("Expected the source to be deleted, got %v", err)
+		}
+	})
+
+	t.Run("File", func(t *testing.T) {
+		root, local := newTree(t)
+		if err := Move(&noRenameFS{LocalStorage: local, fallback: true}, "/single.txt", "/src/moved.txt"); err != nil {
+			t.Fatalf("Failed to move: %v", err)
+		}
+		if got, _ := os.ReadFile(filepath.Join(root, "src/moved.txt")); string(got) != "single" {
+			t.Errorf("Expected the moved content, got %q", got)
+		}
+	})
+
+	t.Run("Not opted in", func(t *testing.T) {
+		root, local := newTree(t)
+		err := Move(&noRenameFS{LocalStorage: local}, "/src", "/dst")
+		if !errors.Is(err, ErrNativeMoveFailed) {
+			t.Fatalf("Expected the native move error, got %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(root, "src/a.txt")); err != nil {
+			t.Errorf("Expected the source to be kept: %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(root, "dst")); !os.IsNotExist(err) {
+			t.Error("Expected nothing to be copied")
+		}
+	})
+}
+
+func TestS3Storage_MoveOverCopyLimit(t *testing.T) {
+	client := &mockS3Client{
+		objects: map[string][]byte{"videos/raw.mov": []byte(strings.Repeat("x", 1024))},
+		copyObject: func(*s3.CopyObjectInput) (*s3.CopyObjectOutput, error) {
+			return nil, &smithy.GenericAPIError{
+				Code:    "InvalidRequest",
+				Message: "The specified copy source is larger than the maximum allowable size for a copy source: 5368709120",
+			}
+		},
+	}
+	fs := &S3FileSystem{S3Storage: newMockS3Storage(client)}
+
+	if err := fs.Move("/videos/raw.mov", "/archive/raw.mov"); !errors.Is(err, ErrNativeMoveFailed) {
+		t.Fatalf("Expected CopyObject's size limit to be reported as ErrNativeMoveFailed, got %v", err)
+	}
+
+	if err := Move(fs, "/videos/raw.mov", "/archive/raw.mov"); err != nil {
+		t.Fatalf("Failed to move: %v", err)
+	}
+	reader, err := fs.Read("/archive/raw.mov")
+	if err != nil {
+		t.Fatalf("Failed to read the moved object: %v", err)
+	}
+	defer reader.Close()
+	if data, _ := io.ReadAll(reader); len(data) != 1024 {
+		t.Errorf("Expected 1024 bytes at the destination, got %d", len(data))
+	}
+	if len(client.deleted) != 1 || client.deleted[0] != "videos/raw.mov" {
+		t.Errorf("Expected the source object to be deleted, got %v", client.deleted)
+	}
+}
@@ -11,15 +11,31 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 )
 
+// nfsCommand runs mount, umount and the like, returning their combined
+// output. Tests replace it so nothing is really mounted.
+var nfsCommand = func(name string, args ...string) ([]byte, error) {
+	return exec.Command(name, args...).CombinedOutput()
+}
+
 // NFSStorage implements FileSystem interface for NFS mounts
 type NFSStorage struct {
 	mountPoint string
 	server     string
 	exportPath string
-	mounted    bool
 	readOnly   bool
+
+	mu          sync.Mutex
+	mounted     bool
+	ownMount    bool // mounted by us rather than found mounted
+	closed      bool
+	active      int // operations holding the mount
+	lastUsed    time.Time
+	idleTimeout time.Duration
+	stopIdle    chan struct{}
 }
 
 // NewNFSStorage creates a new NFS storage backend
@@ -58,17 +74,17 @@ func (nfs *NFSStorage) mount() error {
 	}
 
 	// Mount NFS share
-	cmd := exec.Command("mount", "-t", "nfs",
+	output, err := nfsCommand("mount", "-t", "nfs",
 		"-o", mountOptions,
 		fmt.Sprintf("%s:%s", nfs.server, nfs.exportPath),
 		nfs.mountPoint)
-
-	output, err := cmd.CombinedOutput()
 	if err != nil {
 		return fmt.Errorf("mount failed: %s - %v", string(output), err)
 	}
 
 	nfs.mounted = true
+	nfs.ownMount = true
+	nfs.lastUsed = time.Now()
 	return nil
 }
 
@@ -78,8 +94,7 @@ func (nfs *NFSStorage) unmount() error {
 		return nil
 	}
 
-	cmd := exec.Command("umount", nfs.mountPoint)
-	output, err := cmd.CombinedOutput()
+	output, err := nfsCommand("umount", nfs.mountPoint)
 	if err != nil {
 		return fmt.Errorf("unmount failed: %s - %v", string(output), err)
 	}
@@ -90,8 +105,7 @@ func (nfs *NFSStorage) unmount() error {
 
 // checkMount verifies if the NFS share is currently mounted
 func (nfs *NFSStorage) checkMount() error {
-	cmd := exec.Command("mount")
-	output, err := cmd.Output()
+	output, err := nfsCommand("mount")
 	if err != nil {
 		return err
 	}
@@ -104,11 +118,95 @@ func (nfs *NFSStorage) checkMount() error {
 	return fmt.Errorf("not mounted")
 }
 
+// acquire makes sure the share is mounted, mounting it again after an idle
+// unmount, and keeps it mounted until release is called
+func (nfs *NFSStorage) acquire() (release func(), err error) {
+	nfs.mu.Lock()
+	defer nfs.mu.Unlock()
+
+	if nfs.closed {
+		return nil, fmt.Errorf("NFS share not mounted")
+	}
+	if !nfs.mounted {
+		if err := nfs.mount(); err != nil {
+			return nil, fmt.Errorf("failed to remount NFS share: %w", err)
+		}
+	}
+	nfs.active++
+	return nfs.release, nil
+}
+
+func (nfs *NFSStorage) release() {
+	nfs.mu.Lock()
+	defer nfs.mu.Unlock()
+	nfs.active--
+	nfs.lastUsed = time.Now()
+}
+
+// SetIdleTimeout makes the share unmount once nothing has used it for
+// timeout, to be mounted again by the next operation. Shares that were
+// already mounted when the storage was created are left alone. Zero turns
+// this off.
+func (nfs *NFSStorage) SetIdleTimeout(timeout time.Duration) {
+	nfs.mu.Lock()
+	defer nfs.mu.Unlock()
+
+	if nfs.stopIdle != nil {
+		close(nfs.stopIdle)
+		nfs.stopIdle = nil
+	}
+	nfs.idleTimeout = timeout
+	if timeout <= 0 {
+		return
+	}
+
+	interval := timeout / 4
+	if interval < time.Second {
+		interval = time.Second
+	}
+	stop := make(chan struct{})
+	nfs.stopIdle = stop
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-ticker.C:
+				nfs.unmountIfIdle(now)
+			}
+		}
+	}()
+}
+
+// unmountIfIdle unmounts the share if it has been idle for the idle
+// timeout as of now, and reports whether it did
+func (nfs *NFSStorage) unmountIfIdle(now time.Time) bool {
+	nfs.mu.Lock()
+	defer nfs.mu.Unlock()
+
+	if !nfs.mounted || !nfs.ownMount || nfs.idleTimeout <= 0 || nfs.active > 0 || now.Sub(nfs.lastUsed) < nfs.idleTimeout {
+		return false
+	}
+	if err := nfs.unmount(); err != nil {
+		// Usually a file is still open for reading; try again after
+		// another idle period
+		log.Printf("Error unmounting idle NFS share %s: %v", nfs.mountPoint, err)
+		nfs.lastUsed = now
+		return false
+	}
+	log.Printf("Unmounted NFS share %s:%s after %v idle", nfs.server, nfs.exportPath, nfs.idleTimeout)
+	return true
+}
+
 // List returns a list of files/directories at the given path
 func (nfs *NFSStorage) List(path string) ([]FileInfo, error) {
-	if !nfs.mounted {
-		return nil, fmt.Errorf("NFS share not mounted")
+	release, err := nfs.acquire()
+	if err != nil {
+		return nil, err
 	}
+	defer release()
 
 	fullPath := filepath.Join(nfs.mountPoint, path)
 
@@ -137,24 +235,46 @@ func (nfs *NFSStorage) List(path string) ([]FileInfo, error) {
 	return files, nil
 }
 
-// Read opens a file for reading
+// Read opens a file for reading. The share stays mounted until the file
+// is closed.
 func (nfs *NFSStorage) Read(path string) (io.ReadCloser, error) {
-	if !nfs.mounted {
-		return nil, fmt.Errorf("NFS share not mounted")
+	release, err := nfs.acquire()
+	if err != nil {
+		return nil, err
 	}
 
 	fullPath := filepath.Join(nfs.mountPoint, path)
-	return os.Open(fullPath)
+	file, err := os.Open(fullPath)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return &nfsFile{File: file, release: release}, nil
+}
+
+// nfsFile releases its hold on the mount when closed
+type nfsFile struct {
+	*os.File
+	release func()
+	once    sync.Once
+}
+
+func (f *nfsFile) Close() error {
+	err := f.File.Close()
+	f.once.Do(f.release)
+	return err
 }
 
 // Write writes data to a file
 func (nfs *NFSStorage) Write(path string, data io.Reader) error {
-	if !nfs.mounted {
-		return fmt.Errorf("NFS share not mounted")
+	release, err := nfs.acquire()
+	if err != nil {
+		return err
 	}
+	defer release()
 
 	if nfs.readOnly {
-		return fmt.Errorf("NFS share is mounted read-only")
+		return fmt.Errorf("NFS share is mounted read-only: %w", ErrReadOnly)
 	}
 
 	fullPath := filepath.Join(nfs.mountPoint, path)
@@ -183,12 +303,14 @@ func (nfs *NFSStorage) Write(path string, data io.Reader) error {
 
 // Delete removes a file or directory
 func (nfs *NFSStorage) Delete(path string) error {
-	if !nfs.mounted {
-		return fmt.Errorf("NFS share not mounted")
+	release, err := nfs.acquire()
+	if err != nil {
+		return err
 	}
+	defer release()
 
 	if nfs.readOnly {
-		return fmt.Errorf("NFS share is mounted read-only")
+		return fmt.Errorf("NFS share is mounted read-only: %w", ErrReadOnly)
 	}
 
 	fullPath := filepath.Join(nfs.mountPoint, path)
@@ -197,23 +319,86 @@ func (nfs *NFSStorage) Delete(path string) error {
 
 // MkDir creates a new directory
 func (nfs *NFSStorage) MkDir(path string) error {
-	if !nfs.mounted {
-		return fmt.Errorf("NFS share not mounted")
+	release, err := nfs.acquire()
+	if err != nil {
+		return err
 	}
+	defer release()
 
 	if nfs.readOnly {
-		return fmt.Errorf("NFS share is mounted read-only")
+		return fmt.Errorf("NFS share is mounted read-only: %w", ErrReadOnly)
 	}
 
 	fullPath := filepath.Join(nfs.mountPoint, path)
 	return os.MkdirAll(fullPath, 0755)
 }
 
+// Chmod changes the permission bits of a file or directory. Symlinks are
+// refused, as changing their mode would change their target's.
+func (nfs *NFSStorage) Chmod(path string, mode os.FileMode) error {
+	release, err := nfs.acquire()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if nfs.readOnly {
+		return fmt.Errorf("NFS share is mounted read-only: %w", ErrReadOnly)
+	}
+
+	fullPath := filepath.Join(nfs.mountPoint, path)
+	if err := refuseSymlink(fullPath); err != nil {
+		return err
+	}
+	return os.Chmod(fullPath, mode)
+}
+
+// Chown changes the owner and group of a file or directory
+func (nfs *NFSStorage) Chown(path string, uid, gid int) error {
+	release, err := nfs.acquire()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if nfs.readOnly {
+		return fmt.Errorf("NFS share is mounted read-only: %w", ErrReadOnly)
+	}
+
+	fullPath := filepath.Join(nfs.mountPoint, path)
+	return os.Lchown(fullPath, uid, gid)
+}
+
+// LocalOwners reports that owners are the users of this host, which maps
+// the share's IDs
+func (nfs *NFSStorage) LocalOwners() bool {
+	return true
+}
+
+// Owner returns the names of the user and group owning a file, as this
+// host maps the IDs the share reports
+func (nfs *NFSStorage) Owner(path string) (owner, group string, err error) {
+	release, err := nfs.acquire()
+	if err != nil {
+		return "", "", err
+	}
+	defer release()
+
+	return fileOwner(filepath.Join(nfs.mountPoint, path))
+}
+
+// IsReadOnly reports whether the share is mounted read-only
+func (nfs *NFSStorage) IsReadOnly() bool {
+	return nfs.readOnly
+}
+
 // Stat returns information about a file
 func (nfs *NFSStorage) Stat(path string) (FileInfo, error) {
-	if !nfs.mounted {
-		return FileInfo{}, fmt.Errorf("NFS share not mounted")
+	release, err := nfs.acquire()
+	if err != nil {
+		return FileInfo{}, err
 	}
+	defer release()
 
 	fullPath := filepath.Join(nfs.mountPoint, path)
 
@@ -233,12 +418,14 @@ func (nfs *NFSStorage) Stat(path string) (FileInfo, error) {
 
 // Move moves a file from src to dst
 func (nfs *NFSStorage) Move(src, dst string) error {
-	if !nfs.mounted {
-		return fmt.Errorf("NFS share not mounted")
+	release, err := nfs.acquire()
+	if err != nil {
+		return err
 	}
+	defer release()
 
 	if nfs.readOnly {
-		return fmt.Errorf("NFS share is mounted read-only")
+		return fmt.Errorf("NFS share is mounted read-only: %w", ErrReadOnly)
 	}
 
 	srcPath := filepath.Join(nfs.mountPoint, src)
@@ -253,11 +440,25 @@ func (nfs *NFSStorage) Move(src, dst string) error {
 	return os.Rename(srcPath, dstPath)
 }
 
+// DirID identifies a directory on the share by device and inode, so walks
+// notice when a link leads back into a directory they've been through
+func (nfs *NFSStorage) DirID(path string) (string, error) {
+	release, err := nfs.acquire()
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	return fileID(filepath.Join(nfs.mountPoint, path))
+}
+
 // Copy copies a file from src to dst
 func (nfs *NFSStorage) Copy(src, dst string, progress ProgressCallback) error {
-	if !nfs.mounted {
-		return fmt.Errorf("NFS share not mounted")
+	release, err := nfs.acquire()
+	if err != nil {
+		return err
 	}
+	defer release()
 
 	// Get file size for progress reporting
 	info, err := nfs.Stat(src)
@@ -325,13 +526,37 @@ func (nfs *NFSStorage) ResolvePath(path string) string {
 	return filepath.Clean(path)
 }
 
-// Close unmounts the NFS share
+// Close stops the idle timer and unmounts the NFS share
 func (nfs *NFSStorage) Close() error {
+	nfs.mu.Lock()
+	defer nfs.mu.Unlock()
+
+	if nfs.stopIdle != nil {
+		close(nfs.stopIdle)
+		nfs.stopIdle = nil
+	}
+	nfs.closed = true
 	return nfs.unmount()
 }
 
+// releaseTest closes a share mounted for a connection test. A share that
+// was found already mounted is left alone, as a configured storage may be
+// using it.
+func (nfs *NFSStorage) releaseTest() error {
+	nfs.mu.Lock()
+	owned := nfs.ownMount
+	nfs.mu.Unlock()
+	if !owned {
+		return nil
+	}
+	return nfs.Close()
+}
+
 // GetMountInfo returns information about the NFS mount
 func (nfs *NFSStorage) GetMountInfo() map[string]interface{} {
+	nfs.mu.Lock()
+	defer nfs.mu.Unlock()
+
 	info := map[string]interface{}{
 		"server":     nfs.server,
 		"exportPath": nfs.exportPath,
@@ -346,8 +571,20 @@ func (nfs *NFSStorage) GetMountInfo() map[string]interface{} {
 	return info
 }
 
+// Info reports the mount, see GetMountInfo
+func (nfs *NFSStorage) Info() map[string]interface{} {
+	info := nfs.GetMountInfo()
+	nfs.mu.Lock()
+	info["idleTimeout"] = nfs.idleTimeout.String()
+	nfs.mu.Unlock()
+	return info
+}
+
 // RefreshMount attempts to remount if connection was lost
 func (nfs *NFSStorage) RefreshMount() error {
+	nfs.mu.Lock()
+	defer nfs.mu.Unlock()
+
 	if err := nfs.checkMount(); err != nil {
 		nfs.mounted = false
 		return nfs.mount()
@@ -0,0 +1,141 @@
+//go:build !basic
+// +build !basic
+
+package storage
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeMounts stands in for the mount commands, recording what was run
+type fakeMounts struct {
+	mu       sync.Mutex
+	commands []string
+	mounted  bool
+}
+
+func (f *fakeMounts) run(name string, args ...string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.commands = append(f.commands, strings.TrimSpace(name+" "+strings.Join(args, " ")))
+	switch {
+	case name == "mount" && len(args) == 0:
+		return nil, nil // nothing mounted
+	case name == "mount":
+		f.mounted = true
+	case name == "umount":
+		f.mounted = false
+	}
+	return nil, nil
+}
+
+func (f *fakeMounts) count(prefix string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, cmd := range f.commands {
+		if strings.HasPrefix(cmd, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+func installFakeMounts(t *testing.T) *fakeMounts {
+	fake := &fakeMounts{}
+	saved := nfsCommand
+	nfsCommand = fake.run
+	t.Cleanup(func() { nfsCommand = saved })
+	return fake
+}
+
+func TestNFSStorage_IdleUnmount(t *testing.T) {
+	fake := installFakeMounts(t)
+	mountPoint := t.TempDir()
+	if err := os.WriteFile(mountPoint+"/file.txt", []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	nfs, err := NewNFSStorage("nas", "/export", mountPoint, false)
+	if err != nil {
+		t.Fatalf("Failed to create NFS storage: %v", err)
+	}
+	defer nfs.Close()
+	if fake.count("mount -t nfs") != 1 {
+		t.Fatalf("Expected one mount, got %v", fake.commands)
+	}
+
+	// Without an idle timeout the share stays mounted
+	if nfs.unmountIfIdle(time.Now().Add(24 * time.Hour)) {
+		t.Error("Expected no unmount without an idle timeout")
+	}
+
+	nfs.SetIdleTimeout(time.Minute)
+	if _, err := nfs.Stat("/file.txt"); err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if nfs.unmountIfIdle(time.Now().Add(30 * time.Second)) {
+		t.Error("Expected no unmount before the idle timeout")
+	}
+
+	// An open file holds the mount
+	reader, err := nfs.Read("/file.txt")
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if nfs.unmountIfIdle(time.Now().Add(2 * time.Minute)) {
+		t.Error("Expected no unmount while a file is open")
+	}
+	reader.Close()
+
+	if !nfs.unmountIfIdle(time.Now().Add(2 * time.Minute)) {
+		t.Fatal("Expected an unmount after the idle timeout")
+	}
+	if fake.count("umount") != 1 || fake.mounted {
+		t.Fatalf("Expected the share to be unmounted, got %v", fake.commands)
+	}
+
+	// The next operation mounts it again
+	info, err := nfs.Stat("/file.txt")
+	if err != nil {
+		t.Fatalf("Stat after idle unmount failed: %v", err)
+	}
+	if info.Size != 4 {
+		t.Errorf("Expected size 4, got %d", info.Size)
+	}
+	if fake.count("mount -t nfs") != 2 || !fake.mounted {
+		t.Errorf("Expected a remount, got %v", fake.commands)
+	}
+}
+
+func TestCloudManager_RemoveStorageClosesNFS(t *testing.T) {
+	fake := installFakeMounts(t)
+	dir := t.TempDir()
+	t.Chdir(dir)
+	if err := os.Mkdir("config", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	nfs, err := NewNFSStorage("nas", "/export", t.TempDir(), true)
+	if err != nil {
+		t.Fatalf("Failed to create NFS storage: %v", err)
+	}
+	sm := NewCloudManager()
+	sm.Register("nas", nfs)
+	sm.configs["nas"] = &StorageConfig{ID: "nas", Type: "nfs"}
+
+	if err := sm.RemoveStorage("nas"); err != nil {
+		t.Fatalf("RemoveStorage failed: %v", err)
+	}
+	if fake.count("umount") != 1 || fake.mounted {
+		t.Errorf("Expected the share to be unmounted on removal, got %v", fake.commands)
+	}
+	if _, err := nfs.Stat("/"); err == nil {
+		t.Error("Expected a removed storage not to mount again")
+	}
+}
@@ -6,14 +6,20 @@ package storage
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
+	"os"
 	"path"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/oauth2"
@@ -25,9 +31,25 @@ type OneDriveStorage struct {
 	baseURL string
 	driveID string
 	cache   map[string]*OneDriveItem
+	cacheMu sync.Mutex
+
+	chunkSize     int // upload session chunk size, oneDriveChunkSize if zero
+	uploadRetries int // attempts per chunk, DefaultOneDriveUploadRetries if zero
 	// Note: accessToken removed - auth handled via OAuth2 client configuration
 }
 
+// oneDriveChunkSize is the upload session chunk size. Graph wants chunks
+// in multiples of 320 KiB.
+const oneDriveChunkSize = 32 * 320 * 1024
+
+// oneDriveSimpleUploadLimit is the size from which files are written
+// through an upload session rather than a single PUT
+const oneDriveSimpleUploadLimit = 4 << 20
+
+// DefaultOneDriveUploadRetries is how many times a failed upload chunk is
+// retried before the upload session is cancelled
+const DefaultOneDriveUploadRetries = 5
+
 // OneDriveItem represents a file or folder in OneDrive
 type OneDriveItem struct {
 	ID               string           `json:"id"`
@@ -49,7 +71,15 @@ type OneDriveFolder struct {
 
 // OneDriveFile represents file metadata
 type OneDriveFile struct {
-	MimeType string `json:"mimeType"`
+	MimeType string          `json:"mimeType"`
+	Hashes   *OneDriveHashes `json:"hashes,omitempty"`
+}
+
+// OneDriveHashes are the content hashes Graph reports for a file
+type OneDriveHashes struct {
+	QuickXorHash string `json:"quickXorHash,omitempty"`
+	SHA1Hash     string `json:"sha1Hash,omitempty"`
+	SHA256Hash   string `json:"sha256Hash,omitempty"`
 }
 
 // ParentReference contains parent folder information
@@ -119,6 +149,41 @@ func NewOneDriveFileSystem(clientID, clientSecret, refreshToken string) (*OneDri
 
 // List lists files in a directory
 func (o *OneDriveStorage) List(dirPath string) ([]FileInfo, error) {
+	allItems, err := o.listChildren(dirPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Convert to FileInfo
+	var files []FileInfo
+	for _, item := range allItems {
+		isDir := item.Folder != nil
+		mimeType := ""
+		if item.File != nil {
+			mimeType = item.File.MimeType
+		}
+
+		// Cache the item
+		fullPath := path.Join(dirPath, item.Name)
+		o.cacheMu.Lock()
+		o.cache[fullPath] = &item
+		o.cacheMu.Unlock()
+
+		files = append(files, FileInfo{
+			Name:     item.Name,
+			Size:     item.Size,
+			IsDir:    isDir,
+			ModTime:  o.parseTime(item.ModifiedDateTime),
+			Path:     fullPath,
+			MimeType: mimeType,
+		})
+	}
+
+	return files, nil
+}
+
+// listChildren fetches every item in a directory, following the pages
+func (o *OneDriveStorage) listChildren(dirPath string) ([]OneDriveItem, error) {
 	encodedPath := o.encodePath(dirPath)
 
 	var apiURL string
@@ -157,34 +222,112 @@ func (o *OneDriveStorage) List(dirPath string) ([]FileInfo, error) {
 		nextLink = listResp.NextLink
 	}
 
-	// Convert to FileInfo
-	var files []FileInfo
-	for _, item := range allItems {
-		isDir := item.Folder != nil
-		mimeType := ""
-		if item.File != nil {
-			mimeType = item.File.MimeType
-		}
-
-		// Cache the item
-		fullPath := path.Join(dirPath, item.Name)
-		o.cache[fullPath] = &item
+	return allItems, nil
+}
 
-		files = append(files, FileInfo{
-			Name:     item.Name,
-			Size:     item.Size,
-			IsDir:    isDir,
-			ModTime:  o.parseTime(item.ModifiedDateTime),
-			Path:     fullPath,
-			MimeType: mimeType,
-		})
+// ContentHashes returns the hashes OneDrive keeps for the files in a
+// directory. Personal drives have SHA-1 and quickXorHash, business drives
+// quickXorHash alone, and some SHA-256 too.
+func (o *OneDriveStorage) ContentHashes(dirPath, algo string) (map[string]string, error) {
+	if algo != "sha1" && algo != "sha256" && algo != "quickxor" {
+		return nil, ErrNotSupported
+	}
+	items, err := o.listChildren(dirPath)
+	if err != nil {
+		return nil, err
 	}
 
-	return files, nil
+	hashes := make(map[string]string)
+	for _, item := range items {
+		if item.File == nil || item.File.Hashes == nil {
+			continue
+		}
+		var digest string
+		switch algo {
+		case "sha1":
+			digest = strings.ToLower(item.File.Hashes.SHA1Hash)
+		case "sha256":
+			digest = strings.ToLower(item.File.Hashes.SHA256Hash)
+		case "quickxor":
+			// Graph sends it base64 encoded
+			if raw, err := base64.StdEncoding.DecodeString(item.File.Hashes.QuickXorHash); err == nil {
+				digest = hex.EncodeToString(raw)
+			}
+		}
+		if digest != "" {
+			hashes[item.Name] = digest
+		}
+	}
+	return hashes, nil
 }
 
 // Stat returns information about a file
 func (o *OneDriveStorage) Stat(filePath string) (FileInfo, error) {
+	item, err := o.getItem(filePath)
+	if err != nil {
+		return FileInfo{}, err
+	}
+
+	isDir := item.Folder != nil
+	mimeType := ""
+	if item.File != nil {
+		mimeType = item.File.MimeType
+	}
+
+	return FileInfo{
+		Name:     item.Name,
+		Size:     item.Size,
+		IsDir:    isDir,
+		ModTime:  o.parseTime(item.ModifiedDateTime),
+		Path:     filePath,
+		MimeType: mimeType,
+	}, nil
+}
+
+// oneDriveReservedNames are the names OneDrive refuses for files and
+// folders, besides names starting with "~$"
+var oneDriveReservedNames = []string{
+	".lock", "CON", "PRN", "AUX", "NUL",
+	"COM0", "COM1", "COM2", "COM3", "COM4", "COM5", "COM6", "COM7", "COM8", "COM9",
+	"LPT0", "LPT1", "LPT2", "LPT3", "LPT4", "LPT5", "LPT6", "LPT7", "LPT8", "LPT9",
+	"_vti_", "desktop.ini",
+}
+
+// PathConventions reports OneDrive's rules, which follow Windows: names
+// ignore case, and device names and some punctuation are refused
+func (o *OneDriveStorage) PathConventions() PathConventions {
+	conventions := unixPathConventions
+	conventions.CaseSensitive = false
+	conventions.ReservedNames = oneDriveReservedNames
+	conventions.ReservedChars = "\"*:<>?/\\|"
+	return conventions
+}
+
+// NativeID returns the OneDrive item ID, which stays the same when the
+// item is renamed or moved
+func (o *OneDriveStorage) NativeID(filePath string) (string, error) {
+	item, err := o.getItem(filePath)
+	if err != nil {
+		return "", err
+	}
+	return item.ID, nil
+}
+
+// invalidateCache drops the cached items for filePath and everything
+// below it
+func (o *OneDriveStorage) invalidateCache(filePath string) {
+	prefix := strings.TrimSuffix(filePath, "/") + "/"
+	o.cacheMu.Lock()
+	defer o.cacheMu.Unlock()
+	for key := range o.cache {
+		if key == filePath || strings.HasPrefix(key, prefix) {
+			delete(o.cache, key)
+		}
+	}
+}
+
+// getItem fetches the metadata of the item at filePath
+func (o *OneDriveStorage) getItem(filePath string) (*OneDriveItem, error) {
 	encodedPath := o.encodePath(filePath)
 
 	var apiURL string
@@ -196,7 +339,7 @@ func (o *OneDriveStorage) Stat(filePath string) (FileInfo, error) {
 
 	resp, err := o.client.Get(apiURL)
 	if err != nil {
-		return FileInfo{}, fmt.Errorf("failed to get item info: %v", err)
+		return nil, fmt.Errorf("failed to get item info: %v", err)
 	}
 	defer func() {
 		if err := resp.Body.Close(); err != nil {
@@ -205,28 +348,14 @@ func (o *OneDriveStorage) Stat(filePath string) (FileInfo, error) {
 	}()
 
 	if resp.StatusCode != http.StatusOK {
-		return FileInfo{}, fmt.Errorf("item not found")
+		return nil, fmt.Errorf("item not found")
 	}
 
 	var item OneDriveItem
 	if err := json.NewDecoder(resp.Body).Decode(&item); err != nil {
-		return FileInfo{}, fmt.Errorf("failed to parse item info: %v", err)
+		return nil, fmt.Errorf("failed to parse item info: %v", err)
 	}
-
-	isDir := item.Folder != nil
-	mimeType := ""
-	if item.File != nil {
-		mimeType = item.File.MimeType
-	}
-
-	return FileInfo{
-		Name:     item.Name,
-		Size:     item.Size,
-		IsDir:    isDir,
-		ModTime:  o.parseTime(item.ModifiedDateTime),
-		Path:     filePath,
-		MimeType: mimeType,
-	}, nil
+	return &item, nil
 }
 
 // Read reads a file from OneDrive
@@ -251,20 +380,99 @@ func (o *OneDriveStorage) Read(filePath string) (io.ReadCloser, error) {
 	return resp.Body, nil
 }
 
-// Write writes a file to OneDrive
+// SetUploadRetries sets how many times a failed large-upload chunk is
+// retried. Zero restores DefaultOneDriveUploadRetries.
+func (o *OneDriveStorage) SetUploadRetries(n int) {
+	o.uploadRetries = n
+}
+
+// Write writes a file to OneDrive. Upload sessions need the total size up
+// front, so data that can seek is measured and streamed like WriteSized,
+// while anything else too big for a simple upload is spooled to disk first.
 func (o *OneDriveStorage) Write(filePath string, data io.Reader) error {
-	content, err := io.ReadAll(data)
+	if size, ok := remainingSize(data); ok {
+		return o.WriteSized(filePath, data, size, nil)
+	}
+
+	// For small files (< 4MB), use simple upload
+	head := make([]byte, oneDriveSimpleUploadLimit)
+	n, err := io.ReadFull(data, head)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return o.simpleUpload(filePath, head[:n])
+	}
 	if err != nil {
 		return fmt.Errorf("failed to read data: %v", err)
 	}
 
-	// For small files (< 4MB), use simple upload
-	if len(content) < 4*1024*1024 {
-		return o.simpleUpload(filePath, content)
+	spool, err := os.CreateTemp("", "jacommander-onedrive-*")
+	if err != nil {
+		return fmt.Errorf("failed to create upload spool file: %v", err)
+	}
+	defer func() {
+		if err := spool.Close(); err != nil {
+			log.Printf("Error closing upload spool file: %v", err)
+		}
+		if err := os.Remove(spool.Name()); err != nil {
+			log.Printf("Error removing upload spool file: %v", err)
+		}
+	}()
+	size, err := io.Copy(spool, io.MultiReader(bytes.NewReader(head), data))
+	if err != nil {
+		return fmt.Errorf("failed to read data: %v", err)
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind upload spool file: %v", err)
 	}
 
-	// For large files, use upload session
-	return o.largeUpload(filePath, content)
+	return o.largeUpload(filePath, spool, size, nil)
+}
+
+// WriteSized writes the size bytes of data to OneDrive, failing if data
+// holds more or less than that. Large files are streamed through an
+// upload session a chunk at a time, so only one chunk is ever held in
+// memory; progress is called as each is accepted.
+func (o *OneDriveStorage) WriteSized(filePath string, data io.Reader, size int64, progress ProgressCallback) error {
+	if size >= oneDriveSimpleUploadLimit {
+		return o.largeUpload(filePath, data, size, progress)
+	}
+
+	content := make([]byte, size)
+	if _, err := io.ReadFull(data, content); err != nil {
+		return fmt.Errorf("failed to read data: %v", err)
+	}
+	if err := expectEOF(data); err != nil {
+		return err
+	}
+	if err := o.simpleUpload(filePath, content); err != nil {
+		return err
+	}
+	if progress != nil {
+		progress(size, size)
+	}
+	return nil
+}
+
+// errSizeMismatch is returned when data runs past the size it was
+// declared with
+var errSizeMismatch = errors.New("the data is larger than its declared size")
+
+// expectEOF checks that r has nothing left, so that data larger than the
+// size it was declared with fails before the upload is committed rather
+// than being cut short
+func expectEOF(r io.Reader) error {
+	var extra [1]byte
+	for {
+		n, err := r.Read(extra[:])
+		if n > 0 {
+			return errSizeMismatch
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read data: %v", err)
+		}
+	}
 }
 
 // simpleUpload handles small file uploads
@@ -298,13 +506,15 @@ func (o *OneDriveStorage) simpleUpload(filePath string, content []byte) error {
 }
 
 // largeUpload handles large file uploads using upload sessions
-func (o *OneDriveStorage) largeUpload(filePath string, content []byte) error {
+func (o *OneDriveStorage) largeUpload(filePath string, content io.Reader, size int64, progress ProgressCallback) error {
 	// Create upload session
 	encodedPath := o.encodePath(filePath)
 	sessionURL := fmt.Sprintf("%s/me/drive/root:%s:/createUploadSession", o.baseURL, encodedPath)
 
+	// Replace an existing file like the simple upload does, rather than
+	// keeping both under a new name
 	sessionReq := map[string]interface{}{
-		"@microsoft.graph.conflictBehavior": "rename",
+		"@microsoft.graph.conflictBehavior": "replace",
 	}
 
 	sessionData, _ := json.Marshal(sessionReq)
@@ -338,43 +548,258 @@ func (o *OneDriveStorage) largeUpload(filePath string, content []byte) error {
 		return err
 	}
 
-	// Upload file in chunks
-	chunkSize := 10 * 1024 * 1024 // 10MB chunks
-	totalSize := len(content)
+	if err := o.uploadChunks(session.UploadURL, content, size, progress); err != nil {
+		// Leave no half-written session behind on OneDrive
+		o.cancelUploadSession(session.UploadURL)
+		return err
+	}
+	return nil
+}
 
-	for offset := 0; offset < totalSize; offset += chunkSize {
-		end := offset + chunkSize
-		if end > totalSize {
-			end = totalSize
+// uploadChunks sends totalSize bytes of content to an upload session chunk
+// by chunk, reading each as it's sent. A failed chunk is retried with a
+// growing delay, resuming from the offset the server says it expects next.
+func (o *OneDriveStorage) uploadChunks(uploadURL string, content io.Reader, totalSize int64, progress ProgressCallback) error {
+	chunkSize := o.chunkSize
+	if chunkSize <= 0 {
+		chunkSize = oneDriveChunkSize
+	}
+	retries := o.uploadRetries
+	if retries <= 0 {
+		retries = DefaultOneDriveUploadRetries
+	}
+	stream := &chunkStream{r: content, buf: make([]byte, chunkSize)}
+
+	offset := int64(0)
+	failures := 0
+	delay := retryBaseDelay
+	for offset < totalSize {
+		chunk, err := stream.chunk(offset, int(min(int64(chunkSize), totalSize-offset)))
+		if err != nil {
+			return fmt.Errorf("failed to read chunk: %w", err)
+		}
+		if offset+int64(len(chunk)) == totalSize {
+			// The last chunk commits the file, so check there's no more
+			if err := expectEOF(content); err != nil {
+				return err
+			}
 		}
 
-		chunk := content[offset:end]
+		next, done, err := o.putChunk(uploadURL, chunk, offset, totalSize)
+		if done {
+			if progress != nil {
+				progress(totalSize, totalSize)
+			}
+			return nil
+		}
+		if err == nil {
+			offset = next
+			failures = 0
+			delay = retryBaseDelay
+			if progress != nil {
+				progress(offset, totalSize)
+			}
+			continue
+		}
 
-		req, err := http.NewRequest("PUT", session.UploadURL, bytes.NewReader(chunk))
-		if err != nil {
+		var chunkErr *chunkError
+		if errors.As(err, &chunkErr) && !chunkErr.retryable {
 			return err
 		}
+		failures++
+		if failures > retries {
+			return fmt.Errorf("chunk upload failed after %d attempts: %w", failures, err)
+		}
+		if chunkErr != nil && chunkErr.retryAfter > delay {
+			time.Sleep(chunkErr.retryAfter)
+		} else {
+			time.Sleep(delay)
+		}
+		delay = min(delay*2, retryMaxDelay)
 
-		rangeHeader := fmt.Sprintf("bytes %d-%d/%d", offset, end-1, totalSize)
-		req.Header.Set("Content-Range", rangeHeader)
-		req.Header.Set("Content-Length", fmt.Sprintf("%d", len(chunk)))
+		// The chunk may have landed before the error; ask where to resume
+		if expected, ok := o.uploadSessionOffset(uploadURL); ok {
+			offset = expected
+		} else if chunkErr != nil && chunkErr.nextOffset >= 0 {
+			offset = chunkErr.nextOffset
+		}
+	}
 
-		chunkResp, err := o.client.Do(req)
+	return nil
+}
+
+// chunkStream reads an upload forward from r, keeping only the chunk last
+// read so that it can be resent after a failure. It always holds the bytes
+// from start up to where r has been read.
+type chunkStream struct {
+	r     io.Reader
+	buf   []byte
+	start int64 // offset of buf[0]
+	n     int   // bytes of buf holding data
+}
+
+// chunk returns the length bytes at offset. The server may ask to resume
+// anywhere: ahead of the chunk held, bytes are read and dropped; behind
+// it, r is seeked back if it can be, and otherwise the upload can't go on.
+func (c *chunkStream) chunk(offset int64, length int) ([]byte, error) {
+	end := c.start + int64(c.n)
+	switch {
+	case offset < c.start:
+		seeker, ok := c.r.(io.Seeker)
+		if !ok {
+			return nil, fmt.Errorf("server asked for byte %d again, which was already discarded", offset)
+		}
+		if _, err := seeker.Seek(offset-end, io.SeekCurrent); err != nil {
+			return nil, err
+		}
+		c.n = 0
+	case offset <= end:
+		c.n = copy(c.buf, c.buf[offset-c.start:c.n])
+	default:
+		if _, err := io.CopyN(io.Discard, c.r, offset-end); err != nil {
+			return nil, err
+		}
+		c.n = 0
+	}
+	c.start = offset
+
+	if c.n < length {
+		n, err := io.ReadFull(c.r, c.buf[c.n:length])
+		c.n += n
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("data ended after %d bytes", c.start+int64(c.n))
+		}
 		if err != nil {
-			return err
+			return nil, err
 		}
-		func() {
-			if err := chunkResp.Body.Close(); err != nil {
-				log.Printf("Error closing chunk response body: %v", err)
-			}
-		}()
+	}
+	return c.buf[:length], nil
+}
 
-		if chunkResp.StatusCode != http.StatusAccepted && chunkResp.StatusCode != http.StatusCreated && chunkResp.StatusCode != http.StatusOK {
-			return fmt.Errorf("chunk upload failed: status %d", chunkResp.StatusCode)
+// chunkError is a chunk upload failure
+type chunkError struct {
+	status     int
+	retryable  bool
+	retryAfter time.Duration
+	nextOffset int64 // from nextExpectedRanges in the error body, or -1
+}
+
+func (e *chunkError) Error() string {
+	return fmt.Sprintf("chunk upload failed: status %d", e.status)
+}
+
+// uploadSessionStatus is the body OneDrive returns for an upload session
+type uploadSessionStatus struct {
+	NextExpectedRanges []string `json:"nextExpectedRanges"`
+}
+
+// nextOffset returns the start of the first range the server still expects
+func (s uploadSessionStatus) nextOffset() (int64, bool) {
+	if len(s.NextExpectedRanges) == 0 {
+		return 0, false
+	}
+	start, _, _ := strings.Cut(s.NextExpectedRanges[0], "-")
+	n, err := strconv.ParseInt(start, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// putChunk uploads content[offset:offset+len(chunk)]. It reports done once
+// the server has created the item, and otherwise the next offset the server
+// expects.
+func (o *OneDriveStorage) putChunk(uploadURL string, chunk []byte, offset, totalSize int64) (next int64, done bool, err error) {
+	end := offset + int64(len(chunk))
+	req, err := http.NewRequest("PUT", uploadURL, bytes.NewReader(chunk))
+	if err != nil {
+		return 0, false, err
+	}
+
+	req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, end-1, totalSize))
+	req.ContentLength = int64(len(chunk))
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return 0, false, fmt.Errorf("chunk upload failed: %w", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Printf("Error closing chunk response body: %v", err)
 		}
+	}()
+
+	var status uploadSessionStatus
+	_ = json.NewDecoder(resp.Body).Decode(&status)
+	expected, hasExpected := status.nextOffset()
+
+	switch {
+	case resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusCreated:
+		return 0, true, nil
+	case resp.StatusCode == http.StatusAccepted:
+		if hasExpected {
+			return expected, false, nil
+		}
+		return end, false, nil
 	}
 
-	return nil
+	chunkErr := &chunkError{status: resp.StatusCode, nextOffset: -1}
+	if hasExpected {
+		chunkErr.nextOffset = expected
+	}
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode >= 500:
+		chunkErr.retryable = true
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			chunkErr.retryAfter = time.Duration(secs) * time.Second
+		}
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable, resp.StatusCode == http.StatusConflict:
+		// Out of step with the server; resync from the session status
+		chunkErr.retryable = true
+	}
+	return 0, false, chunkErr
+}
+
+// uploadSessionOffset asks the upload session which byte it expects next
+func (o *OneDriveStorage) uploadSessionOffset(uploadURL string) (int64, bool) {
+	resp, err := o.client.Get(uploadURL)
+	if err != nil {
+		return 0, false
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Printf("Error closing response body: %v", err)
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, false
+	}
+	var status uploadSessionStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return 0, false
+	}
+	return status.nextOffset()
+}
+
+// cancelUploadSession deletes an upload session so its uploaded chunks are
+// discarded
+func (o *OneDriveStorage) cancelUploadSession(uploadURL string) {
+	req, err := http.NewRequest("DELETE", uploadURL, nil)
+	if err != nil {
+		return
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		log.Printf("Failed to cancel OneDrive upload session: %v", err)
+		return
+	}
+	if err := resp.Body.Close(); err != nil {
+		log.Printf("Error closing response body: %v", err)
+	}
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		log.Printf("Failed to cancel OneDrive upload session: status %d", resp.StatusCode)
+	}
 }
 
 // Delete deletes a file or folder
@@ -397,13 +822,15 @@ func (o *OneDriveStorage) Delete(filePath string) error {
 		}
 	}()
 
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		return fmt.Errorf("delete failed: %w (status %d)", ErrRateLimited, resp.StatusCode)
+	}
 	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
 		return fmt.Errorf("delete failed: %s", body)
 	}
 
-	// Remove from cache
-	delete(o.cache, filePath)
+	o.invalidateCache(filePath)
 
 	return nil
 }
@@ -519,8 +946,9 @@ func (o *OneDriveStorage) Move(src, dst string) error {
 		return fmt.Errorf("move failed: %s", body)
 	}
 
-	// Update cache
-	delete(o.cache, src)
+	// Entries below either path no longer describe what is there
+	o.invalidateCache(src)
+	o.invalidateCache(dst)
 
 	return nil
 }
@@ -717,48 +1145,6 @@ func (o *OneDriveStorage) PutFileContent(filePath string, content []byte) error
 	return o.Write(filePath, bytes.NewReader(content))
 }
 
-// Search searches for files
-func (o *OneDriveStorage) Search(query string, options map[string]interface{}) ([]FileInfo, error) {
-	searchURL := fmt.Sprintf("%s/me/drive/search(q='%s')", o.baseURL, url.QueryEscape(query))
-
-	resp, err := o.client.Get(searchURL)
-	if err != nil {
-		return nil, err
-	}
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			log.Printf("Error closing response body: %v", err)
-		}
-	}()
-
-	var searchResp struct {
-		Value []OneDriveItem `json:"value"`
-	}
-
-	if err := json.NewDecoder(resp.Body).Decode(&searchResp); err != nil {
-		return nil, err
-	}
-
-	var results []FileInfo
-	for _, item := range searchResp.Value {
-		isDir := item.Folder != nil
-		mimeType := ""
-		if item.File != nil {
-			mimeType = item.File.MimeType
-		}
-
-		results = append(results, FileInfo{
-			Name:     item.Name,
-			Size:     item.Size,
-			IsDir:    isDir,
-			ModTime:  o.parseTime(item.ModifiedDateTime),
-			MimeType: mimeType,
-		})
-	}
-
-	return results, nil
-}
-
 // Helper functions
 
 func (o *OneDriveStorage) encodePath(filePath string) string {
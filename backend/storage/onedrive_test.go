@@ -0,0 +1,341 @@
+//go:build !basic
+// +build !basic
+
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestOneDriveStorage_NativeID(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/me/drive/root:/Documents/report.docx":
+			w.Write([]byte(`{"id": "01BYE5RZ6QN3ZWBTUFOFD3GSPGOHDJD36K", "name": "report.docx", "file": {}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	o := &OneDriveStorage{client: server.Client(), baseURL: server.URL}
+
+	id, err := o.NativeID("/Documents/report.docx")
+	if err != nil {
+		t.Fatalf("Failed to get native ID: %v", err)
+	}
+	if id != "01BYE5RZ6QN3ZWBTUFOFD3GSPGOHDJD36K" {
+		t.Errorf("Expected the item ID, got %q", id)
+	}
+
+	if _, err := o.NativeID("/missing.txt"); err == nil {
+		t.Error("Expected error for missing item")
+	}
+}
+
+func TestOneDriveStorage_MoveInvalidatesCache(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/me/drive/root:/old":
+			w.Write([]byte(`{"id": "dir-1", "name": "old", "folder": {}}`))
+		case r.Method == http.MethodPatch && r.URL.Path == "/me/drive/items/dir-1":
+			w.Write([]byte(`{"id": "dir-1", "name": "new", "folder": {}}`))
+		case r.URL.Path == "/me/drive/root:/new/sub:/children":
+			w.Write([]byte(`{"value": [{"id": "file-2", "name": "file.txt", "file": {}}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	o := &OneDriveStorage{client: server.Client(), baseURL: server.URL, cache: map[string]*OneDriveItem{
+		"/old":              {ID: "dir-1"},
+		"/old/sub/file.txt": {ID: "file-2"},
+		"/new/sub/file.txt": {ID: "stale"},
+		"/older/file.txt":   {ID: "file-3"},
+	}}
+
+	if err := o.Move("/old", "/new"); err != nil {
+		t.Fatalf("Failed to move: %v", err)
+	}
+	for _, key := range []string{"/old", "/old/sub/file.txt", "/new/sub/file.txt"} {
+		if _, cached := o.cache[key]; cached {
+			t.Errorf("Expected %s to leave the cache", key)
+		}
+	}
+	if _, cached := o.cache["/older/file.txt"]; !cached {
+		t.Error("Expected a sibling sharing the prefix to stay cached")
+	}
+
+	files, err := o.List("/new/sub")
+	if err != nil {
+		t.Fatalf("Failed to list the moved folder: %v", err)
+	}
+	if len(files) != 1 || files[0].Path != "/new/sub/file.txt" {
+		t.Fatalf("Expected the moved child, got %+v", files)
+	}
+	if item := o.cache["/new/sub/file.txt"]; item == nil || item.ID != "file-2" {
+		t.Errorf("Expected the moved child to be cached under its new path, got %+v", item)
+	}
+}
+
+func TestOneDriveStorage_ContentHashes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/me/drive/root:/Documents:/children" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"value": [
+			{"name": "report.docx", "file": {"hashes": {"quickXorHash": "AAECAwQFBgcICQoLDA0ODxAREhM=", "sha1Hash": "A9993E364706816ABA3E25717850C26C9CD0D89D"}}},
+			{"name": "business.xlsx", "file": {"hashes": {"quickXorHash": "ExIREA8ODQwLCgkIBwYFBAMCAQA="}}},
+			{"name": "Photos", "folder": {"childCount": 3}}
+		]}`))
+	}))
+	defer server.Close()
+
+	o := &OneDriveStorage{client: server.Client(), baseURL: server.URL}
+
+	hashes, err := o.ContentHashes("/Documents", "quickxor")
+	if err != nil {
+		t.Fatalf("Failed to get hashes: %v", err)
+	}
+	if len(hashes) != 2 || hashes["report.docx"] != "000102030405060708090a0b0c0d0e0f10111213" {
+		t.Errorf("Expected hex quickXorHashes of both files, got %v", hashes)
+	}
+
+	hashes, err = o.ContentHashes("/Documents", "sha1")
+	if err != nil {
+		t.Fatalf("Failed to get hashes: %v", err)
+	}
+	if len(hashes) != 1 || hashes["report.docx"] != "a9993e364706816aba3e25717850c26c9cd0d89d" {
+		t.Errorf("Expected the lowercase SHA-1 of report.docx only, got %v", hashes)
+	}
+
+	if _, err := o.ContentHashes("/Documents", "md5"); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("Expected ErrNotSupported for md5, got %v", err)
+	}
+}
+
+// mockUploadSession is a Graph upload session that appends chunks in
+// order, rejecting any that don't start where it expects
+type mockUploadSession struct {
+	mu        sync.Mutex
+	received  []byte
+	stored    int64
+	puts      int
+	cancelled bool
+	// digest, when set, is fed the chunks instead of received
+	digest hash.Hash
+	// fail returns the status to answer the nth PUT with after storing
+	// its chunk, or 0 to accept it
+	fail func(n int) int
+}
+
+func (s *mockUploadSession) handler(t *testing.T, uploadURL *string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/me/drive/root:/big.bin:/createUploadSession":
+			fmt.Fprintf(w, `{"uploadUrl": %q}`, *uploadURL)
+		case r.Method == "GET" && r.URL.Path == "/upload":
+			fmt.Fprintf(w, `{"nextExpectedRanges": ["%d-"]}`, s.stored)
+		case r.Method == "DELETE" && r.URL.Path == "/upload":
+			s.cancelled = true
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == "PUT" && r.URL.Path == "/upload":
+			s.puts++
+			var start, end, total int
+			if _, err := fmt.Sscanf(r.Header.Get("Content-Range"), "bytes %d-%d/%d", &start, &end, &total); err != nil {
+				t.Errorf("Bad Content-Range %q", r.Header.Get("Content-Range"))
+			}
+			if int64(start) != s.stored {
+				w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+				fmt.Fprintf(w, `{"nextExpectedRanges": ["%d-"]}`, s.stored)
+				return
+			}
+			chunk, _ := io.ReadAll(r.Body)
+			if s.digest != nil {
+				s.digest.Write(chunk)
+			} else {
+				s.received = append(s.received, chunk...)
+			}
+			s.stored += int64(len(chunk))
+			if s.fail != nil {
+				if status := s.fail(s.puts); status != 0 {
+					w.WriteHeader(status)
+					return
+				}
+			}
+			if s.stored == int64(total) {
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte(`{"id": "item-1"}`))
+				return
+			}
+			w.WriteHeader(http.StatusAccepted)
+			fmt.Fprintf(w, `{"nextExpectedRanges": ["%d-%d"]}`, s.stored, total-1)
+		default:
+			http.NotFound(w, r)
+		}
+	}
+}
+
+func TestOneDriveStorage_LargeUpload(t *testing.T) {
+	defer func(delay time.Duration) { retryBaseDelay = delay }(retryBaseDelay)
+	retryBaseDelay = time.Millisecond
+
+	content := []byte("0123456789abcdefghij")
+
+	newSession := func(t *testing.T, fail func(n int) int) (*OneDriveStorage, *mockUploadSession) {
+		session := &mockUploadSession{fail: fail}
+		var uploadURL string
+		server := httptest.NewServer(session.handler(t, &uploadURL))
+		t.Cleanup(server.Close)
+		uploadURL = server.URL + "/upload"
+		return &OneDriveStorage{client: server.Client(), baseURL: server.URL, chunkSize: 6}, session
+	}
+
+	t.Run("Retry after the chunk landed", func(t *testing.T) {
+		// The second chunk is stored but answered with a 503; resending it
+		// unchanged would duplicate it
+		o, session := newSession(t, func(n int) int {
+			if n == 2 {
+				return http.StatusServiceUnavailable
+			}
+			return 0
+		})
+		if err := o.largeUpload("/big.bin", bytes.NewReader(content), int64(len(content)), nil); err != nil {
+			t.Fatalf("Failed to upload: %v", err)
+		}
+		if !bytes.Equal(session.received, content) {
+			t.Errorf("Expected %q uploaded, got %q", content, session.received)
+		}
+		if session.cancelled {
+			t.Error("Expected the session to be kept")
+		}
+	})
+
+	t.Run("Cancel after retries run out", func(t *testing.T) {
+		o, session := newSession(t, func(n int) int {
+			if n > 1 {
+				return http.StatusInternalServerError
+			}
+			return 0
+		})
+		o.SetUploadRetries(2)
+		if err := o.largeUpload("/big.bin", bytes.NewReader(content), int64(len(content)), nil); err == nil {
+			t.Fatal("Expected the upload to fail")
+		}
+		if !session.cancelled {
+			t.Error("Expected the upload session to be deleted")
+		}
+	})
+
+	t.Run("Cancel on a permanent error", func(t *testing.T) {
+		o, session := newSession(t, func(n int) int { return http.StatusNotFound })
+		if err := o.largeUpload("/big.bin", bytes.NewReader(content), int64(len(content)), nil); err == nil {
+			t.Fatal("Expected the upload to fail")
+		}
+		if session.puts != 1 || !session.cancelled {
+			t.Errorf("Expected one attempt and a cancelled session, got %d attempts, cancelled %v", session.puts, session.cancelled)
+		}
+	})
+}
+
+func TestOneDriveStorage_WriteLarge(t *testing.T) {
+	session := &mockUploadSession{}
+	var uploadURL string
+	server := httptest.NewServer(session.handler(t, &uploadURL))
+	defer server.Close()
+	uploadURL = server.URL + "/upload"
+	o := &OneDriveStorage{client: server.Client(), baseURL: server.URL, chunkSize: 1 << 20}
+
+	size := int64(24 << 20)
+	source := newHeapWatcher(&patternReader{size: size})
+	if err := o.Write("/big.bin", source); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if growth := source.growth(); growth > size/2 {
+		t.Errorf("Expected the file to be spooled rather than buffered, heap grew by %d bytes", growth)
+	}
+	if !bytes.Equal(session.received, patternBytes(size)) {
+		t.Errorf("Uploaded content differs (%d bytes, want %d)", len(session.received), size)
+	}
+}
+
+func TestOneDriveStorage_WriteSized(t *testing.T) {
+	session := &mockUploadSession{digest: sha256.New()}
+	var uploadURL string
+	server := httptest.NewServer(session.handler(t, &uploadURL))
+	defer server.Close()
+	uploadURL = server.URL + "/upload"
+	o := &OneDriveStorage{client: server.Client(), baseURL: server.URL}
+
+	size := int64(500 << 20)
+	source := newHeapWatcher(&patternReader{size: size})
+	var reports int
+	var last int64
+	err := WriteSized(o, "/big.bin", source, size, func(current, total int64) {
+		reports++
+		last = current
+	})
+	if err != nil {
+		t.Fatalf("WriteSized failed: %v", err)
+	}
+	if growth := source.growth(); growth > 64<<20 {
+		t.Errorf("Expected the upload to hold a chunk at a time, heap grew by %d bytes", growth)
+	}
+	if want := (size + oneDriveChunkSize - 1) / oneDriveChunkSize; int64(session.puts) != want {
+		t.Errorf("Expected %d chunks, got %d", want, session.puts)
+	}
+	if reports != session.puts || last != size {
+		t.Errorf("Expected progress after each of %d chunks ending at %d, got %d ending at %d", session.puts, size, reports, last)
+	}
+
+	want := sha256.New()
+	io.Copy(want, &patternReader{size: size})
+	if !bytes.Equal(session.digest.Sum(nil), want.Sum(nil)) {
+		t.Errorf("Uploaded content differs (%d bytes, want %d)", session.stored, size)
+	}
+
+	// Data ending early fails rather than leaving a short file
+	short := &mockUploadSession{}
+	shortServer := httptest.NewServer(short.handler(t, &uploadURL))
+	defer shortServer.Close()
+	uploadURL = shortServer.URL + "/upload"
+	o = &OneDriveStorage{client: shortServer.Client(), baseURL: shortServer.URL, chunkSize: 1 << 20}
+	if err := o.WriteSized("/big.bin", &patternReader{size: 5 << 20}, 6<<20, nil); err == nil {
+		t.Error("Expected data shorter than its size to fail")
+	}
+	if !short.cancelled {
+		t.Error("Expected the upload session to be deleted")
+	}
+
+	// So does data running past its size, before the last chunk commits
+	long := &mockUploadSession{}
+	longServer := httptest.NewServer(long.handler(t, &uploadURL))
+	defer longServer.Close()
+	uploadURL = longServer.URL + "/upload"
+	o = &OneDriveStorage{client: longServer.Client(), baseURL: longServer.URL, chunkSize: 1 << 20}
+	if err := o.WriteSized("/big.bin", &patternReader{size: 6<<20 + 1}, 6<<20, nil); !errors.Is(err, errSizeMismatch) {
+		t.Errorf("Expected data longer than its size to fail, got %v", err)
+	}
+	if !long.cancelled || long.stored == 6<<20 {
+		t.Errorf("Expected the upload session to be deleted before completing, stored %d", long.stored)
+	}
+	if err := o.WriteSized("/small.txt", strings.NewReader("exported document"), 0, nil); !errors.Is(err, errSizeMismatch) {
+		t.Errorf("Expected a small file longer than its size to fail, got %v", err)
+	}
+}
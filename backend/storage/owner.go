@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+// ownerNames caches user and group names by ID, as every lookup may read
+// /etc/passwd or ask NSS. IDs without a name are cached as the number.
+var ownerNames = struct {
+	sync.Mutex
+	users  map[uint32]string
+	groups map[uint32]string
+}{users: make(map[uint32]string), groups: make(map[uint32]string)}
+
+// userName returns the name of the user with the given ID, or the ID
+// itself when it has none
+func userName(uid uint32) string {
+	ownerNames.Lock()
+	defer ownerNames.Unlock()
+	if name, ok := ownerNames.users[uid]; ok {
+		return name
+	}
+	name := strconv.FormatUint(uint64(uid), 10)
+	if u, err := user.LookupId(name); err == nil {
+		name = u.Username
+	}
+	ownerNames.users[uid] = name
+	return name
+}
+
+// groupName returns the name of the group with the given ID, or the ID
+// itself when it has none
+func groupName(gid uint32) string {
+	ownerNames.Lock()
+	defer ownerNames.Unlock()
+	if name, ok := ownerNames.groups[gid]; ok {
+		return name
+	}
+	name := strconv.FormatUint(uint64(gid), 10)
+	if g, err := user.LookupGroupId(name); err == nil {
+		name = g.Name
+	}
+	ownerNames.groups[gid] = name
+	return name
+}
+
+// fileOwner returns the user and group owning the file at fullPath. Links
+// are not followed, so a symlink reports its own owner as ls -l does.
+func fileOwner(fullPath string) (owner, group string, err error) {
+	info, err := os.Lstat(fullPath)
+	if err != nil {
+		return "", "", err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", "", nil
+	}
+	return userName(stat.Uid), groupName(stat.Gid), nil
+}
@@ -12,6 +12,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -196,9 +197,14 @@ func (r *RDBStorage) Read(path string) (io.ReadCloser, error) {
 		return nil, fmt.Errorf("cannot read directory: %s", path)
 	}
 
-	// Get file data
+	// Get file data. An empty file may have no data key at all (older
+	// writes and some Redis proxies drop empty strings); that's still a
+	// valid, empty file rather than a missing one.
 	dataKey := r.getDataKey(path)
 	data, err := r.client.Get(r.ctx, dataKey).Result()
+	if err == redis.Nil && meta.Size == 0 {
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to read file data: %w", err)
 	}
@@ -212,23 +218,52 @@ func (r *RDBStorage) Read(path string) (io.ReadCloser, error) {
 	return io.NopCloser(bytes.NewReader(decoded)), nil
 }
 
-// Write writes data to a file
+// rdbWriteChunk is how much of a file Write reads, encodes and appends to
+// Redis at a time. It is a multiple of 3 so the base64 of the chunks joins
+// up without padding in between.
+const rdbWriteChunk = 3 << 20
+
+// Write writes data to a file. The data is appended to a temporary key a
+// chunk at a time and renamed into place at the end, so only one chunk is
+// held in memory and a failed write leaves the old content alone.
 func (r *RDBStorage) Write(path string, data io.Reader) error {
-	// Read all data
-	content, err := io.ReadAll(data)
-	if err != nil {
+	dataKey := r.getDataKey(path)
+	tmpKey := fmt.Sprintf("%s:writing:%d", dataKey, time.Now().UnixNano())
+	if err := r.client.Set(r.ctx, tmpKey, "", 0).Err(); err != nil {
+		return err
+	}
+	discard := func(err error) error {
+		r.client.Del(r.ctx, tmpKey)
 		return err
 	}
 
-	// Check size limit
-	if int64(len(content)) > r.maxSize {
-		return fmt.Errorf("file size exceeds limit (%d bytes > %d bytes)", len(content), r.maxSize)
+	buf := make([]byte, rdbWriteChunk)
+	var size int64
+	for {
+		n, err := io.ReadFull(data, buf)
+		if n > 0 {
+			// Check size limit
+			size += int64(n)
+			if size > r.maxSize {
+				return discard(fmt.Errorf("file size exceeds limit (more than %d bytes)", r.maxSize))
+			}
+			// Store data (encoded as base64 to handle binary)
+			if err := r.client.Append(r.ctx, tmpKey, base64.StdEncoding.EncodeToString(buf[:n])).Err(); err != nil {
+				return discard(err)
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return discard(err)
+		}
 	}
 
 	// Create metadata
 	meta := RDBFileMetadata{
 		Name:    filepath.Base(path),
-		Size:    int64(len(content)),
+		Size:    size,
 		ModTime: time.Now(),
 		IsDir:   false,
 		Mode:    0644,
@@ -237,21 +272,18 @@ func (r *RDBStorage) Write(path string, data io.Reader) error {
 	// Store metadata
 	metaJSON, err := json.Marshal(meta)
 	if err != nil {
-		return err
+		return discard(err)
 	}
 
 	metaKey := r.getKey(path)
 	if err := r.client.Set(r.ctx, metaKey, metaJSON, 0).Err(); err != nil {
-		return err
+		return discard(err)
 	}
 
-	// Store data (encoded as base64 to handle binary)
-	dataKey := r.getDataKey(path)
-	encoded := base64.StdEncoding.EncodeToString(content)
-	if err := r.client.Set(r.ctx, dataKey, encoded, 0).Err(); err != nil {
+	if err := r.client.Rename(r.ctx, tmpKey, dataKey).Err(); err != nil {
 		// Rollback metadata
 		r.client.Del(r.ctx, metaKey)
-		return err
+		return discard(err)
 	}
 
 	// Update parent directory
@@ -552,27 +584,46 @@ func (r *RDBStorage) GetRootPath() string {
 	return "/"
 }
 
-// GetAvailableSpace returns available and total space
+// GetAvailableSpace reports the server's memory from INFO memory: the
+// maxmemory limit, or the host's memory when there is none, less
+// used_memory. It returns -1 for both when the server reports neither.
 func (r *RDBStorage) GetAvailableSpace() (available, total int64, err error) {
-	// Redis doesn't have a traditional file system with space limitations
-	// Return the max size as "total" and estimate available based on current usage
-	var used int64
-	pattern := fmt.Sprintf("%s:data:*", r.namespace)
-	if keys, err := r.client.Keys(r.ctx, pattern).Result(); err == nil {
-		for _, key := range keys {
-			if val, err := r.client.Get(r.ctx, key).Result(); err == nil {
-				// Approximate size (base64 encoded)
-				used += int64(len(val))
-			}
-		}
+	info, err := r.client.Info(r.ctx, "memory").Result()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read Redis memory info: %w", err)
 	}
+	available, total = redisMemorySpace(parseRedisInfo(info))
+	return available, total, nil
+}
 
-	available = r.maxSize - used
-	if available < 0 {
-		available = 0
+// redisMemorySpace works out the space left from the fields of INFO memory
+func redisMemorySpace(fields map[string]string) (available, total int64) {
+	used, err := strconv.ParseInt(fields["used_memory"], 10, 64)
+	if err != nil {
+		return -1, -1
+	}
+	for _, field := range []string{"maxmemory", "total_system_memory"} {
+		if limit, err := strconv.ParseInt(fields[field], 10, 64); err == nil && limit > 0 {
+			return max(limit-used, 0), limit
+		}
 	}
+	return -1, -1
+}
 
-	return available, r.maxSize, nil
+// parseRedisInfo splits the output of INFO into its fields, skipping the
+// section headers
+func parseRedisInfo(info string) map[string]string {
+	fields := make(map[string]string)
+	for _, line := range strings.Split(info, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if key, value, ok := strings.Cut(line, ":"); ok {
+			fields[key] = value
+		}
+	}
+	return fields
 }
 
 // IsValidPath checks if a path is valid
@@ -616,3 +667,8 @@ func (r *RDBStorage) GetInfo() map[string]interface{} {
 
 	return info
 }
+
+// Info reports the Redis server and the namespace's usage, see GetInfo
+func (r *RDBStorage) Info() map[string]interface{} {
+	return r.GetInfo()
+}
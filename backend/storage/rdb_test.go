@@ -0,0 +1,47 @@
+//go:build !basic
+// +build !basic
+
+package storage
+
+import "testing"
+
+func TestRedisMemorySpace(t *testing.T) {
+	for _, tc := range []struct {
+		name             string
+		info             string
+		available, total int64
+	}{
+		{
+			name:      "maxmemory",
+			info:      "# Memory\r\nused_memory:300\r\nused_memory_human:300B\r\nmaxmemory:1000\r\ntotal_system_memory:8000\r\n",
+			available: 700, total: 1000,
+		},
+		{
+			name:      "No maxmemory",
+			info:      "# Memory\r\nused_memory:300\r\nmaxmemory:0\r\ntotal_system_memory:8000\r\n",
+			available: 7700, total: 8000,
+		},
+		{
+			name:      "Over the limit",
+			info:      "used_memory:1200\r\nmaxmemory:1000\r\n",
+			available: 0, total: 1000,
+		},
+		{
+			name:      "Unknown",
+			info:      "used_memory:300\r\nmaxmemory:0\r\n",
+			available: -1, total: -1,
+		},
+		{
+			name:      "No usage",
+			info:      "# Memory\r\n",
+			available: -1, total: -1,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			available, total := redisMemorySpace(parseRedisInfo(tc.info))
+			if available != tc.available || total != tc.total {
+				t.Errorf("Expected %d of %d, got %d of %d", tc.available, tc.total, available, total)
+			}
+		})
+	}
+}
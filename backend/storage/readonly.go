@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// ReadOnlyFileSystem wraps a FileSystem configured read_only, refusing
+// every change to it with ErrReadOnly. Capabilities that write, like
+// Chmoder or ServerSideCopier, are refused here too, since As would
+// otherwise find them on the backend underneath.
+type ReadOnlyFileSystem struct {
+	FileSystem
+	id string
+}
+
+// NewReadOnlyFileSystem wraps fs, naming it id in errors
+func NewReadOnlyFileSystem(id string, fs FileSystem) *ReadOnlyFileSystem {
+	return &ReadOnlyFileSystem{FileSystem: fs, id: id}
+}
+
+// Unwrap returns the wrapped FileSystem
+func (r *ReadOnlyFileSystem) Unwrap() FileSystem {
+	return r.FileSystem
+}
+
+// IsReadOnly always reports true
+func (r *ReadOnlyFileSystem) IsReadOnly() bool {
+	return true
+}
+
+func (r *ReadOnlyFileSystem) refuse() error {
+	return fmt.Errorf("storage %s is configured read-only: %w", r.id, ErrReadOnly)
+}
+
+// Write refuses to write
+func (r *ReadOnlyFileSystem) Write(path string, data io.Reader) error {
+	return r.refuse()
+}
+
+// Delete refuses to delete
+func (r *ReadOnlyFileSystem) Delete(path string) error {
+	return r.refuse()
+}
+
+// MkDir refuses to create directories
+func (r *ReadOnlyFileSystem) MkDir(path string) error {
+	return r.refuse()
+}
+
+// Move refuses to move
+func (r *ReadOnlyFileSystem) Move(src, dst string) error {
+	return r.refuse()
+}
+
+// Copy refuses to copy, as the copy would be written to this storage
+func (r *ReadOnlyFileSystem) Copy(src, dst string, progress ProgressCallback) error {
+	return r.refuse()
+}
+
+// CopyOverwrite refuses to copy
+func (r *ReadOnlyFileSystem) CopyOverwrite(src, dst string, overwrite bool, progress ProgressCallback) error {
+	return r.refuse()
+}
+
+// Chmod refuses to change permissions
+func (r *ReadOnlyFileSystem) Chmod(path string, mode os.FileMode) error {
+	return r.refuse()
+}
+
+// Chown refuses to change ownership
+func (r *ReadOnlyFileSystem) Chown(path string, uid, gid int) error {
+	return r.refuse()
+}
+
+// WriteContentType refuses to write
+func (r *ReadOnlyFileSystem) WriteContentType(path string, data io.Reader, contentType string) error {
+	return r.refuse()
+}
+
+// WriteSized refuses to write
+func (r *ReadOnlyFileSystem) WriteSized(path string, data io.Reader, size int64, progress ProgressCallback) error {
+	return r.refuse()
+}
+
+// WriteConditional refuses to write
+func (r *ReadOnlyFileSystem) WriteConditional(path string, data io.Reader, contentType, ifMatch string) error {
+	return r.refuse()
+}
+
+// DeletePermanently refuses to delete
+func (r *ReadOnlyFileSystem) DeletePermanently(path string) error {
+	return r.refuse()
+}
+
+// CopyFrom refuses server-side copies into this storage
+func (r *ReadOnlyFileSystem) CopyFrom(src FileSystem, srcPath, dstPath string) error {
+	return r.refuse()
+}
+
+// PresignUpload refuses to authorize uploads
+func (r *ReadOnlyFileSystem) PresignUpload(path string, opts PresignOptions) (*PresignedUpload, error) {
+	return nil, r.refuse()
+}
+
+// MultipartPartSize returns the backend's part size, or 0 when it has no
+// multipart uploads; either way StartMultipart refuses them
+func (r *ReadOnlyFileSystem) MultipartPartSize() int64 {
+	if mw, ok := As[MultipartWriter](r.FileSystem); ok {
+		return mw.MultipartPartSize()
+	}
+	return 0
+}
+
+// StartMultipart refuses to start uploads
+func (r *ReadOnlyFileSystem) StartMultipart(path, contentType string) (string, error) {
+	return "", r.refuse()
+}
+
+// WritePart refuses to store parts
+func (r *ReadOnlyFileSystem) WritePart(path, uploadID string, n int, data io.ReadSeeker) (string, error) {
+	return "", r.refuse()
+}
+
+// CompleteMultipart refuses to assemble uploads
+func (r *ReadOnlyFileSystem) CompleteMultipart(path, uploadID string, parts []string) error {
+	return r.refuse()
+}
+
+// AbortMultipart passes through to the backend, so uploads left over from
+// before the storage was made read-only can still be cleaned up
+func (r *ReadOnlyFileSystem) AbortMultipart(path, uploadID string) error {
+	if mw, ok := As[MultipartWriter](r.FileSystem); ok {
+		return mw.AbortMultipart(path, uploadID)
+	}
+	return ErrNotSupported
+}
@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestReadOnlyFileSystem(t *testing.T) {
+	local := NewLocalStorage(t.TempDir())
+	if err := local.Write("/a.txt", strings.NewReader("hello")); err != nil {
+		t.Fatal(err)
+	}
+	mgr := NewManager()
+	mgr.Register("ro", NewReadOnlyFileSystem("ro", local))
+	fs, _ := mgr.Get("ro")
+
+	reader, err := fs.Read("/a.txt")
+	if err != nil {
+		t.Fatalf("Expected reads to work, got %v", err)
+	}
+	data, _ := io.ReadAll(reader)
+	reader.Close()
+	if string(data) != "hello" {
+		t.Errorf("Expected hello, got %q", data)
+	}
+	if _, ok := As[RangeReader](fs); !ok {
+		t.Error("Expected the backend's ranged reads to stay reachable")
+	}
+
+	writes := map[string]error{
+		"write":  fs.Write("/b.txt", strings.NewReader("x")),
+		"delete": fs.Delete("/a.txt"),
+		"mkdir":  fs.MkDir("/dir"),
+		"move":   fs.Move("/a.txt", "/c.txt"),
+		"copy":   fs.Copy("/a.txt", "/c.txt", nil),
+	}
+	if chmoder, ok := As[Chmoder](fs); ok {
+		writes["chmod"] = chmoder.Chmod("/a.txt", 0600)
+	} else {
+		t.Error("Expected Chmod to be refused rather than hidden")
+	}
+	for op, err := range writes {
+		if !errors.Is(err, ErrReadOnly) {
+			t.Errorf("Expected %s to fail with ErrReadOnly, got %v", op, err)
+		}
+	}
+
+	if ro, ok := As[ReadOnlyReporter](fs); !ok || !ro.IsReadOnly() {
+		t.Error("Expected the storage to report it is read-only")
+	}
+	if _, err := os.Stat(local.ResolvePath("/b.txt")); !os.IsNotExist(err) {
+		t.Error("Expected nothing to be written to the backend")
+	}
+	if _, err := local.Stat("/a.txt"); err != nil {
+		t.Errorf("Expected the file to be left alone, got %v", err)
+	}
+}
@@ -3,12 +3,14 @@ package storage
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"mime"
 	"path"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -16,17 +18,91 @@ import (
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
 )
 
+// s3API is the subset of the S3 client used by S3Storage
+type s3API interface {
+	s3.ListObjectsV2APIClient
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+	CopyObject(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error)
+	DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
+	DeleteObjects(ctx context.Context, params *s3.DeleteObjectsInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error)
+	GetObjectRetention(ctx context.Context, params *s3.GetObjectRetentionInput, optFns ...func(*s3.Options)) (*s3.GetObjectRetentionOutput, error)
+	GetObjectLegalHold(ctx context.Context, params *s3.GetObjectLegalHoldInput, optFns ...func(*s3.Options)) (*s3.GetObjectLegalHoldOutput, error)
+	CreateMultipartUpload(ctx context.Context, params *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error)
+	UploadPart(ctx context.Context, params *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error)
+	CompleteMultipartUpload(ctx context.Context, params *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error)
+	AbortMultipartUpload(ctx context.Context, params *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error)
+	s3.ListMultipartUploadsAPIClient
+	s3.ListPartsAPIClient
+}
+
+// s3PartSize is the part size for multipart uploads. S3 needs at least
+// 5MB for every part but the last.
+const s3PartSize = 8 << 20
+
+// s3PostPresigner is the part of s3.PresignClient used for presigned uploads
+type s3PostPresigner interface {
+	PresignPostObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.PresignPostOptions)) (*s3.PresignedPostRequest, error)
+}
+
 // S3Storage implements the Storage interface for Amazon S3
 type S3Storage struct {
-	client    *s3.Client
+	client    s3API
+	presigner s3PostPresigner
 	bucket    string
 	region    string
 	prefix    string
 	accessKey string
 	secretKey string
 	endpoint  string // For S3-compatible services
+
+	// bypassGovernance allows deleting objects under governance-mode
+	// retention. The credentials must hold s3:BypassGovernanceRetention.
+	bypassGovernance bool
+
+	// caseInsensitive makes lookups fall back to a case-insensitive match
+	// for stores that treat keys that way
+	caseInsensitive bool
+
+	// contentTypes holds configured per-extension Content-Type overrides
+	contentTypes map[string]string
+
+	// reportUsage adds up the objects under the prefix as used space, out
+	// of quota bytes when that isn't zero. The sum is kept for
+	// s3UsageTTL, as every listing asks for the space.
+	reportUsage bool
+	quota       int64
+	usageMu     sync.Mutex
+	usage       int64
+	usageAt     time.Time
+}
+
+// s3UsageTTL is how long the sum of a bucket's objects is reused before
+// they are listed again
+const s3UsageTTL = 5 * time.Minute
+
+// ObjectLockedError is returned when an object cannot be deleted because
+// it is protected by S3 Object Lock
+type ObjectLockedError struct {
+	Path        string
+	Mode        string    // "GOVERNANCE" or "COMPLIANCE", empty for legal hold only
+	RetainUntil time.Time // zero when only a legal hold applies
+	LegalHold   bool
+}
+
+func (e *ObjectLockedError) Error() string {
+	if e.LegalHold && e.RetainUntil.IsZero() {
+		return fmt.Sprintf("object %s is under legal hold and cannot be deleted", e.Path)
+	}
+	msg := fmt.Sprintf("object %s is locked (%s mode) until %s", e.Path, strings.ToLower(e.Mode), e.RetainUntil.UTC().Format(time.RFC3339))
+	if e.LegalHold {
+		msg += " and is under legal hold"
+	}
+	return msg
 }
 
 // NewS3Storage creates a new S3 storage instance
@@ -66,6 +142,7 @@ func NewS3Storage(bucket, region, prefix, accessKey, secretKey, endpoint string)
 
 	return &S3Storage{
 		client:    client,
+		presigner: s3.NewPresignClient(client),
 		bucket:    bucket,
 		region:    region,
 		prefix:    prefix,
@@ -75,6 +152,89 @@ func NewS3Storage(bucket, region, prefix, accessKey, secretKey, endpoint string)
 	}, nil
 }
 
+// SetBypassGovernance enables deletes that bypass governance-mode retention
+func (s *S3Storage) SetBypassGovernance(bypass bool) {
+	s.bypassGovernance = bypass
+}
+
+// Info reports the bucket and how the storage uses it
+func (s *S3Storage) Info() map[string]interface{} {
+	info := map[string]interface{}{
+		"bucket":           s.bucket,
+		"region":           s.region,
+		"prefix":           s.prefix,
+		"bypassGovernance": s.bypassGovernance,
+		"caseInsensitive":  s.caseInsensitive,
+		"reportUsage":      s.reportUsage,
+	}
+	if s.quota > 0 {
+		info["quota"] = s.quota
+	}
+	if s.endpoint != "" {
+		info["endpoint"] = s.endpoint
+	}
+	return info
+}
+
+// SetReportUsage makes the storage add up its objects to report used
+// space, against quota bytes when that isn't zero
+func (s *S3Storage) SetReportUsage(enabled bool, quota int64) {
+	s.reportUsage = enabled
+	s.quota = quota
+}
+
+// Usage returns the total size of the objects under the prefix, listing
+// them at most once every s3UsageTTL. Without report_usage it returns
+// ErrNotSupported rather than list a whole bucket unasked.
+func (s *S3Storage) Usage() (int64, error) {
+	if !s.reportUsage {
+		return 0, ErrNotSupported
+	}
+
+	s.usageMu.Lock()
+	defer s.usageMu.Unlock()
+	if !s.usageAt.IsZero() && time.Since(s.usageAt) < s3UsageTTL {
+		return s.usage, nil
+	}
+
+	prefix := s.getFullPath("")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	var used int64
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(context.Background())
+		if err != nil {
+			return 0, fmt.Errorf("failed to list objects: %w", err)
+		}
+		for _, obj := range output.Contents {
+			used += aws.ToInt64(obj.Size)
+		}
+	}
+
+	s.usage = used
+	s.usageAt = time.Now()
+	return used, nil
+}
+
+// SetCaseInsensitive enables case-insensitive path lookups
+func (s *S3Storage) SetCaseInsensitive(enabled bool) {
+	s.caseInsensitive = enabled
+}
+
+// PathConventions reports that keys are case-sensitive unless lookups fall
+// back to other cases, and that only "/" has a meaning in them
+func (s *S3Storage) PathConventions() PathConventions {
+	conventions := unixPathConventions
+	conventions.CaseSensitive = !s.caseInsensitive
+	conventions.ReservedChars = "/"
+	return conventions
+}
+
 // GetType returns the storage type
 func (s *S3Storage) GetType() string {
 	return "s3"
@@ -88,7 +248,7 @@ func (s *S3Storage) GetID() string {
 // List lists files and directories at the given path
 func (s *S3Storage) List(dirPath string) ([]FileInfo, error) {
 	// Normalize path
-	fullPath := s.getFullPath(dirPath)
+	fullPath := s.getFullPath(s.resolveCase(dirPath))
 	if fullPath != "" && !strings.HasSuffix(fullPath, "/") {
 		fullPath += "/"
 	}
@@ -109,22 +269,26 @@ func (s *S3Storage) List(dirPath string) ([]FileInfo, error) {
 			return nil, fmt.Errorf("failed to list objects: %w", err)
 		}
 
-		// Add directories (common prefixes)
+		// Add directories (common prefixes). S3 has no modification time
+		// for a prefix, so it is left zero rather than made up.
 		for _, prefix := range output.CommonPrefixes {
 			name := strings.TrimSuffix(strings.TrimPrefix(*prefix.Prefix, fullPath), "/")
+			if name == "" {
+				continue
+			}
 			files = append(files, FileInfo{
-				Name:    name,
-				Path:    "/" + strings.TrimPrefix(*prefix.Prefix, s.prefix),
-				IsDir:   true,
-				Size:    0,
-				ModTime: time.Now(),
+				Name:  name,
+				Path:  s.keyPath(*prefix.Prefix),
+				IsDir: true,
+				Size:  0,
 			})
 		}
 
 		// Add files
 		for _, obj := range output.Contents {
-			// Skip the directory marker itself
-			if *obj.Key == fullPath {
+			// Skip directory markers, this directory's own included, as
+			// Delete and GetInfo do
+			if isDirectoryMarker(*obj.Key) {
 				continue
 			}
 
@@ -136,7 +300,7 @@ func (s *S3Storage) List(dirPath string) ([]FileInfo, error) {
 
 			files = append(files, FileInfo{
 				Name:    name,
-				Path:    "/" + strings.TrimPrefix(*obj.Key, s.prefix),
+				Path:    s.keyPath(*obj.Key),
 				IsDir:   false,
 				Size:    *obj.Size,
 				ModTime: *obj.LastModified,
@@ -147,9 +311,60 @@ func (s *S3Storage) List(dirPath string) ([]FileInfo, error) {
 	return files, nil
 }
 
-// Read reads the content of a file
-func (s *S3Storage) Read(filePath string) ([]byte, error) {
-	fullPath := s.getFullPath(filePath)
+// ListDirs calls fn for each subdirectory of dirPath: the common prefixes
+// of a delimited listing. The objects S3 returns alongside are ignored.
+func (s *S3Storage) ListDirs(dirPath string, fn func(FileInfo) error) error {
+	fullPath := s.getFullPath(s.resolveCase(dirPath))
+	if fullPath != "" && !strings.HasSuffix(fullPath, "/") {
+		fullPath += "/"
+	}
+
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(s.bucket),
+		Prefix:    aws.String(fullPath),
+		Delimiter: aws.String("/"),
+	})
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(context.Background())
+		if err != nil {
+			return fmt.Errorf("failed to list objects: %w", err)
+		}
+		for _, prefix := range output.CommonPrefixes {
+			name := strings.TrimSuffix(strings.TrimPrefix(*prefix.Prefix, fullPath), "/")
+			if name == "" {
+				continue
+			}
+			err := fn(FileInfo{
+				Name:  name,
+				Path:  s.keyPath(*prefix.Prefix),
+				IsDir: true,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// s3DirectoryContentType marks the zero-byte objects that stand in for
+// directories
+const s3DirectoryContentType = "application/x-directory"
+
+// isDirectoryMarker reports whether key names a directory marker rather
+// than a file. Markers are zero-byte objects like empty files, so the
+// trailing slash is the only reliable difference.
+func isDirectoryMarker(key string) bool {
+	return strings.HasSuffix(key, "/")
+}
+
+// Read opens a file for reading. The object is streamed from S3 as the
+// caller reads, so the caller must close it.
+func (s *S3Storage) Read(filePath string) (io.ReadCloser, error) {
+	fullPath := s.getFullPath(s.resolveCase(filePath))
+	if isDirectoryMarker(fullPath) {
+		return nil, fmt.Errorf("cannot read directory: %s", filePath)
+	}
 
 	ctx := context.Background()
 	result, err := s.client.GetObject(ctx, &s3.GetObjectInput{
@@ -159,27 +374,64 @@ func (s *S3Storage) Read(filePath string) ([]byte, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to read file: %w", err)
 	}
-	defer func() {
-		if err := result.Body.Close(); err != nil {
-			log.Printf("Error closing result body: %v", err)
-		}
-	}()
 
-	return io.ReadAll(result.Body)
+	return result.Body, nil
+}
+
+// ReadRange fetches length bytes of an object from offset with a ranged
+// GetObject
+func (s *S3Storage) ReadRange(filePath string, offset, length int64) (io.ReadCloser, error) {
+	fullPath := s.getFullPath(s.resolveCase(filePath))
+	if isDirectoryMarker(fullPath) {
+		return nil, fmt.Errorf("cannot read directory: %s", filePath)
+	}
+	if length <= 0 {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+
+	result, err := s.client.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(fullPath),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	return result.Body, nil
 }
 
 // Write writes content to a file
 func (s *S3Storage) Write(filePath string, content []byte) error {
+	return s.WriteContentType(filePath, content, "")
+}
+
+// WriteContentType writes content to a file with an explicit Content-Type.
+// An empty contentType picks one from the file extension.
+func (s *S3Storage) WriteContentType(filePath string, content []byte, contentType string) error {
+	return s.putObject(filePath, content, contentType, nil)
+}
+
+// putObject writes content to filePath. A non-nil condition adds request
+// headers that make the write conditional.
+func (s *S3Storage) putObject(filePath string, content []byte, contentType string, condition func(*s3.PutObjectInput)) error {
 	fullPath := s.getFullPath(filePath)
+	if fullPath == "" || isDirectoryMarker(fullPath) {
+		return fmt.Errorf("cannot write a file at directory path: %s", filePath)
+	}
+	if contentType == "" {
+		contentType = s.getContentType(filePath)
+	}
 
-	ctx := context.Background()
-	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+	input := &s3.PutObjectInput{
 		Bucket:      aws.String(s.bucket),
 		Key:         aws.String(fullPath),
 		Body:        bytes.NewReader(content),
-		ContentType: aws.String(s.getContentType(filePath)),
-	})
-	if err != nil {
+		ContentType: aws.String(contentType),
+	}
+	if condition != nil {
+		condition(input)
+	}
+	if _, err := s.client.PutObject(context.Background(), input); err != nil {
 		return fmt.Errorf("failed to write file: %w", err)
 	}
 
@@ -196,9 +448,10 @@ func (s *S3Storage) CreateDirectory(dirPath string) error {
 	// In S3, directories are virtual, but we can create a marker
 	ctx := context.Background()
 	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
-		Bucket: aws.String(s.bucket),
-		Key:    aws.String(fullPath),
-		Body:   bytes.NewReader([]byte{}),
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(fullPath),
+		Body:        bytes.NewReader([]byte{}),
+		ContentType: aws.String(s3DirectoryContentType),
 	})
 	if err != nil {
 		return fmt.Errorf("failed to create directory: %w", err)
@@ -209,7 +462,7 @@ func (s *S3Storage) CreateDirectory(dirPath string) error {
 
 // Delete deletes a file or directory
 func (s *S3Storage) Delete(filePath string) error {
-	fullPath := s.getFullPath(filePath)
+	fullPath := s.getFullPath(s.resolveCase(filePath))
 
 	// Check if it's a directory
 	isDir := false
@@ -259,24 +512,37 @@ func (s *S3Storage) Delete(filePath string) error {
 					end = len(objectsToDelete)
 				}
 
-				_, err := s.client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+				output, err := s.client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
 					Bucket: aws.String(s.bucket),
 					Delete: &types.Delete{
 						Objects: objectsToDelete[i:end],
 						Quiet:   aws.Bool(true),
 					},
+					BypassGovernanceRetention: s.bypassParam(),
 				})
 				if err != nil {
 					return fmt.Errorf("failed to delete objects: %w", err)
 				}
+				if len(output.Errors) > 0 {
+					failed := output.Errors[0]
+					key := aws.ToString(failed.Key)
+					if lockErr := s.checkObjectLock(ctx, key); lockErr != nil {
+						return lockErr
+					}
+					return fmt.Errorf("failed to delete %d objects, first %s: %s", len(output.Errors), key, aws.ToString(failed.Message))
+				}
 			}
 		}
 	} else {
-		// Delete single file
+		// Delete single file, refusing early if Object Lock protects it
 		ctx := context.Background()
+		if lockErr := s.checkObjectLock(ctx, fullPath); lockErr != nil {
+			return lockErr
+		}
 		_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
-			Bucket: aws.String(s.bucket),
-			Key:    aws.String(fullPath),
+			Bucket:                    aws.String(s.bucket),
+			Key:                       aws.String(fullPath),
+			BypassGovernanceRetention: s.bypassParam(),
 		})
 		if err != nil {
 			return fmt.Errorf("failed to delete file: %w", err)
@@ -286,18 +552,66 @@ func (s *S3Storage) Delete(filePath string) error {
 	return nil
 }
 
+// checkObjectLock returns an *ObjectLockedError if the object is protected by
+// an active retention period or legal hold. Buckets without Object Lock
+// answer these calls with an error, which is treated as "not locked".
+func (s *S3Storage) checkObjectLock(ctx context.Context, key string) error {
+	lockErr := &ObjectLockedError{Path: s.keyPath(key)}
+	locked := false
+
+	retention, err := s.client.GetObjectRetention(ctx, &s3.GetObjectRetentionInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err == nil && retention.Retention != nil && retention.Retention.RetainUntilDate != nil {
+		until := *retention.Retention.RetainUntilDate
+		mode := retention.Retention.Mode
+		bypassed := mode == types.ObjectLockRetentionModeGovernance && s.bypassGovernance
+		if until.After(time.Now()) && !bypassed {
+			lockErr.Mode = string(mode)
+			lockErr.RetainUntil = until
+			locked = true
+		}
+	}
+
+	hold, err := s.client.GetObjectLegalHold(ctx, &s3.GetObjectLegalHoldInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err == nil && hold.LegalHold != nil && hold.LegalHold.Status == types.ObjectLockLegalHoldStatusOn {
+		lockErr.LegalHold = true
+		locked = true
+	}
+
+	if locked {
+		return lockErr
+	}
+	return nil
+}
+
+// bypassParam returns the BypassGovernanceRetention value for delete requests
+func (s *S3Storage) bypassParam() *bool {
+	if s.bypassGovernance {
+		return aws.Bool(true)
+	}
+	return nil
+}
+
 // Copy copies a file
 func (s *S3Storage) Copy(srcPath, dstPath string) error {
-	srcFullPath := s.getFullPath(srcPath)
-	dstFullPath := s.getFullPath(dstPath)
+	return s.copyObject(s.bucket, s.getFullPath(s.resolveCase(srcPath)), s.getFullPath(dstPath))
+}
 
+// copyObject copies srcKey in srcBucket, which may be another bucket, to
+// dstKey in this storage's bucket
+func (s *S3Storage) copyObject(srcBucket, srcKey, dstKey string) error {
 	ctx := context.Background()
-	copySource := fmt.Sprintf("%s/%s", s.bucket, srcFullPath)
+	copySource := fmt.Sprintf("%s/%s", srcBucket, srcKey)
 
 	_, err := s.client.CopyObject(ctx, &s3.CopyObjectInput{
 		Bucket:     aws.String(s.bucket),
 		CopySource: aws.String(copySource),
-		Key:        aws.String(dstFullPath),
+		Key:        aws.String(dstKey),
 	})
 	if err != nil {
 		return fmt.Errorf("failed to copy file: %w", err)
@@ -306,10 +620,19 @@ func (s *S3Storage) Copy(srcPath, dstPath string) error {
 	return nil
 }
 
+// sameAccount reports whether other is reached through the same endpoint
+// with the same credentials, so one can copy objects from the other
+func (s *S3Storage) sameAccount(other *S3Storage) bool {
+	return s.endpoint == other.endpoint && s.accessKey == other.accessKey && s.secretKey == other.secretKey
+}
+
 // Move moves a file
 func (s *S3Storage) Move(srcPath, dstPath string) error {
 	// Copy first
 	if err := s.Copy(srcPath, dstPath); err != nil {
+		if isCopySizeLimit(err) {
+			return fmt.Errorf("%w: %v", ErrNativeMoveFailed, err)
+		}
 		return err
 	}
 
@@ -317,9 +640,291 @@ func (s *S3Storage) Move(srcPath, dstPath string) error {
 	return s.Delete(srcPath)
 }
 
+// MoveFallback lets Move stream objects that CopyObject refuses
+func (s *S3Storage) MoveFallback() bool {
+	return true
+}
+
+// isCopySizeLimit reports whether CopyObject failed because the source is
+// over the 5GB a single server-side copy allows
+func isCopySizeLimit(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.ErrorCode() {
+	case "EntityTooLarge":
+		return true
+	case "InvalidRequest":
+		return strings.Contains(apiErr.ErrorMessage(), "copy source is larger than the maximum")
+	}
+	return false
+}
+
+// isPreconditionFailed reports whether a conditional write was refused
+// because the object changed. A missing object fails If-Match with
+// NoSuchKey rather than PreconditionFailed.
+func isPreconditionFailed(err error, ifMatch string) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.ErrorCode() {
+	case "PreconditionFailed", "ConditionalRequestConflict":
+		return true
+	case "NoSuchKey", "NotFound":
+		return ifMatch != ""
+	}
+	return false
+}
+
+// PresignUpload returns a presigned POST for uploading filePath directly
+// to the bucket. The policy pins the key and, when set in opts, the size
+// range and Content-Type, so the form can't be reused for anything else.
+func (s *S3Storage) PresignUpload(filePath string, opts PresignOptions) (*PresignedUpload, error) {
+	if s.presigner == nil {
+		return nil, ErrNotSupported
+	}
+	if path.Clean("/"+filePath) == "/" || strings.HasSuffix(filePath, "/") {
+		return nil, fmt.Errorf("cannot upload a file at directory path: %s", filePath)
+	}
+	fullPath := s.getFullPath(filePath)
+
+	var conditions []interface{}
+	if opts.MaxSize > 0 {
+		conditions = append(conditions, []interface{}{"content-length-range", 0, opts.MaxSize})
+	}
+	exactType := ""
+	if prefix, ok := strings.CutSuffix(opts.ContentType, "*"); ok {
+		conditions = append(conditions, []interface{}{"starts-with", "$Content-Type", prefix})
+	} else if opts.ContentType != "" {
+		exactType = opts.ContentType
+		conditions = append(conditions, map[string]string{"Content-Type": exactType})
+	}
+
+	expires := time.Now().Add(opts.Expires)
+	req, err := s.presigner.PresignPostObject(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(fullPath),
+	}, func(o *s3.PresignPostOptions) {
+		o.Expires = opts.Expires
+		o.Conditions = conditions
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to presign upload: %w", err)
+	}
+
+	if exactType != "" {
+		req.Values["Content-Type"] = exactType
+	}
+	return &PresignedUpload{URL: req.URL, Fields: req.Values, Expires: expires}, nil
+}
+
+// MultipartPartSize returns the size of each multipart upload part
+func (s *S3Storage) MultipartPartSize() int64 {
+	return s3PartSize
+}
+
+// StartMultipart creates a multipart upload for filePath. Parts stored
+// under it are billed until the upload is completed or aborted.
+func (s *S3Storage) StartMultipart(filePath, contentType string) (string, error) {
+	fullPath := s.getFullPath(filePath)
+	if fullPath == "" || isDirectoryMarker(fullPath) {
+		return "", fmt.Errorf("cannot write a file at directory path: %s", filePath)
+	}
+	if contentType == "" {
+		contentType = s.getContentType(filePath)
+	}
+
+	out, err := s.client.CreateMultipartUpload(context.Background(), &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(fullPath),
+		ContentType: aws.String(contentType),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to start multipart upload: %w", err)
+	}
+	return aws.ToString(out.UploadId), nil
+}
+
+// WritePart uploads part n of a multipart upload and returns its ETag
+func (s *S3Storage) WritePart(filePath, uploadID string, n int, data io.ReadSeeker) (string, error) {
+	out, err := s.client.UploadPart(context.Background(), &s3.UploadPartInput{
+		Bucket:     aws.String(s.bucket),
+		Key:        aws.String(s.getFullPath(filePath)),
+		UploadId:   aws.String(uploadID),
+		PartNumber: aws.Int32(int32(n)),
+		Body:       data,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to upload part %d: %w", n, err)
+	}
+	return aws.ToString(out.ETag), nil
+}
+
+// CompleteMultipart assembles the parts with the given ETags into the object
+func (s *S3Storage) CompleteMultipart(filePath, uploadID string, parts []string) error {
+	completed := make([]types.CompletedPart, len(parts))
+	for i, etag := range parts {
+		completed[i] = types.CompletedPart{ETag: aws.String(etag), PartNumber: aws.Int32(int32(i + 1))}
+	}
+
+	_, err := s.client.CompleteMultipartUpload(context.Background(), &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.bucket),
+		Key:             aws.String(s.getFullPath(filePath)),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to complete multipart upload: %w", err)
+	}
+	return nil
+}
+
+// AbortMultipart aborts a multipart upload, deleting its stored parts
+func (s *S3Storage) AbortMultipart(filePath, uploadID string) error {
+	_, err := s.client.AbortMultipartUpload(context.Background(), &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(s.getFullPath(filePath)),
+		UploadId: aws.String(uploadID),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to abort multipart upload: %w", err)
+	}
+	return nil
+}
+
+// ListMultipart returns the unfinished multipart uploads under the
+// storage's prefix, with the size of the parts each holds so far
+func (s *S3Storage) ListMultipart() ([]MultipartUpload, error) {
+	prefix := ""
+	if s.prefix != "" {
+		prefix = strings.TrimSuffix(s.prefix, "/") + "/"
+	}
+
+	ctx := context.Background()
+	var uploads []MultipartUpload
+	paginator := s3.NewListMultipartUploadsPaginator(s.client, &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list multipart uploads: %w", err)
+		}
+		for _, upload := range output.Uploads {
+			key := aws.ToString(upload.Key)
+			size, err := s.multipartSize(ctx, key, aws.ToString(upload.UploadId))
+			if err != nil {
+				return nil, err
+			}
+			uploads = append(uploads, MultipartUpload{
+				Path:     path.Clean("/" + strings.TrimPrefix(key, prefix)),
+				UploadID: aws.ToString(upload.UploadId),
+				Started:  aws.ToTime(upload.Initiated),
+				Size:     size,
+			})
+		}
+	}
+	return uploads, nil
+}
+
+// multipartSize adds up the parts stored for a multipart upload
+func (s *S3Storage) multipartSize(ctx context.Context, key, uploadID string) (int64, error) {
+	var size int64
+	paginator := s3.NewListPartsPaginator(s.client, &s3.ListPartsInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	})
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("failed to list parts of %s: %w", key, err)
+		}
+		for _, part := range output.Parts {
+			size += aws.ToInt64(part.Size)
+		}
+	}
+	return size, nil
+}
+
+// ETag returns the object's ETag without quotes. For objects uploaded in
+// a single part it is the hex MD5 of the content.
+func (s *S3Storage) ETag(filePath string) (string, error) {
+	fullPath := s.getFullPath(s.resolveCase(filePath))
+
+	result, err := s.client.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(fullPath),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to get object metadata: %w", err)
+	}
+	return strings.Trim(aws.ToString(result.ETag), `"`), nil
+}
+
+// md5ETagPattern matches ETags that are a plain MD5 of the content
+var md5ETagPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// ContentHashes returns the MD5 of the objects in a directory, taken from
+// the ETags of a listing. Only plain MD5 ETags are used: multipart uploads
+// have "-<parts>" ones and are left out.
+func (s *S3Storage) ContentHashes(dirPath, algo string) (map[string]string, error) {
+	if algo != "md5" {
+		return nil, ErrNotSupported
+	}
+	fullPath := s.getFullPath(s.resolveCase(dirPath))
+	if fullPath != "" && !strings.HasSuffix(fullPath, "/") {
+		fullPath += "/"
+	}
+
+	hashes := make(map[string]string)
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(s.bucket),
+		Prefix:    aws.String(fullPath),
+		Delimiter: aws.String("/"),
+	})
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects: %w", err)
+		}
+		for _, obj := range output.Contents {
+			name := strings.TrimPrefix(aws.ToString(obj.Key), fullPath)
+			etag := strings.ToLower(strings.Trim(aws.ToString(obj.ETag), `"`))
+			if name != "" && !strings.Contains(name, "/") && md5ETagPattern.MatchString(etag) {
+				hashes[name] = etag
+			}
+		}
+	}
+	return hashes, nil
+}
+
+// NativeID returns the object's version ID. Buckets without versioning
+// have no stable identifier besides the key, so ErrNotSupported is
+// returned for them.
+func (s *S3Storage) NativeID(filePath string) (string, error) {
+	fullPath := s.getFullPath(s.resolveCase(filePath))
+
+	result, err := s.client.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(fullPath),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to get object metadata: %w", err)
+	}
+	versionID := aws.ToString(result.VersionId)
+	if versionID == "" || versionID == "null" {
+		return "", ErrNotSupported
+	}
+	return versionID, nil
+}
+
 // Exists checks if a file or directory exists
 func (s *S3Storage) Exists(filePath string) (bool, error) {
-	fullPath := s.getFullPath(filePath)
+	fullPath := s.getFullPath(s.resolveCase(filePath))
 
 	ctx := context.Background()
 
@@ -351,16 +956,20 @@ func (s *S3Storage) Exists(filePath string) (bool, error) {
 
 // GetInfo gets information about a file or directory
 func (s *S3Storage) GetInfo(filePath string) (*FileInfo, error) {
-	fullPath := s.getFullPath(filePath)
+	fullPath := s.getFullPath(s.resolveCase(filePath))
 
 	ctx := context.Background()
 
-	// Try as file first
-	headResult, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
-		Bucket: aws.String(s.bucket),
-		Key:    aws.String(fullPath),
-	})
-	if err == nil {
+	// Try as file first, unless the path names a directory marker
+	var headResult *s3.HeadObjectOutput
+	var err error
+	if !isDirectoryMarker(fullPath) {
+		headResult, err = s.client.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(fullPath),
+		})
+	}
+	if headResult != nil && err == nil {
 		return &FileInfo{
 			Name:    path.Base(filePath),
 			Path:    filePath,
@@ -386,93 +995,151 @@ func (s *S3Storage) GetInfo(filePath string) (*FileInfo, error) {
 
 	if len(result.Contents) > 0 || len(result.CommonPrefixes) > 0 {
 		return &FileInfo{
-			Name:    path.Base(strings.TrimSuffix(filePath, "/")),
-			Path:    filePath,
-			IsDir:   true,
-			Size:    0,
-			ModTime: time.Now(),
+			Name:  path.Base(strings.TrimSuffix(filePath, "/")),
+			Path:  filePath,
+			IsDir: true,
+			Size:  0,
 		}, nil
 	}
 
 	return nil, fmt.Errorf("file not found: %s", filePath)
 }
 
-// Search searches for files matching a pattern
-func (s *S3Storage) Search(dirPath, pattern string, caseSensitive, isRegex bool) ([]FileInfo, error) {
-	// List all files recursively
-	allFiles, err := s.listRecursive(dirPath)
-	if err != nil {
-		return nil, err
-	}
+// Helper functions
 
-	// Filter based on pattern
-	matcher, err := createMatcher(pattern, caseSensitive, isRegex)
-	if err != nil {
-		return nil, err
-	}
+func (s *S3Storage) getFullPath(p string) string {
+	// Remove leading slash
+	p = strings.TrimPrefix(p, "/")
 
-	var results []FileInfo
-	for _, file := range allFiles {
-		if matcher(file.Name) {
-			results = append(results, file)
+	// Combine with prefix
+	if s.prefix != "" {
+		if p != "" {
+			return path.Join(s.prefix, p)
 		}
+		return s.prefix
 	}
+	return p
+}
 
-	return results, nil
+// keyPath returns the storage path of an object key or common prefix: the
+// key without the storage prefix, with a single leading slash and no
+// trailing one
+func (s *S3Storage) keyPath(key string) string {
+	if s.prefix != "" {
+		key = strings.TrimPrefix(key, strings.TrimSuffix(s.prefix, "/")+"/")
+	}
+	return path.Join("/", key)
 }
 
-// Helper functions
+// resolveCase returns p with each segment replaced by the real-cased name
+// stored in the bucket. It is a no-op unless case-insensitive matching is
+// enabled or when p already exists as given. Segments that cannot be
+// matched are kept unchanged so the caller reports the usual not-found error.
+func (s *S3Storage) resolveCase(p string) string {
+	if !s.caseInsensitive {
+		return p
+	}
+
+	ctx := context.Background()
+	if _, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.getFullPath(p)),
+	}); err == nil {
+		return p
+	}
 
-// createMatcher creates a function to match file names based on pattern
-func createMatcher(pattern string, caseSensitive, isRegex bool) (func(string) bool, error) {
-	if isRegex {
-		flags := ""
-		if !caseSensitive {
-			flags = "(?i)"
+	resolved := ""
+	segments := strings.Split(strings.Trim(p, "/"), "/")
+	for i, segment := range segments {
+		if segment == "" {
+			continue
 		}
-		re, err := regexp.Compile(flags + pattern)
-		if err != nil {
-			return nil, fmt.Errorf("invalid regex pattern: %w", err)
+
+		name, err := s.findChildName(ctx, resolved, segment)
+		if err != nil || name == "" {
+			rest := strings.Join(segments[i:], "/")
+			return "/" + path.Join(resolved, rest)
 		}
-		return func(name string) bool {
-			return re.MatchString(name)
-		}, nil
+		resolved = path.Join(resolved, name)
 	}
 
-	// Simple pattern matching
-	if !caseSensitive {
-		pattern = strings.ToLower(pattern)
-		return func(name string) bool {
-			return strings.Contains(strings.ToLower(name), pattern)
-		}, nil
-	}
-	return func(name string) bool {
-		return strings.Contains(name, pattern)
-	}, nil
+	return "/" + resolved
 }
 
-func (s *S3Storage) getFullPath(p string) string {
-	// Remove leading slash
-	p = strings.TrimPrefix(p, "/")
+// findChildName lists the entries directly under dir and returns the one
+// matching name, preferring an exact match over a case-insensitive one
+func (s *S3Storage) findChildName(ctx context.Context, dir, name string) (string, error) {
+	listPrefix := s.getFullPath(dir)
+	if listPrefix != "" && !strings.HasSuffix(listPrefix, "/") {
+		listPrefix += "/"
+	}
 
-	// Combine with prefix
-	if s.prefix != "" {
-		if p != "" {
-			return path.Join(s.prefix, p)
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(s.bucket),
+		Prefix:    aws.String(listPrefix),
+		Delimiter: aws.String("/"),
+	})
+
+	match := ""
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return "", err
+		}
+
+		candidates := make([]string, 0, len(output.CommonPrefixes)+len(output.Contents))
+		for _, prefix := range output.CommonPrefixes {
+			candidates = append(candidates, strings.TrimSuffix(strings.TrimPrefix(aws.ToString(prefix.Prefix), listPrefix), "/"))
+		}
+		for _, obj := range output.Contents {
+			candidates = append(candidates, strings.TrimPrefix(aws.ToString(obj.Key), listPrefix))
+		}
+
+		for _, candidate := range candidates {
+			if candidate == name {
+				return candidate, nil
+			}
+			if match == "" && strings.EqualFold(candidate, name) {
+				match = candidate
+			}
 		}
-		return s.prefix
 	}
-	return p
+
+	return match, nil
 }
 
-func (s *S3Storage) listRecursive(dirPath string) ([]FileInfo, error) {
-	fullPath := s.getFullPath(dirPath)
+// Walk implements Walker with a single flat listing of every key under
+// root. Directories, which S3 only has as key prefixes and markers, are
+// reported the first time a key below them is seen.
+func (s *S3Storage) Walk(root string, fn func(FileInfo) error) error {
+	fullPath := s.getFullPath(s.resolveCase(root))
 	if fullPath != "" && !strings.HasSuffix(fullPath, "/") {
 		fullPath += "/"
 	}
 
 	ctx := context.Background()
-	var files []FileInfo
+	seen := make(map[string]bool)
+	skipped := make(map[string]bool)
+
+	// visit reports rel unless it or a directory above it was skipped
+	visit := func(rel string, info FileInfo) error {
+		for dir := path.Dir(rel); dir != "."; dir = path.Dir(dir) {
+			if skipped[dir] {
+				return nil
+			}
+		}
+		info.Name = path.Base(rel)
+		info.Path = path.Join(root, rel)
+		if err := fn(info); err != nil {
+			if err != SkipDir {
+				return err
+			}
+			if info.IsDir {
+				skipped[rel] = true
+			}
+		}
+		return nil
+	}
 
 	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
 		Bucket: aws.String(s.bucket),
@@ -482,65 +1149,154 @@ func (s *S3Storage) listRecursive(dirPath string) ([]FileInfo, error) {
 	for paginator.HasMorePages() {
 		output, err := paginator.NextPage(ctx)
 		if err != nil {
-			return nil, fmt.Errorf("failed to list objects: %w", err)
+			return fmt.Errorf("failed to list objects: %w", err)
 		}
 
 		for _, obj := range output.Contents {
-			// Skip directory markers
-			if strings.HasSuffix(*obj.Key, "/") {
+			rel := strings.TrimPrefix(aws.ToString(obj.Key), fullPath)
+			if rel == "" {
 				continue
 			}
 
-			relativePath := strings.TrimPrefix(*obj.Key, s.prefix)
-			files = append(files, FileInfo{
-				Name:    path.Base(*obj.Key),
-				Path:    "/" + relativePath,
-				IsDir:   false,
-				Size:    *obj.Size,
-				ModTime: *obj.LastModified,
-			})
+			// Report the directories leading to this key, outermost first
+			parts := strings.Split(strings.TrimSuffix(rel, "/"), "/")
+			dirs := len(parts) - 1
+			if isDirectoryMarker(rel) {
+				dirs = len(parts)
+			}
+			for i := 1; i <= dirs; i++ {
+				dir := strings.Join(parts[:i], "/")
+				if seen[dir] {
+					continue
+				}
+				seen[dir] = true
+				if err := visit(dir, FileInfo{IsDir: true}); err != nil {
+					return err
+				}
+			}
+
+			if isDirectoryMarker(rel) {
+				continue
+			}
+			if err := visit(rel, FileInfo{Size: aws.ToInt64(obj.Size), ModTime: aws.ToTime(obj.LastModified)}); err != nil {
+				return err
+			}
 		}
 	}
 
-	return files, nil
+	return nil
+}
+
+// s3ContentTypes maps file extensions to the Content-Type stored with
+// uploaded objects. Types here take precedence over the system MIME
+// database, whose entries vary between hosts.
+var s3ContentTypes = map[string]string{
+	// Text and documents
+	".html":        "text/html",
+	".htm":         "text/html",
+	".css":         "text/css",
+	".js":          "application/javascript",
+	".mjs":         "application/javascript",
+	".json":        "application/json",
+	".map":         "application/json",
+	".jsonld":      "application/ld+json",
+	".webmanifest": "application/manifest+json",
+	".xml":         "application/xml",
+	".txt":         "text/plain",
+	".md":          "text/markdown",
+	".markdown":    "text/markdown",
+	".csv":         "text/csv",
+	".tsv":         "text/tab-separated-values",
+	".yaml":        "application/yaml",
+	".yml":         "application/yaml",
+	".toml":        "application/toml",
+	".ics":         "text/calendar",
+	".rtf":         "application/rtf",
+	".pdf":         "application/pdf",
+	".doc":         "application/msword",
+	".docx":        "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	".xls":         "application/vnd.ms-excel",
+	".xlsx":        "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	".ppt":         "application/vnd.ms-powerpoint",
+	".pptx":        "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+	".odt":         "application/vnd.oasis.opendocument.text",
+	".ods":         "application/vnd.oasis.opendocument.spreadsheet",
+	".epub":        "application/epub+zip",
+
+	// Web assets
+	".wasm":  "application/wasm",
+	".woff":  "font/woff",
+	".woff2": "font/woff2",
+	".ttf":   "font/ttf",
+	".otf":   "font/otf",
+	".eot":   "application/vnd.ms-fontobject",
+
+	// Archives
+	".zip": "application/zip",
+	".tar": "application/x-tar",
+	".gz":  "application/gzip",
+	".tgz": "application/gzip",
+	".bz2": "application/x-bzip2",
+	".xz":  "application/x-xz",
+	".7z":  "application/x-7z-compressed",
+	".rar": "application/vnd.rar",
+	".zst": "application/zstd",
+
+	// Images
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+	".gif":  "image/gif",
+	".svg":  "image/svg+xml",
+	".webp": "image/webp",
+	".avif": "image/avif",
+	".heic": "image/heic",
+	".bmp":  "image/bmp",
+	".ico":  "image/vnd.microsoft.icon",
+	".tif":  "image/tiff",
+	".tiff": "image/tiff",
+
+	// Audio and video
+	".mp4":  "video/mp4",
+	".m4v":  "video/mp4",
+	".webm": "video/webm",
+	".mov":  "video/quicktime",
+	".mkv":  "video/x-matroska",
+	".avi":  "video/x-msvideo",
+	".m3u8": "application/vnd.apple.mpegurl",
+	".ts":   "video/mp2t",
+	".mp3":  "audio/mpeg",
+	".m4a":  "audio/mp4",
+	".aac":  "audio/aac",
+	".ogg":  "audio/ogg",
+	".oga":  "audio/ogg",
+	".opus": "audio/opus",
+	".wav":  "audio/wav",
+	".flac": "audio/flac",
+}
+
+// SetContentTypes adds extension to Content-Type mappings that override the
+// built-in ones, e.g. {".log": "text/plain"}
+func (s *S3Storage) SetContentTypes(types map[string]string) {
+	s.contentTypes = make(map[string]string, len(types))
+	for ext, contentType := range types {
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		s.contentTypes[strings.ToLower(ext)] = contentType
+	}
 }
 
 func (s *S3Storage) getContentType(filePath string) string {
 	ext := strings.ToLower(path.Ext(filePath))
-	switch ext {
-	case ".html", ".htm":
-		return "text/html"
-	case ".css":
-		return "text/css"
-	case ".js":
-		return "application/javascript"
-	case ".json":
-		return "application/json"
-	case ".xml":
-		return "application/xml"
-	case ".pdf":
-		return "application/pdf"
-	case ".zip":
-		return "application/zip"
-	case ".tar":
-		return "application/x-tar"
-	case ".gz":
-		return "application/gzip"
-	case ".jpg", ".jpeg":
-		return "image/jpeg"
-	case ".png":
-		return "image/png"
-	case ".gif":
-		return "image/gif"
-	case ".svg":
-		return "image/svg+xml"
-	case ".mp4":
-		return "video/mp4"
-	case ".mp3":
-		return "audio/mpeg"
-	case ".txt":
-		return "text/plain"
-	default:
-		return "application/octet-stream"
+	if contentType, ok := s.contentTypes[ext]; ok {
+		return contentType
+	}
+	if contentType, ok := s3ContentTypes[ext]; ok {
+		return contentType
+	}
+	if contentType := mime.TypeByExtension(ext); contentType != "" {
+		return contentType
 	}
+	return "application/octet-stream"
 }
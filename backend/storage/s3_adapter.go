@@ -4,8 +4,12 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"log"
 	"path"
 	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
 )
 
 // S3FileSystem adapts S3Storage to implement the FileSystem interface
@@ -31,22 +35,84 @@ func (s *S3FileSystem) Stat(path string) (FileInfo, error) {
 	return *info, nil
 }
 
-// Read returns an io.ReadCloser for the file content
+// Read returns an io.ReadCloser for the file content, streamed straight
+// from the GetObject response
 func (s *S3FileSystem) Read(path string) (io.ReadCloser, error) {
-	data, err := s.S3Storage.Read(path)
-	if err != nil {
-		return nil, err
-	}
-	return io.NopCloser(bytes.NewReader(data)), nil
+	return s.S3Storage.Read(path)
 }
 
 // Write writes data from an io.Reader to a file
 func (s *S3FileSystem) Write(path string, data io.Reader) error {
+	return s.WriteContentType(path, data, "")
+}
+
+// WriteContentType writes data to a file, storing it with the given
+// Content-Type instead of one derived from the extension. Data that fits
+// in one part is written with a single PutObject; anything longer is sent
+// as a multipart upload while it is read, so at most one part is held in
+// memory.
+func (s *S3FileSystem) WriteContentType(path string, data io.Reader, contentType string) error {
+	part := make([]byte, s3PartSize)
+	n, err := io.ReadFull(data, part)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return s.S3Storage.WriteContentType(path, part[:n], contentType)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read data: %w", err)
+	}
+
+	uploadID, err := s.StartMultipart(path, contentType)
+	if err != nil {
+		return err
+	}
+	abort := func(err error) error {
+		if abortErr := s.AbortMultipart(path, uploadID); abortErr != nil {
+			log.Printf("Error aborting multipart upload of %s: %v", path, abortErr)
+		}
+		return err
+	}
+
+	var etags []string
+	for {
+		etag, err := s.WritePart(path, uploadID, len(etags)+1, bytes.NewReader(part[:n]))
+		if err != nil {
+			return abort(err)
+		}
+		etags = append(etags, etag)
+
+		n, err = io.ReadFull(data, part)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return abort(fmt.Errorf("failed to read data: %w", err))
+		}
+	}
+
+	if err := s.CompleteMultipart(path, uploadID, etags); err != nil {
+		return abort(err)
+	}
+	return nil
+}
+
+// WriteConditional writes a file with S3's conditional PutObject, which
+// checks the ETag on the server, so no other write can slip in between
+func (s *S3FileSystem) WriteConditional(path string, data io.Reader, contentType, ifMatch string) error {
 	content, err := io.ReadAll(data)
 	if err != nil {
 		return fmt.Errorf("failed to read data: %w", err)
 	}
-	return s.S3Storage.Write(path, content)
+	err = s.putObject(path, content, contentType, func(in *s3.PutObjectInput) {
+		if ifMatch == "" {
+			in.IfNoneMatch = aws.String("*")
+		} else {
+			in.IfMatch = aws.String(ifMatch)
+		}
+	})
+	if isPreconditionFailed(err, ifMatch) {
+		return fmt.Errorf("%w: %s changed", ErrPreconditionFailed, path)
+	}
+	return err
 }
 
 // MkDir creates a directory
@@ -60,6 +126,23 @@ func (s *S3FileSystem) Copy(src, dst string, progress ProgressCallback) error {
 	return s.S3Storage.Copy(src, dst)
 }
 
+// CopyFrom copies an object from another S3 storage with CopyObject, so
+// the data stays inside the provider. Only storages on the same endpoint
+// with the same credentials qualify, since the destination's credentials
+// must be able to read the source bucket.
+func (s *S3FileSystem) CopyFrom(src FileSystem, srcPath, dstPath string) error {
+	other, ok := As[*S3FileSystem](src)
+	if !ok || !s.sameAccount(other.S3Storage) {
+		return ErrNotSupported
+	}
+
+	err := s.copyObject(other.bucket, other.getFullPath(other.resolveCase(srcPath)), s.getFullPath(dstPath))
+	if isCopySizeLimit(err) {
+		return fmt.Errorf("%w: %v", ErrNotSupported, err)
+	}
+	return err
+}
+
 // GetRootPath returns the root path of the storage
 func (s *S3FileSystem) GetRootPath() string {
 	if s.prefix != "" {
@@ -68,12 +151,22 @@ func (s *S3FileSystem) GetRootPath() string {
 	return "/"
 }
 
-// GetAvailableSpace returns available and total space
-// For S3, we return unlimited space
+// GetAvailableSpace reports unlimited space, as buckets have no quota.
+// With report_usage and a quota, the space left is the quota less the
+// objects' sizes; with report_usage alone, both are -1 for unknown and
+// the sum is reported by Usage instead.
 func (s *S3FileSystem) GetAvailableSpace() (available, total int64, err error) {
-	// S3 has effectively unlimited space
-	const unlimitedSpace = int64(1 << 62) // Very large number
-	return unlimitedSpace, unlimitedSpace, nil
+	if !s.reportUsage {
+		return UnlimitedSpace, UnlimitedSpace, nil
+	}
+	if s.quota <= 0 {
+		return -1, -1, nil
+	}
+	used, err := s.Usage()
+	if err != nil {
+		return 0, 0, err
+	}
+	return max(s.quota-used, 0), s.quota, nil
 }
 
 // IsValidPath checks if a path is valid
@@ -0,0 +1,1067 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// mockS3Client implements s3API for tests. Calls without a stub fail loudly
+// through the nil embedded interface.
+type mockS3Client struct {
+	s3API
+
+	// objects holds key -> content for the default List/Head behaviour
+	objects map[string][]byte
+	// contentTypes records the Content-Type each object was put with
+	contentTypes map[string]string
+	// versions holds the version ID HeadObject reports per key
+	versions map[string]string
+	// etags overrides the MD5 ETag listings report per key
+	etags map[string]string
+
+	retention *types.ObjectLockRetention
+	legalHold types.ObjectLockLegalHoldStatus
+
+	deleted       []string
+	deleteBypass  bool
+	deleteObjects func(*s3.DeleteObjectsInput) (*s3.DeleteObjectsOutput, error)
+	listObjects   func(*s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error)
+	copyObject    func(*s3.CopyObjectInput) (*s3.CopyObjectOutput, error)
+	putObject     func(*s3.PutObjectInput) error
+
+	// parts holds the parts of unfinished multipart uploads by upload ID,
+	// and uploads the key and start time of each
+	parts   map[string][][]byte
+	uploads map[string]types.MultipartUpload
+	aborted []string
+}
+
+func (m *mockS3Client) ListObjectsV2(ctx context.Context, in *s3.ListObjectsV2Input, _ ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	if m.listObjects != nil {
+		return m.listObjects(in)
+	}
+
+	keys := make([]string, 0, len(m.objects))
+	for key := range m.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	prefix := aws.ToString(in.Prefix)
+	delimiter := aws.ToString(in.Delimiter)
+	output := &s3.ListObjectsV2Output{}
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		rest := strings.TrimPrefix(key, prefix)
+		if delimiter != "" {
+			if idx := strings.Index(rest, delimiter); idx >= 0 {
+				common := prefix + rest[:idx+len(delimiter)]
+				if !seen[common] {
+					seen[common] = true
+					output.CommonPrefixes = append(output.CommonPrefixes, types.CommonPrefix{Prefix: aws.String(common)})
+				}
+				continue
+			}
+		}
+		sum := md5.Sum(m.objects[key])
+		etag := hex.EncodeToString(sum[:])
+		if m.etags[key] != "" {
+			etag = m.etags[key]
+		}
+		output.Contents = append(output.Contents, types.Object{
+			Key:          aws.String(key),
+			Size:         aws.Int64(int64(len(m.objects[key]))),
+			LastModified: aws.Time(time.Now()),
+			ETag:         aws.String(`"` + etag + `"`),
+		})
+	}
+	return output, nil
+}
+
+func (m *mockS3Client) HeadObject(ctx context.Context, in *s3.HeadObjectInput, _ ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	content, ok := m.objects[aws.ToString(in.Key)]
+	if !ok {
+		return nil, errors.New("NotFound")
+	}
+	output := &s3.HeadObjectOutput{
+		ContentLength: aws.Int64(int64(len(content))),
+		LastModified:  aws.Time(time.Now()),
+	}
+	if version, ok := m.versions[aws.ToString(in.Key)]; ok {
+		output.VersionId = aws.String(version)
+	}
+	return output, nil
+}
+
+func (m *mockS3Client) GetObject(ctx context.Context, in *s3.GetObjectInput, _ ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	content, ok := m.objects[aws.ToString(in.Key)]
+	if !ok {
+		return nil, errors.New("NoSuchKey")
+	}
+	if in.Range != nil {
+		var start, end int
+		if _, err := fmt.Sscanf(aws.ToString(in.Range), "bytes=%d-%d", &start, &end); err != nil || start >= len(content) {
+			return nil, errors.New("InvalidRange")
+		}
+		content = content[start:min(end+1, len(content))]
+	}
+	return &s3.GetObjectOutput{
+		Body:          io.NopCloser(bytes.NewReader(content)),
+		ContentLength: aws.Int64(int64(len(content))),
+	}, nil
+}
+
+func (m *mockS3Client) CopyObject(ctx context.Context, in *s3.CopyObjectInput, _ ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+	if m.copyObject != nil {
+		return m.copyObject(in)
+	}
+	_, srcKey, _ := strings.Cut(aws.ToString(in.CopySource), "/")
+	content, ok := m.objects[srcKey]
+	if !ok {
+		return nil, errors.New("NoSuchKey")
+	}
+	m.objects[aws.ToString(in.Key)] = append([]byte(nil), content...)
+	return &s3.CopyObjectOutput{}, nil
+}
+
+func (m *mockS3Client) PutObject(ctx context.Context, in *s3.PutObjectInput, _ ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	if m.putObject != nil {
+		if err := m.putObject(in); err != nil {
+			return nil, err
+		}
+	}
+	content, err := io.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+	if m.objects == nil {
+		m.objects = make(map[string][]byte)
+	}
+	if m.contentTypes == nil {
+		m.contentTypes = make(map[string]string)
+	}
+	key := aws.ToString(in.Key)
+	m.objects[key] = content
+	m.contentTypes[key] = aws.ToString(in.ContentType)
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (m *mockS3Client) DeleteObject(ctx context.Context, in *s3.DeleteObjectInput, _ ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	m.deleted = append(m.deleted, aws.ToString(in.Key))
+	m.deleteBypass = aws.ToBool(in.BypassGovernanceRetention)
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func (m *mockS3Client) DeleteObjects(ctx context.Context, in *s3.DeleteObjectsInput, _ ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error) {
+	if m.deleteObjects != nil {
+		return m.deleteObjects(in)
+	}
+	for _, obj := range in.Delete.Objects {
+		m.deleted = append(m.deleted, aws.ToString(obj.Key))
+	}
+	return &s3.DeleteObjectsOutput{}, nil
+}
+
+func (m *mockS3Client) GetObjectRetention(ctx context.Context, in *s3.GetObjectRetentionInput, _ ...func(*s3.Options)) (*s3.GetObjectRetentionOutput, error) {
+	if m.retention == nil {
+		return nil, errors.New("ObjectLockConfigurationNotFoundError")
+	}
+	return &s3.GetObjectRetentionOutput{Retention: m.retention}, nil
+}
+
+func (m *mockS3Client) GetObjectLegalHold(ctx context.Context, in *s3.GetObjectLegalHoldInput, _ ...func(*s3.Options)) (*s3.GetObjectLegalHoldOutput, error) {
+	if m.legalHold == "" {
+		return nil, errors.New("ObjectLockConfigurationNotFoundError")
+	}
+	return &s3.GetObjectLegalHoldOutput{LegalHold: &types.ObjectLockLegalHold{Status: m.legalHold}}, nil
+}
+
+func (m *mockS3Client) CreateMultipartUpload(ctx context.Context, in *s3.CreateMultipartUploadInput, _ ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	if m.parts == nil {
+		m.parts = make(map[string][][]byte)
+		m.uploads = make(map[string]types.MultipartUpload)
+	}
+	id := fmt.Sprintf("upload-%d", len(m.uploads)+1)
+	m.parts[id] = nil
+	m.uploads[id] = types.MultipartUpload{Key: in.Key, UploadId: aws.String(id), Initiated: aws.Time(time.Now())}
+	return &s3.CreateMultipartUploadOutput{UploadId: aws.String(id)}, nil
+}
+
+func (m *mockS3Client) ListMultipartUploads(ctx context.Context, in *s3.ListMultipartUploadsInput, _ ...func(*s3.Options)) (*s3.ListMultipartUploadsOutput, error) {
+	output := &s3.ListMultipartUploadsOutput{}
+	for id, upload := range m.uploads {
+		if _, open := m.parts[id]; open && strings.HasPrefix(aws.ToString(upload.Key), aws.ToString(in.Prefix)) {
+			output.Uploads = append(output.Uploads, upload)
+		}
+	}
+	return output, nil
+}
+
+func (m *mockS3Client) ListParts(ctx context.Context, in *s3.ListPartsInput, _ ...func(*s3.Options)) (*s3.ListPartsOutput, error) {
+	output := &s3.ListPartsOutput{}
+	for i, part := range m.parts[aws.ToString(in.UploadId)] {
+		output.Parts = append(output.Parts, types.Part{PartNumber: aws.Int32(int32(i + 1)), Size: aws.Int64(int64(len(part)))})
+	}
+	return output, nil
+}
+
+func (m *mockS3Client) UploadPart(ctx context.Context, in *s3.UploadPartInput, _ ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	content, err := io.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+	id := aws.ToString(in.UploadId)
+	m.parts[id] = append(m.parts[id], content)
+	return &s3.UploadPartOutput{ETag: aws.String(fmt.Sprintf(`"etag-%d"`, aws.ToInt32(in.PartNumber)))}, nil
+}
+
+func (m *mockS3Client) CompleteMultipartUpload(ctx context.Context, in *s3.CompleteMultipartUploadInput, _ ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	id := aws.ToString(in.UploadId)
+	var content []byte
+	for i, part := range in.MultipartUpload.Parts {
+		if want := fmt.Sprintf(`"etag-%d"`, i+1); aws.ToString(part.ETag) != want || aws.ToInt32(part.PartNumber) != int32(i+1) {
+			return nil, fmt.Errorf("part %d: unexpected ETag %s", i+1, aws.ToString(part.ETag))
+		}
+		content = append(content, m.parts[id][i]...)
+	}
+	delete(m.parts, id)
+	if m.objects == nil {
+		m.objects = make(map[string][]byte)
+	}
+	m.objects[aws.ToString(in.Key)] = content
+	return &s3.CompleteMultipartUploadOutput{}, nil
+}
+
+func (m *mockS3Client) AbortMultipartUpload(ctx context.Context, in *s3.AbortMultipartUploadInput, _ ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	delete(m.parts, aws.ToString(in.UploadId))
+	m.aborted = append(m.aborted, aws.ToString(in.Key))
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+func newMockS3Storage(client *mockS3Client) *S3Storage {
+	return &S3Storage{client: client, bucket: "test-bucket"}
+}
+
+func TestS3Storage_DeleteObjectLock(t *testing.T) {
+	until := time.Now().Add(48 * time.Hour)
+
+	t.Run("Compliance retention blocks delete", func(t *testing.T) {
+		client := &mockS3Client{retention: &types.ObjectLockRetention{
+			Mode:            types.ObjectLockRetentionModeCompliance,
+			RetainUntilDate: aws.Time(until),
+		}}
+		s := newMockS3Storage(client)
+		s.SetBypassGovernance(true)
+
+		err := s.Delete("/reports/q1.pdf")
+		var lockErr *ObjectLockedError
+		if !errors.As(err, &lockErr) {
+			t.Fatalf("Expected ObjectLockedError, got %v", err)
+		}
+		if lockErr.Mode != "COMPLIANCE" || !lockErr.RetainUntil.Equal(until) {
+			t.Errorf("Unexpected lock details: %+v", lockErr)
+		}
+		if lockErr.Path != "/reports/q1.pdf" {
+			t.Errorf("Expected path /reports/q1.pdf, got %s", lockErr.Path)
+		}
+		if len(client.deleted) != 0 {
+			t.Errorf("Expected no delete call, got %v", client.deleted)
+		}
+	})
+
+	t.Run("Governance retention without bypass", func(t *testing.T) {
+		client := &mockS3Client{retention: &types.ObjectLockRetention{
+			Mode:            types.ObjectLockRetentionModeGovernance,
+			RetainUntilDate: aws.Time(until),
+		}}
+		s := newMockS3Storage(client)
+
+		var lockErr *ObjectLockedError
+		if err := s.Delete("/file.txt"); !errors.As(err, &lockErr) {
+			t.Fatalf("Expected ObjectLockedError, got %v", err)
+		}
+	})
+
+	t.Run("Governance retention with bypass", func(t *testing.T) {
+		client := &mockS3Client{retention: &types.ObjectLockRetention{
+			Mode:            types.ObjectLockRetentionModeGovernance,
+			RetainUntilDate: aws.Time(until),
+		}}
+		s := newMockS3Storage(client)
+		s.SetBypassGovernance(true)
+
+		if err := s.Delete("/file.txt"); err != nil {
+			t.Fatalf("Failed to delete with bypass: %v", err)
+		}
+		if len(client.deleted) != 1 || !client.deleteBypass {
+			t.Errorf("Expected one bypassing delete, got %v (bypass=%v)", client.deleted, client.deleteBypass)
+		}
+	})
+
+	t.Run("Expired retention allows delete", func(t *testing.T) {
+		client := &mockS3Client{retention: &types.ObjectLockRetention{
+			Mode:            types.ObjectLockRetentionModeCompliance,
+			RetainUntilDate: aws.Time(time.Now().Add(-time.Hour)),
+		}}
+		s := newMockS3Storage(client)
+
+		if err := s.Delete("/file.txt"); err != nil {
+			t.Fatalf("Failed to delete: %v", err)
+		}
+	})
+
+	t.Run("Legal hold blocks delete", func(t *testing.T) {
+		client := &mockS3Client{legalHold: types.ObjectLockLegalHoldStatusOn}
+		s := newMockS3Storage(client)
+
+		var lockErr *ObjectLockedError
+		if err := s.Delete("/file.txt"); !errors.As(err, &lockErr) || !lockErr.LegalHold {
+			t.Fatalf("Expected legal hold error, got %v", err)
+		}
+	})
+
+	t.Run("Locked object inside directory", func(t *testing.T) {
+		client := &mockS3Client{
+			retention: &types.ObjectLockRetention{
+				Mode:            types.ObjectLockRetentionModeCompliance,
+				RetainUntilDate: aws.Time(until),
+			},
+			listObjects: func(in *s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error) {
+				return &s3.ListObjectsV2Output{Contents: []types.Object{{Key: aws.String("dir/a.txt")}}}, nil
+			},
+			deleteObjects: func(in *s3.DeleteObjectsInput) (*s3.DeleteObjectsOutput, error) {
+				return &s3.DeleteObjectsOutput{Errors: []types.Error{{
+					Key:     aws.String("dir/a.txt"),
+					Code:    aws.String("AccessDenied"),
+					Message: aws.String("Access Denied"),
+				}}}, nil
+			},
+		}
+		s := newMockS3Storage(client)
+
+		var lockErr *ObjectLockedError
+		if err := s.Delete("/dir"); !errors.As(err, &lockErr) {
+			t.Fatalf("Expected ObjectLockedError, got %v", err)
+		}
+	})
+}
+
+func TestS3Storage_CaseInsensitiveLookup(t *testing.T) {
+	client := &mockS3Client{objects: map[string][]byte{
+		"Photos/Summer/Beach.JPG": []byte("jpeg"),
+		"Photos/Summer/beach.txt": []byte("notes"),
+		"Photos/Winter/":          {},
+	}}
+	s := newMockS3Storage(client)
+
+	t.Run("Case-sensitive by default", func(t *testing.T) {
+		if _, err := s.GetInfo("/photos/summer/beach.jpg"); err == nil {
+			t.Error("Expected not found for differently-cased path")
+		}
+	})
+
+	s.SetCaseInsensitive(true)
+
+	t.Run("Resolves differently-cased file", func(t *testing.T) {
+		info, err := s.GetInfo("/photos/SUMMER/beach.jpg")
+		if err != nil {
+			t.Fatalf("Failed to resolve path: %v", err)
+		}
+		if info.Size != 4 {
+			t.Errorf("Expected size 4, got %d", info.Size)
+		}
+	})
+
+	t.Run("Prefers exact match", func(t *testing.T) {
+		if got := s.resolveCase("/Photos/Summer/beach.txt"); got != "/Photos/Summer/beach.txt" {
+			t.Errorf("Expected exact key, got %s", got)
+		}
+	})
+
+	t.Run("Resolves directory for listing", func(t *testing.T) {
+		files, err := s.List("/PHOTOS")
+		if err != nil {
+			t.Fatalf("Failed to list: %v", err)
+		}
+		if len(files) != 2 {
+			t.Errorf("Expected 2 entries, got %d", len(files))
+		}
+	})
+
+	t.Run("Unknown path stays unresolved", func(t *testing.T) {
+		if got := s.resolveCase("/photos/autumn/x.jpg"); got != "/Photos/autumn/x.jpg" {
+			t.Errorf("Unexpected resolution: %s", got)
+		}
+	})
+}
+
+func TestS3Storage_ContentType(t *testing.T) {
+	client := &mockS3Client{}
+	s := newMockS3Storage(client)
+	s.SetContentTypes(map[string]string{"log": "text/plain"})
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"/img/photo.webp", "image/webp"},
+		{"/app/module.wasm", "application/wasm"},
+		{"/fonts/Inter.WOFF2", "font/woff2"},
+		{"/README.md", "text/markdown"},
+		{"/config.yaml", "application/yaml"},
+		{"/server.log", "text/plain"},
+		{"/blob.unknownext", "application/octet-stream"},
+	}
+
+	for _, tt := range tests {
+		if err := s.Write(tt.path, []byte("x")); err != nil {
+			t.Fatalf("Failed to write %s: %v", tt.path, err)
+		}
+		key := strings.TrimPrefix(tt.path, "/")
+		if got := client.contentTypes[key]; got != tt.expected {
+			t.Errorf("%s: expected Content-Type %s, got %s", tt.path, tt.expected, got)
+		}
+	}
+
+	t.Run("Explicit override", func(t *testing.T) {
+		if err := s.WriteContentType("/data.bin", []byte("x"), "application/x-custom"); err != nil {
+			t.Fatalf("Failed to write: %v", err)
+		}
+		if got := client.contentTypes["data.bin"]; got != "application/x-custom" {
+			t.Errorf("Expected override to be kept, got %s", got)
+		}
+	})
+}
+
+func TestS3Storage_DirectoryModTime(t *testing.T) {
+	client := &mockS3Client{objects: map[string][]byte{
+		"photos/2023/a.jpg":   []byte("a"),
+		"photos/2024/b.jpg":   []byte("b"),
+		"photos/readme.txt":   []byte("hello"),
+		"photos/2024/c/d.jpg": []byte("d"),
+	}}
+	s := newMockS3Storage(client)
+
+	first, err := s.List("/photos")
+	if err != nil {
+		t.Fatalf("Failed to list: %v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	second, err := s.List("/photos")
+	if err != nil {
+		t.Fatalf("Failed to list again: %v", err)
+	}
+
+	dirs := 0
+	for i, file := range first {
+		if !file.IsDir {
+			if file.ModTime.IsZero() {
+				t.Errorf("Expected %s to keep its LastModified", file.Name)
+			}
+			continue
+		}
+		dirs++
+		if !file.ModTime.IsZero() {
+			t.Errorf("Expected zero modtime for directory %s, got %v", file.Name, file.ModTime)
+		}
+		if !second[i].ModTime.Equal(file.ModTime) {
+			t.Errorf("Directory %s modtime changed between listings: %v vs %v", file.Name, file.ModTime, second[i].ModTime)
+		}
+	}
+	if dirs != 2 {
+		t.Errorf("Expected 2 directories, got %d", dirs)
+	}
+
+	info, err := s.GetInfo("/photos/2024")
+	if err != nil {
+		t.Fatalf("Failed to stat directory: %v", err)
+	}
+	if !info.ModTime.IsZero() {
+		t.Errorf("Expected zero modtime from GetInfo, got %v", info.ModTime)
+	}
+}
+
+func TestS3Storage_ListPaths(t *testing.T) {
+	objects := func(prefix string) map[string][]byte {
+		return map[string][]byte{
+			prefix + "readme.txt":          []byte("hello"),
+			prefix + "photos/":             nil,
+			prefix + "photos/a.jpg":        []byte("a"),
+			prefix + "photos/2024/b.jpg":   []byte("b"),
+			prefix + "photos/empty/":       nil,
+			prefix + "docs/report/":        nil,
+			prefix + "docs/report/one.pdf": []byte("1"),
+		}
+	}
+
+	for _, tc := range []struct {
+		name    string
+		prefix  string
+		keys    string
+		dir     string
+		entries string
+	}{
+		{"Empty prefix at the root", "", "", "/", "/docs,/photos,/readme.txt"},
+		{"Empty prefix nested", "", "", "/photos", "/photos/2024,/photos/a.jpg,/photos/empty"},
+		{"Nested prefix at the root", "users/alice", "users/alice/", "/", "/docs,/photos,/readme.txt"},
+		{"Nested prefix nested", "users/alice", "users/alice/", "/photos", "/photos/2024,/photos/a.jpg,/photos/empty"},
+		{"Prefix with a trailing slash", "users/alice/", "users/alice/", "/docs", "/docs/report"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newMockS3Storage(&mockS3Client{objects: objects(tc.keys)})
+			s.prefix = tc.prefix
+
+			files, err := s.List(tc.dir)
+			if err != nil {
+				t.Fatalf("Failed to list: %v", err)
+			}
+			var paths []string
+			for _, file := range files {
+				if file.Name == "" || file.Name != path.Base(file.Path) {
+					t.Errorf("Name %q doesn't match path %q", file.Name, file.Path)
+				}
+				if file.IsDir && !file.ModTime.IsZero() {
+					t.Errorf("Expected zero modtime for directory %s", file.Path)
+				}
+				paths = append(paths, file.Path)
+			}
+			sort.Strings(paths)
+			if got := strings.Join(paths, ","); got != tc.entries {
+				t.Errorf("Expected %s, got %s", tc.entries, got)
+			}
+		})
+	}
+
+	t.Run("Directory marker listed as an object", func(t *testing.T) {
+		client := &mockS3Client{}
+		client.listObjects = func(in *s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error) {
+			return &s3.ListObjectsV2Output{
+				Contents: []types.Object{
+					{Key: aws.String("photos/"), Size: aws.Int64(0)},
+					{Key: aws.String("photos/a.jpg"), Size: aws.Int64(1), LastModified: aws.Time(time.Now())},
+				},
+			}, nil
+		}
+		files, err := newMockS3Storage(client).List("/")
+		if err != nil {
+			t.Fatalf("Failed to list: %v", err)
+		}
+		if len(files) != 0 {
+			t.Errorf("Expected neither the marker nor nested objects as files, got %+v", files)
+		}
+	})
+}
+
+func TestS3FileSystem_Usage(t *testing.T) {
+	client := &mockS3Client{objects: map[string][]byte{
+		"users/alice/a.txt":     []byte("12345"),
+		"users/alice/dir/":      nil,
+		"users/alice/dir/b.txt": []byte("123"),
+		"users/bob/c.txt":       []byte("1234567"),
+	}}
+	fs := &S3FileSystem{S3Storage: newMockS3Storage(client)}
+	fs.prefix = "users/alice"
+
+	if available, total, _ := fs.GetAvailableSpace(); available != UnlimitedSpace || total != UnlimitedSpace {
+		t.Errorf("Expected unlimited space without report_usage, got %d of %d", available, total)
+	}
+	if _, err := fs.Usage(); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("Expected no usage without report_usage, got %v", err)
+	}
+
+	fs.SetReportUsage(true, 0)
+	if available, total, _ := fs.GetAvailableSpace(); available != -1 || total != -1 {
+		t.Errorf("Expected unknown space without a quota, got %d of %d", available, total)
+	}
+	if used, err := fs.Usage(); err != nil || used != 8 {
+		t.Errorf("Expected 8 bytes used under the prefix, got %d: %v", used, err)
+	}
+
+	// The sum is reused rather than listed again for every call
+	client.objects["users/alice/new.txt"] = []byte("1")
+	fs.SetReportUsage(true, 20)
+	if available, total, _ := fs.GetAvailableSpace(); available != 12 || total != 20 {
+		t.Errorf("Expected 12 of 20 bytes available, got %d of %d", available, total)
+	}
+	fs.usageAt = time.Now().Add(-s3UsageTTL)
+	if used, _ := fs.Usage(); used != 9 {
+		t.Errorf("Expected the objects to be listed again once the sum is stale, got %d", used)
+	}
+}
+
+func TestS3Storage_NativeID(t *testing.T) {
+	client := &mockS3Client{
+		objects:  map[string][]byte{"versioned.txt": []byte("v"), "plain.txt": []byte("p")},
+		versions: map[string]string{"versioned.txt": "3HL4kqtJlcpXroDTDmJ+rmSpXd3dIbrHY"},
+	}
+	s := newMockS3Storage(client)
+
+	id, err := s.NativeID("/versioned.txt")
+	if err != nil {
+		t.Fatalf("Failed to get native ID: %v", err)
+	}
+	if id != "3HL4kqtJlcpXroDTDmJ+rmSpXd3dIbrHY" {
+		t.Errorf("Expected the version ID, got %q", id)
+	}
+
+	if _, err := s.NativeID("/plain.txt"); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("Expected ErrNotSupported for an unversioned object, got %v", err)
+	}
+}
+
+func TestS3Storage_PresignUpload(t *testing.T) {
+	client := s3.New(s3.Options{
+		Region:       "eu-west-1",
+		Credentials:  credentials.NewStaticCredentialsProvider("AKIDEXAMPLE", "secret", ""),
+		BaseEndpoint: aws.String("https://s3.example.com"),
+		UsePathStyle: true,
+	})
+	s := &S3Storage{presigner: s3.NewPresignClient(client), bucket: "uploads", prefix: "users"}
+
+	policyOf := func(up *PresignedUpload) string {
+		t.Helper()
+		doc, err := base64.StdEncoding.DecodeString(up.Fields["policy"])
+		if err != nil {
+			t.Fatalf("Failed to decode policy: %v", err)
+		}
+		var policy struct {
+			Conditions []interface{} `json:"conditions"`
+		}
+		if err := json.Unmarshal(doc, &policy); err != nil {
+			t.Fatalf("Failed to parse policy: %v", err)
+		}
+		conditions, _ := json.Marshal(policy.Conditions)
+		return string(conditions)
+	}
+
+	up, err := s.PresignUpload("/alice/avatar.png", PresignOptions{
+		Expires: 10 * time.Minute, MaxSize: 1 << 20, ContentType: "image/png",
+	})
+	if err != nil {
+		t.Fatalf("Failed to presign: %v", err)
+	}
+	if !strings.HasPrefix(up.URL, "https://s3.example.com/uploads") {
+		t.Errorf("Unexpected URL %s", up.URL)
+	}
+	if up.Fields["key"] != "users/alice/avatar.png" || up.Fields["Content-Type"] != "image/png" {
+		t.Errorf("Unexpected fields %v", up.Fields)
+	}
+	if until := time.Until(up.Expires); until < 9*time.Minute || until > 10*time.Minute {
+		t.Errorf("Expected expiry in 10 minutes, got %v", until)
+	}
+	conditions := policyOf(up)
+	for _, want := range []string{`["content-length-range",0,1048576]`, `{"Content-Type":"image/png"}`, `{"key":"users/alice/avatar.png"}`, `{"bucket":"uploads"}`} {
+		if !strings.Contains(conditions, want) {
+			t.Errorf("Expected %s in policy conditions %s", want, conditions)
+		}
+	}
+
+	t.Run("Content type prefix", func(t *testing.T) {
+		up, err := s.PresignUpload("/alice/photo.jpg", PresignOptions{Expires: time.Minute, ContentType: "image/*"})
+		if err != nil {
+			t.Fatalf("Failed to presign: %v", err)
+		}
+		conditions := policyOf(up)
+		if !strings.Contains(conditions, `["starts-with","$Content-Type","image/"]`) {
+			t.Errorf("Expected a Content-Type prefix condition, got %s", conditions)
+		}
+		if strings.Contains(conditions, "content-length-range") {
+			t.Errorf("Expected no size condition without MaxSize, got %s", conditions)
+		}
+	})
+
+	t.Run("Directory path", func(t *testing.T) {
+		if _, err := s.PresignUpload("/", PresignOptions{Expires: time.Minute}); err == nil {
+			t.Error("Expected an error presigning the bucket root")
+		}
+	})
+}
+
+func TestS3FileSystem_CopyFrom(t *testing.T) {
+	var copies []*s3.CopyObjectInput
+	dstClient := &mockS3Client{copyObject: func(in *s3.CopyObjectInput) (*s3.CopyObjectOutput, error) {
+		copies = append(copies, in)
+		return &s3.CopyObjectOutput{}, nil
+	}}
+	newFS := func(client *mockS3Client, bucket, prefix, accessKey string) *S3FileSystem {
+		return &S3FileSystem{S3Storage: &S3Storage{client: client, bucket: bucket, prefix: prefix, accessKey: accessKey, secretKey: "secret"}}
+	}
+	// The source client has no stubs: reading through it would panic
+	src := newFS(&mockS3Client{}, "archive", "2024", "AKIA1")
+	dst := newFS(dstClient, "media", "", "AKIA1")
+
+	if err := dst.CopyFrom(src, "/reports/q1.pdf", "/q1.pdf"); err != nil {
+		t.Fatalf("Failed to copy: %v", err)
+	}
+	if len(copies) != 1 {
+		t.Fatalf("Expected one CopyObject call, got %d", len(copies))
+	}
+	in := copies[0]
+	if aws.ToString(in.CopySource) != "archive/2024/reports/q1.pdf" || aws.ToString(in.Bucket) != "media" || aws.ToString(in.Key) != "q1.pdf" {
+		t.Errorf("Unexpected copy: %s -> %s/%s", aws.ToString(in.CopySource), aws.ToString(in.Bucket), aws.ToString(in.Key))
+	}
+
+	t.Run("Other account", func(t *testing.T) {
+		other := newFS(&mockS3Client{}, "archive", "", "AKIA2")
+		if err := dst.CopyFrom(other, "/a.txt", "/a.txt"); !errors.Is(err, ErrNotSupported) {
+			t.Errorf("Expected ErrNotSupported, got %v", err)
+		}
+	})
+
+	t.Run("Other provider", func(t *testing.T) {
+		if err := dst.CopyFrom(NewLocalStorage(t.TempDir()), "/a.txt", "/a.txt"); !errors.Is(err, ErrNotSupported) {
+			t.Errorf("Expected ErrNotSupported, got %v", err)
+		}
+	})
+
+	t.Run("Too large for CopyObject", func(t *testing.T) {
+		dstClient.copyObject = func(*s3.CopyObjectInput) (*s3.CopyObjectOutput, error) {
+			return nil, &smithy.GenericAPIError{Code: "EntityTooLarge"}
+		}
+		if err := dst.CopyFrom(src, "/disk.img", "/disk.img"); !errors.Is(err, ErrNotSupported) {
+			t.Errorf("Expected ErrNotSupported so the caller streams, got %v", err)
+		}
+	})
+}
+
+func TestS3Storage_ListDirs(t *testing.T) {
+	var inputs []*s3.ListObjectsV2Input
+	client := &mockS3Client{}
+	client.listObjects = func(in *s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error) {
+		inputs = append(inputs, in)
+		return &s3.ListObjectsV2Output{
+			CommonPrefixes: []types.CommonPrefix{{Prefix: aws.String("photos/2023/")}, {Prefix: aws.String("photos/2024/")}},
+			Contents:       []types.Object{{Key: aws.String("photos/readme.txt"), Size: aws.Int64(5)}},
+		}, nil
+	}
+	s := newMockS3Storage(client)
+
+	var dirs []string
+	err := s.ListDirs("/photos", func(info FileInfo) error {
+		if !info.IsDir {
+			t.Errorf("Expected only directories, got %s", info.Name)
+		}
+		dirs = append(dirs, info.Path)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to list directories: %v", err)
+	}
+	if strings.Join(dirs, ",") != "/photos/2023,/photos/2024" {
+		t.Errorf("Unexpected directories %v", dirs)
+	}
+	if len(inputs) != 1 || aws.ToString(inputs[0].Delimiter) != "/" || aws.ToString(inputs[0].Prefix) != "photos/" {
+		t.Errorf("Expected one delimited listing of photos/, got %+v", inputs)
+	}
+}
+
+func TestS3Storage_ContentHashes(t *testing.T) {
+	client := &mockS3Client{objects: map[string][]byte{
+		"data/docs/a.txt":       []byte("hello"),
+		"data/docs/sub/b.txt":   []byte("nested"),
+		"data/docs/upload.bin":  []byte("parts"),
+		"data/other/c.txt":      []byte("elsewhere"),
+		"data/docs/sub/":        nil,
+		"data/docs/zero-length": {},
+	}}
+	s := newMockS3Storage(client)
+	s.prefix = "data"
+
+	// A multipart upload's ETag isn't an MD5 of the content
+	client.etags = map[string]string{"data/docs/upload.bin": "9b2cf535f27731c974343645a3985328-2"}
+
+	hashes, err := s.ContentHashes("/docs", "md5")
+	if err != nil {
+		t.Fatalf("Failed to get hashes: %v", err)
+	}
+	want := map[string]string{
+		"a.txt":       "5d41402abc4b2a76b9719d911017c592",
+		"zero-length": "d41d8cd98f00b204e9800998ecf8427e",
+	}
+	if fmt.Sprint(hashes) != fmt.Sprint(want) {
+		t.Errorf("Expected %v, got %v", want, hashes)
+	}
+
+	if _, err := s.ContentHashes("/docs", "sha256"); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("Expected ErrNotSupported for sha256, got %v", err)
+	}
+}
+
+func TestS3FileSystem_ReadRange(t *testing.T) {
+	client := &mockS3Client{objects: map[string][]byte{"video.mp4": []byte("0123456789")}}
+	fs := &S3FileSystem{S3Storage: newMockS3Storage(client)}
+
+	reader, err := ReadRange(fs, "/video.mp4", 3, 4)
+	if err != nil {
+		t.Fatalf("Failed to read range: %v", err)
+	}
+	defer reader.Close()
+	got, err := io.ReadAll(reader)
+	if err != nil || string(got) != "3456" {
+		t.Errorf("Expected 3456, got %q (%v)", got, err)
+	}
+}
+
+func TestS3Storage_Multipart(t *testing.T) {
+	client := &mockS3Client{}
+	s := newMockS3Storage(client)
+	s.prefix = "data"
+
+	id, err := s.StartMultipart("/big.bin", "")
+	if err != nil {
+		t.Fatalf("Failed to start upload: %v", err)
+	}
+	var etags []string
+	for n, part := range []string{"hello ", "world"} {
+		etag, err := s.WritePart("/big.bin", id, n+1, strings.NewReader(part))
+		if err != nil {
+			t.Fatalf("Failed to write part %d: %v", n+1, err)
+		}
+		etags = append(etags, etag)
+	}
+	if err := s.CompleteMultipart("/big.bin", id, etags); err != nil {
+		t.Fatalf("Failed to complete upload: %v", err)
+	}
+	if got := string(client.objects["data/big.bin"]); got != "hello world" {
+		t.Errorf("Expected assembled object, got %q", got)
+	}
+
+	id, _ = s.StartMultipart("/other.bin", "")
+	if _, err := s.WritePart("/other.bin", id, 1, strings.NewReader("partial")); err != nil {
+		t.Fatalf("Failed to write part: %v", err)
+	}
+	pending, err := s.ListMultipart()
+	if err != nil {
+		t.Fatalf("Failed to list uploads: %v", err)
+	}
+	if len(pending) != 1 || pending[0].Path != "/other.bin" || pending[0].UploadID != id || pending[0].Size != 7 || pending[0].Started.IsZero() {
+		t.Errorf("Expected the unfinished upload of /other.bin, got %+v", pending)
+	}
+	if err := s.AbortMultipart("/other.bin", id); err != nil {
+		t.Fatalf("Failed to abort upload: %v", err)
+	}
+	if len(client.parts) != 0 || len(client.aborted) != 1 || client.aborted[0] != "data/other.bin" {
+		t.Errorf("Expected the parts of data/other.bin to be aborted, got %v with %d uploads left", client.aborted, len(client.parts))
+	}
+	if _, ok := client.objects["data/other.bin"]; ok {
+		t.Error("Aborted upload should not create an object")
+	}
+	if pending, _ := s.ListMultipart(); len(pending) != 0 {
+		t.Errorf("Expected no uploads left after aborting, got %+v", pending)
+	}
+}
+
+func TestS3FileSystem_WriteConditional(t *testing.T) {
+	client := &mockS3Client{objects: map[string][]byte{"notes.txt": []byte("v1")}}
+	var ifMatch, ifNoneMatch string
+	client.putObject = func(in *s3.PutObjectInput) error {
+		ifMatch, ifNoneMatch = aws.ToString(in.IfMatch), aws.ToString(in.IfNoneMatch)
+		switch {
+		case ifMatch == `"stale"`:
+			return &smithy.GenericAPIError{Code: "PreconditionFailed"}
+		case ifNoneMatch == "*" && client.objects[aws.ToString(in.Key)] != nil:
+			return &smithy.GenericAPIError{Code: "PreconditionFailed"}
+		}
+		return nil
+	}
+	fs := &S3FileSystem{S3Storage: newMockS3Storage(client)}
+
+	if err := fs.WriteConditional("/notes.txt", strings.NewReader("v2"), "", `"current"`); err != nil {
+		t.Fatalf("Expected the write to succeed: %v", err)
+	}
+	if ifMatch != `"current"` || string(client.objects["notes.txt"]) != "v2" {
+		t.Errorf("Expected If-Match to be sent, got %q", ifMatch)
+	}
+
+	err := fs.WriteConditional("/notes.txt", strings.NewReader("v3"), "", `"stale"`)
+	if !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("Expected ErrPreconditionFailed for a stale ETag, got %v", err)
+	}
+	if err := fs.WriteConditional("/notes.txt", strings.NewReader("v3"), "", ""); !errors.Is(err, ErrPreconditionFailed) || ifNoneMatch != "*" {
+		t.Errorf("Expected If-None-Match: * to refuse an existing object, got %v", err)
+	}
+	if string(client.objects["notes.txt"]) != "v2" {
+		t.Errorf("Refused writes must not change the object, got %q", client.objects["notes.txt"])
+	}
+}
+
+// patternReader yields size bytes of a repeating pattern without holding
+// them, counting how many have been read
+type patternReader struct {
+	size, read int64
+}
+
+func (p *patternReader) Read(b []byte) (int, error) {
+	if p.read >= p.size {
+		return 0, io.EOF
+	}
+	n := min(int64(len(b)), p.size-p.read)
+	for i := range b[:n] {
+		b[i] = byte((p.read + int64(i)) % 251)
+	}
+	p.read += n
+	return int(n), nil
+}
+
+// heapWatcher wraps a reader, sampling the live heap every few MB read, so
+// a test can tell whether the stream was ever held in memory as a whole
+type heapWatcher struct {
+	r          io.Reader
+	read, next int64
+	base, peak uint64
+}
+
+func newHeapWatcher(r io.Reader) *heapWatcher {
+	h := &heapWatcher{r: r}
+	h.base = liveHeap()
+	h.peak = h.base
+	return h
+}
+
+func liveHeap() uint64 {
+	runtime.GC()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return ms.HeapAlloc
+}
+
+func (h *heapWatcher) Read(b []byte) (int, error) {
+	n, err := h.r.Read(b)
+	h.read += int64(n)
+	if h.read >= h.next || err != nil {
+		h.peak = max(h.peak, liveHeap())
+		h.next = h.read + 4<<20
+	}
+	return n, err
+}
+
+// growth is the most the live heap grew by while the stream was read
+func (h *heapWatcher) growth() int64 {
+	return int64(h.peak - h.base)
+}
+
+// patternBytes is the content of a patternReader of the given size
+func patternBytes(size int64) []byte {
+	data, _ := io.ReadAll(&patternReader{size: size})
+	return data
+}
+
+// readAheadS3Client records how far the source of a multipart upload has
+// been read beyond the parts already sent
+type readAheadS3Client struct {
+	*mockS3Client
+	source   *patternReader
+	sent     int64
+	maxAhead int64
+}
+
+func (c *readAheadS3Client) UploadPart(ctx context.Context, in *s3.UploadPartInput, opts ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	c.maxAhead = max(c.maxAhead, c.source.read-c.sent)
+	out, err := c.mockS3Client.UploadPart(ctx, in, opts...)
+	if err == nil {
+		parts := c.parts[aws.ToString(in.UploadId)]
+		c.sent += int64(len(parts[len(parts)-1]))
+	}
+	return out, err
+}
+
+func TestS3FileSystem_WriteStreams(t *testing.T) {
+	size := int64(2*s3PartSize + 3<<20)
+	source := &patternReader{size: size}
+	client := &readAheadS3Client{mockS3Client: &mockS3Client{}, source: source}
+	fs := &S3FileSystem{S3Storage: &S3Storage{client: client, bucket: "test-bucket"}}
+
+	if err := fs.Write("/big.bin", source); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if client.maxAhead > s3PartSize {
+		t.Errorf("Expected at most one part read ahead of the upload, got %d bytes", client.maxAhead)
+	}
+	if !bytes.Equal(client.objects["big.bin"], patternBytes(size)) {
+		t.Errorf("Uploaded content differs (%d bytes, want %d)", len(client.objects["big.bin"]), size)
+	}
+
+	// Anything up to one part is a single PutObject
+	small := &mockS3Client{}
+	fs = &S3FileSystem{S3Storage: newMockS3Storage(small)}
+	if err := fs.Write("/small.txt", strings.NewReader("hello")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if string(small.objects["small.txt"]) != "hello" || small.parts != nil {
+		t.Errorf("Expected a single PutObject, got objects %v, parts %v", small.objects, small.parts)
+	}
+
+	// A failing source aborts the upload
+	failing := &mockS3Client{}
+	fs = &S3FileSystem{S3Storage: newMockS3Storage(failing)}
+	err := fs.Write("/broken.bin", io.MultiReader(&patternReader{size: s3PartSize + 1}, iotest.ErrReader(errors.New("connection reset"))))
+	if err == nil || len(failing.aborted) != 1 || failing.objects["broken.bin"] != nil {
+		t.Errorf("Expected the upload to be aborted, got %v, aborted %v", err, failing.aborted)
+	}
+}
+
+// streamingS3Client serves every GetObject from one patternReader, to see
+// how much of the object is read before the caller asks for it
+type streamingS3Client struct {
+	*mockS3Client
+	body   *patternReader
+	closed bool
+}
+
+func (c *streamingS3Client) GetObject(ctx context.Context, in *s3.GetObjectInput, _ ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	return &s3.GetObjectOutput{Body: c}, nil
+}
+
+func (c *streamingS3Client) Read(p []byte) (int, error) { return c.body.Read(p) }
+
+func (c *streamingS3Client) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestS3FileSystem_ReadStreams(t *testing.T) {
+	client := &streamingS3Client{mockS3Client: &mockS3Client{}, body: &patternReader{size: 4 << 30}}
+	fs := &S3FileSystem{S3Storage: &S3Storage{client: client, bucket: "test-bucket"}}
+
+	reader, err := fs.Read("/huge.iso")
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if client.body.read != 0 {
+		t.Fatalf("Expected nothing read before the caller reads, got %d bytes", client.body.read)
+	}
+
+	buf := make([]byte, 1024)
+	if _, err := io.ReadFull(reader, buf); err != nil {
+		t.Fatalf("Failed to read: %v", err)
+	}
+	if client.body.read != 1024 {
+		t.Errorf("Expected reads to pass straight through, got %d bytes read", client.body.read)
+	}
+	if err := reader.Close(); err != nil || !client.closed {
+		t.Errorf("Expected Close to close the response body, got %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// SearchOptions controls how Search matches entry names
+type SearchOptions struct {
+	CaseSensitive bool
+	// Regex treats the pattern as a regular expression instead of text
+	// the name must contain
+	Regex bool
+	// Exclude skips matching entries along with their contents; nil uses
+	// the server-wide default
+	Exclude *ExcludeFilter
+	// Limits bounds the walk of backends searched without a native index
+	Limits WalkLimits
+	// Context, when set, ends the search once it is done
+	Context context.Context
+}
+
+// SearchResult is a line of a file that contains the text searched for
+type SearchResult struct {
+	Path    string `json:"path"`
+	Line    int    `json:"line"`
+	Content string `json:"content"`
+}
+
+// Searcher is implemented by backends that can find entries by name
+// without walking the tree themselves
+type Searcher interface {
+	// Search calls fn for every entry below root whose name matches
+	// pattern, stopping at the first error fn returns
+	Search(root, pattern string, opts SearchOptions, fn func(FileInfo) error) error
+}
+
+// NewNameMatcher returns a function reporting whether a name matches
+// pattern: as a regular expression, or as text the name contains
+func NewNameMatcher(pattern string, opts SearchOptions) (func(string) bool, error) {
+	if opts.Regex {
+		flags := ""
+		if !opts.CaseSensitive {
+			flags = "(?i)"
+		}
+		re, err := regexp.Compile(flags + pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex pattern: %w", err)
+		}
+		return re.MatchString, nil
+	}
+
+	if !opts.CaseSensitive {
+		pattern = strings.ToLower(pattern)
+		return func(name string) bool {
+			return strings.Contains(strings.ToLower(name), pattern)
+		}, nil
+	}
+	return func(name string) bool {
+		return strings.Contains(name, pattern)
+	}, nil
+}
+
+// Search calls fn, as they're found, for the files and directories below
+// root whose names match pattern. Backends that implement Searcher answer
+// it themselves; the rest are walked, so every backend can be searched.
+// Searching stops at the first error fn returns, which Search returns.
+func Search(fs FileSystem, root, pattern string, opts SearchOptions, fn func(FileInfo) error) error {
+	if opts.Exclude == nil {
+		opts.Exclude = DefaultExcludes()
+	}
+	if s, ok := As[Searcher](fs); ok {
+		return s.Search(root, pattern, opts, fn)
+	}
+
+	match, err := NewNameMatcher(pattern, opts)
+	if err != nil {
+		return err
+	}
+	return WalkLimited(fs, root, opts.Limits, func(file FileInfo) error {
+		if opts.Context != nil && opts.Context.Err() != nil {
+			return opts.Context.Err()
+		}
+		if opts.Exclude.Excluded(file.Name) {
+			return SkipDir
+		}
+		if match(file.Name) {
+			return fn(file)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// indexedFileSystem answers searches from a fixed list instead of walking
+type indexedFileSystem struct {
+	*LocalStorage
+	index []FileInfo
+}
+
+func (s *indexedFileSystem) Search(root, pattern string, opts SearchOptions, fn func(FileInfo) error) error {
+	for _, info := range s.index {
+		if err := fn(info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestSearch(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"notes.txt", "docs/Report.TXT", "docs/deep/todo.txt", "docs/reports/q1.csv", ".git/report.txt"} {
+		full := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	local := NewLocalStorage(root)
+	exclude, _ := NewExcludeFilter([]string{".git"})
+
+	search := func(fs FileSystem, dir, pattern string, opts SearchOptions) []string {
+		t.Helper()
+		var found []string
+		err := Search(fs, dir, pattern, opts, func(info FileInfo) error {
+			found = append(found, info.Path)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Search %q failed: %v", pattern, err)
+		}
+		sort.Strings(found)
+		return found
+	}
+
+	tests := []struct {
+		dir     string
+		pattern string
+		opts    SearchOptions
+		want    []string
+	}{
+		{"/", "report", SearchOptions{}, []string{"/.git/report.txt", "/docs/Report.TXT", "/docs/reports"}},
+		{"/", "report", SearchOptions{Exclude: exclude}, []string{"/docs/Report.TXT", "/docs/reports"}},
+		{"/", "Report", SearchOptions{CaseSensitive: true, Exclude: exclude}, []string{"/docs/Report.TXT"}},
+		{"/", `\.txt$`, SearchOptions{Regex: true, Exclude: exclude}, []string{"/docs/Report.TXT", "/docs/deep/todo.txt", "/notes.txt"}},
+		{"/", `\.txt$`, SearchOptions{Regex: true, CaseSensitive: true, Exclude: exclude}, []string{"/docs/deep/todo.txt", "/notes.txt"}},
+		{"/docs", "o", SearchOptions{}, []string{"/docs/Report.TXT", "/docs/deep/todo.txt", "/docs/reports"}},
+	}
+	for _, tt := range tests {
+		if got := search(local, tt.dir, tt.pattern, tt.opts); strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("Search %s %q %+v: expected %v, got %v", tt.dir, tt.pattern, tt.opts, tt.want, got)
+		}
+	}
+
+	// Stopping early returns the callback's error
+	stop := errors.New("stop")
+	calls := 0
+	err := Search(local, "/", "t", SearchOptions{}, func(FileInfo) error {
+		calls++
+		return stop
+	})
+	if err != stop || calls != 1 {
+		t.Errorf("Expected the search to stop after one match, got %d calls and %v", calls, err)
+	}
+
+	if err := Search(local, "/", "(", SearchOptions{Regex: true}, func(FileInfo) error { return nil }); err == nil {
+		t.Error("Expected an invalid regex to fail")
+	}
+
+	// A native search is used through decorators
+	indexed := &indexedFileSystem{LocalStorage: local, index: []FileInfo{{Name: "indexed", Path: "/indexed"}}}
+	if got := search(NewStatsFileSystem(indexed), "/", "report", SearchOptions{}); strings.Join(got, ",") != "/indexed" {
+		t.Errorf("Expected the native search to answer, got %v", got)
+	}
+}
@@ -0,0 +1,295 @@
+package storage
+
+import (
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Operations counted by StatsFileSystem
+var statsOperations = []string{"list", "stat", "read", "write", "delete", "mkdir", "move", "copy"}
+
+// StorageStats is a summary of the traffic through one storage since the
+// counters were last reset
+type StorageStats struct {
+	BytesRead    int64            `json:"bytes_read"`
+	BytesWritten int64            `json:"bytes_written"`
+	Operations   map[string]int64 `json:"operations"`
+	Since        time.Time        `json:"since"`
+}
+
+// StatsFileSystem wraps a FileSystem and counts the bytes read and written
+// through it and the operations called on it. Transfers the backend does
+// on its own, like server-side copies, don't pass through and aren't
+// counted.
+type StatsFileSystem struct {
+	FileSystem
+
+	bytesRead    atomic.Int64
+	bytesWritten atomic.Int64
+	ops          map[string]*atomic.Int64
+
+	mu    sync.Mutex
+	since time.Time
+}
+
+// NewStatsFileSystem wraps fs with usage counters
+func NewStatsFileSystem(fs FileSystem) *StatsFileSystem {
+	s := &StatsFileSystem{
+		FileSystem: fs,
+		ops:        make(map[string]*atomic.Int64, len(statsOperations)),
+		since:      time.Now(),
+	}
+	for _, op := range statsOperations {
+		s.ops[op] = new(atomic.Int64)
+	}
+	return s
+}
+
+// Unwrap returns the wrapped FileSystem
+func (s *StatsFileSystem) Unwrap() FileSystem {
+	return s.FileSystem
+}
+
+// Stats returns a snapshot of the counters
+func (s *StatsFileSystem) Stats() StorageStats {
+	s.mu.Lock()
+	since := s.since
+	s.mu.Unlock()
+
+	stats := StorageStats{
+		BytesRead:    s.bytesRead.Load(),
+		BytesWritten: s.bytesWritten.Load(),
+		Operations:   make(map[string]int64, len(s.ops)),
+		Since:        since,
+	}
+	for op, n := range s.ops {
+		stats.Operations[op] = n.Load()
+	}
+	return stats
+}
+
+// ResetStats sets every counter back to zero
+func (s *StatsFileSystem) ResetStats() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bytesRead.Store(0)
+	s.bytesWritten.Store(0)
+	for _, n := range s.ops {
+		n.Store(0)
+	}
+	s.since = time.Now()
+}
+
+func (s *StatsFileSystem) count(op string) {
+	s.ops[op].Add(1)
+}
+
+// List counts a listing
+func (s *StatsFileSystem) List(path string) ([]FileInfo, error) {
+	s.count("list")
+	return s.FileSystem.List(path)
+}
+
+// ListFunc counts a listing, streaming it when the backend can
+func (s *StatsFileSystem) ListFunc(path string, fn func(FileInfo) error) error {
+	s.count("list")
+	return ListFunc(s.FileSystem, path, fn)
+}
+
+// Stat counts a stat
+func (s *StatsFileSystem) Stat(path string) (FileInfo, error) {
+	s.count("stat")
+	return s.FileSystem.Stat(path)
+}
+
+// Read counts a read and the bytes later read from the returned reader
+func (s *StatsFileSystem) Read(path string) (io.ReadCloser, error) {
+	s.count("read")
+	reader, err := s.FileSystem.Read(path)
+	if err != nil {
+		return nil, err
+	}
+	counted := &statsReader{ReadCloser: reader, n: &s.bytesRead}
+	// Keep random access for callers that seek, like tail and archive browsing
+	if file, ok := reader.(statsFileLike); ok {
+		return &statsFile{statsReader: counted, file: file}, nil
+	}
+	return counted, nil
+}
+
+// Write counts a write and the bytes the backend consumes from data
+func (s *StatsFileSystem) Write(path string, data io.Reader) error {
+	s.count("write")
+	return s.FileSystem.Write(path, &statsReader{ReadCloser: io.NopCloser(data), n: &s.bytesWritten})
+}
+
+// WriteContentType counts a write, passing the Content-Type on to backends
+// that store one
+func (s *StatsFileSystem) WriteContentType(path string, data io.Reader, contentType string) error {
+	ctw, ok := As[ContentTypeWriter](s.FileSystem)
+	if !ok {
+		return s.Write(path, data)
+	}
+	s.count("write")
+	return ctw.WriteContentType(path, &statsReader{ReadCloser: io.NopCloser(data), n: &s.bytesWritten}, contentType)
+}
+
+// WriteSized counts a write, passing the size on to backends that use it
+func (s *StatsFileSystem) WriteSized(path string, data io.Reader, size int64, progress ProgressCallback) error {
+	sw, ok := As[SizedWriter](s.FileSystem)
+	if !ok {
+		return s.Write(path, data)
+	}
+	s.count("write")
+	return sw.WriteSized(path, &statsReader{ReadCloser: io.NopCloser(data), n: &s.bytesWritten}, size, progress)
+}
+
+// WriteConditional counts a write, passing the condition on to backends
+// that can check it. Others write unconditionally, as if the backend
+// weren't wrapped.
+func (s *StatsFileSystem) WriteConditional(path string, data io.Reader, contentType, ifMatch string) error {
+	cw, ok := As[ConditionalWriter](s.FileSystem)
+	if !ok {
+		return s.WriteContentType(path, data, contentType)
+	}
+	s.count("write")
+	return cw.WriteConditional(path, &statsReader{ReadCloser: io.NopCloser(data), n: &s.bytesWritten}, contentType, ifMatch)
+}
+
+// MultipartPartSize returns the backend's part size, or 0 when it has no
+// multipart uploads
+func (s *StatsFileSystem) MultipartPartSize() int64 {
+	if mw, ok := As[MultipartWriter](s.FileSystem); ok {
+		return mw.MultipartPartSize()
+	}
+	return 0
+}
+
+// StartMultipart counts the upload as a write
+func (s *StatsFileSystem) StartMultipart(path, contentType string) (string, error) {
+	mw, ok := As[MultipartWriter](s.FileSystem)
+	if !ok {
+		return "", ErrNotSupported
+	}
+	s.count("write")
+	return mw.StartMultipart(path, contentType)
+}
+
+// WritePart counts the bytes of each part stored. They are measured
+// rather than counted as read, since a backend may read a part twice.
+func (s *StatsFileSystem) WritePart(path, uploadID string, n int, data io.ReadSeeker) (string, error) {
+	mw, ok := As[MultipartWriter](s.FileSystem)
+	if !ok {
+		return "", ErrNotSupported
+	}
+	size, sized := remainingSize(data)
+	token, err := mw.WritePart(path, uploadID, n, data)
+	if err == nil && sized {
+		s.bytesWritten.Add(size)
+	}
+	return token, err
+}
+
+// CompleteMultipart passes through to the backend
+func (s *StatsFileSystem) CompleteMultipart(path, uploadID string, parts []string) error {
+	mw, ok := As[MultipartWriter](s.FileSystem)
+	if !ok {
+		return ErrNotSupported
+	}
+	return mw.CompleteMultipart(path, uploadID, parts)
+}
+
+// AbortMultipart passes through to the backend
+func (s *StatsFileSystem) AbortMultipart(path, uploadID string) error {
+	mw, ok := As[MultipartWriter](s.FileSystem)
+	if !ok {
+		return ErrNotSupported
+	}
+	return mw.AbortMultipart(path, uploadID)
+}
+
+// Delete counts a delete
+func (s *StatsFileSystem) Delete(path string) error {
+	s.count("delete")
+	return s.FileSystem.Delete(path)
+}
+
+// MkDir counts a directory creation
+func (s *StatsFileSystem) MkDir(path string) error {
+	s.count("mkdir")
+	return s.FileSystem.MkDir(path)
+}
+
+// Move counts a move
+func (s *StatsFileSystem) Move(src, dst string) error {
+	s.count("move")
+	return s.FileSystem.Move(src, dst)
+}
+
+// Copy counts a copy within the storage
+func (s *StatsFileSystem) Copy(src, dst string, progress ProgressCallback) error {
+	s.count("copy")
+	return s.FileSystem.Copy(src, dst, progress)
+}
+
+// CopyOverwrite counts a copy within the storage
+func (s *StatsFileSystem) CopyOverwrite(src, dst string, overwrite bool, progress ProgressCallback) error {
+	oc, ok := As[OverwriteCopier](s.FileSystem)
+	if !ok {
+		return s.Copy(src, dst, progress)
+	}
+	s.count("copy")
+	return oc.CopyOverwrite(src, dst, overwrite, progress)
+}
+
+// ReadRange counts a read and the bytes later read from the window
+func (s *StatsFileSystem) ReadRange(path string, offset, length int64) (io.ReadCloser, error) {
+	s.count("read")
+	reader, err := ReadRange(s.FileSystem, path, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	return &statsReader{ReadCloser: reader, n: &s.bytesRead}, nil
+}
+
+// statsReader adds the bytes read through it to n
+type statsReader struct {
+	io.ReadCloser
+	n *atomic.Int64
+}
+
+func (r *statsReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n.Add(int64(n))
+	return n, err
+}
+
+// statsFileLike is the random access an *os.File offers
+type statsFileLike interface {
+	io.ReadSeekCloser
+	io.ReaderAt
+	Stat() (os.FileInfo, error)
+}
+
+// statsFile is a statsReader over a file, keeping its random access
+type statsFile struct {
+	*statsReader
+	file statsFileLike
+}
+
+func (f *statsFile) Seek(offset int64, whence int) (int64, error) {
+	return f.file.Seek(offset, whence)
+}
+
+func (f *statsFile) ReadAt(p []byte, off int64) (int, error) {
+	n, err := f.file.ReadAt(p, off)
+	f.n.Add(int64(n))
+	return n, err
+}
+
+func (f *statsFile) Stat() (os.FileInfo, error) {
+	return f.file.Stat()
+}
@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestStatsFileSystem(t *testing.T) {
+	local := NewLocalStorage(t.TempDir())
+	mgr := NewManager()
+	mgr.Register("local", local)
+	fs, _ := mgr.Get("local")
+	stats, ok := mgr.Stats("local")
+	if !ok {
+		t.Fatal("Expected registered storages to be counted")
+	}
+
+	if err := fs.MkDir("/dir"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Write("/dir/a.txt", strings.NewReader("hello world")); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeWithType(fs, "/dir/b.txt", "12345"); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := fs.Read("/dir/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Seeking still works through the counting reader
+	if _, err := reader.(io.Seeker).Seek(6, io.SeekStart); err != nil {
+		t.Fatalf("Expected a seekable reader: %v", err)
+	}
+	data, _ := io.ReadAll(reader)
+	reader.Close()
+	if string(data) != "world" {
+		t.Errorf("Expected to read from the seek offset, got %q", data)
+	}
+
+	if _, err := fs.List("/dir"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ListFunc(fs, "/dir", func(FileInfo) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat("/dir/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Delete("/dir/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	got := stats.Stats()
+	if got.BytesWritten != 16 || got.BytesRead != 5 {
+		t.Errorf("Expected 16 bytes written and 5 read, got %d and %d", got.BytesWritten, got.BytesRead)
+	}
+	want := map[string]int64{"mkdir": 1, "write": 2, "read": 1, "list": 2, "stat": 1, "delete": 1, "move": 0, "copy": 0}
+	for op, n := range want {
+		if got.Operations[op] != n {
+			t.Errorf("Expected %d %s operations, got %d", n, op, got.Operations[op])
+		}
+	}
+
+	// Capabilities of the wrapped storage stay reachable
+	if l, ok := As[*LocalStorage](fs); !ok || l != local {
+		t.Error("Expected As to find the wrapped LocalStorage")
+	}
+
+	since := got.Since
+	stats.ResetStats()
+	got = stats.Stats()
+	if got.BytesRead != 0 || got.BytesWritten != 0 || got.Operations["write"] != 0 || !got.Since.After(since) {
+		t.Errorf("Expected counters to be reset, got %+v", got)
+	}
+}
+
+func writeWithType(fs FileSystem, path, content string) error {
+	ctw, ok := As[ContentTypeWriter](fs)
+	if !ok {
+		return fs.Write(path, strings.NewReader(content))
+	}
+	return ctw.WriteContentType(path, strings.NewReader(content), "text/plain")
+}
+
+// uploadFS adds conditional writes and multipart uploads to a storage,
+// reading each part twice like a backend that checksums it first
+type uploadFS struct {
+	FileSystem
+	parts map[string][]byte
+}
+
+func (u *uploadFS) WriteConditional(path string, data io.Reader, contentType, ifMatch string) error {
+	return u.Write(path, data)
+}
+
+func (u *uploadFS) MultipartPartSize() int64 { return 4 }
+
+func (u *uploadFS) StartMultipart(path, contentType string) (string, error) { return "upload-1", nil }
+
+func (u *uploadFS) WritePart(path, uploadID string, n int, data io.ReadSeeker) (string, error) {
+	if _, err := io.Copy(io.Discard, data); err != nil {
+		return "", err
+	}
+	if _, err := data.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	part, err := io.ReadAll(data)
+	u.parts[path] = append(u.parts[path], part...)
+	return "etag", err
+}
+
+func (u *uploadFS) CompleteMultipart(path, uploadID string, parts []string) error {
+	return u.Write(path, bytes.NewReader(u.parts[path]))
+}
+
+func (u *uploadFS) AbortMultipart(path, uploadID string) error { return nil }
+
+func TestStatsFileSystem_Uploads(t *testing.T) {
+	stats := NewStatsFileSystem(&uploadFS{FileSystem: NewLocalStorage(t.TempDir()), parts: map[string][]byte{}})
+
+	cw, _ := As[ConditionalWriter](stats)
+	if err := cw.WriteConditional("/a.txt", strings.NewReader("hello"), "", ""); err != nil {
+		t.Fatal(err)
+	}
+	mw, _ := As[MultipartWriter](stats)
+	id, err := mw.StartMultipart("/big.bin", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tokens []string
+	for n, part := range []string{"0123", "45"} {
+		token, err := mw.WritePart("/big.bin", id, n+1, strings.NewReader(part))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens = append(tokens, token)
+	}
+	if err := mw.CompleteMultipart("/big.bin", id, tokens); err != nil {
+		t.Fatal(err)
+	}
+
+	// The completed upload writes through the backend, not the counter
+	if got := stats.Stats(); got.BytesWritten != 11 || got.Operations["write"] != 2 {
+		t.Errorf("Expected 11 bytes in 2 writes, got %d in %d", got.BytesWritten, got.Operations["write"])
+	}
+
+	// A backend without them is written as if unwrapped
+	plain := NewStatsFileSystem(NewLocalStorage(t.TempDir()))
+	if mw, ok := As[MultipartWriter](plain); !ok || mw.MultipartPartSize() != 0 {
+		t.Error("Expected no multipart part size without a multipart backend")
+	}
+	if cw, _ := As[ConditionalWriter](plain); cw.WriteConditional("/a.txt", strings.NewReader("hello"), "", "") != nil {
+		t.Error("Expected an unconditional write")
+	}
+	if got := plain.Stats(); got.BytesWritten != 5 || got.Operations["write"] != 1 {
+		t.Errorf("Expected 5 bytes in 1 write, got %d in %d", got.BytesWritten, got.Operations["write"])
+	}
+}
@@ -0,0 +1,260 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// listRecursively is the per-directory recursion Walk replaces: List each
+// directory and descend into the subdirectories that aren't symlinks
+func listRecursively(t *testing.T, fs FileSystem, dir string, found map[string]bool) {
+	t.Helper()
+	entries, err := fs.List(dir)
+	if err != nil {
+		t.Fatalf("Failed to list %s: %v", dir, err)
+	}
+	for _, entry := range entries {
+		entryPath := path.Join(dir, entry.Name)
+		found[entryPath] = entry.IsDir
+		if entry.IsDir && !entry.IsLink {
+			listRecursively(t, fs, entryPath, found)
+		}
+	}
+}
+
+// walkAll collects Walk's output, checking every directory comes before
+// its contents
+func walkAll(t *testing.T, walk func(fn func(FileInfo) error) error) map[string]bool {
+	t.Helper()
+	found := make(map[string]bool)
+	err := walk(func(info FileInfo) error {
+		if _, ok := found[info.Path]; ok {
+			t.Errorf("%s reported twice", info.Path)
+		}
+		if parent := path.Dir(info.Path); parent != "/" && parent != "." {
+			if isDir, ok := found[parent]; ok && !isDir {
+				t.Errorf("%s reported under the file %s", info.Path, parent)
+			}
+		}
+		found[info.Path] = info.IsDir
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to walk: %v", err)
+	}
+	return found
+}
+
+func TestWalk_Local(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"docs/2023", "docs/2024/q1", "empty"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", dir, err)
+		}
+	}
+	for _, file := range []string{"readme.txt", "docs/index.md", "docs/2023/a.txt", "docs/2024/q1/b.txt"} {
+		if err := os.WriteFile(filepath.Join(root, file), []byte(file), 0644); err != nil {
+			t.Fatalf("Failed to create %s: %v", file, err)
+		}
+	}
+	// A link back up the tree must not be followed
+	if err := os.Symlink(filepath.Join(root, "docs"), filepath.Join(root, "docs/2024/loop")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	fs := NewLocalStorage(root)
+	want := make(map[string]bool)
+	listRecursively(t, fs, "/", want)
+
+	got := walkAll(t, func(fn func(FileInfo) error) error { return Walk(fs, "/", fn) })
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Walk differs from recursive listing:\n got  %v\n want %v", got, want)
+	}
+	if _, ok := got["/docs/2024/loop"]; !ok {
+		t.Error("Expected the symlink itself to be reported")
+	}
+
+	t.Run("Subtree", func(t *testing.T) {
+		got := walkAll(t, func(fn func(FileInfo) error) error { return Walk(fs, "/docs/2024", fn) })
+		var paths []string
+		for p := range got {
+			paths = append(paths, p)
+		}
+		sort.Strings(paths)
+		if strings.Join(paths, ",") != "/docs/2024/loop,/docs/2024/q1,/docs/2024/q1/b.txt" {
+			t.Errorf("Unexpected subtree walk: %v", paths)
+		}
+	})
+
+	t.Run("SkipDir", func(t *testing.T) {
+		got := walkAll(t, func(fn func(FileInfo) error) error {
+			return Walk(fs, "/", func(info FileInfo) error {
+				if info.Name == "2024" {
+					return SkipDir
+				}
+				return fn(info)
+			})
+		})
+		for p := range got {
+			if strings.HasPrefix(p, "/docs/2024") {
+				t.Errorf("Expected %s to be skipped", p)
+			}
+		}
+		if !got["/docs/2023"] {
+			t.Error("Expected /docs/2023 to be walked")
+		}
+	})
+}
+
+func TestS3Storage_Walk(t *testing.T) {
+	client := &mockS3Client{objects: map[string][]byte{
+		"photos/2023/a.jpg":     []byte("a"),
+		"photos/2024/b.jpg":     []byte("bb"),
+		"photos/2024/c/d.jpg":   []byte("d"),
+		"photos/readme.txt":     []byte("hello"),
+		"photos/empty/":         nil,
+		"photos-old/e.jpg":      []byte("e"),
+		"documents/report.docx": []byte("r"),
+	}}
+	fs := &S3FileSystem{S3Storage: newMockS3Storage(client)}
+
+	for _, root := range []string{"/", "/photos", "/photos/2024"} {
+		t.Run(root, func(t *testing.T) {
+			want := walkAll(t, func(fn func(FileInfo) error) error { return walkList(fs, root, 0, fn) })
+			got := walkAll(t, func(fn func(FileInfo) error) error { return Walk(fs, root, fn) })
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Flat walk differs from per-directory walk:\n got  %v\n want %v", got, want)
+			}
+		})
+	}
+
+	t.Run("Sizes", func(t *testing.T) {
+		err := Walk(fs, "/photos/2024", func(info FileInfo) error {
+			if info.Path == "/photos/2024/b.jpg" && info.Size != 2 {
+				t.Errorf("Expected size 2 for b.jpg, got %d", info.Size)
+			}
+			if info.IsDir && !info.ModTime.IsZero() {
+				t.Errorf("Expected no modification time for %s", info.Path)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Failed to walk: %v", err)
+		}
+	})
+
+	t.Run("SkipDir", func(t *testing.T) {
+		got := walkAll(t, func(fn func(FileInfo) error) error {
+			return Walk(fs, "/photos", func(info FileInfo) error {
+				if info.Name == "2024" {
+					return SkipDir
+				}
+				return fn(info)
+			})
+		})
+		for p := range got {
+			if strings.HasPrefix(p, "/photos/2024") {
+				t.Errorf("Expected %s to be skipped", p)
+			}
+		}
+		if len(got) != 4 {
+			t.Errorf("Expected 2023, 2023/a.jpg, empty and readme.txt, got %v", got)
+		}
+	})
+}
+
+func TestWalk_SymlinkLoop(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "loop/sub"), 0755); err != nil {
+		t.Fatalf("Failed to create directories: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "loop/sub/file.txt"), []byte("data"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	for link, target := range map[string]string{"loop/self": ".", "loop/sub/up": ".."} {
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Fatalf("Failed to create symlink: %v", err)
+		}
+	}
+	ls := NewLocalStorage(root)
+
+	got := walkAll(t, func(fn func(FileInfo) error) error { return Walk(ls, "/loop", fn) })
+	if len(got) != 4 || !got["/loop/sub"] || got["/loop/self"] {
+		t.Errorf("Expected the loop's links to be reported but not entered, got %v", got)
+	}
+
+	size, truncated := ls.calculateDirSize(filepath.Join(root, "loop"))
+	if truncated || size != 7 { // the file and the two links themselves
+		t.Errorf("Expected a size of 7, got %d (truncated %v)", size, truncated)
+	}
+
+	if err := ls.Copy("/loop", "/copy", nil); err != nil {
+		t.Fatalf("Copy failed: %v", err)
+	}
+	if target, err := os.Readlink(filepath.Join(root, "copy/sub/up")); err != nil || target != ".." {
+		t.Errorf("Expected the link to be recreated, got %q, %v", target, err)
+	}
+
+	// Copying a folder into itself copies it once rather than forever
+	if err := ls.Copy("/loop", "/loop/sub/nested", nil); err != nil {
+		t.Fatalf("Copy into itself failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "loop/sub/nested/sub/file.txt")); err != nil {
+		t.Errorf("Expected the copy to hold the original files: %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(root, "loop/sub/nested/sub/nested")); !os.IsNotExist(err) {
+		t.Errorf("Expected the copy not to contain itself, got %v", err)
+	}
+}
+
+// aliasedStorage reports some directories as the same directory as
+// another, the way a bind mount does
+type aliasedStorage struct {
+	*LocalStorage
+	aliases map[string]string
+}
+
+func (a *aliasedStorage) DirID(p string) (string, error) {
+	if target, ok := a.aliases[p]; ok {
+		p = target
+	}
+	return a.LocalStorage.DirID(p)
+}
+
+func TestWalk_RevisitedDirectory(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"data/mnt", "data/docs"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", dir, err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(root, "data/mnt/a.txt"), []byte("a"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	fs := &aliasedStorage{LocalStorage: NewLocalStorage(root), aliases: map[string]string{"/data/mnt": "/data"}}
+
+	got := walkAll(t, func(fn func(FileInfo) error) error { return Walk(fs, "/data", fn) })
+	if !got["/data/mnt"] || !got["/data/docs"] {
+		t.Errorf("Expected both directories to be reported, got %v", got)
+	}
+	if _, ok := got["/data/mnt/a.txt"]; ok {
+		t.Error("Expected the aliased directory not to be entered")
+	}
+
+	t.Run("Limits", func(t *testing.T) {
+		var seen int
+		err := WalkLimited(NewLocalStorage(root), "/", WalkLimits{MaxEntries: 2}, func(FileInfo) error {
+			seen++
+			return nil
+		})
+		if !errors.Is(err, ErrWalkTruncated) || seen != 2 {
+			t.Errorf("Expected ErrWalkTruncated after 2 entries, got %v after %d", err, seen)
+		}
+	})
+}
@@ -5,12 +5,15 @@ package storage
 
 import (
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
+	"os"
 	"path"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -32,6 +35,7 @@ type multiStatus struct {
 
 type response struct {
 	Href     string   `xml:"href"`
+	Status   string   `xml:"status"`
 	Propstat propstat `xml:"propstat"`
 }
 
@@ -82,7 +86,7 @@ func NewWebDAVStorage(baseURL, username, password, rootPath string) (*WebDAVStor
 // List lists files in a directory
 func (w *WebDAVStorage) List(dirPath string) ([]FileInfo, error) {
 	fullPath := w.getFullPath(dirPath)
-	fullURL := w.baseURL + fullPath
+	fullURL := w.urlFor(fullPath)
 
 	// Create PROPFIND request
 	propfindBody := `<?xml version="1.0" encoding="utf-8"?>
@@ -126,18 +130,10 @@ func (w *WebDAVStorage) List(dirPath string) ([]FileInfo, error) {
 	}
 
 	var files []FileInfo
-	for i, response := range ms.Responses {
-		// Skip the directory itself (first entry)
-		if i == 0 && strings.TrimSuffix(response.Href, "/") == strings.TrimSuffix(fullPath, "/") {
-			continue
-		}
-
-		// Parse href to get the name
-		href, _ := url.QueryUnescape(response.Href)
-		name := path.Base(href)
-
-		// Skip empty names
-		if name == "" || name == "." {
+	requested := w.serverPath(fullPath)
+	for _, response := range ms.Responses {
+		name, ok := entryName(response.Href, requested)
+		if !ok {
 			continue
 		}
 
@@ -166,7 +162,7 @@ func (w *WebDAVStorage) List(dirPath string) ([]FileInfo, error) {
 // Stat returns information about a file
 func (w *WebDAVStorage) Stat(filePath string) (FileInfo, error) {
 	fullPath := w.getFullPath(filePath)
-	fullURL := w.baseURL + fullPath
+	fullURL := w.urlFor(fullPath)
 
 	// Create PROPFIND request
 	propfindBody := `<?xml version="1.0" encoding="utf-8"?>
@@ -229,7 +225,7 @@ func (w *WebDAVStorage) Stat(filePath string) (FileInfo, error) {
 // Read reads a file from WebDAV server
 func (w *WebDAVStorage) Read(filePath string) (io.ReadCloser, error) {
 	fullPath := w.getFullPath(filePath)
-	fullURL := w.baseURL + fullPath
+	fullURL := w.urlFor(fullPath)
 
 	req, err := http.NewRequest("GET", fullURL, nil)
 	if err != nil {
@@ -249,7 +245,7 @@ func (w *WebDAVStorage) Read(filePath string) (io.ReadCloser, error) {
 				log.Printf("Error closing response body: %v", err)
 			}
 		}()
-		return nil, fmt.Errorf("failed to read file: %s (status %d)", filePath, resp.StatusCode)
+		return nil, fmt.Errorf("failed to read file: %s (%w)", filePath, webdavStatusError(resp.StatusCode))
 	}
 
 	return resp.Body, nil
@@ -258,7 +254,7 @@ func (w *WebDAVStorage) Read(filePath string) (io.ReadCloser, error) {
 // Write writes a file to WebDAV server
 func (w *WebDAVStorage) Write(filePath string, data io.Reader) error {
 	fullPath := w.getFullPath(filePath)
-	fullURL := w.baseURL + fullPath
+	fullURL := w.urlFor(fullPath)
 
 	// Ensure parent directory exists
 	parentDir := path.Dir(fullPath)
@@ -287,7 +283,7 @@ func (w *WebDAVStorage) Write(filePath string, data io.Reader) error {
 
 	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("failed to write file: %s (status %d): %s", filePath, resp.StatusCode, body)
+		return fmt.Errorf("failed to write file: %s (%w): %s", filePath, webdavStatusError(resp.StatusCode), body)
 	}
 
 	return nil
@@ -296,7 +292,7 @@ func (w *WebDAVStorage) Write(filePath string, data io.Reader) error {
 // Delete deletes a file or directory
 func (w *WebDAVStorage) Delete(filePath string) error {
 	fullPath := w.getFullPath(filePath)
-	fullURL := w.baseURL + fullPath
+	fullURL := w.urlFor(fullPath)
 
 	req, err := http.NewRequest("DELETE", fullURL, nil)
 	if err != nil {
@@ -315,8 +311,11 @@ func (w *WebDAVStorage) Delete(filePath string) error {
 		}
 	}()
 
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		return fmt.Errorf("failed to delete: %s: %w (status %d)", filePath, ErrRateLimited, resp.StatusCode)
+	}
 	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to delete: %s (status %d)", filePath, resp.StatusCode)
+		return fmt.Errorf("failed to delete: %s (%w)", filePath, webdavStatusError(resp.StatusCode))
 	}
 
 	return nil
@@ -325,7 +324,7 @@ func (w *WebDAVStorage) Delete(filePath string) error {
 // MkDir creates a directory
 func (w *WebDAVStorage) MkDir(dirPath string) error {
 	fullPath := w.getFullPath(dirPath)
-	fullURL := w.baseURL + fullPath
+	fullURL := w.urlFor(fullPath)
 
 	// Ensure trailing slash for directories
 	if !strings.HasSuffix(fullURL, "/") {
@@ -350,7 +349,7 @@ func (w *WebDAVStorage) MkDir(dirPath string) error {
 	}()
 
 	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to create directory: %s (status %d)", dirPath, resp.StatusCode)
+		return fmt.Errorf("failed to create directory: %s (%w)", dirPath, webdavStatusError(resp.StatusCode))
 	}
 
 	return nil
@@ -359,10 +358,10 @@ func (w *WebDAVStorage) MkDir(dirPath string) error {
 // Move moves a file or directory
 func (w *WebDAVStorage) Move(src, dst string) error {
 	srcPath := w.getFullPath(src)
-	srcURL := w.baseURL + srcPath
+	srcURL := w.urlFor(srcPath)
 
 	dstPath := w.getFullPath(dst)
-	dstURL := w.baseURL + dstPath
+	dstURL := w.urlFor(dstPath)
 
 	req, err := http.NewRequest("MOVE", srcURL, nil)
 	if err != nil {
@@ -383,15 +382,38 @@ func (w *WebDAVStorage) Move(src, dst string) error {
 		}
 	}()
 
-	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent {
-		return fmt.Errorf("failed to move file: %s to %s (status %d)", src, dst, resp.StatusCode)
+	switch resp.StatusCode {
+	case http.StatusCreated, http.StatusNoContent:
+		return nil
+	case http.StatusMultiStatus:
+		if err := multiStatusError(resp.Body); err != nil {
+			return fmt.Errorf("failed to move file: %s to %s: %w", src, dst, err)
+		}
+		return nil
+	case http.StatusBadGateway, http.StatusInsufficientStorage:
+		// The server can't move to that destination itself (another
+		// server or volume) or has no room for its temporary copy
+		return fmt.Errorf("%w: %s to %s (status %d)", ErrNativeMoveFailed, src, dst, resp.StatusCode)
 	}
+	return fmt.Errorf("failed to move file: %s to %s (%w)", src, dst, webdavStatusError(resp.StatusCode))
+}
 
-	return nil
+// MoveFallback lets Move copy through the client when the server can't
+// move an entry itself
+func (w *WebDAVStorage) MoveFallback() bool {
+	return true
 }
 
-// Copy copies a file
+// Copy copies a file, replacing whatever is at dst
 func (w *WebDAVStorage) Copy(src, dst string, progress ProgressCallback) error {
+	return w.CopyOverwrite(src, dst, true, progress)
+}
+
+// CopyOverwrite copies a file. Unless overwrite is set, the server is
+// asked to leave an existing dst alone, and the refusal is returned as
+// os.ErrExist. Overwriting a directory replaces it as a whole; nothing of
+// what it held is kept.
+func (w *WebDAVStorage) CopyOverwrite(src, dst string, overwrite bool, progress ProgressCallback) error {
 	// Get file info for progress reporting
 	info, err := w.Stat(src)
 	if err != nil {
@@ -403,10 +425,10 @@ func (w *WebDAVStorage) Copy(src, dst string, progress ProgressCallback) error {
 	}
 
 	srcPath := w.getFullPath(src)
-	srcURL := w.baseURL + srcPath
+	srcURL := w.urlFor(srcPath)
 
 	dstPath := w.getFullPath(dst)
-	dstURL := w.baseURL + dstPath
+	dstURL := w.urlFor(dstPath)
 
 	req, err := http.NewRequest("COPY", srcURL, nil)
 	if err != nil {
@@ -415,7 +437,11 @@ func (w *WebDAVStorage) Copy(src, dst string, progress ProgressCallback) error {
 
 	req.SetBasicAuth(w.username, w.password)
 	req.Header.Set("Destination", dstURL)
-	req.Header.Set("Overwrite", "T")
+	if overwrite {
+		req.Header.Set("Overwrite", "T")
+	} else {
+		req.Header.Set("Overwrite", "F")
+	}
 
 	resp, err := w.client.Do(req)
 	if err != nil {
@@ -427,8 +453,16 @@ func (w *WebDAVStorage) Copy(src, dst string, progress ProgressCallback) error {
 		}
 	}()
 
-	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent {
-		return fmt.Errorf("failed to copy file: %s to %s (status %d)", src, dst, resp.StatusCode)
+	switch resp.StatusCode {
+	case http.StatusCreated, http.StatusNoContent:
+	case http.StatusMultiStatus:
+		if err := multiStatusError(resp.Body); err != nil {
+			return fmt.Errorf("failed to copy file: %s to %s: %w", src, dst, err)
+		}
+	case http.StatusPreconditionFailed:
+		return fmt.Errorf("failed to copy file: %s to %s: destination exists (%w)", src, dst, webdavStatusError(resp.StatusCode))
+	default:
+		return fmt.Errorf("failed to copy file: %s to %s (%w)", src, dst, webdavStatusError(resp.StatusCode))
 	}
 
 	if progress != nil {
@@ -438,6 +472,37 @@ func (w *WebDAVStorage) Copy(src, dst string, progress ProgressCallback) error {
 	return nil
 }
 
+// multiStatusError reads the 207 Multi-Status body of a COPY or MOVE,
+// which lists the resources the server failed on while the rest of the
+// tree went through. Some servers also list the ones that succeeded, so
+// 2xx statuses are passed over. Each failure matches its status as a
+// webdavStatusError does.
+func multiStatusError(body io.Reader) error {
+	var ms multiStatus
+	if err := xml.NewDecoder(body).Decode(&ms); err != nil {
+		return fmt.Errorf("unreadable multi-status response: %v", err)
+	}
+
+	var errs []error
+	for _, response := range ms.Responses {
+		// "HTTP/1.1 423 Locked"
+		fields := strings.Fields(response.Status)
+		if len(fields) < 2 {
+			continue
+		}
+		code, err := strconv.Atoi(fields[1])
+		if err != nil || (code >= 200 && code < 300) {
+			continue
+		}
+		href := response.Href
+		if unescaped, err := url.PathUnescape(href); err == nil {
+			href = unescaped
+		}
+		errs = append(errs, fmt.Errorf("%s (%w)", href, webdavStatusError(code)))
+	}
+	return errors.Join(errs...)
+}
+
 // GetType returns the storage type
 func (w *WebDAVStorage) GetType() string {
 	return "webdav"
@@ -451,7 +516,7 @@ func (w *WebDAVStorage) GetRootPath() string {
 // GetAvailableSpace returns available and total space
 func (w *WebDAVStorage) GetAvailableSpace() (available, total int64, err error) {
 	// Try to get quota information (not all servers support this)
-	fullURL := w.baseURL + w.rootPath
+	fullURL := w.urlFor(w.rootPath)
 
 	propfindBody := `<?xml version="1.0" encoding="utf-8"?>
 <d:propfind xmlns:d="DAV:">
@@ -525,46 +590,6 @@ func (w *WebDAVStorage) PutFileContent(filePath string, content []byte) error {
 	return w.Write(filePath, strings.NewReader(string(content)))
 }
 
-// Search searches for files
-func (w *WebDAVStorage) Search(query string, options map[string]interface{}) ([]FileInfo, error) {
-	// Start from root and search recursively
-	return w.searchRecursive(w.rootPath, query, options, 0)
-}
-
-func (w *WebDAVStorage) searchRecursive(dirPath, query string, options map[string]interface{}, depth int) ([]FileInfo, error) {
-	if depth > 10 { // Limit recursion depth
-		return nil, nil
-	}
-
-	files, err := w.List(dirPath)
-	if err != nil {
-		return nil, err
-	}
-
-	var results []FileInfo
-	queryLower := strings.ToLower(query)
-
-	for _, file := range files {
-		// Check if name matches
-		if strings.Contains(strings.ToLower(file.Name), queryLower) {
-			results = append(results, file)
-		}
-
-		// Recursively search directories
-		if file.IsDir {
-			subResults, _ := w.searchRecursive(file.Path, query, options, depth+1)
-			results = append(results, subResults...)
-		}
-
-		// Limit results
-		if len(results) >= 100 {
-			break
-		}
-	}
-
-	return results, nil
-}
-
 // Helper functions
 
 func (w *WebDAVStorage) getFullPath(filePath string) string {
@@ -583,6 +608,53 @@ func (w *WebDAVStorage) getFullPath(filePath string) string {
 	return path.Join(w.rootPath, "/", cleaned)
 }
 
+// urlFor returns the URL of a path under the server, escaped so names with
+// spaces, '#', '?' or non-ASCII characters reach the right resource
+func (w *WebDAVStorage) urlFor(fullPath string) string {
+	return w.baseURL + (&url.URL{Path: fullPath}).EscapedPath()
+}
+
+// serverPath returns the unescaped path the server sees for fullPath,
+// including any path the base URL has
+func (w *WebDAVStorage) serverPath(fullPath string) string {
+	base := ""
+	if u, err := url.Parse(w.baseURL); err == nil {
+		base = u.Path
+	}
+	return path.Join("/", base, fullPath)
+}
+
+// entryName returns the name of the entry a PROPFIND response describes,
+// given the unescaped path of the directory that was listed. Hrefs are
+// path-escaped and may be full URLs, absolute paths or relative to the
+// directory. ok is false for the directory itself.
+func entryName(href, requested string) (name string, ok bool) {
+	if u, err := url.Parse(href); err == nil && u.IsAbs() {
+		href = u.EscapedPath()
+	}
+	decoded, err := url.PathUnescape(href)
+	if err != nil {
+		decoded = href
+	}
+	if !strings.HasPrefix(decoded, "/") {
+		decoded = path.Join(requested, decoded)
+	}
+
+	decoded = path.Clean(decoded)
+	requested = path.Clean("/" + requested)
+	if decoded == requested {
+		return "", false
+	}
+	prefix := strings.TrimSuffix(requested, "/") + "/"
+	if rest, found := strings.CutPrefix(decoded, prefix); found {
+		name, _, _ = strings.Cut(rest, "/")
+	} else {
+		// The server maps paths differently, behind a proxy for instance
+		name = path.Base(decoded)
+	}
+	return name, name != "" && name != "." && name != "/"
+}
+
 func (w *WebDAVStorage) parseTime(timeStr string) time.Time {
 	if timeStr == "" {
 		return time.Time{}
@@ -641,3 +713,28 @@ func NewWebDAVAdapter(baseURL, username, password, rootPath string) (FileSystem,
 	}
 	return &WebDAVAdapter{storage}, nil
 }
+
+// webdavStatusError is a failed response's status. It matches the storage
+// error the status stands for, so callers can tell a missing file or a
+// full server from other failures with errors.Is.
+type webdavStatusError int
+
+func (e webdavStatusError) Error() string {
+	return fmt.Sprintf("status %d", int(e))
+}
+
+func (e webdavStatusError) Is(target error) bool {
+	switch int(e) {
+	case http.StatusNotFound:
+		return target == os.ErrNotExist
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return target == os.ErrPermission
+	case http.StatusPreconditionFailed:
+		return target == os.ErrExist
+	case http.StatusInsufficientStorage:
+		return target == ErrQuotaExceeded
+	case http.StatusTooManyRequests:
+		return target == ErrRateLimited
+	}
+	return false
+}
@@ -0,0 +1,170 @@
+//go:build !basic
+// +build !basic
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// davMultistatus renders a multistatus body with one response per href.
+// Hrefs ending in a slash are collections.
+func davMultistatus(hrefs ...string) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="utf-8"?><d:multistatus xmlns:d="DAV:">`)
+	for _, href := range hrefs {
+		resourceType := ""
+		if strings.HasSuffix(href, "/") {
+			resourceType = "<d:collection/>"
+		}
+		fmt.Fprintf(&b, `<d:response><d:href>%s</d:href><d:propstat><d:prop><d:resourcetype>%s</d:resourcetype><d:getcontentlength>1</d:getcontentlength></d:prop></d:propstat></d:response>`, href, resourceType)
+	}
+	b.WriteString(`</d:multistatus>`)
+	return b.String()
+}
+
+func TestWebDAVStorage_ListHrefs(t *testing.T) {
+	// Canned listings by the unescaped path the server was asked for
+	var serverURL string
+	listings := map[string]func() string{
+		"/dav/files": func() string { return davMultistatus("/dav/files/") },
+		// Absolute paths, with the directory itself listed last
+		"/dav/files/docs": func() string {
+			return davMultistatus(
+				"/dav/files/docs/a+b.txt",
+				"/dav/files/docs/my%20file.txt",
+				"/dav/files/docs/%23notes.md",
+				"/dav/files/docs/caf%C3%A9.txt",
+				"/dav/files/docs/%E6%97%A5%E6%9C%AC/",
+				"/dav/files/docs/",
+			)
+		},
+		// Full URLs
+		"/dav/files/full urls": func() string {
+			return davMultistatus(
+				serverURL+"/dav/files/full%20urls/",
+				serverURL+"/dav/files/full%20urls/1+1%3D2.txt",
+				serverURL+"/dav/files/full%20urls/sub%20dir/",
+			)
+		},
+		// Relative to the listed directory
+		"/dav/files/q?#": func() string {
+			return davMultistatus("./", "x+y.txt", "%C3%BCber%20uns/")
+		},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		listing, ok := listings[strings.TrimSuffix(r.URL.Path, "/")]
+		if r.Method != "PROPFIND" || !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusMultiStatus)
+		w.Write([]byte(listing()))
+	}))
+	defer server.Close()
+	serverURL = server.URL
+
+	w, err := NewWebDAVStorage(server.URL+"/dav", "user", "pass", "/files")
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+
+	for dir, want := range map[string][]string{
+		"/docs":      {"#notes.md", "a+b.txt", "café.txt", "my file.txt", "日本/"},
+		"/full urls": {"1+1=2.txt", "sub dir/"},
+		"/q?#":       {"x+y.txt", "über uns/"},
+	} {
+		files, err := w.List(dir)
+		if err != nil {
+			t.Errorf("%s: failed to list: %v", dir, err)
+			continue
+		}
+		var got []string
+		for _, f := range files {
+			name := f.Name
+			if f.IsDir {
+				name += "/"
+			}
+			if f.Path != dir+"/"+f.Name {
+				t.Errorf("%s: unexpected path %q for %q", dir, f.Path, f.Name)
+			}
+			got = append(got, name)
+		}
+		sort.Strings(got)
+		if strings.Join(got, "|") != strings.Join(want, "|") {
+			t.Errorf("%s: expected %q, got %q", dir, want, got)
+		}
+	}
+}
+
+func TestWebDAVStorage_Copy(t *testing.T) {
+	// COPY answers by destination, like a Nextcloud server would
+	var overwrite string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "PROPFIND":
+			w.WriteHeader(http.StatusMultiStatus)
+			w.Write([]byte(davMultistatus(r.URL.Path)))
+		case "COPY":
+			overwrite = r.Header.Get("Overwrite")
+			switch r.Header.Get("Destination") {
+			case "http://" + r.Host + "/dav/taken.txt":
+				if overwrite == "F" {
+					w.WriteHeader(http.StatusPreconditionFailed)
+					return
+				}
+				w.WriteHeader(http.StatusNoContent)
+			case "http://" + r.Host + "/dav/partial":
+				w.WriteHeader(http.StatusMultiStatus)
+				w.Write([]byte(`<?xml version="1.0" encoding="utf-8"?><d:multistatus xmlns:d="DAV:">` +
+					`<d:response><d:href>/dav/partial/a.txt</d:href><d:status>HTTP/1.1 201 Created</d:status></d:response>` +
+					`<d:response><d:href>/dav/partial/locked%20file.txt</d:href><d:status>HTTP/1.1 423 Locked</d:status></d:response>` +
+					`<d:response><d:href>/dav/partial/big.bin</d:href><d:status>HTTP/1.1 507 Insufficient Storage</d:status></d:response>` +
+					`</d:multistatus>`))
+			case "http://" + r.Host + "/dav/all-created":
+				w.WriteHeader(http.StatusMultiStatus)
+				w.Write([]byte(`<?xml version="1.0" encoding="utf-8"?><d:multistatus xmlns:d="DAV:">` +
+					`<d:response><d:href>/dav/all-created/a.txt</d:href><d:status>HTTP/1.1 201 Created</d:status></d:response>` +
+					`</d:multistatus>`))
+			default:
+				w.WriteHeader(http.StatusCreated)
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	w, err := NewWebDAVStorage(server.URL+"/dav", "user", "pass", "/")
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+
+	if err := w.CopyOverwrite("/a.txt", "/taken.txt", false, nil); !errors.Is(err, os.ErrExist) {
+		t.Errorf("Expected os.ErrExist for an existing destination, got %v", err)
+	}
+	if overwrite != "F" {
+		t.Errorf("Expected Overwrite: F, got %q", overwrite)
+	}
+	if err := w.Copy("/a.txt", "/taken.txt", nil); err != nil || overwrite != "T" {
+		t.Errorf("Expected Copy to overwrite, got %v with Overwrite: %q", err, overwrite)
+	}
+
+	err = w.CopyOverwrite("/dir", "/partial", false, nil)
+	if err == nil {
+		t.Fatal("Expected failures listed in a multi-status response to fail the copy")
+	}
+	if !errors.Is(err, ErrQuotaExceeded) || !strings.Contains(err.Error(), "/dav/partial/locked file.txt") || strings.Contains(err.Error(), "a.txt") {
+		t.Errorf("Expected the locked and full resources in the error, got %v", err)
+	}
+	if err := w.CopyOverwrite("/dir", "/all-created", false, nil); err != nil {
+		t.Errorf("Expected a multi-status response without failures to succeed, got %v", err)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package storage
+
+// copyXattrs does nothing where extended attributes aren't supported
+func copyXattrs(src, dst string) error {
+	return nil
+}
@@ -0,0 +1,78 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+
+	"golang.org/x/sys/unix"
+)
+
+// copyXattrs copies the extended attributes of src to dst. Filesystems
+// without xattr support on either side are skipped without an error, as
+// are single attributes this process may not set, like "trusted." ones
+// when not running as root.
+func copyXattrs(src, dst string) error {
+	list, err := readXattr(func(buf []byte) (int, error) { return unix.Listxattr(src, buf) })
+	if err != nil {
+		if xattrsUnsupported(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to list extended attributes: %w", err)
+	}
+
+	for _, name := range bytes.Split(list, []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		attr := string(name)
+		value, err := readXattr(func(buf []byte) (int, error) { return unix.Getxattr(src, attr, buf) })
+		if err != nil {
+			// Most likely removed since it was listed
+			log.Printf("Warning: failed to read extended attribute %s of %s: %v", attr, src, err)
+			continue
+		}
+		if err := unix.Setxattr(dst, attr, value, 0); err != nil {
+			if xattrsUnsupported(err) {
+				return nil
+			}
+			if errors.Is(err, unix.EPERM) || errors.Is(err, unix.EACCES) {
+				log.Printf("Warning: not permitted to copy extended attribute %s to %s", attr, dst)
+				continue
+			}
+			return fmt.Errorf("failed to set extended attribute %s: %w", attr, err)
+		}
+	}
+	return nil
+}
+
+// readXattr calls get first for the size and then for the data, retrying
+// when the value grew in between
+func readXattr(get func([]byte) (int, error)) ([]byte, error) {
+	for {
+		size, err := get(nil)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return nil, nil
+		}
+		buf := make([]byte, size)
+		n, err := get(buf)
+		if errors.Is(err, unix.ERANGE) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+}
+
+func xattrsUnsupported(err error) bool {
+	return errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EOPNOTSUPP)
+}
@@ -0,0 +1,64 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestLocalStorage_CopyPreservesXattrs(t *testing.T) {
+	tempDir, cleanup := setupTestDir(t)
+	defer cleanup()
+
+	src := filepath.Join(tempDir, "tagged.txt")
+	if err := os.WriteFile(src, []byte("content"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if err := unix.Setxattr(src, "user.jacommander.tag", []byte("red"), 0); err != nil {
+		t.Skipf("Filesystem does not support extended attributes: %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(tempDir, "dir"), 0755); err != nil {
+		t.Fatalf("Failed to create test dir: %v", err)
+	}
+	if err := unix.Setxattr(filepath.Join(tempDir, "dir"), "user.jacommander.tag", []byte("blue"), 0); err != nil {
+		t.Fatalf("Failed to set xattr on dir: %v", err)
+	}
+
+	getTag := func(path string) string {
+		buf := make([]byte, 64)
+		n, err := unix.Getxattr(filepath.Join(tempDir, path), "user.jacommander.tag", buf)
+		if err != nil {
+			return ""
+		}
+		return string(buf[:n])
+	}
+
+	ls := NewLocalStorage(tempDir)
+	if err := ls.Copy("/tagged.txt", "/plain.txt", nil); err != nil {
+		t.Fatalf("Copy failed: %v", err)
+	}
+	if tag := getTag("plain.txt"); tag != "" {
+		t.Errorf("Expected no xattr without preserve_xattrs, got %q", tag)
+	}
+
+	ls.SetPreserveXattrs(true)
+	if err := ls.Copy("/tagged.txt", "/copy.txt", nil); err != nil {
+		t.Fatalf("Copy failed: %v", err)
+	}
+	if tag := getTag("copy.txt"); tag != "red" {
+		t.Errorf("Expected xattr %q on the copy, got %q", "red", tag)
+	}
+
+	progress := func(written, total int64) {}
+	if err := ls.Copy("/dir", "/dir-copy", progress); err != nil {
+		t.Fatalf("Copy failed: %v", err)
+	}
+	if tag := getTag("dir-copy"); tag != "blue" {
+		t.Errorf("Expected xattr %q on the copied directory, got %q", "blue", tag)
+	}
+}
@@ -0,0 +1,103 @@
+//go:build !basic
+// +build !basic
+
+package storage
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// checkZeroByteFile writes, lists, stats, reads and copies an empty file
+// in dir and checks it is always treated as a file
+func checkZeroByteFile(t *testing.T, fs FileSystem, dir string) {
+	t.Helper()
+
+	if err := fs.MkDir(dir); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := fs.Write(dir+"/empty.txt", bytes.NewReader(nil)); err != nil {
+		t.Fatalf("Failed to write empty file: %v", err)
+	}
+
+	files, err := fs.List(dir)
+	if err != nil {
+		t.Fatalf("Failed to list: %v", err)
+	}
+	if len(files) != 1 || files[0].Name != "empty.txt" || files[0].IsDir || files[0].Size != 0 {
+		t.Errorf("Expected exactly one empty file in the listing, got %+v", files)
+	}
+
+	info, err := fs.Stat(dir + "/empty.txt")
+	if err != nil {
+		t.Fatalf("Failed to stat empty file: %v", err)
+	}
+	if info.IsDir || info.Size != 0 {
+		t.Errorf("Expected a zero-byte file, got %+v", info)
+	}
+
+	reader, err := fs.Read(dir + "/empty.txt")
+	if err != nil {
+		t.Fatalf("Failed to read empty file: %v", err)
+	}
+	content, err := io.ReadAll(reader)
+	reader.Close()
+	if err != nil || len(content) != 0 {
+		t.Errorf("Expected empty content, got %q (err %v)", content, err)
+	}
+
+	if err := fs.Copy(dir+"/empty.txt", dir+"/copy.txt", nil); err != nil {
+		t.Fatalf("Failed to copy empty file: %v", err)
+	}
+	info, err = fs.Stat(dir + "/copy.txt")
+	if err != nil {
+		t.Fatalf("Failed to stat copy: %v", err)
+	}
+	if info.IsDir || info.Size != 0 {
+		t.Errorf("Expected the copy to be a zero-byte file, got %+v", info)
+	}
+
+	info, err = fs.Stat(dir)
+	if err != nil {
+		t.Fatalf("Failed to stat directory: %v", err)
+	}
+	if !info.IsDir {
+		t.Errorf("Expected %s to be a directory", dir)
+	}
+}
+
+func TestZeroByteFiles(t *testing.T) {
+	t.Run("Local", func(t *testing.T) {
+		checkZeroByteFile(t, NewLocalStorage(t.TempDir()), "/dir")
+	})
+
+	t.Run("S3", func(t *testing.T) {
+		client := &mockS3Client{objects: map[string][]byte{}}
+		fs := &S3FileSystem{S3Storage: newMockS3Storage(client)}
+		checkZeroByteFile(t, fs, "/dir")
+
+		// The directory marker is also a zero-byte object but must never
+		// look like a file
+		if client.contentTypes["dir/"] != s3DirectoryContentType {
+			t.Errorf("Expected the marker to be tagged %s, got %q", s3DirectoryContentType, client.contentTypes["dir/"])
+		}
+		info, err := fs.Stat("/dir/")
+		if err != nil {
+			t.Fatalf("Failed to stat marker path: %v", err)
+		}
+		if !info.IsDir {
+			t.Error("Directory marker was reported as a file")
+		}
+		if _, err := fs.Read("/dir/"); err == nil {
+			t.Error("Expected reading a directory marker to fail")
+		}
+		if err := fs.Write("/dir/", bytes.NewReader(nil)); err == nil {
+			t.Error("Expected writing a file at a directory path to fail")
+		}
+	})
+
+	t.Run("SFTP", func(t *testing.T) {
+		checkZeroByteFile(t, newPipeSFTPStorage(t, 0, 1), "/dir")
+	})
+}
@@ -309,6 +309,11 @@ class JaCommander {
     formatDate(dateString) {
         const date = new Date(dateString);
 
+        // Entries without a known time (e.g. S3 folders) carry Go's zero time
+        if (isNaN(date) || date.getUTCFullYear() <= 1) {
+            return '';
+        }
+
         // Return ISO 8601 format: YYYY-MM-DD HH:MM:SS
         const year = date.getFullYear();
         const month = String(date.getMonth() + 1).padStart(2, '0');
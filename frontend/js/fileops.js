@@ -186,6 +186,8 @@ export class FileOperations {
             document.getElementById('edit-content').dataset.storage = storage;
             document.getElementById('edit-content').dataset.path = path.replace('//', '/');
             document.getElementById('edit-content').dataset.panel = panel;
+            // Sent back with the save so a concurrent edit isn't overwritten
+            document.getElementById('edit-content').dataset.etag = response.headers.get('ETag') || '';
             this.app.showModal('edit-modal');
 
             // Focus on editor
@@ -216,11 +218,21 @@ export class FileOperations {
             const filename = path.substring(path.lastIndexOf('/') + 1);
             formData.append('file', blob, filename);
 
+            const headers = {};
+            if (editor.dataset.etag) {
+                headers['If-Match'] = editor.dataset.etag;
+            }
+
             const response = await fetch('/api/fs/upload', {
                 method: 'POST',
+                headers,
                 body: formData
             });
 
+            if (response.status === 412) {
+                throw new Error('the file was changed by someone else since you opened it. Copy your changes, then reopen the file');
+            }
+
             const data = await response.json();
 
             if (data.success) {
@@ -588,14 +600,21 @@ export class FileOperations {
                     files: selectedFiles,
                     base_path: basePath,
                     output_path: outputPath.replace('//', '/'),
-                    format: format
+                    format: format,
+                    // Never clobber an existing archive from the UI
+                    on_output_exists: 'rename'
                 })
             });
 
             const data = await response.json();
 
             if (data.success) {
-                this.app.showNotification('Compression started', 'success');
+                const savedAs = data.data?.output_path?.split('/').pop();
+                if (savedAs && savedAs !== archiveName) {
+                    this.app.showNotification(`Compression started, saving as ${savedAs}`, 'success');
+                } else {
+                    this.app.showNotification('Compression started', 'success');
+                }
                 this.app.closeModal('compress-modal');
 
                 // Refresh the other panel after a delay
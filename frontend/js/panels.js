@@ -142,7 +142,7 @@ export class PanelManager {
         try {
             // Use cached request via PerformanceOptimizer
             const data = await this.app.performance.executeRequest({
-                url: `/api/fs/list?storage=${panelData.storage}&path=${encodeURIComponent(path)}&calc_sizes=true`,
+                url: `/api/fs/list?storage=${panelData.storage}&path=${encodeURIComponent(path)}&calc_sizes=true&follow_links=true`,
                 options: {
                     method: 'GET',
                     headers: {
@@ -605,9 +605,9 @@ export class PanelManager {
         document.getElementById(`item-count-${panel}`).textContent =
             `${itemCount} ${itemCount === 1 ? 'item' : 'items'}`;
 
-        // Update space info
+        // Update space info; -1 means the storage can't tell
         const spaceInfo = document.getElementById(`space-info-${panel}`);
-        if (data.available && data.total) {
+        if (data.available >= 0 && data.total > 0) {
             const available = this.app.formatFileSize(data.available);
             const total = this.app.formatFileSize(data.total);
             spaceInfo.textContent = `${available} / ${total}`;